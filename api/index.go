@@ -5,60 +5,80 @@ import (
 	"log"
 	"net/http"
 	"sync"
+	"time"
 
 	"trekka-api/internal/config"
+	"trekka-api/internal/handlers"
+	"trekka-api/internal/middleware"
+	"trekka-api/internal/models"
 	"trekka-api/internal/server"
 )
 
+// Minimum time between initialization attempts after a failure, so a broken deployment
+// doesn't retry (and log) on every request.
+const initRetryInterval = 10 * time.Second
+
 var (
-	handler http.Handler
-	initErr error
-	once    sync.Once
+	handler     http.Handler
+	initErr     error
+	lastAttempt time.Time
+	mu          sync.Mutex
 )
 
-// Initializes the HTTP handler once and reuses it across invocations.
-// Uses sync.Once to ensure thread-safe single initialization without data races.
+// Initializes the HTTP handler and reuses it across invocations. A failed initialization
+// (e.g. Firestore credentials not yet available) is retried on a later request, at most
+// once per initRetryInterval; until then the last error is returned.
 func initHandler() error {
-	once.Do(func() {
-		ctx := context.Background()
-
-		// Load and validate configuration
-		cfg, err := config.Load()
-		if err != nil {
-			log.Printf("Failed to load configuration: %v", err)
-			initErr = err
-			return
-		}
+	mu.Lock()
+	defer mu.Unlock()
 
-		// Initialize all services
-		svcs, err := server.InitServices(ctx, cfg)
-		if err != nil {
-			log.Printf("Failed to initialize services: %v", err)
-			initErr = err
-			return
-		}
+	if handler != nil {
+		return nil
+	}
+	if initErr != nil && time.Since(lastAttempt) < initRetryInterval {
+		return initErr
+	}
+	lastAttempt = time.Now()
 
-		// Create HTTP handler
-		wrappedHandler := server.CreateHandler(svcs.Image, cfg.AllowedOrigins, cfg.APIKeys)
-
-		// Start Google Drive background sync if enabled
-		// Note: In serverless environments, this goroutine persists across requests
-		// within the same container instance
-		if svcs.Drive != nil {
-			server.StartDriveSync(
-				context.Background(),
-				svcs.Drive,
-				cfg.DriveSyncInterval,
-				cfg.DriveBackfillOnStartup,
-			)
-		}
+	ctx := context.Background()
+
+	// Load and validate configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Printf("Failed to load configuration: %v", err)
+		initErr = err
+		return err
+	}
+
+	// Initialize all services
+	svcs, err := server.InitServices(ctx, cfg)
+	if err != nil {
+		log.Printf("Failed to initialize services: %v", err)
+		initErr = err
+		return err
+	}
 
-		// Only set handler after full successful initialization
-		handler = wrappedHandler
-		log.Println("Handler initialized successfully")
-	})
+	// Create HTTP handler
+	wrappedHandler := server.CreateHandler(svcs, cfg)
+
+	// Start Google Drive background sync if enabled
+	// Note: In serverless environments, this goroutine persists across requests
+	// within the same container instance
+	if svcs.Drive != nil {
+		server.StartDriveSync(
+			context.Background(),
+			svcs.Drive,
+			cfg.DriveSyncInterval,
+			cfg.DriveBackfillOnStartup,
+		)
+	}
 
-	return initErr
+	// Only set handler after full successful initialization
+	handler = wrappedHandler
+	initErr = nil
+	log.Println("Handler initialized successfully")
+
+	return nil
 }
 
 // Handler is the Vercel serverless function entry point
@@ -66,7 +86,14 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	// Attempt initialization (will succeed immediately if already initialized)
 	if err := initHandler(); err != nil {
 		log.Printf("Handler initialization failed: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+
+		// The process is alive even though it can't serve yet, so liveness still passes
+		if r.URL.Path == "/healthz" {
+			handlers.HandleLiveness(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", "10")
+		middleware.WriteError(w, r, http.StatusServiceUnavailable, models.ErrorCodeUnavailable, "Service not ready")
 		return
 	}
 
@@ -0,0 +1,403 @@
+// Package client is a typed Go client for the Trekka API.
+//
+// Response types are aliases of the ones the handlers encode, so the client and server
+// can't drift apart. Requests carry the API key, retry on 429 using the Retry-After header,
+// and propagate a request ID set with WithRequestID.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"trekka-api/internal/models"
+)
+
+// Response types shared with the server.
+type (
+	ImageMetadata = models.ImageMetadata
+	Coordinates   = models.Coordinates
+	Place         = models.Place
+	NearbyImage   = models.NearbyImage
+	MapPoint      = models.MapPoint
+	ImageStats    = models.ImageStats
+	MissingStats  = models.MissingStats
+
+	BackfillRequest = models.BackfillRequest
+	BackfillStarted = models.BackfillStarted
+)
+
+// Client calls the Trekka API. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+	maxRetries int
+	userAgent  string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// Uses a custom HTTP client (timeouts, transport). Redirects are never followed for /image.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		if hc != nil {
+			c.httpClient = hc
+		}
+	}
+}
+
+// Sets how many times a rate-limited (429) request is retried. Defaults to 3.
+func WithMaxRetries(n int) Option {
+	return func(c *Client) {
+		if n >= 0 {
+			c.maxRetries = n
+		}
+	}
+}
+
+// Sets the User-Agent header sent with each request.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) {
+		c.userAgent = ua
+	}
+}
+
+// Creates a client for the API at baseURL (e.g. "https://api.example.com").
+func NewClient(baseURL, apiKey string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		maxRetries: 3,
+		userAgent:  "trekka-api-client",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type requestIDKey struct{}
+
+// Returns a context whose requests carry the given X-Request-ID, so the ID shows up in
+// the server's logs and responses.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// APIError is returned for non-2xx responses.
+type APIError struct {
+	StatusCode int
+	Code       string // Machine-readable code from the error envelope (e.g. "not_found"); empty for non-JSON bodies
+	Message    string
+	RequestID  string // X-Request-ID of the failed response
+}
+
+func (e *APIError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("trekka api: %d %s (request %s)", e.StatusCode, e.Message, e.RequestID)
+	}
+	return fmt.Sprintf("trekka api: %d %s", e.StatusCode, e.Message)
+}
+
+// ListImagesOptions filters and paginates ListImages.
+type ListImagesOptions struct {
+	Limit    int  // 0 uses the server default (1000)
+	Page     int  // 0-indexed
+	Favorite bool // Only favorites
+}
+
+// Checks that the API is up. Does not require a valid API key.
+func (c *Client) Health(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, "/health", nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Lists image metadata, newest first.
+func (c *Client) ListImages(ctx context.Context, opts ListImagesOptions) ([]*ImageMetadata, error) {
+	q := url.Values{}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Page > 0 {
+		q.Set("page", strconv.Itoa(opts.Page))
+	}
+	if opts.Favorite {
+		q.Set("favorite", "true")
+	}
+
+	var images []*ImageMetadata
+	if err := c.getJSON(ctx, "/images/list", q, &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// Returns the short-lived signed URL /image would redirect to, without downloading the file.
+func (c *Client) GetImageURL(ctx context.Context, fileName string) (string, error) {
+	resp, err := c.do(ctx, http.MethodHead, "/image", url.Values{"fileName": {fileName}}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", &APIError{StatusCode: resp.StatusCode, Message: "response had no Location header", RequestID: resp.Header.Get("X-Request-ID")}
+	}
+	return location, nil
+}
+
+// Downloads a resized rendition of an image. Returns the bytes and their content type.
+func (c *Client) GetThumbnail(ctx context.Context, fileName string, width int) ([]byte, string, error) {
+	q := url.Values{"fileName": {fileName}}
+	if width > 0 {
+		q.Set("w", strconv.Itoa(width))
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, "/image/thumbnail", q, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("read thumbnail: %w", err)
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// Lists places (images grouped by snapped coordinates), most photos first.
+func (c *Client) ListPlaces(ctx context.Context) ([]*Place, error) {
+	var places []*Place
+	if err := c.getJSON(ctx, "/images/places", nil, &places); err != nil {
+		return nil, err
+	}
+	return places, nil
+}
+
+// Lists images within radiusKm of lat/lng, nearest first. limit 0 uses the server default.
+func (c *Client) NearbyImages(ctx context.Context, lat, lng, radiusKm float64, limit int) ([]*NearbyImage, error) {
+	q := url.Values{
+		"lat":    {strconv.FormatFloat(lat, 'f', -1, 64)},
+		"lng":    {strconv.FormatFloat(lng, 'f', -1, 64)},
+		"radius": {strconv.FormatFloat(radiusKm, 'f', -1, 64)},
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+
+	var images []*NearbyImage
+	if err := c.getJSON(ctx, "/images/near", q, &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// Lists every geotagged image as a compact map point, using the binary encoding.
+func (c *Client) MapPoints(ctx context.Context) ([]MapPoint, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/images/points", nil, http.Header{"Accept": {models.PointsBinaryContentType}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read points: %w", err)
+	}
+	return models.DecodePointsBinary(data)
+}
+
+// Retrieves collection statistics; refresh forces the server to recompute them.
+func (c *Client) Stats(ctx context.Context, refresh bool) (*ImageStats, error) {
+	var q url.Values
+	if refresh {
+		q = url.Values{"refresh": {"true"}}
+	}
+
+	var stats ImageStats
+	if err := c.getJSON(ctx, "/images/stats", q, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Retrieves one image's metadata by document ID.
+func (c *Client) GetMetadata(ctx context.Context, id string) (*ImageMetadata, error) {
+	var metadata ImageMetadata
+	if err := c.getJSON(ctx, "/images/"+url.PathEscape(id), nil, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// Uploads an image or video under fileName and returns the metadata the server extracted. An
+// empty contentType lets the server infer it. A name or content already stored gets a 409 APIError.
+func (c *Client) Upload(ctx context.Context, fileName, contentType string, r io.Reader) (*ImageMetadata, error) {
+	// Read up front so a rate-limited upload can be resent
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read upload: %w", err)
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "/images", url.Values{"fileName": {fileName}}, http.Header{"Content-Type": {contentType}}, data)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var metadata ImageMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("decode /images response: %w", err)
+	}
+	return &metadata, nil
+}
+
+// Deletes an image's metadata and stored file.
+func (c *Client) Delete(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/images/"+url.PathEscape(id), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Starts a Drive backfill in the background. Only one runs at a time; starting another while one
+// is going gets a 409 APIError, and a server without Drive sync returns 503.
+func (c *Client) TriggerSync(ctx context.Context, req BackfillRequest) (*BackfillStarted, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("encode backfill request: %w", err)
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "/admin/backfill", nil, http.Header{"Content-Type": {"application/json"}}, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var started BackfillStarted
+	if err := json.NewDecoder(resp.Body).Decode(&started); err != nil {
+		return nil, fmt.Errorf("decode /admin/backfill response: %w", err)
+	}
+	return &started, nil
+}
+
+// Performs a GET and decodes the JSON response into out.
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, out interface{}) error {
+	resp, err := c.do(ctx, http.MethodGet, path, query, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode %s response: %w", path, err)
+	}
+	return nil
+}
+
+// Sends a request with any extra headers and body, retrying 429s after the server's Retry-After
+// delay. Returns an *APIError for any status of 400 or above; redirects are returned as-is.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	// Copy the client so redirects can be surfaced without mutating the caller's http.Client
+	hc := *c.httpClient
+	hc.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	for attempt := 0; ; attempt++ {
+		// A fresh reader per attempt, so a retried request sends the whole body again
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+		if err != nil {
+			return nil, fmt.Errorf("build request: %w", err)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("X-API-Key", c.apiKey)
+		req.Header.Set("User-Agent", c.userAgent)
+		if id, ok := ctx.Value(requestIDKey{}).(string); ok && id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+
+		resp, err := hc.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < c.maxRetries {
+			delay := retryAfter(resp.Header.Get("Retry-After"), attempt)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+
+			select {
+			case <-time.After(delay):
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			apiErr := &APIError{
+				StatusCode: resp.StatusCode,
+				Message:    strings.TrimSpace(string(body)),
+				RequestID:  resp.Header.Get("X-Request-ID"),
+			}
+			// Errors from the API are a JSON envelope; anything else (e.g. a proxy's page) is kept as text
+			var envelope models.ErrorResponse
+			if json.Unmarshal(body, &envelope) == nil && envelope.Error.Code != "" {
+				apiErr.Code = envelope.Error.Code
+				apiErr.Message = envelope.Error.Message
+			}
+			return nil, apiErr
+		}
+
+		return resp, nil
+	}
+}
+
+// Parses a Retry-After header (seconds or HTTP date), falling back to exponential backoff.
+func retryAfter(header string, attempt int) time.Duration {
+	if secs, err := strconv.Atoi(strings.TrimSpace(header)); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return time.Duration(1<<uint(attempt)) * time.Second
+}
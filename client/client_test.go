@@ -0,0 +1,213 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"trekka-api/internal/handlers"
+	"trekka-api/internal/middleware"
+	"trekka-api/internal/models"
+	"trekka-api/internal/router"
+	"trekka-api/internal/services"
+	"trekka-api/internal/testutil"
+)
+
+const testAPIKey = "test-key"
+
+// A server running the real router and middleware over the Firestore emulator, a fake GCS and
+// a fake Drive, so the client is checked against what the handlers actually encode.
+type contractServer struct {
+	client *Client
+	gcs    *testutil.FakeGCS
+	drive  *services.DriveService
+}
+
+// Starts the server and returns it with a client for it. Without Drive, the server runs without
+// Drive sync. Skips the test unless FIRESTORE_EMULATOR_HOST is set (see make test-emulator).
+func newContractServer(t *testing.T, withDrive bool) *contractServer {
+	t.Helper()
+	gcs := testutil.NewFakeGCS(t)
+
+	cache := services.NewCacheService(time.Minute, time.Minute)
+	t.Cleanup(cache.Stop)
+	fs := services.NewFirestoreService(testutil.FirestoreClient(t), "images")
+	storage := services.NewStorageService(gcs.Client, testutil.FakeBucket)
+	images := services.NewImageService(storage, cache, fs)
+	images.SetGeocoder(noGeocoder{})
+
+	var drive *services.DriveService
+	if withDrive {
+		fake := testutil.NewFakeDrive(t)
+		fake.Add(testutil.DriveFile{ID: "file-1", Name: "IMG_1.jpg", MimeType: "image/jpeg", Parent: "folder", Data: testJPEG(t)})
+		drive = services.NewDriveService(services.NewDriveClient(fake.Service, services.DriveClientOptions{}), storage, fs, noGeocoder{}, "folder")
+	}
+
+	h := handlers.New(images, nil, nil, cache, nil, nil, nil, nil, nil, nil, drive)
+	server := httptest.NewServer(middleware.RequestID(middleware.APIKeyAuth([]string{testAPIKey}, false)(router.Setup(h))))
+	t.Cleanup(server.Close)
+
+	return &contractServer{
+		client: NewClient(server.URL, testAPIKey, WithHTTPClient(server.Client())),
+		gcs:    gcs,
+		drive:  drive,
+	}
+}
+
+// Finds no place for any coordinates; the test images carry none anyway.
+type noGeocoder struct{}
+
+func (noGeocoder) ReverseGeocode(context.Context, models.Coordinates) (models.LocationParts, error) {
+	return models.LocationParts{}, nil
+}
+
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 48)), nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// Returns err as an *APIError, failing the test if it isn't one with the given status.
+func requireAPIError(t *testing.T, err error, status int) *APIError {
+	t.Helper()
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want an *APIError", err)
+	}
+	if apiErr.StatusCode != status {
+		t.Fatalf("status = %d, want %d (%v)", apiErr.StatusCode, status, apiErr)
+	}
+	return apiErr
+}
+
+func TestUploadRoundTrip(t *testing.T) {
+	s := newContractServer(t, false)
+	ctx := context.Background()
+	photo := testJPEG(t)
+
+	uploaded, err := s.client.Upload(ctx, "IMG_1.jpg", "image/jpeg", bytes.NewReader(photo))
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if uploaded.Id == "" || uploaded.FileName != "IMG_1.jpg" || uploaded.ContentType != "image/jpeg" {
+		t.Fatalf("Upload returned %+v, want IMG_1.jpg as image/jpeg with an ID", uploaded)
+	}
+	if stored, ok := s.gcs.Get(uploaded.StoragePath); !ok || !bytes.Equal(stored, photo) {
+		t.Errorf("stored object %q = %d bytes (found %t), want the uploaded %d", uploaded.StoragePath, len(stored), ok, len(photo))
+	}
+
+	got, err := s.client.GetMetadata(ctx, uploaded.Id)
+	if err != nil {
+		t.Fatalf("GetMetadata: %v", err)
+	}
+	if got.Id != uploaded.Id || got.FileName != uploaded.FileName || got.StoragePath != uploaded.StoragePath {
+		t.Errorf("GetMetadata = %+v, want the uploaded %+v", got, uploaded)
+	}
+
+	listed, err := s.client.ListImages(ctx, ListImagesOptions{})
+	if err != nil {
+		t.Fatalf("ListImages: %v", err)
+	}
+	if len(listed) != 1 || listed[0].Id != uploaded.Id {
+		t.Errorf("ListImages = %d images, want just the upload", len(listed))
+	}
+
+	t.Run("same name", func(t *testing.T) {
+		_, err := s.client.Upload(ctx, "IMG_1.jpg", "image/jpeg", bytes.NewReader(testJPEG(t)))
+		requireAPIError(t, err, http.StatusConflict)
+	})
+
+	t.Run("same content", func(t *testing.T) {
+		_, err := s.client.Upload(ctx, "copy.jpg", "", bytes.NewReader(photo))
+		requireAPIError(t, err, http.StatusConflict)
+	})
+
+	t.Run("not an image", func(t *testing.T) {
+		_, err := s.client.Upload(ctx, "notes.txt", "text/plain", bytes.NewReader([]byte("hello")))
+		requireAPIError(t, err, http.StatusUnsupportedMediaType)
+	})
+}
+
+func TestDeleteRoundTrip(t *testing.T) {
+	s := newContractServer(t, false)
+	ctx := context.Background()
+
+	uploaded, err := s.client.Upload(ctx, "IMG_1.jpg", "image/jpeg", bytes.NewReader(testJPEG(t)))
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	if err := s.client.Delete(ctx, uploaded.Id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok := s.gcs.Get(uploaded.StoragePath); ok {
+		t.Errorf("object %q still stored after Delete", uploaded.StoragePath)
+	}
+
+	_, err = s.client.GetMetadata(WithRequestID(ctx, "req-123"), uploaded.Id)
+	apiErr := requireAPIError(t, err, http.StatusNotFound)
+	if apiErr.Code != models.ErrorCodeNotFound {
+		t.Errorf("Code = %q, want %q", apiErr.Code, models.ErrorCodeNotFound)
+	}
+	if apiErr.RequestID != "req-123" {
+		t.Errorf("RequestID = %q, want the one sent", apiErr.RequestID)
+	}
+
+	err = s.client.Delete(ctx, uploaded.Id)
+	requireAPIError(t, err, http.StatusNotFound)
+}
+
+func TestTriggerSyncRoundTrip(t *testing.T) {
+	s := newContractServer(t, true)
+	ctx := context.Background()
+
+	started, err := s.client.TriggerSync(ctx, BackfillRequest{DryRun: true})
+	if err != nil {
+		t.Fatalf("TriggerSync: %v", err)
+	}
+	if !started.Started || !started.DryRun || !started.SkipExisting {
+		t.Errorf("TriggerSync = %+v, want a started dry run skipping existing files", started)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		report, running := s.drive.LastBackfill()
+		if !running && report != nil {
+			if report.Listed != 1 || !report.DryRun {
+				t.Errorf("report = %+v, want a dry run listing the one file", report)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("backfill did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Run("without Drive", func(t *testing.T) {
+		s := newContractServer(t, false)
+		_, err := s.client.TriggerSync(ctx, BackfillRequest{})
+		requireAPIError(t, err, http.StatusServiceUnavailable)
+	})
+}
+
+func TestWrongAPIKey(t *testing.T) {
+	s := newContractServer(t, false)
+	client := NewClient(s.client.baseURL, "wrong-key", WithHTTPClient(s.client.httpClient))
+
+	_, err := client.GetMetadata(context.Background(), "img-1")
+	requireAPIError(t, err, http.StatusUnauthorized)
+
+	if err := client.Health(context.Background()); err != nil {
+		t.Errorf("Health: %v, want it exempt from the API key", err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/option"
+
+	"trekka-api/internal/config"
+	"trekka-api/internal/services"
+)
+
+// Moves randomly-keyed image documents to their deterministic IDs (Drive file ID, else the hash of
+// the stored bytes, else of the normalized file name) in batched transactions, folding duplicates of the same media into one
+// document and rewriting album and trip references. The old IDs are kept in legacyIds so
+// existing links keep resolving during the transition.
+func main() {
+	logger := log.New(os.Stdout, "[MigrateIDs] ", log.LstdFlags)
+
+	dryRun := flag.Bool("dry-run", false, "Preview ID changes without writing to Firestore")
+	batchSize := flag.Int("batch", services.MaxMigrationBatch, "Documents moved per transaction (at most 200)")
+	flag.Parse()
+
+	if *dryRun {
+		logger.Println("DRY RUN - no Firestore writes")
+	}
+
+	cfg, cfgErr := config.Load()
+	if cfgErr != nil {
+		logger.Fatalf("load config: %v", cfgErr)
+	}
+
+	ctx := context.Background()
+
+	// Configure GCP credentials
+	var opts []option.ClientOption
+	if cfg.FirebaseCredentialsJSON != "" {
+		opts = append(opts, option.WithCredentialsJSON([]byte(cfg.FirebaseCredentialsJSON)))
+	} else {
+		opts = append(opts, option.WithCredentialsFile(cfg.FirebaseCredentialsPath))
+	}
+
+	firestoreClient, err := firestore.NewClient(ctx, cfg.FirebaseProjectID, opts...)
+	if err != nil {
+		logger.Fatalf("firestore client: %v", err)
+	}
+	defer firestoreClient.Close()
+
+	// Services
+	firestoreService := services.NewFirestoreService(firestoreClient, cfg.FirestoreCollection)
+
+	allImages, err := firestoreService.ListAllImageMetadata(ctx, 0, 0)
+	if err != nil {
+		logger.Fatalf("list images: %v", err)
+	}
+
+	var moves []services.IDMove
+	targets := make(map[string]int) // Deterministic ID -> documents moving there
+	existing := make(map[string]bool, len(allImages))
+	for _, image := range allImages {
+		existing[image.Id] = true
+	}
+
+	skipped := 0
+	for _, image := range allImages {
+		newID := services.DeterministicDocumentID(image.DriveFileID, image.ContentHash, image.FileName)
+		if newID == "" || newID == image.Id {
+			skipped++
+			continue
+		}
+
+		moves = append(moves, services.IDMove{OldID: image.Id, NewID: newID})
+		targets[newID]++
+	}
+
+	duplicates := 0
+	for newID, count := range targets {
+		if existing[newID] {
+			duplicates += count
+		} else {
+			duplicates += count - 1
+		}
+	}
+
+	if *dryRun {
+		for _, move := range moves {
+			note := ""
+			if existing[move.NewID] || targets[move.NewID] > 1 {
+				note = " (duplicate, merged)"
+			}
+			logger.Printf("🔍 [DRY] Would move %s -> %s%s", move.OldID, move.NewID, note)
+		}
+		logger.Printf("Done: would move=%d (duplicates merged=%d) skipped=%d", len(moves), duplicates, skipped)
+		return
+	}
+
+	result, err := firestoreService.MigrateDocumentIDs(ctx, moves, *batchSize)
+	logger.Printf("Done: moved=%d merged=%d albums=%d trips=%d skipped=%d",
+		result.Moved, result.Merged, result.Albums, result.Trips, skipped)
+	if err != nil {
+		// Batches before the failed one are done; a rerun picks up the rest
+		logger.Fatalf("❌ %v", err)
+	}
+}
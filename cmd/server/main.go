@@ -47,7 +47,7 @@ func main() {
 	}
 
 	// Create HTTP handler
-	handler := server.CreateHandler(svcs.Image, cfg.AllowedOrigins, cfg.APIKeys)
+	handler := server.CreateHandler(svcs, cfg)
 
 	// Start Google Drive background sync if enabled
 	var driveCancelFunc context.CancelFunc
@@ -60,6 +60,12 @@ func main() {
 		)
 	}
 
+	// Start evicting cached entries as documents change, if enabled
+	var watcherCancelFunc context.CancelFunc
+	if svcs.Watcher != nil {
+		watcherCancelFunc = server.StartMetadataWatcher(context.Background(), svcs.Watcher)
+	}
+
 	// Create server
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%s", cfg.Port),
@@ -90,6 +96,10 @@ func main() {
 		driveCancelFunc()
 	}
 
+	if watcherCancelFunc != nil {
+		watcherCancelFunc()
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
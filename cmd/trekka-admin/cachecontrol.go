@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+
+	"trekka-api/internal/config"
+	"trekka-api/internal/services"
+)
+
+// Runs `cache-control [--dry-run] [--prefix P]`, which sets the Cache-Control uploads get now on
+// objects stored before it (or with a different one), and returns the exit code (1 if any update
+// failed). Only object metadata is updated; no data is rewritten.
+func cacheControl(args []string) int {
+	fs := flag.NewFlagSet("cache-control", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Print the objects that would be updated without changing them")
+	prefix := fs.String("prefix", "", "Only update objects whose names start with this prefix")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	var opts []option.ClientOption
+	if cfg.FirebaseCredentialsJSON != "" {
+		opts = append(opts, option.WithCredentialsJSON([]byte(cfg.FirebaseCredentialsJSON)))
+	} else {
+		opts = append(opts, option.WithCredentialsFile(cfg.FirebaseCredentialsPath))
+	}
+	storageClient, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "storage client: %v\n", err)
+		return 1
+	}
+	defer storageClient.Close()
+
+	storageService := services.NewStorageService(storageClient, cfg.FirebaseBucketName)
+	objects, err := storageService.ListObjects(ctx, *prefix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "list: %v\n", err)
+		return 1
+	}
+
+	updated, failed := 0, 0
+	for _, obj := range objects {
+		want := services.CacheControlFor(obj.Name)
+		if strings.HasSuffix(obj.Name, "/") || obj.CacheControl == want {
+			continue
+		}
+		if *dryRun {
+			fmt.Printf("would set %s to %q (was %q)\n", obj.Name, want, obj.CacheControl)
+			updated++
+			continue
+		}
+		if err := storageService.SetCacheControl(ctx, obj.Name, want); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", obj.Name, err)
+			failed++
+			continue
+		}
+		updated++
+	}
+
+	verb := "Updated"
+	if *dryRun {
+		verb = "Would update"
+	}
+	fmt.Printf("%s Cache-Control on %d of %d objects (%d failed)\n", verb, updated, len(objects), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/option"
+
+	"trekka-api/internal/config"
+	"trekka-api/internal/services"
+	"trekka-api/internal/utils"
+)
+
+// Runs `expected-files import <file.csv>` or `expected-files report` and returns the exit code.
+// The report exits 1 when any expected file is still missing, so it can gate scripts.
+func expectedFiles(args []string) int {
+	if len(args) == 0 || (args[0] == "import" && len(args) != 2) {
+		fmt.Fprintln(os.Stderr, "Usage: trekka-admin expected-files import <file.csv> | report")
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	client, err := openFirestore(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "firestore client: %v\n", err)
+		return 1
+	}
+	defer client.Close()
+
+	expectations := services.NewExpectationService(services.NewFirestoreService(client, cfg.FirestoreCollection))
+
+	switch args[0] {
+	case "import":
+		file, err := os.Open(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "open: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+
+		fileNames, err := utils.ParseFileNamesCSV(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", args[1], err)
+			return 1
+		}
+
+		added, duplicates, err := expectations.AddExpected(ctx, fileNames)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "register: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Registered %d expected files (%d already registered)\n", added, duplicates)
+		return 0
+
+	case "report":
+		report, err := expectations.Report(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "report: %v\n", err)
+			return 1
+		}
+
+		fmt.Printf("Expected %d: %d satisfied, %d satisfied late, %d missing\n\n",
+			report.Expected, report.Satisfied, len(report.SatisfiedLate), len(report.Missing))
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "STATUS\tFILE\tREGISTERED\tINGESTED")
+		for _, f := range report.Missing {
+			fmt.Fprintf(tw, "missing\t%s\t%s\t-\n", f.FileName, f.RegisteredAt.Format("2006-01-02 15:04"))
+		}
+		for _, f := range report.SatisfiedLate {
+			fmt.Fprintf(tw, "late\t%s\t%s\t%s\n", f.FileName, f.RegisteredAt.Format("2006-01-02 15:04"), f.IngestedAt.Format("2006-01-02 15:04"))
+		}
+		tw.Flush()
+
+		if len(report.Missing) > 0 {
+			return 1
+		}
+		return 0
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown expected-files command %q\n", args[0])
+		return 2
+	}
+}
+
+// Opens a Firestore client with the configured credentials.
+func openFirestore(ctx context.Context, cfg *config.Config) (*firestore.Client, error) {
+	var opts []option.ClientOption
+	if cfg.FirebaseCredentialsJSON != "" {
+		opts = append(opts, option.WithCredentialsJSON([]byte(cfg.FirebaseCredentialsJSON)))
+	} else {
+		opts = append(opts, option.WithCredentialsFile(cfg.FirebaseCredentialsPath))
+	}
+	return firestore.NewClient(ctx, cfg.FirebaseProjectID, opts...)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"trekka-api/internal/config"
+	"trekka-api/internal/services"
+	"trekka-api/internal/utils"
+)
+
+// Runs `geotag --gpx track.gpx [--offset 2m] [--max-gap 5m] [--dry-run]` and returns the exit code.
+func geotag(args []string) int {
+	fs := flag.NewFlagSet("geotag", flag.ContinueOnError)
+	gpxPath := fs.String("gpx", "", "GPX track to geotag from (required)")
+	offset := fs.Duration("offset", 0, "Camera clock correction added to each photo's takenAt (negative if the camera runs fast)")
+	maxGap := fs.Duration("max-gap", 5*time.Minute, "Longest gap between trackpoints to interpolate across (0 for no limit)")
+	dryRun := fs.Bool("dry-run", false, "Print proposed matches without writing them")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *gpxPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: trekka-admin geotag --gpx <track.gpx> [--offset 2m] [--max-gap 5m] [--dry-run]")
+		return 2
+	}
+
+	file, err := os.Open(*gpxPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open: %v\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	track, err := utils.ParseGPX(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *gpxPath, err)
+		return 1
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	client, err := openFirestore(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "firestore client: %v\n", err)
+		return 1
+	}
+	defer client.Close()
+
+	firestoreService := services.NewFirestoreService(client, cfg.FirestoreCollection)
+	geocoder := services.NewGeocodingServiceWithCache(firestoreService)
+	geocoder.SetPlaceGridMeters(cfg.PlaceGridMeters)
+	geocoder.SetCacheMaxAge(cfg.GeocodeCacheMaxAge)
+	geocodeProvider, err := services.GeocoderFor(cfg.Geocoders, cfg.GoogleMapsAPIKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "geocoders: %v\n", err)
+		return 1
+	}
+	geocoder.SetProvider(geocodeProvider)
+	geotagger := services.NewGeotagService(firestoreService, geocoder)
+
+	report, err := geotagger.GeotagFromTrack(ctx, track, services.GeotagOptions{
+		Offset: *offset,
+		MaxGap: *maxGap,
+		DryRun: *dryRun,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "geotag: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Track %s - %s (%d points): %d photos without GPS, %d matched, %d in gaps, %d failed\n\n",
+		report.TrackStart.Format(time.RFC3339), report.TrackEnd.Format(time.RFC3339), report.TrackPoints,
+		report.Candidates, len(report.Matched), len(report.Unmatched), report.Failed)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tTAKEN\tLAT\tLNG\tLOCATION")
+	for _, m := range report.Matched {
+		location := m.GeoLocation
+		if location == "" {
+			location = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", m.FileName, m.TakenAt.Format("2006-01-02 15:04:05"), m.Coordinates.Lat, m.Coordinates.Lng, location)
+	}
+	for _, name := range report.Unmatched {
+		fmt.Fprintf(tw, "%s\t-\tgap\t-\t-\n", name)
+	}
+	tw.Flush()
+
+	if *dryRun {
+		fmt.Println("\nDry run: nothing was written")
+	}
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
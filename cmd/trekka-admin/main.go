@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+
+	"trekka-api/internal/config"
+	"trekka-api/internal/models"
+	"trekka-api/internal/services"
+)
+
+// Per-probe deadline so a hung integration can't stall the whole run.
+const probeTimeout = 15 * time.Second
+
+// A single end-to-end check with a hint for fixing it when it fails.
+type probe struct {
+	name string
+	hint string
+	run  func(ctx context.Context) (string, error)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "cache-control":
+		os.Exit(cacheControl(os.Args[2:]))
+	case "doctor":
+		os.Exit(doctor())
+	case "expected-files":
+		os.Exit(expectedFiles(os.Args[2:]))
+	case "geotag":
+		os.Exit(geotag(os.Args[2:]))
+	case "quarantine":
+		os.Exit(quarantine(os.Args[2:]))
+	case "reconcile":
+		os.Exit(reconcile(os.Args[2:]))
+	case "relocate":
+		os.Exit(relocate(os.Args[2:]))
+	case "trips":
+		os.Exit(trips(os.Args[2:]))
+	case "undated":
+		os.Exit(undated(os.Args[2:]))
+	case "help", "-h", "--help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: trekka-admin <command>")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  cache-control [--dry-run]          Set the current Cache-Control on stored objects (--prefix P to limit)")
+	fmt.Fprintln(os.Stderr, "  doctor                             Check every integration end to end and print a pass/fail table")
+	fmt.Fprintln(os.Stderr, "  expected-files import <file.csv>   Register filenames expected to arrive through sync")
+	fmt.Fprintln(os.Stderr, "  expected-files report              List expected files never ingested (exit 1 if any)")
+	fmt.Fprintln(os.Stderr, "  geotag --gpx <track.gpx>           Set coordinates of photos without GPS from a GPX track (--dry-run to preview)")
+	fmt.Fprintln(os.Stderr, "  quarantine list                    List files set aside after repeatedly failing to process")
+	fmt.Fprintln(os.Stderr, "  quarantine restore <fileName>      Move a quarantined file back so the next sync retries it")
+	fmt.Fprintln(os.Stderr, "  reconcile [--fix]                  Report objects without documents and documents without objects (exit 1 if any)")
+	fmt.Fprintln(os.Stderr, "  relocate [--dry-run] [--limit N]   Move stored files to the keys STORAGE_LAYOUT gives them (--batch N per page)")
+	fmt.Fprintln(os.Stderr, "  trips list                         List trips, most recent first")
+	fmt.Fprintln(os.Stderr, "  trips recompute                    Regroup photos into trips, keeping manual trip names")
+	fmt.Fprintln(os.Stderr, "  undated list                       List documents without takenAt, which /images/list can't show (exit 1 if any)")
+	fmt.Fprintln(os.Stderr, "  undated repair [--dry-run]         Copy createdAt into takenAt on those documents")
+}
+
+// Runs every probe, prints the results, and returns the process exit code (1 if anything failed).
+func doctor() int {
+	cfg, err := config.Load()
+	if err != nil {
+		printResults([]result{{name: "config", err: err, hint: "Check .env / environment variables against .env.example"}})
+		return 1
+	}
+
+	ctx := context.Background()
+
+	var opts []option.ClientOption
+	if cfg.FirebaseCredentialsJSON != "" {
+		opts = append(opts, option.WithCredentialsJSON([]byte(cfg.FirebaseCredentialsJSON)))
+	} else {
+		opts = append(opts, option.WithCredentialsFile(cfg.FirebaseCredentialsPath))
+	}
+
+	storageClient, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		printResults([]result{{name: "storage client", err: err, hint: "Check FIREBASE_CREDENTIALS_JSON / FIREBASE_CREDENTIALS_PATH"}})
+		return 1
+	}
+	defer storageClient.Close()
+
+	firestoreClient, err := firestore.NewClient(ctx, cfg.FirebaseProjectID, opts...)
+	if err != nil {
+		printResults([]result{{name: "firestore client", err: err, hint: "Check FIREBASE_PROJECT_ID and credentials"}})
+		return 1
+	}
+	defer firestoreClient.Close()
+
+	storageService := services.NewStorageService(storageClient, cfg.FirebaseBucketName)
+	probeName := fmt.Sprintf("_doctor/probe-%d.txt", time.Now().UnixNano())
+
+	probes := []probe{
+		{
+			name: "storage write/delete",
+			hint: fmt.Sprintf("Check FIREBASE_BUCKET_NAME (%q) exists and the service account has Storage Object Admin", cfg.FirebaseBucketName),
+			run: func(ctx context.Context) (string, error) {
+				if err := storageService.UploadFile(ctx, probeName, []byte("trekka doctor probe"), "text/plain", services.UploadOptions{}); err != nil {
+					return "", err
+				}
+				if err := storageService.DeleteFile(ctx, probeName); err != nil {
+					return "", fmt.Errorf("probe object %s was written but not deleted: %w", probeName, err)
+				}
+				return "gs://" + cfg.FirebaseBucketName, nil
+			},
+		},
+		{
+			name: "signed URL",
+			hint: "Signing needs a service account key (FIREBASE_CREDENTIALS_JSON / _PATH), not user credentials",
+			run: func(ctx context.Context) (string, error) {
+				if _, _, err := storageService.GenerateSignedURL(ctx, probeName); err != nil {
+					return "", err
+				}
+				return "V4 signing ok", nil
+			},
+		},
+		{
+			name: "firestore write/delete",
+			hint: fmt.Sprintf("Check FIRESTORE_COLLECTION (%q), that Firestore is enabled in native mode, and the service account has Cloud Datastore User", cfg.FirestoreCollection),
+			run: func(ctx context.Context) (string, error) {
+				ref := firestoreClient.Collection(cfg.FirestoreCollection).Doc(fmt.Sprintf("_doctor-probe-%d", time.Now().UnixNano()))
+				if _, err := ref.Create(ctx, map[string]interface{}{"probe": true, "createdAt": time.Now()}); err != nil {
+					return "", err
+				}
+				if _, err := ref.Delete(ctx); err != nil {
+					return "", fmt.Errorf("probe document %s was written but not deleted: %w", ref.ID, err)
+				}
+				return cfg.FirestoreCollection, nil
+			},
+		},
+		{
+			name: "drive folder",
+			hint: "Set GOOGLE_DRIVE_FOLDER_ID and share the folder with the service account (or make it readable with GOOGLE_API_KEY); for a Shared Drive folder set DRIVE_SHARED_DRIVE or DRIVE_ID; with DRIVE_IMPERSONATE_SUBJECT, grant the drive.readonly scope under domain-wide delegation",
+			run: func(ctx context.Context) (string, error) {
+				if cfg.GoogleDriveFolderID == "" {
+					return "", fmt.Errorf("GOOGLE_DRIVE_FOLDER_ID is not set")
+				}
+				driveSvc, identity, err := services.NewDriveAPI(ctx, services.DriveCredentials{
+					APIKey:          cfg.GoogleAPIKey,
+					CredentialsJSON: cfg.FirebaseCredentialsJSON,
+					CredentialsPath: cfg.FirebaseCredentialsPath,
+					Subject:         cfg.DriveImpersonateSubject,
+				})
+				if err != nil {
+					return "", err
+				}
+				driveClient := services.NewDriveClient(driveSvc, services.DefaultDriveClientOptions())
+				if cfg.DriveSharedDrive {
+					driveClient.SetSharedDrive(cfg.DriveID)
+				}
+				if err := driveClient.Ping(ctx, cfg.GoogleDriveFolderID); err != nil {
+					return "", services.ExplainDriveAccessError(err, cfg.GoogleDriveFolderID, identity)
+				}
+				escapedFolderID := strings.ReplaceAll(cfg.GoogleDriveFolderID, "'", "\\'")
+				call := driveSvc.Files.List().Context(ctx)
+				if cfg.DriveSharedDrive {
+					call = call.SupportsAllDrives(true).IncludeItemsFromAllDrives(true).Corpora("allDrives")
+					if cfg.DriveID != "" {
+						call = call.Corpora("drive").DriveId(cfg.DriveID)
+					}
+				}
+				list, err := call.
+					Q(fmt.Sprintf("'%s' in parents and trashed=false", escapedFolderID)).
+					Fields("files(id, name)").
+					PageSize(1).
+					Do()
+				if err != nil {
+					return "", err
+				}
+				if len(list.Files) == 0 {
+					return "folder reachable but empty (as " + identity + ")", nil
+				}
+				return "listed " + list.Files[0].Name + " (as " + identity + ")", nil
+			},
+		},
+		{
+			name: "reverse geocode",
+			hint: fmt.Sprintf("The geocoders in GEOCODERS (%s) must be reachable over HTTPS from this host", strings.Join(cfg.Geocoders, ",")),
+			run: func(ctx context.Context) (string, error) {
+				geocoder, err := services.GeocoderFor(cfg.Geocoders, cfg.GoogleMapsAPIKey)
+				if err != nil {
+					return "", err
+				}
+				parts, err := geocoder.ReverseGeocode(ctx, models.Coordinates{Lat: "51.5007", Lng: "-0.1246"})
+				if err != nil {
+					return "", err
+				}
+				location := services.FormatLocation(parts)
+				if location == "" {
+					return "", fmt.Errorf("empty location for a known coordinate")
+				}
+				return location, nil
+			},
+		},
+		{
+			name: "exiftool",
+			hint: "Install exiftool (apt-get install libimage-exiftool-perl / brew install exiftool); video GPS extraction needs it",
+			run: func(ctx context.Context) (string, error) {
+				out, err := exec.CommandContext(ctx, "exiftool", "-ver").Output()
+				if err != nil {
+					return "", err
+				}
+				return "version " + strings.TrimSpace(string(out)), nil
+			},
+		},
+		{
+			name: "HEIC support",
+			hint: "HEIC decoding needs cgo; build with CGO_ENABLED=1 and a C toolchain",
+			run: func(ctx context.Context) (string, error) {
+				info, ok := debug.ReadBuildInfo()
+				if !ok {
+					return "", fmt.Errorf("build info unavailable")
+				}
+				for _, setting := range info.Settings {
+					if setting.Key == "CGO_ENABLED" {
+						if setting.Value != "1" {
+							return "", fmt.Errorf("binary built with CGO_ENABLED=%s", setting.Value)
+						}
+						return "cgo enabled", nil
+					}
+				}
+				return "", fmt.Errorf("CGO_ENABLED not recorded in build info")
+			},
+		},
+	}
+
+	results := make([]result, 0, len(probes))
+	for _, p := range probes {
+		results = append(results, runProbe(ctx, p))
+	}
+
+	printResults(results)
+
+	for _, r := range results {
+		if r.err != nil {
+			return 1
+		}
+	}
+	return 0
+}
+
+type result struct {
+	name     string
+	detail   string
+	hint     string
+	err      error
+	duration time.Duration
+}
+
+// Runs one probe under its own timeout.
+func runProbe(ctx context.Context, p probe) result {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	detail, err := p.run(ctx)
+	return result{name: p.name, detail: detail, hint: p.hint, err: err, duration: time.Since(start)}
+}
+
+func printResults(results []result) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tSTATUS\tTIME\tDETAIL")
+	for _, r := range results {
+		status, detail := "✅ pass", r.detail
+		if r.err != nil {
+			status, detail = "❌ fail", r.err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%v\t%s\n", r.name, status, r.duration.Round(time.Millisecond), detail)
+	}
+	tw.Flush()
+
+	var failed []result
+	for _, r := range results {
+		if r.err != nil {
+			failed = append(failed, r)
+		}
+	}
+	if len(failed) == 0 {
+		fmt.Println("\nAll checks passed.")
+		return
+	}
+
+	fmt.Println("\nHow to fix:")
+	for _, r := range failed {
+		fmt.Printf("  - %s: %s\n", r.name, r.hint)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+
+	"trekka-api/internal/config"
+	"trekka-api/internal/services"
+)
+
+// Runs `quarantine list` or `quarantine restore <fileName>` and returns the exit code.
+func quarantine(args []string) int {
+	if len(args) == 0 || (args[0] == "restore" && len(args) != 2) {
+		fmt.Fprintln(os.Stderr, "Usage: trekka-admin quarantine list | restore <fileName>")
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	client, err := openFirestore(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "firestore client: %v\n", err)
+		return 1
+	}
+	defer client.Close()
+
+	var opts []option.ClientOption
+	if cfg.FirebaseCredentialsJSON != "" {
+		opts = append(opts, option.WithCredentialsJSON([]byte(cfg.FirebaseCredentialsJSON)))
+	} else {
+		opts = append(opts, option.WithCredentialsFile(cfg.FirebaseCredentialsPath))
+	}
+	storageClient, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "storage client: %v\n", err)
+		return 1
+	}
+	defer storageClient.Close()
+
+	quarantineService := services.NewQuarantineService(
+		services.NewFirestoreService(client, cfg.FirestoreCollection),
+		services.NewStorageService(storageClient, cfg.FirebaseBucketName),
+		cfg.QuarantineAfter,
+	)
+
+	switch args[0] {
+	case "list":
+		records, err := quarantineService.List(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "list: %v\n", err)
+			return 1
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "FILE\tATTEMPTS\tQUARANTINED\tLAST ERROR")
+		for _, r := range records {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", r.FileName, r.Attempts, r.QuarantinedAt.Format("2006-01-02 15:04"), r.LastError)
+		}
+		tw.Flush()
+		return 0
+
+	case "restore":
+		if _, err := quarantineService.Restore(ctx, args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "restore: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Restored %s; it will be re-ingested by the next Drive sync or backfill\n", args[1])
+		return 0
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown quarantine command %q\n", args[0])
+		return 2
+	}
+}
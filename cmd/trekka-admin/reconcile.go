@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+
+	"trekka-api/internal/config"
+	"trekka-api/internal/services"
+)
+
+// Runs `reconcile [--fix]` and returns the exit code (1 if anything is out of step and wasn't fixed).
+func reconcile(args []string) int {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "Create metadata for orphan objects and soft-delete dangling documents")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	client, err := openFirestore(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "firestore client: %v\n", err)
+		return 1
+	}
+	defer client.Close()
+
+	var opts []option.ClientOption
+	if cfg.FirebaseCredentialsJSON != "" {
+		opts = append(opts, option.WithCredentialsJSON([]byte(cfg.FirebaseCredentialsJSON)))
+	} else {
+		opts = append(opts, option.WithCredentialsFile(cfg.FirebaseCredentialsPath))
+	}
+	storageClient, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "storage client: %v\n", err)
+		return 1
+	}
+	defer storageClient.Close()
+
+	firestoreService := services.NewFirestoreService(client, cfg.FirestoreCollection)
+	firestoreService.SetDeterministicIDs(cfg.DeterministicIDs)
+	geocoder := services.NewGeocodingServiceWithCache(firestoreService)
+	geocoder.SetPlaceGridMeters(cfg.PlaceGridMeters)
+	geocoder.SetCacheMaxAge(cfg.GeocodeCacheMaxAge)
+	geocodeProvider, err := services.GeocoderFor(cfg.Geocoders, cfg.GoogleMapsAPIKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "geocoders: %v\n", err)
+		return 1
+	}
+	geocoder.SetProvider(geocodeProvider)
+	reconciler := services.NewReconcileService(
+		firestoreService,
+		services.NewStorageService(storageClient, cfg.FirebaseBucketName),
+		geocoder,
+	)
+
+	report, err := reconciler.Run(ctx, *fix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reconcile: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("%d objects, %d documents: %d orphan objects, %d dangling documents, %d content-type mismatches\n\n",
+		report.Objects, report.Documents, len(report.OrphanObjects), len(report.DanglingDocuments), len(report.ContentTypeMismatches))
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tPATH\tDOCUMENT\tDETAIL")
+	for _, o := range report.OrphanObjects {
+		fmt.Fprintf(tw, "orphan\t%s\t-\t%s, %d bytes\n", o.StoragePath, o.ContentType, o.Size)
+	}
+	for _, d := range report.DanglingDocuments {
+		fmt.Fprintf(tw, "dangling\t%s\t%s\t%s\n", d.StoragePath, d.Id, d.FileName)
+	}
+	for _, m := range report.ContentTypeMismatches {
+		fmt.Fprintf(tw, "content-type\t%s\t%s\t%s (document) vs %s (object)\n", m.StoragePath, m.Id, m.DocumentType, m.ObjectContentType)
+	}
+	tw.Flush()
+
+	if *fix {
+		fmt.Printf("\nCreated %d documents, soft-deleted %d\n", len(report.Created), len(report.SoftDeleted))
+		for _, e := range report.FixErrors {
+			fmt.Fprintf(os.Stderr, "fix failed: %s\n", e)
+		}
+		if len(report.FixErrors) > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	if len(report.OrphanObjects) > 0 || len(report.DanglingDocuments) > 0 {
+		return 1
+	}
+	return 0
+}
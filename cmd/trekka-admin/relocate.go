@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+
+	"trekka-api/internal/config"
+	"trekka-api/internal/models"
+	"trekka-api/internal/services"
+)
+
+// Stops the document walk once --limit files have been moved.
+var errRelocateLimit = errors.New("relocation limit reached")
+
+// Runs `relocate [--dry-run] [--batch N] [--limit N]`, which moves stored files to the keys
+// STORAGE_LAYOUT gives them, and returns the exit code (1 if any move failed).
+// Each file is copied, its document repointed, then the old object deleted, so readers never see
+// a document pointing at a missing object.
+func relocate(args []string) int {
+	fs := flag.NewFlagSet("relocate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Print the moves without copying or writing anything")
+	batch := fs.Int("batch", 100, "Documents read per page; progress is printed after each")
+	limit := fs.Int("limit", 0, "Stop after moving this many files (0 for no limit)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *batch <= 0 || *limit < 0 {
+		fmt.Fprintln(os.Stderr, "--batch must be positive and --limit must not be negative")
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return 1
+	}
+	storagePaths, err := services.StoragePathStrategyFor(cfg.StorageLayout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	client, err := openFirestore(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "firestore client: %v\n", err)
+		return 1
+	}
+	defer client.Close()
+
+	var opts []option.ClientOption
+	if cfg.FirebaseCredentialsJSON != "" {
+		opts = append(opts, option.WithCredentialsJSON([]byte(cfg.FirebaseCredentialsJSON)))
+	} else {
+		opts = append(opts, option.WithCredentialsFile(cfg.FirebaseCredentialsPath))
+	}
+	storageClient, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "storage client: %v\n", err)
+		return 1
+	}
+	defer storageClient.Close()
+
+	firestoreService := services.NewFirestoreService(client, cfg.FirestoreCollection)
+	storageService := services.NewStorageService(storageClient, cfg.FirebaseBucketName)
+
+	moved, seen, skipped, failed := 0, 0, 0, 0
+	err = firestoreService.ForEachImageMetadata(ctx, *batch, func(m *models.ImageMetadata) error {
+		seen++
+		if seen%*batch == 0 {
+			fmt.Printf("... %d documents checked, %d moved\n", seen, moved)
+		}
+
+		// Quarantined and deleted files are kept where they are set aside
+		if m.Hidden() || m.StoragePath == "" {
+			return nil
+		}
+		target := storagePaths(path.Base(m.StoragePath), m.TakenAt)
+		if target == m.StoragePath {
+			return nil
+		}
+
+		taken, err := storageService.Exists(ctx, target)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s (%s): %v\n", m.Id, m.StoragePath, err)
+			failed++
+			return nil
+		}
+		if taken {
+			fmt.Printf("skip %s (%s): %s already exists\n", m.Id, m.StoragePath, target)
+			skipped++
+			return nil
+		}
+
+		if *dryRun {
+			fmt.Printf("would move %s -> %s (%s)\n", m.StoragePath, target, m.Id)
+		} else if err := relocateFile(ctx, storageService, firestoreService, m, target); err != nil {
+			fmt.Fprintf(os.Stderr, "%s (%s): %v\n", m.Id, m.StoragePath, err)
+			failed++
+			return nil
+		}
+
+		moved++
+		if *limit > 0 && moved >= *limit {
+			return errRelocateLimit
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errRelocateLimit) {
+		fmt.Fprintf(os.Stderr, "relocate: %v\n", err)
+		return 1
+	}
+
+	verb := "Moved"
+	if *dryRun {
+		verb = "Would move"
+	}
+	fmt.Printf("%s %d files to the %s layout (%d documents checked, %d skipped, %d failed)\n", verb, moved, cfg.StorageLayout, seen, skipped, failed)
+	if !*dryRun && moved > 0 {
+		fmt.Println("Running servers may have cached signed URLs for the old keys; flush them with DELETE /admin/cache")
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Copies a file to target, points its document there, then deletes the old object. A copy whose
+// document couldn't be repointed is deleted again; an old object that can't be deleted is left
+// for `reconcile` to report as an orphan.
+func relocateFile(ctx context.Context, storageService *services.StorageService, firestoreService *services.FirestoreService, m *models.ImageMetadata, target string) error {
+	if err := storageService.CopyFile(ctx, m.StoragePath, target); err != nil {
+		return err
+	}
+
+	if err := firestoreService.SetStoragePath(ctx, m.Id, target, m.Revision); err != nil {
+		if _, cleanupErr := storageService.DeleteFileIfExists(ctx, target); cleanupErr != nil {
+			return fmt.Errorf("%w (and the copy at %s could not be removed: %v)", err, target, cleanupErr)
+		}
+		return err
+	}
+
+	if _, err := storageService.DeleteFileIfExists(ctx, m.StoragePath); err != nil {
+		fmt.Fprintf(os.Stderr, "%s moved to %s, but the old object remains: %v\n", m.StoragePath, target, err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"trekka-api/internal/config"
+	"trekka-api/internal/services"
+)
+
+// Runs `trips list` or `trips recompute` and returns the exit code.
+func trips(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: trekka-admin trips list | recompute")
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	client, err := openFirestore(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "firestore client: %v\n", err)
+		return 1
+	}
+	defer client.Close()
+
+	tripService := services.NewTripService(
+		services.NewFirestoreService(client, cfg.FirestoreCollection),
+		cfg.TripGap,
+		cfg.TripRegionGap,
+	)
+
+	switch args[0] {
+	case "list":
+		list, err := tripService.List(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "list: %v\n", err)
+			return 1
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tSTART\tEND\tIMAGES\tCOUNTRIES")
+		for _, t := range list {
+			name := t.Name
+			if t.CustomName {
+				name += " (renamed)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", t.Id, name, t.StartAt.Format("2006-01-02"), t.EndAt.Format("2006-01-02"), t.ImageCount, strings.Join(t.Countries, ", "))
+		}
+		tw.Flush()
+		return 0
+
+	case "recompute":
+		report, err := tripService.Recompute(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "recompute: %v\n", err)
+			return 1
+		}
+		fmt.Printf("%d trips from %d images (%d without takenAt skipped); %d manual names kept, %d old trips removed\n",
+			report.Trips, report.Images, report.Undated, report.Renamed, report.Removed)
+		return 0
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown trips command %q\n", args[0])
+		return 2
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"trekka-api/internal/config"
+	"trekka-api/internal/services"
+)
+
+// Runs `undated list` or `undated repair [--dry-run]` and returns the exit code. list exits 1
+// while any document lacks takenAt, since those are invisible to /images/list.
+func undated(args []string) int {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "repair") {
+		fmt.Fprintln(os.Stderr, "Usage: trekka-admin undated list | repair [--dry-run]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("undated "+args[0], flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Print the documents that would be repaired without writing them")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	client, err := openFirestore(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "firestore client: %v\n", err)
+		return 1
+	}
+	defer client.Close()
+
+	firestoreService := services.NewFirestoreService(client, cfg.FirestoreCollection)
+	docs, err := firestoreService.ListImagesWithoutTakenAt(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "list: %v\n", err)
+		return 1
+	}
+
+	if args[0] == "list" {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tFILE\tCREATED")
+		for _, m := range docs {
+			created := "-"
+			if !m.CreatedAt.IsZero() {
+				created = m.CreatedAt.Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", m.Id, m.FileName, created)
+		}
+		tw.Flush()
+		fmt.Printf("%d documents without takenAt (not shown by /images/list)\n", len(docs))
+		if len(docs) > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	// Same fallback sync applies to new records without a capture date
+	repaired, skipped, failed := 0, 0, 0
+	for _, m := range docs {
+		if m.CreatedAt.IsZero() {
+			fmt.Printf("skip %s (%s): no createdAt to copy\n", m.Id, m.FileName)
+			skipped++
+			continue
+		}
+		if *dryRun {
+			fmt.Printf("would set %s (%s) takenAt to %s\n", m.Id, m.FileName, m.CreatedAt.Format("2006-01-02 15:04"))
+			repaired++
+			continue
+		}
+		if err := firestoreService.SetTakenAt(ctx, m.Id, m.CreatedAt); err != nil {
+			fmt.Fprintf(os.Stderr, "%s (%s): %v\n", m.Id, m.FileName, err)
+			failed++
+			continue
+		}
+		repaired++
+	}
+
+	verb := "Repaired"
+	if *dryRun {
+		verb = "Would repair"
+	}
+	fmt.Printf("%s %d of %d documents without takenAt (%d without createdAt skipped, %d failed)\n", verb, repaired, len(docs), skipped, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
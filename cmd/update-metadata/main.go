@@ -2,14 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"log"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/storage"
-	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/option"
 
 	"trekka-api/internal/config"
@@ -18,18 +20,65 @@ import (
 	"trekka-api/internal/utils"
 )
 
-// Handles a list of images, resolves metadata, updates Firestore, and tracks stats
+// Number of updates written per BulkWriter flush.
+const writeBatchSize = 200
+
+// Counts outcomes across a run, keeping the files that failed and why.
+type runStats struct {
+	updated, skipped, noGPS, errors int
+	failures                        []fileFailure
+}
+
+type fileFailure struct {
+	fileName string
+	err      error
+}
+
+// Counts fileName as an error and keeps the cause for the end-of-run report.
+func (s *runStats) fail(fileName string, err error) {
+	s.errors++
+	s.failures = append(s.failures, fileFailure{fileName: fileName, err: err})
+}
+
+// Handles a list of images, resolves metadata, updates Firestore in batches, and tracks stats
 func processImages(
 	ctx context.Context,
 	logger *log.Logger,
 	storageService *services.StorageService,
 	firestoreService *services.FirestoreService,
+	geocoder services.Geocoder,
+	quarantine *services.QuarantineService,
 	images []*models.ImageMetadata,
 	onlyEmpty, dryRun bool,
-	stats *struct {
-		updated, skipped, noGPS, errors int
-	},
+	stats *runStats,
 ) {
+	metrics := services.NewSyncMetrics(10)
+	defer func() { logger.Print(metrics.Summary()) }()
+
+	pending := make([]services.MetadataUpdate, 0, writeBatchSize)
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		logger.Printf("💾 Writing %d updates", len(pending))
+		errs := firestoreService.BulkUpdateImageMetadata(ctx, pending)
+		for i, u := range pending {
+			if errs[i] != nil {
+				logger.Printf("❌ Failed to update %s: %v", u.Metadata.FileName, errs[i])
+				stats.fail(u.Metadata.FileName, errs[i])
+				recordFailure(ctx, logger, quarantine, u.Metadata.FileName, errs[i])
+				continue
+			}
+			if err := quarantine.RecordSuccess(ctx, u.Metadata.FileName); err != nil {
+				logger.Printf("⚠️  Failed to clear failures of %s: %v", u.Metadata.FileName, err)
+			}
+			logger.Printf("✅ Updated %s with location: %s", u.Metadata.FileName, u.Metadata.GeoLocation)
+			stats.updated++
+		}
+		pending = pending[:0]
+	}
+	defer flush()
+
 	for _, img := range images {
 		if onlyEmpty && !utils.HasEmptyFields(img) {
 			logger.Printf("⏭️  Skipping %s (already has complete data)", img.FileName)
@@ -39,20 +88,26 @@ func processImages(
 
 		logger.Printf("🔄 Processing %s", img.FileName)
 
-		// Fetch file from Storage
+		// Fetch file from Storage (timed as the download stage)
+		var timings models.SyncTimings
+		fetchStart := time.Now()
 		fileData, err := storageService.FetchFile(ctx, img.StoragePath)
+		timings.Download = time.Since(fetchStart)
 		if err != nil {
 			logger.Printf("❌ Failed to fetch %s from storage: %v", img.FileName, err)
-			stats.errors++
+			stats.fail(img.FileName, err)
+			if !dryRun {
+				recordFailure(ctx, logger, quarantine, img.FileName, err)
+			}
 			continue
 		}
 
 		if dryRun {
 			// Extract metadata but don't persist
-			extracted, err := services.ExtractMetadataFromBytes(ctx, img.FileName, img.ContentType, fileData)
+			extracted, err := services.ExtractMetadataFromBytes(ctx, img.FileName, img.ContentType, fileData, geocoder)
 			if err != nil {
 				logger.Printf("❌ Failed to extract metadata from %s: %v", img.FileName, err)
-				stats.errors++
+				stats.fail(img.FileName, err)
 				continue
 			}
 			logger.Printf("🔍 [DRY] Would update %s -> %s", img.FileName, extracted.GeoLocation)
@@ -60,19 +115,452 @@ func processImages(
 			continue
 		}
 
-		// Extract and persist metadata using shared function
-		updated, err := services.ExtractAndPersistMetadata(ctx, firestoreService, img.FileName, img.ContentType, fileData, img, services.NewGeocodingService())
+		// Extract and merge now; the write goes out with the next batch
+		merged, err := services.ExtractAndMergeMetadata(ctx, firestoreService, img.FileName, img.ContentType, fileData, img, geocoder, &timings)
+		metrics.Record(img.FileName, timings)
 		if err != nil {
 			logger.Printf("❌ Failed to process %s: %v", img.FileName, err)
+			stats.fail(img.FileName, err)
+			recordFailure(ctx, logger, quarantine, img.FileName, err)
+			continue
+		}
+
+		pending = append(pending, services.MetadataUpdate{Id: img.Id, Metadata: merged, Revision: img.Revision})
+		if len(pending) == writeBatchSize {
+			flush()
+		}
+	}
+}
+
+// Counts a failed attempt towards quarantining the file and logs if it was quarantined
+func recordFailure(ctx context.Context, logger *log.Logger, quarantine *services.QuarantineService, fileName string, cause error) {
+	quarantined, err := quarantine.RecordFailure(ctx, fileName, cause)
+	if err != nil {
+		logger.Printf("⚠️  Failed to record failure of %s: %v", fileName, err)
+		return
+	}
+	if quarantined {
+		logger.Printf("🚧 Quarantined %s after repeated failures", fileName)
+	}
+}
+
+// Computes and stores the dominant color for images that don't have one yet
+func backfillDominantColors(
+	ctx context.Context,
+	logger *log.Logger,
+	storageService *services.StorageService,
+	firestoreService *services.FirestoreService,
+	images []*models.ImageMetadata,
+	dryRun bool,
+	stats *runStats,
+) {
+	for _, img := range images {
+		if img.DominantColor != "" {
+			stats.skipped++
+			continue
+		}
+
+		fileData, err := storageService.FetchFile(ctx, img.StoragePath)
+		if err != nil {
+			logger.Printf("❌ Failed to fetch %s from storage: %v", img.FileName, err)
+			stats.errors++
+			continue
+		}
+
+		// Only the color is needed, so skip the EXIF, geocoding and other extraction
+		color := services.DominantColorOf(img.FileName, img.ContentType, fileData)
+		if color == "" {
+			logger.Printf("❌ No dominant color for %s", img.FileName)
+			stats.errors++
+			continue
+		}
+
+		if dryRun {
+			logger.Printf("🔍 [DRY] Would set %s dominant color -> %s", img.FileName, color)
+			stats.updated++
+			continue
+		}
+
+		if err := firestoreService.SetDominantColor(ctx, img.Id, color); err != nil {
+			logger.Printf("❌ Failed to update %s: %v", img.FileName, err)
+			stats.errors++
+			continue
+		}
+
+		logger.Printf("✅ Set %s dominant color -> %s", img.FileName, color)
+		stats.updated++
+	}
+}
+
+// Computes and stores PlaceKeys for geotagged images that don't have one yet (no file download needed)
+func backfillPlaceKeys(
+	ctx context.Context,
+	logger *log.Logger,
+	firestoreService *services.FirestoreService,
+	geocoder *services.GeocodingService,
+	images []*models.ImageMetadata,
+	dryRun bool,
+	stats *runStats,
+) {
+	for _, img := range images {
+		placeKey := geocoder.PlaceKey(img.Coordinates)
+		if placeKey == "" {
+			stats.noGPS++
+			continue
+		}
+		if img.PlaceKey == placeKey {
+			stats.skipped++
+			continue
+		}
+
+		if dryRun {
+			logger.Printf("🔍 [DRY] Would set %s place -> %s", img.FileName, placeKey)
+			stats.updated++
+			continue
+		}
+
+		if err := firestoreService.SetPlaceKey(ctx, img.Id, placeKey); err != nil {
+			logger.Printf("❌ Failed to update %s: %v", img.FileName, err)
+			stats.errors++
+			continue
+		}
+
+		logger.Printf("✅ Set %s place -> %s", img.FileName, placeKey)
+		stats.updated++
+	}
+}
+
+// Computes and stores geohashes for geotagged images that don't have a current one (no file download needed)
+func backfillGeohashes(
+	ctx context.Context,
+	logger *log.Logger,
+	firestoreService *services.FirestoreService,
+	images []*models.ImageMetadata,
+	dryRun bool,
+	stats *runStats,
+) {
+	for _, img := range images {
+		geohash := utils.GeohashFromCoordinates(img.Coordinates)
+		if geohash == "" {
+			stats.noGPS++
+			continue
+		}
+		if img.Geohash == geohash {
+			stats.skipped++
+			continue
+		}
+
+		if dryRun {
+			logger.Printf("🔍 [DRY] Would set %s geohash -> %s", img.FileName, geohash)
+			stats.updated++
+			continue
+		}
+
+		if err := firestoreService.SetGeohash(ctx, img.Id, geohash); err != nil {
+			logger.Printf("❌ Failed to update %s: %v", img.FileName, err)
+			stats.errors++
+			continue
+		}
+
+		logger.Printf("✅ Set %s geohash -> %s", img.FileName, geohash)
+		stats.updated++
+	}
+}
+
+// Stores the lower-cased file name used by /images/search on documents written before it existed
+func backfillFileNameLower(
+	ctx context.Context,
+	logger *log.Logger,
+	firestoreService *services.FirestoreService,
+	images []*models.ImageMetadata,
+	dryRun bool,
+	stats *runStats,
+) {
+	for _, img := range images {
+		lower := strings.ToLower(img.FileName)
+		if img.FileNameLower == lower {
+			stats.skipped++
+			continue
+		}
+
+		if dryRun {
+			logger.Printf("🔍 [DRY] Would set %s fileNameLower -> %s", img.FileName, lower)
+			stats.updated++
+			continue
+		}
+
+		if err := firestoreService.SetFileNameLower(ctx, img.Id, lower); err != nil {
+			logger.Printf("❌ Failed to update %s: %v", img.FileName, err)
+			stats.errors++
+			continue
+		}
+
+		logger.Printf("✅ Set %s fileNameLower -> %s", img.FileName, lower)
+		stats.updated++
+	}
+}
+
+// Stores the list of empty fields for images written before it was kept (no downloads needed), so
+// -only-empty and the ListImagesMissing* queries find them.
+func backfillMissingFields(
+	ctx context.Context,
+	logger *log.Logger,
+	firestoreService *services.FirestoreService,
+	images []*models.ImageMetadata,
+	dryRun bool,
+	stats *runStats,
+) {
+	for _, img := range images {
+		missing := utils.MissingFields(img)
+		if slices.Equal(img.Missing, missing) {
+			stats.skipped++
+			continue
+		}
+
+		if dryRun {
+			logger.Printf("🔍 [DRY] Would set %s missing -> %v", img.FileName, missing)
+			stats.updated++
+			continue
+		}
+
+		if err := firestoreService.SetMissingFields(ctx, img.Id, missing); err != nil {
+			logger.Printf("❌ Failed to update %s: %v", img.FileName, err)
+			stats.errors++
+			continue
+		}
+
+		logger.Printf("✅ Set %s missing -> %v", img.FileName, missing)
+		stats.updated++
+	}
+}
+
+// Fills city/country from the stored geoLocation for images that don't have a hierarchy yet (no
+// downloads or geocoding). Only the unambiguous "City, Country" form is split; region and country
+// code need a lookup, so run -re-geocode for those and for single-name locations.
+func backfillLocationParts(
+	ctx context.Context,
+	logger *log.Logger,
+	firestoreService *services.FirestoreService,
+	images []*models.ImageMetadata,
+	dryRun bool,
+	stats *runStats,
+) {
+	for _, img := range images {
+		if img.GeoLocation == "" {
+			stats.noGPS++
+			continue
+		}
+		if img.Country != "" || img.CountryCode != "" {
+			stats.skipped++
+			continue
+		}
+		parts, ok := services.SplitGeoLocation(img.GeoLocation)
+		if !ok {
+			logger.Printf("⏭️  Skipping %s: %q is ambiguous, re-geocode it instead", img.FileName, img.GeoLocation)
+			stats.skipped++
+			continue
+		}
+
+		if dryRun {
+			logger.Printf("🔍 [DRY] Would set %s -> city=%s country=%s", img.FileName, parts.City, parts.Country)
+			stats.updated++
+			continue
+		}
+
+		if err := firestoreService.SetLocationParts(ctx, img.Id, parts); err != nil {
+			logger.Printf("❌ Failed to update %s: %v", img.FileName, err)
 			stats.errors++
 			continue
 		}
 
-		logger.Printf("✅ Updated %s with location: %s", updated.FileName, updated.GeoLocation)
+		logger.Printf("✅ Set %s -> city=%s country=%s", img.FileName, parts.City, parts.Country)
+		stats.updated++
+	}
+}
+
+// Fills town/village/state/county for geotagged images located before those were kept, along
+// with city/region/country from the same result; geoLocation is left as it is. Each place is
+// resolved once for all its photos, from the geocode cache when the result there has the
+// details, otherwise with one geocoder call (see GeocodingService.DetailedLocation).
+func backfillLocationDetails(
+	ctx context.Context,
+	logger *log.Logger,
+	firestoreService *services.FirestoreService,
+	geocoder *services.GeocodingService,
+	images []*models.ImageMetadata,
+	dryRun bool,
+	stats *runStats,
+) {
+	type place struct {
+		parts models.LocationParts
+		err   error
+	}
+	places := make(map[string]place)
+	calls := 0
+
+	for _, img := range images {
+		if img.Coordinates.Lat == "" || img.Coordinates.Lng == "" {
+			stats.noGPS++
+			continue
+		}
+		if img.LocationParts().HasDetails() {
+			stats.skipped++
+			continue
+		}
+
+		key := img.PlaceKey
+		if key == "" {
+			key = geocoder.PlaceKey(img.Coordinates)
+		}
+		p, ok := places[key]
+		if !ok {
+			parts, called, err := geocoder.DetailedLocation(ctx, img.Coordinates)
+			if called {
+				calls++
+			}
+			p = place{parts: parts, err: err}
+			places[key] = p
+		}
+		if p.err != nil {
+			logger.Printf("❌ Failed to resolve %s: %v", img.FileName, p.err)
+			stats.errors++
+			continue
+		}
+		if !p.parts.HasDetails() {
+			stats.skipped++
+			continue
+		}
+
+		if dryRun {
+			logger.Printf("🔍 [DRY] Would set %s -> %+v", img.FileName, p.parts)
+			stats.updated++
+			continue
+		}
+
+		if err := firestoreService.SetLocationParts(ctx, img.Id, p.parts); err != nil {
+			logger.Printf("❌ Failed to update %s: %v", img.FileName, err)
+			stats.errors++
+			continue
+		}
+
+		logger.Printf("✅ Set %s -> %+v", img.FileName, p.parts)
+		stats.updated++
+	}
+
+	logger.Printf("🌍 Resolved %d places with %d geocoder calls", len(places), calls)
+}
+
+// Re-resolves geoLocation for every geotagged image from its stored coordinates.
+// In trip mode, points within tripThreshold metres of the last looked-up point reuse its
+// result instead of calling the geocoder; each write records whether it was direct or propagated.
+func reGeocode(
+	ctx context.Context,
+	logger *log.Logger,
+	firestoreService *services.FirestoreService,
+	geocoder services.Geocoder,
+	images []*models.ImageMetadata,
+	tripMode bool,
+	tripThreshold float64,
+	dryRun bool,
+	stats *runStats,
+) {
+	var points []services.TripPoint
+	for _, img := range images {
+		if img.Coordinates.Lat == "" || img.Coordinates.Lng == "" {
+			stats.noGPS++
+			continue
+		}
+		points = append(points, services.TripPoint{ID: img.Id, Coordinates: img.Coordinates, TakenAt: img.TakenAt})
+	}
+
+	var results []services.TripGeocodeResult
+	calls := 0
+	if tripMode {
+		results, calls = services.GeocodeTrip(ctx, geocoder, points, tripThreshold)
+	} else {
+		for _, p := range points {
+			calls++
+			parts, err := geocoder.ReverseGeocode(ctx, p.Coordinates)
+			location := services.FormatLocation(parts)
+			if err != nil || location == "" {
+				continue
+			}
+			results = append(results, services.TripGeocodeResult{ID: p.ID, GeoLocation: location, Parts: parts, Source: services.GeoSourceDirect})
+		}
+	}
+	stats.errors += len(points) - len(results)
+
+	for _, res := range results {
+		if dryRun {
+			logger.Printf("🔍 [DRY] Would set %s -> %s (%s)", res.ID, res.GeoLocation, res.Source)
+			stats.updated++
+			continue
+		}
+
+		if err := firestoreService.SetGeoLocation(ctx, res.ID, res.GeoLocation, res.Parts, res.Source); err != nil {
+			logger.Printf("❌ Failed to update %s: %v", res.ID, err)
+			stats.errors++
+			continue
+		}
 		stats.updated++
+	}
 
-		time.Sleep(100 * time.Millisecond)
+	logger.Printf("Geocoded %d points with %d lookups", len(points), calls)
+}
+
+// Logs a backfill's counts, with the skipped files broken down by why, and each failed file.
+// Files a dry run would sync are logged by the backfill as it goes.
+func printBackfillReport(logger *log.Logger, report *models.BackfillReport) {
+	elapsed := report.FinishedAt.Sub(report.StartedAt).Round(time.Second)
+	if report.DryRun {
+		logger.Printf("Done (dry run): listed=%d filtered=%d wouldSync=%d skipped=%d failed=%d in %v",
+			report.Listed, report.Filtered, report.Synced, report.Skipped, report.Failed, elapsed)
+	} else {
+		logger.Printf("Done: listed=%d filtered=%d synced=%d skipped=%d failed=%d removed=%d in %v",
+			report.Listed, report.Filtered, report.Synced, report.Skipped, report.Failed, len(report.Removed), elapsed)
+	}
+	counts := report.StatusCounts()
+	for _, status := range []string{
+		models.SyncStatusSkippedExisting,
+		models.SyncStatusSkippedComplete,
+		models.SyncStatusSkippedNonMedia,
+		models.SyncStatusSkippedQuarantined,
+	} {
+		if counts[status] > 0 {
+			logger.Printf("   %s: %d", status, counts[status])
+		}
 	}
+	for _, result := range report.Failures() {
+		logger.Printf("   ❌ %s: %s", result.FileName, result.Reason)
+	}
+}
+
+// Splits a comma-separated flag value, dropping blanks.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Parses a -since value, either a date (midnight UTC) or an RFC 3339 time.
+func parseSince(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// Writes a backfill report as indented JSON to path.
+func writeBackfillReport(path string, report *models.BackfillReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
 }
 
 func main() {
@@ -81,7 +569,24 @@ func main() {
 	onlyEmpty := flag.Bool("only-empty", false, "Only update entries with empty GPS/location fields")
 	dryRun := flag.Bool("dry-run", false, "Preview changes without updating Firestore")
 	backfill := flag.Bool("backfill", false, "Force download from Google Drive (slower but more reliable)")
+	dominantColor := flag.Bool("dominant-color", false, "Only backfill dominant colors for entries missing one")
+	placeKey := flag.Bool("place-key", false, "Only backfill place keys from stored coordinates")
+	geohashFlag := flag.Bool("geohash", false, "Only backfill geohashes from stored coordinates")
+	fileNameLower := flag.Bool("file-name-lower", false, "Only backfill lower-cased file names for /images/search")
+	missingFields := flag.Bool("missing-fields", false, "Only backfill the list of empty fields that -only-empty queries")
+	locationParts := flag.Bool("location-parts", false, "Only backfill city/country from stored \"City, Country\" locations")
+	locationDetails := flag.Bool("location-details", false, "Only backfill town/village/state/county from the geocode cache, geocoding each place at most once")
+	reGeocodeFlag := flag.Bool("re-geocode", false, "Re-resolve geoLocation from stored coordinates (no downloads)")
+	tripMode := flag.Bool("trip-mode", false, "With -re-geocode: reuse the last lookup for points within -trip-threshold metres")
+	tripThreshold := flag.Float64("trip-threshold", 2000, "Distance in metres before trip mode geocodes again")
 	skipExisting := flag.Bool("skip-existing", true, "Skip files that already exist in Firestore during backfill")
+	recursive := flag.Bool("recursive", false, "With -backfill: also sync files in subfolders (default from DRIVE_RECURSIVE)")
+	force := flag.Bool("force", false, "With -backfill: download and upload files even when Drive's MD5 matches the stored copy")
+	include := flag.String("include", "", "With -backfill: only files whose name matches one of these comma-separated globs (e.g. \"IMG_*,PXL_*\")")
+	exclude := flag.String("exclude", "", "With -backfill: skip files whose name matches one of these comma-separated globs (e.g. \"Screenshot*\")")
+	mimePrefixes := flag.String("mime", "", "With -backfill: only files whose MIME type starts with one of these comma-separated prefixes (e.g. \"video/\")")
+	since := flag.String("since", "", "With -backfill: only files created in Drive after this date (2006-01-02 or RFC 3339)")
+	reportPath := flag.String("report", "", "With -backfill: also write every file's outcome (or with -dry-run, the plan) as JSON to this file")
 	flag.Parse()
 
 	if *dryRun {
@@ -89,7 +594,7 @@ func main() {
 	}
 	if *backfill {
 		logger.Println("BACKFILL MODE - will download from Drive")
-		logger.Println("Rate limiting: 3 seconds between Drive API calls with exponential backoff retry")
+		logger.Println("Rate limiting: DRIVE_MIN_INTERVAL (default 5s) between Drive API calls with exponential backoff retry")
 	}
 	if *onlyEmpty {
 		logger.Println("Only updating entries with empty GPS/location fields")
@@ -122,48 +627,216 @@ func main() {
 	}
 	defer firestoreClient.Close()
 
-	// Optional: Drive client
-	var driveSvc *drive.Service
-	driveSvc, _ = drive.NewService(ctx, option.WithAPIKey(cfg.GoogleAPIKey))
+	// Optional: Drive client (an API key, else the service account with drive.readonly)
+	driveSvc, driveIdentity, driveErr := services.NewDriveAPI(ctx, services.DriveCredentials{
+		APIKey:          cfg.GoogleAPIKey,
+		CredentialsJSON: cfg.FirebaseCredentialsJSON,
+		CredentialsPath: cfg.FirebaseCredentialsPath,
+		Subject:         cfg.DriveImpersonateSubject,
+	})
 
 	// Services
 	storageService := services.NewStorageService(storageClient, cfg.FirebaseBucketName)
 	firestoreService := services.NewFirestoreService(firestoreClient, cfg.FirestoreCollection)
+	firestoreService.SetDeterministicIDs(cfg.DeterministicIDs)
+
+	geocoder := services.NewGeocodingServiceWithCache(firestoreService)
+	geocoder.SetPlaceGridMeters(cfg.PlaceGridMeters)
+	geocoder.SetCacheMaxAge(cfg.GeocodeCacheMaxAge)
+	geocodeProvider, err := services.GeocoderFor(cfg.Geocoders, cfg.GoogleMapsAPIKey)
+	if err != nil {
+		logger.Fatalf("Invalid geocoders: %v", err)
+	}
+	geocoder.SetProvider(geocodeProvider)
 
 	// Drive sync service (for backfill mode)
 	var driveService *services.DriveService
 	if driveSvc != nil && cfg.GoogleDriveFolderID != "" {
-		driveFileService := services.NewDriveClient(driveSvc)
-		geocoder := services.NewGeocodingService()
+		driveFileService := services.NewDriveClient(driveSvc, services.DriveClientOptions{
+			MinInterval: cfg.DriveMinInterval,
+			MaxRetries:  cfg.DriveMaxRetries,
+			BaseBackoff: cfg.DriveBaseBackoff,
+		})
+		if cfg.DriveSharedDrive {
+			driveFileService.SetSharedDrive(cfg.DriveID)
+		}
 		driveService = services.NewDriveService(driveFileService, storageService, firestoreService, geocoder, cfg.GoogleDriveFolderID)
+		storagePaths, err := services.StoragePathStrategyFor(cfg.StorageLayout)
+		if err != nil {
+			logger.Fatalf("Invalid storage layout: %v", err)
+		}
+		driveService.SetStoragePathStrategy(storagePaths)
+		driveService.SetSyncState(services.NewSyncStateStore(firestoreService))
+		driveService.SetSyncLock(services.NewSyncLock(firestoreService, "drive", cfg.DriveLockTTL))
+		driveService.SetSyncRuns(services.NewSyncRunStore(firestoreService, cfg.SyncRunRetention))
+		driveService.SetRecursive(*recursive || cfg.DriveRecursive)
+		driveService.SetStreamThreshold(cfg.DriveStreamThreshold)
+		driveService.SetFileDelay(cfg.DriveFileDelay)
+		driveService.SetPropagateDeletes(cfg.DrivePropagateDeletes, cfg.DriveDeleteObjects)
+		driveService.SetForce(*force)
+	}
+
+	quarantine := services.NewQuarantineService(firestoreService, storageService, cfg.QuarantineAfter)
+	if driveService != nil {
+		driveService.SetQuarantine(quarantine)
 	}
 
-	stats := struct {
-		updated, skipped, noGPS, errors int
-	}{}
+	var stats runStats
 
 	if *backfill {
+		if driveErr != nil {
+			logger.Fatalf("Backfill mode requires Drive credentials: %v", driveErr)
+		}
 		if driveService == nil {
-			logger.Fatalf("Backfill mode requires GOOGLE_DRIVE_FOLDER_ID and Drive credentials")
+			logger.Fatalf("Backfill mode requires GOOGLE_DRIVE_FOLDER_ID")
+		}
+		// Fail here rather than backfill nothing from a folder the credentials can't see
+		if err := driveService.Ping(ctx); err != nil {
+			logger.Fatalf("Cannot read Drive folder: %v", services.ExplainDriveAccessError(err, cfg.GoogleDriveFolderID, driveIdentity))
 		}
 
 		logger.Println("Starting Drive backfill...")
 		// When running from update-metadata in backfill mode, respect the skipExisting flag
 		// By default it's true (skip existing), but can be disabled with --skip-existing=false
-		if err := driveService.BackfillFromDrive(ctx, *skipExisting); err != nil {
+		filter := services.BackfillFilter{
+			Include:      splitList(*include),
+			Exclude:      splitList(*exclude),
+			MimePrefixes: splitList(*mimePrefixes),
+		}
+		if *since != "" {
+			if filter.CreatedAfter, err = parseSince(*since); err != nil {
+				logger.Fatalf("Invalid -since: %v", err)
+			}
+		}
+		report, err := driveService.BackfillFromDrive(ctx, services.BackfillOptions{SkipExisting: *skipExisting, DryRun: *dryRun, Filter: filter})
+		if report != nil {
+			printBackfillReport(logger, report)
+			if *reportPath != "" {
+				if err := writeBackfillReport(*reportPath, report); err != nil {
+					logger.Printf("Failed to write report: %v", err)
+				} else {
+					logger.Printf("Report written to %s", *reportPath)
+				}
+			}
+		}
+		if err != nil {
 			logger.Fatalf("Backfill failed: %v", err)
 		}
 
 		logger.Println("Backfill complete!")
 		return
 	} else {
-		allImages, err := firestoreService.ListImageMetadata(ctx, 0, 0)
+		if *reGeocodeFlag {
+			allImages, err := firestoreService.ListAllImageMetadata(ctx, 0, 0)
+			if err != nil {
+				logger.Fatalf("list images: %v", err)
+			}
+			reGeocode(ctx, logger, firestoreService, geocoder, allImages, *tripMode, *tripThreshold, *dryRun, &stats)
+
+			logger.Printf("Done: updated=%d skipped=%d noGPS=%d errors=%d",
+				stats.updated, stats.skipped, stats.noGPS, stats.errors)
+			return
+		}
+
+		if *missingFields {
+			allImages, err := firestoreService.ListAllImageMetadata(ctx, 0, 0)
+			if err != nil {
+				logger.Fatalf("list images: %v", err)
+			}
+			backfillMissingFields(ctx, logger, firestoreService, allImages, *dryRun, &stats)
+
+			logger.Printf("Done: updated=%d skipped=%d noGPS=%d errors=%d",
+				stats.updated, stats.skipped, stats.noGPS, stats.errors)
+			return
+		}
+
+		if *fileNameLower {
+			allImages, err := firestoreService.ListAllImageMetadata(ctx, 0, 0)
+			if err != nil {
+				logger.Fatalf("list images: %v", err)
+			}
+			backfillFileNameLower(ctx, logger, firestoreService, allImages, *dryRun, &stats)
+
+			logger.Printf("Done: updated=%d skipped=%d noGPS=%d errors=%d",
+				stats.updated, stats.skipped, stats.noGPS, stats.errors)
+			return
+		}
+
+		if *locationParts {
+			allImages, err := firestoreService.ListAllImageMetadata(ctx, 0, 0)
+			if err != nil {
+				logger.Fatalf("list images: %v", err)
+			}
+			backfillLocationParts(ctx, logger, firestoreService, allImages, *dryRun, &stats)
+
+			logger.Printf("Done: updated=%d skipped=%d noGPS=%d errors=%d",
+				stats.updated, stats.skipped, stats.noGPS, stats.errors)
+			return
+		}
+
+		if *locationDetails {
+			allImages, err := firestoreService.ListAllImageMetadata(ctx, 0, 0)
+			if err != nil {
+				logger.Fatalf("list images: %v", err)
+			}
+			backfillLocationDetails(ctx, logger, firestoreService, geocoder, allImages, *dryRun, &stats)
+
+			logger.Printf("Done: updated=%d skipped=%d noGPS=%d errors=%d",
+				stats.updated, stats.skipped, stats.noGPS, stats.errors)
+			return
+		}
+
+		if *geohashFlag {
+			allImages, err := firestoreService.ListAllImageMetadata(ctx, 0, 0)
+			if err != nil {
+				logger.Fatalf("list images: %v", err)
+			}
+			backfillGeohashes(ctx, logger, firestoreService, allImages, *dryRun, &stats)
+
+			logger.Printf("Done: updated=%d skipped=%d noGPS=%d errors=%d",
+				stats.updated, stats.skipped, stats.noGPS, stats.errors)
+			return
+		}
+
+		if *placeKey {
+			allImages, err := firestoreService.ListAllImageMetadata(ctx, 0, 0)
+			if err != nil {
+				logger.Fatalf("list images: %v", err)
+			}
+			backfillPlaceKeys(ctx, logger, firestoreService, geocoder, allImages, *dryRun, &stats)
+
+			logger.Printf("Done: updated=%d skipped=%d noGPS=%d errors=%d",
+				stats.updated, stats.skipped, stats.noGPS, stats.errors)
+			return
+		}
+
+		if *dominantColor {
+			allImages, err := firestoreService.ListAllImageMetadata(ctx, 0, 0)
+			if err != nil {
+				logger.Fatalf("list images: %v", err)
+			}
+			backfillDominantColors(ctx, logger, storageService, firestoreService, allImages, *dryRun, &stats)
+
+			logger.Printf("Done: updated=%d skipped=%d errors=%d", stats.updated, stats.skipped, stats.errors)
+			return
+		}
+
+		// -only-empty reads just the incomplete documents instead of the whole collection
+		var allImages []*models.ImageMetadata
+		if *onlyEmpty {
+			allImages, err = firestoreService.ListIncompleteImages(ctx)
+		} else {
+			allImages, err = firestoreService.ListImageMetadata(ctx, 0, 0)
+		}
 		if err != nil {
 			logger.Fatalf("list images: %v", err)
 		}
-		processImages(ctx, logger, storageService, firestoreService, allImages, *onlyEmpty, *dryRun, &stats)
+		processImages(ctx, logger, storageService, firestoreService, geocoder, quarantine, allImages, *onlyEmpty, *dryRun, &stats)
 
 		logger.Printf("Done: updated=%d skipped=%d noGPS=%d errors=%d",
 			stats.updated, stats.skipped, stats.noGPS, stats.errors)
+		for _, f := range stats.failures {
+			logger.Printf("   ❌ %s: %v", f.fileName, f.err)
+		}
 	}
 }
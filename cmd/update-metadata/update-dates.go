@@ -1,3 +1,8 @@
+//go:build ignore
+
+// Standalone date updater, kept out of the update-metadata build (which has its own main). Run it
+// with: go run cmd/update-metadata/update-dates.go
+
 package main
 
 import (
@@ -11,6 +16,7 @@ import (
 	"google.golang.org/api/option"
 
 	"trekka-api/internal/config"
+	"trekka-api/internal/models"
 	"trekka-api/internal/services"
 )
 
@@ -56,22 +62,34 @@ func main() {
 	imagesLen := len(allImages)
 	for i, image := range allImages {
 		file, _ := storageService.FetchFile(ctx, image.StoragePath)
-		filemeta, _ := services.ExtractMetadataFromBytes(ctx, image.FileName, image.ContentType, file)
+		filemeta, _ := services.ExtractMetadataFromBytes(ctx, image.FileName, image.ContentType, file, nil)
 		storagemeta, _ := firestoreService.GetImageMetadataByFilename(ctx, image.FileName, image.ContentType)
-		updated := false
-		if filemeta.TakenAt != storagemeta.TakenAt {
-			storagemeta.TakenAt = filemeta.TakenAt
-			updated = true
+		// Write only the date fields, so anything else on the document is left as it is
+		var updates []firestore.Update
+		var filled []interface{}
+		if !filemeta.TakenAt.Equal(storagemeta.TakenAt) {
+			updates = append(updates, firestore.Update{Path: "takenAt", Value: filemeta.TakenAt})
+			if !filemeta.TakenAt.IsZero() {
+				filled = append(filled, models.MissingTakenAt)
+			}
 		}
 
 		if filemeta.FormattedDate != storagemeta.FormattedDate {
-			storagemeta.FormattedDate = filemeta.FormattedDate
-			updated = true
+			updates = append(updates, firestore.Update{Path: "formattedDate", Value: filemeta.FormattedDate})
+			if filemeta.FormattedDate != "" {
+				filled = append(filled, models.MissingFormattedDate)
+			}
 		}
 
-		if updated {
-			storagemeta.UpdatedAt = time.Now()
-			firestoreService.UpdateImageMetadata(ctx, storagemeta.Id, storagemeta)
+		if len(updates) > 0 {
+			if len(filled) > 0 {
+				updates = append(updates, firestore.Update{Path: "missing", Value: firestore.ArrayRemove(filled...)})
+			}
+			updates = append(updates, firestore.Update{Path: "updatedAt", Value: time.Now()})
+			if err := firestoreService.UpdateImageMetadataFields(ctx, storagemeta.Id, updates); err != nil {
+				logger.Printf("Failed %d/%d: %s: %v", i, imagesLen, storagemeta.FileName, err)
+				continue
+			}
 			logger.Printf("Updated %d/%d: %s with type %s", i, imagesLen, storagemeta.FileName, storagemeta.ContentType)
 		}
 	}
@@ -0,0 +1,8 @@
+package docs
+
+import _ "embed"
+
+// SwaggerJSON is the generated OpenAPI document, embedded at build time and served at /openapi.json.
+//
+//go:embed swagger.json
+var SwaggerJSON []byte
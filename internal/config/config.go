@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -11,6 +12,12 @@ import (
 	"github.com/joho/godotenv"
 )
 
+// CORSOrigin is the per-origin policy parsed from ALLOWED_ORIGINS_JSON.
+type CORSOrigin struct {
+	Methods          []string `json:"methods"`
+	AllowCredentials bool     `json:"allowCredentials"`
+}
+
 type Config struct {
 	Port                    string
 	FirebaseProjectID       string
@@ -20,13 +27,52 @@ type Config struct {
 	FirestoreCollection     string
 	CacheTTL                time.Duration
 	CacheCleanupInterval    time.Duration
+	CacheMaxEntries         int           // Entries held before least recently used ones are evicted (0 for no limit)
+	NegativeCacheTTL        time.Duration // How long /image remembers a file name that wasn't found (0 disables)
 	AllowedOrigins          []string
-	APIKeys                 []string      // API keys for authentication (comma-separated)
-	GoogleDriveFolderID     string        // Google Drive folder ID for sync
-	GoogleAPIKey            string        // Google API key for Drive access (alternative to service account)
-	DriveSyncInterval       time.Duration // How often to check Drive for new files (default: 5 minutes)
-	DriveBackfillOnStartup  bool          // Run one-time backfill on server startup before starting watch
-	IsVercel                bool          // Detected via VERCEL env var
+	CORSOrigins             map[string]CORSOrigin // Per-origin policies from ALLOWED_ORIGINS_JSON (overrides AllowedOrigins)
+	APIKeys                 []string              // API keys for authentication (comma-separated)
+	GoogleDriveFolderID     string                // Google Drive folder ID for sync
+	GoogleAPIKey            string                // Google API key for Drive access (alternative to service account)
+	DriveSyncInterval       time.Duration         // How often to check Drive for new files (default: 5 minutes)
+	DriveBackfillOnStartup  bool                  // Run one-time backfill on server startup before starting watch
+	DriveRecursive          bool                  // Also sync files in subfolders of GOOGLE_DRIVE_FOLDER_ID
+	DriveSharedDrive        bool                  // The folder lives in a Shared Drive (implied by DriveID)
+	DriveID                 string                // Shared Drive to search; empty searches every drive when DriveSharedDrive is set
+	DriveImpersonateSubject string                // Workspace user the service account reads Drive as (domain-wide delegation)
+	DriveStreamThreshold    int64                 // Synced files larger than this go through a temp file instead of memory (0 never)
+	DrivePropagateDeletes   bool                  // Soft-delete documents of files deleted in Drive (after two syncs agree)
+	DriveDeleteObjects      bool                  // With DrivePropagateDeletes, also delete their stored objects
+	DriveMinInterval        time.Duration         // Least time between two Drive API calls
+	DriveMaxRetries         int                   // Retries of a Drive call rate limited with 403/429
+	DriveBaseBackoff        time.Duration         // Wait before the first retry of a rate-limited call (doubles, with jitter)
+	DriveFileDelay          time.Duration         // Pause between files during a backfill
+	DriveWatchLookback      time.Duration         // How far back the polling watch looks when it has no saved last check
+	DriveLockTTL            time.Duration         // Lifetime of the cross-instance sync lease unless renewed
+	SyncRunRetention        time.Duration         // How long recorded sync runs are kept (0 keeps them all)
+	DeterministicIDs        bool                  // Key new documents by Drive file ID / content hash / file name instead of random IDs
+	StorageLayout           string                // Where synced files are stored: "dated" (YYYY/MM/<name>) or "flat" (bucket root)
+	RateLimitBackend        string                // "memory" (per instance) or "distributed" (Firestore counters)
+	RateLimitWindow         time.Duration         // Window for the distributed limiter
+	RateLimitWindowMax      int                   // Requests allowed per IP per window across all instances
+	ProxyMaxBytes           int64                 // Largest object /image?mode=proxy will stream
+	PlaceGridMeters         int                   // Grid size for PlaceKey snapping (photos in one cell share a place)
+	GeocodeCacheMaxAge      time.Duration         // Age after which persisted geocoding results are looked up again (0 keeps them)
+	Geocoders               []string              // Geocoding providers tried in order until one finds a place: "nominatim", "google"
+	GoogleMapsAPIKey        string                // Key for the Google Maps Geocoding API ("google" geocoder)
+	NearMaxRadiusKm         int                   // Largest radius accepted by /images/near
+	StaleOnOutage           bool                  // Serve expired cache entries when Firestore is unavailable
+	StaleMaxAge             time.Duration         // How long expired entries are kept for StaleOnOutage
+	CacheListener           bool                  // Evict cached entries when their document changes (snapshot listener; off on Vercel)
+	CacheWarmup             bool                  // Sign and cache the newest images' URLs in the background on startup (off on Vercel)
+	CacheWarmupCount        int                   // Images signed by the startup warm-up
+	QuarantineAfter         int                   // Consecutive processing failures before a file is quarantined (0 disables)
+	SignedURLCheckRate      float64               // Fraction (0-1) of cached signed URLs probed against GCS on a cache hit
+	SignedURLExpiry         time.Duration         // Lifetime of signed URLs (15m to 7 days)
+	PublicMode              bool                  // Serve public images on /image and /images/list without an API key
+	TripGap                 time.Duration         // Largest takenAt gap between consecutive images of one trip
+	TripRegionGap           time.Duration         // Gap after which a change of region starts a new trip (0 disables)
+	IsVercel                bool                  // Detected via VERCEL env var
 }
 
 // Load reads configuration from environment variables and .env file.
@@ -47,15 +93,88 @@ func Load() (*Config, error) {
 		FirestoreCollection:     getEnv("FIRESTORE_COLLECTION", "images"),
 		CacheTTL:                getDurationEnv("CACHE_TTL", 15*time.Minute),
 		CacheCleanupInterval:    getDurationEnv("CACHE_CLEANUP_INTERVAL", 10*time.Minute),
+		CacheMaxEntries:         getIntEnv("CACHE_MAX_ENTRIES", 10_000),
+		NegativeCacheTTL:        getDurationEnv("NEGATIVE_CACHE_TTL", 30*time.Second),
 		AllowedOrigins:          getList("ALLOWED_ORIGINS", []string{"*"}),
 		APIKeys:                 getList("API_KEYS", []string{}),
 		GoogleDriveFolderID:     getEnv("GOOGLE_DRIVE_FOLDER_ID", ""),
 		GoogleAPIKey:            getEnv("GOOGLE_API_KEY", ""),
 		DriveSyncInterval:       getDurationEnv("DRIVE_SYNC_INTERVAL", 5*time.Minute),
 		DriveBackfillOnStartup:  getBoolEnv("DRIVE_BACKFILL_ON_STARTUP", false),
+		DriveRecursive:          getBoolEnv("DRIVE_RECURSIVE", false),
+		DriveSharedDrive:        getBoolEnv("DRIVE_SHARED_DRIVE", false),
+		DriveID:                 getEnv("DRIVE_ID", ""),
+		DriveImpersonateSubject: getEnv("DRIVE_IMPERSONATE_SUBJECT", ""),
+		DriveStreamThreshold:    int64(getIntEnv("DRIVE_STREAM_THRESHOLD_BYTES", 100*1024*1024)),
+		DrivePropagateDeletes:   getBoolEnv("DRIVE_PROPAGATE_DELETES", false),
+		DriveDeleteObjects:      getBoolEnv("DRIVE_PROPAGATE_DELETES_OBJECTS", false),
+		DriveMinInterval:        getDurationEnv("DRIVE_MIN_INTERVAL", 5*time.Second),
+		DriveMaxRetries:         getIntEnv("DRIVE_MAX_RETRIES", 3),
+		DriveBaseBackoff:        getDurationEnv("DRIVE_BASE_BACKOFF", 5*time.Second),
+		DriveFileDelay:          getDurationEnv("DRIVE_FILE_DELAY", 2*time.Second),
+		DriveWatchLookback:      getDurationEnv("DRIVE_WATCH_LOOKBACK", 24*time.Hour),
+		DriveLockTTL:            getDurationEnv("DRIVE_LOCK_TTL", 2*time.Minute),
+		SyncRunRetention:        getDurationEnv("SYNC_RUN_RETENTION", 30*24*time.Hour),
+		DeterministicIDs:        getBoolEnv("DETERMINISTIC_IDS", true),
+		StorageLayout:           getEnv("STORAGE_LAYOUT", "dated"),
+		RateLimitWindow:         getDurationEnv("RATE_LIMIT_WINDOW", time.Minute),
+		RateLimitWindowMax:      getIntEnv("RATE_LIMIT_WINDOW_MAX", 600),
+		ProxyMaxBytes:           int64(getIntEnv("PROXY_MAX_BYTES", 25*1024*1024)),
+		PlaceGridMeters:         getIntEnv("PLACE_GRID_METERS", 100),
+		GeocodeCacheMaxAge:      getDurationEnv("GEOCODE_CACHE_MAX_AGE", 180*24*time.Hour),
+		GoogleMapsAPIKey:        getEnv("GOOGLE_MAPS_API_KEY", ""),
+		NearMaxRadiusKm:         getIntEnv("NEAR_MAX_RADIUS_KM", 50),
+		StaleOnOutage:           getBoolEnv("STALE_ON_OUTAGE", false),
+		StaleMaxAge:             getDurationEnv("STALE_MAX_AGE", 6*time.Hour),
+		CacheListener:           getBoolEnv("CACHE_LISTENER", false),
+		CacheWarmup:             getBoolEnv("CACHE_WARMUP", false),
+		CacheWarmupCount:        getIntEnv("CACHE_WARMUP_COUNT", 200),
+		QuarantineAfter:         getIntEnv("QUARANTINE_AFTER", 3),
+		SignedURLCheckRate:      getFloatEnv("SIGNED_URL_CHECK_RATE", 0),
+		SignedURLExpiry:         getDurationEnv("SIGNED_URL_EXPIRY", 15*time.Minute),
+		PublicMode:              getBoolEnv("PUBLIC_MODE", false),
+		TripGap:                 getDurationEnv("TRIP_GAP", 72*time.Hour),
+		TripRegionGap:           getDurationEnv("TRIP_REGION_GAP", 24*time.Hour),
 		IsVercel:                getEnv("VERCEL", "") != "",
 	}
 
+	// Each Vercel container has its own memory, so default to shared counters there
+	defaultBackend := "memory"
+	if cfg.IsVercel {
+		defaultBackend = "distributed"
+	}
+	cfg.RateLimitBackend = getEnv("RATE_LIMIT_BACKEND", defaultBackend)
+
+	// Google Maps is billed per request, so it only backs Nominatim up when given a key
+	defaultGeocoders := []string{"nominatim"}
+	if cfg.GoogleMapsAPIKey != "" {
+		defaultGeocoders = append(defaultGeocoders, "google")
+	}
+	cfg.Geocoders = getList("GEOCODERS", defaultGeocoders)
+
+	// A Shared Drive ID is only ever used for Shared Drive listings
+	if cfg.DriveID != "" {
+		cfg.DriveSharedDrive = true
+	}
+
+	// Functions are frozen between requests, so a long-lived listener would keep dropping
+	if cfg.IsVercel && cfg.CacheListener {
+		log.Println("CACHE_LISTENER is not supported on Vercel, ignoring")
+		cfg.CacheListener = false
+	}
+
+	// Each cold start would pay for the warm-up, and its cache rarely outlives a few requests
+	if cfg.IsVercel && cfg.CacheWarmup {
+		log.Println("CACHE_WARMUP is not supported on Vercel, ignoring")
+		cfg.CacheWarmup = false
+	}
+
+	corsOrigins, err := getCORSOrigins("ALLOWED_ORIGINS_JSON")
+	if err != nil {
+		return nil, err
+	}
+	cfg.CORSOrigins = corsOrigins
+
 	// Validate required fields
 	if err := cfg.Validate(); err != nil {
 		return nil, err
@@ -84,6 +203,87 @@ func (c *Config) Validate() error {
 	if c.CacheCleanupInterval <= 0 {
 		return fmt.Errorf("CACHE_CLEANUP_INTERVAL must be positive")
 	}
+	if c.CacheMaxEntries < 0 {
+		return fmt.Errorf("CACHE_MAX_ENTRIES must not be negative")
+	}
+	if c.CacheWarmupCount < 0 {
+		return fmt.Errorf("CACHE_WARMUP_COUNT must not be negative")
+	}
+	if c.NegativeCacheTTL < 0 {
+		return fmt.Errorf("NEGATIVE_CACHE_TTL must not be negative")
+	}
+	if c.RateLimitBackend != "memory" && c.RateLimitBackend != "distributed" {
+		return fmt.Errorf("RATE_LIMIT_BACKEND must be \"memory\" or \"distributed\"")
+	}
+	if c.StorageLayout != "dated" && c.StorageLayout != "flat" {
+		return fmt.Errorf("STORAGE_LAYOUT must be \"dated\" or \"flat\"")
+	}
+	if c.PlaceGridMeters <= 0 {
+		return fmt.Errorf("PLACE_GRID_METERS must be positive")
+	}
+	if c.NearMaxRadiusKm <= 0 {
+		return fmt.Errorf("NEAR_MAX_RADIUS_KM must be positive")
+	}
+	if c.QuarantineAfter < 0 {
+		return fmt.Errorf("QUARANTINE_AFTER cannot be negative")
+	}
+	if c.DriveStreamThreshold < 0 {
+		return fmt.Errorf("DRIVE_STREAM_THRESHOLD_BYTES cannot be negative")
+	}
+	if c.DriveMinInterval < 0 || c.DriveFileDelay < 0 {
+		return fmt.Errorf("DRIVE_MIN_INTERVAL and DRIVE_FILE_DELAY cannot be negative")
+	}
+	if c.DriveWatchLookback < 0 {
+		return fmt.Errorf("DRIVE_WATCH_LOOKBACK cannot be negative")
+	}
+	if c.DriveLockTTL < 10*time.Second {
+		return fmt.Errorf("DRIVE_LOCK_TTL must be at least 10s")
+	}
+	if c.SyncRunRetention < 0 {
+		return fmt.Errorf("SYNC_RUN_RETENTION cannot be negative")
+	}
+	if c.DriveMaxRetries < 0 {
+		return fmt.Errorf("DRIVE_MAX_RETRIES cannot be negative")
+	}
+	if c.DriveBaseBackoff <= 0 {
+		return fmt.Errorf("DRIVE_BASE_BACKOFF must be positive")
+	}
+	if c.SignedURLCheckRate < 0 || c.SignedURLCheckRate > 1 {
+		return fmt.Errorf("SIGNED_URL_CHECK_RATE must be between 0 and 1")
+	}
+	// /image redirects are cacheable for 15 minutes, so URLs must outlive that; GCS caps them at 7 days
+	if c.SignedURLExpiry < 15*time.Minute || c.SignedURLExpiry > 7*24*time.Hour {
+		return fmt.Errorf("SIGNED_URL_EXPIRY must be between 15m and 168h")
+	}
+	if c.GeocodeCacheMaxAge < 0 {
+		return fmt.Errorf("GEOCODE_CACHE_MAX_AGE cannot be negative")
+	}
+	for _, name := range c.Geocoders {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "nominatim":
+		case "google":
+			if c.GoogleMapsAPIKey == "" {
+				return fmt.Errorf("GEOCODERS includes \"google\", which needs GOOGLE_MAPS_API_KEY")
+			}
+		default:
+			return fmt.Errorf("GEOCODERS must list \"nominatim\" and/or \"google\", got %q", name)
+		}
+	}
+	if c.TripGap <= 0 {
+		return fmt.Errorf("TRIP_GAP must be positive")
+	}
+	if c.TripRegionGap < 0 {
+		return fmt.Errorf("TRIP_REGION_GAP cannot be negative")
+	}
+	if c.ProxyMaxBytes <= 0 {
+		return fmt.Errorf("PROXY_MAX_BYTES must be positive")
+	}
+	if c.StaleOnOutage && c.StaleMaxAge <= 0 {
+		return fmt.Errorf("STALE_MAX_AGE must be positive when STALE_ON_OUTAGE is enabled")
+	}
+	if c.RateLimitBackend == "distributed" && (c.RateLimitWindow <= 0 || c.RateLimitWindowMax <= 0) {
+		return fmt.Errorf("RATE_LIMIT_WINDOW and RATE_LIMIT_WINDOW_MAX must be positive")
+	}
 	if len(c.APIKeys) == 0 {
 		return fmt.Errorf("API_KEYS is required (comma-separated list of API keys)")
 	}
@@ -113,6 +313,26 @@ func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	return defaultValue
 }
 
+// Retrieves an integer from environment variable or returns a default value.
+func getIntEnv(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+// Retrieves a float from environment variable or returns a default value.
+func getFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
 // Retrieves a comma-separated list from environment variable or returns a default value.
 func getList(key string, defaultValue []string) []string {
 	if value := os.Getenv(key); value != "" {
@@ -121,6 +341,27 @@ func getList(key string, defaultValue []string) []string {
 	return defaultValue
 }
 
+// Parses a JSON object of origin -> CORS policy from an environment variable.
+// Returns nil if the variable is unset.
+func getCORSOrigins(key string) (map[string]CORSOrigin, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil, nil
+	}
+
+	var origins map[string]CORSOrigin
+	if err := json.Unmarshal([]byte(value), &origins); err != nil {
+		return nil, fmt.Errorf("%s must be a JSON object of origin -> {methods, allowCredentials}: %w", key, err)
+	}
+	for origin, policy := range origins {
+		if origin == "*" && policy.AllowCredentials {
+			return nil, fmt.Errorf("%s: credentials cannot be allowed for wildcard origin", key)
+		}
+	}
+
+	return origins, nil
+}
+
 // Retrieves a boolean from environment variable or returns a default value.
 func getBoolEnv(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetCORSOrigins(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]CORSOrigin
+		wantErr bool
+	}{
+		{name: "unset", value: "", want: nil},
+		{
+			name:  "per-origin policies",
+			value: `{"https://app.example.com":{"methods":["GET","POST"],"allowCredentials":true},"*":{"methods":["GET"]}}`,
+			want: map[string]CORSOrigin{
+				"https://app.example.com": {Methods: []string{"GET", "POST"}, AllowCredentials: true},
+				"*":                       {Methods: []string{"GET"}},
+			},
+		},
+		{name: "not an object", value: `["https://app.example.com"]`, wantErr: true},
+		{name: "credentials for the wildcard", value: `{"*":{"allowCredentials":true}}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ALLOWED_ORIGINS_JSON", tt.value)
+
+			got, err := getCORSOrigins("ALLOWED_ORIGINS_JSON")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getCORSOrigins() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getCORSOrigins() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,12 +1,61 @@
 package errors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // Common application errors for type-safe error handling.
 // These errors can be checked using errors.Is() instead of string comparison.
 var (
-	ErrNotFound     = errors.New("resource not found")
-	ErrInvalidInput = errors.New("invalid input")
-	ErrUnauthorized = errors.New("unauthorized")
-	ErrInternal     = errors.New("internal server error")
+	ErrNotFound             = errors.New("resource not found")
+	ErrInvalidInput         = errors.New("invalid input")
+	ErrUnauthorized         = errors.New("unauthorized")
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrTooLarge             = errors.New("resource too large")
+	ErrUnavailable          = errors.New("upstream service unavailable")
+	ErrRangeNotSatisfiable  = errors.New("requested range not satisfiable")
+	ErrConflict             = errors.New("resource modified concurrently")
+	ErrUnprocessable        = errors.New("request cannot be applied to this resource")
+	ErrInternal             = errors.New("internal server error")
+	ErrMissingIndex         = errors.New("missing Firestore index")
+	ErrChecksumMismatch     = errors.New("upload checksum mismatch")
 )
+
+// IndexError reports a query that needs a Firestore composite index that hasn't been created.
+// It matches ErrMissingIndex with errors.Is.
+type IndexError struct {
+	Fields string // The index to create, e.g. "countryCode ASC, takenAt DESC"
+	Err    error  // The Firestore error (its message links to the console)
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("%s on %s", ErrMissingIndex, e.Fields)
+}
+
+func (e *IndexError) Is(target error) bool {
+	return target == ErrMissingIndex
+}
+
+func (e *IndexError) Unwrap() error {
+	return e.Err
+}
+
+// RangeError reports a byte range that lies outside an object, carrying the object's size for
+// the Content-Range of the 416 response. It matches ErrRangeNotSatisfiable with errors.Is.
+type RangeError struct {
+	Size int64 // Full size of the object in bytes
+	Err  error // The storage error, if any
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("%s (object is %d bytes)", ErrRangeNotSatisfiable, e.Size)
+}
+
+func (e *RangeError) Is(target error) bool {
+	return target == ErrRangeNotSatisfiable
+}
+
+func (e *RangeError) Unwrap() error {
+	return e.Err
+}
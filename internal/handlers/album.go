@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+// Largest album create/update body accepted (room for MaxAlbumImages IDs).
+const maxAlbumBody = 256 << 10 // 256KB
+
+// HandleListAlbums lists every album.
+//
+//	@Summary		List albums
+//	@Description	Every album with its summary, ordered by name.
+//	@Tags			albums
+//	@Produce		json
+//	@Success		200	{array}		models.Album			"Albums"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503	{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/albums [get]
+func (h *Handler) HandleListAlbums(w http.ResponseWriter, r *http.Request) {
+	albums, err := h.imageService.ListAlbums(r.Context())
+	if err != nil {
+		log.Printf("[Album] Failed to list albums: %v", err)
+		writeAlbumError(w, r, err)
+		return
+	}
+	writeAlbumJSON(w, http.StatusOK, albums)
+}
+
+// HandleCreateAlbum creates an album.
+//
+//	@Summary		Create an album
+//	@Description	Creates an album from {"name", "description", "coverImageId", "imageIds"}; name is required, imageIds are image document IDs
+//	@Description	in album order (max 1000) and must exist, and coverImageId must be one of them.
+//	@Tags			albums
+//	@Accept			json
+//	@Produce		json
+//	@Param			album	body		models.AlbumInput		true	"Album to create"
+//	@Success		201		{object}	models.Album			"Created album"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		413		{object}	models.ErrorResponse	"Request body too large"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/albums [post]
+func (h *Handler) HandleCreateAlbum(w http.ResponseWriter, r *http.Request) {
+	input, ok := decodeAlbumInput(w, r)
+	if !ok {
+		return
+	}
+	album, err := h.imageService.CreateAlbum(r.Context(), input)
+	if err != nil {
+		log.Printf("[Album] Failed to create album: %v", err)
+		writeAlbumError(w, r, err)
+		return
+	}
+	log.Printf("[Album] Created album %s (%q, %d images)", album.Id, album.Name, len(album.ImageIDs))
+	writeAlbumJSON(w, http.StatusCreated, album)
+}
+
+// HandleGetAlbum returns one album.
+//
+//	@Summary		Get an album
+//	@Tags			albums
+//	@Produce		json
+//	@Param			id	path		string					true	"Album ID"
+//	@Success		200	{object}	models.Album			"Album"
+//	@Failure		404	{object}	models.ErrorResponse	"Album not found"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503	{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/albums/{id} [get]
+func (h *Handler) HandleGetAlbum(w http.ResponseWriter, r *http.Request) {
+	album, err := h.imageService.GetAlbum(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeAlbumError(w, r, err)
+		return
+	}
+	writeAlbumJSON(w, http.StatusOK, album)
+}
+
+// HandleUpdateAlbum changes an album's fields or membership.
+//
+//	@Summary		Update an album
+//	@Description	Takes the same body as album creation; omitted fields are unchanged, imageIds replaces the whole list (use it to reorder),
+//	@Description	and an empty coverImageId goes back to the computed cover.
+//	@Tags			albums
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string					true	"Album ID"
+//	@Param			album	body		models.AlbumInput		true	"Fields to change"
+//	@Success		200		{object}	models.Album			"Album"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		404		{object}	models.ErrorResponse	"Album not found"
+//	@Failure		409		{object}	models.ErrorResponse	"Album modified concurrently"
+//	@Failure		413		{object}	models.ErrorResponse	"Request body too large"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/albums/{id} [patch]
+func (h *Handler) HandleUpdateAlbum(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	input, ok := decodeAlbumInput(w, r)
+	if !ok {
+		return
+	}
+	album, err := h.imageService.UpdateAlbum(r.Context(), id, input)
+	if err != nil {
+		log.Printf("[Album] Failed to update album %s: %v", id, err)
+		writeAlbumError(w, r, err)
+		return
+	}
+	log.Printf("[Album] Updated album %s (%d images)", id, len(album.ImageIDs))
+	writeAlbumJSON(w, http.StatusOK, album)
+}
+
+// HandleDeleteAlbum deletes an album, leaving its images in place.
+//
+//	@Summary		Delete an album
+//	@Tags			albums
+//	@Param			id	path		string					true	"Album ID"
+//	@Success		204	{string}	string					"Deleted"
+//	@Failure		404	{object}	models.ErrorResponse	"Album not found"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503	{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/albums/{id} [delete]
+func (h *Handler) HandleDeleteAlbum(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := h.imageService.DeleteAlbum(r.Context(), id); err != nil {
+		log.Printf("[Album] Failed to delete album %s: %v", id, err)
+		writeAlbumError(w, r, err)
+		return
+	}
+	log.Printf("[Album] Deleted album %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleAlbumImages returns an album's images in album order.
+//
+//	@Summary		List album images
+//	@Description	Metadata of an album's members in the album's order, paginated over the album's references. References to images that
+//	@Description	have since been deleted are skipped and counted in "missing", so a page can hold fewer than limit images.
+//	@Tags			albums
+//	@Produce		json
+//	@Param			id		path		string					true	"Album ID"
+//	@Param			limit	query		int						false	"Images per page (default 100, max 1000, 0 for all)"
+//	@Param			page	query		int						false	"Page number (0-indexed)"
+//	@Success		200		{object}	models.AlbumImages		"Album and page of images"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		404		{object}	models.ErrorResponse	"Album not found"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/albums/{id}/images [get]
+func (h *Handler) HandleAlbumImages(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	id := r.PathValue("id")
+	query := r.URL.Query()
+
+	limit := 100
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err != nil || parsedLimit < 0 {
+			writeError(w, r, http.StatusBadRequest, "Invalid limit parameter")
+			return
+		}
+		limit = parsedLimit
+	}
+
+	page := 0
+	if pageStr := query.Get("page"); pageStr != "" {
+		parsedPage, err := strconv.Atoi(pageStr)
+		if err != nil || parsedPage < 0 {
+			writeError(w, r, http.StatusBadRequest, "Invalid page parameter")
+			return
+		}
+		page = parsedPage
+	}
+
+	result, err := h.imageService.ListAlbumImages(r.Context(), id, limit, page)
+	if err != nil {
+		log.Printf("[Album] Failed to list images of album %s: %v", id, err)
+		writeAlbumError(w, r, err)
+		return
+	}
+
+	if result.Missing > 0 {
+		log.Printf("[Album] Album %s has %d dangling image references on page %d", id, result.Missing, page)
+	}
+	log.Printf("[Album] Served %d images of album %s (limit=%d, page=%d) in %v", len(result.Images), id, limit, page, time.Since(start))
+
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	writeAlbumJSON(w, http.StatusOK, result)
+}
+
+// Decodes an album create/update body, writing the error response itself on failure.
+func decodeAlbumInput(w http.ResponseWriter, r *http.Request) (models.AlbumInput, bool) {
+	var input models.AlbumInput
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAlbumBody)).Decode(&input); err != nil {
+		writeError(w, r, bodyErrorStatus(err), "Body must be a JSON album object")
+		return input, false
+	}
+	return input, true
+}
+
+// Writes an album service error. Validation failures carry their reason (e.g. which image ID
+// doesn't exist), since the caller needs it to fix the request.
+func writeAlbumError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, apperrors.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "Album not found")
+	case errors.Is(err, apperrors.ErrInvalidInput):
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	default:
+		writeServiceError(w, r, err)
+	}
+}
+
+// Encodes v as the JSON response body with the given status.
+func writeAlbumJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[Album] Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+	"trekka-api/internal/services"
+)
+
+const maxBackfillBody = 4 << 10 // 4KB
+
+// HandleBackfill starts a backfill from Drive in the background.
+//
+//	@Summary		Start a Drive backfill
+//	@Description	Syncs every file in the Drive folder, as on startup with DRIVE_BACKFILL_ON_STARTUP. The body is optional; skipExisting
+//	@Description	defaults to true. Runs in the background: GET /admin/backfill reports when it is done and what happened to each file.
+//	@Description	With dryRun, files are only listed and looked up, and the report says which would be synced and why; nothing is
+//	@Description	downloaded or written. include/exclude (file name globs), mime (type prefixes) and createdAfter narrow the run to
+//	@Description	matching files before anything is downloaded. Only one backfill runs at a time; starting another while one is going gets 409,
+//	@Description	as does starting one (other than a dry run) while another instance holds the Drive sync lock or the sync is paused.
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		models.BackfillRequest	false	"Backfill options"
+//	@Success		202		{object}	models.BackfillStarted	"Started"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		409		{object}	models.ErrorResponse	"Backfill already running, sync paused, or another instance is syncing"
+//	@Failure		503		{object}	models.ErrorResponse	"Drive sync is not enabled"
+//	@Security		ApiKeyAuth
+//	@Router			/admin/backfill [post]
+func (h *Handler) HandleBackfill(w http.ResponseWriter, r *http.Request) {
+	if h.driveService == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "Drive sync is not enabled")
+		return
+	}
+
+	var input models.BackfillRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBackfillBody)).Decode(&input); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, r, bodyErrorStatus(err), "Body must be a JSON backfill request")
+		return
+	}
+	options := services.BackfillOptions{
+		SkipExisting: input.SkipExisting == nil || *input.SkipExisting,
+		DryRun:       input.DryRun,
+		Filter: services.BackfillFilter{
+			Include:      input.Include,
+			Exclude:      input.Exclude,
+			MimePrefixes: input.Mime,
+		},
+	}
+	if input.CreatedAfter != nil {
+		options.Filter.CreatedAfter = *input.CreatedAfter
+	}
+
+	// Not the request's context, which ends with this response
+	if err := h.driveService.StartBackfill(context.Background(), options); err != nil {
+		if errors.Is(err, services.ErrSyncPaused) {
+			writeError(w, r, http.StatusConflict, "Drive sync is paused")
+			return
+		}
+		if errors.Is(err, services.ErrSyncLocked) {
+			writeError(w, r, http.StatusConflict, "Another instance is syncing Drive")
+			return
+		}
+		if errors.Is(err, apperrors.ErrConflict) {
+			writeError(w, r, http.StatusConflict, "Backfill already running")
+			return
+		}
+		if errors.Is(err, apperrors.ErrInvalidInput) {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("[Backfill] Failed to start backfill: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+	log.Printf("[Backfill] Started backfill (skipExisting=%t, dryRun=%t, filter=%q)", options.SkipExisting, options.DryRun, options.Filter)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(models.BackfillStarted{
+		Started:      true,
+		SkipExisting: options.SkipExisting,
+		DryRun:       options.DryRun,
+	}); err != nil {
+		log.Printf("[Backfill] Failed to encode response: %v", err)
+	}
+}
+
+// HandleBackfillStatus reports whether a backfill is running and how the latest one went.
+//
+//	@Summary		Drive backfill status
+//	@Description	The report of the latest backfill to finish, whether started here or on startup: counts of synced, skipped and
+//	@Description	failed files, and each file's status with why it was skipped or the error it failed with. Kept in memory only.
+//	@Description	Also says whether the sync is paused (POST /admin/sync/pause) and since when.
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	models.BackfillStatus	"Backfill status"
+//	@Failure		503	{object}	models.ErrorResponse	"Drive sync is not enabled"
+//	@Security		ApiKeyAuth
+//	@Router			/admin/backfill [get]
+func (h *Handler) HandleBackfillStatus(w http.ResponseWriter, r *http.Request) {
+	if h.driveService == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "Drive sync is not enabled")
+		return
+	}
+
+	last, running := h.driveService.LastBackfill()
+	status := models.BackfillStatus{Running: running, Last: last}
+	if pausedAt := h.driveService.PausedAt(); !pausedAt.IsZero() {
+		status.Paused, status.PausedAt = true, &pausedAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Printf("[Backfill] Failed to encode response: %v", err)
+	}
+}
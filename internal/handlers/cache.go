@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// HandleCacheStats reports what the in-memory signed URL / rendition cache holds.
+//
+//	@Summary		Cache statistics
+//	@Description	Entry count, hit/miss counters since startup and approximate memory usage of the in-memory cache,
+//	@Description	plus counters for signed URL probes (sampled checks and /image/report-broken)
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	models.CacheStats	"Cache statistics"
+//	@Security		ApiKeyAuth
+//	@Router			/admin/cache/stats [get]
+func (h *Handler) HandleCacheStats(w http.ResponseWriter, r *http.Request) {
+	stats := h.cacheService.Stats()
+	stats.SignedURLs = h.imageService.URLCheckStats()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		log.Printf("[Cache] Failed to encode response: %v", err)
+	}
+}
+
+// HandleCacheFlush empties the cache, or evicts a single entry when key is given.
+//
+//	@Summary		Flush cache
+//	@Description	Removes every cache entry, or only the one named by key. Responds with the number of entries removed.
+//	@Tags			admin
+//	@Produce		json
+//	@Param			key	query		string					false	"Cache key to evict (e.g. a file name)"
+//	@Success		200	{object}	map[string]int			"Entries removed"
+//	@Failure		404	{object}	models.ErrorResponse	"Key not cached"
+//	@Security		ApiKeyAuth
+//	@Router			/admin/cache [delete]
+func (h *Handler) HandleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	removed := 0
+	if key := r.URL.Query().Get("key"); key != "" {
+		if !h.cacheService.Delete(key) {
+			writeError(w, r, http.StatusNotFound, "Key not cached")
+			return
+		}
+		removed = 1
+		log.Printf("[Cache] Evicted %s", key)
+	} else {
+		removed = h.cacheService.Flush()
+		log.Printf("[Cache] Flushed %d entries", removed)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]int{"removed": removed}); err != nil {
+		log.Printf("[Cache] Failed to encode response: %v", err)
+	}
+}
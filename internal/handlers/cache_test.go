@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"trekka-api/internal/models"
+	"trekka-api/internal/services"
+)
+
+func TestCacheAdminEndpoints(t *testing.T) {
+	cache := services.NewCacheService(time.Hour, time.Hour)
+	t.Cleanup(cache.Stop)
+	h := New(services.NewImageService(nil, cache, nil), nil, nil, cache, nil, nil, nil, nil, nil, nil, nil)
+
+	for _, key := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		cache.SetBytes(key, []byte(key), "image/jpeg", "")
+	}
+	cache.Get("a.jpg")
+	cache.Get("missing.jpg")
+
+	rec := httptest.NewRecorder()
+	h.HandleCacheStats(rec, httptest.NewRequest("GET", "/admin/cache/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("stats status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("stats Cache-Control = %q, want no-store", got)
+	}
+	var stats models.CacheStats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatalf("decode stats: %v", err)
+	}
+	if stats.Entries != 3 || stats.Hits != 1 || stats.Misses != 1 || stats.ApproxBytes == 0 {
+		t.Errorf("stats = %+v, want 3 entries, 1 hit, 1 miss and a size", stats)
+	}
+
+	flush := func(target string) (int, int) {
+		rec := httptest.NewRecorder()
+		h.HandleCacheFlush(rec, httptest.NewRequest("DELETE", target, nil))
+		var body struct {
+			Removed int `json:"removed"`
+		}
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("decode flush response: %v", err)
+			}
+		}
+		return rec.Code, body.Removed
+	}
+
+	if status, removed := flush("/admin/cache?key=b.jpg"); status != http.StatusOK || removed != 1 {
+		t.Errorf("evict b.jpg = %d removing %d, want 200 removing 1", status, removed)
+	}
+	if _, ok := cache.Get("b.jpg"); ok {
+		t.Error("b.jpg still cached after eviction")
+	}
+	if status, _ := flush("/admin/cache?key=b.jpg"); status != http.StatusNotFound {
+		t.Errorf("evict an uncached key = %d, want 404", status)
+	}
+	if status, removed := flush("/admin/cache"); status != http.StatusOK || removed != 2 {
+		t.Errorf("flush = %d removing %d, want 200 removing 2", status, removed)
+	}
+	if n := cache.Len(); n != 0 {
+		t.Errorf("%d entries left after flush", n)
+	}
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"trekka-api/internal/models"
+	"trekka-api/internal/services"
+	"trekka-api/internal/testutil"
+)
+
+func TestStaleFallbackDuringFirestoreOutage(t *testing.T) {
+	outage := testutil.NewFirestoreOutage(t, codes.Unavailable)
+	gcs := testutil.NewFakeGCS(t)
+
+	// Entries expire almost at once but are kept for the fallback
+	cache := services.NewCacheService(10*time.Millisecond, time.Hour)
+	t.Cleanup(cache.Stop)
+	images := services.NewImageService(services.NewStorageService(gcs.Client, testutil.FakeBucket), cache,
+		services.NewFirestoreService(outage.Client, "images"))
+	images.EnableStaleFallback(time.Hour)
+	h := New(images, nil, nil, cache, nil, nil, nil, nil, nil, nil, nil)
+
+	cache.SetSignedURL("photo.jpg", models.SignedURLEntry{
+		URL:         "https://storage.example.com/photo.jpg?signature=abc",
+		ContentType: "image/jpeg",
+		GeoLocation: "Lisbon, Portugal",
+		FileName:    "photo.jpg",
+		StoragePath: "images/photo.jpg",
+		URLExpires:  time.Now().Add(time.Hour),
+	})
+	list, err := json.Marshal([]*models.ImageMetadata{{Id: "img-1", FileName: "photo.jpg"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.SetBytes("list:1000:0", list, "application/json", "")
+	time.Sleep(20 * time.Millisecond)
+
+	if images.Degraded() {
+		t.Fatal("Degraded before any fallback was served")
+	}
+
+	t.Run("cached image keeps serving", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.HandleImage(rec, httptest.NewRequest(http.MethodGet, "/image?fileName=photo.jpg", nil))
+
+		if rec.Code != http.StatusFound {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusFound)
+		}
+		if rec.Header().Get("Location") == "" {
+			t.Error("no Location header")
+		}
+		if got := rec.Header().Get("X-Geo-Location"); got != "Lisbon, Portugal" {
+			t.Errorf("X-Geo-Location = %q, want the cached Lisbon, Portugal", got)
+		}
+		if rec.Header().Get("X-Data-Staleness") == "" {
+			t.Error("no X-Data-Staleness header on a stale response")
+		}
+		if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+			t.Errorf("Cache-Control = %q, want no-store", got)
+		}
+	})
+
+	t.Run("cached list keeps serving", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.HandleImagesList(rec, httptest.NewRequest(http.MethodGet, "/images/list", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if rec.Header().Get("X-Data-Staleness") == "" {
+			t.Error("no X-Data-Staleness header on a stale response")
+		}
+		var got []*models.ImageMetadata
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("decode list: %v", err)
+		}
+		if len(got) != 1 || got[0].Id != "img-1" {
+			t.Errorf("list = %v, want the cached img-1", got)
+		}
+	})
+
+	t.Run("uncached requests fail with 503", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.HandleImage(rec, httptest.NewRequest(http.MethodGet, "/image?fileName=other.jpg", nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("/image status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+		}
+		if got := rec.Header().Get("Retry-After"); got == "" {
+			t.Error("no Retry-After on 503")
+		}
+
+		rec = httptest.NewRecorder()
+		h.HandleImagesList(rec, httptest.NewRequest(http.MethodGet, "/images/list?page=1", nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("/images/list status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+		}
+	})
+
+	t.Run("health reports degraded", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.HandleHealth(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+		var body map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode health: %v", err)
+		}
+		if body["status"] != "degraded" {
+			t.Errorf("status = %q, want degraded", body["status"])
+		}
+	})
+}
+
+func TestStaleFallbackWithCustomTTLs(t *testing.T) {
+	outage := testutil.NewFirestoreOutage(t, codes.Unavailable)
+	gcs := testutil.NewFakeGCS(t)
+
+	// The cache's own TTL is long; these entries expire on TTLs of their own. The retention
+	// allows for the reads' retries (under a second) before the fallback is looked up.
+	cache := services.NewCacheService(time.Hour, time.Hour)
+	t.Cleanup(cache.Stop)
+	images := services.NewImageService(services.NewStorageService(gcs.Client, testutil.FakeBucket), cache,
+		services.NewFirestoreService(outage.Client, "images"))
+	images.EnableStaleFallback(1500 * time.Millisecond)
+	h := New(images, nil, nil, cache, nil, nil, nil, nil, nil, nil, nil)
+
+	list, err := json.Marshal([]*models.ImageMetadata{{Id: "img-1", FileName: "photo.jpg"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.SetBytesWithTTL("list:1000:0", list, "application/json", "", time.Second)
+	cache.SetBytesWithTTL("list:1000:1", list, "application/json", "", 10*time.Millisecond)
+	time.Sleep(1100 * time.Millisecond)
+
+	t.Run("within retention of its own expiry", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.HandleImagesList(rec, httptest.NewRequest(http.MethodGet, "/images/list", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		// Measured from when it was stored, not from an expiry the cache's TTL would have given it
+		staleness, err := strconv.Atoi(rec.Header().Get("X-Data-Staleness"))
+		if err != nil || staleness > 5 {
+			t.Errorf("X-Data-Staleness = %q, want the seconds since it was stored", rec.Header().Get("X-Data-Staleness"))
+		}
+	})
+
+	t.Run("past retention of its own expiry", func(t *testing.T) {
+		// Still held, as the cleanup hasn't run, but too old to serve
+		rec := httptest.NewRecorder()
+		h.HandleImagesList(rec, httptest.NewRequest(http.MethodGet, "/images/list?page=1", nil))
+
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+		}
+	})
+}
+
+func TestOutageWithoutStaleFallback(t *testing.T) {
+	outage := testutil.NewFirestoreOutage(t, codes.Unavailable)
+	gcs := testutil.NewFakeGCS(t)
+
+	cache := services.NewCacheService(10*time.Millisecond, time.Hour)
+	t.Cleanup(cache.Stop)
+	images := services.NewImageService(services.NewStorageService(gcs.Client, testutil.FakeBucket), cache,
+		services.NewFirestoreService(outage.Client, "images"))
+	h := New(images, nil, nil, cache, nil, nil, nil, nil, nil, nil, nil)
+
+	cache.SetSignedURL("photo.jpg", models.SignedURLEntry{
+		URL:        "https://storage.example.com/photo.jpg?signature=abc",
+		FileName:   "photo.jpg",
+		URLExpires: time.Now().Add(time.Hour),
+	})
+	time.Sleep(20 * time.Millisecond)
+
+	// The flag is off, so an expired entry isn't served in place of the failed read
+	rec := httptest.NewRecorder()
+	h.HandleImage(rec, httptest.NewRequest(http.MethodGet, "/image?fileName=photo.jpg", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if images.Degraded() {
+		t.Error("Degraded with stale fallback disabled")
+	}
+}
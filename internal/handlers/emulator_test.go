@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"trekka-api/internal/models"
+	"trekka-api/internal/services"
+	"trekka-api/internal/testutil"
+)
+
+// A Handler on the Firestore emulator and a fake GCS server, with the clients to seed them.
+// Skips the test unless FIRESTORE_EMULATOR_HOST is set (see make test-emulator).
+type testEnv struct {
+	handler   *Handler
+	images    *services.ImageService
+	firestore *firestore.Client
+	gcs       *testutil.FakeGCS
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	client := testutil.FirestoreClient(t)
+	gcs := testutil.NewFakeGCS(t)
+
+	cache := services.NewCacheService(time.Minute, time.Minute)
+	t.Cleanup(cache.Stop)
+	fs := services.NewFirestoreService(client, "images")
+	images := services.NewImageService(services.NewStorageService(gcs.Client, testutil.FakeBucket), cache, fs)
+
+	return &testEnv{
+		handler:   New(images, nil, nil, cache, nil, nil, nil, nil, nil, nil, nil),
+		images:    images,
+		firestore: client,
+		gcs:       gcs,
+	}
+}
+
+// Stores an image's document and object.
+func (e *testEnv) seedImage(t *testing.T, id string, metadata *models.ImageMetadata, data []byte) {
+	t.Helper()
+	if _, err := e.firestore.Collection("images").Doc(id).Set(context.Background(), metadata); err != nil {
+		t.Fatalf("seed image %s: %v", id, err)
+	}
+	if data != nil {
+		e.gcs.Put(metadata.StoragePath, data, metadata.ContentType)
+	}
+}
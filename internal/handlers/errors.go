@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/middleware"
+	"trekka-api/internal/models"
+)
+
+// Writes a JSON error envelope whose code follows the status (see models.ErrorCodeForStatus).
+// Every handler error goes through here so clients can parse failures uniformly.
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	middleware.WriteError(w, r, status, models.ErrorCodeForStatus(status), message)
+}
+
+// Maps a service error to an HTTP status code and client-facing message using the sentinels
+// in internal/errors. Anything unrecognised (including upstream credential failures, which
+// are our misconfiguration rather than the caller's) is reported as a 500.
+func errorStatus(err error) (int, string) {
+	var indexErr *apperrors.IndexError
+	switch {
+	case errors.Is(err, apperrors.ErrNotFound):
+		return http.StatusNotFound, "File not found"
+	case errors.Is(err, apperrors.ErrInvalidInput):
+		return http.StatusBadRequest, "Invalid request"
+	case errors.Is(err, apperrors.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge, "File too large"
+	case errors.Is(err, apperrors.ErrUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType, "Unsupported media type"
+	case errors.Is(err, apperrors.ErrConflict):
+		return http.StatusConflict, "Resource was modified concurrently; re-read and retry"
+	case errors.Is(err, apperrors.ErrUnprocessable):
+		return http.StatusUnprocessableEntity, "Request cannot be applied to this resource"
+	case errors.Is(err, apperrors.ErrRangeNotSatisfiable):
+		return http.StatusRequestedRangeNotSatisfiable, "Requested range not satisfiable"
+	case errors.Is(err, apperrors.ErrUnavailable):
+		return http.StatusServiceUnavailable, "Service temporarily unavailable"
+	case errors.As(err, &indexErr):
+		// Names the index so the deployment can be fixed without digging through logs
+		return http.StatusInternalServerError, "Missing Firestore index on " + indexErr.Fields + " (see README: Location Hierarchy)"
+	default:
+		return http.StatusInternalServerError, "Internal server error"
+	}
+}
+
+// Writes the error response for a service error.
+func writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	status, msg := errorStatus(err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "5")
+	}
+	writeError(w, r, status, msg)
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/middleware"
+	"trekka-api/internal/models"
+)
+
+func TestWriteServiceError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantStatus     int
+		wantCode       string
+		wantMessage    string
+		wantRetryAfter string
+	}{
+		{"not found", fmt.Errorf("get metadata: %w", apperrors.ErrNotFound), http.StatusNotFound, models.ErrorCodeNotFound, "File not found", ""},
+		{"invalid input", apperrors.ErrInvalidInput, http.StatusBadRequest, models.ErrorCodeInvalidInput, "Invalid request", ""},
+		{"too large", apperrors.ErrTooLarge, http.StatusRequestEntityTooLarge, models.ErrorCodeTooLarge, "File too large", ""},
+		{"unsupported media type", apperrors.ErrUnsupportedMediaType, http.StatusUnsupportedMediaType, models.ErrorCodeUnsupportedMediaType, "Unsupported media type", ""},
+		{"conflict", apperrors.ErrConflict, http.StatusConflict, models.ErrorCodeConflict, "Resource was modified concurrently; re-read and retry", ""},
+		{"unprocessable", apperrors.ErrUnprocessable, http.StatusUnprocessableEntity, models.ErrorCodeUnprocessable, "Request cannot be applied to this resource", ""},
+		{"range", &apperrors.RangeError{Size: 10}, http.StatusRequestedRangeNotSatisfiable, models.ErrorCodeRangeNotSatisfiable, "Requested range not satisfiable", ""},
+		{"unavailable", fmt.Errorf("list: %w", apperrors.ErrUnavailable), http.StatusServiceUnavailable, models.ErrorCodeUnavailable, "Service temporarily unavailable", "5"},
+		{"missing index", &apperrors.IndexError{Fields: "countryCode ASC, takenAt DESC"}, http.StatusInternalServerError, models.ErrorCodeInternal,
+			"Missing Firestore index on countryCode ASC, takenAt DESC (see README: Location Hierarchy)", ""},
+		{"unauthorized upstream", apperrors.ErrUnauthorized, http.StatusInternalServerError, models.ErrorCodeInternal, "Internal server error", ""},
+		{"unknown", fmt.Errorf("boom"), http.StatusInternalServerError, models.ErrorCodeInternal, "Internal server error", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/images/list", nil)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.RequestIDKey, "req-123"))
+			rec := httptest.NewRecorder()
+
+			writeServiceError(rec, req, tt.err)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+			var body models.ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode body %q: %v", rec.Body.String(), err)
+			}
+			want := models.ErrorBody{Code: tt.wantCode, Message: tt.wantMessage, RequestID: "req-123"}
+			if body.Error != want {
+				t.Errorf("error = %+v, want %+v", body.Error, want)
+			}
+		})
+	}
+}
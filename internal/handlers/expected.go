@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"trekka-api/internal/utils"
+)
+
+// Largest request body accepted by HandleExpectedFiles.
+const maxExpectedFilesBody = 5 << 20 // 5MB
+
+// HandleExpectedFiles registers filenames that are expected to arrive through sync.
+//
+//	@Summary		Register expected files
+//	@Description	Registers filenames (e.g. from a camera's DCIM index) so /admin/expected-files/report can list shots that were never ingested.
+//	@Description	Accepts a JSON array of filenames, {"fileNames": [...]}, a text/csv body, or a multipart upload in field "file".
+//	@Description	CSV input uses the first column; a "filename" header row is skipped.
+//	@Tags			admin
+//	@Accept			json
+//	@Accept			text/csv
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			fileNames	body		[]string				false	"Filenames"
+//	@Param			file		formData	file					false	"CSV file"
+//	@Success		200			{object}	map[string]int			"added and duplicates"
+//	@Failure		400			{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		413			{object}	models.ErrorResponse	"Request body too large"
+//	@Failure		415			{object}	models.ErrorResponse	"Unsupported content type"
+//	@Failure		500			{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503			{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/admin/expected-files [post]
+func (h *Handler) HandleExpectedFiles(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxExpectedFilesBody)
+	fileNames, status, err := readExpectedFileNames(r)
+	if err != nil {
+		log.Printf("[Expected] Rejected upload: %v", err)
+		writeError(w, r, status, err.Error())
+		return
+	}
+
+	added, duplicates, err := h.expectationService.AddExpected(r.Context(), fileNames)
+	if err != nil {
+		log.Printf("[Expected] Failed to register expected files: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Expected] Registered %d expected files (%d duplicates) in %v", added, duplicates, time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]int{
+		"added":      added,
+		"duplicates": duplicates,
+	}); err != nil {
+		log.Printf("[Expected] Failed to encode response: %v", err)
+	}
+}
+
+// HandleExpectedFilesReport diffs registered expectations against ingested documents.
+//
+//	@Summary		Expected files report
+//	@Description	Lists expected files that were never ingested (missing) and those ingested only after being registered (satisfiedLate).
+//	@Description	Matching is case-insensitive and treats IMG_1.HEIC as satisfied by IMG_1.jpg.
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	models.ExpectedFilesReport	"Diff of expectations against documents"
+//	@Failure		500	{object}	models.ErrorResponse		"Internal Server Error"
+//	@Failure		503	{object}	models.ErrorResponse		"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/admin/expected-files/report [get]
+func (h *Handler) HandleExpectedFilesReport(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	report, err := h.expectationService.Report(r.Context())
+	if err != nil {
+		log.Printf("[Expected] Failed to build report: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Expected] Report: %d expected, %d missing, %d late in %v",
+		report.Expected, len(report.Missing), len(report.SatisfiedLate), time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("[Expected] Failed to encode response: %v", err)
+	}
+}
+
+// Reads filenames from a JSON, CSV or multipart body. Returns the HTTP status to use on error.
+func readExpectedFileNames(r *http.Request) ([]string, int, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var (
+		fileNames []string
+		err       error
+	)
+	switch mediaType {
+	case "", "application/json":
+		fileNames, err = decodeExpectedJSON(r.Body)
+	case "text/csv", "text/plain":
+		fileNames, err = utils.ParseFileNamesCSV(r.Body)
+	case "multipart/form-data":
+		file, _, formErr := r.FormFile("file")
+		if formErr != nil {
+			return nil, bodyErrorStatus(formErr), fmt.Errorf("missing CSV file in form field \"file\": %w", formErr)
+		}
+		defer file.Close()
+		fileNames, err = utils.ParseFileNamesCSV(file)
+	default:
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q", mediaType)
+	}
+	if err != nil {
+		return nil, bodyErrorStatus(err), err
+	}
+
+	return fileNames, http.StatusOK, nil
+}
+
+// Accepts either a bare JSON array of filenames or {"fileNames": [...]}.
+func decodeExpectedJSON(body io.Reader) ([]string, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileNames []string
+	if err := json.Unmarshal(raw, &fileNames); err == nil {
+		return fileNames, nil
+	}
+
+	var wrapped struct {
+		FileNames []string `json:"fileNames"`
+	}
+	if err := json.Unmarshal(raw, &wrapped); err != nil {
+		return nil, fmt.Errorf("invalid JSON body: %w", err)
+	}
+	return wrapped.FileNames, nil
+}
+
+// Maps a body read error to 413 when the size limit was hit, 400 otherwise.
+func bodyErrorStatus(err error) int {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"trekka-api/internal/models"
+	"trekka-api/internal/utils"
+)
+
+// GeoJSON feature for a single image.
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   *geoJSONPoint     `json:"geometry"` // null for images without coordinates
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // [lng, lat] per RFC 7946
+}
+
+type geoJSONProperties struct {
+	FileName     string     `json:"fileName"`
+	TakenAt      *time.Time `json:"takenAt,omitempty"`
+	GeoLocation  string     `json:"geoLocation,omitempty"`
+	ThumbnailURL string     `json:"thumbnailUrl"`
+}
+
+// HandleImagesExport streams the whole collection in a map-friendly format.
+//
+//	@Summary		Export images as GeoJSON, KML or CSV
+//	@Description	format=geojson streams every geotagged image as a GeoJSON Point feature (for Leaflet and similar).
+//	@Description	Images without coordinates are skipped unless includeUngeotagged=true, which emits them with null geometry.
+//	@Description	format=kml produces a KML document for Google Earth with one Placemark per geotagged image, grouped into Folders by country.
+//	@Description	format=csv streams one row per image; fields selects and orders columns.
+//	@Tags			images
+//	@Produce		json
+//	@Produce		application/vnd.google-earth.kml+xml
+//	@Produce		text/csv
+//	@Param			format				query		string					true	"Export format"	Enums(geojson, kml, csv)
+//	@Param			fields				query		string					false	"CSV only: comma-separated columns (default all)"
+//	@Param			includeUngeotagged	query		bool					false	"Include images without coordinates (GeoJSON only, null geometry)"
+//	@Success		200					{object}	object					"GeoJSON FeatureCollection, KML document or CSV"
+//	@Failure		400					{object}	models.ErrorResponse	"Bad Request"
+//	@Security		ApiKeyAuth
+//	@Router			/images/export [get]
+func (h *Handler) HandleImagesExport(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get("format") {
+	case "geojson":
+		h.exportGeoJSON(w, r)
+	case "kml":
+		h.exportKML(w, r)
+	case "csv":
+		h.exportCSV(w, r)
+	default:
+		writeError(w, r, http.StatusBadRequest, "Invalid format parameter (supported: geojson, kml, csv)")
+	}
+}
+
+// Streams a GeoJSON FeatureCollection, encoding one feature per image as Firestore pages arrive.
+func (h *Handler) exportGeoJSON(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	includeUngeotagged := false
+	if v := r.URL.Query().Get("includeUngeotagged"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid includeUngeotagged parameter")
+			return
+		}
+		includeUngeotagged = parsed
+	}
+
+	thumbnailBase := requestBaseURL(r) + "/image/thumbnail?fileName="
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Cache-Control", "public, max-age=300, s-maxage=900") // 5 min client, 15 min edge
+
+	// Headers are committed once the first byte is written, so later failures can only truncate the stream
+	if _, err := w.Write([]byte(`{"type":"FeatureCollection","features":[` + "\n")); err != nil {
+		log.Printf("[Export] Failed to write response: %v", err)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	written, skipped := 0, 0
+
+	err := h.imageService.ForEachImage(r.Context(), func(img *models.ImageMetadata) error {
+		point := coordinatesToPoint(img.Coordinates)
+		if point == nil && !includeUngeotagged {
+			skipped++
+			return nil
+		}
+
+		if written > 0 {
+			if _, err := w.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+
+		feature := geoJSONFeature{
+			Type:     "Feature",
+			Geometry: point,
+			Properties: geoJSONProperties{
+				FileName:     img.FileName,
+				GeoLocation:  img.GeoLocation,
+				ThumbnailURL: thumbnailBase + url.QueryEscape(img.FileName),
+			},
+		}
+		if !img.TakenAt.IsZero() {
+			feature.Properties.TakenAt = &img.TakenAt
+		}
+		if err := encoder.Encode(feature); err != nil {
+			return err
+		}
+
+		written++
+		if flusher != nil && written%100 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[Export] Export aborted after %d features: %v", written, err)
+		return
+	}
+
+	if _, err := w.Write([]byte("]}\n")); err != nil {
+		log.Printf("[Export] Failed to write response: %v", err)
+		return
+	}
+
+	log.Printf("[Export] Streamed %d features (%d skipped) in %v", written, skipped, time.Since(start))
+}
+
+// Converts stored string coordinates to a GeoJSON point, or nil if they're missing or invalid.
+func coordinatesToPoint(c models.Coordinates) *geoJSONPoint {
+	lat, err := strconv.ParseFloat(strings.TrimSpace(c.Lat), 64)
+	if err != nil {
+		return nil
+	}
+	lng, err := strconv.ParseFloat(strings.TrimSpace(c.Lng), 64)
+	if err != nil {
+		return nil
+	}
+
+	return &geoJSONPoint{Type: "Point", Coordinates: [2]float64{lng, lat}}
+}
+
+// Reconstructs the externally visible base URL (scheme://host) of a request, honouring
+// X-Forwarded-Proto from proxies such as Vercel.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+	}
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+// CSV columns in default order, each with how to render it from an image.
+var csvColumns = []struct {
+	name  string
+	value func(img *models.ImageMetadata) string
+}{
+	{"fileName", func(img *models.ImageMetadata) string { return img.FileName }},
+	{"contentType", func(img *models.ImageMetadata) string { return img.ContentType }},
+	{"lat", func(img *models.ImageMetadata) string { return img.Coordinates.Lat }},
+	{"lng", func(img *models.ImageMetadata) string { return img.Coordinates.Lng }},
+	{"geoLocation", func(img *models.ImageMetadata) string { return img.GeoLocation }},
+	{"takenAt", func(img *models.ImageMetadata) string { return formatCSVTime(img.TakenAt) }},
+	{"createdAt", func(img *models.ImageMetadata) string { return formatCSVTime(img.CreatedAt) }},
+	{"width", func(img *models.ImageMetadata) string { return resolutionComponent(img.Resolution, 0) }},
+	{"height", func(img *models.ImageMetadata) string { return resolutionComponent(img.Resolution, 1) }},
+}
+
+// Streams the collection as CSV with a header row. encoding/csv handles quoting, so filenames
+// containing commas or quotes round-trip correctly.
+func (h *Handler) exportCSV(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	columns := csvColumns
+	if fields := r.URL.Query().Get("fields"); fields != "" {
+		columns = columns[:0:0]
+		for _, field := range strings.Split(fields, ",") {
+			field = strings.TrimSpace(field)
+			found := false
+			for _, col := range csvColumns {
+				if col.name == field {
+					columns = append(columns, col)
+					found = true
+					break
+				}
+			}
+			if !found {
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Unknown field %q", field))
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="trekka.csv"`)
+	w.Header().Set("Cache-Control", "public, max-age=300, s-maxage=900") // 5 min client, 15 min edge
+
+	cw := csv.NewWriter(w)
+	row := make([]string, len(columns))
+
+	for i, col := range columns {
+		row[i] = col.name
+	}
+	if err := cw.Write(row); err != nil {
+		log.Printf("[Export] Failed to write response: %v", err)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	written := 0
+	err := h.imageService.ForEachImage(r.Context(), func(img *models.ImageMetadata) error {
+		for i, col := range columns {
+			row[i] = col.value(img)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+
+		written++
+		if written%500 == 0 {
+			cw.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return cw.Error()
+	})
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	if err != nil {
+		log.Printf("[Export] CSV export aborted after %d rows: %v", written, err)
+		return
+	}
+
+	log.Printf("[Export] Streamed %d CSV rows in %v", written, time.Since(start))
+}
+
+// Formats a timestamp as RFC3339 in UTC, or "" if unset.
+func formatCSVTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Returns one component of a [width, height] resolution, or "" if unknown.
+func resolutionComponent(resolution []float64, i int) string {
+	if len(resolution) != 2 {
+		return ""
+	}
+	return strconv.FormatFloat(resolution[i], 'f', -1, 64)
+}
+
+// Minimal per-image data kept while grouping KML placemarks by country.
+type kmlRecord struct {
+	fileName    string
+	storagePath string
+	contentType string
+	geoLocation string
+	lat, lng    float64
+	takenAt     time.Time
+}
+
+type kmlPlacemark struct {
+	XMLName     xml.Name      `xml:"Placemark"`
+	Name        string        `xml:"name"`
+	TimeStamp   *kmlTimeStamp `xml:"TimeStamp,omitempty"`
+	Description kmlCDATA      `xml:"description"`
+	Point       kmlPoint      `xml:"Point"`
+}
+
+type kmlTimeStamp struct {
+	When string `xml:"when"`
+}
+
+type kmlCDATA struct {
+	Text string `xml:",cdata"`
+}
+
+type kmlPoint struct {
+	Coordinates string `xml:"coordinates"` // "lng,lat"
+}
+
+// Writes a KML document with one Placemark per geotagged image, grouped into a Folder per country.
+// Folders need every image of a country together, so a compact record per geotagged image is
+// collected in one cursor-paged pass; the XML itself is streamed with encoding/xml and signed
+// URLs are generated as each placemark is written rather than held in memory.
+func (h *Handler) exportKML(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ctx := r.Context()
+
+	byCountry := make(map[string][]kmlRecord)
+	err := h.imageService.ForEachImage(ctx, func(img *models.ImageMetadata) error {
+		point := coordinatesToPoint(img.Coordinates)
+		if point == nil {
+			return nil
+		}
+
+		country := utils.CountryFromGeoLocation(img.GeoLocation)
+		if country == "" {
+			country = "Unknown"
+		}
+		byCountry[country] = append(byCountry[country], kmlRecord{
+			fileName:    img.FileName,
+			storagePath: img.StoragePath,
+			contentType: img.ContentType,
+			geoLocation: img.GeoLocation,
+			lng:         point.Coordinates[0],
+			lat:         point.Coordinates[1],
+			takenAt:     img.TakenAt,
+		})
+		return nil
+	})
+	if err != nil {
+		log.Printf("[Export] Failed to collect images for KML: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	countries := make([]string, 0, len(byCountry))
+	for country := range byCountry {
+		countries = append(countries, country)
+	}
+	sort.Strings(countries)
+
+	w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="trekka.kml"`)
+	// Signed URLs inside expire after 15 minutes, so don't let caches hold the document longer
+	w.Header().Set("Cache-Control", "private, max-age=600")
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		log.Printf("[Export] Failed to write response: %v", err)
+		return
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	written := 0
+	err = func() error {
+		kml := xml.StartElement{Name: xml.Name{Local: "kml"}, Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: "http://www.opengis.net/kml/2.2"}}}
+		document := xml.StartElement{Name: xml.Name{Local: "Document"}}
+		folder := xml.StartElement{Name: xml.Name{Local: "Folder"}}
+
+		if err := enc.EncodeToken(kml); err != nil {
+			return err
+		}
+		if err := enc.EncodeToken(document); err != nil {
+			return err
+		}
+		if err := enc.EncodeElement("Trekka", xml.StartElement{Name: xml.Name{Local: "name"}}); err != nil {
+			return err
+		}
+
+		for _, country := range countries {
+			if err := enc.EncodeToken(folder); err != nil {
+				return err
+			}
+			if err := enc.EncodeElement(country, xml.StartElement{Name: xml.Name{Local: "name"}}); err != nil {
+				return err
+			}
+
+			for _, rec := range byCountry[country] {
+				if err := enc.Encode(h.kmlPlacemark(ctx, rec)); err != nil {
+					return err
+				}
+				written++
+			}
+
+			if err := enc.EncodeToken(folder.End()); err != nil {
+				return err
+			}
+			// Free each country's records once written
+			delete(byCountry, country)
+		}
+
+		if err := enc.EncodeToken(document.End()); err != nil {
+			return err
+		}
+		if err := enc.EncodeToken(kml.End()); err != nil {
+			return err
+		}
+		return enc.Flush()
+	}()
+	if err != nil {
+		log.Printf("[Export] KML export aborted after %d placemarks: %v", written, err)
+		return
+	}
+
+	log.Printf("[Export] Streamed %d KML placemarks in %d folders in %v", written, len(countries), time.Since(start))
+}
+
+// Builds a placemark for one image, embedding a signed image URL in the description.
+func (h *Handler) kmlPlacemark(ctx context.Context, rec kmlRecord) kmlPlacemark {
+	placemark := kmlPlacemark{
+		Name:  rec.fileName,
+		Point: kmlPoint{Coordinates: strconv.FormatFloat(rec.lng, 'f', -1, 64) + "," + strconv.FormatFloat(rec.lat, 'f', -1, 64)},
+	}
+	if !rec.takenAt.IsZero() {
+		placemark.TimeStamp = &kmlTimeStamp{When: rec.takenAt.UTC().Format(time.RFC3339)}
+	}
+
+	var description strings.Builder
+	if strings.HasPrefix(rec.contentType, "image/") {
+		signedURL, err := h.imageService.SignedURL(ctx, &models.ImageMetadata{StoragePath: rec.storagePath})
+		if err != nil {
+			log.Printf("[Export] Failed to sign URL for %s: %v", rec.fileName, err)
+		} else {
+			fmt.Fprintf(&description, `<img src="%s" width="400"/><br/>`, html.EscapeString(signedURL))
+		}
+	}
+	description.WriteString(html.EscapeString(rec.geoLocation))
+	placemark.Description = kmlCDATA{Text: description.String()}
+
+	return placemark
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/services"
+	"trekka-api/internal/utils"
+)
+
+// Largest GPX upload accepted by HandleGeotag.
+const maxGPXBody = 20 << 20 // 20MB
+
+// HandleGeotag geotags photos without GPS from an uploaded GPX track.
+//
+//	@Summary		Geotag from GPX
+//	@Description	Interpolates a position for every photo taken during the track that has no coordinates, from the trackpoints either side of it,
+//	@Description	then stores it with coordinatesSource "gpx" and reverse-geocodes it. Photos in a gap between trackpoints longer than maxGap are left alone.
+//	@Description	Accepts the GPX as the request body or as a multipart upload in field "file".
+//	@Tags			admin
+//	@Accept			xml
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			offset	query		string					false	"Camera clock correction added to takenAt (e.g. 2m, -30s)"	default(0s)
+//	@Param			maxGap	query		string					false	"Longest gap between trackpoints to interpolate across"		default(5m)
+//	@Param			dryRun	query		bool					false	"Report matches without writing them"
+//	@Param			file	formData	file					false	"GPX file"
+//	@Success		200		{object}	models.GeotagReport		"Matches"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		413		{object}	models.ErrorResponse	"Request body too large"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/admin/geotag [post]
+func (h *Handler) HandleGeotag(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	opts, err := parseGeotagOptions(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxGPXBody)
+	var body io.Reader = r.Body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, r, bodyErrorStatus(err), fmt.Sprintf("missing GPX file in form field \"file\": %v", err))
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	track, err := utils.ParseGPX(body)
+	if err != nil {
+		log.Printf("[Geotag] Rejected upload: %v", err)
+		writeError(w, r, bodyErrorStatus(err), err.Error())
+		return
+	}
+
+	report, err := h.geotagService.GeotagFromTrack(r.Context(), track, opts)
+	if err != nil {
+		log.Printf("[Geotag] Failed to geotag from track: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Geotag] Processed %d trackpoints in %v", report.TrackPoints, time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("[Geotag] Failed to encode response: %v", err)
+	}
+}
+
+// Reads offset, maxGap and dryRun from the query string.
+func parseGeotagOptions(r *http.Request) (services.GeotagOptions, error) {
+	query := r.URL.Query()
+	opts := services.GeotagOptions{MaxGap: 5 * time.Minute}
+
+	if v := query.Get("offset"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid offset: %v", err)
+		}
+		opts.Offset = d
+	}
+	if v := query.Get("maxGap"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return opts, fmt.Errorf("invalid maxGap %q", v)
+		}
+		opts.MaxGap = d
+	}
+	if v := query.Get("dryRun"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid dryRun %q", v)
+		}
+		opts.DryRun = dryRun
+	}
+
+	return opts, nil
+}
+
+// HandleImageRegeocode refreshes an image's location from its stored coordinates.
+//
+//	@Summary		Re-geocode an image
+//	@Description	Reverse geocodes the image's stored coordinates again, bypassing the geocoder's cache, and replaces geoLocation and the
+//	@Description	city/region/country fields with the result. Use it after OpenStreetMap data has improved. Legacy IDs are accepted.
+//	@Tags			images
+//	@Produce		json
+//	@Param			id	path		string					true	"Image document ID"
+//	@Success		200	{object}	models.RegeocodeResult	"New location"
+//	@Failure		404	{object}	models.ErrorResponse	"Image not found"
+//	@Failure		409	{object}	models.ErrorResponse	"Image modified concurrently"
+//	@Failure		422	{object}	models.ErrorResponse	"Image has no coordinates, or no place was found at them"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503	{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/{id}/regeocode [post]
+func (h *Handler) HandleImageRegeocode(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	result, err := h.geotagService.Regeocode(r.Context(), id)
+	if err != nil {
+		log.Printf("[Geotag] Failed to re-geocode %s: %v", id, err)
+		switch {
+		case errors.Is(err, apperrors.ErrNotFound):
+			writeError(w, r, http.StatusNotFound, "Image not found")
+		case errors.Is(err, apperrors.ErrUnprocessable):
+			writeError(w, r, http.StatusUnprocessableEntity, err.Error())
+		default:
+			writeServiceError(w, r, err)
+		}
+		return
+	}
+
+	h.imageService.EvictImage(id, result.Id, result.FileName)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("[Geotag] Failed to encode response: %v", err)
+	}
+}
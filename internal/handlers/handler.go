@@ -3,11 +3,50 @@ package handlers
 import "trekka-api/internal/services"
 
 type Handler struct {
-	imageService *services.ImageService
+	imageService       *services.ImageService
+	expectationService *services.ExpectationService
+	syncMetrics        *services.SyncMetrics
+	cacheService       *services.CacheService
+	geotagService      *services.GeotagService
+	quarantineService  *services.QuarantineService
+	tripService        *services.TripService
+	reconcileService   *services.ReconcileService
+	healthService      *services.HealthService
+	readiness          *services.Readiness
+	driveService       *services.DriveService // May be nil if Drive sync is disabled
+	publicMode         bool                   // Anonymous callers may read public images (see middleware.IsAnonymous)
 }
 
-func New(imageService *services.ImageService) *Handler {
+func New(
+	imageService *services.ImageService,
+	expectationService *services.ExpectationService,
+	syncMetrics *services.SyncMetrics,
+	cacheService *services.CacheService,
+	geotagService *services.GeotagService,
+	quarantineService *services.QuarantineService,
+	tripService *services.TripService,
+	reconcileService *services.ReconcileService,
+	healthService *services.HealthService,
+	readiness *services.Readiness,
+	driveService *services.DriveService,
+) *Handler {
 	return &Handler{
-		imageService: imageService,
+		imageService:       imageService,
+		expectationService: expectationService,
+		syncMetrics:        syncMetrics,
+		cacheService:       cacheService,
+		geotagService:      geotagService,
+		quarantineService:  quarantineService,
+		tripService:        tripService,
+		reconcileService:   reconcileService,
+		healthService:      healthService,
+		readiness:          readiness,
+		driveService:       driveService,
 	}
 }
+
+// Marks the server as running in public mode, so responses that differ by caller are kept out of
+// shared caches.
+func (h *Handler) EnablePublicMode() {
+	h.publicMode = true
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+
+	"trekka-api/internal/models"
 )
 
 // HandleHealth responds to health check requests.
@@ -13,13 +15,82 @@ import (
 //	@Tags			health
 //	@Accept			json
 //	@Produce		json
-//	@Success		200	{object}	map[string]string	"status: ok"
+//	@Success		200	{object}	map[string]string	"status: ok, or degraded while serving stale cache during a Firestore outage"
 //	@Router			/health [get]
 func (h *Handler) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	status := "ok"
+	if h.imageService.Degraded() {
+		status = "degraded"
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]string{
-		"status": "ok",
+		"status": status,
 	}); err != nil {
 		log.Printf("[Health] Failed to encode response: %v", err)
 	}
 }
+
+// HandleHealthDeep probes Firestore, Storage and (when sync is enabled) Drive.
+//
+//	@Summary		Deep health check
+//	@Description	Runs a cheap read against each backing service, each with its own 2s timeout, and reports per-dependency status and latency.
+//	@Description	Responds 503 if any dependency fails. Results are reused for 5 seconds.
+//	@Tags			health
+//	@Produce		json
+//	@Success		200	{object}	models.HealthReport	"Every dependency is reachable"
+//	@Failure		503	{object}	models.HealthReport	"At least one dependency failed"
+//	@Security		ApiKeyAuth
+//	@Router			/health/deep [get]
+func (h *Handler) HandleHealthDeep(w http.ResponseWriter, r *http.Request) {
+	report := h.healthService.Check(r.Context())
+
+	status := http.StatusOK
+	if report.Status != models.HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("[Health] Failed to encode response: %v", err)
+	}
+}
+
+// HandleLiveness reports that the process is up. It touches no dependencies, so it stays cheap
+// enough for frequent liveness probes; it is a plain function so it can be served before the
+// services exist (see api/index.go).
+//
+//	@Summary		Liveness probe
+//	@Description	Always 200 while the process can serve HTTP. Does not check dependencies; use /readyz for that.
+//	@Tags			health
+//	@Produce		plain
+//	@Success		200	{string}	string	"ok"
+//	@Router			/healthz [get]
+func HandleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
+
+// HandleReadiness reports whether the server should receive traffic.
+//
+//	@Summary		Readiness probe
+//	@Description	503 until services are initialized, and while Firestore can't be read. The Firestore probe result is reused for 5 seconds.
+//	@Tags			health
+//	@Produce		plain
+//	@Success		200	{string}	string					"ready"
+//	@Failure		503	{object}	models.ErrorResponse	"Not ready, with the reason"
+//	@Router			/readyz [get]
+func (h *Handler) HandleReadiness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := h.readiness.Check(r.Context()); err != nil {
+		log.Printf("[Health] Not ready: %v", err)
+		writeError(w, r, http.StatusServiceUnavailable, "not ready: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ready\n"))
+}
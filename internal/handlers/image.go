@@ -3,112 +3,373 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"cloud.google.com/go/storage"
+
 	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/middleware"
 	"trekka-api/internal/models"
+	"trekka-api/internal/services"
+	"trekka-api/internal/utils"
 )
 
 // HandleImage retrieves and serves images from Firebase Storage with caching.
 //
 //	@Summary		Get an image
-//	@Description	Retrieve an image from Firebase Storage by filename
+//	@Description	Retrieve an image from Firebase Storage by filename.
+//	@Description	Redirects to a signed URL by default; mode=proxy streams the bytes through the API instead.
+//	@Description	With PUBLIC_MODE, requests without an API key only find images whose visibility is public, and can't use mode=proxy.
 //	@Tags			images
 //	@Accept			json
 //	@Produce		json
-//	@Param			fileName	query		string	true	"Image filename"
-//	@Success		302			{string}	string	"Redirect to signed URL"
-//	@Failure		400			{string}	string	"Bad Request"
-//	@Failure		404			{string}	string	"Not Found"
-//	@Failure		500			{string}	string	"Internal Server Error"
+//	@Param			fileName	query		string					true	"Image filename"
+//	@Param			mode		query		string					false	"Delivery mode"	Enums(redirect, proxy)	default(redirect)
+//	@Param			download	query		bool					false	"Have the browser save the file under its name (Content-Disposition: attachment)"
+//	@Param			Range		header		string					false	"Single byte range, honoured with mode=proxy (e.g. bytes=1000-)"
+//	@Success		200			{file}		binary					"Image bytes (mode=proxy)"
+//	@Success		206			{file}		binary					"Partial content for a Range request (mode=proxy)"
+//	@Success		302			{string}	string					"Redirect to signed URL"
+//	@Header			302			{string}	X-Data-Staleness		"Age in seconds of cached data served during a Firestore outage"
+//	@Failure		400			{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		404			{object}	models.ErrorResponse	"Not Found"
+//	@Failure		413			{object}	models.ErrorResponse	"Object exceeds proxy size limit"
+//	@Failure		416			{object}	models.ErrorResponse	"Range Not Satisfiable"
+//	@Failure		500			{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503			{object}	models.ErrorResponse	"Upstream Unavailable"
 //	@Security		ApiKeyAuth
 //	@Router			/image [get]
+//	@Router			/image [head]
 func (h *Handler) HandleImage(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 
-	// Only allow GET requests
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	fileName, msg := fileNameParam(r)
+	if msg != "" {
+		writeError(w, r, http.StatusBadRequest, msg)
 		return
 	}
 
-	query := r.URL.Query()
-	fileName := strings.TrimSpace(query.Get("fileName"))
+	anonymous := middleware.IsAnonymous(r.Context())
 
-	// Validate fileName parameter
-	if fileName == "" {
-		http.Error(w, "Missing fileName parameter", http.StatusBadRequest)
-		return
+	download := false
+	if v := r.URL.Query().Get("download"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid download parameter")
+			return
+		}
+		download = parsed
 	}
 
-	// Security: Prevent path traversal attacks
-	if strings.Contains(fileName, "..") || strings.Contains(fileName, "/") || strings.Contains(fileName, "\\") {
-		log.Printf("[Image] Security: Rejected suspicious fileName: %s", fileName)
-		http.Error(w, "Invalid fileName", http.StatusBadRequest)
+	switch r.URL.Query().Get("mode") {
+	case "", "redirect":
+	case "proxy":
+		if anonymous {
+			writeError(w, r, http.StatusUnauthorized, "Unauthorized: mode=proxy requires an API key")
+			return
+		}
+		h.proxyImage(w, r, fileName, download, start)
 		return
-	}
-
-	// Validate fileName length
-	if len(fileName) > 255 {
-		http.Error(w, "fileName too long", http.StatusBadRequest)
+	default:
+		writeError(w, r, http.StatusBadRequest, "Invalid mode parameter")
 		return
 	}
 
 	req := models.ImageRequest{
-		FileName: fileName,
+		FileName:   fileName,
+		PublicOnly: anonymous,
+		Download:   download,
 	}
 
-	signedURL, contentType, geoLocation, err := h.imageService.GetImage(r.Context(), req)
+	signedURL, contentType, geoLocation, staleness, err := h.imageService.GetImage(r.Context(), req)
 	if err != nil {
 		log.Printf("[Image] Failed to get image %s: %v", fileName, err)
-		// Check if it's a "not found" error vs infrastructure error
-		if errors.Is(err, apperrors.ErrNotFound) {
-			http.Error(w, "File not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-		}
+		writeServiceError(w, r, err)
 		return
 	}
 
 	log.Printf("[Image] Redirecting to signed URL for %s (%s at %s) in %v", fileName, contentType, geoLocation, time.Since(start))
 
 	// Set metadata headers before redirect
-	w.Header().Set("Cache-Control", "public, max-age=900, s-maxage=900") // 15 min
-	w.Header().Set("CDN-Cache-Control", "public, max-age=86400")         // Vercel edge: 24hr
+	if h.publicMode && !anonymous {
+		// May be a private image; keep it out of shared caches that could serve it to anonymous callers
+		w.Header().Set("Cache-Control", "private, max-age=900")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=900, s-maxage=900") // 15 min
+		w.Header().Set("CDN-Cache-Control", "public, max-age=86400")         // Vercel edge: 24hr
+	}
+	setStaleness(w, staleness)
+	writeImageRedirect(w, r, signedURL, contentType, geoLocation)
+}
+
+// HandleImageURLs resolves signed URLs for a batch of images in one request.
+//
+//	@Summary		Batch signed URLs
+//	@Description	Resolve signed URLs for up to 100 filenames at once. Each filename maps to its signed URL, content type and location,
+//	@Description	or to an entry with only "error" set when the file is missing or could not be signed.
+//	@Tags			images
+//	@Accept			json
+//	@Produce		json
+//	@Param			fileNames	body		[]string							true	"Filenames (max 100)"
+//	@Success		200			{object}	map[string]models.SignedImageURL	"Signed URL per filename"
+//	@Failure		400			{object}	models.ErrorResponse				"Bad Request"
+//	@Failure		500			{object}	models.ErrorResponse				"Internal Server Error"
+//	@Failure		503			{object}	models.ErrorResponse				"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/urls [post]
+func (h *Handler) HandleImageURLs(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	var fileNames []string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&fileNames); err != nil {
+		writeError(w, r, bodyErrorStatus(err), "Body must be a JSON array of filenames")
+		return
+	}
+	if len(fileNames) == 0 {
+		writeError(w, r, http.StatusBadRequest, "No filenames given")
+		return
+	}
+
+	urls, err := h.imageService.SignedURLs(r.Context(), fileNames)
+	if err != nil {
+		log.Printf("[Image] Failed to resolve batch of %d URLs: %v", len(fileNames), err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Image] Resolved batch of %d URLs in %v", len(urls), time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(urls); err != nil {
+		log.Printf("[Image] Failed to encode response: %v", err)
+	}
+}
+
+// HandleRandomImage redirects to a uniformly random image, like /image.
+//
+//	@Summary		Get a random image
+//	@Description	Redirect to the signed URL of a random image, with the same metadata headers as /image.
+//	@Description	country and year narrow the pool.
+//	@Tags			images
+//	@Param			country	query		string					false	"Country name as it appears in geoLocation (case-insensitive)"
+//	@Param			year	query		int						false	"Year the photo was taken"
+//	@Success		302		{string}	string					"Redirect to signed URL"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		404		{object}	models.ErrorResponse	"No images match the filters"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Security		ApiKeyAuth
+//	@Router			/image/random [get]
+//	@Router			/image/random [head]
+func (h *Handler) HandleRandomImage(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	query := r.URL.Query()
+	year := 0
+	if yearStr := query.Get("year"); yearStr != "" {
+		parsedYear, err := strconv.Atoi(yearStr)
+		if err != nil || parsedYear <= 0 {
+			writeError(w, r, http.StatusBadRequest, "Invalid year parameter")
+			return
+		}
+		year = parsedYear
+	}
+
+	id, err := h.imageService.RandomImageID(r.Context(), query.Get("country"), year)
+	if err != nil {
+		log.Printf("[Image] Failed to pick random image: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	signedURL, contentType, geoLocation, staleness, err := h.imageService.GetImage(r.Context(), models.ImageRequest{Id: id})
+	if err != nil {
+		log.Printf("[Image] Failed to get random image %s: %v", id, err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Image] Redirecting to random image %s (%s at %s) in %v", id, contentType, geoLocation, time.Since(start))
+
+	// Every request should pick again, so the redirect itself must not be cached
+	w.Header().Set("Cache-Control", "no-store")
+	setStaleness(w, staleness)
+	writeImageRedirect(w, r, signedURL, contentType, geoLocation)
+}
+
+// Writes the metadata headers and 302 to a signed URL shared by /image and /image/random.
+func writeImageRedirect(w http.ResponseWriter, r *http.Request, signedURL, contentType, geoLocation string) {
 	w.Header().Set("X-Geo-Location", geoLocation)
 	w.Header().Set("X-Content-Type", contentType)
 
+	// HEAD is an existence check: same headers, no redirect body
+	if r.Method == http.MethodHead {
+		w.Header().Set("Location", signedURL)
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+
 	// Redirect to GCS signed URL for direct download
 	http.Redirect(w, r, signedURL, http.StatusFound)
 }
 
+// Streams an image from GCS through the server for clients that can't follow the redirect.
+// A single Range header (e.g. video seeking) is served as 206 via a GCS range reader; multi-range
+// requests and ranges past the end get 416 with the object's size in Content-Range. The GCS reader is tied to the request context, so a client disconnect aborts
+// the copy and the deferred Close releases it.
+func (h *Handler) proxyImage(w http.ResponseWriter, r *http.Request, fileName string, download bool, start time.Time) {
+	var (
+		reader   *storage.Reader
+		metadata *models.ImageMetadata
+		err      error
+	)
+
+	partial := false
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		offset, length, rangeErr := parseByteRange(rangeHeader)
+		switch {
+		case errors.Is(rangeErr, errMultiRange):
+			size, err := h.imageService.ImageSize(r.Context(), fileName)
+			if err != nil {
+				log.Printf("[Image] Failed to open image %s for proxying: %v", fileName, err)
+				writeServiceError(w, r, err)
+				return
+			}
+			setUnsatisfiedRange(w, size)
+			writeError(w, r, http.StatusRequestedRangeNotSatisfiable, "Multiple ranges are not supported")
+			return
+		case rangeErr == nil:
+			partial = true
+			reader, metadata, err = h.imageService.OpenImageRange(r.Context(), fileName, offset, length)
+		}
+		// Malformed Range headers are ignored and the full object is served
+	}
+	if !partial {
+		reader, metadata, err = h.imageService.OpenImage(r.Context(), fileName)
+	}
+	if err != nil {
+		log.Printf("[Image] Failed to open image %s for proxying: %v", fileName, err)
+		var rangeErr *apperrors.RangeError
+		if errors.As(err, &rangeErr) {
+			setUnsatisfiedRange(w, rangeErr.Size)
+		}
+		writeServiceError(w, r, err)
+		return
+	}
+	defer reader.Close()
+
+	contentType := reader.Attrs.ContentType
+	if contentType == "" {
+		contentType = metadata.ContentType
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Cache-Control", "public, max-age=3600, s-maxage=86400") // 1 hr client, 24 hr edge
+	w.Header().Set("X-Geo-Location", metadata.GeoLocation)
+	if download {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": metadata.FileName}))
+	}
+
+	status := http.StatusOK
+	if partial {
+		status = http.StatusPartialContent
+		rangeStart := reader.Attrs.StartOffset
+		rangeEnd := rangeStart + reader.Remain() - 1
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rangeStart, rangeEnd, reader.Attrs.Size))
+		w.Header().Set("Content-Length", strconv.FormatInt(reader.Remain(), 10))
+	} else if reader.Attrs.ContentEncoding == "" && reader.Attrs.Size >= 0 {
+		// Decompressive transcoding changes the body length, so only advertise it for raw objects
+		w.Header().Set("Content-Length", strconv.FormatInt(reader.Attrs.Size, 10))
+	}
+
+	w.WriteHeader(status)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	written, err := io.Copy(w, reader)
+	if err != nil {
+		log.Printf("[Image] Proxy of %s aborted after %d bytes: %v", fileName, written, err)
+		return
+	}
+
+	log.Printf("[Image] Proxied %s (%s, %d bytes, status %d) in %v", fileName, contentType, written, status, time.Since(start))
+}
+
+// HandleReportBrokenURL lets a client report that a signed URL from /image failed to load.
+//
+//	@Summary		Report a broken signed URL
+//	@Description	Probes the cached signed URLs for the file against GCS, evicts any that are rejected (403/404) and signs a fresh one
+//	@Description	from the current storage path, so the next /image request redirects somewhere that works. A URL that still works is kept.
+//	@Description	Rate limited per client more strictly than other endpoints.
+//	@Tags			images
+//	@Produce		json
+//	@Param			fileName	query		string					true	"Image filename"
+//	@Success		200			{object}	models.URLReport		"Outcome of the check"
+//	@Failure		400			{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		404			{object}	models.ErrorResponse	"Not Found"
+//	@Failure		429			{object}	models.ErrorResponse	"Rate limit exceeded"
+//	@Failure		500			{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503			{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/image/report-broken [post]
+func (h *Handler) HandleReportBrokenURL(w http.ResponseWriter, r *http.Request) {
+	fileName, msg := fileNameParam(r)
+	if msg != "" {
+		writeError(w, r, http.StatusBadRequest, msg)
+		return
+	}
+
+	report, err := h.imageService.ReportBrokenURL(r.Context(), fileName)
+	if err != nil {
+		log.Printf("[Image] Failed to handle broken URL report for %s: %v", fileName, err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("[Image] Failed to encode response: %v", err)
+	}
+}
+
 // HandleImagesList retrieves a paginated list of images with metadata.
 //
 //	@Summary		List images
-//	@Description	Get a paginated list of images with metadata from Firestore
+//	@Description	Get a paginated list of images with metadata from Firestore.
+//	@Description	With PUBLIC_MODE, requests without an API key only list images whose visibility is public.
 //	@Tags			images
 //	@Accept			json
 //	@Produce		json
-//	@Param			limit	query		int								false	"Number of items to return (max 1000, default 1000)"	default(1000)
-//	@Param			page	query		int								false	"Page number (0-indexed, default 0)"				default(0)
-//	@Success		200		{array}		models.ImageMetadata			"List of images"
-//	@Failure		400		{string}	string							"Bad Request"
-//	@Failure		500		{string}	string							"Internal Server Error"
+//	@Param			limit			query		int						false	"Number of items to return (max 1000, default 1000)"	default(1000)
+//	@Param			page			query		int						false	"Page number (0-indexed, default 0)"					default(0)
+//	@Param			country			query		string					false	"Country code (2 letters) or name"
+//	@Param			region			query		string					false	"Region within the country (requires country)"
+//	@Param			city			query		string					false	"City within the region (requires country)"
+//	@Param			favorite		query		bool					false	"Only favorites (true); false applies no filter"
+//	@Param			hasDescription	query		bool					false	"Only images with a description (true); false applies no filter"
+//	@Success		200				{array}		models.ImageMetadata	"List of images"
+//	@Header			200				{string}	X-Data-Staleness		"Age in seconds of cached data served during a Firestore outage"
+//	@Failure		400				{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		500				{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503				{object}	models.ErrorResponse	"Upstream Unavailable"
 //	@Security		ApiKeyAuth
 //	@Router			/images/list [get]
+//	@Router			/images/list [head]
 func (h *Handler) HandleImagesList(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 
-	// Only allow GET requests
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	query := r.URL.Query()
 
 	// Parse and validate limit parameter
@@ -116,7 +377,7 @@ func (h *Handler) HandleImagesList(w http.ResponseWriter, r *http.Request) {
 	if limitStr := query.Get("limit"); limitStr != "" {
 		parsedLimit, err := strconv.Atoi(limitStr)
 		if err != nil || parsedLimit < 0 {
-			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, "Invalid limit parameter")
 			return
 		}
 		limit = parsedLimit
@@ -127,25 +388,1036 @@ func (h *Handler) HandleImagesList(w http.ResponseWriter, r *http.Request) {
 	if pageStr := query.Get("page"); pageStr != "" {
 		parsedPage, err := strconv.Atoi(pageStr)
 		if err != nil || parsedPage < 0 {
-			http.Error(w, "Invalid page parameter", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, "Invalid page parameter")
 			return
 		}
 		page = parsedPage
 	}
 
-	images, err := h.imageService.ListImages(r.Context(), limit, page)
+	filter := models.ImageFilter{
+		Country: strings.TrimSpace(query.Get("country")),
+		Region:  strings.TrimSpace(query.Get("region")),
+		City:    strings.TrimSpace(query.Get("city")),
+	}
+	if filter.Country == "" && (filter.Region != "" || filter.City != "") {
+		writeError(w, r, http.StatusBadRequest, "region and city filters require country")
+		return
+	}
+	if favoriteStr := query.Get("favorite"); favoriteStr != "" {
+		favorite, err := strconv.ParseBool(favoriteStr)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid favorite parameter")
+			return
+		}
+		filter.Favorite = favorite
+	}
+	if hasDescriptionStr := query.Get("hasDescription"); hasDescriptionStr != "" {
+		hasDescription, err := strconv.ParseBool(hasDescriptionStr)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid hasDescription parameter")
+			return
+		}
+		filter.HasDescription = hasDescription
+	}
+	// Anonymous callers only ever get public images, filtered by the Firestore query itself
+	anonymous := middleware.IsAnonymous(r.Context())
+	filter.PublicOnly = anonymous
+
+	var (
+		images    []*models.ImageMetadata
+		staleness time.Duration
+		err       error
+	)
+	if filter != (models.ImageFilter{}) {
+		images, err = h.imageService.ListImagesFiltered(r.Context(), filter, limit, page)
+	} else {
+		images, staleness, err = h.imageService.ListImages(r.Context(), limit, page)
+	}
 	if err != nil {
 		log.Printf("[Images] Failed to list images: %v", err)
-		http.Error(w, "Failed to retrieve images", http.StatusInternalServerError)
+		writeServiceError(w, r, err)
 		return
 	}
 
 	log.Printf("[Images] Served %d images (limit=%d, page=%d) in %v", len(images), limit, page, time.Since(start))
 
+	body, err := json.Marshal(images)
+	if err != nil {
+		log.Printf("[Images] Failed to encode response: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to encode images")
+		return
+	}
+	body = append(body, '\n')
+
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "public, max-age=60, s-maxage=300") // 1 min client, 5 min edge
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("ETag", etag(body))
+	if h.publicMode && !anonymous {
+		// Includes private images; keep it out of shared caches that could serve it to anonymous callers
+		w.Header().Set("Cache-Control", "private, max-age=60")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=60, s-maxage=300") // 1 min client, 5 min edge
+	}
+	setStaleness(w, staleness)
+
+	// HEAD gets the headers only
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if _, err := w.Write(body); err != nil {
+		log.Printf("[Images] Failed to write response: %v", err)
+	}
+}
+
+// HandleImagesPlaces lists distinct places (images grouped by snapped coordinates) with counts.
+//
+//	@Summary		List places
+//	@Description	Group geotagged images into ~100m places with a photo count, representative photo, and resolved location
+//	@Tags			images
+//	@Produce		json
+//	@Success		200	{array}		models.Place			"List of places, most photos first"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503	{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/places [get]
+func (h *Handler) HandleImagesPlaces(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	places, err := h.imageService.ListPlaces(r.Context())
+	if err != nil {
+		log.Printf("[Places] Failed to list places: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Places] Served %d places in %v", len(places), time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300, s-maxage=900") // 5 min client, 15 min edge
+	if err := json.NewEncoder(w).Encode(places); err != nil {
+		log.Printf("[Places] Failed to encode response: %v", err)
+	}
+}
+
+// HandleImagesNear lists images taken within a radius of a point, nearest first.
+//
+//	@Summary		Images near a point
+//	@Description	Images taken within radius kilometres of lat/lng, sorted by distance, each with distanceMeters.
+//	@Description	Only images with a geohash (set on sync, or backfilled with -geohash) are searched.
+//	@Tags			images
+//	@Produce		json
+//	@Param			lat		query		number					true	"Latitude in degrees"
+//	@Param			lng		query		number					true	"Longitude in degrees"
+//	@Param			radius	query		number					false	"Radius in kilometres (default 5, max NEAR_MAX_RADIUS_KM)"	default(5)
+//	@Param			limit	query		int						false	"Maximum number of results (max 1000, default 100)"			default(100)
+//	@Success		200		{array}		models.NearbyImage		"Nearby images"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/near [get]
+func (h *Handler) HandleImagesNear(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	query := r.URL.Query()
+	lat, err := strconv.ParseFloat(query.Get("lat"), 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid lat parameter")
+		return
+	}
+	lng, err := strconv.ParseFloat(query.Get("lng"), 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid lng parameter")
+		return
+	}
+
+	radiusKm := 5.0
+	if radiusStr := query.Get("radius"); radiusStr != "" {
+		radiusKm, err = strconv.ParseFloat(radiusStr, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid radius parameter")
+			return
+		}
+	}
+
+	limit := 100
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err != nil || parsedLimit <= 0 || parsedLimit > 1000 {
+			writeError(w, r, http.StatusBadRequest, "Invalid limit parameter")
+			return
+		}
+		limit = parsedLimit
+	}
+
+	images, err := h.imageService.NearbyImages(r.Context(), lat, lng, radiusKm*1000, limit)
+	if err != nil {
+		log.Printf("[Near] Failed to find images near %f,%f: %v", lat, lng, err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Near] Served %d images within %gkm of %f,%f in %v", len(images), radiusKm, lat, lng, time.Since(start))
 
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=60, s-maxage=300") // 1 min client, 5 min edge
 	if err := json.NewEncoder(w).Encode(images); err != nil {
-		log.Printf("[Images] Failed to encode response: %v", err)
+		log.Printf("[Near] Failed to encode response: %v", err)
+	}
+}
+
+// HandleImagesClusters groups geotagged images in a map viewport into clusters for the zoom level.
+//
+//	@Summary		Map clusters
+//	@Description	Clusters of geotagged images inside bbox, sized for the map zoom level, each with a centroid, count and representative fileName.
+//	@Description	A bbox whose minLng is greater than its maxLng crosses the antimeridian. Results are cached for 30 seconds.
+//	@Tags			images
+//	@Produce		json
+//	@Param			bbox	query		string					true	"minLng,minLat,maxLng,maxLat in degrees"
+//	@Param			zoom	query		int						true	"Map zoom level (0-22)"
+//	@Success		200		{array}		models.Cluster			"Clusters, largest first"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/clusters [get]
+func (h *Handler) HandleImagesClusters(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	query := r.URL.Query()
+	parts := strings.Split(query.Get("bbox"), ",")
+	if len(parts) != 4 {
+		writeError(w, r, http.StatusBadRequest, "Invalid bbox parameter")
+		return
+	}
+	var bbox [4]float64
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid bbox parameter")
+			return
+		}
+		bbox[i] = value
+	}
+
+	zoom, err := strconv.Atoi(query.Get("zoom"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid zoom parameter")
+		return
+	}
+
+	clusters, err := h.imageService.Clusters(r.Context(), bbox[1], bbox[0], bbox[3], bbox[2], zoom)
+	if err != nil {
+		log.Printf("[Clusters] Failed to cluster images: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Clusters] Served %d clusters (zoom=%d) in %v", len(clusters), zoom, time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=30, s-maxage=60")
+	if err := json.NewEncoder(w).Encode(clusters); err != nil {
+		log.Printf("[Clusters] Failed to encode response: %v", err)
+	}
+}
+
+// HandleImagesPoints returns every geotagged image as a compact point for plotting on a map.
+//
+//	@Summary		Map points
+//	@Description	Every geotagged image as a [id, lat, lng, takenAtEpochSeconds] tuple (takenAt 0 when unknown), coordinates at float32 precision.
+//	@Description	Send Accept: application/octet-stream for the binary encoding: little-endian uint32 count, then per point float32 lat,
+//	@Description	float32 lng, uint32 takenAt, uint8 id length and the id bytes. The ETag follows the collection's latest update, so
+//	@Description	If-None-Match revalidation is cheap; deletions are picked up when the server cache expires.
+//	@Tags			images
+//	@Produce		json
+//	@Produce		octet-stream
+//	@Success		200	{array}		array					"Point tuples"
+//	@Success		304	{string}	string					"Not Modified"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503	{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/points [get]
+func (h *Handler) HandleImagesPoints(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	version, err := h.imageService.MapPointsVersion(r.Context())
+	if err != nil {
+		log.Printf("[Points] Failed to read collection version: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	binary := acceptsMediaType(r, models.PointsBinaryContentType)
+	contentType, tag := "application/json", `"`+version+`-json"`
+	if binary {
+		contentType, tag = models.PointsBinaryContentType, `"`+version+`-bin"`
+	}
+
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Cache-Control", "public, max-age=60, s-maxage=300") // 1 min client, 5 min edge
+	if r.Header.Get("If-None-Match") == tag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	body, err := h.imageService.MapPoints(r.Context(), version, binary)
+	if err != nil {
+		log.Printf("[Points] Failed to build points: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Points] Served %d bytes (%s) in %v", len(body), contentType, time.Since(start))
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+
+	// HEAD gets the headers only
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if _, err := w.Write(body); err != nil {
+		log.Printf("[Points] Failed to write response: %v", err)
+	}
+}
+
+// HandleImageMetadata returns one image's metadata document.
+//
+//	@Summary		Get image metadata
+//	@Tags			images
+//	@Produce		json
+//	@Param			id	path		string					true	"Document ID"
+//	@Success		200	{object}	models.ImageMetadata	"Metadata"
+//	@Failure		404	{object}	models.ErrorResponse	"Not Found"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503	{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/{id} [get]
+func (h *Handler) HandleImageMetadata(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	metadata, err := h.imageService.GetImageMetadata(r.Context(), id)
+	if err != nil {
+		log.Printf("[Images] Failed to get metadata of %s: %v", id, err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	writeMetadataJSON(w, metadata)
+}
+
+// HandleImageMetadataPatch edits one image's user-editable metadata.
+//
+//	@Summary		Edit image metadata
+//	@Description	Updates the user-editable fields from {"description": "...", "visibility": "public"}: descriptions are trimmed, at most
+//	@Description	2000 characters, and an empty string clears it; visibility is "public" or "private". Drive sync never overwrites either.
+//	@Tags			images
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string						true	"Document ID"
+//	@Param			patch	body		models.ImageMetadataPatch	true	"Fields to change"
+//	@Success		200		{object}	models.ImageMetadata		"Metadata"
+//	@Failure		400		{object}	models.ErrorResponse		"Bad Request"
+//	@Failure		404		{object}	models.ErrorResponse		"Not Found"
+//	@Failure		500		{object}	models.ErrorResponse		"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse		"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/{id} [patch]
+func (h *Handler) HandleImageMetadataPatch(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var patch models.ImageMetadataPatch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&patch); err != nil {
+		writeError(w, r, bodyErrorStatus(err), "Body must be a JSON object of fields to change")
+		return
 	}
+	metadata, err := h.imageService.UpdateImageMetadata(r.Context(), id, patch)
+	if err != nil {
+		log.Printf("[Images] Failed to patch metadata of %s: %v", id, err)
+		if errors.Is(err, apperrors.ErrInvalidInput) {
+			// Our own validation message (e.g. the length cap), so the caller can fix the request
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeServiceError(w, r, err)
+		return
+	}
+	log.Printf("[Images] Updated metadata of %s", metadata.Id)
+
+	writeMetadataJSON(w, metadata)
+}
+
+// HandleImageUpload stores an uploaded image or video and creates its metadata document.
+//
+//	@Summary		Upload an image
+//	@Description	The body is the file itself, with its type in Content-Type (inferred from the name and content if missing or
+//	@Description	application/octet-stream). It is handled like a file synced from Drive: HEIC is converted to JPEG, metadata is
+//	@Description	extracted and geocoded, and the file is stored under STORAGE_LAYOUT. At most 50MB. A name already in use, or content
+//	@Description	already stored under another name, gets 409 and nothing is written.
+//	@Tags			images
+//	@Accept			image/jpeg,image/png,image/heic,video/mp4,application/octet-stream
+//	@Produce		json
+//	@Param			fileName	query		string					true	"Name to store the file under"
+//	@Success		201			{object}	models.ImageMetadata	"Created; Location is /images/{id}"
+//	@Failure		400			{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		409			{object}	models.ErrorResponse	"Name or content already stored"
+//	@Failure		413			{object}	models.ErrorResponse	"File too large"
+//	@Failure		415			{object}	models.ErrorResponse	"Not an image or video"
+//	@Failure		500			{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503			{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images [post]
+func (h *Handler) HandleImageUpload(w http.ResponseWriter, r *http.Request) {
+	fileName, msg := fileNameParam(r)
+	if msg != "" {
+		writeError(w, r, http.StatusBadRequest, msg)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, services.MaxUploadSize))
+	if err != nil {
+		writeError(w, r, bodyErrorStatus(err), "Could not read the uploaded file")
+		return
+	}
+
+	metadata, err := h.imageService.UploadImage(r.Context(), fileName, r.Header.Get("Content-Type"), data)
+	if err != nil {
+		log.Printf("[Images] Failed to upload %s: %v", fileName, err)
+		switch {
+		case errors.Is(err, apperrors.ErrConflict):
+			writeError(w, r, http.StatusConflict, "An image with this name or content already exists")
+		case errors.Is(err, apperrors.ErrInvalidInput):
+			writeError(w, r, http.StatusBadRequest, err.Error())
+		default:
+			writeServiceError(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Location", "/images/"+metadata.Id)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(metadata); err != nil {
+		log.Printf("[Images] Failed to encode response: %v", err)
+	}
+}
+
+// HandleImageDelete deletes an image's metadata document and its stored file.
+//
+//	@Summary		Delete an image
+//	@Description	Removes the document (and the image from any album), then the stored file, and evicts its cached entries. A file
+//	@Description	still in the synced Drive folder comes back with the next backfill.
+//	@Tags			images
+//	@Param			id	path	string	true	"Document ID"
+//	@Success		204	"Deleted"
+//	@Failure		404	{object}	models.ErrorResponse	"Not Found"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503	{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/{id} [delete]
+func (h *Handler) HandleImageDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if err := h.imageService.DeleteImage(r.Context(), id); err != nil {
+		log.Printf("[Images] Failed to delete %s: %v", id, err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Encodes an image's metadata as an uncached JSON response.
+func writeMetadataJSON(w http.ResponseWriter, metadata *models.ImageMetadata) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(metadata); err != nil {
+		log.Printf("[Images] Failed to encode response: %v", err)
+	}
+}
+
+// HandleImageFavorite marks or unmarks an image as a favorite.
+//
+//	@Summary		Set favorite
+//	@Description	POST toggles the favorite flag; PUT sets it explicitly from {"favorite": true|false}. Only the flag and updatedAt change,
+//	@Description	and the image's cached entries are evicted. List favorites with /images/list?favorite=true.
+//	@Tags			images
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		string					true	"Document ID"
+//	@Param			favorite	body		map[string]bool			false	"{\"favorite\": true} (PUT)"
+//	@Success		200			{object}	map[string]any			"id and the new favorite value"
+//	@Failure		400			{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		404			{object}	models.ErrorResponse	"Not Found"
+//	@Failure		500			{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503			{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/{id}/favorite [post]
+//	@Router			/images/{id}/favorite [put]
+func (h *Handler) HandleImageFavorite(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	// nil toggles
+	var favorite *bool
+	if r.Method == http.MethodPut {
+		var body struct {
+			Favorite *bool `json:"favorite"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&body); err != nil || body.Favorite == nil {
+			writeError(w, r, http.StatusBadRequest, `Body must be {"favorite": true} or {"favorite": false}`)
+			return
+		}
+		favorite = body.Favorite
+	}
+
+	docID, value, err := h.imageService.SetFavorite(r.Context(), id, favorite)
+	if err != nil {
+		log.Printf("[Images] Failed to set favorite on %s: %v", id, err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Images] Set favorite=%t on %s", value, docID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"id":       docID,
+		"favorite": value,
+	}); err != nil {
+		log.Printf("[Images] Failed to encode response: %v", err)
+	}
+}
+
+// HandleImageRefreshMetadata re-extracts one image's metadata from its stored file.
+//
+//	@Summary		Refresh image metadata
+//	@Description	Downloads the image or video from Storage, extracts EXIF/MP4 metadata again and merges it into the document like the
+//	@Description	update-metadata tool (fields the file no longer yields are kept). Returns the changed fields; with dryRun=true nothing is written.
+//	@Tags			images
+//	@Produce		json
+//	@Param			id		path		string					true	"Document ID"
+//	@Param			dryRun	query		bool					false	"Report the changes without writing them"
+//	@Success		200		{object}	models.MetadataRefresh	"Changed fields and resulting metadata"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		404		{object}	models.ErrorResponse	"Not Found"
+//	@Failure		409		{object}	models.ErrorResponse	"Image modified concurrently"
+//	@Failure		413		{object}	models.ErrorResponse	"File too large"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/{id}/refresh-metadata [post]
+func (h *Handler) HandleImageRefreshMetadata(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	id := r.PathValue("id")
+
+	dryRun := false
+	if v := r.URL.Query().Get("dryRun"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid dryRun parameter")
+			return
+		}
+		dryRun = parsed
+	}
+
+	refresh, err := h.imageService.RefreshMetadata(r.Context(), id, dryRun)
+	if err != nil {
+		log.Printf("[Images] Failed to refresh metadata of %s: %v", id, err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Images] Refreshed metadata of %s: %d fields changed (dryRun=%t, updated=%t) in %v",
+		refresh.Id, len(refresh.Changes), dryRun, refresh.Updated, time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(refresh); err != nil {
+		log.Printf("[Images] Failed to encode response: %v", err)
+	}
+}
+
+// HandleImageExif dumps the raw metadata tags of a stored image or video, for debugging extraction.
+//
+//	@Summary		Raw EXIF tags
+//	@Description	Every decodable EXIF tag of the original file (exiftool tags, group-qualified, for videos) as tag name → string value.
+//	@Description	Tags are sorted by name and paged with offset/limit; values over 512 characters are truncated. If no tags could be
+//	@Description	decoded, tags is empty and error says why. Files over PROXY_MAX_BYTES are rejected.
+//	@Tags			images
+//	@Produce		json
+//	@Param			id		path		string					true	"Document ID"
+//	@Param			offset	query		int						false	"Index of the first tag to return"	default(0)
+//	@Param			limit	query		int						false	"Tags per page (max 1000)"			default(200)
+//	@Success		200		{object}	models.ExifDump			"Tags"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		404		{object}	models.ErrorResponse	"Not Found"
+//	@Failure		413		{object}	models.ErrorResponse	"File too large"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/{id}/exif [get]
+func (h *Handler) HandleImageExif(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	id := r.PathValue("id")
+	if id == "" {
+		writeError(w, r, http.StatusBadRequest, "Missing image ID")
+		return
+	}
+
+	query := r.URL.Query()
+	offset := 0
+	if v := query.Get("offset"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			writeError(w, r, http.StatusBadRequest, "Invalid offset parameter")
+			return
+		}
+		offset = parsed
+	}
+	limit := 200
+	if v := query.Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			writeError(w, r, http.StatusBadRequest, "Invalid limit parameter (1-1000)")
+			return
+		}
+		limit = parsed
+	}
+
+	dump, err := h.imageService.ExifTags(r.Context(), id)
+	if err != nil {
+		log.Printf("[Exif] Failed to read tags of %s: %v", id, err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	names := make([]string, 0, len(dump.Tags))
+	for name := range dump.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	page := make(map[string]string)
+	for i := offset; i < len(names) && i < offset+limit; i++ {
+		page[names[i]] = dump.Tags[names[i]]
+	}
+	dump.Tags = page
+	dump.Offset = offset
+
+	log.Printf("[Exif] Served %d of %d tags for %s in %v", len(page), dump.Total, dump.FileName, time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(dump); err != nil {
+		log.Printf("[Exif] Failed to encode response: %v", err)
+	}
+}
+
+// HandleImagesStats returns collection statistics (counts by country, year and media type, plus missing-data counts).
+//
+//	@Summary		Image statistics
+//	@Description	Totals by country, year of takenAt and media type, plus counts of documents with missing metadata.
+//	@Description	Results are cached; pass refresh=true to recompute.
+//	@Tags			images
+//	@Produce		json
+//	@Param			refresh	query		bool					false	"Recompute instead of using the cached result"
+//	@Success		200		{object}	models.ImageStats		"Collection statistics"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/stats [get]
+func (h *Handler) HandleImagesStats(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	refresh := false
+	if v := r.URL.Query().Get("refresh"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid refresh parameter")
+			return
+		}
+		refresh = parsed
+	}
+
+	stats, err := h.imageService.GetStats(r.Context(), refresh)
+	if err != nil {
+		log.Printf("[Stats] Failed to compute stats: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Stats] Served stats for %d images (refresh=%t) in %v", stats.Total, refresh, time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		log.Printf("[Stats] Failed to encode response: %v", err)
+	}
+}
+
+// HandleImagesTimeline groups images into day or month buckets, newest first.
+//
+//	@Summary		Image timeline
+//	@Description	Buckets keyed by the UTC date of takenAt ("2025-01-15" by day, "2025-01" by month), newest first, each with its image count
+//	@Description	and its newest images. Images without takenAt are grouped in a final "unknown" bucket. Pass nextCursor as cursor for the next page.
+//	@Tags			images
+//	@Produce		json
+//	@Param			granularity	query		string					false	"Bucket size: day or month"				default(day)
+//	@Param			per			query		int						false	"Images listed per bucket (max 100)"	default(5)
+//	@Param			limit		query		int						false	"Buckets per page (max 366)"			default(30)
+//	@Param			cursor		query		string					false	"Key of the last bucket of the previous page"
+//	@Success		200			{object}	models.Timeline			"Page of buckets"
+//	@Failure		400			{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		500			{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503			{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/timeline [get]
+func (h *Handler) HandleImagesTimeline(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	query := r.URL.Query()
+
+	granularity := models.TimelineDay
+	keyLayout := "2006-01-02"
+	switch query.Get("granularity") {
+	case "", models.TimelineDay:
+	case models.TimelineMonth:
+		granularity = models.TimelineMonth
+		keyLayout = "2006-01"
+	default:
+		writeError(w, r, http.StatusBadRequest, "Invalid granularity parameter (must be day or month)")
+		return
+	}
+
+	per := 5
+	if perStr := query.Get("per"); perStr != "" {
+		parsedPer, err := strconv.Atoi(perStr)
+		if err != nil || parsedPer < 0 || parsedPer > 100 {
+			writeError(w, r, http.StatusBadRequest, "Invalid per parameter (must be 0-100)")
+			return
+		}
+		per = parsedPer
+	}
+
+	limit := 30
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err != nil || parsedLimit < 1 || parsedLimit > 366 {
+			writeError(w, r, http.StatusBadRequest, "Invalid limit parameter (must be 1-366)")
+			return
+		}
+		limit = parsedLimit
+	}
+
+	cursor := query.Get("cursor")
+	if cursor != "" && cursor != models.TimelineUnknown {
+		if _, err := time.Parse(keyLayout, cursor); err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid cursor parameter")
+			return
+		}
+	}
+
+	timeline, err := h.imageService.Timeline(r.Context(), granularity, per, limit, cursor)
+	if err != nil {
+		log.Printf("[Timeline] Failed to build timeline: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Timeline] Served %d %s buckets (cursor=%q) in %v", len(timeline.Buckets), granularity, cursor, time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	if err := json.NewEncoder(w).Encode(timeline); err != nil {
+		log.Printf("[Timeline] Failed to encode response: %v", err)
+	}
+}
+
+// HandleImagesSearch finds images by file name prefix.
+//
+//	@Summary		Search images by file name
+//	@Description	Returns images whose file name starts with q, ordered by file name. Matches the stored name exactly or case-insensitively.
+//	@Tags			images
+//	@Produce		json
+//	@Param			q		query		string					true	"File name prefix (e.g. IMG_2024)"
+//	@Param			limit	query		int						false	"Number of items to return (max 1000, default 1000)"	default(1000)
+//	@Param			page	query		int						false	"Page number (0-indexed, default 0)"					default(0)
+//	@Success		200		{array}		models.ImageMetadata	"Matching images"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/search [get]
+func (h *Handler) HandleImagesSearch(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	query := r.URL.Query()
+
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		writeError(w, r, http.StatusBadRequest, "Missing q parameter")
+		return
+	}
+	if len(q) > 255 {
+		writeError(w, r, http.StatusBadRequest, "q too long")
+		return
+	}
+
+	limit := 1000
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err != nil || parsedLimit < 0 {
+			writeError(w, r, http.StatusBadRequest, "Invalid limit parameter")
+			return
+		}
+		limit = parsedLimit
+	}
+
+	page := 0
+	if pageStr := query.Get("page"); pageStr != "" {
+		parsedPage, err := strconv.Atoi(pageStr)
+		if err != nil || parsedPage < 0 {
+			writeError(w, r, http.StatusBadRequest, "Invalid page parameter")
+			return
+		}
+		page = parsedPage
+	}
+
+	images, err := h.imageService.SearchImages(r.Context(), q, limit, page)
+	if err != nil {
+		log.Printf("[Search] Failed to search %q: %v", q, err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Search] Served %d matches for %q (limit=%d, page=%d) in %v", len(images), q, limit, page, time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	if err := json.NewEncoder(w).Encode(images); err != nil {
+		log.Printf("[Search] Failed to encode response: %v", err)
+	}
+}
+
+// HandleLocationTree returns the country → region → city hierarchy with image counts.
+//
+//	@Summary		Location tree
+//	@Description	Countries, their regions and the regions' cities, each with the number of images under it, largest first.
+//	@Description	Use the names (or a country's code) as country/region/city filters on /images/list. Results are cached; pass refresh=true to recompute.
+//	@Tags			images
+//	@Produce		json
+//	@Param			refresh	query		bool					false	"Recompute instead of using the cached result"
+//	@Success		200		{object}	models.LocationTree		"Location hierarchy"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/locations/tree [get]
+func (h *Handler) HandleLocationTree(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	refresh := false
+	if v := r.URL.Query().Get("refresh"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid refresh parameter")
+			return
+		}
+		refresh = parsed
+	}
+
+	tree, err := h.imageService.LocationTree(r.Context(), refresh)
+	if err != nil {
+		log.Printf("[Locations] Failed to build tree: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Locations] Served tree of %d countries (refresh=%t) in %v", len(tree.Countries), refresh, time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	if err := json.NewEncoder(w).Encode(tree); err != nil {
+		log.Printf("[Locations] Failed to encode response: %v", err)
+	}
+}
+
+// HandleImagesCountries returns the countries the collection has images from.
+//
+//	@Summary		Countries visited
+//	@Description	Distinct countries with their image count and the dates of the first and last image taken there, largest first.
+//	@Description	Images without a country or geoLocation are left out and counted in "unlocated". Results are cached; pass refresh=true to recompute.
+//	@Tags			images
+//	@Produce		json
+//	@Param			refresh	query		bool					false	"Recompute instead of using the cached result"
+//	@Success		200		{object}	models.CountriesVisited	"Countries visited"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/images/countries [get]
+func (h *Handler) HandleImagesCountries(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	refresh := false
+	if v := r.URL.Query().Get("refresh"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid refresh parameter")
+			return
+		}
+		refresh = parsed
+	}
+
+	visited, err := h.imageService.CountriesVisited(r.Context(), refresh)
+	if err != nil {
+		log.Printf("[Locations] Failed to summarise countries: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Locations] Served %d countries (refresh=%t) in %v", len(visited.Countries), refresh, time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	if err := json.NewEncoder(w).Encode(visited); err != nil {
+		log.Printf("[Locations] Failed to encode response: %v", err)
+	}
+}
+
+// HandleThumbnail serves a resized rendition of an image, generated on first request and cached.
+//
+//	@Summary		Get an image thumbnail
+//	@Description	Retrieve a resized JPEG/PNG rendition of an image (width capped at 2048px).
+//	@Description	Clients sending Accept: image/webp receive a WebP rendition of JPEG and still GIF sources (when cwebp is installed).
+//	@Tags			images
+//	@Produce		image/jpeg
+//	@Produce		image/png
+//	@Produce		image/webp
+//	@Param			fileName	query		string					true	"Image filename"
+//	@Param			w			query		int						false	"Target width in pixels (default 400, max 2048)"	default(400)
+//	@Success		200			{file}		binary					"Resized image bytes"
+//	@Failure		400			{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		404			{object}	models.ErrorResponse	"Not Found"
+//	@Failure		413			{object}	models.ErrorResponse	"Source image too large"
+//	@Failure		415			{object}	models.ErrorResponse	"Unsupported Media Type"
+//	@Failure		500			{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503			{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/image/thumbnail [get]
+func (h *Handler) HandleThumbnail(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	fileName, msg := fileNameParam(r)
+	if msg != "" {
+		writeError(w, r, http.StatusBadRequest, msg)
+		return
+	}
+
+	// Parse and validate width parameter
+	width := 400
+	if widthStr := r.URL.Query().Get("w"); widthStr != "" {
+		parsedWidth, err := strconv.Atoi(widthStr)
+		if err != nil || parsedWidth <= 0 {
+			writeError(w, r, http.StatusBadRequest, "Invalid w parameter")
+			return
+		}
+		width = min(parsedWidth, services.MaxThumbnailWidth)
+	}
+
+	data, contentType, err := h.imageService.GetThumbnail(r.Context(), fileName, width, acceptsWebP(r))
+	if err != nil {
+		log.Printf("[Thumbnail] Failed to get thumbnail %s@%d: %v", fileName, width, err)
+		if errors.Is(err, apperrors.ErrUnsupportedMediaType) {
+			writeError(w, r, http.StatusUnsupportedMediaType, "Thumbnails are only available for images")
+			return
+		}
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[Thumbnail] Served %s@%d (%d bytes) in %v", fileName, width, len(data), time.Since(start))
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "public, max-age=604800, s-maxage=2592000, immutable") // 7 days client, 30 days edge
+
+	// Body depends on WebP negotiation, so CDNs must key on Accept
+	w.Header().Add("Vary", "Accept")
+
+	if _, err := w.Write(data); err != nil {
+		log.Printf("[Thumbnail] Failed to write response: %v", err)
+	}
+}
+
+// Marks a response served from stale cache during a Firestore outage with its age in seconds,
+// and keeps it out of shared caches so fresh data is served as soon as Firestore recovers.
+func setStaleness(w http.ResponseWriter, staleness time.Duration) {
+	if staleness <= 0 {
+		return
+	}
+	w.Header().Set("X-Data-Staleness", strconv.Itoa(int(staleness.Seconds())))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Del("CDN-Cache-Control")
+}
+
+// Computes a strong ETag for a response body.
+func etag(body []byte) string {
+	return `"` + utils.ContentHash(body)[:32] + `"`
+}
+
+// Reports whether the client's Accept header lists image/webp with a non-zero quality.
+func acceptsWebP(r *http.Request) bool {
+	return acceptsMediaType(r, "image/webp")
+}
+
+// Reports whether the client's Accept header explicitly lists mediaType with a non-zero quality.
+// Wildcards don't count, so the default encoding is kept for generic clients.
+func acceptsMediaType(r *http.Request, target string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(mediaType), target) {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Reads and validates the fileName query parameter.
+// Returns the trimmed fileName, or a non-empty message describing why it was rejected.
+func fileNameParam(r *http.Request) (string, string) {
+	fileName := strings.TrimSpace(r.URL.Query().Get("fileName"))
+
+	// Validate fileName parameter
+	if fileName == "" {
+		return "", "Missing fileName parameter"
+	}
+
+	// Security: Prevent path traversal attacks
+	if strings.Contains(fileName, "..") || strings.Contains(fileName, "/") || strings.Contains(fileName, "\\") {
+		log.Printf("[Image] Security: Rejected suspicious fileName: %s", fileName)
+		return "", "Invalid fileName"
+	}
+
+	// Validate fileName length
+	if len(fileName) > 255 {
+		return "", "fileName too long"
+	}
+
+	return fileName, ""
 }
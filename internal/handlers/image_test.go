@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"trekka-api/internal/models"
+)
+
+func TestImageFileNameLookup(t *testing.T) {
+	env := newTestEnv(t)
+	seed := func(id, fileName, contentType, status string) {
+		env.seedImage(t, id, &models.ImageMetadata{
+			FileName:      fileName,
+			FileNameLower: strings.ToLower(fileName),
+			ContentType:   contentType,
+			StoragePath:   "images/" + id,
+			Status:        status,
+		}, []byte(id))
+	}
+	seed("mixed-case", "IMG_001.jpg", "image/jpeg", "")
+	seed("heic-original", "IMG_002.HEIC", "image/heic", "") // Stored as is when conversion failed
+	seed("heic-converted", "IMG_003.jpg", "image/jpeg", "")
+	seed("both-original", "IMG_004.HEIC", "image/heic", "")
+	seed("both-converted", "IMG_004.jpg", "image/jpeg", "")
+	seed("quarantined", "IMG_005.jpg", "image/jpeg", models.StatusQuarantined)
+	seed("deleted", "IMG_006.jpg", "image/jpeg", models.StatusDeleted)
+	seed("visible", "IMG_006.jpg", "image/jpeg", "")
+
+	tests := []struct {
+		name     string
+		fileName string
+		wantID   string // Whose object is served; empty for 404
+	}{
+		{"exact name", "IMG_001.jpg", "mixed-case"},
+		{"other case", "img_001.JPG", "mixed-case"},
+		{"HEIC name stored as is", "IMG_002.HEIC", "heic-original"},
+		{"HEIC name stored as is, other case", "img_002.heic", "heic-original"},
+		{"HEIC name stored converted", "IMG_003.HEIC", "heic-converted"},
+		{"HEIC name stored converted, other case", "img_003.heif", "heic-converted"},
+		{"HEIC name stored both ways", "IMG_004.HEIC", "both-original"},
+		{"converted name stored both ways", "IMG_004.jpg", "both-converted"},
+		{"quarantined", "IMG_005.jpg", ""},
+		{"visible copy of a deleted name", "IMG_006.jpg", "visible"},
+		{"missing", "IMG_404.jpg", ""},
+		{"missing HEIC", "IMG_404.HEIC", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			env.handler.HandleImage(rec, httptest.NewRequest(http.MethodGet, "/image?mode=proxy&fileName="+url.QueryEscape(tt.fileName), nil))
+
+			if tt.wantID == "" {
+				if rec.Code != http.StatusNotFound {
+					t.Errorf("status = %d, want %d (body %s)", rec.Code, http.StatusNotFound, rec.Body)
+				}
+				return
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, http.StatusOK, rec.Body)
+			}
+			if got := rec.Body.String(); got != tt.wantID {
+				t.Errorf("served %s's object, want %s's", got, tt.wantID)
+			}
+		})
+	}
+}
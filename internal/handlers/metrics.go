@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// HandleSyncMetrics reports where ingest time goes, per stage, for files synced by this instance.
+//
+//	@Summary		Sync stage metrics
+//	@Description	Histograms of per-file durations for each ingest stage (download, convert, upload, extract, geocode, persist)
+//	@Description	and the 10 slowest files per stage, since this instance started.
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	models.SyncMetricsSnapshot	"Stage timings"
+//	@Security		ApiKeyAuth
+//	@Router			/admin/metrics/sync [get]
+func (h *Handler) HandleSyncMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(h.syncMetrics.Snapshot()); err != nil {
+		log.Printf("[Metrics] Failed to encode response: %v", err)
+	}
+}
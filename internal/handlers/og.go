@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HandleOpenGraphImage serves a small, upright JPEG of an image for link previews (Slack, Twitter, etc.).
+//
+//	@Summary		Link preview image
+//	@Description	Orientation-corrected JPEG of at most 1200px, generated on first request and stored alongside the originals.
+//	@Description	Public (no API key) so link unfurlers can fetch it; only images with visibility "public" are served, others return 404.
+//	@Description	With v set to the current version (X-Preview-Version) the response is cacheable for a year.
+//	@Tags			images
+//	@Produce		jpeg
+//	@Param			fileName	path		string					true	"Image filename, with .jpg appended (e.g. IMG_1.HEIC.jpg)"
+//	@Param			v			query		string					false	"Content version for immutable caching"
+//	@Success		200			{file}		binary					"JPEG preview"
+//	@Failure		404			{object}	models.ErrorResponse	"Not Found"
+//	@Failure		415			{object}	models.ErrorResponse	"Unsupported Media Type"
+//	@Failure		500			{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503			{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Router			/og/{fileName}.jpg [get]
+//	@Router			/og/{fileName}.jpg [head]
+func (h *Handler) HandleOpenGraphImage(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	name := strings.TrimPrefix(r.URL.Path, "/og/")
+	fileName := strings.TrimSuffix(name, ".jpg")
+	if fileName == "" || fileName == name || strings.Contains(fileName, "/") {
+		writeError(w, r, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	data, version, err := h.imageService.OpenGraphImage(r.Context(), fileName)
+	if err != nil {
+		log.Printf("[OG] Failed to get preview for %s: %v", fileName, err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	log.Printf("[OG] Served preview for %s (%d bytes) in %v", fileName, len(data), time.Since(start))
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("ETag", `"`+version+`"`)
+	w.Header().Set("X-Preview-Version", version)
+	if r.URL.Query().Get("v") == version {
+		// The version pins the bytes, so the URL can be cached forever
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=3600, s-maxage=86400")
+	}
+
+	if match := r.Header.Get("If-None-Match"); match == `"`+version+`"` {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// HEAD gets the headers only
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if _, err := w.Write(data); err != nil {
+		log.Printf("[OG] Failed to write response: %v", err)
+	}
+}
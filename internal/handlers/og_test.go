@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"trekka-api/internal/models"
+)
+
+// Encodes a blank width×height JPEG.
+func blankJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestOpenGraphImage(t *testing.T) {
+	env := newTestEnv(t)
+	const version = "0123456789abcdef"
+
+	env.seedImage(t, "public-1", &models.ImageMetadata{
+		FileName:    "IMG_1.jpg",
+		ContentType: "image/jpeg",
+		StoragePath: "images/IMG_1.jpg",
+		ContentHash: version + "0123456789abcdef0123456789abcdef0123456789abcdef",
+		Visibility:  models.VisibilityPublic,
+	}, blankJPEG(t, 1600, 800))
+	env.seedImage(t, "private-1", &models.ImageMetadata{
+		FileName:    "IMG_2.jpg",
+		ContentType: "image/jpeg",
+		StoragePath: "images/IMG_2.jpg",
+		ContentHash: "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210",
+	}, blankJPEG(t, 1600, 800))
+
+	get := func(target string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		rec := httptest.NewRecorder()
+		env.handler.HandleOpenGraphImage(rec, req)
+		return rec
+	}
+
+	t.Run("generates and stores a capped preview", func(t *testing.T) {
+		rec := get("/og/IMG_1.jpg.jpg", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Type"); got != "image/jpeg" {
+			t.Errorf("Content-Type = %q, want image/jpeg", got)
+		}
+		config, format, err := image.DecodeConfig(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil || format != "jpeg" {
+			t.Fatalf("preview isn't a JPEG: %v (%s)", err, format)
+		}
+		if config.Width != 1200 || config.Height != 600 {
+			t.Errorf("preview is %dx%d, want 1200x600", config.Width, config.Height)
+		}
+
+		stored, ok := env.gcs.Get("derived/og/" + version + ".jpg")
+		if !ok {
+			t.Fatal("preview wasn't stored under derived/og/")
+		}
+		if !bytes.Equal(stored, rec.Body.Bytes()) {
+			t.Error("stored preview differs from the one served")
+		}
+	})
+
+	t.Run("caching headers", func(t *testing.T) {
+		rec := get("/og/IMG_1.jpg.jpg", nil)
+		if got := rec.Header().Get("X-Preview-Version"); got != version {
+			t.Errorf("X-Preview-Version = %q, want %q", got, version)
+		}
+		if got := rec.Header().Get("ETag"); got != `"`+version+`"` {
+			t.Errorf("ETag = %q, want %q", got, `"`+version+`"`)
+		}
+		if got := rec.Header().Get("Cache-Control"); got != "public, max-age=3600, s-maxage=86400" {
+			t.Errorf("unversioned Cache-Control = %q", got)
+		}
+
+		rec = get("/og/IMG_1.jpg.jpg?v="+version, nil)
+		if got := rec.Header().Get("Cache-Control"); got != "public, max-age=31536000, immutable" {
+			t.Errorf("versioned Cache-Control = %q, want immutable", got)
+		}
+
+		// A stale version isn't pinned to the current bytes
+		rec = get("/og/IMG_1.jpg.jpg?v=0000000000000000", nil)
+		if got := rec.Header().Get("Cache-Control"); got != "public, max-age=3600, s-maxage=86400" {
+			t.Errorf("Cache-Control for an old version = %q, want the short one", got)
+		}
+
+		rec = get("/og/IMG_1.jpg.jpg", http.Header{"If-None-Match": {`"` + version + `"`}})
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("conditional request = %d with %d bytes, want 304 and no body", rec.Code, rec.Body.Len())
+		}
+	})
+
+	t.Run("reuses the stored preview", func(t *testing.T) {
+		env.handler.cacheService.Flush()
+		stored, _ := env.gcs.Get("derived/og/" + version + ".jpg")
+		reads := len(env.gcs.Reads())
+
+		rec := get("/og/IMG_1.jpg.jpg", nil)
+		if !bytes.Equal(rec.Body.Bytes(), stored) {
+			t.Error("served preview differs from the stored one")
+		}
+		if got := len(env.gcs.Reads()) - reads; got != 1 {
+			t.Errorf("made %d reads, want 1 (the stored preview, not the original)", got)
+		}
+	})
+
+	t.Run("head", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodHead, "/og/IMG_1.jpg.jpg", nil)
+		rec := httptest.NewRecorder()
+		env.handler.HandleOpenGraphImage(rec, req)
+		if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+			t.Errorf("HEAD = %d with %d bytes, want 200 and no body", rec.Code, rec.Body.Len())
+		}
+		if rec.Header().Get("Content-Length") == "" {
+			t.Error("HEAD has no Content-Length")
+		}
+	})
+
+	t.Run("refuses private and unknown images", func(t *testing.T) {
+		for _, target := range []string{"/og/IMG_2.jpg.jpg", "/og/missing.jpg.jpg", "/og/IMG_1.jpg", "/og/.jpg"} {
+			if rec := get(target, nil); rec.Code != http.StatusNotFound {
+				t.Errorf("%s status = %d, want %d", target, rec.Code, http.StatusNotFound)
+			}
+		}
+		if _, ok := env.gcs.Get("derived/og/fedcba9876543210.jpg"); ok {
+			t.Error("generated a preview for a private image")
+		}
+	})
+}
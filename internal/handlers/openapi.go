@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"trekka-api/docs"
+)
+
+// ETag of the embedded document, fixed for the life of the binary.
+var openAPIETag = etag(docs.SwaggerJSON)
+
+// HandleOpenAPI serves the generated OpenAPI document.
+//
+//	@Summary		OpenAPI document
+//	@Description	The Swagger 2.0 document generated from the handler annotations (the one /swagger/ renders), embedded at build time.
+//	@Description	The ETag is a hash of the document, so clients can revalidate with If-None-Match.
+//	@Tags			docs
+//	@Produce		json
+//	@Success		200	{object}	object	"OpenAPI document"
+//	@Success		304	{string}	string	"Not Modified"
+//	@Security		ApiKeyAuth
+//	@Router			/openapi.json [get]
+func HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", openAPIETag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if r.Header.Get("If-None-Match") == openAPIETag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(docs.SwaggerJSON)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(docs.SwaggerJSON); err != nil {
+		log.Printf("[OpenAPI] Failed to write response: %v", err)
+	}
+}
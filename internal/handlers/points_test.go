@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"trekka-api/internal/models"
+)
+
+func TestImagesPoints(t *testing.T) {
+	env := newTestEnv(t)
+	takenAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	updatedAt := time.Date(2024, 6, 2, 8, 0, 0, 0, time.UTC)
+	env.seedImage(t, "lisbon", &models.ImageMetadata{
+		FileName: "lisbon.jpg", Coordinates: models.Coordinates{Lat: "38.722300", Lng: "-9.139300"},
+		TakenAt: takenAt, UpdatedAt: updatedAt,
+	}, nil)
+	env.seedImage(t, "undated", &models.ImageMetadata{
+		FileName: "undated.jpg", Coordinates: models.Coordinates{Lat: "-33.868800", Lng: "151.209300"},
+		UpdatedAt: updatedAt.Add(-time.Hour),
+	}, nil)
+	env.seedImage(t, "no-gps", &models.ImageMetadata{FileName: "no-gps.jpg", TakenAt: takenAt, UpdatedAt: updatedAt}, nil)
+	env.seedImage(t, "quarantined", &models.ImageMetadata{
+		FileName: "quarantined.jpg", Coordinates: models.Coordinates{Lat: "1", Lng: "2"},
+		Status: models.StatusQuarantined, UpdatedAt: updatedAt,
+	}, nil)
+
+	get := func(accept, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/images/points", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		env.handler.HandleImagesPoints(rec, req)
+		return rec
+	}
+
+	jsonRec := get("", "")
+	if jsonRec.Code != http.StatusOK || jsonRec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("JSON response = %d %s", jsonRec.Code, jsonRec.Header().Get("Content-Type"))
+	}
+	var fromJSON []models.MapPoint
+	if err := json.Unmarshal(jsonRec.Body.Bytes(), &fromJSON); err != nil {
+		t.Fatalf("decode JSON: %v", err)
+	}
+
+	binaryRec := get(models.PointsBinaryContentType, "")
+	if binaryRec.Code != http.StatusOK || binaryRec.Header().Get("Content-Type") != models.PointsBinaryContentType {
+		t.Fatalf("binary response = %d %s", binaryRec.Code, binaryRec.Header().Get("Content-Type"))
+	}
+	fromBinary, err := models.DecodePointsBinary(binaryRec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("decode binary: %v", err)
+	}
+
+	// Narrowed from float64 the way the scan does, which can round differently from a float32 constant
+	f32 := func(v float64) float32 { return float32(v) }
+	want := map[string]models.MapPoint{
+		"lisbon":  {Id: "lisbon", Lat: f32(38.7223), Lng: f32(-9.1393), TakenAt: takenAt.Unix()},
+		"undated": {Id: "undated", Lat: f32(-33.8688), Lng: f32(151.2093)},
+	}
+	for name, points := range map[string][]models.MapPoint{"JSON": fromJSON, "binary": fromBinary} {
+		got := make(map[string]models.MapPoint, len(points))
+		for _, p := range points {
+			got[p.Id] = p
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s points = %+v, want %+v", name, got, want)
+		}
+	}
+	if !reflect.DeepEqual(fromJSON, fromBinary) {
+		t.Errorf("encodings differ: JSON %+v, binary %+v", fromJSON, fromBinary)
+	}
+
+	t.Run("caching", func(t *testing.T) {
+		jsonTag, binaryTag := jsonRec.Header().Get("ETag"), binaryRec.Header().Get("ETag")
+		if jsonTag == "" || jsonTag == binaryTag {
+			t.Fatalf("ETags = %q and %q, want one per encoding", jsonTag, binaryTag)
+		}
+		if got := jsonRec.Header().Get("Vary"); got != "Accept" {
+			t.Errorf("Vary = %q, want Accept", got)
+		}
+
+		if rec := get("", jsonTag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("revalidation = %d with %d bytes, want an empty 304", rec.Code, rec.Body.Len())
+		}
+		if rec := get(models.PointsBinaryContentType, jsonTag); rec.Code != http.StatusOK {
+			t.Errorf("binary request with the JSON ETag = %d, want 200", rec.Code)
+		}
+
+		// A newer updatedAt moves the version, so the old ETag no longer matches
+		env.seedImage(t, "porto", &models.ImageMetadata{
+			FileName: "porto.jpg", Coordinates: models.Coordinates{Lat: "41.157900", Lng: "-8.629100"},
+			UpdatedAt: updatedAt.Add(time.Hour),
+		}, nil)
+		rec := get("", jsonTag)
+		if rec.Code != http.StatusOK || rec.Header().Get("ETag") == jsonTag {
+			t.Fatalf("after an update = %d with ETag %s, want 200 with a new ETag", rec.Code, rec.Header().Get("ETag"))
+		}
+		var points []models.MapPoint
+		if err := json.Unmarshal(rec.Body.Bytes(), &points); err != nil {
+			t.Fatalf("decode JSON: %v", err)
+		}
+		if len(points) != 3 {
+			t.Errorf("got %d points after adding one, want 3", len(points))
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// How long a restore's background re-sync may take before it is abandoned.
+const resyncTimeout = 10 * time.Minute
+
+// HandleQuarantineList lists files set aside after repeatedly failing to process.
+//
+//	@Summary		List quarantined files
+//	@Description	Files whose processing failed QUARANTINE_AFTER times in a row, with the last error. Their objects live under quarantine/
+//	@Description	and they are skipped by sync and excluded from listings until restored.
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		models.SyncFailure		"Quarantined files, most recent first"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503	{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/admin/quarantine [get]
+func (h *Handler) HandleQuarantineList(w http.ResponseWriter, r *http.Request) {
+	records, err := h.quarantineService.List(r.Context())
+	if err != nil {
+		log.Printf("[Quarantine] Failed to list quarantined files: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		log.Printf("[Quarantine] Failed to encode response: %v", err)
+	}
+}
+
+// HandleQuarantineRestore takes a file out of quarantine, e.g. after its source was replaced in Drive.
+//
+//	@Summary		Restore a quarantined file
+//	@Description	Moves the file's object back from quarantine/, clears its status and failure count, and (when Drive sync is enabled)
+//	@Description	re-syncs it from Drive in the background. Otherwise it is picked up by the next backfill.
+//	@Tags			admin
+//	@Produce		json
+//	@Param			fileName	query		string					true	"File name as listed by /admin/quarantine"
+//	@Success		202			{object}	map[string]any			"Restored"
+//	@Failure		400			{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		404			{object}	models.ErrorResponse	"Not quarantined"
+//	@Failure		500			{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503			{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/admin/quarantine/restore [post]
+func (h *Handler) HandleQuarantineRestore(w http.ResponseWriter, r *http.Request) {
+	fileName := r.URL.Query().Get("fileName")
+	if fileName == "" {
+		writeError(w, r, http.StatusBadRequest, "Missing fileName parameter")
+		return
+	}
+
+	if _, err := h.quarantineService.Restore(r.Context(), fileName); err != nil {
+		log.Printf("[Quarantine] Failed to restore %s: %v", fileName, err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	resync := h.driveService != nil
+	if resync {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), resyncTimeout)
+			defer cancel()
+			if _, err := h.driveService.ResyncFile(ctx, fileName); err != nil {
+				log.Printf("[Quarantine] Re-sync of %s failed: %v", fileName, err)
+				return
+			}
+			log.Printf("[Quarantine] Re-synced %s", fileName)
+		}()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"fileName": fileName,
+		"resync":   resync,
+	}); err != nil {
+		log.Printf("[Quarantine] Failed to encode response: %v", err)
+	}
+}
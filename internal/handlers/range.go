@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errMultiRange   = errors.New("multiple ranges are not supported")
+	errInvalidRange = errors.New("invalid range header")
+)
+
+// Parses a single-range "bytes=" Range header into the offset/length form used by
+// storage range readers:
+//
+//	bytes=500-999  -> offset 500, length 500
+//	bytes=1000-    -> offset 1000, length -1 (to end)
+//	bytes=-500     -> offset -500, length -1 (last 500 bytes)
+//
+// Multi-range requests return errMultiRange; anything malformed returns errInvalidRange,
+// which callers should treat as if no Range header was sent.
+func parseByteRange(header string) (offset, length int64, err error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok {
+		return 0, 0, errInvalidRange
+	}
+	if strings.Contains(spec, ",") {
+		return 0, 0, errMultiRange
+	}
+
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, errInvalidRange
+	}
+
+	// Suffix range: last N bytes
+	if startStr == "" {
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, errInvalidRange
+		}
+		return -n, -1, nil
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errInvalidRange
+	}
+
+	// Open-ended range: from start to end of object
+	if endStr == "" {
+		return start, -1, nil
+	}
+
+	end, err := strconv.ParseInt(endStr, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, errInvalidRange
+	}
+
+	return start, end - start + 1, nil
+}
+
+// Sets the Content-Range of a 416 response, which names only the object's size.
+func setUnsatisfiedRange(w http.ResponseWriter, size int64) {
+	w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+}
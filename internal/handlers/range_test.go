@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"trekka-api/internal/models"
+)
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		header     string
+		wantOffset int64
+		wantLength int64
+		wantErr    error
+	}{
+		{"bytes=500-999", 500, 500, nil},
+		{"bytes=0-0", 0, 1, nil},
+		{"bytes=1000-", 1000, -1, nil},
+		{"bytes=-500", -500, -1, nil},
+		{" bytes= 10-19 ", 10, 10, nil},
+		{"bytes=0-1,5-6", 0, 0, errMultiRange},
+		{"bytes=-0", 0, 0, errInvalidRange},
+		{"bytes=9-5", 0, 0, errInvalidRange},
+		{"bytes=a-b", 0, 0, errInvalidRange},
+		{"items=0-5", 0, 0, errInvalidRange},
+		{"bytes=5", 0, 0, errInvalidRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			offset, length, err := parseByteRange(tt.header)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (offset != tt.wantOffset || length != tt.wantLength) {
+				t.Errorf("= offset %d length %d, want %d %d", offset, length, tt.wantOffset, tt.wantLength)
+			}
+		})
+	}
+}
+
+func TestProxyImageRanges(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedImage(t, "clip", &models.ImageMetadata{
+		FileName: "clip.mp4", FileNameLower: "clip.mp4", ContentType: "video/mp4", StoragePath: "2024/05/clip.mp4",
+	}, []byte("0123456789"))
+
+	tests := []struct {
+		name             string
+		rangeHeader      string
+		wantStatus       int
+		wantBody         string
+		wantContentRange string
+	}{
+		{"whole object", "", http.StatusOK, "0123456789", ""},
+		{"single range", "bytes=2-4", http.StatusPartialContent, "234", "bytes 2-4/10"},
+		{"suffix range", "bytes=-3", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"open-ended range", "bytes=6-", http.StatusPartialContent, "6789", "bytes 6-9/10"},
+		{"malformed range is ignored", "bytes=x-", http.StatusOK, "0123456789", ""},
+		{"range past the end", "bytes=20-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"multiple ranges", "bytes=0-1,4-5", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/image?fileName=clip.mp4&mode=proxy", nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			rec := httptest.NewRecorder()
+
+			env.handler.HandleImage(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Range"); got != tt.wantContentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantContentRange)
+			}
+			if tt.wantStatus != http.StatusRequestedRangeNotSatisfiable {
+				if got := rec.Body.String(); got != tt.wantBody {
+					t.Errorf("body = %q, want %q", got, tt.wantBody)
+				}
+				if got := rec.Header().Get("Accept-Ranges"); got != "bytes" {
+					t.Errorf("Accept-Ranges = %q, want bytes", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	apperrors "trekka-api/internal/errors"
+)
+
+// HandleReconcile compares the bucket with the collection, and with POST fixes what it finds.
+//
+//	@Summary		Reconcile bucket and collection
+//	@Description	Lists every media object and document and reports orphan objects (no document), dangling documents (storagePath
+//	@Description	missing) and content-type mismatches. GET only reports. POST also fixes: orphan objects get a document by running
+//	@Description	extraction, and dangling documents get status "deleted". Runs synchronously; a second run while one is going gets 409.
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	models.ReconcileReport	"Reconciliation report"
+//	@Failure		409	{object}	models.ErrorResponse	"Reconciliation already running"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503	{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/admin/reconcile [get]
+//	@Router			/admin/reconcile [post]
+func (h *Handler) HandleReconcile(w http.ResponseWriter, r *http.Request) {
+	report, err := h.reconcileService.Run(r.Context(), r.Method == http.MethodPost)
+	if errors.Is(err, apperrors.ErrConflict) {
+		writeError(w, r, http.StatusConflict, "Reconciliation already running")
+		return
+	}
+	if err != nil {
+		log.Printf("[Reconcile] Failed to reconcile: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("[Reconcile] Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"trekka-api/internal/services"
+)
+
+// HandleSyncRuns lists the latest Drive sync runs.
+//
+//	@Summary		List Drive sync runs
+//	@Description	One entry per backfill (other than dry runs) and watch tick, newest first: what triggered it (startup, watch or
+//	@Description	manual), how it found files (backfill, changes or poll), when it started and finished, how many files synced, were
+//	@Description	skipped or failed, and the first errors. Kept in Firestore for SYNC_RUN_RETENTION.
+//	@Tags			admin
+//	@Produce		json
+//	@Param			limit	query		int						false	"Runs to return (1-100)"	default(20)
+//	@Success		200		{array}		models.SyncRun			"Sync runs, newest first"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse	"Drive sync is not enabled"
+//	@Security		ApiKeyAuth
+//	@Router			/admin/sync/runs [get]
+func (h *Handler) HandleSyncRuns(w http.ResponseWriter, r *http.Request) {
+	if h.driveService == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "Drive sync is not enabled")
+		return
+	}
+
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 || parsed > services.MaxSyncRunsLimit {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid limit parameter (1-%d)", services.MaxSyncRunsLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	runs, err := h.driveService.SyncRuns(r.Context(), limit)
+	if err != nil {
+		log.Printf("[Sync] Failed to list sync runs: %v", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(runs); err != nil {
+		log.Printf("[Sync] Failed to encode response: %v", err)
+	}
+}
+
+// HandleSyncPause pauses the Drive sync on this instance.
+//
+//	@Summary		Pause the Drive sync
+//	@Description	Skips watch ticks and refuses backfills (other than dry runs) until POST /admin/sync/resume, e.g. while the Drive
+//	@Description	folder is reorganized. A backfill or watch tick already running stops once the file in flight is done. The pause
+//	@Description	applies to the instance that receives it, and ends if it restarts. Pausing again keeps the original time.
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	map[string]any			"Paused"
+//	@Failure		503	{object}	models.ErrorResponse	"Drive sync is not enabled"
+//	@Security		ApiKeyAuth
+//	@Router			/admin/sync/pause [post]
+func (h *Handler) HandleSyncPause(w http.ResponseWriter, r *http.Request) {
+	if h.driveService == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "Drive sync is not enabled")
+		return
+	}
+
+	pausedAt := h.driveService.Pause()
+	log.Printf("[Sync] Paused since %s", pausedAt.Format(time.RFC3339))
+	writeSyncState(w, map[string]any{"paused": true, "pausedAt": pausedAt})
+}
+
+// HandleSyncResume resumes a paused Drive sync.
+//
+//	@Summary		Resume the Drive sync
+//	@Description	Ends a pause from POST /admin/sync/pause; the next watch tick picks up what changed in Drive meanwhile. Resuming a
+//	@Description	sync that isn't paused does nothing; wasPaused says which it was.
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	map[string]any			"Resumed"
+//	@Failure		503	{object}	models.ErrorResponse	"Drive sync is not enabled"
+//	@Security		ApiKeyAuth
+//	@Router			/admin/sync/resume [post]
+func (h *Handler) HandleSyncResume(w http.ResponseWriter, r *http.Request) {
+	if h.driveService == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "Drive sync is not enabled")
+		return
+	}
+
+	wasPaused := h.driveService.Resume()
+	log.Printf("[Sync] Resumed (wasPaused=%t)", wasPaused)
+	writeSyncState(w, map[string]any{"paused": false, "wasPaused": wasPaused})
+}
+
+// Writes the sync's pause state after a pause or resume.
+func writeSyncState(w http.ResponseWriter, state map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		log.Printf("[Sync] Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+// Largest trip update body accepted.
+const maxTripBody = 4 << 10 // 4KB
+
+// HandleTrips lists trips, most recent first.
+//
+//	@Summary		List trips
+//	@Description	Trips computed from the collection (runs of images with takenAt gaps under TRIP_GAP, split where the region changes),
+//	@Description	most recent first, without their image IDs. Recompute them with /admin/trips/recompute.
+//	@Tags			trips
+//	@Produce		json
+//	@Success		200	{array}		models.Trip				"Trips"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503	{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/trips [get]
+func (h *Handler) HandleTrips(w http.ResponseWriter, r *http.Request) {
+	trips, err := h.tripService.List(r.Context())
+	if err != nil {
+		log.Printf("[Trips] Failed to list trips: %v", err)
+		writeTripError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	writeTripJSON(w, http.StatusOK, trips)
+}
+
+// HandleGetTrip returns one trip with its image IDs.
+//
+//	@Summary		Get a trip
+//	@Tags			trips
+//	@Produce		json
+//	@Param			id	path		string					true	"Trip ID"
+//	@Success		200	{object}	models.Trip				"Trip"
+//	@Failure		404	{object}	models.ErrorResponse	"Trip not found"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503	{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/trips/{id} [get]
+func (h *Handler) HandleGetTrip(w http.ResponseWriter, r *http.Request) {
+	trip, err := h.tripService.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeTripError(w, r, err)
+		return
+	}
+	writeTripJSON(w, http.StatusOK, trip)
+}
+
+// HandleRenameTrip sets or clears a trip's manual name.
+//
+//	@Summary		Rename a trip
+//	@Description	Takes {"name": "..."}; the name is kept when trips are recomputed. A null or empty name restores the generated one.
+//	@Tags			trips
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string					true	"Trip ID"
+//	@Param			trip	body		models.TripInput		true	"New name"
+//	@Success		200		{object}	models.Trip				"Trip"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		404		{object}	models.ErrorResponse	"Trip not found"
+//	@Failure		413		{object}	models.ErrorResponse	"Request body too large"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/trips/{id} [patch]
+func (h *Handler) HandleRenameTrip(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var input models.TripInput
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTripBody)).Decode(&input); err != nil {
+		writeError(w, r, bodyErrorStatus(err), "Body must be a JSON object with a name")
+		return
+	}
+	trip, err := h.tripService.Rename(r.Context(), id, input)
+	if err != nil {
+		log.Printf("[Trips] Failed to rename trip %s: %v", id, err)
+		writeTripError(w, r, err)
+		return
+	}
+	log.Printf("[Trips] Renamed trip %s to %q", id, trip.Name)
+	writeTripJSON(w, http.StatusOK, trip)
+}
+
+// HandleTripImages returns a trip's images in takenAt order.
+//
+//	@Summary		List trip images
+//	@Description	Metadata of a trip's images in takenAt order, paginated over the trip's stored IDs. Images deleted since the trip was
+//	@Description	computed are skipped and counted in "missing", so a page can hold fewer than limit images.
+//	@Tags			trips
+//	@Produce		json
+//	@Param			id		path		string					true	"Trip ID"
+//	@Param			limit	query		int						false	"Images per page (default 100, max 1000, 0 for all)"
+//	@Param			page	query		int						false	"Page number (0-indexed)"
+//	@Success		200		{object}	models.TripImages		"Trip and page of images"
+//	@Failure		400		{object}	models.ErrorResponse	"Bad Request"
+//	@Failure		404		{object}	models.ErrorResponse	"Trip not found"
+//	@Failure		500		{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503		{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/trips/{id}/images [get]
+func (h *Handler) HandleTripImages(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	id := r.PathValue("id")
+	query := r.URL.Query()
+
+	limit := 100
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err != nil || parsedLimit < 0 {
+			writeError(w, r, http.StatusBadRequest, "Invalid limit parameter")
+			return
+		}
+		limit = parsedLimit
+	}
+
+	page := 0
+	if pageStr := query.Get("page"); pageStr != "" {
+		parsedPage, err := strconv.Atoi(pageStr)
+		if err != nil || parsedPage < 0 {
+			writeError(w, r, http.StatusBadRequest, "Invalid page parameter")
+			return
+		}
+		page = parsedPage
+	}
+
+	result, err := h.tripService.ListImages(r.Context(), id, limit, page)
+	if err != nil {
+		log.Printf("[Trips] Failed to list images of trip %s: %v", id, err)
+		writeTripError(w, r, err)
+		return
+	}
+
+	log.Printf("[Trips] Served %d images of trip %s (limit=%d, page=%d, missing=%d) in %v", len(result.Images), id, limit, page, result.Missing, time.Since(start))
+
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	writeTripJSON(w, http.StatusOK, result)
+}
+
+// HandleTripsRecompute segments the collection into trips again.
+//
+//	@Summary		Recompute trips
+//	@Description	Regroups every dated image into trips and replaces the stored trips. Trips keep their ID and manual name when most of
+//	@Description	their images end up in the same recomputed trip. Runs synchronously; a second request while one is running gets 409.
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	models.TripRecompute	"Recomputation summary"
+//	@Failure		409	{object}	models.ErrorResponse	"Recomputation already running"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal Server Error"
+//	@Failure		503	{object}	models.ErrorResponse	"Upstream Unavailable"
+//	@Security		ApiKeyAuth
+//	@Router			/admin/trips/recompute [post]
+func (h *Handler) HandleTripsRecompute(w http.ResponseWriter, r *http.Request) {
+	report, err := h.tripService.Recompute(r.Context())
+	if errors.Is(err, apperrors.ErrConflict) {
+		writeError(w, r, http.StatusConflict, "Trip recomputation already running")
+		return
+	}
+	if err != nil {
+		log.Printf("[Trips] Failed to recompute trips: %v", err)
+		writeTripError(w, r, err)
+		return
+	}
+
+	writeTripJSON(w, http.StatusOK, report)
+}
+
+// Writes a trip service error. Validation failures carry their reason, as for albums.
+func writeTripError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, apperrors.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "Trip not found")
+	case errors.Is(err, apperrors.ErrInvalidInput):
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	default:
+		writeServiceError(w, r, err)
+	}
+}
+
+// Encodes v as the JSON response body with the given status.
+func writeTripJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[Trips] Failed to encode response: %v", err)
+	}
+}
@@ -1,27 +1,60 @@
 package middleware
 
 import (
+	"context"
 	"crypto/subtle"
 	"net/http"
+	"strings"
+
+	"trekka-api/internal/models"
 )
 
+const anonymousKey contextKey = "anonymous"
+
+// Read-only paths anonymous callers may use in public mode.
+var publicPaths = map[string]bool{
+	"/image":       true,
+	"/images/list": true,
+}
+
+// Reports whether the request was let through without an API key in public mode. Handlers must
+// then restrict what they return to public images.
+func IsAnonymous(ctx context.Context) bool {
+	anonymous, _ := ctx.Value(anonymousKey).(bool)
+	return anonymous
+}
+
 // APIKeyAuth creates middleware that validates API key authentication.
 // It checks the X-API-Key header against a list of valid API keys using
 // constant-time comparison to prevent timing attacks.
-// Requests to /health are exempted from authentication.
-func APIKeyAuth(apiKeys []string) func(http.Handler) http.Handler {
+// Requests to /health, the /healthz and /readyz probes, and public link previews under /og/
+// are exempted from authentication. With publicMode, GET and HEAD requests to /image and
+// /images/list without a key are let through marked anonymous (see IsAnonymous); a wrong key
+// is still rejected.
+func APIKeyAuth(apiKeys []string, publicMode bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Exempt health check endpoint from authentication
-			if r.URL.Path == "/health" {
+			// Exempt health checks, orchestrator probes and link previews (fetched by unfurlers without keys) from authentication
+			if r.URL.Path == "/health" || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || strings.HasPrefix(r.URL.Path, "/og/") {
 				next.ServeHTTP(w, r)
 				return
 			}
 
 			// Get API key from header
 			key := r.Header.Get("X-API-Key")
+
+			publicPath := publicMode && publicPaths[r.URL.Path]
+			if publicPath {
+				// The same URL answers differently with and without a key, so shared caches must not mix them
+				w.Header().Add("Vary", "X-API-Key")
+			}
+			if key == "" && publicPath && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), anonymousKey, true)))
+				return
+			}
+
 			if key == "" {
-				http.Error(w, "Unauthorized: missing API key", http.StatusUnauthorized)
+				WriteError(w, r, http.StatusUnauthorized, models.ErrorCodeUnauthorized, "Unauthorized: missing API key")
 				return
 			}
 
@@ -35,7 +68,7 @@ func APIKeyAuth(apiKeys []string) func(http.Handler) http.Handler {
 			}
 
 			if !valid {
-				http.Error(w, "Unauthorized: invalid API key", http.StatusUnauthorized)
+				WriteError(w, r, http.StatusUnauthorized, models.ErrorCodeUnauthorized, "Unauthorized: invalid API key")
 				return
 			}
 
@@ -3,30 +3,107 @@ package middleware
 import (
 	"net/http"
 	"slices"
+	"strings"
 )
 
+// Default methods advertised when an origin has no explicit method list.
+var defaultCORSMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// Request headers browsers may send cross-origin; X-API-Key is how clients authenticate.
+const corsAllowedHeaders = "Content-Type, Authorization, X-API-Key, X-Request-ID"
+
+// Response headers scripts may read cross-origin (browsers hide all but a safelisted few otherwise).
+const corsExposedHeaders = "X-Geo-Location, X-Content-Type, X-Request-ID, X-Total-Count, X-Data-Staleness"
+
+// How long browsers may cache a preflight response, in seconds.
+const corsMaxAge = "86400"
+
+// OriginPolicy describes what a single allowed origin may do.
+type OriginPolicy struct {
+	Methods          []string // Allowed methods; empty means the default set
+	AllowCredentials bool     // Whether to send Access-Control-Allow-Credentials
+}
+
+// CORSOptions configures the CORS middleware.
+// When Origins is non-empty it takes precedence over AllowedOrigins,
+// giving each origin its own method set and credentials setting.
+type CORSOptions struct {
+	AllowedOrigins []string                // Simple list of origins ("*" allows all)
+	Origins        map[string]OriginPolicy // Structured per-origin policies
+}
+
 // Adds Cross-Origin Resource Sharing headers to HTTP responses.
-// It allows all origins (*) and common HTTP methods.
+// With a simple origin list, every allowed origin gets the same method set.
+// With per-origin policies, preflights only advertise the origin's own methods
+// and requests using other methods are rejected with 403.
 // Handles preflight OPTIONS requests automatically.
-func CORS(next http.Handler, allowedOrigins []string) http.Handler {
-	allowAll := len(allowedOrigins) == 1 && allowedOrigins[0] == "*"
+func CORS(next http.Handler, opts CORSOptions) http.Handler {
+	allowAll := len(opts.AllowedOrigins) == 1 && opts.AllowedOrigins[0] == "*"
+	structured := len(opts.Origins) > 0
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
-		if allowAll {
+		methods := defaultCORSMethods
+
+		switch {
+		case structured:
+			policy, ok := opts.Origins[origin]
+			if !ok {
+				break
+			}
+			if len(policy.Methods) > 0 {
+				methods = policy.Methods
+			}
+
+			// Reject methods this origin isn't allowed to use, whether announced in a preflight or sent directly
+			requested := r.Method
+			if r.Method == http.MethodOptions {
+				requested = r.Header.Get("Access-Control-Request-Method")
+			}
+			if requested != "" && requested != http.MethodOptions && !containsMethod(methods, requested) {
+				w.Header().Add("Vary", "Origin")
+				http.Error(w, "Method not allowed for origin", http.StatusForbidden)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			if policy.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		case allowAll:
 			w.Header().Set("Access-Control-Allow-Origin", "*")
-		} else if origin != "" && slices.Contains(allowedOrigins, origin) {
+		case origin != "" && slices.Contains(opts.AllowedOrigins, origin):
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Add("Vary", "Origin")
 		}
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(withOptions(methods), ", "))
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
 
 		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
 			w.WriteHeader(http.StatusOK)
 			return
 		}
 
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Reports whether method appears in methods, ignoring case.
+func containsMethod(methods []string, method string) bool {
+	return slices.ContainsFunc(methods, func(m string) bool {
+		return strings.EqualFold(m, method)
+	})
+}
+
+// Ensures OPTIONS is always advertised so preflights themselves are allowed.
+func withOptions(methods []string) []string {
+	if containsMethod(methods, http.MethodOptions) {
+		return methods
+	}
+	return append(slices.Clone(methods), http.MethodOptions)
+}
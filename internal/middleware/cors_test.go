@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Sends a request through CORS with opts and returns the recorded response and whether the
+// wrapped handler ran.
+func serveCORS(opts CORSOptions, method, origin, requestMethod string) (*httptest.ResponseRecorder, bool) {
+	called := false
+	handler := CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusNoContent)
+	}), opts)
+
+	req := httptest.NewRequest(method, "/images/list", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if requestMethod != "" {
+		req.Header.Set("Access-Control-Request-Method", requestMethod)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec, called
+}
+
+func TestCORSOriginPolicies(t *testing.T) {
+	list := CORSOptions{AllowedOrigins: []string{"https://app.example.com"}}
+	wildcard := CORSOptions{AllowedOrigins: []string{"*"}}
+	structured := CORSOptions{Origins: map[string]OriginPolicy{
+		"https://app.example.com":   {Methods: []string{"GET", "POST"}, AllowCredentials: true},
+		"https://admin.example.com": {},
+	}}
+
+	tests := []struct {
+		name          string
+		opts          CORSOptions
+		method        string
+		origin        string
+		requestMethod string
+
+		wantStatus      int
+		wantCalled      bool
+		wantOrigin      string
+		wantMethods     string
+		wantCredentials string
+		wantVary        string
+	}{
+		{
+			name: "listed origin", opts: list, method: "GET", origin: "https://app.example.com",
+			wantStatus: http.StatusNoContent, wantCalled: true, wantOrigin: "https://app.example.com",
+			wantMethods: "GET, POST, PUT, PATCH, DELETE, OPTIONS", wantVary: "Origin",
+		},
+		{
+			name: "unlisted origin", opts: list, method: "GET", origin: "https://evil.example.com",
+			wantStatus: http.StatusNoContent, wantCalled: true,
+			wantMethods: "GET, POST, PUT, PATCH, DELETE, OPTIONS",
+		},
+		{
+			name: "wildcard", opts: wildcard, method: "GET", origin: "https://anyone.example.com",
+			wantStatus: http.StatusNoContent, wantCalled: true, wantOrigin: "*",
+			wantMethods: "GET, POST, PUT, PATCH, DELETE, OPTIONS",
+		},
+		{
+			name: "structured origin with credentials", opts: structured, method: "POST", origin: "https://app.example.com",
+			wantStatus: http.StatusNoContent, wantCalled: true, wantOrigin: "https://app.example.com",
+			wantMethods: "GET, POST, OPTIONS", wantCredentials: "true", wantVary: "Origin",
+		},
+		{
+			name: "structured origin using the default methods", opts: structured, method: "DELETE", origin: "https://admin.example.com",
+			wantStatus: http.StatusNoContent, wantCalled: true, wantOrigin: "https://admin.example.com",
+			wantMethods: "GET, POST, PUT, PATCH, DELETE, OPTIONS", wantVary: "Origin",
+		},
+		{
+			name: "method the origin may not use", opts: structured, method: "DELETE", origin: "https://app.example.com",
+			wantStatus: http.StatusForbidden, wantVary: "Origin",
+		},
+		{
+			name: "method compared case-insensitively", opts: CORSOptions{Origins: map[string]OriginPolicy{
+				"https://app.example.com": {Methods: []string{"get"}},
+			}}, method: "GET", origin: "https://app.example.com",
+			wantStatus: http.StatusNoContent, wantCalled: true, wantOrigin: "https://app.example.com",
+			wantMethods: "get, OPTIONS", wantVary: "Origin",
+		},
+		{
+			name: "preflight for an allowed method", opts: structured, method: "OPTIONS", origin: "https://app.example.com", requestMethod: "POST",
+			wantStatus: http.StatusOK, wantOrigin: "https://app.example.com",
+			wantMethods: "GET, POST, OPTIONS", wantCredentials: "true", wantVary: "Origin",
+		},
+		{
+			name: "preflight for a disallowed method", opts: structured, method: "OPTIONS", origin: "https://app.example.com", requestMethod: "PUT",
+			wantStatus: http.StatusForbidden, wantVary: "Origin",
+		},
+		{
+			name: "structured policy ignores unknown origins", opts: structured, method: "GET", origin: "https://evil.example.com",
+			wantStatus: http.StatusNoContent, wantCalled: true,
+			wantMethods: "GET, POST, PUT, PATCH, DELETE, OPTIONS",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, called := serveCORS(tt.opts, tt.method, tt.origin, tt.requestMethod)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("handler called = %v, want %v", called, tt.wantCalled)
+			}
+			h := rec.Header()
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := h.Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Errorf("Allow-Methods = %q, want %q", got, tt.wantMethods)
+			}
+			if got := h.Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("Allow-Credentials = %q, want %q", got, tt.wantCredentials)
+			}
+			if got := h.Get("Vary"); got != tt.wantVary {
+				t.Errorf("Vary = %q, want %q", got, tt.wantVary)
+			}
+		})
+	}
+}
+
+func TestCORSExposedHeadersAndPreflightCache(t *testing.T) {
+	opts := CORSOptions{AllowedOrigins: []string{"*"}}
+
+	rec, _ := serveCORS(opts, "GET", "https://app.example.com", "")
+	if got := rec.Header().Get("Access-Control-Expose-Headers"); got != corsExposedHeaders {
+		t.Errorf("Expose-Headers = %q, want %q", got, corsExposedHeaders)
+	}
+	for _, header := range []string{"X-Geo-Location", "X-Total-Count", "X-Data-Staleness"} {
+		if !strings.Contains(corsExposedHeaders, header) {
+			t.Errorf("Expose-Headers is missing %s", header)
+		}
+	}
+	if got := rec.Header().Get("Access-Control-Max-Age"); got != "" {
+		t.Errorf("Max-Age on a normal request = %q, want none", got)
+	}
+
+	rec, _ = serveCORS(opts, "OPTIONS", "https://app.example.com", "GET")
+	if got := rec.Header().Get("Access-Control-Max-Age"); got != "86400" {
+		t.Errorf("preflight Max-Age = %q, want 86400", got)
+	}
+	if got := rec.Header().Get("Access-Control-Expose-Headers"); got != "" {
+		t.Errorf("preflight Expose-Headers = %q, want none", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, "X-API-Key") {
+		t.Errorf("preflight Allow-Headers = %q, want X-API-Key among them", got)
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"trekka-api/internal/models"
+)
+
+// WriteError writes the JSON error envelope shared by middleware and handlers, tagged with the
+// request ID from the context (set by RequestID) so clients can quote it when reporting problems.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	requestID, _ := r.Context().Value(RequestIDKey).(string)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(models.ErrorResponse{Error: models.ErrorBody{
+		Code:      code,
+		Message:   message,
+		RequestID: requestID,
+	}}); err != nil {
+		log.Printf("[Error] Failed to encode error response: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"trekka-api/internal/models"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+		want      string
+	}{
+		{"with request ID", "req-123", `{"error":{"code":"not_found","message":"File not found","requestId":"req-123"}}`},
+		{"without request ID", "", `{"error":{"code":"not_found","message":"File not found"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/image", nil)
+			if tt.requestID != "" {
+				req = req.WithContext(context.WithValue(req.Context(), RequestIDKey, tt.requestID))
+			}
+			rec := httptest.NewRecorder()
+
+			WriteError(rec, req, http.StatusNotFound, models.ErrorCodeNotFound, "File not found")
+
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("X-Content-Type-Options = %q, want nosniff", got)
+			}
+			if got := rec.Body.String(); got != tt.want+"\n" {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestIDReachesErrors(t *testing.T) {
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, http.StatusBadRequest, models.ErrorCodeInvalidInput, "Invalid request")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/image", nil)
+	req.Header.Set("X-Request-ID", "client-supplied")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var body models.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body.Error.RequestID != "client-supplied" {
+		t.Errorf("requestId = %q, want the caller's client-supplied", body.Error.RequestID)
+	}
+}
@@ -1,19 +1,29 @@
 package middleware
 
 import (
+	"context"
+	"log"
 	"net/http"
 	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
+
+	"trekka-api/internal/models"
 )
 
+// GlobalLimiter enforces a request budget shared across server instances.
+type GlobalLimiter interface {
+	Allow(ctx context.Context, key string) (bool, error)
+}
+
 // RateLimiter provides per-IP rate limiting
 type RateLimiter struct {
 	visitors map[string]*rate.Limiter
 	mu       sync.RWMutex
-	r        rate.Limit // requests per second
-	b        int        // burst size
+	r        rate.Limit    // requests per second
+	b        int           // burst size
+	global   GlobalLimiter // optional cross-instance limiter, consulted after the local check
 }
 
 // NewRateLimiter creates a new rate limiter
@@ -26,6 +36,14 @@ func NewRateLimiter(rps rate.Limit, burst int) *RateLimiter {
 	}
 }
 
+// WithGlobal adds a distributed limiter behind the in-memory one.
+// The local token bucket still rejects obvious floods without a network round trip;
+// requests it lets through are then counted against the shared budget.
+func (rl *RateLimiter) WithGlobal(global GlobalLimiter) *RateLimiter {
+	rl.global = global
+	return rl
+}
+
 // getVisitor returns the rate limiter for the given IP
 func (rl *RateLimiter) getVisitor(ip string) *rate.Limiter {
 	rl.mu.Lock()
@@ -59,10 +77,26 @@ func (rl *RateLimiter) Limit(next http.Handler) http.Handler {
 
 		limiter := rl.getVisitor(ip)
 		if !limiter.Allow() {
-			http.Error(w, "Rate limit exceeded. Try again later.", http.StatusTooManyRequests)
+			w.Header().Set("Retry-After", "1")
+			WriteError(w, r, http.StatusTooManyRequests, models.ErrorCodeRateLimited, "Rate limit exceeded. Try again later.")
 			return
 		}
 
+		if rl.global != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+			allowed, err := rl.global.Allow(ctx, ip)
+			cancel()
+			if err != nil {
+				// Fail open: a counter store outage shouldn't take the API down with it
+				log.Printf("[RateLimit] Global limiter unavailable, allowing request: %v", err)
+			} else if !allowed {
+				// The shared budget refills over the window, so back callers off longer than the per-second bucket
+				w.Header().Set("Retry-After", "5")
+				WriteError(w, r, http.StatusTooManyRequests, models.ErrorCodeRateLimited, "Rate limit exceeded. Try again later.")
+				return
+			}
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// GlobalLimiter answering every request with allowed and err, counting the calls.
+type fakeGlobalLimiter struct {
+	allowed bool
+	err     error
+	calls   int
+}
+
+func (l *fakeGlobalLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	l.calls++
+	return l.allowed, l.err
+}
+
+func TestRateLimiterConsultsGlobalLimiter(t *testing.T) {
+	tests := []struct {
+		name           string
+		global         *fakeGlobalLimiter
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{name: "within the shared budget", global: &fakeGlobalLimiter{allowed: true}, wantStatus: http.StatusNoContent},
+		{name: "over the shared budget", global: &fakeGlobalLimiter{allowed: false}, wantStatus: http.StatusTooManyRequests, wantRetryAfter: "5"},
+		{name: "store unavailable fails open", global: &fakeGlobalLimiter{err: errors.New("unavailable")}, wantStatus: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewRateLimiter(10, 20).WithGlobal(tt.global).Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/images/list", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+			if tt.global.calls != 1 {
+				t.Errorf("global limiter called %d times, want 1", tt.global.calls)
+			}
+		})
+	}
+}
+
+func TestRateLimiterPreFiltersLocally(t *testing.T) {
+	global := &fakeGlobalLimiter{allowed: true}
+	handler := NewRateLimiter(1, 2).WithGlobal(global).Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	var statuses []int
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/images/list", nil))
+		statuses = append(statuses, rec.Code)
+	}
+
+	// The burst of 2 passes; the third is rejected by the local bucket without a round trip
+	if statuses[2] != http.StatusTooManyRequests || statuses[0] != http.StatusNoContent || statuses[1] != http.StatusNoContent {
+		t.Errorf("statuses = %v, want [204 204 429]", statuses)
+	}
+	if global.calls != 2 {
+		t.Errorf("global limiter called %d times, want 2", global.calls)
+	}
+}
@@ -12,12 +12,16 @@ type contextKey string
 const RequestIDKey contextKey = "requestID"
 
 // RequestID creates middleware that generates a unique request ID for each request.
+// A well-formed X-Request-ID sent by the caller is reused so IDs propagate across services.
 // The request ID is added to the request context and included in the response headers
 // as X-Request-ID for easier debugging and request tracing.
 func RequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Generate unique request ID
-		requestID := uuid.New().String()
+		// Reuse the caller's request ID when it's safe to echo, otherwise generate one
+		requestID := r.Header.Get("X-Request-ID")
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
 
 		// Add request ID to context for use in handlers/services
 		ctx := context.WithValue(r.Context(), RequestIDKey, requestID)
@@ -29,3 +33,18 @@ func RequestID(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// Accepts short IDs made of characters that are safe in logs and headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
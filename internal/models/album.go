@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// AlbumSummary is the aggregate metadata stored on an album so clients can render it
+// without fetching every member.
+type AlbumSummary struct {
+	CoverFileName string    `firestore:"coverFileName,omitempty" json:"coverFileName,omitempty"`
+	CoverPinned   bool      `firestore:"coverPinned,omitempty" json:"coverPinned,omitempty"` // Cover was chosen manually and survives recomputation
+	MemberCount   int       `firestore:"memberCount" json:"memberCount"`
+	TakenFrom     time.Time `firestore:"takenFrom,omitempty" json:"takenFrom,omitempty"`
+	TakenTo       time.Time `firestore:"takenTo,omitempty" json:"takenTo,omitempty"`
+	Countries     []string  `firestore:"countries,omitempty" json:"countries,omitempty"` // Sorted, distinct
+}
+
+// Album is an explicit, ordered collection of images, stored in the albums collection.
+type Album struct {
+	Id           string       `firestore:"-" json:"id"`
+	Name         string       `firestore:"name" json:"name"`
+	Description  string       `firestore:"description,omitempty" json:"description,omitempty"`
+	CoverImageID string       `firestore:"coverImageId,omitempty" json:"coverImageId,omitempty"` // Chosen cover; must be a member
+	ImageIDs     []string     `firestore:"imageIds" json:"imageIds"`                             // Image document IDs in album order
+	Summary      AlbumSummary `firestore:"summary" json:"summary"`                               // Recomputed on every membership change
+	CreatedAt    time.Time    `firestore:"createdAt" json:"createdAt"`
+	UpdatedAt    time.Time    `firestore:"updatedAt" json:"updatedAt"`
+}
+
+// AlbumInput is the body of album create and update requests.
+// On update, omitted (null) fields are left unchanged; an empty coverImageId clears the cover.
+type AlbumInput struct {
+	Name         *string  `json:"name,omitempty"`
+	Description  *string  `json:"description,omitempty"`
+	CoverImageID *string  `json:"coverImageId,omitempty"`
+	ImageIDs     []string `json:"imageIds,omitempty"` // Replaces the whole list, in the order given
+}
+
+// AlbumImages is one page of an album's members, in album order.
+type AlbumImages struct {
+	Album   *Album           `json:"album"`
+	Images  []*ImageMetadata `json:"images"`
+	Total   int              `json:"total"`   // Number of references paged over: all but quarantined images, including missing ones
+	Missing int              `json:"missing"` // References on this page whose image no longer exists (skipped)
+}
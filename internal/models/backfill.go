@@ -0,0 +1,81 @@
+package models
+
+import "time"
+
+// BackfillReport is the outcome of one BackfillFromDrive run, file by file.
+type BackfillReport struct {
+	FolderID     string        `json:"folderId"`
+	SkipExisting bool          `json:"skipExisting"`
+	DryRun       bool          `json:"dryRun"` // Nothing was downloaded or written; Synced counts files that would have been
+	StartedAt    time.Time     `json:"startedAt"`
+	FinishedAt   time.Time     `json:"finishedAt"`
+	Listed       int           `json:"listed"` // Files in the folder listing
+	Synced       int           `json:"synced"`
+	Skipped      int           `json:"skipped"`
+	Failed       int           `json:"failed"`
+	Filtered     int           `json:"filtered"`          // Listed files left out by the request's filters; not in Files
+	Removed      []string      `json:"removed,omitempty"` // Files whose documents were removed as gone from Drive
+	Files        []*SyncResult `json:"files"`             // In listing order; files not reached before a cancellation are absent
+	Error        string        `json:"error,omitempty"`   // Why the run stopped early or reported failure
+}
+
+// Adds a file's result to the report and its counts.
+func (r *BackfillReport) Add(result *SyncResult) {
+	r.Files = append(r.Files, result)
+	switch {
+	case result.Status == SyncStatusFailed:
+		r.Failed++
+	case result.Skipped():
+		r.Skipped++
+	default:
+		r.Synced++
+	}
+}
+
+// Counts the files with each status, e.g. to break down Skipped.
+func (r *BackfillReport) StatusCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, result := range r.Files {
+		counts[result.Status]++
+	}
+	return counts
+}
+
+// Returns the results of the files that failed.
+func (r *BackfillReport) Failures() []*SyncResult {
+	var failed []*SyncResult
+	for _, result := range r.Files {
+		if result.Status == SyncStatusFailed {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// BackfillRequest is the optional body of POST /admin/backfill.
+type BackfillRequest struct {
+	SkipExisting *bool `json:"skipExisting,omitempty"` // Skip files already in Firestore; defaults to true
+	DryRun       bool  `json:"dryRun,omitempty"`       // Only report what each file would get
+
+	// Filters, checked against the listing before any download; each one given must hold
+	Include      []string   `json:"include,omitempty"`      // File name globs, e.g. "IMG_*"; the name must match one
+	Exclude      []string   `json:"exclude,omitempty"`      // File name globs, e.g. "Screenshot*"; the name must match none
+	Mime         []string   `json:"mime,omitempty"`         // MIME type prefixes, e.g. "video/"; the type must have one
+	CreatedAfter *time.Time `json:"createdAfter,omitempty"` // RFC 3339; only files created in Drive after it
+}
+
+// BackfillStarted is the response to POST /admin/backfill: the backfill runs in the background with
+// these options, and GET /admin/backfill reports how it went.
+type BackfillStarted struct {
+	Started      bool `json:"started"`
+	SkipExisting bool `json:"skipExisting"`
+	DryRun       bool `json:"dryRun"`
+}
+
+// BackfillStatus says whether a backfill is running and carries the latest finished one's report.
+type BackfillStatus struct {
+	Running  bool            `json:"running"`
+	Paused   bool            `json:"paused"`             // Watch ticks are skipped and backfills refused until resumed
+	PausedAt *time.Time      `json:"pausedAt,omitempty"` // When the sync was paused
+	Last     *BackfillReport `json:"last"`               // Null until a backfill has finished
+}
@@ -0,0 +1,61 @@
+package models
+
+import "net/http"
+
+// Machine-readable codes carried in ErrorResponse.
+const (
+	ErrorCodeInvalidInput         = "invalid_input"
+	ErrorCodeUnauthorized         = "unauthorized"
+	ErrorCodeNotFound             = "not_found"
+	ErrorCodeMethodNotAllowed     = "method_not_allowed"
+	ErrorCodeConflict             = "conflict"
+	ErrorCodeUnprocessable        = "unprocessable"
+	ErrorCodeTooLarge             = "too_large"
+	ErrorCodeUnsupportedMediaType = "unsupported_media_type"
+	ErrorCodeRangeNotSatisfiable  = "range_not_satisfiable"
+	ErrorCodeRateLimited          = "rate_limited"
+	ErrorCodeInternal             = "internal"
+	ErrorCodeUnavailable          = "unavailable"
+)
+
+// ErrorResponse is the body of every error response: {"error": {"code": ..., "message": ..., "requestId": ...}}.
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody describes a failed request.
+type ErrorBody struct {
+	Code      string `json:"code"`                // One of the ErrorCode constants
+	Message   string `json:"message"`             // Human-readable; not meant to be parsed
+	RequestID string `json:"requestId,omitempty"` // X-Request-ID of the request, for matching server logs
+}
+
+// Returns the error code conventionally used for an HTTP status.
+func ErrorCodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrorCodeInvalidInput
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrorCodeUnauthorized
+	case http.StatusNotFound:
+		return ErrorCodeNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrorCodeMethodNotAllowed
+	case http.StatusConflict:
+		return ErrorCodeConflict
+	case http.StatusUnprocessableEntity:
+		return ErrorCodeUnprocessable
+	case http.StatusRequestEntityTooLarge:
+		return ErrorCodeTooLarge
+	case http.StatusUnsupportedMediaType:
+		return ErrorCodeUnsupportedMediaType
+	case http.StatusRequestedRangeNotSatisfiable:
+		return ErrorCodeRangeNotSatisfiable
+	case http.StatusTooManyRequests:
+		return ErrorCodeRateLimited
+	case http.StatusServiceUnavailable:
+		return ErrorCodeUnavailable
+	default:
+		return ErrorCodeInternal
+	}
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestErrorCodeForStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{http.StatusBadRequest, ErrorCodeInvalidInput},
+		{http.StatusUnauthorized, ErrorCodeUnauthorized},
+		{http.StatusForbidden, ErrorCodeUnauthorized},
+		{http.StatusNotFound, ErrorCodeNotFound},
+		{http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed},
+		{http.StatusConflict, ErrorCodeConflict},
+		{http.StatusUnprocessableEntity, ErrorCodeUnprocessable},
+		{http.StatusRequestEntityTooLarge, ErrorCodeTooLarge},
+		{http.StatusUnsupportedMediaType, ErrorCodeUnsupportedMediaType},
+		{http.StatusRequestedRangeNotSatisfiable, ErrorCodeRangeNotSatisfiable},
+		{http.StatusTooManyRequests, ErrorCodeRateLimited},
+		{http.StatusServiceUnavailable, ErrorCodeUnavailable},
+		{http.StatusInternalServerError, ErrorCodeInternal},
+		{http.StatusBadGateway, ErrorCodeInternal},
+	}
+
+	for _, tt := range tests {
+		if got := ErrorCodeForStatus(tt.status); got != tt.want {
+			t.Errorf("ErrorCodeForStatus(%d) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// ExpectedFile is a filename registered as expected to arrive through the sync pipeline
+// (e.g. from a camera's DCIM index), used to spot shots that never got ingested.
+type ExpectedFile struct {
+	FileName     string    `firestore:"fileName" json:"fileName"`
+	RegisteredAt time.Time `firestore:"registeredAt" json:"registeredAt"`
+	IngestedAt   time.Time `firestore:"-" json:"ingestedAt,omitzero"` // CreatedAt of the matching document, in reports
+}
+
+// ExpectedFilesReport diffs registered expectations against the documents actually ingested.
+type ExpectedFilesReport struct {
+	Expected      int             `json:"expected"`
+	Satisfied     int             `json:"satisfied"`     // Ingested before the expectation was registered
+	SatisfiedLate []*ExpectedFile `json:"satisfiedLate"` // Ingested after the expectation was registered
+	Missing       []*ExpectedFile `json:"missing"`       // Never ingested
+	GeneratedAt   time.Time       `json:"generatedAt"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// GeotagMatch is a photo without GPS whose position was interpolated from a GPX track.
+type GeotagMatch struct {
+	Id          string      `json:"id"`
+	FileName    string      `json:"fileName"`
+	TakenAt     time.Time   `json:"takenAt"`
+	Coordinates Coordinates `json:"coordinates"`
+	GeoLocation string      `json:"geoLocation,omitempty"` // Empty in dry runs and when geocoding failed
+}
+
+// RegeocodeResult is the location stored for an image by a forced re-geocode.
+type RegeocodeResult struct {
+	Id          string      `json:"id"`
+	FileName    string      `json:"fileName"`
+	Coordinates Coordinates `json:"coordinates"`
+	Previous    string      `json:"previous,omitempty"` // geoLocation before the refresh
+	GeoLocation string      `json:"geoLocation"`
+	City        string      `json:"city,omitempty"`
+	Region      string      `json:"region,omitempty"`
+	Country     string      `json:"country,omitempty"`
+	CountryCode string      `json:"countryCode,omitempty"`
+	Town        string      `json:"town,omitempty"`
+	Village     string      `json:"village,omitempty"`
+	State       string      `json:"state,omitempty"`
+	County      string      `json:"county,omitempty"`
+	Changed     bool        `json:"changed"` // Whether geoLocation or any level of the hierarchy changed
+	UpdatedAt   time.Time   `json:"updatedAt"`
+}
+
+// GeotagReport summarises matching a GPX track against stored photos.
+type GeotagReport struct {
+	TrackStart  time.Time      `json:"trackStart"`
+	TrackEnd    time.Time      `json:"trackEnd"`
+	TrackPoints int            `json:"trackPoints"`
+	Candidates  int            `json:"candidates"` // Photos in the track's time range without coordinates
+	Matched     []*GeotagMatch `json:"matched"`
+	Unmatched   []string       `json:"unmatched"` // File names that fell in a gap longer than the max gap
+	Failed      int            `json:"failed"`    // Matches that could not be written
+	DryRun      bool           `json:"dryRun"`
+}
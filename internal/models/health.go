@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Values of HealthReport.Status and DependencyHealth.Status.
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+// HealthReport is the result of probing every backing service, as served by /health/deep.
+type HealthReport struct {
+	Status    string                      `json:"status"` // HealthOK only if every dependency is
+	Checks    map[string]DependencyHealth `json:"checks"` // Keyed by dependency: firestore, storage, drive
+	CheckedAt time.Time                   `json:"checkedAt"`
+}
+
+// DependencyHealth is the outcome of one dependency probe.
+type DependencyHealth struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
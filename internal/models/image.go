@@ -7,31 +7,138 @@ type Coordinates struct {
 	Lat string `firestore:"lat,omitempty" json:"lat,omitempty"`
 }
 
+// CacheEntry is one value held by CacheService: a signed URL (SignedURL set), raw bytes such as
+// a rendered thumbnail or an encoded list response (Data set), or a not-found tombstone.
 type CacheEntry struct {
-	SignedURL   string
+	SignedURL   *SignedURLEntry // Set for entries stored with SetSignedURL
+	Data        []byte          // Raw bytes for cached renditions (e.g. thumbnails)
+	NotFound    bool            // Tombstone stored with SetNotFound: the key was looked up and doesn't exist
+	ContentType string
+	FileName    string
+	Expires     time.Time
+}
+
+// SignedURLEntry is a cached signed URL with the metadata served alongside it.
+type SignedURLEntry struct {
+	URL         string
 	ContentType string
 	GeoLocation string
 	FileName    string
+	StoragePath string    // Lets a stale entry be re-signed without a Firestore lookup
+	Download    bool      // Signed with an attachment Content-Disposition (see ImageRequest.Download)
+	URLExpires  time.Time // When GCS stops accepting the URL; may be sooner than Expires
 	Expires     time.Time
 }
 
+type CacheStats struct {
+	Entries      int           `json:"entries"`      // Entries held, including expired ones awaiting cleanup
+	MaxEntries   int           `json:"maxEntries"`   // Cap on entries before least recently used ones are evicted (0 for no limit)
+	Evictions    uint64        `json:"evictions"`    // Entries evicted to stay under maxEntries since startup
+	Expired      int           `json:"expired"`      // Entries past their TTL (kept for stale fallback or until the next cleanup)
+	Hits         uint64        `json:"hits"`         // Lookups served from the cache since startup
+	Misses       uint64        `json:"misses"`       // Lookups that missed or found an expired entry since startup
+	ExpiredReads uint64        `json:"expiredReads"` // Misses that found an entry past its expiry (or a signed URL about to expire)
+	Sets         uint64        `json:"sets"`         // Entries stored (new or replaced) since startup
+	HitRatio     float64       `json:"hitRatio"`     // hits / (hits + misses), 0 before any lookup
+	ApproxBytes  int64         `json:"approxBytes"`  // Approximate size of keys, URLs and cached bytes
+	SignedURLs   URLCheckStats `json:"signedUrls"`
+}
+
+// URLCheckStats counts probes of cached signed URLs against GCS since startup.
+type URLCheckStats struct {
+	Checked     uint64 `json:"checked"`     // Probes made (sampled cache hits and client reports)
+	Broken      uint64 `json:"broken"`      // Probes GCS answered with 403 or 404
+	Regenerated uint64 `json:"regenerated"` // Broken URLs evicted and re-signed
+	Reports     uint64 `json:"reports"`     // Calls to /image/report-broken
+}
+
+// URLReport is the outcome of a client report that a signed URL failed.
+type URLReport struct {
+	FileName    string `json:"fileName"`
+	Broken      bool   `json:"broken"`      // GCS rejected the cached URL, which was evicted
+	Regenerated bool   `json:"regenerated"` // A fresh URL was signed and cached
+}
+
+// ExifDump is one page of the raw metadata tags of a stored file, for debugging extraction.
+type ExifDump struct {
+	Id          string            `json:"id"`
+	FileName    string            `json:"fileName"`
+	ContentType string            `json:"contentType"`
+	Total       int               `json:"total"`           // Tags in the file
+	Offset      int               `json:"offset"`          // Index of the first tag in this page (tags sorted by name)
+	Tags        map[string]string `json:"tags"`            // Tag name → value
+	Error       string            `json:"error,omitempty"` // Why no tags could be read (e.g. the file has no EXIF)
+}
+
 type ImageRequest struct {
-	Id       string
-	FileName string
+	Id         string
+	FileName   string
+	PublicOnly bool // Anonymous caller in public mode: only documents with VisibilityPublic are found
+	Download   bool // Sign the URL so browsers save the file (Content-Disposition: attachment) instead of showing it
 }
 
+// Values of ImageMetadata.Missing: the fields utils.HasEmptyFields checks, by their stored name.
+const (
+	MissingCoordinates   = "coordinates"
+	MissingTakenAt       = "takenAt"
+	MissingGeoLocation   = "geoLocation"
+	MissingFormattedDate = "formattedDate"
+)
+
+// Values of ImageMetadata.Visibility. An empty value means VisibilityPrivate.
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
 type ImageMetadata struct {
-	Id            string      `firestore:"id,omitempty"`
-	FileName      string      `firestore:"fileName"`
-	ContentType   string      `firestore:"contentType"`
-	Coordinates   Coordinates `firestore:"coordinates,omitempty"`
-	StoragePath   string      `firestore:"storagePath"`
-	GeoLocation   string      `firestore:"geoLocation,omitempty"`   // Format: "City, Country"
-	FormattedDate string      `firestore:"formattedDate,omitempty"` // Format: "Wednesday, 15 January 2025, 14:30"
-	Resolution    []float64   `firestore:"resolution,omitempty"`    // Format: [width, height]
-	TakenAt       time.Time   `firestore:"takenAt,omitempty"`       // Actual photo capture time from EXIF
-	CreatedAt     time.Time   `firestore:"createdAt,omitempty"`     // When record was created
-	UpdatedAt     time.Time   `firestore:"updatedAt,omitempty"`     // When record was updated
+	Id                 string      `firestore:"-"` // Document ID, set on every read; not stored so it can't go stale
+	FileName           string      `firestore:"fileName"`
+	FileNameLower      string      `firestore:"fileNameLower,omitempty"` // Lower-cased FileName for case-insensitive prefix search
+	ContentType        string      `firestore:"contentType"`
+	Coordinates        Coordinates `firestore:"coordinates,omitempty"`
+	StoragePath        string      `firestore:"storagePath"`
+	GeoLocation        string      `firestore:"geoLocation,omitempty"`        // Format: "City, Country"
+	City               string      `firestore:"city,omitempty"`               // Location hierarchy, each level empty when unknown
+	Region             string      `firestore:"region,omitempty"`             // State, district or county
+	Country            string      `firestore:"country,omitempty"`            // Country name
+	CountryCode        string      `firestore:"countryCode,omitempty"`        // ISO 3166-1 alpha-2, upper case
+	Town               string      `firestore:"town,omitempty"`               // Levels as the geocoder named them (see LocationParts), each empty when not named
+	Village            string      `firestore:"village,omitempty"`            // Village, as above
+	State              string      `firestore:"state,omitempty"`              // State or province, as above
+	County             string      `firestore:"county,omitempty"`             // County, as above
+	FormattedDate      string      `firestore:"formattedDate,omitempty"`      // Format: "Wednesday, 15 January 2025, 14:30"
+	Resolution         []float64   `firestore:"resolution,omitempty"`         // Format: [width, height]
+	TakenAt            time.Time   `firestore:"takenAt,omitempty"`            // Actual photo capture time from EXIF
+	CreatedAt          time.Time   `firestore:"createdAt,omitempty"`          // When record was created
+	UpdatedAt          time.Time   `firestore:"updatedAt,omitempty"`          // When record was updated
+	DriveFileID        string      `firestore:"driveFileId,omitempty"`        // Source Google Drive file ID (Drive-synced media only)
+	ContentHash        string      `firestore:"contentHash,omitempty"`        // SHA-256 of the stored file bytes
+	SourceChecksum     string      `firestore:"sourceChecksum,omitempty"`     // Drive's MD5 of the source file when last synced (before any conversion)
+	SourceModifiedTime time.Time   `firestore:"sourceModifiedTime,omitempty"` // Drive's modifiedTime of the source file when last synced
+	DominantColor      string      `firestore:"dominantColor,omitempty"`      // Format: "#rrggbb" (poster frame for videos)
+	PlaceKey           string      `firestore:"placeKey,omitempty"`           // Coordinates snapped to a ~100m grid (see utils.PlaceKey)
+	GeoLocationSource  string      `firestore:"geoLocationSource,omitempty"`  // "direct" or "propagated" (trip-mode geocoding)
+	CoordinatesSource  string      `firestore:"coordinatesSource,omitempty"`  // "gpx" when interpolated from a track; empty for EXIF GPS
+	Geohash            string      `firestore:"geohash,omitempty"`            // Geohash of Coordinates (see utils.GeohashPrecision)
+	Favorite           bool        `firestore:"favorite,omitempty"`           // Marked as a favorite via /images/{id}/favorite
+	Description        string      `firestore:"description,omitempty"`        // Caption, user-written or the Drive file's description (see SourceDescriptionHash)
+	Visibility         string      `firestore:"visibility,omitempty"`         // VisibilityPublic to serve anonymously (/og, and /image in public mode); empty means private
+	HasDescription     bool        `firestore:"hasDescription,omitempty"`     // Description is non-empty (lets listings filter with an equality query)
+	Status             string      `firestore:"status,omitempty"`             // StatusQuarantined or StatusDeleted; empty otherwise
+	LegacyIDs          []string    `firestore:"legacyIds,omitempty"`          // Random document IDs this record was migrated from
+	Missing            []string    `firestore:"missing,omitempty"`            // Missing* fields that are empty, so incomplete documents can be queried
+	Revision           time.Time   `firestore:"-"`                            // Document update time when read; pass back to ReplaceImageMetadataAt
+
+	// What else the Drive file carried when last synced. Sync copies a changed description into
+	// Description unless the caption there was set by hand (its hash doesn't match).
+	SourceDescriptionHash string            `firestore:"sourceDescriptionHash,omitempty"` // SHA-256 of its description (empty if it had none)
+	SourceProperties      map[string]string `firestore:"sourceProperties,omitempty"`      // Its appProperties
+}
+
+// Reports whether the image is left out of listings: quarantined or soft-deleted.
+func (m *ImageMetadata) Hidden() bool {
+	return m.Status == StatusQuarantined || m.Status == StatusDeleted
 }
 
 type ImageResponse struct {
@@ -41,3 +148,190 @@ type ImageResponse struct {
 	Coordinates Coordinates `json:"coordinates,omitzero"`
 	Size        int         `json:"size"`
 }
+
+// LocationParts is a reverse-geocoded location split into its hierarchy. City and Region are the
+// levels listings filter on: the most specific settlement (city, town or village) and the state
+// or, failing that, county. Town, Village, State and County are the levels the geocoder named
+// themselves, so a place can be told apart as a town or a village, and are empty when not named.
+type LocationParts struct {
+	City        string `json:"city,omitempty"`
+	Region      string `json:"region,omitempty"`
+	Country     string `json:"country,omitempty"`
+	CountryCode string `json:"countryCode,omitempty"`
+	Town        string `json:"town,omitempty"`
+	Village     string `json:"village,omitempty"`
+	State       string `json:"state,omitempty"`
+	County      string `json:"county,omitempty"`
+}
+
+// Returns the document's location hierarchy.
+func (m *ImageMetadata) LocationParts() LocationParts {
+	return LocationParts{
+		City:        m.City,
+		Region:      m.Region,
+		Country:     m.Country,
+		CountryCode: m.CountryCode,
+		Town:        m.Town,
+		Village:     m.Village,
+		State:       m.State,
+		County:      m.County,
+	}
+}
+
+// Reports whether the location names any of the levels geocoders return beyond City, Region and
+// the country, i.e. it was resolved since those were kept.
+func (p LocationParts) HasDetails() bool {
+	return p.Town != "" || p.Village != "" || p.State != "" || p.County != ""
+}
+
+// GeocodeCacheEntry is a reverse-geocoding result kept in the geocodeCache collection, so lookups
+// survive restarts. Documents are keyed like the in-memory cache (PlaceKey or rounded lat/lng).
+type GeocodeCacheEntry struct {
+	City        string    `firestore:"city,omitempty"`
+	Region      string    `firestore:"region,omitempty"`
+	Country     string    `firestore:"country,omitempty"`
+	CountryCode string    `firestore:"countryCode,omitempty"`
+	Town        string    `firestore:"town,omitempty"`
+	Village     string    `firestore:"village,omitempty"`
+	State       string    `firestore:"state,omitempty"`
+	County      string    `firestore:"county,omitempty"`
+	Version     int       `firestore:"version,omitempty"` // GeocodeCacheVersion when stored; 0 before Town, Village, State and County were kept
+	FetchedAt   time.Time `firestore:"fetchedAt"`         // When the geocoder returned it; older than the max age counts as a miss
+}
+
+// Version of the GeocodeCacheEntry fields written now.
+const GeocodeCacheVersion = 1
+
+// Returns the cached location hierarchy.
+func (e *GeocodeCacheEntry) LocationParts() LocationParts {
+	return LocationParts{
+		City:        e.City,
+		Region:      e.Region,
+		Country:     e.Country,
+		CountryCode: e.CountryCode,
+		Town:        e.Town,
+		Village:     e.Village,
+		State:       e.State,
+		County:      e.County,
+	}
+}
+
+// ImageFilter narrows a listing to one branch of the location hierarchy and/or by user-set flags.
+// Zero fields match anything.
+type ImageFilter struct {
+	Country        string // Country code (2 letters) or name
+	Region         string
+	City           string
+	Favorite       bool // Only images marked as favorites
+	HasDescription bool // Only images with a description
+	PublicOnly     bool // Only images with VisibilityPublic (anonymous callers in public mode)
+}
+
+// ImageMetadataPatch is the body of PATCH /images/{id}: the user-editable fields, nil when unchanged.
+type ImageMetadataPatch struct {
+	Description *string `json:"description"` // Empty clears it
+	Visibility  *string `json:"visibility"`  // VisibilityPublic or VisibilityPrivate
+}
+
+// FieldChange is one stored field altered by re-extracting an image's metadata, named as in Firestore.
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// MetadataRefresh is the outcome of re-extracting an image's metadata from its stored file.
+type MetadataRefresh struct {
+	Id       string         `json:"id"`
+	FileName string         `json:"fileName"`
+	DryRun   bool           `json:"dryRun"`
+	Updated  bool           `json:"updated"` // Whether the document was written (never in a dry run, nor when nothing changed)
+	Changes  []FieldChange  `json:"changes"`
+	Metadata *ImageMetadata `json:"metadata"` // The record as stored, or as it would be stored in a dry run
+}
+
+// LocationNode is one level of the location tree (country, region or city) with the number of images under it.
+// Images with a partial hierarchy count towards the levels they have, so children can sum to less than Count.
+type LocationNode struct {
+	Name     string          `json:"name"`
+	Code     string          `json:"code,omitempty"` // Country code, on country nodes
+	Count    int             `json:"count"`
+	Children []*LocationNode `json:"children,omitempty"`
+}
+
+// LocationTree is the country → region → city hierarchy of the collection, largest first.
+type LocationTree struct {
+	Total       int             `json:"total"`
+	Unlocated   int             `json:"unlocated"` // Images with no country stored
+	Countries   []*LocationNode `json:"countries"`
+	GeneratedAt time.Time       `json:"generatedAt"`
+}
+
+// CountryVisit summarises the images taken in one country.
+type CountryVisit struct {
+	Name         string    `json:"name"`
+	Code         string    `json:"code,omitempty"`
+	Count        int       `json:"count"`
+	FirstTakenAt time.Time `json:"firstTakenAt,omitzero"` // Earliest takenAt in the country; zero if none of its images has one
+	LastTakenAt  time.Time `json:"lastTakenAt,omitzero"`  // Latest takenAt in the country
+}
+
+// CountriesVisited lists every country the collection has images from, largest first.
+type CountriesVisited struct {
+	Total       int             `json:"total"`     // Images in the listed countries
+	Unlocated   int             `json:"unlocated"` // Images with no country, left out of the list
+	Countries   []*CountryVisit `json:"countries"`
+	GeneratedAt time.Time       `json:"generatedAt"`
+}
+
+// Place groups images whose coordinates snap to the same PlaceKey grid cell.
+type Place struct {
+	PlaceKey       string      `json:"placeKey"`
+	Count          int         `json:"count"`
+	GeoLocation    string      `json:"geoLocation,omitempty"`
+	Coordinates    Coordinates `json:"coordinates"`    // Representative photo's coordinates
+	Representative string      `json:"representative"` // FileName of the most recently taken photo
+}
+
+// NearbyImage is an image returned by a proximity query, with its distance from the query point.
+type NearbyImage struct {
+	*ImageMetadata
+	DistanceMeters float64 `json:"distanceMeters"`
+}
+
+// Cluster groups geotagged images sharing a geohash cell at a map zoom level.
+type Cluster struct {
+	Geohash        string  `json:"geohash"`
+	Count          int     `json:"count"`
+	Lat            float64 `json:"lat"` // Centroid of the members' coordinates
+	Lng            float64 `json:"lng"`
+	Representative string  `json:"representative"` // FileName of the most recently taken photo
+}
+
+// SignedImageURL is one entry of a batch signed-URL response. Error is set instead of the
+// other fields when that file could not be resolved.
+type SignedImageURL struct {
+	SignedURL   string `json:"signedUrl,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	GeoLocation string `json:"geoLocation,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ImageStats summarises the collection for dashboards and data-quality checks.
+type ImageStats struct {
+	Total       int            `json:"total"`
+	Photos      int            `json:"photos"`
+	Videos      int            `json:"videos"`
+	ByCountry   map[string]int `json:"byCountry"` // Country parsed from GeoLocation ("Unknown" if none)
+	ByYear      map[string]int `json:"byYear"`    // Year of TakenAt ("Unknown" if unset)
+	Missing     MissingStats   `json:"missing"`
+	GeneratedAt time.Time      `json:"generatedAt"`
+}
+
+// MissingStats breaks down documents with incomplete metadata.
+type MissingStats struct {
+	Incomplete  int `json:"incomplete"` // Documents failing utils.HasEmptyFields
+	Coordinates int `json:"coordinates"`
+	GeoLocation int `json:"geoLocation"`
+	TakenAt     int `json:"takenAt"`
+}
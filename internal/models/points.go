@@ -0,0 +1,102 @@
+package models
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// MapPoint is the minimum needed to plot a photo. Coordinates are float32 (~1m precision)
+// so the JSON and binary encodings carry exactly the same values. It encodes to JSON as a
+// compact [id, lat, lng, takenAtEpochSeconds] tuple.
+type MapPoint struct {
+	Id      string
+	Lat     float32
+	Lng     float32
+	TakenAt int64 // Unix seconds; 0 when unknown
+}
+
+func (p MapPoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{p.Id, p.Lat, p.Lng, p.TakenAt})
+}
+
+func (p *MapPoint) UnmarshalJSON(data []byte) error {
+	var tuple []json.RawMessage
+	if err := json.Unmarshal(data, &tuple); err != nil {
+		return err
+	}
+	if len(tuple) != 4 {
+		return fmt.Errorf("map point: expected 4 elements, got %d", len(tuple))
+	}
+	for i, dst := range []any{&p.Id, &p.Lat, &p.Lng, &p.TakenAt} {
+		if err := json.Unmarshal(tuple[i], dst); err != nil {
+			return fmt.Errorf("map point element %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Media type of the binary map point encoding.
+const PointsBinaryContentType = "application/octet-stream"
+
+// Encodes map points compactly, little-endian: a uint32 count, then per point float32 lat,
+// float32 lng, uint32 takenAt (Unix seconds, 0 if unknown), uint8 id length and the id bytes.
+// Ids longer than 255 bytes are truncated (Firestore auto-IDs are 20).
+func EncodePointsBinary(points []MapPoint) []byte {
+	size := 4
+	for _, p := range points {
+		size += 13 + min(len(p.Id), math.MaxUint8)
+	}
+
+	buf := make([]byte, 0, size)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(points)))
+	for _, p := range points {
+		id := p.Id
+		if len(id) > math.MaxUint8 {
+			id = id[:math.MaxUint8]
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(p.Lat))
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(p.Lng))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(p.TakenAt))
+		buf = append(buf, byte(len(id)))
+		buf = append(buf, id...)
+	}
+	return buf
+}
+
+// Decodes the output of EncodePointsBinary.
+func DecodePointsBinary(data []byte) ([]MapPoint, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("points: truncated header")
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+
+	// Every point takes at least 13 bytes, so a bogus count can't force a huge allocation
+	if uint64(count)*13 > uint64(len(data)) {
+		return nil, fmt.Errorf("points: count %d exceeds body size", count)
+	}
+
+	points := make([]MapPoint, 0, count)
+	for i := uint32(0); i < count; i++ {
+		if len(data) < 13 {
+			return nil, fmt.Errorf("points: truncated at point %d", i)
+		}
+		idLen := int(data[12])
+		if len(data) < 13+idLen {
+			return nil, fmt.Errorf("points: truncated id at point %d", i)
+		}
+		points = append(points, MapPoint{
+			Lat:     math.Float32frombits(binary.LittleEndian.Uint32(data[0:])),
+			Lng:     math.Float32frombits(binary.LittleEndian.Uint32(data[4:])),
+			TakenAt: int64(binary.LittleEndian.Uint32(data[8:])),
+			Id:      string(data[13 : 13+idLen]),
+		})
+		data = data[13+idLen:]
+	}
+	if len(data) != 0 {
+		return nil, fmt.Errorf("points: %d trailing bytes", len(data))
+	}
+	return points, nil
+}
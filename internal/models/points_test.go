@@ -0,0 +1,114 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Builds n points with 20-character IDs (the length of Firestore auto-IDs) spread over the globe.
+func randomPoints(n int) []MapPoint {
+	r := rand.New(rand.NewPCG(1, 2))
+	points := make([]MapPoint, n)
+	for i := range points {
+		points[i] = MapPoint{
+			Id:  fmt.Sprintf("%020d", r.Uint64()%1e18),
+			Lat: float32(r.Float64()*180 - 90),
+			Lng: float32(r.Float64()*360 - 180),
+		}
+		if i%10 != 0 { // Some photos have no takenAt
+			points[i].TakenAt = 1_500_000_000 + r.Int64N(300_000_000)
+		}
+	}
+	return points
+}
+
+func TestPointEncodingsAgree(t *testing.T) {
+	points := randomPoints(10_000)
+
+	jsonData, err := json.Marshal(points)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var fromJSON []MapPoint
+	if err := json.Unmarshal(jsonData, &fromJSON); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	binaryData := EncodePointsBinary(points)
+	fromBinary, err := DecodePointsBinary(binaryData)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	if !reflect.DeepEqual(fromJSON, points) {
+		t.Error("JSON round trip changed the points")
+	}
+	if !reflect.DeepEqual(fromBinary, points) {
+		t.Error("binary round trip changed the points")
+	}
+
+	// 4-byte count, then 13 bytes and the ID per point
+	if want := 4 + len(points)*(13+20); len(binaryData) != want {
+		t.Errorf("binary encoding is %d bytes, want %d", len(binaryData), want)
+	}
+	if len(binaryData) >= len(jsonData) {
+		t.Errorf("binary encoding (%d bytes) is no smaller than JSON (%d bytes)", len(binaryData), len(jsonData))
+	}
+	t.Logf("10k points: %d bytes JSON, %d bytes binary", len(jsonData), len(binaryData))
+}
+
+func TestMapPointJSONShape(t *testing.T) {
+	data, err := json.Marshal(MapPoint{Id: "img-1", Lat: 38.5, Lng: -9.25, TakenAt: 1717243200})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if got, want := string(data), `["img-1",38.5,-9.25,1717243200]`; got != want {
+		t.Errorf("JSON = %s, want %s", got, want)
+	}
+
+	for _, bad := range []string{`["img-1",38.5,-9.25]`, `{"id":"img-1"}`, `["img-1","north",-9.25,0]`} {
+		var p MapPoint
+		if err := json.Unmarshal([]byte(bad), &p); err == nil {
+			t.Errorf("Unmarshal(%s) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestPointsBinaryEdgeCases(t *testing.T) {
+	empty := EncodePointsBinary(nil)
+	if points, err := DecodePointsBinary(empty); err != nil || len(points) != 0 {
+		t.Errorf("empty encoding decoded to %v, %v; want no points", points, err)
+	}
+
+	long := strings.Repeat("x", 300)
+	points, err := DecodePointsBinary(EncodePointsBinary([]MapPoint{{Id: long}}))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if points[0].Id != long[:255] {
+		t.Errorf("long ID decoded to %d bytes, want it truncated to 255", len(points[0].Id))
+	}
+
+	valid := EncodePointsBinary([]MapPoint{{Id: "img-1", Lat: 1, Lng: 2}})
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated header", valid[:3]},
+		{"count beyond the body", append([]byte{0xff, 0xff, 0xff, 0x00}, valid[4:]...)},
+		{"truncated point", valid[:10]},
+		{"truncated ID", valid[:len(valid)-1]},
+		{"trailing bytes", append(append([]byte{}, valid...), 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodePointsBinary(tt.data); err == nil {
+				t.Error("decoded without an error")
+			}
+		})
+	}
+}
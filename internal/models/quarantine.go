@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Value of ImageMetadata.Status for files moved out of the way after repeated processing failures.
+const StatusQuarantined = "quarantined"
+
+// SyncFailure counts consecutive processing failures of one source file, and records when it was
+// quarantined once the retry budget ran out.
+type SyncFailure struct {
+	FileName      string    `firestore:"fileName" json:"fileName"`                              // Name in Drive (or in the bucket for refresh passes)
+	Attempts      int       `firestore:"attempts" json:"attempts"`                              // Consecutive failures since the last success
+	LastError     string    `firestore:"lastError" json:"lastError"`                            // Most recent failure
+	LastFailedAt  time.Time `firestore:"lastFailedAt" json:"lastFailedAt"`                      // When it last failed
+	QuarantinedAt time.Time `firestore:"quarantinedAt,omitempty" json:"quarantinedAt,omitzero"` // Zero until quarantined
+	StoragePath   string    `firestore:"storagePath,omitempty" json:"storagePath,omitempty"`    // Object path before quarantine, if one was moved
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Value of ImageMetadata.Status for documents soft-deleted because their stored object is gone.
+const StatusDeleted = "deleted"
+
+// ReconcileReport compares the bucket's media objects with the collection.
+type ReconcileReport struct {
+	Objects               int                   `json:"objects"`               // Media objects listed (derived/, quarantine/ and _doctor/ excluded)
+	Documents             int                   `json:"documents"`             // Documents checked (soft-deleted ones excluded)
+	OrphanObjects         []OrphanObject        `json:"orphanObjects"`         // Objects no document points at
+	DanglingDocuments     []DanglingDocument    `json:"danglingDocuments"`     // Documents whose storagePath has no object
+	ContentTypeMismatches []ContentTypeMismatch `json:"contentTypeMismatches"` // Documents whose contentType differs from their object's
+	Fix                   bool                  `json:"fix"`                   // Whether orphans and dangling documents were fixed
+	Created               []string              `json:"created,omitempty"`     // Object paths given a document by the fix
+	SoftDeleted           []string              `json:"softDeleted,omitempty"` // Document IDs marked StatusDeleted by the fix
+	FixErrors             []string              `json:"fixErrors,omitempty"`   // Fixes that failed, one line each
+	DurationMs            int64                 `json:"durationMs"`
+}
+
+// OrphanObject is a stored object without a document, e.g. left by a failed persist.
+type OrphanObject struct {
+	StoragePath string    `json:"storagePath"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	Updated     time.Time `json:"updated"`
+}
+
+// DanglingDocument is a document whose storagePath no longer exists, e.g. after a rename.
+type DanglingDocument struct {
+	Id          string `json:"id"`
+	FileName    string `json:"fileName"`
+	StoragePath string `json:"storagePath"`
+}
+
+// ContentTypeMismatch is a document whose contentType disagrees with its object's.
+type ContentTypeMismatch struct {
+	Id                string `json:"id"`
+	StoragePath       string `json:"storagePath"`
+	DocumentType      string `json:"documentType"`
+	ObjectContentType string `json:"objectContentType"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// Values of SyncRun.Trigger: what started the run.
+const (
+	SyncTriggerStartup = "startup" // The backfill DRIVE_BACKFILL_ON_STARTUP runs
+	SyncTriggerWatch   = "watch"   // A tick of the background watch
+	SyncTriggerManual  = "manual"  // POST /admin/backfill or update-metadata -backfill
+)
+
+// Values of SyncRun.Mode: how the run found the files to sync.
+const (
+	SyncModeBackfill = "backfill" // Listed the whole folder
+	SyncModeChanges  = "changes"  // Asked the Changes API what changed since the last run
+	SyncModePoll     = "poll"     // Listed the folder for files changed since the last check
+)
+
+// Error messages a SyncRun keeps; later failures are only counted.
+const MaxSyncRunErrors = 10
+
+// SyncRun is one backfill or watch tick as kept in the syncRuns collection, newest first.
+type SyncRun struct {
+	Id         string    `firestore:"-" json:"id"`
+	FolderID   string    `firestore:"folderId" json:"folderId"`
+	Trigger    string    `firestore:"trigger" json:"trigger"` // One of the SyncTrigger values
+	Mode       string    `firestore:"mode" json:"mode"`       // One of the SyncMode values
+	StartedAt  time.Time `firestore:"startedAt" json:"startedAt"`
+	FinishedAt time.Time `firestore:"finishedAt" json:"finishedAt"`
+	Synced     int       `firestore:"synced" json:"synced"`
+	Skipped    int       `firestore:"skipped" json:"skipped"`
+	Failed     int       `firestore:"failed" json:"failed"`
+	Errors     []string  `firestore:"errors,omitempty" json:"errors,omitempty"` // "<file>: <error>" of the first MaxSyncRunErrors failed files
+	Error      string    `firestore:"error,omitempty" json:"error,omitempty"`   // Why the run stopped early or reported failure
+}
+
+// Counts a file's result towards the run, keeping its error if it failed and there is room.
+func (r *SyncRun) Add(result *SyncResult) {
+	switch {
+	case result.Status == SyncStatusFailed:
+		r.Failed++
+		if len(r.Errors) < MaxSyncRunErrors {
+			r.Errors = append(r.Errors, result.FileName+": "+result.Reason)
+		}
+	case result.Skipped():
+		r.Skipped++
+	default:
+		r.Synced++
+	}
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// DriveSyncState is what incremental Drive sync keeps between runs, one document per synced folder.
+type DriveSyncState struct {
+	FolderID  string    `firestore:"folderId" json:"folderId"`
+	PageToken string    `firestore:"pageToken" json:"pageToken"` // Changes API position; changes after it are still to be processed
+	UpdatedAt time.Time `firestore:"updatedAt" json:"updatedAt"`
+
+	// When the polling watch (used when the Changes API isn't available) last found every new or
+	// modified file synced; the next poll looks for files changed after it. Zero until the first.
+	LastCheck time.Time `firestore:"lastCheck" json:"lastCheck,omitempty"`
+
+	// Drive file IDs of synced files found gone from the folder by one deletion pass, with when;
+	// the next pass removes those still gone and forgets the rest.
+	Missing map[string]time.Time `firestore:"missing,omitempty" json:"missing,omitempty"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Values of Timeline.Granularity.
+const (
+	TimelineDay   = "day"
+	TimelineMonth = "month"
+)
+
+// Key of the bucket holding images without a capture time. It always comes last.
+const TimelineUnknown = "unknown"
+
+// Timeline is one page of date buckets, newest first, as served by /images/timeline.
+type Timeline struct {
+	Granularity string            `json:"granularity"`
+	Buckets     []*TimelineBucket `json:"buckets"`
+	NextCursor  string            `json:"nextCursor,omitempty"` // Pass as cursor for the next page; empty on the last page
+}
+
+// TimelineBucket groups the images taken on one day or in one month.
+type TimelineBucket struct {
+	Key    string          `json:"key"`    // "2025-01-15" (day), "2025-01" (month) or TimelineUnknown
+	Count  int             `json:"count"`  // Every image in the bucket, not just those listed
+	Images []TimelineImage `json:"images"` // The bucket's newest images, at most per
+}
+
+// TimelineImage is the summary of an image shown in a timeline bucket.
+type TimelineImage struct {
+	Id            string    `json:"id"`
+	FileName      string    `json:"fileName"`
+	ContentType   string    `json:"contentType"`
+	TakenAt       time.Time `json:"takenAt,omitzero"`
+	GeoLocation   string    `json:"geoLocation,omitempty"`
+	DominantColor string    `json:"dominantColor,omitempty"`
+}
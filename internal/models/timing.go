@@ -0,0 +1,108 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Names of the ingest stages timed in SyncTimings.
+const (
+	StageDownload = "download"
+	StageConvert  = "convert"
+	StageUpload   = "upload"
+	StageExtract  = "extract"
+	StageGeocode  = "geocode"
+	StagePersist  = "persist"
+)
+
+// SyncStages lists the ingest stages in pipeline order.
+var SyncStages = []string{StageDownload, StageConvert, StageUpload, StageExtract, StageGeocode, StagePersist}
+
+// SyncTimings records how long each ingest stage took for one file. Stages that did not run are zero.
+type SyncTimings struct {
+	Download time.Duration `json:"download"` // Drive download
+	Convert  time.Duration `json:"convert"`  // HEIC → JPEG
+	Upload   time.Duration `json:"upload"`   // Storage upload
+	Extract  time.Duration `json:"extract"`  // EXIF/MP4 parsing and dominant color, excluding geocoding
+	Geocode  time.Duration `json:"geocode"`  // Place lookup and reverse geocoding
+	Persist  time.Duration `json:"persist"`  // Firestore write
+}
+
+// Returns the duration recorded for a stage name, or zero for unknown stages.
+func (t SyncTimings) Stage(stage string) time.Duration {
+	switch stage {
+	case StageDownload:
+		return t.Download
+	case StageConvert:
+		return t.Convert
+	case StageUpload:
+		return t.Upload
+	case StageExtract:
+		return t.Extract
+	case StageGeocode:
+		return t.Geocode
+	case StagePersist:
+		return t.Persist
+	}
+	return 0
+}
+
+// Returns the sum of all stage durations.
+func (t SyncTimings) Total() time.Duration {
+	return t.Download + t.Convert + t.Upload + t.Extract + t.Geocode + t.Persist
+}
+
+// Values of SyncResult.Status.
+const (
+	SyncStatusSynced             = "synced"
+	SyncStatusSkippedExisting    = "skipped_existing"    // In Firestore, and the sync was asked to skip existing files
+	SyncStatusSkippedComplete    = "skipped_complete"    // In Firestore with complete metadata
+	SyncStatusSkippedNonMedia    = "skipped_nonmedia"    // Neither an image nor a video
+	SyncStatusSkippedQuarantined = "skipped_quarantined" // Set aside after failing repeatedly
+	SyncStatusSkippedNative      = "skipped_native"      // A Google Doc, Sheet, Form or other Google-native file
+	SyncStatusSkippedShortcut    = "skipped_shortcut"    // A shortcut whose target is gone, unreadable or behind too many shortcuts
+	SyncStatusFailed             = "failed"
+	SyncStatusWouldSync          = "would_sync" // Dry run: would have been synced
+)
+
+// SyncResult describes the outcome of syncing one Drive file.
+type SyncResult struct {
+	FileName string        `json:"fileName"`
+	Shortcut string        `json:"shortcut,omitempty"` // Name of the Drive shortcut the file was reached through
+	Status   string        `json:"status"`             // One of the SyncStatus values
+	Reason   string        `json:"reason,omitempty"`   // Why it was skipped, or the error it failed with
+	Duration time.Duration `json:"durationNs"`         // Wall time of the whole sync, including lookups
+	Timings  SyncTimings   `json:"timings"`
+}
+
+// Reports whether the file was left as it was, without an error.
+func (r *SyncResult) Skipped() bool {
+	return strings.HasPrefix(r.Status, "skipped_")
+}
+
+// StageHistogram is a cumulative histogram of one stage's durations (Prometheus-style buckets).
+type StageHistogram struct {
+	Count      int               `json:"count"`
+	SumSeconds float64           `json:"sumSeconds"`
+	Buckets    []HistogramBucket `json:"buckets"`
+}
+
+// HistogramBucket counts observations at or below LeSeconds.
+type HistogramBucket struct {
+	LeSeconds float64 `json:"le"`
+	Count     int     `json:"count"`
+}
+
+// FileTiming is one file's duration for a stage, used in slowest-file listings.
+type FileTiming struct {
+	FileName string        `json:"fileName"`
+	Shortcut string        `json:"shortcut,omitempty"` // Name of the Drive shortcut the file was reached through
+	Duration time.Duration `json:"durationNs"`
+}
+
+// SyncMetricsSnapshot is the aggregated view of recorded sync timings.
+type SyncMetricsSnapshot struct {
+	Files      int                       `json:"files"`
+	Histograms map[string]StageHistogram `json:"histograms"` // By stage
+	Slowest    map[string][]FileTiming   `json:"slowest"`    // By stage, slowest first
+}
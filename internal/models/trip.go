@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Trip is a run of images taken close together in time (and place), stored in the trips
+// collection. Trips are recomputed from the image metadata; only a manual name is user-owned.
+type Trip struct {
+	Id            string    `firestore:"-" json:"id"`
+	Name          string    `firestore:"name" json:"name"`
+	AutoName      string    `firestore:"autoName" json:"-"`                                // Generated name, restored when a manual name is cleared
+	CustomName    bool      `firestore:"customName,omitempty" json:"customName,omitempty"` // Renamed by hand; kept across recomputation
+	StartAt       time.Time `firestore:"startAt" json:"startAt"`                           // takenAt of the first image
+	EndAt         time.Time `firestore:"endAt" json:"endAt"`                               // takenAt of the last image
+	Countries     []string  `firestore:"countries,omitempty" json:"countries,omitempty"`   // Most photographed first
+	Places        []string  `firestore:"places,omitempty" json:"places,omitempty"`         // Up to three most photographed cities
+	CoverFileName string    `firestore:"coverFileName,omitempty" json:"coverFileName,omitempty"`
+	ImageCount    int       `firestore:"imageCount" json:"imageCount"`
+	ImageIDs      []string  `firestore:"imageIds" json:"imageIds,omitempty"` // Image document IDs in takenAt order; left out of listings
+	ComputedAt    time.Time `firestore:"computedAt" json:"computedAt"`
+}
+
+// TripInput is the body of trip update requests. A null or empty name restores the generated one.
+type TripInput struct {
+	Name *string `json:"name,omitempty"`
+}
+
+// TripImages is one page of a trip's images, in takenAt order.
+type TripImages struct {
+	Trip    *Trip            `json:"trip"`
+	Images  []*ImageMetadata `json:"images"`
+	Total   int              `json:"total"`   // Number of images paged over: the trip's images when computed, less any quarantined since
+	Missing int              `json:"missing"` // Images on this page deleted since (skipped until the next recompute)
+}
+
+// TripRecompute reports the outcome of segmenting the collection into trips.
+type TripRecompute struct {
+	Trips     int   `json:"trips"`   // Trips now stored
+	Images    int   `json:"images"`  // Images assigned to a trip
+	Undated   int   `json:"undated"` // Images without takenAt, which belong to no trip
+	Renamed   int   `json:"renamed"` // Manual names carried over to a recomputed trip
+	Removed   int   `json:"removed"` // Earlier trips with no counterpart, deleted
+	ElapsedMs int64 `json:"elapsedMs"`
+}
@@ -5,21 +5,130 @@ import (
 
 	httpSwagger "github.com/swaggo/http-swagger"
 	"trekka-api/internal/handlers"
+	"trekka-api/internal/middleware"
+	"trekka-api/internal/models"
 )
 
-// Setup configures and returns the HTTP router with all application routes.
+// Per-client limit for /image/report-broken, on top of the global limiter, since each report
+// costs a Firestore read and a request to GCS.
+const reportRate, reportBurst = 0.2, 3
+
+// Setup configures and returns the HTTP router with all application routes. Patterns are
+// method-qualified, so the mux answers other methods with 405 and an Allow header itself
+// ("GET" also matches HEAD).
 func Setup(h *handlers.Handler) http.Handler {
 	mux := http.NewServeMux()
 
-	// Swagger UI
-	mux.HandleFunc("/swagger/", httpSwagger.WrapHandler)
+	// OpenAPI document, and the Swagger UI rendering it
+	mux.HandleFunc("GET /openapi.json", handlers.HandleOpenAPI)
+	mux.Handle("GET /swagger/", httpSwagger.Handler(httpSwagger.URL("/openapi.json")))
 
 	// Health check
-	mux.HandleFunc("/health", h.HandleHealth)
+	mux.HandleFunc("GET /health", h.HandleHealth)
+	mux.HandleFunc("GET /health/deep", h.HandleHealthDeep)
+	mux.HandleFunc("GET /healthz", handlers.HandleLiveness)
+	mux.HandleFunc("GET /readyz", h.HandleReadiness)
 
 	// Image endpoints
-	mux.HandleFunc("/image", h.HandleImage)
-	mux.HandleFunc("/images/list", h.HandleImagesList)
+	mux.HandleFunc("GET /image", h.HandleImage)
+	mux.HandleFunc("GET /image/thumbnail", h.HandleThumbnail)
+	mux.HandleFunc("GET /image/random", h.HandleRandomImage)
+	mux.Handle("POST /image/report-broken", middleware.NewRateLimiter(reportRate, reportBurst).Limit(http.HandlerFunc(h.HandleReportBrokenURL)))
+	mux.HandleFunc("GET /images/list", h.HandleImagesList)
+	mux.HandleFunc("GET /images/search", h.HandleImagesSearch)
+	mux.HandleFunc("POST /images/urls", h.HandleImageURLs)
+	mux.HandleFunc("GET /images/places", h.HandleImagesPlaces)
+	mux.HandleFunc("GET /images/near", h.HandleImagesNear)
+	mux.HandleFunc("GET /images/clusters", h.HandleImagesClusters)
+	mux.HandleFunc("GET /images/points", h.HandleImagesPoints)
+	mux.HandleFunc("POST /images", h.HandleImageUpload)
+	mux.HandleFunc("GET /images/{id}", h.HandleImageMetadata)
+	mux.HandleFunc("PATCH /images/{id}", h.HandleImageMetadataPatch)
+	mux.HandleFunc("DELETE /images/{id}", h.HandleImageDelete)
+	mux.HandleFunc("GET /images/{id}/exif", h.HandleImageExif)
+	mux.HandleFunc("POST /images/{id}/favorite", h.HandleImageFavorite)
+	mux.HandleFunc("PUT /images/{id}/favorite", h.HandleImageFavorite)
+	mux.HandleFunc("POST /images/{id}/regeocode", h.HandleImageRegeocode)
+	mux.HandleFunc("POST /images/{id}/refresh-metadata", h.HandleImageRefreshMetadata)
+	mux.HandleFunc("GET /images/export", h.HandleImagesExport)
+	mux.HandleFunc("GET /images/stats", h.HandleImagesStats)
+	mux.HandleFunc("GET /images/timeline", h.HandleImagesTimeline)
+	mux.HandleFunc("GET /images/locations/tree", h.HandleLocationTree)
+	mux.HandleFunc("GET /images/countries", h.HandleImagesCountries)
+
+	// Album endpoints
+	mux.HandleFunc("GET /albums", h.HandleListAlbums)
+	mux.HandleFunc("POST /albums", h.HandleCreateAlbum)
+	mux.HandleFunc("GET /albums/{id}", h.HandleGetAlbum)
+	mux.HandleFunc("PATCH /albums/{id}", h.HandleUpdateAlbum)
+	mux.HandleFunc("DELETE /albums/{id}", h.HandleDeleteAlbum)
+	mux.HandleFunc("GET /albums/{id}/images", h.HandleAlbumImages)
+
+	// Trip endpoints
+	mux.HandleFunc("GET /trips", h.HandleTrips)
+	mux.HandleFunc("GET /trips/{id}", h.HandleGetTrip)
+	mux.HandleFunc("PATCH /trips/{id}", h.HandleRenameTrip)
+	mux.HandleFunc("GET /trips/{id}/images", h.HandleTripImages)
+
+	// Public link-preview images (exempt from API key auth)
+	mux.HandleFunc("GET /og/", h.HandleOpenGraphImage)
+
+	// Admin endpoints
+	mux.HandleFunc("POST /admin/expected-files", h.HandleExpectedFiles)
+	mux.HandleFunc("GET /admin/expected-files/report", h.HandleExpectedFilesReport)
+	mux.HandleFunc("GET /admin/metrics/sync", h.HandleSyncMetrics)
+	mux.HandleFunc("DELETE /admin/cache", h.HandleCacheFlush)
+	mux.HandleFunc("GET /admin/cache/stats", h.HandleCacheStats)
+	mux.HandleFunc("POST /admin/geotag", h.HandleGeotag)
+	mux.HandleFunc("GET /admin/quarantine", h.HandleQuarantineList)
+	mux.HandleFunc("POST /admin/quarantine/restore", h.HandleQuarantineRestore)
+	mux.HandleFunc("POST /admin/trips/recompute", h.HandleTripsRecompute)
+	mux.HandleFunc("GET /admin/reconcile", h.HandleReconcile)
+	mux.HandleFunc("POST /admin/backfill", h.HandleBackfill)
+	mux.HandleFunc("GET /admin/backfill", h.HandleBackfillStatus)
+	mux.HandleFunc("GET /admin/sync/runs", h.HandleSyncRuns)
+	mux.HandleFunc("POST /admin/sync/pause", h.HandleSyncPause)
+	mux.HandleFunc("POST /admin/sync/resume", h.HandleSyncResume)
+	mux.HandleFunc("POST /admin/reconcile", h.HandleReconcile)
+
+	return jsonMuxErrors(mux)
+}
+
+// Serves the mux's own 404 and 405 responses (no pattern matched) in the JSON error envelope
+// instead of its plain-text bodies. Headers the mux sets, such as Allow, are kept.
+func jsonMuxErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(&muxErrorWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+// Rewrites the status written by http.Error as a JSON error and drops its plain-text body.
+type muxErrorWriter struct {
+	http.ResponseWriter
+	r     *http.Request
+	wrote bool
+}
+
+func (w *muxErrorWriter) WriteHeader(status int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+
+	message := "Not found"
+	if status == http.StatusMethodNotAllowed {
+		message = "Method not allowed"
+	}
+	middleware.WriteError(w.ResponseWriter, w.r, status, models.ErrorCodeForStatus(status), message)
+}
 
-	return mux
+func (w *muxErrorWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	return len(b), nil
 }
@@ -0,0 +1,208 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"trekka-api/internal/handlers"
+	"trekka-api/internal/models"
+	"trekka-api/internal/services"
+	"trekka-api/internal/testutil"
+)
+
+// Returns the router over a Handler on the Firestore emulator and a fake GCS server, with the
+// cache to seed signed URLs into. Skips the test unless FIRESTORE_EMULATOR_HOST is set (see make
+// test-emulator).
+func newEmulatorRouter(t *testing.T) (http.Handler, *services.CacheService, *services.FirestoreService) {
+	t.Helper()
+	client := testutil.FirestoreClient(t)
+	gcs := testutil.NewFakeGCS(t)
+
+	cache := services.NewCacheService(time.Minute, time.Minute)
+	t.Cleanup(cache.Stop)
+	fs := services.NewFirestoreService(client, "images")
+	images := services.NewImageService(services.NewStorageService(gcs.Client, testutil.FakeBucket), cache, fs)
+
+	h := handlers.New(images, nil, nil, cache, nil, nil, nil, nil, nil, nil, nil)
+	return Setup(h), cache, fs
+}
+
+// Sends a request through the router and returns the recorded response.
+func serve(handler http.Handler, method, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func TestHeadWritesNoBody(t *testing.T) {
+	handler, cache, fs := newEmulatorRouter(t)
+	ctx := context.Background()
+
+	cache.SetSignedURL("photo.jpg", models.SignedURLEntry{
+		URL:         "https://storage.example.com/photo.jpg?signature=abc",
+		ContentType: "image/jpeg",
+		GeoLocation: "Lisbon, Portugal",
+		FileName:    "photo.jpg",
+		URLExpires:  time.Now().Add(time.Hour),
+	})
+	if _, err := fs.CreateImageMetadata(ctx, &models.ImageMetadata{
+		FileName:    "photo.jpg",
+		ContentType: "image/jpeg",
+		StoragePath: "images/photo.jpg",
+		TakenAt:     time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	}); err != nil {
+		t.Fatalf("seed image: %v", err)
+	}
+
+	t.Run("image", func(t *testing.T) {
+		get := serve(handler, http.MethodGet, "/image?fileName=photo.jpg")
+		head := serve(handler, http.MethodHead, "/image?fileName=photo.jpg")
+
+		if head.Code != http.StatusFound {
+			t.Fatalf("HEAD status = %d, want %d", head.Code, http.StatusFound)
+		}
+		if head.Body.Len() != 0 {
+			t.Errorf("HEAD wrote a %d byte body, want none", head.Body.Len())
+		}
+		for _, header := range []string{"Location", "X-Geo-Location", "X-Content-Type"} {
+			if got, want := head.Header().Get(header), get.Header().Get(header); got == "" || got != want {
+				t.Errorf("HEAD %s = %q, want %q as on GET", header, got, want)
+			}
+		}
+	})
+
+	t.Run("images/list", func(t *testing.T) {
+		get := serve(handler, http.MethodGet, "/images/list")
+		head := serve(handler, http.MethodHead, "/images/list")
+
+		if head.Code != http.StatusOK {
+			t.Fatalf("HEAD status = %d, want %d", head.Code, http.StatusOK)
+		}
+		if head.Body.Len() != 0 {
+			t.Errorf("HEAD wrote a %d byte body, want none", head.Body.Len())
+		}
+		if get.Body.Len() == 0 {
+			t.Fatal("GET wrote no body")
+		}
+		for _, header := range []string{"Content-Length", "ETag", "Content-Type"} {
+			if got, want := head.Header().Get(header), get.Header().Get(header); got == "" || got != want {
+				t.Errorf("HEAD %s = %q, want %q as on GET", header, got, want)
+			}
+		}
+	})
+}
+
+func TestUnsupportedMethods(t *testing.T) {
+	// 405s come from the mux before any handler runs, so the handler needs no services
+	handler := Setup(handlers.New(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil))
+
+	tests := []struct {
+		method    string
+		target    string
+		wantAllow string
+	}{
+		{http.MethodPost, "/image", "GET, HEAD"},
+		{http.MethodDelete, "/image/thumbnail", "GET, HEAD"},
+		{http.MethodGet, "/image/report-broken", "POST"},
+		{http.MethodPut, "/images/img-1", "DELETE, GET, HEAD, PATCH"},
+		{http.MethodPost, "/images/img-1", "DELETE, GET, HEAD, PATCH"},
+		{http.MethodGet, "/images", "POST"},
+		{http.MethodGet, "/images/img-1/favorite", "POST, PUT"},
+		{http.MethodPut, "/images/urls", "DELETE, GET, HEAD, PATCH, POST"}, // Also matches /images/{id}
+		{http.MethodPut, "/albums", "GET, HEAD, POST"},
+		{http.MethodPost, "/albums/album-1", "DELETE, GET, HEAD, PATCH"},
+		{http.MethodDelete, "/trips/trip-1", "GET, HEAD, PATCH"},
+		{http.MethodGet, "/admin/cache", "DELETE"},
+		{http.MethodDelete, "/admin/reconcile", "GET, HEAD, POST"},
+		{http.MethodPost, "/health", "GET, HEAD"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			rec := serve(handler, tt.method, tt.target)
+
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+			assertErrorCode(t, rec, models.ErrorCodeMethodNotAllowed)
+		})
+	}
+
+	t.Run("unknown path", func(t *testing.T) {
+		rec := serve(handler, http.MethodGet, "/nope")
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+		if got := rec.Header().Get("Allow"); got != "" {
+			t.Errorf("Allow = %q, want none", got)
+		}
+		assertErrorCode(t, rec, models.ErrorCodeNotFound)
+	})
+}
+
+func TestRoutesReachHandlers(t *testing.T) {
+	handler, _, fs := newEmulatorRouter(t)
+
+	id, err := fs.CreateImageMetadata(context.Background(), &models.ImageMetadata{
+		FileName:    "photo.jpg",
+		ContentType: "image/jpeg",
+		StoragePath: "images/photo.jpg",
+		TakenAt:     time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("seed image: %v", err)
+	}
+
+	tests := []struct {
+		method     string
+		target     string
+		wantStatus int
+	}{
+		{http.MethodGet, "/images/list", http.StatusOK},
+		{http.MethodHead, "/images/list", http.StatusOK},
+		{http.MethodGet, "/images/" + id, http.StatusOK},
+		{http.MethodGet, "/images/missing", http.StatusNotFound},
+		{http.MethodGet, "/image", http.StatusBadRequest}, // Handler ran and wants fileName
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			rec := serve(handler, tt.method, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	// The {id} wildcard reaches the handler through r.PathValue
+	rec := serve(handler, http.MethodGet, "/images/"+id)
+	var metadata models.ImageMetadata
+	if err := json.Unmarshal(rec.Body.Bytes(), &metadata); err != nil {
+		t.Fatalf("decode metadata: %v", err)
+	}
+	if metadata.FileName != "photo.jpg" {
+		t.Errorf("GET /images/%s returned %q, want photo.jpg", id, metadata.FileName)
+	}
+}
+
+// Fails the test unless the response is the JSON error envelope with code.
+func assertErrorCode(t *testing.T, rec *httptest.ResponseRecorder, code string) {
+	t.Helper()
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	var body models.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error body %q: %v", rec.Body.String(), err)
+	}
+	if body.Error.Code != code {
+		t.Errorf("error code = %q, want %q", body.Error.Code, code)
+	}
+}
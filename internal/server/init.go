@@ -2,29 +2,40 @@ package server
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/storage"
-	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/option"
 
 	"trekka-api/internal/config"
 	"trekka-api/internal/handlers"
 	"trekka-api/internal/middleware"
+	"trekka-api/internal/models"
 	"trekka-api/internal/router"
 	"trekka-api/internal/services"
+	"trekka-api/internal/utils"
 )
 
 // Services holds all initialized services for the application
 type Services struct {
-	Cache     *services.CacheService
-	Storage   *services.StorageService
-	Firestore *services.FirestoreService
-	Image     *services.ImageService
-	Drive     *services.DriveService // May be nil if Drive sync is disabled
+	Cache      *services.CacheService
+	Storage    *services.StorageService
+	Firestore  *services.FirestoreService
+	Image      *services.ImageService
+	Expected   *services.ExpectationService
+	Geotag     *services.GeotagService
+	Quarantine *services.QuarantineService
+	Trips      *services.TripService
+	Reconcile  *services.ReconcileService
+	Health     *services.HealthService
+	Ready      *services.Readiness       // Marked ready once InitServices completes; consulted by /readyz
+	Metrics    *services.SyncMetrics     // Stage timings of files synced by Drive
+	Watcher    *services.MetadataWatcher // May be nil if CACHE_LISTENER is disabled
+	Drive      *services.DriveService    // May be nil if Drive sync is disabled
 }
 
 // InitServices initializes all application services based on configuration.
@@ -54,78 +65,213 @@ func InitServices(ctx context.Context, cfg *config.Config) (*Services, error) {
 
 	// Initialize core services
 	cacheService := services.NewCacheService(cfg.CacheTTL, cfg.CacheCleanupInterval)
+	cacheService.SetMaxEntries(cfg.CacheMaxEntries)
 	storageService := services.NewStorageService(storageClient, cfg.FirebaseBucketName)
+	storageService.SetSignedURLExpiry(cfg.SignedURLExpiry)
 	firestoreService := services.NewFirestoreService(firestoreClient, cfg.FirestoreCollection)
+	firestoreService.SetDeterministicIDs(cfg.DeterministicIDs)
 	imageService := services.NewImageService(storageService, cacheService, firestoreService)
+	imageService.SetProxyMaxBytes(cfg.ProxyMaxBytes)
+	imageService.SetNearMaxRadius(float64(cfg.NearMaxRadiusKm) * 1000)
+	imageService.SetURLCheckRate(cfg.SignedURLCheckRate)
+	imageService.SetNegativeCacheTTL(cfg.NegativeCacheTTL)
+	if utils.WebPAvailable() {
+		imageService.EnableWebP()
+	} else {
+		log.Printf("cwebp not found on PATH; thumbnails will be served without WebP")
+	}
+	if cfg.StaleOnOutage {
+		imageService.EnableStaleFallback(cfg.StaleMaxAge)
+	}
+
+	// Shared so Drive sync, uploads and GPX geotagging stay within one geocoding rate limit
+	geocoder := services.NewGeocodingServiceWithCache(firestoreService)
+	geocoder.SetPlaceGridMeters(cfg.PlaceGridMeters)
+	geocoder.SetCacheMaxAge(cfg.GeocodeCacheMaxAge)
+	geocodeProvider, err := services.GeocoderFor(cfg.Geocoders, cfg.GoogleMapsAPIKey)
+	if err != nil {
+		return nil, err
+	}
+	geocoder.SetProvider(geocodeProvider)
+	imageService.SetGeocoder(geocoder)
+
+	storagePaths, err := services.StoragePathStrategyFor(cfg.StorageLayout)
+	if err != nil {
+		return nil, err
+	}
+	imageService.SetStoragePathStrategy(storagePaths)
 
 	svcs := &Services{
-		Cache:     cacheService,
-		Storage:   storageService,
-		Firestore: firestoreService,
-		Image:     imageService,
+		Cache:      cacheService,
+		Storage:    storageService,
+		Firestore:  firestoreService,
+		Image:      imageService,
+		Expected:   services.NewExpectationService(firestoreService),
+		Geotag:     services.NewGeotagService(firestoreService, geocoder),
+		Quarantine: services.NewQuarantineService(firestoreService, storageService, cfg.QuarantineAfter),
+		Trips:      services.NewTripService(firestoreService, cfg.TripGap, cfg.TripRegionGap),
+		Reconcile:  services.NewReconcileService(firestoreService, storageService, geocoder),
+		Metrics:    services.NewSyncMetrics(10),
+	}
+
+	svcs.Reconcile.SetCacheEvictor(imageService.EvictImage)
+
+	if cfg.CacheListener {
+		svcs.Watcher = services.NewMetadataWatcher(firestoreService, imageService)
+	}
+
+	// In the background, so readiness doesn't wait for it
+	if cfg.CacheWarmup && cfg.CacheWarmupCount > 0 {
+		go warmCache(imageService, cfg.CacheWarmupCount)
 	}
 
 	// Initialize Google Drive sync if enabled
 	if cfg.DriveSyncInterval > 0 {
 		if cfg.GoogleDriveFolderID == "" {
 			log.Println("Drive sync enabled but GOOGLE_DRIVE_FOLDER_ID not set, skipping Drive sync")
-		} else if cfg.GoogleAPIKey == "" && cfg.FirebaseCredentialsPath == "" && cfg.FirebaseCredentialsJSON == "" {
-			log.Println("Drive sync enabled but no credentials available, skipping Drive sync")
+		} else if driveClient, identity, err := services.NewDriveAPI(context.Background(), services.DriveCredentials{
+			APIKey:          cfg.GoogleAPIKey,
+			CredentialsJSON: cfg.FirebaseCredentialsJSON,
+			CredentialsPath: cfg.FirebaseCredentialsPath,
+			Subject:         cfg.DriveImpersonateSubject,
+		}); err != nil {
+			log.Printf("Failed to create Drive API client, skipping Drive sync: %v", err)
 		} else {
-			log.Println("Initializing Google Drive sync service...")
-
-			var driveClient *drive.Service
-			if cfg.GoogleAPIKey != "" {
-				var err error
-				driveClient, err = drive.NewService(context.Background(), option.WithAPIKey(cfg.GoogleAPIKey))
-				if err != nil {
-					log.Printf("Failed to create Drive API client: %v", err)
-				}
-			}
+			log.Printf("Initializing Google Drive sync service (as %s)...", identity)
 
 			// Wrap Drive client in DriveFileService
-			driveFileService := services.NewDriveClient(driveClient)
+			driveFileService := services.NewDriveClient(driveClient, services.DriveClientOptions{
+				MinInterval: cfg.DriveMinInterval,
+				MaxRetries:  cfg.DriveMaxRetries,
+				BaseBackoff: cfg.DriveBaseBackoff,
+			})
+			if cfg.DriveSharedDrive {
+				driveFileService.SetSharedDrive(cfg.DriveID)
+			}
+
+			// An unshared folder or a missing scope would otherwise only show as empty listings
+			pingCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := driveFileService.Ping(pingCtx, cfg.GoogleDriveFolderID); err != nil {
+				log.Printf("Drive sync cannot read its folder and will fail until this is fixed: %v", services.ExplainDriveAccessError(err, cfg.GoogleDriveFolderID, identity))
+			}
+			cancel()
 
 			// Create the DriveService using the new constructor
 			driveService := services.NewDriveService(
 				driveFileService,
 				storageService,
 				firestoreService,
-				services.NewGeocodingService(),
+				geocoder,
 				cfg.GoogleDriveFolderID,
 			)
 
+			driveService.SetStoragePathStrategy(storagePaths)
+			driveService.SetSyncState(services.NewSyncStateStore(firestoreService))
+			driveService.SetSyncLock(services.NewSyncLock(firestoreService, "drive", cfg.DriveLockTTL))
+			driveService.SetSyncRuns(services.NewSyncRunStore(firestoreService, cfg.SyncRunRetention))
+			driveService.SetRecursive(cfg.DriveRecursive)
+			driveService.SetStreamThreshold(cfg.DriveStreamThreshold)
+			driveService.SetFileDelay(cfg.DriveFileDelay)
+			driveService.SetWatchLookback(cfg.DriveWatchLookback)
+			driveService.SetPropagateDeletes(cfg.DrivePropagateDeletes, cfg.DriveDeleteObjects)
+			driveService.SetMetrics(svcs.Metrics)
+			driveService.SetQuarantine(svcs.Quarantine)
+			driveService.SetCacheEvictor(imageService.EvictImage)
+
 			svcs.Drive = driveService
 		}
 	}
 
+	svcs.Health = services.NewHealthService(firestoreService, storageService, svcs.Drive)
+	svcs.Ready = services.NewReadiness(firestoreService)
+	svcs.Ready.MarkReady()
+
 	return svcs, nil
 }
 
 // CreateHandler creates an HTTP handler with all middleware applied
-func CreateHandler(imageService *services.ImageService, allowedOrigins []string, apiKeys []string) http.Handler {
+func CreateHandler(svcs *Services, cfg *config.Config) http.Handler {
 	// Initialize handlers
-	h := handlers.New(imageService)
+	h := handlers.New(svcs.Image, svcs.Expected, svcs.Metrics, svcs.Cache, svcs.Geotag, svcs.Quarantine, svcs.Trips, svcs.Reconcile, svcs.Health, svcs.Ready, svcs.Drive)
+	if cfg.PublicMode {
+		h.EnablePublicMode()
+		log.Println("Public mode enabled: /image and /images/list serve public images without an API key")
+	}
 
 	// Setup router with middleware
 	mux := router.Setup(h)
 
 	// Rate limiter: 10 requests per second per IP, with burst of 20
 	rateLimiter := middleware.NewRateLimiter(10, 20)
+	if cfg.RateLimitBackend == "distributed" {
+		// Shared budget across instances; the in-memory limiter stays in front as a cheap pre-filter
+		rateLimiter.WithGlobal(services.NewDistributedRateLimiter(svcs.Firestore, cfg.RateLimitWindowMax, cfg.RateLimitWindow))
+		log.Printf("Distributed rate limiting enabled (%d requests per %v per IP)", cfg.RateLimitWindowMax, cfg.RateLimitWindow)
+	}
 
 	// Apply global middleware (innermost to outermost)
-	wrappedHandler := middleware.APIKeyAuth(apiKeys)(mux)
-	wrappedHandler = middleware.RequestID(wrappedHandler)
+	wrappedHandler := middleware.APIKeyAuth(cfg.APIKeys, cfg.PublicMode)(mux)
 	wrappedHandler = middleware.Logger(wrappedHandler)
-	wrappedHandler = rateLimiter.Limit(wrappedHandler) // Rate limiting
-	wrappedHandler = middleware.CORS(wrappedHandler, allowedOrigins)
+	wrappedHandler = rateLimiter.Limit(wrappedHandler)    // Rate limiting
+	wrappedHandler = middleware.RequestID(wrappedHandler) // Outside the limiter so 429s carry a request ID too
+	wrappedHandler = middleware.CORS(wrappedHandler, corsOptions(cfg))
 
 	return wrappedHandler
 }
 
+// Builds CORS middleware options from configuration.
+func corsOptions(cfg *config.Config) middleware.CORSOptions {
+	opts := middleware.CORSOptions{AllowedOrigins: cfg.AllowedOrigins}
+	if len(cfg.CORSOrigins) > 0 {
+		opts.Origins = make(map[string]middleware.OriginPolicy, len(cfg.CORSOrigins))
+		for origin, policy := range cfg.CORSOrigins {
+			opts.Origins[origin] = middleware.OriginPolicy{
+				Methods:          policy.Methods,
+				AllowCredentials: policy.AllowCredentials,
+			}
+		}
+	}
+	return opts
+}
+
+// Upper bound on the startup cache warm-up.
+const cacheWarmupTimeout = 2 * time.Minute
+
+// Signs and caches the URLs of the newest count images, logging how long it took.
+func warmCache(images *services.ImageService, count int) {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheWarmupTimeout)
+	defer cancel()
+
+	start := time.Now()
+	warmed, err := images.WarmCache(ctx, count)
+	if err != nil {
+		log.Printf("Cache warm-up stopped after %d images in %v: %v", warmed, time.Since(start).Round(time.Millisecond), err)
+		return
+	}
+	log.Printf("Cache warm-up signed %d of the newest %d images in %v", warmed, count, time.Since(start).Round(time.Millisecond))
+}
+
+// StartMetadataWatcher starts evicting cached entries as their documents change.
+// Returns a cancel function to stop the listener.
+func StartMetadataWatcher(ctx context.Context, watcher *services.MetadataWatcher) context.CancelFunc {
+	watchCtx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		log.Println("Starting cache invalidation listener")
+		watcher.Run(watchCtx)
+		log.Println("Cache invalidation listener stopped")
+	}()
+
+	return cancel
+}
+
+// Longest the func StartDriveSync returns waits for the sync to stop, e.g. for an in-flight file.
+const driveStopTimeout = 20 * time.Second
+
 // StartDriveSync starts the Google Drive sync service with optional backfill.
-// If backfillOnStartup is true, runs a one-time backfill before starting the watch.
-// Returns a cancel function to stop the sync gracefully.
+// If backfillOnStartup is true, runs a one-time backfill before starting the watch; it is skipped
+// if another instance holds the sync lock. Returns a function that stops the sync gracefully,
+// waiting (up to driveStopTimeout) until it has stopped and given the sync lock up.
 func StartDriveSync(ctx context.Context, driveService *services.DriveService, interval time.Duration, backfillOnStartup bool) context.CancelFunc {
 	if driveService == nil {
 		log.Println("Cannot start Drive sync: driveService is nil")
@@ -138,14 +284,19 @@ func StartDriveSync(ctx context.Context, driveService *services.DriveService, in
 	}
 
 	driveCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
 
 	go func() {
+		defer close(done)
+
 		// Run backfill if enabled
 		if backfillOnStartup {
 			log.Println("Running one-time backfill from Google Drive...")
 			// Skip existing files on server startup (only process new files)
-			if err := driveService.BackfillFromDrive(driveCtx, true); err != nil {
-				if err != context.Canceled {
+			if _, err := driveService.BackfillFromDrive(driveCtx, services.BackfillOptions{SkipExisting: true, Trigger: models.SyncTriggerStartup}); err != nil {
+				if errors.Is(err, services.ErrSyncLocked) {
+					log.Printf("Skipping startup backfill: %v", err)
+				} else if err != context.Canceled {
 					log.Printf("Backfill completed with errors: %v", err)
 				} else {
 					log.Println("Backfill canceled")
@@ -165,5 +316,12 @@ func StartDriveSync(ctx context.Context, driveService *services.DriveService, in
 		}
 	}()
 
-	return cancel
+	return func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(driveStopTimeout):
+			log.Printf("Drive sync did not stop within %v; its sync lock expires on its own", driveStopTimeout)
+		}
+	}
 }
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"sort"
+
+	"trekka-api/internal/models"
+	"trekka-api/internal/utils"
+)
+
+// Recomputes an album's aggregate metadata from its current members.
+// A pinned cover is kept as long as it is still a member; otherwise the cover is the most
+// recently taken geotagged member, falling back to the most recent member of any kind.
+// Albums store it through modifyAlbum, which every membership change goes through (API edits,
+// dedupe in MigrateDocumentIDs and image deletes), and which also runs when a member changes in a
+// way the summary reflects (sync, status changes, metadata refreshes and re-geocoding).
+func ComputeAlbumSummary(members []*models.ImageMetadata, pinnedCover string) models.AlbumSummary {
+	summary := models.AlbumSummary{MemberCount: len(members)}
+
+	var latest, latestGeotagged *models.ImageMetadata
+	countries := make(map[string]struct{})
+	pinnedPresent := false
+
+	for _, m := range members {
+		if m == nil {
+			continue
+		}
+		if pinnedCover != "" && m.FileName == pinnedCover {
+			pinnedPresent = true
+		}
+
+		if !m.TakenAt.IsZero() {
+			if summary.TakenFrom.IsZero() || m.TakenAt.Before(summary.TakenFrom) {
+				summary.TakenFrom = m.TakenAt
+			}
+			if m.TakenAt.After(summary.TakenTo) {
+				summary.TakenTo = m.TakenAt
+			}
+		}
+
+		if country := utils.CountryFromGeoLocation(m.GeoLocation); country != "" {
+			countries[country] = struct{}{}
+		}
+
+		if latest == nil || m.TakenAt.After(latest.TakenAt) {
+			latest = m
+		}
+		if m.Coordinates.Lat != "" && m.Coordinates.Lng != "" {
+			if latestGeotagged == nil || m.TakenAt.After(latestGeotagged.TakenAt) {
+				latestGeotagged = m
+			}
+		}
+	}
+
+	switch {
+	case pinnedPresent:
+		summary.CoverFileName = pinnedCover
+		summary.CoverPinned = true
+	case latestGeotagged != nil:
+		summary.CoverFileName = latestGeotagged.FileName
+	case latest != nil:
+		summary.CoverFileName = latest.FileName
+	}
+
+	for country := range countries {
+		summary.Countries = append(summary.Countries, country)
+	}
+	sort.Strings(summary.Countries)
+
+	return summary
+}
+
+// Reports whether a change to an image alters what ComputeAlbumSummary makes of it: its file
+// name (the cover), capture date, location or visibility to listings.
+func affectsAlbumSummary(before, after *models.ImageMetadata) bool {
+	return before.FileName != after.FileName ||
+		!before.TakenAt.Equal(after.TakenAt) ||
+		before.GeoLocation != after.GeoLocation ||
+		before.Coordinates != after.Coordinates ||
+		before.Hidden() != after.Hidden()
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"trekka-api/internal/models"
+)
+
+func TestComputeAlbumSummary(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	geotagged := models.Coordinates{Lat: "38.7", Lng: "-9.1"}
+	lisbon := &models.ImageMetadata{FileName: "lisbon.jpg", TakenAt: day, GeoLocation: "Lisbon, Portugal", Coordinates: geotagged}
+	madrid := &models.ImageMetadata{FileName: "madrid.jpg", TakenAt: day.Add(48 * time.Hour), GeoLocation: "Madrid, Spain", Coordinates: geotagged}
+	screenshot := &models.ImageMetadata{FileName: "screenshot.png", TakenAt: day.Add(72 * time.Hour)}
+	undated := &models.ImageMetadata{FileName: "undated.jpg", GeoLocation: "Porto, Portugal"}
+
+	tests := []struct {
+		name    string
+		members []*models.ImageMetadata
+		pinned  string
+		want    models.AlbumSummary
+	}{
+		{"empty", nil, "", models.AlbumSummary{}},
+		{
+			"latest geotagged member is the cover",
+			[]*models.ImageMetadata{lisbon, madrid, screenshot, undated},
+			"",
+			models.AlbumSummary{
+				CoverFileName: "madrid.jpg", MemberCount: 4, TakenFrom: day, TakenTo: day.Add(72 * time.Hour),
+				Countries: []string{"Portugal", "Spain"},
+			},
+		},
+		{
+			"latest member without any geotagged",
+			[]*models.ImageMetadata{screenshot, undated},
+			"",
+			models.AlbumSummary{
+				CoverFileName: "screenshot.png", MemberCount: 2, TakenFrom: day.Add(72 * time.Hour), TakenTo: day.Add(72 * time.Hour),
+				Countries: []string{"Portugal"},
+			},
+		},
+		{
+			"pinned cover kept",
+			[]*models.ImageMetadata{lisbon, madrid},
+			"lisbon.jpg",
+			models.AlbumSummary{
+				CoverFileName: "lisbon.jpg", CoverPinned: true, MemberCount: 2, TakenFrom: day, TakenTo: day.Add(48 * time.Hour),
+				Countries: []string{"Portugal", "Spain"},
+			},
+		},
+		{
+			"pinned cover no longer a member",
+			[]*models.ImageMetadata{lisbon},
+			"madrid.jpg",
+			models.AlbumSummary{CoverFileName: "lisbon.jpg", MemberCount: 1, TakenFrom: day, TakenTo: day, Countries: []string{"Portugal"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ComputeAlbumSummary(tt.members, tt.pinned); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ComputeAlbumSummary = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlbumSummaryFollowsMembership(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	seedImage(t, fs, "lisbon", &models.ImageMetadata{FileName: "lisbon.jpg", TakenAt: day, GeoLocation: "Lisbon, Portugal",
+		Coordinates: models.Coordinates{Lat: "38.7", Lng: "-9.1"}})
+	seedImage(t, fs, "madrid", &models.ImageMetadata{FileName: "madrid.jpg", TakenAt: day.Add(48 * time.Hour), GeoLocation: "Madrid, Spain",
+		Coordinates: models.Coordinates{Lat: "40.4", Lng: "-3.7"}})
+
+	name := "Iberia"
+	album, err := fs.CreateAlbum(ctx, models.AlbumInput{Name: &name, ImageIDs: []string{"lisbon"}})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+	assertSummary := func(event string, want models.AlbumSummary) {
+		t.Helper()
+		stored, err := fs.GetAlbum(ctx, album.Id)
+		if err != nil {
+			t.Fatalf("GetAlbum after %s: %v", event, err)
+		}
+		// Firestore keeps microseconds
+		stored.Summary.TakenFrom, stored.Summary.TakenTo = stored.Summary.TakenFrom.UTC(), stored.Summary.TakenTo.UTC()
+		if !reflect.DeepEqual(stored.Summary, want) {
+			t.Errorf("summary after %s = %+v, want %+v", event, stored.Summary, want)
+		}
+	}
+	assertSummary("create", models.AlbumSummary{CoverFileName: "lisbon.jpg", MemberCount: 1, TakenFrom: day, TakenTo: day, Countries: []string{"Portugal"}})
+
+	if _, err := fs.UpdateAlbum(ctx, album.Id, models.AlbumInput{ImageIDs: []string{"lisbon", "madrid"}}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	assertSummary("add", models.AlbumSummary{
+		CoverFileName: "madrid.jpg", MemberCount: 2, TakenFrom: day, TakenTo: day.Add(48 * time.Hour), Countries: []string{"Portugal", "Spain"},
+	})
+
+	cover := "lisbon"
+	if _, err := fs.UpdateAlbum(ctx, album.Id, models.AlbumInput{CoverImageID: &cover}); err != nil {
+		t.Fatalf("pin cover: %v", err)
+	}
+	assertSummary("pin", models.AlbumSummary{
+		CoverFileName: "lisbon.jpg", CoverPinned: true, MemberCount: 2, TakenFrom: day, TakenTo: day.Add(48 * time.Hour),
+		Countries: []string{"Portugal", "Spain"},
+	})
+
+	// Removing the pinned cover's image drops the pin
+	if _, err := fs.UpdateAlbum(ctx, album.Id, models.AlbumInput{ImageIDs: []string{"madrid"}}); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	assertSummary("remove", models.AlbumSummary{
+		CoverFileName: "madrid.jpg", MemberCount: 1, TakenFrom: day.Add(48 * time.Hour), TakenTo: day.Add(48 * time.Hour), Countries: []string{"Spain"},
+	})
+
+	if err := fs.DeleteImageMetadata(ctx, "madrid"); err != nil {
+		t.Fatalf("DeleteImageMetadata: %v", err)
+	}
+	assertSummary("delete", models.AlbumSummary{})
+	stored, err := fs.GetAlbum(ctx, album.Id)
+	if err != nil {
+		t.Fatalf("GetAlbum: %v", err)
+	}
+	if len(stored.ImageIDs) != 0 {
+		t.Errorf("imageIds after delete = %v, want none", stored.ImageIDs)
+	}
+}
+
+func TestAlbumSummaryFollowsMemberChanges(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	seedImage(t, fs, "lisbon", &models.ImageMetadata{FileName: "lisbon.jpg", TakenAt: day, GeoLocation: "Lisbon, Portugal",
+		Coordinates: models.Coordinates{Lat: "38.7", Lng: "-9.1"}})
+	seedImage(t, fs, "porto", &models.ImageMetadata{FileName: "porto.jpg", TakenAt: day.Add(24 * time.Hour)})
+
+	name := "Portugal"
+	album, err := fs.CreateAlbum(ctx, models.AlbumInput{Name: &name, ImageIDs: []string{"lisbon", "porto"}})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+	assertSummary := func(event string, want models.AlbumSummary) {
+		t.Helper()
+		stored, err := fs.GetAlbum(ctx, album.Id)
+		if err != nil {
+			t.Fatalf("GetAlbum after %s: %v", event, err)
+		}
+		stored.Summary.TakenFrom, stored.Summary.TakenTo = stored.Summary.TakenFrom.UTC(), stored.Summary.TakenTo.UTC()
+		if !reflect.DeepEqual(stored.Summary, want) {
+			t.Errorf("summary after %s = %+v, want %+v", event, stored.Summary, want)
+		}
+	}
+	assertSummary("create", models.AlbumSummary{
+		CoverFileName: "lisbon.jpg", MemberCount: 2, TakenFrom: day, TakenTo: day.Add(24 * time.Hour), Countries: []string{"Portugal"},
+	})
+
+	// A sync finds Porto's coordinates and a later capture date
+	porto, err := fs.GetImageMetadata(ctx, "porto")
+	if err != nil {
+		t.Fatalf("read porto: %v", err)
+	}
+	extracted := &models.ImageMetadata{
+		FileName:    "porto.jpg",
+		TakenAt:     day.Add(72 * time.Hour),
+		GeoLocation: "Porto, Spain", // Misgeocoded, fixed by the re-geocode below
+		Coordinates: models.Coordinates{Lat: "41.1", Lng: "-8.6"},
+	}
+	if _, err := persistExtracted(ctx, fs, extracted, "", porto, nil); err != nil {
+		t.Fatalf("persistExtracted: %v", err)
+	}
+	assertSummary("sync", models.AlbumSummary{
+		CoverFileName: "porto.jpg", MemberCount: 2, TakenFrom: day, TakenTo: day.Add(72 * time.Hour), Countries: []string{"Portugal", "Spain"},
+	})
+
+	porto, err = fs.GetImageMetadata(ctx, "porto")
+	if err != nil {
+		t.Fatalf("re-read porto: %v", err)
+	}
+	if err := fs.ReplaceGeoLocation(ctx, "porto", "Porto, Portugal", models.LocationParts{City: "Porto", Country: "Portugal"}, porto.Revision); err != nil {
+		t.Fatalf("ReplaceGeoLocation: %v", err)
+	}
+	assertSummary("re-geocode", models.AlbumSummary{
+		CoverFileName: "porto.jpg", MemberCount: 2, TakenFrom: day, TakenTo: day.Add(72 * time.Hour), Countries: []string{"Portugal"},
+	})
+
+	// Hidden members drop out of the summary but stay in the album
+	if err := fs.SetStatus(ctx, "porto", models.StatusDeleted, "images/porto.jpg"); err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+	assertSummary("soft delete", models.AlbumSummary{
+		CoverFileName: "lisbon.jpg", MemberCount: 1, TakenFrom: day, TakenTo: day, Countries: []string{"Portugal"},
+	})
+
+	if err := fs.SetStatus(ctx, "porto", "", "images/porto.jpg"); err != nil {
+		t.Fatalf("clear status: %v", err)
+	}
+	assertSummary("restore", models.AlbumSummary{
+		CoverFileName: "porto.jpg", MemberCount: 2, TakenFrom: day, TakenTo: day.Add(72 * time.Hour), Countries: []string{"Portugal"},
+	})
+}
+
+func TestAffectsAlbumSummary(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	base := models.ImageMetadata{FileName: "a.jpg", TakenAt: day, GeoLocation: "Lisbon, Portugal", Coordinates: models.Coordinates{Lat: "38.7", Lng: "-9.1"}}
+
+	tests := []struct {
+		name   string
+		change func(*models.ImageMetadata)
+		want   bool
+	}{
+		{"nothing", func(*models.ImageMetadata) {}, false},
+		{"description", func(m *models.ImageMetadata) { m.Description = "caption" }, false},
+		{"dominant color", func(m *models.ImageMetadata) { m.DominantColor = "#ff0000" }, false},
+		{"file name", func(m *models.ImageMetadata) { m.FileName = "a2.jpg" }, true},
+		{"capture date", func(m *models.ImageMetadata) { m.TakenAt = day.Add(time.Hour) }, true},
+		{"location", func(m *models.ImageMetadata) { m.GeoLocation = "Porto, Portugal" }, true},
+		{"coordinates", func(m *models.ImageMetadata) { m.Coordinates = models.Coordinates{} }, true},
+		{"quarantined", func(m *models.ImageMetadata) { m.Status = models.StatusQuarantined }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			after := base
+			tt.change(&after)
+			if got := affectsAlbumSummary(&base, &after); got != tt.want {
+				t.Errorf("affectsAlbumSummary = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,456 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+const albumsCollection = "albums"
+
+// Most images one album may hold. Every membership change re-reads all members inside a
+// transaction to recompute the summary, so this also bounds the cost of an edit.
+const MaxAlbumImages = 1000
+
+// Length limits for album text fields, in bytes.
+const (
+	maxAlbumNameLength        = 200
+	maxAlbumDescriptionLength = 2000
+)
+
+// Lists every album, ordered by name.
+func (fs *FirestoreService) ListAlbums(ctx context.Context) ([]*models.Album, error) {
+	iter := fs.client.Collection(albumsCollection).OrderBy("name", firestore.Asc).Documents(ctx)
+	defer iter.Stop()
+
+	albums := []*models.Album{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate albums: %w", classifyError(err))
+		}
+
+		var album models.Album
+		if err := doc.DataTo(&album); err != nil {
+			// Skip unparseable documents rather than failing the listing
+			continue
+		}
+		album.Id = doc.Ref.ID
+		albums = append(albums, &album)
+	}
+
+	return albums, nil
+}
+
+// Retrieves an album by ID.
+func (fs *FirestoreService) GetAlbum(ctx context.Context, id string) (*models.Album, error) {
+	if id == "" {
+		return nil, fmt.Errorf("%w: album ID cannot be empty", apperrors.ErrInvalidInput)
+	}
+
+	doc, err := fs.client.Collection(albumsCollection).Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, apperrors.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get album: %w", classifyError(err))
+	}
+
+	var album models.Album
+	if err := doc.DataTo(&album); err != nil {
+		return nil, fmt.Errorf("failed to parse album: %w", err)
+	}
+	album.Id = doc.Ref.ID
+
+	return &album, nil
+}
+
+// Creates an album from input. A name is required; every image ID must exist, and the cover
+// (if given) must be one of them.
+func (fs *FirestoreService) CreateAlbum(ctx context.Context, input models.AlbumInput) (*models.Album, error) {
+	if input.Name == nil || strings.TrimSpace(*input.Name) == "" {
+		return nil, fmt.Errorf("%w: album name is required", apperrors.ErrInvalidInput)
+	}
+
+	ref := fs.client.Collection(albumsCollection).NewDoc()
+	var album models.Album
+	err := fs.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		now := time.Now().UTC()
+		album = models.Album{ImageIDs: []string{}, CreatedAt: now, UpdatedAt: now}
+		if err := applyAlbumInput(&album, input); err != nil {
+			return err
+		}
+		if err := fs.refreshAlbumSummary(tx, &album, input.ImageIDs); err != nil {
+			return err
+		}
+		return tx.Create(ref, &album)
+	})
+	if err != nil {
+		// Not wrapped, so validation errors read cleanly when returned to the client
+		return nil, classifyError(err)
+	}
+	album.Id = ref.ID
+
+	return &album, nil
+}
+
+// Applies input to an existing album. Fields left nil are unchanged; imageIds replaces the
+// whole member list and every ID in it must exist. The summary is recomputed in the same
+// transaction as the write.
+func (fs *FirestoreService) UpdateAlbum(ctx context.Context, id string, input models.AlbumInput) (*models.Album, error) {
+	return fs.modifyAlbum(ctx, id, input.ImageIDs, func(album *models.Album) error {
+		return applyAlbumInput(album, input)
+	})
+}
+
+// Deletes an album. Its images are untouched.
+func (fs *FirestoreService) DeleteAlbum(ctx context.Context, id string) error {
+	if id == "" {
+		return fmt.Errorf("%w: album ID cannot be empty", apperrors.ErrInvalidInput)
+	}
+
+	if _, err := fs.client.Collection(albumsCollection).Doc(id).Delete(ctx, firestore.Exists); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return apperrors.ErrNotFound
+		}
+		return fmt.Errorf("failed to delete album: %w", classifyError(err))
+	}
+
+	return nil
+}
+
+// Resolves one page of an album's members, keeping album order. Paging is over the stored
+// references, so pages stay aligned even when some references dangle: images that no longer
+// exist are skipped and counted in Missing. Quarantined ones are left out before paging, like in
+// listings.
+// limit is capped at 1000; zero returns every member.
+func (fs *FirestoreService) ListAlbumImages(ctx context.Context, id string, limit int, page int) (*models.AlbumImages, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("%w: limit cannot be negative", apperrors.ErrInvalidInput)
+	}
+	if page < 0 {
+		return nil, fmt.Errorf("%w: page cannot be negative", apperrors.ErrInvalidInput)
+	}
+
+	album, err := fs.GetAlbum(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	images, missing, total, err := fs.getImagePage(ctx, album.ImageIDs, limit, page)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get album images: %w", err)
+	}
+
+	return &models.AlbumImages{
+		Album:   album,
+		Images:  images,
+		Total:   total,
+		Missing: missing,
+	}, nil
+}
+
+// Number of references getImagePage reads per GetAll.
+const imagePageReadBatch = 300
+
+// Reads one page of a list of image document IDs (limit per page, at most 1000; 0 for all),
+// keeping the list's order, and returns it with the number of missing references on the page and
+// the number of references paged over. Quarantined and deleted images are dropped before paging,
+// so they never leave a page short. IDs whose document no longer exists (or can't be parsed) keep
+// their slot and are counted as missing, so later pages don't shift. Every ID is read to tell
+// which are hidden; albums hold at most MaxAlbumImages.
+func (fs *FirestoreService) getImagePage(ctx context.Context, ids []string, limit int, page int) ([]*models.ImageMetadata, int, int, error) {
+	coll := fs.client.Collection(fs.collection)
+
+	// One slot per visible reference, nil when the document is missing
+	slots := make([]*models.ImageMetadata, 0, len(ids))
+	for start := 0; start < len(ids); start += imagePageReadBatch {
+		chunk := ids[start:min(start+imagePageReadBatch, len(ids))]
+		refs := make([]*firestore.DocumentRef, len(chunk))
+		for i, imageID := range chunk {
+			refs[i] = coll.Doc(imageID)
+		}
+
+		docs, err := fs.client.GetAll(ctx, refs)
+		if err != nil {
+			return nil, 0, 0, classifyError(err)
+		}
+
+		// GetAll returns snapshots in the order of refs, so the list's order is preserved
+		for _, doc := range docs {
+			if !doc.Exists() {
+				slots = append(slots, nil)
+				continue
+			}
+			var metadata models.ImageMetadata
+			if err := doc.DataTo(&metadata); err != nil {
+				slots = append(slots, nil)
+				continue
+			}
+			if metadata.Hidden() {
+				continue
+			}
+			metadata.Id = doc.Ref.ID
+			metadata.Revision = doc.UpdateTime
+			slots = append(slots, &metadata)
+		}
+	}
+
+	total := len(slots)
+	if limit > 0 {
+		limit = min(limit, 1000)
+		start := min(page*limit, len(slots))
+		slots = slots[start:min(start+limit, len(slots))]
+	}
+
+	images := make([]*models.ImageMetadata, 0, len(slots))
+	missing := 0
+	for _, metadata := range slots {
+		if metadata == nil {
+			missing++
+			continue
+		}
+		images = append(images, metadata)
+	}
+
+	return images, missing, total, nil
+}
+
+// Returns the IDs of the albums that reference an image.
+func (fs *FirestoreService) albumsContaining(ctx context.Context, imageID string) ([]string, error) {
+	docs, err := fs.client.Collection(albumsCollection).
+		Where("imageIds", "array-contains", imageID).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find albums containing %s: %w", imageID, classifyError(err))
+	}
+
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.Ref.ID
+	}
+	return ids, nil
+}
+
+// Recomputes the summary of every album that references an image, after a change to the image
+// that the summary reflects (see affectsAlbumSummary).
+func (fs *FirestoreService) refreshAlbumsContaining(ctx context.Context, imageID string) error {
+	albumIDs, err := fs.albumsContaining(ctx, imageID)
+	if err != nil {
+		return err
+	}
+
+	for _, albumID := range albumIDs {
+		if _, err := fs.modifyAlbum(ctx, albumID, nil, func(*models.Album) error { return nil }); err != nil {
+			return fmt.Errorf("failed to refresh album %s: %w", albumID, err)
+		}
+	}
+
+	return nil
+}
+
+// Removes an image from every album that references it, recomputing their summaries.
+func (fs *FirestoreService) removeImageFromAlbums(ctx context.Context, imageID string) error {
+	albumIDs, err := fs.albumsContaining(ctx, imageID)
+	if err != nil {
+		return err
+	}
+
+	for _, albumID := range albumIDs {
+		_, err := fs.modifyAlbum(ctx, albumID, nil, func(album *models.Album) error {
+			kept := album.ImageIDs[:0]
+			for _, id := range album.ImageIDs {
+				if id != imageID {
+					kept = append(kept, id)
+				}
+			}
+			album.ImageIDs = kept
+			if album.CoverImageID == imageID {
+				album.CoverImageID = ""
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to remove %s from album %s: %w", imageID, albumID, err)
+		}
+	}
+
+	return nil
+}
+
+// Reads an album, applies fn and writes it back with a fresh summary, all in one transaction.
+// Members listed in mustExist are rejected if they don't exist; other dangling members are ignored.
+func (fs *FirestoreService) modifyAlbum(ctx context.Context, id string, mustExist []string, fn func(*models.Album) error) (*models.Album, error) {
+	if id == "" {
+		return nil, fmt.Errorf("%w: album ID cannot be empty", apperrors.ErrInvalidInput)
+	}
+
+	ref := fs.client.Collection(albumsCollection).Doc(id)
+	var album models.Album
+	err := fs.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return apperrors.ErrNotFound
+			}
+			return err
+		}
+
+		album = models.Album{}
+		if err := doc.DataTo(&album); err != nil {
+			return fmt.Errorf("failed to parse album: %w", err)
+		}
+		if err := fn(&album); err != nil {
+			return err
+		}
+		if err := fs.refreshAlbumSummary(tx, &album, mustExist); err != nil {
+			return err
+		}
+		album.UpdatedAt = time.Now().UTC()
+		return tx.Set(ref, &album)
+	})
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	album.Id = id
+
+	return &album, nil
+}
+
+// Reads an album's members within tx and recomputes its summary, pinning the chosen cover.
+// A missing member listed in mustExist is an ErrInvalidInput; any other is left out of the summary,
+// as are quarantined and deleted members, which album listings skip too.
+func (fs *FirestoreService) refreshAlbumSummary(tx *firestore.Transaction, album *models.Album, mustExist []string) error {
+	coll := fs.client.Collection(fs.collection)
+	refs := make([]*firestore.DocumentRef, len(album.ImageIDs))
+	for i, id := range album.ImageIDs {
+		refs[i] = coll.Doc(id)
+	}
+
+	var docs []*firestore.DocumentSnapshot
+	if len(refs) > 0 {
+		var err error
+		if docs, err = tx.GetAll(refs); err != nil {
+			return err
+		}
+	}
+
+	members := make([]*models.ImageMetadata, 0, len(docs))
+	pinnedCover := ""
+	for _, doc := range docs {
+		if !doc.Exists() {
+			if slices.Contains(mustExist, doc.Ref.ID) {
+				return fmt.Errorf("%w: image %s does not exist", apperrors.ErrInvalidInput, doc.Ref.ID)
+			}
+			continue
+		}
+		var metadata models.ImageMetadata
+		if err := doc.DataTo(&metadata); err != nil || metadata.Hidden() {
+			continue
+		}
+		if doc.Ref.ID == album.CoverImageID {
+			pinnedCover = metadata.FileName
+		}
+		members = append(members, &metadata)
+	}
+
+	album.Summary = ComputeAlbumSummary(members, pinnedCover)
+	return nil
+}
+
+// Validates input and copies its non-nil fields onto album.
+func applyAlbumInput(album *models.Album, input models.AlbumInput) error {
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" {
+			return fmt.Errorf("%w: album name cannot be empty", apperrors.ErrInvalidInput)
+		}
+		if len(name) > maxAlbumNameLength {
+			return fmt.Errorf("%w: album name longer than %d bytes", apperrors.ErrInvalidInput, maxAlbumNameLength)
+		}
+		album.Name = name
+	}
+
+	if input.Description != nil {
+		if len(*input.Description) > maxAlbumDescriptionLength {
+			return fmt.Errorf("%w: album description longer than %d bytes", apperrors.ErrInvalidInput, maxAlbumDescriptionLength)
+		}
+		album.Description = *input.Description
+	}
+
+	if input.ImageIDs != nil {
+		if len(input.ImageIDs) > MaxAlbumImages {
+			return fmt.Errorf("%w: at most %d images per album", apperrors.ErrInvalidInput, MaxAlbumImages)
+		}
+		seen := make(map[string]struct{}, len(input.ImageIDs))
+		for _, id := range input.ImageIDs {
+			if id == "" || strings.Contains(id, "/") {
+				return fmt.Errorf("%w: invalid image ID %q", apperrors.ErrInvalidInput, id)
+			}
+			if _, dup := seen[id]; dup {
+				return fmt.Errorf("%w: image %s listed more than once", apperrors.ErrInvalidInput, id)
+			}
+			seen[id] = struct{}{}
+		}
+		album.ImageIDs = input.ImageIDs
+	}
+
+	if input.CoverImageID != nil {
+		album.CoverImageID = *input.CoverImageID
+	}
+	if album.CoverImageID != "" {
+		if !slices.Contains(album.ImageIDs, album.CoverImageID) {
+			if input.CoverImageID == nil {
+				// The cover was dropped from the members; fall back to the computed cover
+				album.CoverImageID = ""
+			} else {
+				return fmt.Errorf("%w: cover image %s is not in the album", apperrors.ErrInvalidInput, album.CoverImageID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Lists every album, ordered by name.
+func (s *ImageService) ListAlbums(ctx context.Context) ([]*models.Album, error) {
+	return s.firestore.ListAlbums(ctx)
+}
+
+// Retrieves an album by ID.
+func (s *ImageService) GetAlbum(ctx context.Context, id string) (*models.Album, error) {
+	return s.firestore.GetAlbum(ctx, id)
+}
+
+// Creates an album. See FirestoreService.CreateAlbum.
+func (s *ImageService) CreateAlbum(ctx context.Context, input models.AlbumInput) (*models.Album, error) {
+	return s.firestore.CreateAlbum(ctx, input)
+}
+
+// Updates an album. See FirestoreService.UpdateAlbum.
+func (s *ImageService) UpdateAlbum(ctx context.Context, id string, input models.AlbumInput) (*models.Album, error) {
+	return s.firestore.UpdateAlbum(ctx, id, input)
+}
+
+// Deletes an album, leaving its images in place.
+func (s *ImageService) DeleteAlbum(ctx context.Context, id string) error {
+	return s.firestore.DeleteAlbum(ctx, id)
+}
+
+// Resolves one page of an album's members. See FirestoreService.ListAlbumImages.
+func (s *ImageService) ListAlbumImages(ctx context.Context, id string, limit int, page int) (*models.AlbumImages, error) {
+	return s.firestore.ListAlbumImages(ctx, id, limit, page)
+}
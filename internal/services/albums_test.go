@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"trekka-api/internal/models"
+)
+
+func TestMemberPagesSkipQuarantinedImages(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+
+	// 12 images, 8 visible, plus a reference to an image deleted since: 9 slots over two pages of 5
+	visible := seedWithQuarantined(t, fs, 12)
+	ids := []string{"deleted"}
+	for i := range 12 {
+		ids = append(ids, fmt.Sprintf("img-%03d", i))
+	}
+
+	if _, err := fs.client.Collection(albumsCollection).Doc("album").Set(ctx, &models.Album{Name: "Lisbon", ImageIDs: ids}); err != nil {
+		t.Fatalf("seed album: %v", err)
+	}
+	if _, err := fs.client.Collection(tripsCollection).Doc("trip").Set(ctx, &models.Trip{
+		Name: "Lisbon", ImageIDs: ids, ImageCount: len(ids), ComputedAt: time.Now(),
+	}); err != nil {
+		t.Fatalf("seed trip: %v", err)
+	}
+	trips := NewTripService(fs, 0, 0)
+
+	tests := []struct {
+		page        int
+		wantIDs     []string
+		wantMissing int
+	}{
+		{0, visible[0:4], 1}, // The deleted reference keeps its slot
+		{1, visible[4:8], 0},
+		{2, nil, 0},
+	}
+	for _, tt := range tests {
+		album, err := fs.ListAlbumImages(ctx, "album", 5, tt.page)
+		if err != nil {
+			t.Fatalf("ListAlbumImages page %d: %v", tt.page, err)
+		}
+		trip, err := trips.ListImages(ctx, "trip", 5, tt.page)
+		if err != nil {
+			t.Fatalf("ListImages page %d: %v", tt.page, err)
+		}
+
+		for name, got := range map[string]struct {
+			images         []*models.ImageMetadata
+			total, missing int
+		}{
+			"album": {album.Images, album.Total, album.Missing},
+			"trip":  {trip.Images, trip.Total, trip.Missing},
+		} {
+			if ids := imageIDs(got.images); !reflect.DeepEqual(ids, tt.wantIDs) && (len(ids) != 0 || len(tt.wantIDs) != 0) {
+				t.Errorf("%s page %d = %v, want %v", name, tt.page, ids, tt.wantIDs)
+			}
+			if got.missing != tt.wantMissing {
+				t.Errorf("%s page %d missing = %d, want %d", name, tt.page, got.missing, tt.wantMissing)
+			}
+			if got.total != 9 {
+				t.Errorf("%s total = %d, want 9 (8 visible and 1 missing)", name, got.total)
+			}
+		}
+	}
+}
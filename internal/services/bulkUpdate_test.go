@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+func TestBulkUpdateReportsPerDocument(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+
+	// More than BulkWriter sends in one batch
+	const n = 250
+	var updates []MetadataUpdate
+	for i := range n {
+		id := fmt.Sprintf("img-%03d", i)
+		seedImage(t, fs, id, &models.ImageMetadata{FileName: id + ".jpg", Description: "caption " + id, DominantColor: "#000000"})
+		updates = append(updates, MetadataUpdate{
+			Id:       id,
+			Metadata: &models.ImageMetadata{FileName: id + ".jpg", DominantColor: "#ff0000", Country: "Portugal"},
+		})
+	}
+	updates = append(updates,
+		MetadataUpdate{Id: "missing", Metadata: &models.ImageMetadata{Country: "Portugal"}},
+		MetadataUpdate{Id: "", Metadata: &models.ImageMetadata{Country: "Portugal"}},
+	)
+
+	errs := fs.BulkUpdateImageMetadata(ctx, updates)
+	if len(errs) != len(updates) {
+		t.Fatalf("got %d results for %d updates", len(errs), len(updates))
+	}
+	for i, err := range errs[:n] {
+		if err != nil {
+			t.Errorf("update %s: %v", updates[i].Id, err)
+		}
+	}
+	if errs[n] == nil {
+		t.Error("update of a missing document succeeded; it must not create one")
+	}
+	if !errors.Is(errs[n+1], apperrors.ErrInvalidInput) {
+		t.Errorf("update without an ID: err = %v, want ErrInvalidInput", errs[n+1])
+	}
+	if count := countImages(t, fs); count != n {
+		t.Errorf("%d documents after the bulk update, want %d", count, n)
+	}
+
+	for _, id := range []string{"img-000", "img-249"} {
+		got, err := fs.GetImageMetadata(ctx, id)
+		if err != nil {
+			t.Fatalf("read %s: %v", id, err)
+		}
+		if got.DominantColor != "#ff0000" || got.Country != "Portugal" {
+			t.Errorf("%s color = %q, country = %q; want the extracted fields written", id, got.DominantColor, got.Country)
+		}
+		if got.Description != "caption "+id {
+			t.Errorf("%s description = %q, want it left as stored", id, got.Description)
+		}
+	}
+}
+
+func TestBulkUpdateKeepsManualDescription(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+	data := gpsJPEG(t, [3]uint32{38, 42, 36}, "N", [3]uint32{9, 8, 24}, "W")
+
+	seedImage(t, fs, "img-1", &models.ImageMetadata{
+		FileName:         "IMG_1.jpg",
+		ContentType:      "image/jpeg",
+		DriveFileID:      "drive-file-1",
+		SourceProperties: map[string]string{"album": "Lisbon"},
+	})
+	listed, err := fs.GetImageMetadata(ctx, "img-1")
+	if err != nil {
+		t.Fatalf("read img-1: %v", err)
+	}
+
+	// Captioned by hand after update-metadata listed the document
+	if err := fs.UpdateImageMetadataFields(ctx, "img-1", []firestore.Update{
+		{Path: "description", Value: "Sunset over the river"},
+		{Path: "hasDescription", Value: true},
+	}); err != nil {
+		t.Fatalf("set description: %v", err)
+	}
+
+	geocoder := &fakeGeocoder{parts: models.LocationParts{City: "Lisbon", Country: "Portugal"}}
+	merged, err := ExtractAndMergeMetadata(ctx, fs, listed.FileName, listed.ContentType, data, listed, geocoder, nil)
+	if err != nil {
+		t.Fatalf("ExtractAndMergeMetadata: %v", err)
+	}
+	if errs := fs.BulkUpdateImageMetadata(ctx, []MetadataUpdate{{Id: "img-1", Metadata: merged}}); errs[0] != nil {
+		t.Fatalf("bulk update: %v", errs[0])
+	}
+
+	got, err := fs.GetImageMetadata(ctx, "img-1")
+	if err != nil {
+		t.Fatalf("re-read img-1: %v", err)
+	}
+	if got.GeoLocation != "Lisbon, Portugal" {
+		t.Errorf("GeoLocation = %q, want the extracted location written", got.GeoLocation)
+	}
+	if got.Description != "Sunset over the river" || !got.HasDescription {
+		t.Errorf("description = %q (has %t), want the manual caption kept", got.Description, got.HasDescription)
+	}
+	if got.DriveFileID != "drive-file-1" || got.SourceProperties["album"] != "Lisbon" {
+		t.Errorf("driveFileId = %q, sourceProperties = %v; want them left as stored", got.DriveFileID, got.SourceProperties)
+	}
+}
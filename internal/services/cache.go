@@ -1,26 +1,50 @@
 package services
 
 import (
+	"container/list"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"trekka-api/internal/models"
 )
 
+// Default most entries held at once; see SetMaxEntries.
+const DefaultCacheMaxEntries = 10_000
+
 type CacheService struct {
-	cache           map[string]*models.CacheEntry
-	mu              sync.RWMutex
+	cache           map[string]*list.Element // Values are *cacheItem
+	lru             *list.List               // Most recently used at the front
+	mu              sync.Mutex
 	ttl             time.Duration
+	maxEntries      int           // Least recently used entries are evicted beyond this (0 for no limit)
+	staleRetention  time.Duration // How long expired entries are kept for GetStale
 	cleanupInterval time.Duration
 	stopChan        chan struct{}
+	hits            atomic.Uint64
+	misses          atomic.Uint64
+	expiredReads    atomic.Uint64 // Misses that found an entry past its expiry
+	sets            atomic.Uint64
+	evictions       atomic.Uint64
+	now             func() time.Time // time.Now; tests replace it to move the clock
+}
+
+// One cached value with its key, so the LRU list can find the map entry to remove.
+type cacheItem struct {
+	key    string
+	entry  *models.CacheEntry
+	stored time.Time // When entry was stored, for GetStale's age; entries may have their own TTL
 }
 
 func NewCacheService(ttl, cleanupInterval time.Duration) *CacheService {
 	cs := &CacheService{
-		cache:           make(map[string]*models.CacheEntry),
+		cache:           make(map[string]*list.Element),
+		lru:             list.New(),
 		ttl:             ttl,
+		maxEntries:      DefaultCacheMaxEntries,
 		cleanupInterval: cleanupInterval,
 		stopChan:        make(chan struct{}),
+		now:             time.Now,
 	}
 
 	// Start cleanup goroutine
@@ -29,41 +53,290 @@ func NewCacheService(ttl, cleanupInterval time.Duration) *CacheService {
 	return cs
 }
 
+// Caps how many entries the cache holds; storing beyond it evicts the least recently used entry.
+// Zero removes the limit, negative values are ignored. Lowering the cap evicts immediately.
+func (cs *CacheService) SetMaxEntries(maxEntries int) {
+	if maxEntries < 0 {
+		return
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.maxEntries = maxEntries
+	cs.evictOverflow()
+}
+
 // Retrieves a cache entry by key, returning nil if not found or expired.
+// A hit marks the entry as recently used.
 func (cs *CacheService) Get(key string) (*models.CacheEntry, bool) {
-	cs.mu.RLock()
-	defer cs.mu.RUnlock()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
 
-	entry, ok := cs.cache[key]
+	elem, ok := cs.cache[key]
 	if !ok {
+		cs.misses.Add(1)
 		return nil, false
 	}
-
-	if entry.Expires.Before(time.Now()) {
+	if elem.Value.(*cacheItem).entry.Expires.Before(cs.now()) {
+		cs.misses.Add(1)
+		cs.expiredReads.Add(1)
 		return nil, false
 	}
 
-	return entry, true
+	cs.lru.MoveToFront(elem)
+	cs.hits.Add(1)
+	return elem.Value.(*cacheItem).entry, true
 }
 
-// Stores a signed URL in the cache with the specified key and metadata.
-// The entry will expire after the configured TTL.
-// Returns early if key or signedURL is empty to prevent invalid cache entries.
-func (cs *CacheService) Set(key string, signedURL string, contentType, geoLocation, fileName string) {
-	if key == "" || signedURL == "" {
+// Retrieves a cache entry by key even if it has expired, as long as it is still within the
+// stale retention window. Also returns how long ago the entry was stored.
+// The window runs from the entry's own expiry, so entries stored with a TTL other than the
+// configured one (SetBytesWithTTL, SetNotFound) age out on time too, whether or not the
+// cleanup has removed them yet.
+func (cs *CacheService) GetStale(key string) (*models.CacheEntry, time.Duration, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	elem, ok := cs.cache[key]
+	if !ok {
+		return nil, 0, false
+	}
+
+	item := elem.Value.(*cacheItem)
+	now := cs.now()
+	if item.entry.Expires.Add(cs.staleRetention).Before(now) {
+		return nil, 0, false
+	}
+	return item.entry, now.Sub(item.stored), true
+}
+
+// Keeps expired entries around for the given duration so GetStale can still serve them.
+// Zero (the default) removes entries as soon as they expire.
+func (cs *CacheService) SetStaleRetention(retention time.Duration) {
+	if retention < 0 {
+		return
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.staleRetention = retention
+}
+
+// Stores a signed URL under key, expiring after the configured TTL (entry.Expires is set here).
+// Returns early if key or entry.URL is empty to prevent invalid cache entries.
+func (cs *CacheService) SetSignedURL(key string, entry models.SignedURLEntry) {
+	if key == "" || entry.URL == "" {
 		return
 	}
+	entry.Expires = cs.now().Add(cs.ttl)
+
+	cs.put(key, &models.CacheEntry{
+		SignedURL:   &entry,
+		ContentType: entry.ContentType,
+		FileName:    entry.FileName,
+		Expires:     entry.Expires,
+	})
+}
+
+// How long before its URL expires a cached signed URL stops being served, so a client following
+// the redirect (possibly after a slow page load) doesn't get a 403 from GCS.
+const signedURLRefreshMargin = 2 * time.Minute
 
+// Retrieves a signed URL stored with SetSignedURL. Byte entries under the same key, expired
+// entries and URLs expiring within signedURLRefreshMargin are misses, so the caller signs afresh.
+func (cs *CacheService) GetSignedURL(key string) (*models.SignedURLEntry, bool) {
 	cs.mu.Lock()
 	defer cs.mu.Unlock()
 
-	cs.cache[key] = &models.CacheEntry{
-		SignedURL:   signedURL,
+	now := cs.now()
+	elem, ok := cs.cache[key]
+	if !ok {
+		cs.misses.Add(1)
+		return nil, false
+	}
+	entry := elem.Value.(*cacheItem).entry
+	if entry.SignedURL == nil {
+		cs.misses.Add(1)
+		return nil, false
+	}
+	if entry.Expires.Before(now) ||
+		(!entry.SignedURL.URLExpires.IsZero() && entry.SignedURL.URLExpires.Before(now.Add(signedURLRefreshMargin))) {
+		cs.misses.Add(1)
+		cs.expiredReads.Add(1)
+		return nil, false
+	}
+
+	cs.lru.MoveToFront(elem)
+	cs.hits.Add(1)
+	return entry.SignedURL, true
+}
+
+// Retrieves a signed URL like GetSignedURL, but past its expiry as long as it is within the stale
+// retention window (see GetStale). Also returns how long ago it was stored.
+func (cs *CacheService) GetStaleSignedURL(key string) (*models.SignedURLEntry, time.Duration, bool) {
+	entry, age, ok := cs.GetStale(key)
+	if !ok || entry.SignedURL == nil {
+		return nil, 0, false
+	}
+	return entry.SignedURL, age, true
+}
+
+// Stores raw bytes (e.g. a resized rendition) in the cache under the specified key.
+// The entry will expire after the configured TTL.
+// Returns early if key or data is empty to prevent invalid cache entries.
+func (cs *CacheService) SetBytes(key string, data []byte, contentType, fileName string) {
+	cs.SetBytesWithTTL(key, data, contentType, fileName, cs.ttl)
+}
+
+// Stores raw bytes like SetBytes, but expiring after ttl instead of the configured TTL
+// (e.g. short-lived responses to bursts of identical requests).
+func (cs *CacheService) SetBytesWithTTL(key string, data []byte, contentType, fileName string, ttl time.Duration) {
+	if key == "" || len(data) == 0 || ttl <= 0 {
+		return
+	}
+
+	cs.put(key, &models.CacheEntry{
+		Data:        data,
 		ContentType: contentType,
-		GeoLocation: geoLocation,
 		FileName:    fileName,
-		Expires:     time.Now().Add(cs.ttl),
+		Expires:     cs.now().Add(ttl),
+	})
+}
+
+// Records that the image requested under key doesn't exist, so repeated requests can be
+// answered without a Firestore lookup until ttl passes or the key is deleted (as happens when
+// the image is synced). GetSignedURL treats the tombstone as a miss.
+func (cs *CacheService) SetNotFound(key string, ttl time.Duration) {
+	if key == "" || ttl <= 0 {
+		return
+	}
+
+	cs.put(key, &models.CacheEntry{NotFound: true, Expires: cs.now().Add(ttl)})
+}
+
+// Reports whether an unexpired not-found tombstone is stored under key (see SetNotFound).
+// Only a tombstone found counts, as a hit; anything else leaves the counters to the lookup that follows.
+func (cs *CacheService) IsNotFound(key string) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	elem, ok := cs.cache[key]
+	if !ok {
+		return false
+	}
+	entry := elem.Value.(*cacheItem).entry
+	if !entry.NotFound || entry.Expires.Before(cs.now()) {
+		return false
+	}
+
+	cs.lru.MoveToFront(elem)
+	cs.hits.Add(1)
+	return true
+}
+
+// Stores entry under key as the most recently used entry, evicting the least recently used
+// ones if that takes the cache over its cap.
+func (cs *CacheService) put(key string, entry *models.CacheEntry) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.sets.Add(1)
+	now := cs.now()
+	if elem, ok := cs.cache[key]; ok {
+		item := elem.Value.(*cacheItem)
+		item.entry, item.stored = entry, now
+		cs.lru.MoveToFront(elem)
+		return
+	}
+
+	cs.cache[key] = cs.lru.PushFront(&cacheItem{key: key, entry: entry, stored: now})
+	cs.evictOverflow()
+}
+
+// Removes least recently used entries until the cache is within maxEntries. Callers hold mu.
+func (cs *CacheService) evictOverflow() {
+	if cs.maxEntries <= 0 {
+		return
+	}
+	for len(cs.cache) > cs.maxEntries {
+		cs.remove(cs.lru.Back())
+		cs.evictions.Add(1)
+	}
+}
+
+// Removes one element from both the map and the LRU list. Callers hold mu.
+func (cs *CacheService) remove(elem *list.Element) {
+	delete(cs.cache, elem.Value.(*cacheItem).key)
+	cs.lru.Remove(elem)
+}
+
+// Returns the number of entries currently held, including expired ones awaiting cleanup.
+func (cs *CacheService) Len() int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return len(cs.cache)
+}
+
+// Removes a single entry. Returns false if the key was not cached.
+func (cs *CacheService) Delete(key string) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	elem, ok := cs.cache[key]
+	if !ok {
+		return false
+	}
+	cs.remove(elem)
+	return true
+}
+
+// Removes every entry and returns how many were dropped. Hit/miss counters are kept.
+func (cs *CacheService) Flush() int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	n := len(cs.cache)
+	cs.cache = make(map[string]*list.Element)
+	cs.lru.Init()
+	return n
+}
+
+// Returns entry counts, hit/miss/set/eviction counters since startup and an approximate memory
+// footprint (keys, strings and cached bytes; map, list and struct overhead is not counted).
+func (cs *CacheService) Stats() models.CacheStats {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	stats := models.CacheStats{
+		Entries:      len(cs.cache),
+		MaxEntries:   cs.maxEntries,
+		Hits:         cs.hits.Load(),
+		Misses:       cs.misses.Load(),
+		ExpiredReads: cs.expiredReads.Load(),
+		Sets:         cs.sets.Load(),
+		Evictions:    cs.evictions.Load(),
 	}
+
+	now := cs.now()
+	for k, elem := range cs.cache {
+		v := elem.Value.(*cacheItem).entry
+		if v.Expires.Before(now) {
+			stats.Expired++
+		}
+		stats.ApproxBytes += int64(len(k) + len(v.Data) + len(v.ContentType) + len(v.FileName))
+		if u := v.SignedURL; u != nil {
+			stats.ApproxBytes += int64(len(u.URL) + len(u.ContentType) + len(u.GeoLocation) + len(u.FileName) + len(u.StoragePath))
+		}
+	}
+
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+
+	return stats
 }
 
 // Periodically removes expired entries from the cache.
@@ -75,11 +348,11 @@ func (cs *CacheService) cleanupExpired() {
 	for {
 		select {
 		case <-ticker.C:
-			now := time.Now()
 			cs.mu.Lock()
-			for k, v := range cs.cache {
-				if v.Expires.Before(now) {
-					delete(cs.cache, k)
+			now := cs.now().Add(-cs.staleRetention)
+			for _, elem := range cs.cache {
+				if elem.Value.(*cacheItem).entry.Expires.Before(now) {
+					cs.remove(elem)
 				}
 			}
 			cs.mu.Unlock()
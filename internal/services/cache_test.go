@@ -0,0 +1,357 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"trekka-api/internal/models"
+)
+
+// Returns a cache with a long TTL and no background cleanup during the test.
+func newTestCache(t *testing.T) *CacheService {
+	t.Helper()
+	cs := NewCacheService(time.Hour, time.Hour)
+	t.Cleanup(cs.Stop)
+	return cs
+}
+
+func TestCacheDeleteAndFlush(t *testing.T) {
+	cs := newTestCache(t)
+	for i := range 3 {
+		cs.SetBytes(fmt.Sprintf("img-%d.jpg", i), []byte("data"), "image/jpeg", "")
+	}
+	if n := cs.Len(); n != 3 {
+		t.Fatalf("Len = %d, want 3", n)
+	}
+
+	if !cs.Delete("img-1.jpg") {
+		t.Error("Delete of a cached key = false, want true")
+	}
+	if cs.Delete("img-1.jpg") {
+		t.Error("Delete of an evicted key = true, want false")
+	}
+	if _, ok := cs.Get("img-1.jpg"); ok {
+		t.Error("deleted key still served")
+	}
+	if n := cs.Len(); n != 2 {
+		t.Fatalf("Len after Delete = %d, want 2", n)
+	}
+
+	if n := cs.Flush(); n != 2 {
+		t.Errorf("Flush = %d, want 2 removed", n)
+	}
+	if n := cs.Len(); n != 0 {
+		t.Errorf("Len after Flush = %d, want 0", n)
+	}
+	if stats := cs.Stats(); stats.Misses != 1 {
+		t.Errorf("misses after Flush = %d, want the counters kept", stats.Misses)
+	}
+
+	// The LRU list is reset along with the map, so the cap still evicts correctly
+	cs.SetMaxEntries(1)
+	cs.SetBytes("a.jpg", []byte("a"), "image/jpeg", "")
+	cs.SetBytes("b.jpg", []byte("b"), "image/jpeg", "")
+	if _, ok := cs.Get("a.jpg"); ok {
+		t.Error("a.jpg kept beyond the cap after a flush")
+	}
+	if _, ok := cs.Get("b.jpg"); !ok {
+		t.Error("b.jpg evicted; want the least recently used entry to go")
+	}
+}
+
+func TestCacheGetStale(t *testing.T) {
+	cs := NewCacheService(time.Hour, time.Hour)
+	t.Cleanup(cs.Stop)
+	cs.SetStaleRetention(100 * time.Millisecond)
+
+	cs.SetBytes("fresh.jpg", []byte("a"), "image/jpeg", "")
+	cs.SetBytesWithTTL("recent.jpg", []byte("b"), "image/jpeg", "", 20*time.Millisecond)
+	cs.SetBytesWithTTL("old.jpg", []byte("c"), "image/jpeg", "", time.Millisecond)
+	cs.SetNotFound("gone.jpg", time.Millisecond)
+	time.Sleep(150 * time.Millisecond)
+	cs.SetBytesWithTTL("recent.jpg", []byte("b"), "image/jpeg", "", 20*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+
+	tests := []struct {
+		key    string
+		wantOK bool
+		maxAge time.Duration
+	}{
+		{"fresh.jpg", true, time.Second},             // Not expired yet
+		{"recent.jpg", true, 100 * time.Millisecond}, // Expired, restored 30ms ago: the age restarts
+		{"old.jpg", false, 0},                        // Expired over the retention ago, though still held
+		{"gone.jpg", false, 0},
+		{"missing.jpg", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			entry, age, ok := cs.GetStale(tt.key)
+			if ok != tt.wantOK {
+				t.Fatalf("GetStale ok = %t, want %t", ok, tt.wantOK)
+			}
+			if !ok {
+				if entry != nil || age != 0 {
+					t.Errorf("GetStale = %v, %v on a miss, want nil, 0", entry, age)
+				}
+				return
+			}
+			if age <= 0 || age > tt.maxAge {
+				t.Errorf("age = %v, want under %v since it was stored", age, tt.maxAge)
+			}
+		})
+	}
+
+	if n := cs.Len(); n != 4 {
+		t.Errorf("Len = %d, want GetStale to leave entries for the cleanup", n)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	cs := newTestCache(t)
+	cs.SetSignedURL("photo.jpg", models.SignedURLEntry{URL: "https://example.com/photo.jpg", FileName: "photo.jpg"})
+	cs.SetBytes("thumb.jpg", []byte("12345"), "image/jpeg", "")
+	cs.SetBytesWithTTL("gone.jpg", []byte("x"), "image/jpeg", "", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	cs.Get("thumb.jpg")
+	cs.Get("thumb.jpg")
+	cs.Get("missing.jpg")
+	cs.Get("gone.jpg")
+
+	stats := cs.Stats()
+	if stats.Entries != 3 || stats.Expired != 1 {
+		t.Errorf("entries = %d, expired = %d; want 3 and 1", stats.Entries, stats.Expired)
+	}
+	if stats.Hits != 2 || stats.Misses != 2 || stats.ExpiredReads != 1 {
+		t.Errorf("hits = %d, misses = %d, expired reads = %d; want 2, 2 and 1", stats.Hits, stats.Misses, stats.ExpiredReads)
+	}
+	if stats.Sets != 3 {
+		t.Errorf("sets = %d, want 3", stats.Sets)
+	}
+	if stats.HitRatio != 0.5 {
+		t.Errorf("hit ratio = %v, want 0.5", stats.HitRatio)
+	}
+	// Keys, the cached bytes and the signed URL's strings
+	minBytes := int64(len("photo.jpg")*2 + len("https://example.com/photo.jpg") + len("thumb.jpg") + 5 + len("image/jpeg"))
+	if stats.ApproxBytes < minBytes {
+		t.Errorf("approx bytes = %d, want at least %d", stats.ApproxBytes, minBytes)
+	}
+}
+
+func TestCacheConcurrentUse(t *testing.T) {
+	cs := newTestCache(t)
+	cs.SetMaxEntries(50)
+
+	const workers = 8
+	const rounds = 500
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range rounds {
+				key := fmt.Sprintf("img-%d.jpg", (w*rounds+i)%100)
+				switch i % 10 {
+				case 0:
+					cs.Delete(key)
+				case 1:
+					cs.Stats()
+					cs.Len()
+				case 2:
+					if w == 0 && i%100 == 2 {
+						cs.Flush()
+					}
+				case 3, 4, 5:
+					cs.SetBytes(key, []byte(key), "image/jpeg", "")
+				default:
+					cs.Get(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := cs.Stats()
+	if stats.Entries > 50 || stats.Entries != cs.Len() {
+		t.Errorf("entries = %d (Len %d), want at most the cap of 50", stats.Entries, cs.Len())
+	}
+	if stats.Sets != workers*rounds*3/10 {
+		t.Errorf("sets = %d, want %d", stats.Sets, workers*rounds*3/10)
+	}
+	if got, want := stats.Hits+stats.Misses, uint64(workers*rounds*4/10); got != want {
+		t.Errorf("hits + misses = %d, want one per Get (%d)", got, want)
+	}
+}
+
+func TestCacheEvictsUnderConcurrentUse(t *testing.T) {
+	cs := newTestCache(t)
+	const maxEntries = 100
+	cs.SetMaxEntries(maxEntries)
+
+	// Each worker stores keys of its own, far past the cap, reading back recent and evicted ones
+	const workers = 8
+	const keysPerWorker = 1000
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range keysPerWorker {
+				key := fmt.Sprintf("w%d/img-%d.jpg", w, i)
+				cs.SetBytes(key, []byte(key), "image/jpeg", "")
+				if entry, ok := cs.Get(key); ok && string(entry.Data) != key {
+					t.Errorf("Get(%s) = %q, want its own data", key, entry.Data)
+				}
+				cs.Get(fmt.Sprintf("w%d/img-%d.jpg", w, i/2))
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := cs.Stats()
+	if stats.Entries != maxEntries {
+		t.Errorf("entries = %d, want the cap of %d", stats.Entries, maxEntries)
+	}
+	if want := uint64(workers*keysPerWorker - maxEntries); stats.Evictions != want {
+		t.Errorf("evictions = %d, want %d (one per store over the cap)", stats.Evictions, want)
+	}
+
+	// The map and the LRU list must still describe the same entries
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.lru.Len() != len(cs.cache) {
+		t.Fatalf("LRU list holds %d entries, map %d", cs.lru.Len(), len(cs.cache))
+	}
+	for elem := cs.lru.Front(); elem != nil; elem = elem.Next() {
+		if cs.cache[elem.Value.(*cacheItem).key] != elem {
+			t.Errorf("LRU entry %q missing from the map", elem.Value.(*cacheItem).key)
+		}
+	}
+}
+
+// Get and Set latency with no cap, and at a cap every Set has to evict for. Run with -benchmem
+// to compare the LRU bookkeeping against the uncapped baseline.
+func BenchmarkCacheGetSet(b *testing.B) {
+	const keys = 10_000
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = fmt.Sprintf("img-%d.jpg", i)
+	}
+	data := []byte("data")
+
+	for _, bc := range []struct {
+		name       string
+		maxEntries int
+	}{
+		{"uncapped", 0},
+		{"evicting", keys / 10},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			cs := NewCacheService(time.Hour, time.Hour)
+			b.Cleanup(cs.Stop)
+			cs.SetMaxEntries(bc.maxEntries)
+			for _, name := range names {
+				cs.SetBytes(name, data, "image/jpeg", "")
+			}
+
+			b.Run("Get", func(b *testing.B) {
+				b.RunParallel(func(pb *testing.PB) {
+					for i := 0; pb.Next(); i++ {
+						cs.Get(names[i%keys])
+					}
+				})
+			})
+			b.Run("Set", func(b *testing.B) {
+				b.RunParallel(func(pb *testing.PB) {
+					for i := 0; pb.Next(); i++ {
+						cs.SetBytes(names[i%keys], data, "image/jpeg", "")
+					}
+				})
+			})
+		})
+	}
+}
+
+// A clock for CacheService.now that only moves when the test advances it.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestCacheSignedURLRefreshedBeforeExpiry(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	cs := newTestCache(t) // Cached for an hour, longer than the URLs stay valid
+	cs.now = clock.Now
+
+	sign := func(url string) {
+		cs.SetSignedURL("photo.jpg", models.SignedURLEntry{URL: url, URLExpires: clock.Now().Add(DefaultSignedURLExpiry)})
+	}
+	served := func() string {
+		entry, ok := cs.GetSignedURL("photo.jpg")
+		if !ok {
+			return ""
+		}
+		return entry.URL
+	}
+
+	sign("https://signed/1")
+	clock.Advance(DefaultSignedURLExpiry - signedURLRefreshMargin - time.Second)
+	if got := served(); got != "https://signed/1" {
+		t.Fatalf("URL with over the margin left: served %q, want the cached one", got)
+	}
+
+	clock.Advance(2 * time.Second)
+	if got := served(); got != "" {
+		t.Fatalf("URL within the margin of expiring: served %q, want a miss so it is re-signed", got)
+	}
+	if stats := cs.Stats(); stats.ExpiredReads != 1 || stats.Expired != 0 {
+		t.Errorf("ExpiredReads = %d, Expired = %d; want the read counted while the entry itself is fresh", stats.ExpiredReads, stats.Expired)
+	}
+	if _, ok := cs.Get("photo.jpg"); !ok {
+		t.Error("Get missed; want only signed URL reads to stop short of the URL's expiry")
+	}
+
+	sign("https://signed/2")
+	clock.Advance(DefaultSignedURLExpiry - signedURLRefreshMargin - time.Second)
+	if got := served(); got != "https://signed/2" {
+		t.Errorf("re-signed URL: served %q, want it cached for its own lifetime", got)
+	}
+
+	t.Run("without a URL expiry", func(t *testing.T) {
+		// Entries cached before URLExpires existed are served until the cache TTL
+		cs.SetSignedURL("old.jpg", models.SignedURLEntry{URL: "https://signed/old"})
+		clock.Advance(time.Hour - time.Second)
+		if _, ok := cs.GetSignedURL("old.jpg"); !ok {
+			t.Error("miss before the TTL, want the URL served")
+		}
+		clock.Advance(2 * time.Second)
+		if _, ok := cs.GetSignedURL("old.jpg"); ok {
+			t.Error("hit after the TTL, want a miss")
+		}
+	})
+
+	t.Run("TTL shorter than the URL", func(t *testing.T) {
+		short := NewCacheService(5*time.Minute, time.Hour)
+		t.Cleanup(short.Stop)
+		short.now = clock.Now
+		short.SetSignedURL("photo.jpg", models.SignedURLEntry{URL: "https://signed/3", URLExpires: clock.Now().Add(DefaultSignedURLExpiry)})
+		clock.Advance(5*time.Minute + time.Second)
+		if _, ok := short.GetSignedURL("photo.jpg"); ok {
+			t.Error("hit after the TTL, want the TTL to bound the entry too")
+		}
+	})
+}
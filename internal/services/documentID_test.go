@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+func TestReadsTakeIDFromDocumentReference(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+	takenAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// Written before Id stopped being persisted, with a copy that disagrees with the reference
+	raw := map[string]any{
+		"id":            "stale-id",
+		"fileName":      "IMG_1.jpg",
+		"fileNameLower": "img_1.jpg",
+		"storagePath":   "2024/06/IMG_1.jpg",
+		"takenAt":       takenAt,
+		"createdAt":     takenAt,
+		"missing":       []string{models.MissingCoordinates},
+	}
+	if _, err := fs.client.Collection(fs.collection).Doc("doc-1").Set(ctx, raw); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	single := map[string]func() (*models.ImageMetadata, error){
+		"GetImageMetadata": func() (*models.ImageMetadata, error) { return fs.GetImageMetadata(ctx, "doc-1") },
+		"GetImageMetadataByFilename": func() (*models.ImageMetadata, error) {
+			return fs.GetImageMetadataByFilename(ctx, "IMG_1.jpg", "jpg")
+		},
+		"GetImageMetadataByStoragePath": func() (*models.ImageMetadata, error) {
+			return fs.GetImageMetadataByStoragePath(ctx, "2024/06/IMG_1.jpg")
+		},
+	}
+	for name, read := range single {
+		got, err := read()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got.Id != "doc-1" {
+			t.Errorf("%s Id = %q, want doc-1", name, got.Id)
+		}
+	}
+
+	lists := map[string]func() ([]*models.ImageMetadata, error){
+		"ListImageMetadata":    func() ([]*models.ImageMetadata, error) { return fs.ListImageMetadata(ctx, 10, 0) },
+		"ListAllImageMetadata": func() ([]*models.ImageMetadata, error) { return fs.ListAllImageMetadata(ctx, 10, 0) },
+		"ListIncompleteImages": func() ([]*models.ImageMetadata, error) { return fs.ListIncompleteImages(ctx) },
+		"ListImageMetadataTakenBetween": func() ([]*models.ImageMetadata, error) {
+			return fs.ListImageMetadataTakenBetween(ctx, takenAt.Add(-time.Hour), takenAt.Add(time.Hour))
+		},
+		"ForEachImageMetadata": func() ([]*models.ImageMetadata, error) {
+			var all []*models.ImageMetadata
+			err := fs.ForEachImageMetadata(ctx, 10, func(m *models.ImageMetadata) error {
+				all = append(all, m)
+				return nil
+			})
+			return all, err
+		},
+	}
+	for name, list := range lists {
+		got, err := list()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(got) != 1 || got[0].Id != "doc-1" {
+			t.Errorf("%s returned %d documents, first Id %q; want doc-1", name, len(got), firstID(got))
+		}
+	}
+}
+
+// Returns the Id of the first image, or "" for none.
+func firstID(images []*models.ImageMetadata) string {
+	if len(images) == 0 {
+		return ""
+	}
+	return images[0].Id
+}
+
+func TestWritesTargetTheReturnedID(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+
+	// No Drive file or storage path, so the document gets a random ID
+	metadata := &models.ImageMetadata{Id: "ignored", FileName: "IMG_2.jpg"}
+	id, err := fs.CreateImageMetadata(ctx, metadata)
+	if err != nil {
+		t.Fatalf("CreateImageMetadata: %v", err)
+	}
+	if id == "" || id == "ignored" {
+		t.Fatalf("created document %q, want a fresh ID", id)
+	}
+	doc, err := fs.client.Collection(fs.collection).Doc(id).Get(ctx)
+	if err != nil {
+		t.Fatalf("read created document: %v", err)
+	}
+	if _, ok := doc.Data()["id"]; ok {
+		t.Error("created document stores an id field; the reference is the only source")
+	}
+
+	stored, err := fs.GetImageMetadata(ctx, id)
+	if err != nil {
+		t.Fatalf("GetImageMetadata: %v", err)
+	}
+	stored.Country = "Portugal"
+	if err := fs.ReplaceImageMetadata(ctx, stored.Id, stored); err != nil {
+		t.Fatalf("ReplaceImageMetadata: %v", err)
+	}
+	if n := countImages(t, fs); n != 1 {
+		t.Errorf("%d documents after updating the one read, want 1", n)
+	}
+	updated, err := fs.GetImageMetadata(ctx, id)
+	if err != nil {
+		t.Fatalf("re-read: %v", err)
+	}
+	if updated.Country != "Portugal" {
+		t.Errorf("after the update country = %q, want Portugal", updated.Country)
+	}
+
+	for name, err := range map[string]error{
+		"ReplaceImageMetadata":   fs.ReplaceImageMetadata(ctx, "", stored),
+		"ReplaceImageMetadataAt": fs.ReplaceImageMetadataAt(ctx, "", stored, stored.Revision),
+	} {
+		if !errors.Is(err, apperrors.ErrInvalidInput) {
+			t.Errorf("%s with an empty ID: err = %v, want ErrInvalidInput", name, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+
+	apperrors "trekka-api/internal/errors"
+)
+
+// Selects how the Drive API is reached. An API key can only read publicly shared files; without
+// one, the service account key (JSON, or the file at CredentialsPath) is used with the
+// drive.readonly scope, reading whatever is shared with it. With Subject, the service account
+// acts as that Workspace user through domain-wide delegation and reads what they can.
+type DriveCredentials struct {
+	APIKey          string
+	CredentialsJSON string
+	CredentialsPath string
+	Subject         string // User to impersonate; optional
+}
+
+// Creates a Drive API client for the credentials, along with a description of who it acts as
+// for log and error messages.
+func NewDriveAPI(ctx context.Context, creds DriveCredentials) (*drive.Service, string, error) {
+	if creds.APIKey != "" {
+		svc, err := drive.NewService(ctx, option.WithAPIKey(creds.APIKey))
+		return svc, "GOOGLE_API_KEY", err
+	}
+
+	keyJSON := []byte(creds.CredentialsJSON)
+	if len(keyJSON) == 0 {
+		if creds.CredentialsPath == "" {
+			return nil, "", fmt.Errorf("%w: no Drive credentials: set GOOGLE_API_KEY or service account credentials", apperrors.ErrInvalidInput)
+		}
+		var err error
+		if keyJSON, err = os.ReadFile(creds.CredentialsPath); err != nil {
+			return nil, "", fmt.Errorf("failed to read service account key: %w", err)
+		}
+	}
+
+	identity := serviceAccountEmail(keyJSON)
+	if creds.Subject == "" {
+		svc, err := drive.NewService(ctx, option.WithCredentialsJSON(keyJSON), option.WithScopes(drive.DriveReadonlyScope))
+		return svc, identity, err
+	}
+
+	// Delegation needs a JWT signed for the subject, which the option package can't express
+	conf, err := google.JWTConfigFromJSON(keyJSON, drive.DriveReadonlyScope)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse service account key: %w", err)
+	}
+	conf.Subject = creds.Subject
+	identity = fmt.Sprintf("%s as %s", identity, creds.Subject)
+
+	svc, err := drive.NewService(ctx, option.WithTokenSource(conf.TokenSource(ctx)))
+	return svc, identity, err
+}
+
+// Returns the client_email of a service account key, or a placeholder if it has none.
+func serviceAccountEmail(keyJSON []byte) string {
+	var key struct {
+		ClientEmail string `json:"client_email"`
+	}
+	if err := json.Unmarshal(keyJSON, &key); err != nil || key.ClientEmail == "" {
+		return "the service account"
+	}
+	return key.ClientEmail
+}
+
+// Adds a likely fix to an error from reading the synced folder at startup. Drive answers a
+// folder that isn't shared with the credentials with 404, and lists inside it come back empty
+// rather than failing, so this is the only point where the cause can be told apart.
+func ExplainDriveAccessError(err error, folderID, identity string) error {
+	switch {
+	case err == nil:
+		return nil
+	case isInsufficientScope(err):
+		return fmt.Errorf("%w (%s lacks the drive.readonly scope; with DRIVE_IMPERSONATE_SUBJECT, authorize the service account's client ID for it under domain-wide delegation in the Workspace admin console)", err, identity)
+	case errors.Is(err, apperrors.ErrNotFound):
+		return fmt.Errorf("%w (folder %s doesn't exist or isn't shared with %s)", err, folderID, identity)
+	case errors.Is(err, apperrors.ErrUnauthorized):
+		return fmt.Errorf("%w (%s may not read folder %s; check it is shared with them and the Drive API is enabled)", err, identity, folderID)
+	}
+	return err
+}
+
+// Reports whether Drive rejected a call because the token wasn't granted the scope it needs.
+func isInsufficientScope(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != 403 {
+		return false
+	}
+	for _, item := range apiErr.Errors {
+		if item.Reason == "insufficientPermissions" {
+			return true
+		}
+	}
+	return strings.Contains(apiErr.Message, "ACCESS_TOKEN_SCOPE_INSUFFICIENT") || strings.Contains(apiErr.Message, "insufficient authentication scopes")
+}
@@ -1,44 +1,127 @@
 package services
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"path"
 	"strings"
 	"sync"
 	"time"
 
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/googleapi"
+
+	apperrors "trekka-api/internal/errors"
 )
 
 // Handles Drive-related metadata extraction and downloading.
 type DriveClient struct {
 	client       *drive.Service
+	options      DriveClientOptions
 	rateLimitMu  sync.Mutex
 	lastCallTime time.Time
+	sharedDrives bool   // Include Shared Drive items in every call
+	driveID      string // Shared Drive to search (corpora=drive); empty searches all drives with sharedDrives
+}
+
+// Pacing and retry policy of a DriveClient.
+type DriveClientOptions struct {
+	MinInterval time.Duration // Least time between the starts of two Drive calls (0 for none)
+	MaxRetries  int           // Retries of a call Drive answers 403/429, after the first attempt
+	BaseBackoff time.Duration // Wait before the first retry; doubles with each, plus up to 50% jitter
 }
 
-// Creates a DriveFileService with 3-second rate limiting.
-func NewDriveClient(client *drive.Service) *DriveClient {
+// Returns the options DRIVE_MIN_INTERVAL, DRIVE_MAX_RETRIES and DRIVE_BASE_BACKOFF default to:
+// 5 seconds between calls, and 3 retries from 5 seconds.
+func DefaultDriveClientOptions() DriveClientOptions {
+	return DriveClientOptions{
+		MinInterval: 5 * time.Second,
+		MaxRetries:  3,
+		BaseBackoff: 5 * time.Second,
+	}
+}
+
+// Creates a DriveClient that paces and retries its calls as options say.
+func NewDriveClient(client *drive.Service, options DriveClientOptions) *DriveClient {
 	return &DriveClient{
 		client:       client,
-		lastCallTime: time.Now().Add(-3 * time.Second), // Allow first call immediately
+		options:      options,
+		lastCallTime: time.Now().Add(-options.MinInterval), // Allow first call immediately
+	}
+}
+
+// Makes every call include Shared Drive items, which Drive leaves out unless asked. With a
+// driveID, listings search only that Shared Drive (corpora=drive), the cheapest option when
+// the synced folder lives in one; without, they search every drive the credentials can see
+// (corpora=allDrives).
+func (d *DriveClient) SetSharedDrive(driveID string) {
+	d.sharedDrives = true
+	d.driveID = driveID
+}
+
+// Starts a files.list call with the Shared Drive options applied.
+func (d *DriveClient) listCall(ctx context.Context) *drive.FilesListCall {
+	call := d.client.Files.List().Context(ctx)
+	if !d.sharedDrives {
+		return call
 	}
+
+	call = call.SupportsAllDrives(true).IncludeItemsFromAllDrives(true)
+	if d.driveID != "" {
+		return call.Corpora("drive").DriveId(d.driveID)
+	}
+	return call.Corpora("allDrives")
+}
+
+// Starts a files.get call with the Shared Drive options applied.
+func (d *DriveClient) getCall(ctx context.Context, id string) *drive.FilesGetCall {
+	return d.client.Files.Get(id).Context(ctx).SupportsAllDrives(d.sharedDrives)
+}
+
+// Fields of a file that listings, lookups and changes fetch: everything SyncFile needs, including
+// where a shortcut points.
+const driveFileFields = "id, name, mimeType, size, md5Checksum, createdTime, modifiedTime, description, appProperties, imageMediaMetadata, videoMediaMetadata, shortcutDetails(targetId, targetMimeType)"
+
+// Escapes a value for a single-quoted string in a Drive query: backslashes first, then quotes,
+// both with a backslash. IDs (including Shared Drive folder IDs) never need it, but names can.
+func escapeDriveQuery(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return strings.ReplaceAll(value, "'", `\'`)
 }
 
-// Ensures at least 3 seconds between Drive API calls to avoid rate limiting.
-func (d *DriveClient) waitForRateLimit() {
+// Ensures at least MinInterval between Drive API calls to avoid rate limiting. Concurrent callers
+// queue on the lock, so they are spaced out too. Returns ctx.Err() without making room for a call
+// if ctx is done while waiting.
+func (d *DriveClient) waitForRateLimit(ctx context.Context) error {
 	d.rateLimitMu.Lock()
 	defer d.rateLimitMu.Unlock()
 
-	const minDelay = 5 * time.Second
 	elapsed := time.Since(d.lastCallTime)
-	if elapsed < minDelay {
-		time.Sleep(minDelay - elapsed)
+	if elapsed < d.options.MinInterval {
+		if err := sleepContext(ctx, d.options.MinInterval-elapsed); err != nil {
+			return err
+		}
 	}
 	d.lastCallTime = time.Now()
+	return nil
+}
+
+// Ping fetches the ID of a folder to prove the API and credentials work. It skips the
+// inter-call delay, which would outlast a health check's timeout.
+func (d *DriveClient) Ping(ctx context.Context, folderID string) error {
+	if d.client == nil {
+		return fmt.Errorf("drive client is nil")
+	}
+	if _, err := d.getCall(ctx, folderID).Fields("id").Do(); err != nil {
+		return fmt.Errorf("failed to read folder %s: %w", folderID, classifyError(err))
+	}
+	return nil
 }
 
 // Find looks up a Drive file by exact name inside a folder with retry logic.
@@ -47,88 +130,63 @@ func (d *DriveClient) Find(ctx context.Context, folderID, name string) (*drive.F
 		return nil, fmt.Errorf("drive client is nil")
 	}
 
-	const maxRetries = 3
-	backoff := 5 * time.Second
-
-	// Escape single quotes in folder ID and name to prevent query injection
-	// Per Drive API docs, single quotes should be escaped by doubling them
-	escapedFolderID := strings.ReplaceAll(folderID, "'", "\\'")
-	escapedName := strings.ReplaceAll(name, "'", "\\'")
-	q := fmt.Sprintf("'%s' in parents and name='%s' and trashed=false", escapedFolderID, escapedName)
+	// Escape the folder ID and name to prevent query injection
+	q := fmt.Sprintf("'%s' in parents and name='%s' and trashed=false", escapeDriveQuery(folderID), escapeDriveQuery(name))
 
-	for attempt := 0; attempt <= maxRetries; attempt++ {
-		d.waitForRateLimit()
-
-		list, err := d.client.Files.List().Context(ctx).
+	var list *drive.FileList
+	err := d.callWithRetry(ctx, func() (err error) {
+		list, err = d.listCall(ctx).
 			Q(q).
-			Fields("files(id, name, mimeType, size, createdTime, modifiedTime, imageMediaMetadata, videoMediaMetadata)").
+			Fields("files(" + driveFileFields + ")").
 			Do()
-		if err != nil {
-			// Check for rate limit errors using proper type assertion
-			var apiErr *googleapi.Error
-			if errors.As(err, &apiErr) && (apiErr.Code == 403 || apiErr.Code == 429) {
-				if attempt < maxRetries {
-					sleepDuration := backoff * time.Duration(1<<uint(attempt))
-					time.Sleep(sleepDuration)
-					continue
-				}
-			}
-			return nil, err
-		}
-
-		if len(list.Files) == 0 {
-			return nil, fmt.Errorf("file not found in drive: %s", name)
-		}
-
-		return list.Files[0], nil
+		return err
+	})
+	if err != nil {
+		return nil, classifyError(err)
 	}
 
-	return nil, fmt.Errorf("failed to find file after %d retries", maxRetries)
+	if len(list.Files) == 0 {
+		return nil, fmt.Errorf("%w: file not found in drive: %s", apperrors.ErrNotFound, name)
+	}
+	return list.Files[0], nil
 }
 
-// Downloads the file content from Google Drive with exponential backoff retry.
+// Downloads the file content from Google Drive into memory with exponential backoff retry.
 func (d *DriveClient) DownloadBytes(ctx context.Context, id string) ([]byte, error) {
-	const maxRetries = 5
-	backoff := 5 * time.Second
-
-	for attempt := 0; attempt <= maxRetries; attempt++ {
-		if attempt > 0 {
-			fmt.Printf("[DriveClient] Retry attempt %d/%d for file %s\n", attempt, maxRetries, id)
-		}
-
-		d.waitForRateLimit()
-
-		fmt.Printf("[DriveClient] Making download request for file %s\n", id)
-		resp, err := d.client.Files.Get(id).Context(ctx).Download()
-		if err != nil {
-			fmt.Printf("[DriveClient] Download request failed: %v\n", err)
-			// Check for rate limit errors using proper type assertion
-			var apiErr *googleapi.Error
-			if errors.As(err, &apiErr) && (apiErr.Code == 403 || apiErr.Code == 429) {
-				if attempt < maxRetries {
-					// Exponential backoff: 5s, 10s, 20s, 40s, 80s
-					sleepDuration := backoff * time.Duration(1<<uint(attempt))
-					fmt.Printf("[DriveClient] Rate limited (HTTP %d), sleeping for %v\n", apiErr.Code, sleepDuration)
-					time.Sleep(sleepDuration)
-					continue // Retry
-				}
-			}
-			return nil, err
-		}
-		defer resp.Body.Close()
+	var buf bytes.Buffer
+	if _, err := d.DownloadToFile(ctx, id, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
-		fmt.Printf("[DriveClient] Reading response body for file %s\n", id)
-		data, err := io.ReadAll(resp.Body)
+// Streams the file content from Google Drive into w, returning the number of bytes written.
+// Requests are retried with exponential backoff, but a body cut off midway isn't, since part of
+// it has already been written to w.
+func (d *DriveClient) DownloadToFile(ctx context.Context, id string, w io.Writer) (int64, error) {
+	log.Printf("[DriveClient] Making download request for file %s", id)
+	var resp *http.Response
+	err := d.callWithRetry(ctx, func() (err error) {
+		resp, err = d.getCall(ctx, id).Download()
 		if err != nil {
-			fmt.Printf("[DriveClient] Failed to read response body: %v\n", err)
-			return nil, fmt.Errorf("failed to read response body: %w", err)
+			log.Printf("[DriveClient] Download request failed: %v", err)
 		}
+		return err
+	})
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	defer resp.Body.Close()
 
-		fmt.Printf("[DriveClient] Successfully downloaded %d bytes for file %s\n", len(data), id)
-		return data, nil
+	log.Printf("[DriveClient] Reading response body for file %s", id)
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		log.Printf("[DriveClient] Failed to read response body: %v", err)
+		return n, fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	return nil, fmt.Errorf("failed to download file after %d retries", maxRetries)
+	log.Printf("[DriveClient] Successfully downloaded %d bytes for file %s", n, id)
+	return n, nil
 }
 
 // Lists all files in the specified Drive folder (paginated) with retry logic.
@@ -140,48 +198,24 @@ func (d *DriveClient) ListFilesInFolder(ctx context.Context, folderID string) ([
 	var allFiles []*drive.File
 	pageToken := ""
 
-	// Escape single quotes in folder ID to prevent query injection
-	escapedFolderID := strings.ReplaceAll(folderID, "'", "\\'")
-	query := fmt.Sprintf("'%s' in parents and trashed=false", escapedFolderID)
+	// Escape the folder ID to prevent query injection
+	query := fmt.Sprintf("'%s' in parents and trashed=false", escapeDriveQuery(folderID))
 
 	for {
-		const maxRetries = 3
-		backoff := 5 * time.Second
 		var fileList *drive.FileList
-		var err error
-
-		// Retry logic for each page
-		for attempt := 0; attempt <= maxRetries; attempt++ {
-			d.waitForRateLimit()
-
-			call := d.client.Files.List().
-				Context(ctx).
+		err := d.callWithRetry(ctx, func() (err error) {
+			call := d.listCall(ctx).
 				Q(query).
-				Fields("nextPageToken, files(id, name, mimeType, size, createdTime, modifiedTime, imageMediaMetadata, videoMediaMetadata)").
+				Fields("nextPageToken, files(" + driveFileFields + ")").
 				PageSize(1000)
-
 			if pageToken != "" {
 				call = call.PageToken(pageToken)
 			}
-
 			fileList, err = call.Do()
-			if err != nil {
-				// Check for rate limit errors using proper type assertion
-				var apiErr *googleapi.Error
-				if errors.As(err, &apiErr) && (apiErr.Code == 403 || apiErr.Code == 429) {
-					if attempt < maxRetries {
-						sleepDuration := backoff * time.Duration(1<<uint(attempt))
-						time.Sleep(sleepDuration)
-						continue
-					}
-				}
-				return nil, fmt.Errorf("list files failed: %w", err)
-			}
-			break // Success, exit retry loop
-		}
-
+			return err
+		})
 		if err != nil {
-			return nil, fmt.Errorf("list files failed after retries: %w", err)
+			return nil, fmt.Errorf("list files failed: %w", classifyError(err))
 		}
 
 		allFiles = append(allFiles, fileList.Files...)
@@ -194,3 +228,172 @@ func (d *DriveClient) ListFilesInFolder(ctx context.Context, folderID string) ([
 
 	return allFiles, nil
 }
+
+// Runs a Drive call, waiting for the rate limiter before each attempt and retrying up to
+// MaxRetries times while Drive answers 403/429, with exponential backoff from BaseBackoff plus up
+// to 50% jitter (5s, 10s, 20s and so on by default). Other errors, and the last 403/429, are
+// returned as is; ctx.Err() is returned as soon as ctx is done while waiting.
+func (d *DriveClient) callWithRetry(ctx context.Context, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		if err := d.waitForRateLimit(ctx); err != nil {
+			return err
+		}
+
+		err := call()
+		var apiErr *googleapi.Error
+		if err == nil || attempt >= d.options.MaxRetries || !errors.As(err, &apiErr) || (apiErr.Code != 403 && apiErr.Code != 429) {
+			return err
+		}
+
+		delay := d.options.BaseBackoff * time.Duration(1<<uint(attempt))
+		if delay > 0 {
+			delay += rand.N(delay / 2)
+		}
+		log.Printf("[DriveClient] Rate limited (HTTP %d), retry %d/%d in %v", apiErr.Code, attempt+1, d.options.MaxRetries, delay.Round(time.Millisecond))
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Fetches a file's name, parents and trashed flag by ID. A file that was deleted, or that the
+// credentials can no longer see, fails with ErrNotFound.
+func (d *DriveClient) GetFile(ctx context.Context, id string) (*drive.File, error) {
+	if d.client == nil {
+		return nil, fmt.Errorf("drive client is nil")
+	}
+
+	var file *drive.File
+	err := d.callWithRetry(ctx, func() (err error) {
+		file, err = d.getCall(ctx, id).Fields("id, name, parents, trashed").Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get file %s failed: %w", id, classifyError(err))
+	}
+	return file, nil
+}
+
+// Fetches a file by ID with the fields listings return, e.g. to sync the target of a shortcut.
+// A file that doesn't exist, or that the credentials can't see, fails with ErrNotFound.
+func (d *DriveClient) GetFileDetails(ctx context.Context, id string) (*drive.File, error) {
+	if d.client == nil {
+		return nil, fmt.Errorf("drive client is nil")
+	}
+
+	var file *drive.File
+	err := d.callWithRetry(ctx, func() (err error) {
+		file, err = d.getCall(ctx, id).Fields(driveFileFields).Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get file %s failed: %w", id, classifyError(err))
+	}
+	return file, nil
+}
+
+// Returns the Changes API page token for the current state of the Drive: changes listed from it
+// are the ones made after this call.
+func (d *DriveClient) GetStartPageToken(ctx context.Context) (string, error) {
+	if d.client == nil {
+		return "", fmt.Errorf("drive client is nil")
+	}
+
+	var token *drive.StartPageToken
+	err := d.callWithRetry(ctx, func() (err error) {
+		call := d.client.Changes.GetStartPageToken().Context(ctx).SupportsAllDrives(d.sharedDrives)
+		if d.driveID != "" {
+			call = call.DriveId(d.driveID)
+		}
+		token, err = call.Do()
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("get start page token failed: %w", classifyError(err))
+	}
+	return token.StartPageToken, nil
+}
+
+// Lists every change after pageToken, following pages until Drive hands out the token to resume
+// from next time, which is returned with the changes. Removed files are included (Removed set,
+// File nil), as are trashed ones (File.Trashed set).
+func (d *DriveClient) ListChanges(ctx context.Context, pageToken string) ([]*drive.Change, string, error) {
+	if d.client == nil {
+		return nil, "", fmt.Errorf("drive client is nil")
+	}
+
+	var changes []*drive.Change
+	for {
+		var list *drive.ChangeList
+		err := d.callWithRetry(ctx, func() (err error) {
+			call := d.client.Changes.List(pageToken).Context(ctx)
+			if d.sharedDrives {
+				call = call.SupportsAllDrives(true).IncludeItemsFromAllDrives(true)
+				if d.driveID != "" {
+					call = call.DriveId(d.driveID)
+				}
+			}
+			list, err = call.
+				IncludeRemoved(true).
+				Fields("nextPageToken, newStartPageToken, changes(fileId, removed, time, file(" + driveFileFields + ", parents, trashed))").
+				PageSize(1000).
+				Do()
+			return err
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("list changes failed: %w", classifyError(err))
+		}
+
+		changes = append(changes, list.Changes...)
+
+		if list.NewStartPageToken != "" {
+			return changes, list.NewStartPageToken, nil
+		}
+		pageToken = list.NextPageToken
+	}
+}
+
+// MIME types Drive gives folders and shortcuts, and the prefix of every Google-native type (Docs,
+// Sheets, Forms and so on, which have no bytes to download).
+const (
+	driveFolderMimeType    = "application/vnd.google-apps.folder"
+	driveShortcutMimeType  = "application/vnd.google-apps.shortcut"
+	googleNativeMimePrefix = "application/vnd.google-apps."
+)
+
+// Lists the files in a folder and all its subfolders, breadth first, one rate-limited listing per
+// folder page. Folders reachable twice (Drive allows several parents) are listed once. Returns
+// the files without the folders, and every folder's path relative to folderID ("" for folderID
+// itself, "2024/Trips" for a nested one), keyed by folder ID.
+func (d *DriveClient) ListFilesRecursive(ctx context.Context, folderID string) ([]*drive.File, map[string]string, error) {
+	folders := map[string]string{folderID: ""}
+	queue := []string{folderID}
+	var files []*drive.File
+
+	for len(queue) > 0 {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		current := queue[0]
+		queue = queue[1:]
+
+		children, err := d.ListFilesInFolder(ctx, current)
+		if err != nil {
+			return nil, nil, fmt.Errorf("list folder %q failed: %w", folders[current], err)
+		}
+
+		for _, child := range children {
+			if child.MimeType != driveFolderMimeType {
+				files = append(files, child)
+				continue
+			}
+			if _, visited := folders[child.Id]; visited {
+				continue
+			}
+			folders[child.Id] = path.Join(folders[current], child.Name)
+			queue = append(queue, child.Id)
+		}
+	}
+
+	return files, folders, nil
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/testutil"
+)
+
+// Returns a fake Drive holding one photo in "folder", and a client for it with options.
+func newFakeDriveClient(t *testing.T, options DriveClientOptions) (*DriveClient, *testutil.FakeDrive) {
+	t.Helper()
+	fake := testutil.NewFakeDrive(t)
+	fake.Add(testutil.DriveFile{ID: "file-1", Name: "IMG_1.jpg", MimeType: "image/jpeg", Parent: "folder", Data: []byte("jpeg bytes")})
+	return NewDriveClient(fake.Service, options), fake
+}
+
+func TestDriveClientPacesCalls(t *testing.T) {
+	const interval = 40 * time.Millisecond
+	client, fake := newFakeDriveClient(t, DriveClientOptions{MinInterval: interval})
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.ListFilesInFolder(context.Background(), "folder"); err != nil {
+				t.Errorf("list: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	calls := fake.Calls()
+	if len(calls) != 4 {
+		t.Fatalf("Drive got %d calls, want 4", len(calls))
+	}
+	// Some slack for the time between leaving the limiter and reaching the server
+	for i := 1; i < len(calls); i++ {
+		if gap := calls[i].Time.Sub(calls[i-1].Time); gap < interval-10*time.Millisecond {
+			t.Errorf("call %d came %v after the one before, want about %v", i, gap, interval)
+		}
+	}
+}
+
+func TestDriveClientRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		failures   []int
+		wantCalls  int
+		wantErr    error
+		wantCode   int
+	}{
+		{"no failures", 3, nil, 1, nil, 0},
+		{"recovers from 429", 3, []int{429, 429}, 3, nil, 0},
+		{"recovers from rate-limit 403", 3, []int{403}, 2, nil, 0},
+		{"gives up after MaxRetries", 2, []int{429, 429, 429, 429}, 3, apperrors.ErrUnavailable, 429},
+		{"no retries configured", 0, []int{429}, 1, apperrors.ErrUnavailable, 429},
+		{"404 is returned at once", 3, []int{404}, 1, apperrors.ErrNotFound, 404},
+		{"500 is returned at once", 3, []int{500}, 1, apperrors.ErrUnavailable, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, fake := newFakeDriveClient(t, DriveClientOptions{MaxRetries: tt.maxRetries, BaseBackoff: 5 * time.Millisecond})
+			fake.FailNext(tt.failures...)
+
+			_, err := client.Find(context.Background(), "folder", "IMG_1.jpg")
+			if got := len(fake.Calls()); got != tt.wantCalls {
+				t.Errorf("Drive got %d calls, want %d", got, tt.wantCalls)
+			}
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("find: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			var apiErr *googleapi.Error
+			if !errors.As(err, &apiErr) || apiErr.Code != tt.wantCode {
+				t.Errorf("err = %v, want Drive's HTTP %d in the chain", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestDriveClientBacksOffExponentially(t *testing.T) {
+	const base = 20 * time.Millisecond
+	client, fake := newFakeDriveClient(t, DriveClientOptions{MaxRetries: 3, BaseBackoff: base})
+	fake.FailNext(http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests)
+
+	if _, err := client.Find(context.Background(), "folder", "IMG_1.jpg"); err != nil {
+		t.Fatalf("find: %v", err)
+	}
+
+	calls := fake.Calls()
+	if len(calls) != 4 {
+		t.Fatalf("Drive got %d calls, want 4", len(calls))
+	}
+	// Retry n waits base·2^n plus up to half that again
+	for n := 0; n < 3; n++ {
+		wait := base * time.Duration(1<<n)
+		gap := calls[n+1].Time.Sub(calls[n].Time)
+		if gap < wait || gap > wait*3/2+50*time.Millisecond {
+			t.Errorf("retry %d came after %v, want %v to %v", n+1, gap, wait, wait*3/2)
+		}
+	}
+}
+
+func TestDriveClientBackoffStopsWhenContextEnds(t *testing.T) {
+	client, fake := newFakeDriveClient(t, DriveClientOptions{MaxRetries: 3, BaseBackoff: time.Minute})
+	fake.FailNext(http.StatusTooManyRequests)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.Find(ctx, "folder", "IMG_1.jpg")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context's error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %v, want soon after the context ended", elapsed)
+	}
+	if got := len(fake.Calls()); got != 1 {
+		t.Errorf("Drive got %d calls, want 1 before the context ended", got)
+	}
+}
+
+// A My Drive folder, and a folder in each of two Shared Drives.
+func newSharedDriveFake(t *testing.T) *testutil.FakeDrive {
+	t.Helper()
+	fake := testutil.NewFakeDrive(t)
+	fake.Add(
+		testutil.DriveFile{ID: "mine", Name: "mine.jpg", MimeType: "image/jpeg", Parent: "photos", Data: []byte("mine")},
+		testutil.DriveFile{ID: "team", Name: "team.jpg", MimeType: "image/jpeg", Parent: "photos", DriveID: "drive-1", Data: []byte("team")},
+		testutil.DriveFile{ID: "other", Name: "other.jpg", MimeType: "image/jpeg", Parent: "photos", DriveID: "drive-2", Data: []byte("other")},
+	)
+	return fake
+}
+
+func TestDriveClientCorporaModes(t *testing.T) {
+	tests := []struct {
+		name        string
+		shared      bool
+		driveID     string
+		wantFiles   []string
+		wantCorpora string
+		wantDriveID string
+	}{
+		{"My Drive", false, "", []string{"mine"}, "", ""},
+		{"all drives", true, "", []string{"mine", "team", "other"}, "allDrives", ""},
+		{"one Shared Drive", true, "drive-1", []string{"team"}, "drive", "drive-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newSharedDriveFake(t)
+			client := NewDriveClient(fake.Service, DriveClientOptions{})
+			if tt.shared {
+				client.SetSharedDrive(tt.driveID)
+			}
+
+			files, err := client.ListFilesInFolder(context.Background(), "photos")
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			var ids []string
+			for _, file := range files {
+				ids = append(ids, file.Id)
+			}
+			if !slices.Equal(ids, tt.wantFiles) {
+				t.Errorf("listed %v, want %v", ids, tt.wantFiles)
+			}
+
+			query := fake.Calls()[0].Query
+			if got := query.Get("corpora"); got != tt.wantCorpora {
+				t.Errorf("corpora = %q, want %q", got, tt.wantCorpora)
+			}
+			if got := query.Get("driveId"); got != tt.wantDriveID {
+				t.Errorf("driveId = %q, want %q", got, tt.wantDriveID)
+			}
+			wantAll := strconv.FormatBool(tt.shared)
+			for _, param := range []string{"supportsAllDrives", "includeItemsFromAllDrives"} {
+				if got := cmp.Or(query.Get(param), "false"); got != wantAll {
+					t.Errorf("%s = %q, want %s", param, got, wantAll)
+				}
+			}
+
+			// Find goes through the same listing
+			_, err = client.Find(context.Background(), "photos", "team.jpg")
+			if found := err == nil; found != slices.Contains(tt.wantFiles, "team") {
+				t.Errorf("Find(team.jpg) err = %v, want found: %v", err, !found)
+			}
+		})
+	}
+}
+
+func TestDriveClientDownloadsFromSharedDrive(t *testing.T) {
+	fake := newSharedDriveFake(t)
+	client := NewDriveClient(fake.Service, DriveClientOptions{})
+
+	if _, err := client.DownloadBytes(context.Background(), "team"); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("download without Shared Drive support: err = %v, want ErrNotFound", err)
+	}
+
+	client.SetSharedDrive("drive-1")
+	data, err := client.DownloadBytes(context.Background(), "team")
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if string(data) != "team" {
+		t.Errorf("downloaded %q, want %q", data, "team")
+	}
+	if got := fake.Calls()[1].Query.Get("supportsAllDrives"); got != "true" {
+		t.Errorf("supportsAllDrives = %q, want true", got)
+	}
+}
+
+func TestDriveClientEscapesQueries(t *testing.T) {
+	tests := []struct {
+		name     string
+		folderID string
+		fileName string
+		wantQ    string
+	}{
+		{"plain", "0AbCdEf-12_xyz", "IMG_1.jpg", `'0AbCdEf-12_xyz' in parents and name='IMG_1.jpg' and trashed=false`},
+		{"quote", "folder", "Dad's boat.jpg", `'folder' in parents and name='Dad\'s boat.jpg' and trashed=false`},
+		{"backslash", "folder", `a\b.jpg`, `'folder' in parents and name='a\\b.jpg' and trashed=false`},
+		{"backslash before quote", "folder", `a\'b.jpg`, `'folder' in parents and name='a\\\'b.jpg' and trashed=false`},
+		{"injection", "folder", "x' or name!='", `'folder' in parents and name='x\' or name!=\'' and trashed=false`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := testutil.NewFakeDrive(t)
+			fake.Add(
+				testutil.DriveFile{ID: "wanted", Name: tt.fileName, MimeType: "image/jpeg", Parent: tt.folderID, DriveID: "drive-1"},
+				testutil.DriveFile{ID: "decoy", Name: "decoy.jpg", MimeType: "image/jpeg", Parent: tt.folderID, DriveID: "drive-1"},
+			)
+			client := NewDriveClient(fake.Service, DriveClientOptions{})
+			client.SetSharedDrive("drive-1")
+
+			file, err := client.Find(context.Background(), tt.folderID, tt.fileName)
+			if err != nil {
+				t.Fatalf("find: %v", err)
+			}
+			if file.Id != "wanted" {
+				t.Errorf("found %q, want the file named %q", file.Id, tt.fileName)
+			}
+			if got := fake.Calls()[0].Query.Get("q"); got != tt.wantQ {
+				t.Errorf("q = %s, want %s", got, tt.wantQ)
+			}
+		})
+	}
+}
+
+func TestDriveClientStopsWhenContextIsCancelled(t *testing.T) {
+	tests := []struct {
+		name    string
+		options DriveClientOptions
+		prepare func(fake *testutil.FakeDrive, client *DriveClient)
+	}{
+		{
+			name:    "mid-backoff",
+			options: DriveClientOptions{MaxRetries: 3, BaseBackoff: time.Minute},
+			prepare: func(fake *testutil.FakeDrive, client *DriveClient) { fake.FailNext(http.StatusTooManyRequests) },
+		},
+		{
+			name:    "waiting for the rate limiter",
+			options: DriveClientOptions{MinInterval: time.Minute},
+			prepare: func(fake *testutil.FakeDrive, client *DriveClient) {
+				// Takes the one call the interval allows
+				if _, err := client.GetFile(context.Background(), "file-1"); err != nil {
+					t.Fatalf("get: %v", err)
+				}
+			},
+		},
+		{
+			name:    "stalled body",
+			prepare: func(fake *testutil.FakeDrive, client *DriveClient) { fake.StallDownloads() },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, fake := newFakeDriveClient(t, tt.options)
+			tt.prepare(fake, client)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+
+			start := time.Now()
+			_, err := client.DownloadBytes(ctx, "file-1")
+			elapsed := time.Since(start)
+			if err == nil {
+				t.Fatal("download succeeded, want it cut off by the cancellation")
+			}
+			if elapsed > 250*time.Millisecond {
+				t.Errorf("returned %v after the call started, want within milliseconds of the cancellation", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+// Removes the documents of synced files that were deleted in Drive, and with deleteObjects their
+// stored objects too. Off by default, since files removed from the folder are otherwise kept.
+func (ds *DriveService) SetPropagateDeletes(enabled, deleteObjects bool) {
+	ds.propagate = enabled
+	ds.deleteObjs = deleteObjects
+}
+
+// Soft-deletes the documents (marking them models.StatusDeleted) of synced files that are gone
+// from the folder in Drive: deleted, trashed, no longer visible to the credentials, or moved out
+// of it. Candidates only start the count: each file is checked with its own files.get call, and
+// removed only when the next pass finds it gone again, so a listing that comes back short can't
+// delete anything. Files found gone once wait in the sync state for that next pass.
+// Returns the file names removed.
+func (ds *DriveService) propagateDeletions(ctx context.Context, candidates []*models.ImageMetadata) []string {
+	if !ds.propagate {
+		return nil
+	}
+	if ds.syncState == nil {
+		ds.logger.Printf("Not propagating deletions: it needs a sync state store")
+		return nil
+	}
+
+	state, err := ds.syncState.Get(ctx, ds.folderID)
+	if err != nil {
+		ds.logger.Printf("Not propagating deletions: %v", err)
+		return nil
+	}
+	docs := make(map[string]*models.ImageMetadata, len(candidates)+len(state.Missing))
+	for _, doc := range candidates {
+		docs[doc.DriveFileID] = doc
+	}
+	for driveFileID := range state.Missing {
+		if _, ok := docs[driveFileID]; !ok {
+			docs[driveFileID] = nil // Looked up if it's confirmed gone
+		}
+	}
+
+	missing := make(map[string]time.Time)
+	var removed []string
+	for driveFileID, doc := range docs {
+		firstMissing, wasMissing := state.Missing[driveFileID]
+		if ctx.Err() != nil {
+			if wasMissing {
+				missing[driveFileID] = firstMissing
+			}
+			continue
+		}
+
+		gone, err := ds.goneFromDrive(ctx, driveFileID)
+		if err != nil {
+			ds.logger.Printf("Failed to check Drive file %s, leaving it as it is: %v", driveFileID, err)
+			if wasMissing {
+				missing[driveFileID] = firstMissing
+			}
+			continue
+		}
+		if !gone {
+			continue
+		}
+		if !wasMissing {
+			ds.logger.Printf("Drive file %s is gone from the folder; removing it if the next sync finds the same", driveFileID)
+			missing[driveFileID] = time.Now()
+			continue
+		}
+
+		if doc == nil {
+			doc, err = ds.firestore.GetImageMetadataByDriveFileID(ctx, driveFileID)
+			if errors.Is(err, apperrors.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				ds.logger.Printf("Failed to look up removed Drive file %s: %v", driveFileID, err)
+				missing[driveFileID] = firstMissing
+				continue
+			}
+		}
+		if doc.Hidden() {
+			continue
+		}
+		if err := ds.removeSynced(ctx, doc); err != nil {
+			ds.logger.Printf("Failed to remove %s (%s), retrying next sync: %v", doc.Id, doc.FileName, err)
+			missing[driveFileID] = firstMissing
+			continue
+		}
+		removed = append(removed, doc.FileName)
+	}
+
+	if len(missing) > 0 || len(state.Missing) > 0 {
+		if err := ds.syncState.SetMissing(ctx, ds.folderID, missing); err != nil {
+			ds.logger.Printf("Failed to save files missing from Drive: %v", err)
+		}
+	}
+	return removed
+}
+
+// Reports whether a synced file is gone from the folder: deleted or no longer visible (404),
+// trashed, or moved outside the synced folder.
+func (ds *DriveService) goneFromDrive(ctx context.Context, driveFileID string) (bool, error) {
+	file, err := ds.driveClient.GetFile(ctx, driveFileID)
+	if errors.Is(err, apperrors.ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if file.Trashed {
+		return true, nil
+	}
+
+	inFolder, err := ds.inSyncedFolder(ctx, file)
+	return !inFolder, err
+}
+
+// Marks a document deleted, deleting its stored object first when SetPropagateDeletes asked to. Should
+// marking fail after the object is gone, reconciliation finds the document dangling.
+func (ds *DriveService) removeSynced(ctx context.Context, doc *models.ImageMetadata) error {
+	if ds.deleteObjs {
+		if _, err := ds.storage.DeleteFileIfExists(ctx, doc.StoragePath); err != nil {
+			return err
+		}
+	}
+	if err := ds.firestore.SetStatus(ctx, doc.Id, models.StatusDeleted, doc.StoragePath); err != nil {
+		return err
+	}
+	if ds.evictCache != nil {
+		ds.evictCache(doc.Id, doc.FileName)
+	}
+
+	if ds.deleteObjs {
+		ds.logger.Printf("Removed %s (%s) and deleted %s: gone from Drive", doc.Id, doc.FileName, doc.StoragePath)
+	} else {
+		ds.logger.Printf("Removed %s (%s), keeping %s: gone from Drive", doc.Id, doc.FileName, doc.StoragePath)
+	}
+	return nil
+}
+
+// Lists the synced documents whose Drive files are missing from a full listing of the folder, as
+// candidates for propagateDeletions. An empty listing, or one missing more than half the synced
+// files, is assumed to be incomplete and nominates none.
+func (ds *DriveService) missingFromListing(ctx context.Context, files []*drive.File) ([]*models.ImageMetadata, error) {
+	if len(files) == 0 {
+		ds.logger.Printf("Drive listing is empty, not checking for deleted files")
+		return nil, nil
+	}
+
+	listed := make(map[string]bool, len(files))
+	for _, file := range files {
+		listed[file.Id] = true
+		// Files synced through a shortcut are documented under their target's ID
+		if file.ShortcutDetails != nil && file.ShortcutDetails.TargetId != "" {
+			listed[file.ShortcutDetails.TargetId] = true
+		}
+	}
+
+	synced := 0
+	var missing []*models.ImageMetadata
+	err := ds.firestore.ForEachImageMetadata(ctx, 500, func(doc *models.ImageMetadata) error {
+		if doc.DriveFileID == "" || doc.Hidden() {
+			return nil
+		}
+		synced++
+		if !listed[doc.DriveFileID] {
+			missing = append(missing, doc)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(missing) > 1 && 2*len(missing) > synced {
+		ds.logger.Printf("Drive listing is missing %d of %d synced files, assuming it is incomplete", len(missing), synced)
+		return nil, nil
+	}
+	return missing, nil
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/utils"
+)
+
+// Narrows a backfill to some of the folder's files. Each condition that is set must hold, so the
+// zero filter passes every file. It is checked against the listing, before anything is downloaded.
+type BackfillFilter struct {
+	Include      []string  // Glob patterns (path.Match syntax, case-sensitive) of which the file name must match one
+	Exclude      []string  // Glob patterns the file name must match none of
+	MimePrefixes []string  // MIME type prefixes (e.g. "video/") of which the file's type must have one
+	CreatedAfter time.Time // Only files created in Drive after this
+}
+
+// Checks the patterns are well formed, returning ErrInvalidInput naming the first that isn't.
+func (f BackfillFilter) Validate() error {
+	for _, pattern := range append(append([]string(nil), f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: bad file name pattern %q", apperrors.ErrInvalidInput, pattern)
+		}
+	}
+	return nil
+}
+
+// Reports whether the filter has any condition set.
+func (f BackfillFilter) IsZero() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0 && len(f.MimePrefixes) == 0 && f.CreatedAfter.IsZero()
+}
+
+// Returns why the filter leaves a file out, or "" if it passes. Patterns must have been validated.
+func (f BackfillFilter) excludes(file *drive.File) string {
+	if len(f.Include) > 0 && !matchesAny(f.Include, file.Name) {
+		return "matches no include pattern"
+	}
+	for _, pattern := range f.Exclude {
+		if ok, _ := path.Match(pattern, file.Name); ok {
+			return "matches exclude pattern " + pattern
+		}
+	}
+	// A shortcut counts as the type of file it points at
+	mimeType := file.MimeType
+	if file.ShortcutDetails != nil && file.ShortcutDetails.TargetMimeType != "" {
+		mimeType = file.ShortcutDetails.TargetMimeType
+	}
+	if len(f.MimePrefixes) > 0 && !hasAnyPrefix(mimeType, f.MimePrefixes) {
+		return "type " + mimeType + " not included"
+	}
+	if !f.CreatedAfter.IsZero() {
+		// A file without a creation time can't be shown to be recent enough
+		if created := utils.ParseDriveTime(file.CreatedTime); !created.After(f.CreatedAfter) {
+			return "created before " + f.CreatedAfter.Format(time.RFC3339)
+		}
+	}
+	return ""
+}
+
+// Describes the filter's conditions for the backfill log.
+func (f BackfillFilter) String() string {
+	var parts []string
+	if len(f.Include) > 0 {
+		parts = append(parts, "include "+strings.Join(f.Include, ","))
+	}
+	if len(f.Exclude) > 0 {
+		parts = append(parts, "exclude "+strings.Join(f.Exclude, ","))
+	}
+	if len(f.MimePrefixes) > 0 {
+		parts = append(parts, "types "+strings.Join(f.MimePrefixes, ","))
+	}
+	if !f.CreatedAfter.IsZero() {
+		parts = append(parts, "created after "+f.CreatedAfter.Format(time.RFC3339))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
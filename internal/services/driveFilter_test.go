@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/testutil"
+)
+
+func TestBackfillFilterExcludes(t *testing.T) {
+	cutoff := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	file := func(name, mimeType, created string) *drive.File {
+		return &drive.File{Name: name, MimeType: mimeType, CreatedTime: created}
+	}
+	photo := file("IMG_1.jpg", "image/jpeg", "2024-06-01T10:00:00.000Z")
+	video := file("VID_1.mp4", "video/mp4", "2024-06-01T10:00:00.000Z")
+	screenshot := file("Screenshot 2024-06-01.png", "image/png", "2024-06-01T10:00:00.000Z")
+	shortcut := &drive.File{
+		Name:            "VID_2.mp4",
+		MimeType:        driveShortcutMimeType,
+		CreatedTime:     "2024-06-01T10:00:00.000Z",
+		ShortcutDetails: &drive.FileShortcutDetails{TargetMimeType: "video/mp4"},
+	}
+
+	tests := []struct {
+		name   string
+		filter BackfillFilter
+		file   *drive.File
+		want   string // Start of the reason; "" for files that pass
+	}{
+		{"zero filter passes everything", BackfillFilter{}, screenshot, ""},
+		{"include match", BackfillFilter{Include: []string{"IMG_*"}}, photo, ""},
+		{"include match in any pattern", BackfillFilter{Include: []string{"VID_*", "*.jpg"}}, photo, ""},
+		{"include miss", BackfillFilter{Include: []string{"VID_*"}}, photo, "matches no include pattern"},
+		{"include is case-sensitive", BackfillFilter{Include: []string{"img_*"}}, photo, "matches no include pattern"},
+		{"exclude match", BackfillFilter{Exclude: []string{"Screenshot*"}}, screenshot, "matches exclude pattern Screenshot*"},
+		{"exclude miss", BackfillFilter{Exclude: []string{"Screenshot*"}}, photo, ""},
+		{"exclude wins over include", BackfillFilter{Include: []string{"*.png"}, Exclude: []string{"Screenshot*"}}, screenshot, "matches exclude pattern"},
+		{"character class", BackfillFilter{Include: []string{"VID_[0-9].mp4"}}, video, ""},
+		{"mime prefix match", BackfillFilter{MimePrefixes: []string{"video/"}}, video, ""},
+		{"mime prefix miss", BackfillFilter{MimePrefixes: []string{"video/"}}, photo, "type image/jpeg not included"},
+		{"any mime prefix", BackfillFilter{MimePrefixes: []string{"video/", "image/jpeg"}}, photo, ""},
+		{"shortcut counts as its target", BackfillFilter{MimePrefixes: []string{"video/"}}, shortcut, ""},
+		{"created after the cutoff", BackfillFilter{CreatedAfter: cutoff}, photo, ""},
+		{"created before the cutoff", BackfillFilter{CreatedAfter: cutoff}, file("old.jpg", "image/jpeg", "2024-04-30T23:59:59.000Z"), "created before 2024-05-01T00:00:00Z"},
+		{"created at the cutoff", BackfillFilter{CreatedAfter: cutoff}, file("edge.jpg", "image/jpeg", "2024-05-01T00:00:00.000Z"), "created before"},
+		{"no creation time", BackfillFilter{CreatedAfter: cutoff}, file("unknown.jpg", "image/jpeg", ""), "created before"},
+		{"every condition holds", BackfillFilter{Include: []string{"VID_*"}, Exclude: []string{"*_old.*"}, MimePrefixes: []string{"video/"}, CreatedAfter: cutoff}, video, ""},
+		{"one condition fails", BackfillFilter{Include: []string{"VID_*"}, MimePrefixes: []string{"video/"}, CreatedAfter: cutoff.AddDate(1, 0, 0)}, video, "created before"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.filter.excludes(tt.file)
+			if (got == "") != (tt.want == "") || !strings.HasPrefix(got, tt.want) {
+				t.Errorf("excludes = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackfillFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  BackfillFilter
+		wantErr bool
+	}{
+		{"zero filter", BackfillFilter{}, false},
+		{"good patterns", BackfillFilter{Include: []string{"IMG_*", "VID_?.mp4"}, Exclude: []string{"[Ss]creenshot*"}}, false},
+		{"bad include", BackfillFilter{Include: []string{"IMG_["}}, true},
+		{"bad exclude", BackfillFilter{Include: []string{"IMG_*"}, Exclude: []string{`\`}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.filter.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, apperrors.ErrInvalidInput) {
+				t.Errorf("err = %v, want ErrInvalidInput", err)
+			}
+		})
+	}
+}
+
+func TestBackfillFilterIsZero(t *testing.T) {
+	if !(BackfillFilter{}).IsZero() {
+		t.Error("zero filter isn't zero")
+	}
+	for _, filter := range []BackfillFilter{
+		{Include: []string{"*"}},
+		{Exclude: []string{"*"}},
+		{MimePrefixes: []string{"video/"}},
+		{CreatedAfter: time.Now()},
+	} {
+		if filter.IsZero() {
+			t.Errorf("%+v is zero", filter)
+		}
+	}
+}
+
+func TestBackfillFiltersBeforeDownloading(t *testing.T) {
+	ds, fake := newFakeDriveService(t,
+		testutil.DriveFile{ID: "shot", Name: "Screenshot 1.png", MimeType: "image/png", Parent: "folder", Data: []byte("png")},
+		testutil.DriveFile{ID: "photo", Name: "IMG_1.jpg", MimeType: "image/jpeg", Parent: "folder", Data: []byte("jpeg")},
+	)
+	ds.SetFileDelay(0)
+
+	report, err := ds.BackfillFromDrive(context.Background(), BackfillOptions{
+		Filter: BackfillFilter{Exclude: []string{"Screenshot*"}, MimePrefixes: []string{"video/"}},
+	})
+	if err != nil {
+		t.Fatalf("backfill: %v", err)
+	}
+	if report.Listed != 2 || report.Filtered != 2 || len(report.Files) != 0 {
+		t.Errorf("report listed %d, filtered %d and synced %d files, want 2, 2 and 0", report.Listed, report.Filtered, len(report.Files))
+	}
+	for _, call := range fake.Calls() {
+		if call.Method != "list" {
+			t.Errorf("Drive got a %s call for %s, want only the listing", call.Method, call.FileID)
+		}
+	}
+}
+
+func TestBackfillRejectsBadPatterns(t *testing.T) {
+	ds, fake := newFakeDriveService(t)
+
+	_, err := ds.BackfillFromDrive(context.Background(), BackfillOptions{Filter: BackfillFilter{Include: []string{"["}}})
+	if !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("err = %v, want ErrInvalidInput", err)
+	}
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("Drive got %d calls, want none", len(calls))
+	}
+}
@@ -2,74 +2,316 @@ package services
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/googleapi"
 
+	apperrors "trekka-api/internal/errors"
 	"trekka-api/internal/models"
 	"trekka-api/internal/utils"
 )
 
 type DriveService struct {
-	driveClient *DriveClient
-	storage     *StorageService
-	firestore   *FirestoreService
-	folderID    string
-	geocoder    *GeocodingService
-	logger      *log.Logger
+	driveClient  *DriveClient
+	storage      *StorageService
+	firestore    *FirestoreService
+	folderID     string
+	geocoder     Geocoder
+	storagePaths StoragePathStrategy  // Where newly stored files go in the bucket
+	metrics      *SyncMetrics         // Optional; aggregates stage timings of every synced file
+	quarantine   *QuarantineService   // Optional; sets aside files that keep failing
+	evictCache   func(keys ...string) // Optional; drops cached entries (e.g. not-found tombstones) of synced files
+	syncState    *SyncStateStore      // Optional; keeps the Changes API page token for incremental sync
+	lock         *SyncLock            // Optional; keeps other instances from syncing at the same time
+	runs         *SyncRunStore        // Optional; keeps a history of backfills and watch ticks
+	recursive    bool                 // Also sync files in subfolders of folderID
+	streamAbove  int64                // Files larger than this go through a temp file instead of memory (0 never)
+	force        bool                 // Download and upload files even when Drive's MD5 matches the stored copy
+	fileDelay    time.Duration        // Pause between files during a backfill
+	lookback     time.Duration        // How far back the polling watch looks when it has no saved last check
+	propagate    bool                 // Remove documents of files deleted in Drive
+	deleteObjs   bool                 // With propagate, delete their stored objects too
+	foldersMu    sync.Mutex
+	folders      map[string]string // Folder ID -> path under folderID, with recursive; nil until listed
+	backfilling  atomic.Bool       // Set while a backfill runs
+	reportMu     sync.Mutex
+	lastReport   *models.BackfillReport // Of the latest backfill to finish
+	pauseMu      sync.Mutex
+	pausedAt     time.Time // When Pause was called; zero while syncing
+	logger       *log.Logger
 }
 
 func NewDriveService(
 	driveClient *DriveClient,
 	storage *StorageService,
 	firestore *FirestoreService,
-	geocoder *GeocodingService,
+	geocoder Geocoder,
 	folderID string,
 ) *DriveService {
 	logger := log.New(os.Stdout, "[DriveSync] ", log.LstdFlags)
 	return &DriveService{
-		driveClient: driveClient,
-		storage:     storage,
-		firestore:   firestore,
-		folderID:    folderID,
-		geocoder:    geocoder,
-		logger:      logger,
+		driveClient:  driveClient,
+		storage:      storage,
+		firestore:    firestore,
+		folderID:     folderID,
+		geocoder:     geocoder,
+		storagePaths: DatedStoragePaths,
+		streamAbove:  DefaultStreamThreshold,
+		fileDelay:    2 * time.Second,
+		lookback:     DefaultWatchLookback,
+		logger:       logger,
 	}
 }
 
-// Synchronizes a single Drive file. It downloads the file, converts HEIC to JPEG
-// when needed, uploads to Storage, then resolves and persists metadata in Firestore.
-// If skipExisting is true, files that already exist in Firestore will be skipped entirely.
-func (ds *DriveService) SyncFile(ctx context.Context, file *drive.File, skipExisting bool) error {
+// Size above which SyncFile streams a file through a temp file unless SetStreamThreshold changes it.
+const DefaultStreamThreshold = 100 << 20
+
+// Sets the size above which files are streamed from Drive to a temp file and from there to
+// Storage, rather than held in memory; 0 keeps every file in memory. HEIC files are always held
+// in memory, since conversion needs the whole image.
+func (ds *DriveService) SetStreamThreshold(bytes int64) {
+	ds.streamAbove = bytes
+}
+
+// Sets the pause between files during a backfill (2 seconds unless set), on top of the client's
+// spacing of individual Drive calls.
+func (ds *DriveService) SetFileDelay(delay time.Duration) {
+	ds.fileDelay = delay
+}
+
+// How far back the polling watch looks on its first tick when no last check was saved, unless
+// SetWatchLookback changes it.
+const DefaultWatchLookback = 24 * time.Hour
+
+// Sets how far back the polling watch looks for new files when it starts without a saved last
+// check (a first start, or no sync state store).
+func (ds *DriveService) SetWatchLookback(lookback time.Duration) {
+	ds.lookback = lookback
+}
+
+// Makes SyncFile download and upload every file it processes, even when Drive's MD5 shows the
+// copy in Storage is unchanged.
+func (ds *DriveService) SetForce(force bool) {
+	ds.force = force
+}
+
+// Sets where newly synced files are stored (DatedStoragePaths unless set). Files already stored
+// keep their key; `trekka-admin relocate` moves them.
+func (ds *DriveService) SetStoragePathStrategy(strategy StoragePathStrategy) {
+	ds.storagePaths = strategy
+}
+
+// Aggregates the stage timings of every file synced from now on into metrics.
+func (ds *DriveService) SetMetrics(metrics *SyncMetrics) {
+	ds.metrics = metrics
+}
+
+// Counts failures per file and skips files that have been quarantined.
+func (ds *DriveService) SetQuarantine(quarantine *QuarantineService) {
+	ds.quarantine = quarantine
+}
+
+// Evicts the cached entries of every file synced from now on, under its Drive and stored names,
+// so a not-found answer cached before the file arrived doesn't outlive it.
+func (ds *DriveService) SetCacheEvictor(evict func(keys ...string)) {
+	ds.evictCache = evict
+}
+
+// Persists the Changes API page token, enabling incremental sync (SyncChanges) in WatchForChanges.
+func (ds *DriveService) SetSyncState(store *SyncStateStore) {
+	ds.syncState = store
+}
+
+// Makes watch ticks and backfills (other than dry runs) hold lock while they run, so only one
+// instance syncs at a time. A tick finding the lock held by another instance is skipped, and a
+// backfill fails with ErrSyncLocked.
+func (ds *DriveService) SetSyncLock(lock *SyncLock) {
+	ds.lock = lock
+}
+
+// Records every backfill (other than dry runs) and watch tick in store once it finishes, with its
+// trigger, counts and first errors.
+func (ds *DriveService) SetSyncRuns(store *SyncRunStore) {
+	ds.runs = store
+}
+
+// Lists up to limit recorded sync runs, newest first; none without a sync run store.
+func (ds *DriveService) SyncRuns(ctx context.Context, limit int) ([]*models.SyncRun, error) {
+	if ds.runs == nil {
+		return []*models.SyncRun{}, nil
+	}
+	return ds.runs.List(ctx, limit)
+}
+
+// Starts the record of a sync run, to count files into and pass to recordRun.
+func (ds *DriveService) newRun(trigger, mode string) *models.SyncRun {
+	return &models.SyncRun{FolderID: ds.folderID, Trigger: trigger, Mode: mode, StartedAt: time.Now()}
+}
+
+// Saves a finished run with the error it ended with, if any. Failing to save it is only logged;
+// the write gets its own context, since the run's may have been cancelled (e.g. on shutdown).
+func (ds *DriveService) recordRun(run *models.SyncRun, err error) {
+	if ds.runs == nil {
+		return
+	}
+	run.FinishedAt = time.Now()
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := ds.runs.Record(ctx, run); err != nil {
+		ds.logger.Printf("Sync run history: %v", err)
+	}
+}
+
+// Takes the sync lock for work that writes, returning the context to run it under (cancelled if
+// the lease is lost) and the func to call when it's done. Without a lock, ctx is returned as is.
+func (ds *DriveService) lockSync(ctx context.Context) (context.Context, func(), error) {
+	if ds.lock == nil {
+		return ctx, func() {}, nil
+	}
+	return ds.lock.Acquire(ctx)
+}
+
+// Makes backfills, polling and incremental sync include files in every subfolder of the synced
+// folder, not just those directly in it.
+func (ds *DriveService) SetRecursive(recursive bool) {
+	ds.recursive = recursive
+}
+
+// Checks that the Drive API can read the synced folder.
+func (ds *DriveService) Ping(ctx context.Context) error {
+	return ds.driveClient.Ping(ctx, ds.folderID)
+}
+
+// Controls how SyncFile treats a file.
+type SyncOptions struct {
+	SkipExisting bool // Skip files already in Firestore, unless modified in Drive since their last sync
+	DryRun       bool // Decide whether the file would be synced, but download, upload and write nothing
+}
+
+// Synchronizes a single Drive file. It downloads the file, converts HEIC to JPEG when needed,
+// extracts its metadata, uploads it to Storage under the key the storage path strategy picks,
+// then persists the metadata in Firestore. Files above the stream threshold are streamed from
+// Drive to a temp file and from there to Storage, with exiftool reading the temp file. A file
+// whose Drive MD5 matches the stored copy is re-extracted from Storage without a download or upload.
+// With SkipExisting, files that already exist in Firestore will be skipped entirely.
+// Either way, a file modified in Drive after its last sync is synced again. With DryRun, the
+// file is only looked up, and the result says whether and why it would be synced.
+// The result carries the file's status (and why it was skipped or failed) and how long each stage
+// took; it is non-nil even when an error is returned.
+// Quarantined files are skipped; other failures count towards quarantining the file.
+// A shortcut is resolved and its target synced in its place (one whose target is gone or behind
+// too many shortcuts is skipped), and Google-native files such as Docs and Sheets are skipped.
+func (ds *DriveService) SyncFile(ctx context.Context, file *drive.File, options SyncOptions) (result *models.SyncResult, err error) {
+	result = &models.SyncResult{FileName: file.Name}
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+		switch {
+		case err != nil:
+			result.Status = models.SyncStatusFailed
+			result.Reason = err.Error()
+		case result.Status == "":
+			result.Status = models.SyncStatusSynced
+		}
+	}()
+
+	if file.MimeType == driveShortcutMimeType {
+		target, err := ds.resolveShortcut(ctx, file)
+		if errors.Is(err, errUnresolvableShortcut) {
+			ds.logger.Printf("Skipping shortcut %s: %v", file.Name, err)
+			result.Status, result.Reason = models.SyncStatusSkippedShortcut, err.Error()
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		ds.logger.Printf("Shortcut %s points at %s (%s)", file.Name, target.Name, target.Id)
+		result.FileName, result.Shortcut = target.Name, file.Name
+		file = target
+	}
+	if strings.HasPrefix(file.MimeType, googleNativeMimePrefix) {
+		ds.logger.Printf("Skipping Google-native file: %s (%s)", file.Name, file.MimeType)
+		result.Status, result.Reason = models.SyncStatusSkippedNative, file.MimeType
+		return result, nil
+	}
+
 	// Accept both images and videos
 	isImage := strings.HasPrefix(file.MimeType, "image/")
 	isVideo := strings.HasPrefix(file.MimeType, "video/")
 
 	if !isImage && !isVideo {
 		ds.logger.Printf("Skipping non-media file: %s (%s)", file.Name, file.MimeType)
-		return nil
+		result.Status, result.Reason = models.SyncStatusSkippedNonMedia, file.MimeType
+		return result, nil
+	}
+
+	if ds.quarantine != nil {
+		quarantined, err := ds.quarantine.IsQuarantined(ctx, file.Name)
+		if err != nil {
+			return result, err
+		}
+		if quarantined {
+			ds.logger.Printf("Quarantined, skipping: %s", file.Name)
+			result.Status = models.SyncStatusSkippedQuarantined
+			return result, nil
+		}
 	}
 
 	ds.logger.Printf("Processing %s (%s) [%s]", file.Name, file.Id, file.MimeType)
 
 	// Check if file already exists in Firestore
-	existing, _ := ds.firestore.GetImageMetadataByFilename(ctx, file.Name, file.FileExtension)
+	existing, err := ds.firestore.GetImageMetadataByFilename(ctx, file.Name, file.FileExtension)
+	if err != nil && !errors.Is(err, apperrors.ErrNotFound) {
+		// A failed lookup isn't "missing"; creating here would duplicate the document
+		return result, fmt.Errorf("lookup existing metadata failed: %w", err)
+	}
+	if existing != nil && existing.DriveFileID != "" && existing.DriveFileID != file.Id {
+		// Same name, different Drive file: its own document (if any) is the one to update
+		existing, err = ds.firestore.GetImageMetadataByDriveFileID(ctx, file.Id)
+		if err != nil && !errors.Is(err, apperrors.ErrNotFound) {
+			return result, fmt.Errorf("lookup existing metadata failed: %w", err)
+		}
+	}
 
-	if skipExisting && existing != nil {
+	modified := existing != nil && modifiedSinceSync(file, existing)
+	switch {
+	case modified:
+		ds.logger.Printf("Modified in Drive since last sync, syncing again: %s", file.Name)
+		result.Reason = "modified in Drive since last sync"
+	case options.SkipExisting && existing != nil:
 		ds.logger.Printf("File already exists in Firestore, skipping: %s", file.Name)
-		return nil
+		result.Status, result.Reason = models.SyncStatusSkippedExisting, "exists as "+existing.Id
+		return result, nil
+	case existing != nil && !utils.HasEmptyFields(existing):
+		ds.logger.Printf("Already has complete metadata, skipping: %s", file.Name)
+		result.Status, result.Reason = models.SyncStatusSkippedComplete, "exists as "+existing.Id
+		return result, nil
+	}
+	if options.DryRun {
+		return ds.planSync(ctx, file, existing, result), nil
 	}
+	defer func() { ds.metrics.Record(file.Name, result.Timings) }()
+	defer func() { ds.recordOutcome(ctx, file.Name, err) }()
 
-	if existing != nil && !utils.HasEmptyFields(existing) {
-		ds.logger.Printf("Already has complete metadata, skipping: %s", file.Name)
-		return nil
+	if existing != nil && ds.unchangedInStorage(ctx, file, existing) {
+		return ds.reextractStored(ctx, file, existing, result)
 	}
 
 	// Download and prepare file
@@ -77,23 +319,39 @@ func (ds *DriveService) SyncFile(ctx context.Context, file *drive.File, skipExis
 	downloadCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
-	raw, err := ds.driveClient.DownloadBytes(downloadCtx, file.Id)
-	if err != nil {
-		return fmt.Errorf("download from drive failed: %w", err)
-	}
-
 	finalName := file.Name
 	finalMime := file.MimeType
-	finalData := raw
+	var source mediaSource
+
+	stageStart := time.Now()
+	if ds.streams(file) {
+		ds.logger.Printf("Streaming %s (%d bytes) through a temp file", file.Name, file.Size)
+		tmpPath, err := ds.downloadToTemp(downloadCtx, file)
+		result.Timings.Download = time.Since(stageStart)
+		if err != nil {
+			return result, fmt.Errorf("download from drive failed: %w", err)
+		}
+		defer os.Remove(tmpPath)
+		source.path = tmpPath
+	} else {
+		raw, err := ds.driveClient.DownloadBytes(downloadCtx, file.Id)
+		result.Timings.Download = time.Since(stageStart)
+		if err != nil {
+			return result, fmt.Errorf("download from drive failed: %w", err)
+		}
+		source.data = raw
+	}
 
 	// Convert HEIC → JPEG if needed
 	if utils.IsHeifLike(file.MimeType) {
 		ds.logger.Printf("Converting HEIC -> JPEG: %s", file.Name)
-		jpeg, err := utils.ConvertHeicToJpeg(raw)
+		stageStart = time.Now()
+		jpeg, err := utils.ConvertHeicToJpeg(source.data)
+		result.Timings.Convert = time.Since(stageStart)
 		if err != nil {
 			ds.logger.Printf("HEIC conversion failed for %s: %v — continuing with original", file.Name, err)
 		} else {
-			finalData = jpeg
+			source.data = jpeg
 			finalMime = "image/jpeg"
 			if ext := filepath.Ext(file.Name); ext != "" {
 				finalName = strings.TrimSuffix(file.Name, ext) + ".jpg"
@@ -101,66 +359,660 @@ func (ds *DriveService) SyncFile(ctx context.Context, file *drive.File, skipExis
 		}
 	}
 
+	// Extract before uploading, since the storage path depends on the capture date
+	ds.logger.Printf("Extracting metadata from file: %s", finalName)
+	extracted, err := extractMetadata(ctx, ds.firestore, ds.geocoder, finalName, finalMime, source, &result.Timings)
+	if err != nil {
+		return result, err
+	}
+
+	storagePath, existing, err := ds.storagePathFor(ctx, file, finalName, extracted.TakenAt, existing)
+	if err != nil {
+		return result, err
+	}
+	extracted.StoragePath = storagePath
+	setDriveFields(extracted, file)
+
 	// Upload to Storage
-	ds.logger.Printf("Uploading to storage: %s", finalName)
-	if err := ds.storage.UploadFile(ctx, finalName, finalData, finalMime); err != nil {
-		return fmt.Errorf("upload to storage failed: %w", err)
+	uploadOptions := UploadOptions{
+		CacheControl: CacheControlFor(storagePath),
+		Metadata:     map[string]string{"drive-file-id": file.Id},
+	}
+	ds.logger.Printf("Uploading to storage: %s", storagePath)
+	stageStart = time.Now()
+	err = ds.upload(ctx, storagePath, source, finalMime, uploadOptions)
+	if errors.Is(err, apperrors.ErrChecksumMismatch) {
+		ds.logger.Printf("Upload of %s failed its checksum, retrying once: %v", storagePath, err)
+		err = ds.upload(ctx, storagePath, source, finalMime, uploadOptions)
+	}
+	result.Timings.Upload = time.Since(stageStart)
+	if err != nil {
+		return result, fmt.Errorf("upload to storage failed: %w", err)
 	}
 
-	// Resolve and persist metadata in one sweep (using the file bytes we already have)
-	return ds.resolveAndPersist(ctx, finalName, finalMime, finalData, existing)
+	metadata, err := persistExtracted(ctx, ds.firestore, extracted, file.Id, existing, &result.Timings)
+	if err != nil {
+		return result, err
+	}
+	if metadata.GeoLocation != "" {
+		ds.logger.Printf("Successfully synced %s with location: %s", storagePath, metadata.GeoLocation)
+	} else {
+		ds.logger.Printf("Successfully synced %s (no GPS data)", storagePath)
+	}
+
+	if ds.evictCache != nil {
+		ds.evictCache(file.Name, finalName)
+	}
+	ds.cleanupStale(ctx, file.Name, finalName, storagePath, finalMime, existing)
+	return result, nil
 }
 
-// resolveAndPersist handles metadata resolution and Firestore persistence.
-// It extracts metadata from file bytes and creates or updates the Firestore record.
-func (ds *DriveService) resolveAndPersist(ctx context.Context, fileName, contentType string, fileData []byte, existing *models.ImageMetadata) error {
-	ds.logger.Printf("Extracting metadata from file: %s", fileName)
+// Most shortcuts resolveShortcut follows before giving up; Drive doesn't create shortcuts to
+// shortcuts itself, so this only bounds odd or cyclic chains.
+const maxShortcutHops = 3
 
-	metadata, err := ExtractAndPersistMetadata(ctx, ds.firestore, fileName, contentType, fileData, existing, ds.geocoder)
+// Wraps the reasons a shortcut has no file to sync, which SyncFile reports as skips.
+var errUnresolvableShortcut = errors.New("unresolvable shortcut")
+
+// Follows a shortcut to the file it points at, fetching the target's metadata. A target that is
+// gone or unreadable, or a chain longer than maxShortcutHops, fails with errUnresolvableShortcut.
+func (ds *DriveService) resolveShortcut(ctx context.Context, shortcut *drive.File) (*drive.File, error) {
+	file := shortcut
+	for hops := 0; file.MimeType == driveShortcutMimeType; hops++ {
+		if hops == maxShortcutHops {
+			return nil, fmt.Errorf("%w: more than %d shortcuts deep", errUnresolvableShortcut, maxShortcutHops)
+		}
+		if file.ShortcutDetails == nil || file.ShortcutDetails.TargetId == "" {
+			return nil, fmt.Errorf("%w: %s has no target", errUnresolvableShortcut, file.Name)
+		}
+
+		target, err := ds.driveClient.GetFileDetails(ctx, file.ShortcutDetails.TargetId)
+		if errors.Is(err, apperrors.ErrNotFound) {
+			return nil, fmt.Errorf("%w: target %s is gone or not shared", errUnresolvableShortcut, file.ShortcutDetails.TargetId)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("resolve shortcut %s failed: %w", file.Name, err)
+		}
+		file = target
+	}
+	return file, nil
+}
+
+// Fills in a dry run's result for a file SyncFile would go on to sync, saying why and whether it
+// would be downloaded.
+func (ds *DriveService) planSync(ctx context.Context, file *drive.File, existing *models.ImageMetadata, result *models.SyncResult) *models.SyncResult {
+	result.Status = models.SyncStatusWouldSync
+	switch {
+	case existing == nil:
+		result.Reason = "new"
+	case result.Reason != "":
+		// Modified since the last sync
+	default:
+		result.Reason = "metadata incomplete"
+	}
+	if existing != nil && ds.unchangedInStorage(ctx, file, existing) {
+		result.Reason += ", would re-extract from storage"
+	} else {
+		result.Reason += fmt.Sprintf(", would download %d bytes", file.Size)
+	}
+	ds.logger.Printf("[DRY] Would sync %s: %s", file.Name, result.Reason)
+	return result
+}
+
+// Reports whether a file was modified in Drive (edited, rotated or replaced) after its document
+// was last synced. Documents synced before modification times were kept are compared by when
+// they were last updated instead.
+func modifiedSinceSync(file *drive.File, existing *models.ImageMetadata) bool {
+	modified := utils.ParseDriveTime(file.ModifiedTime)
+	if modified.IsZero() {
+		return false
+	}
+
+	synced := existing.SourceModifiedTime
+	if synced.IsZero() {
+		synced = existing.UpdatedAt
+	}
+	return !synced.IsZero() && modified.After(synced)
+}
+
+// Reports whether the stored copy of a file is known to match it in Drive: the document recorded
+// the same Drive MD5 when it was last synced, or, for documents synced before checksums were
+// kept, the object's own MD5 matches (which a converted HEIC never does). Files without an MD5
+// (Google-native files) and hidden documents never match, and SetForce disables the check.
+func (ds *DriveService) unchangedInStorage(ctx context.Context, file *drive.File, existing *models.ImageMetadata) bool {
+	if ds.force || file.Md5Checksum == "" || existing.Hidden() || existing.StoragePath == "" {
+		return false
+	}
+	if existing.SourceChecksum != "" {
+		return existing.SourceChecksum == file.Md5Checksum
+	}
+
+	attrs, err := ds.storage.Attrs(ctx, existing.StoragePath)
+	if err != nil {
+		if !errors.Is(err, apperrors.ErrNotFound) {
+			ds.logger.Printf("Failed to read %s, downloading %s again: %v", existing.StoragePath, file.Name, err)
+		}
+		return false
+	}
+	return hex.EncodeToString(attrs.MD5) == file.Md5Checksum
+}
+
+// Re-extracts the metadata of a file whose stored copy is unchanged from the bytes already in
+// Storage, skipping the Drive download and the upload.
+func (ds *DriveService) reextractStored(ctx context.Context, file *drive.File, existing *models.ImageMetadata, result *models.SyncResult) (*models.SyncResult, error) {
+	ds.logger.Printf("Unchanged in Drive, re-extracting from storage: %s (%s)", file.Name, existing.StoragePath)
+
+	stageStart := time.Now()
+	reader, err := ds.storage.OpenFile(ctx, existing.StoragePath, 0)
+	if err != nil {
+		return result, fmt.Errorf("read from storage failed: %w", err)
+	}
+	defer reader.Close()
+
+	var source mediaSource
+	if ds.streamAbove > 0 && reader.Attrs.Size > ds.streamAbove {
+		tmpPath, err := writeTempFile(path.Ext(existing.StoragePath), func(w io.Writer) error {
+			_, err := io.Copy(w, reader)
+			return err
+		})
+		if err != nil {
+			return result, fmt.Errorf("read from storage failed: %w", err)
+		}
+		defer os.Remove(tmpPath)
+		source.path = tmpPath
+	} else if source.data, err = io.ReadAll(reader); err != nil {
+		return result, fmt.Errorf("read from storage failed: %w", err)
+	}
+	result.Timings.Download = time.Since(stageStart)
+
+	extracted, err := extractMetadata(ctx, ds.firestore, ds.geocoder, existing.FileName, existing.ContentType, source, &result.Timings)
 	if err != nil {
+		return result, err
+	}
+	extracted.StoragePath = existing.StoragePath
+	setDriveFields(extracted, file)
+
+	if _, err := persistExtracted(ctx, ds.firestore, extracted, file.Id, existing, &result.Timings); err != nil {
+		return result, err
+	}
+	if ds.evictCache != nil {
+		ds.evictCache(file.Name, existing.FileName)
+	}
+	ds.logger.Printf("Successfully re-extracted %s", existing.StoragePath)
+	result.Reason = "unchanged in Drive, re-extracted from storage"
+	return result, nil
+}
+
+// Copies what Drive knows of a file onto its extracted metadata: its MD5 and modification time,
+// and its description (as the caption, with its hash) and appProperties, which persistExtracted
+// merges as mergeSourceDescription says. The description is trimmed and cut to
+// MaxDescriptionLength, like a caption set through the API.
+func setDriveFields(extracted *models.ImageMetadata, file *drive.File) {
+	description := strings.TrimSpace(file.Description)
+	if runes := []rune(description); len(runes) > MaxDescriptionLength {
+		description = string(runes[:MaxDescriptionLength])
+	}
+
+	extracted.SourceChecksum = file.Md5Checksum
+	extracted.SourceModifiedTime = utils.ParseDriveTime(file.ModifiedTime)
+	extracted.Description = description
+	extracted.HasDescription = description != ""
+	extracted.SourceDescriptionHash = descriptionHash(description)
+	extracted.SourceProperties = file.AppProperties
+}
+
+// Writes a new temp file (named with ext, which helps tools that go by extension) with fill and
+// returns its path, which the caller removes.
+func writeTempFile(ext string, fill func(w io.Writer) error) (string, error) {
+	tmp, err := os.CreateTemp("", "trekka-drive-*"+ext)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	err = fill(tmp)
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write temp file: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// Reports whether SyncFile streams a file through a temp file: it is over the stream threshold
+// and isn't HEIC, which conversion needs in memory.
+func (ds *DriveService) streams(file *drive.File) bool {
+	return ds.streamAbove > 0 && file.Size > ds.streamAbove && !utils.IsHeifLike(file.MimeType)
+}
+
+// Downloads a Drive file into a new temp file and returns its path, which the caller removes.
+// Nothing but the copy buffer is held in memory, however large the file.
+func (ds *DriveService) downloadToTemp(ctx context.Context, file *drive.File) (string, error) {
+	return writeTempFile(filepath.Ext(file.Name), func(w io.Writer) error {
+		_, err := ds.driveClient.DownloadToFile(ctx, file.Id, w)
 		return err
+	})
+}
+
+// Uploads a file's bytes, or its temp file when it was streamed to disk.
+func (ds *DriveService) upload(ctx context.Context, storagePath string, source mediaSource, contentType string, options UploadOptions) error {
+	if source.path != "" {
+		return ds.storage.UploadFromFile(ctx, storagePath, source.path, contentType, options)
 	}
+	return ds.storage.UploadFile(ctx, storagePath, source.data, contentType, options)
+}
 
-	if metadata.GeoLocation != "" {
-		ds.logger.Printf("Successfully synced %s with location: %s", fileName, metadata.GeoLocation)
-	} else {
-		ds.logger.Printf("Successfully synced %s (no GPS data)", fileName)
+// Picks the object key for a synced file, returning it with the document to update (which may
+// differ from existing when the key turns out to belong to this file's own document). A document
+// already storing the same name keeps its key, so re-syncs overwrite in place and nothing moves
+// until `trekka-admin relocate` runs. Otherwise the strategy decides, and when that key belongs to
+// a different Drive file with the same name, the Drive file ID is added so neither overwrites
+// the other.
+func (ds *DriveService) storagePathFor(ctx context.Context, file *drive.File, finalName string, takenAt time.Time, existing *models.ImageMetadata) (string, *models.ImageMetadata, error) {
+	if existing != nil && !existing.Hidden() && path.Base(existing.StoragePath) == finalName {
+		return existing.StoragePath, existing, nil
 	}
 
-	return nil
+	storagePath := ds.storagePaths(finalName, takenAt)
+	owner, err := ds.firestore.GetImageMetadataByStoragePath(ctx, storagePath)
+	switch {
+	case errors.Is(err, apperrors.ErrNotFound):
+		return storagePath, existing, nil
+	case err != nil:
+		return "", nil, fmt.Errorf("lookup of storage path %s failed: %w", storagePath, err)
+	case existing != nil && owner.Id == existing.Id:
+		return storagePath, existing, nil
+	case owner.DriveFileID == "" || owner.DriveFileID == file.Id:
+		if existing == nil {
+			existing = owner
+		}
+		return storagePath, existing, nil
+	}
+
+	disambiguated := disambiguateStoragePath(storagePath, file.Id)
+	ds.logger.Printf("%s already holds %s from another Drive file (%s), storing as %s", storagePath, owner.FileName, owner.DriveFileID, disambiguated)
+	return disambiguated, existing, nil
+}
+
+// Attempts at deleting a stale object before cleanupStale gives up.
+const staleCleanupAttempts = 3
+
+// Tidies up after a file is stored and persisted under a different key than before: a document
+// still pointing at its old object (e.g. from an unconverted HEIC upload) is pointed at the new
+// one, then the old object is deleted. A converted file without such a document has its
+// original-name object next to the new key deleted instead. Failures are logged rather than
+// failing the sync, since the file is in place; a leftover object shows up as an orphan in
+// reconciliation.
+func (ds *DriveService) cleanupStale(ctx context.Context, originalName, finalName, storagePath, finalMime string, existing *models.ImageMetadata) {
+	var stalePath string
+	switch {
+	case existing != nil && !existing.Hidden() && existing.StoragePath != storagePath:
+		if err := ds.firestore.SetStoredFile(ctx, existing.Id, finalName, storagePath, finalMime); err != nil {
+			// Keep the old object while the document still points at it
+			ds.logger.Printf("Failed to point %s at %s, keeping %s: %v", existing.Id, storagePath, existing.StoragePath, err)
+			return
+		}
+		if ds.evictCache != nil {
+			ds.evictCache(existing.Id, existing.FileName)
+		}
+		ds.logger.Printf("Pointed %s at %s (was %s)", existing.Id, storagePath, existing.StoragePath)
+		stalePath = existing.StoragePath
+	case finalName != originalName:
+		stalePath = path.Join(path.Dir(storagePath), originalName)
+	default:
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		removed, err := ds.storage.DeleteFileIfExists(ctx, stalePath)
+		if err == nil {
+			if removed {
+				ds.logger.Printf("Removed stale %s (now stored as %s)", stalePath, storagePath)
+			}
+			return
+		}
+		if attempt == staleCleanupAttempts {
+			ds.logger.Printf("Failed to remove stale %s after %d attempts, leaving it for reconciliation: %v", stalePath, attempt, err)
+			return
+		}
+
+		ds.logger.Printf("Failed to remove stale %s (attempt %d/%d), retrying: %v", stalePath, attempt, staleCleanupAttempts, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+}
+
+// Counts a failed attempt towards quarantining the file, or clears its failures on success.
+func (ds *DriveService) recordOutcome(ctx context.Context, fileName string, syncErr error) {
+	if ds.quarantine == nil {
+		return
+	}
+
+	if syncErr == nil {
+		if err := ds.quarantine.RecordSuccess(ctx, fileName); err != nil {
+			ds.logger.Printf("Failed to clear failures of %s: %v", fileName, err)
+		}
+		return
+	}
+
+	quarantined, err := ds.quarantine.RecordFailure(ctx, fileName, syncErr)
+	if err != nil {
+		ds.logger.Printf("Failed to record failure of %s: %v", fileName, err)
+		return
+	}
+	if quarantined {
+		ds.logger.Printf("Quarantined %s after repeated failures", fileName)
+	}
+}
+
+// Looks a file up in the synced folder by name and syncs it again, e.g. after it was restored
+// from quarantine. With recursion, subfolders are searched one by one if it isn't at the top.
+func (ds *DriveService) ResyncFile(ctx context.Context, fileName string) (*models.SyncResult, error) {
+	file, err := ds.driveClient.Find(ctx, ds.folderID, fileName)
+	if errors.Is(err, apperrors.ErrNotFound) && ds.recursive {
+		folders, treeErr := ds.folderTree(ctx)
+		if treeErr != nil {
+			return nil, treeErr
+		}
+		for folderID := range folders {
+			if folderID == ds.folderID {
+				continue
+			}
+			if file, err = ds.driveClient.Find(ctx, folderID, fileName); !errors.Is(err, apperrors.ErrNotFound) {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ds.SyncFile(ctx, file, SyncOptions{})
+}
+
+// Lists the files to sync: those directly in the folder, or with recursion those in every
+// subfolder too, refreshing the known folder tree on the way.
+func (ds *DriveService) listFiles(ctx context.Context) ([]*drive.File, error) {
+	if !ds.recursive {
+		return ds.driveClient.ListFilesInFolder(ctx, ds.folderID)
+	}
+
+	files, folders, err := ds.driveClient.ListFilesRecursive(ctx, ds.folderID)
+	if err != nil {
+		return nil, err
+	}
+	ds.setFolderTree(folders)
+	ds.logger.Printf("Found %d files in %d folders", len(files), len(folders))
+	return files, nil
+}
+
+// Returns the folders synced with recursion (folder ID -> path under the synced folder), listing
+// them if they aren't known yet or were invalidated by a folder change.
+func (ds *DriveService) folderTree(ctx context.Context) (map[string]string, error) {
+	ds.foldersMu.Lock()
+	folders := ds.folders
+	ds.foldersMu.Unlock()
+	if folders != nil {
+		return folders, nil
+	}
+
+	_, folders, err := ds.driveClient.ListFilesRecursive(ctx, ds.folderID)
+	if err != nil {
+		return nil, err
+	}
+	ds.setFolderTree(folders)
+	return folders, nil
+}
+
+// Replaces the known folder tree; nil makes the next folderTree call list it again.
+func (ds *DriveService) setFolderTree(folders map[string]string) {
+	ds.foldersMu.Lock()
+	ds.folders = folders
+	ds.foldersMu.Unlock()
+}
+
+// Reports whether a file is in the synced folder or, with recursion, any of its subfolders.
+func (ds *DriveService) inSyncedFolder(ctx context.Context, file *drive.File) (bool, error) {
+	if !ds.recursive {
+		return slices.Contains(file.Parents, ds.folderID), nil
+	}
+
+	folders, err := ds.folderTree(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, parent := range file.Parents {
+		if _, ok := folders[parent]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Controls a BackfillFromDrive run.
+type BackfillOptions struct {
+	SkipExisting bool // Skip files already in Firestore, unless modified in Drive since their last sync
+	DryRun       bool // Report what each file would get without downloading, uploading or writing anything
+	Filter       BackfillFilter
+	Trigger      string // What started it, recorded in the sync run history (models.SyncTriggerManual if empty)
 }
 
 // BackfillFromDrive iterates all files in the Drive folder and syncs them.
 // It uses SyncFile for each file.
-// If skipExisting is true, files that already exist in Firestore will be skipped entirely.
-func (ds *DriveService) BackfillFromDrive(ctx context.Context, skipExisting bool) error {
-	if skipExisting {
-		ds.logger.Printf("Starting backfill for folder %s (skipping existing files)", ds.folderID)
-	} else {
-		ds.logger.Printf("Starting backfill for folder %s (processing all files)", ds.folderID)
+// With SkipExisting, files that already exist in Firestore will be skipped entirely. A dry run
+// only lists the folder and looks files up, reporting those that would be synced as
+// SyncStatusWouldSync; it doesn't save a page token or propagate deletions. Files the filter
+// leaves out are only counted in the report; a filtered backfill doesn't propagate deletions
+// either, since its view of the folder is partial. Malformed filter patterns return ErrInvalidInput.
+// The report lists every file's outcome and is returned even with an error, which says the run
+// was cancelled, the listing failed, or some files failed. Only one backfill runs at a time; while
+// one is, this returns ErrConflict and no report. With a sync lock, a backfill that isn't a dry
+// run holds it throughout, and returns ErrSyncLocked and no report while another instance holds it.
+// While the sync is paused, a backfill that isn't a dry run returns ErrSyncPaused and no report;
+// pausing one that is running stops it once the file in flight is done.
+func (ds *DriveService) BackfillFromDrive(ctx context.Context, options BackfillOptions) (*models.BackfillReport, error) {
+	if err := options.Filter.Validate(); err != nil {
+		return nil, err
+	}
+	if err := ds.checkPaused(options); err != nil {
+		return nil, err
+	}
+	if !ds.backfilling.CompareAndSwap(false, true) {
+		return nil, fmt.Errorf("%w: backfill already running", apperrors.ErrConflict)
+	}
+	defer ds.backfilling.Store(false)
+
+	ctx, unlock, err := ds.lockBackfill(ctx, options)
+	if err != nil {
+		return nil, err
 	}
+	defer unlock()
+	return ds.backfill(ctx, options)
+}
+
+// Takes the sync lock for a backfill, unless it is a dry run, which writes nothing.
+func (ds *DriveService) lockBackfill(ctx context.Context, options BackfillOptions) (context.Context, func(), error) {
+	if options.DryRun {
+		return ctx, func() {}, nil
+	}
+	return ds.lockSync(ctx)
+}
 
-	files, err := ds.driveClient.ListFilesInFolder(ctx, ds.folderID)
+// Runs BackfillFromDrive in the background, whose report LastBackfill returns once it finishes.
+// Returns ErrConflict straight away if a backfill is already running, ErrSyncLocked if another
+// instance holds the sync lock, ErrSyncPaused while the sync is paused, or ErrInvalidInput for a
+// malformed filter.
+func (ds *DriveService) StartBackfill(ctx context.Context, options BackfillOptions) error {
+	if err := options.Filter.Validate(); err != nil {
+		return err
+	}
+	if err := ds.checkPaused(options); err != nil {
+		return err
+	}
+	if !ds.backfilling.CompareAndSwap(false, true) {
+		return fmt.Errorf("%w: backfill already running", apperrors.ErrConflict)
+	}
+	ctx, unlock, err := ds.lockBackfill(ctx, options)
 	if err != nil {
+		ds.backfilling.Store(false)
 		return err
 	}
+	go func() {
+		defer ds.backfilling.Store(false)
+		defer unlock()
+		if _, err := ds.backfill(ctx, options); err != nil {
+			ds.logger.Printf("Backfill: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Returns the report of the latest backfill to finish (nil before the first), and whether a
+// backfill is running now.
+func (ds *DriveService) LastBackfill() (*models.BackfillReport, bool) {
+	ds.reportMu.Lock()
+	defer ds.reportMu.Unlock()
+	return ds.lastReport, ds.backfilling.Load()
+}
+
+// Returned while the sync is paused by backfills that aren't dry runs, and by watch ticks and
+// backfills stopped by a pause. It matches ErrConflict.
+var ErrSyncPaused = fmt.Errorf("%w: Drive sync is paused", apperrors.ErrConflict)
+
+// Pauses syncing on this instance until Resume, e.g. while the Drive folder is reorganized: watch
+// ticks are skipped, and backfills other than dry runs refuse to start. A backfill or tick already
+// running stops once the file in flight is done. The pause is kept in memory, so it ends with a
+// restart and doesn't reach other instances. Returns when the sync was paused, which is earlier
+// than now if it already was.
+func (ds *DriveService) Pause() time.Time {
+	ds.pauseMu.Lock()
+	defer ds.pauseMu.Unlock()
+	if ds.pausedAt.IsZero() {
+		ds.pausedAt = time.Now()
+		ds.logger.Println("Sync paused")
+	}
+	return ds.pausedAt
+}
+
+// Lets syncing carry on after Pause; the next watch tick picks up what changed meanwhile.
+// Reports whether the sync was paused.
+func (ds *DriveService) Resume() bool {
+	ds.pauseMu.Lock()
+	defer ds.pauseMu.Unlock()
+	if ds.pausedAt.IsZero() {
+		return false
+	}
+	ds.pausedAt = time.Time{}
+	ds.logger.Println("Sync resumed")
+	return true
+}
+
+// Returns when the sync was paused, or the zero time while it isn't.
+func (ds *DriveService) PausedAt() time.Time {
+	ds.pauseMu.Lock()
+	defer ds.pauseMu.Unlock()
+	return ds.pausedAt
+}
+
+// Returns ErrSyncPaused while the sync is paused, unless the backfill is a dry run, which writes
+// nothing.
+func (ds *DriveService) checkPaused(options BackfillOptions) error {
+	if options.DryRun || ds.PausedAt().IsZero() {
+		return nil
+	}
+	return ErrSyncPaused
+}
 
-	var (
-		newCount, errCount, consecutiveErrors, skippedCount int
-	)
+func (ds *DriveService) backfill(ctx context.Context, options BackfillOptions) (report *models.BackfillReport, err error) {
+	report = &models.BackfillReport{
+		FolderID:     ds.folderID,
+		SkipExisting: options.SkipExisting,
+		DryRun:       options.DryRun,
+		StartedAt:    time.Now(),
+	}
+	defer func() {
+		report.FinishedAt = time.Now()
+		if err != nil {
+			report.Error = err.Error()
+		}
+		ds.reportMu.Lock()
+		ds.lastReport = report
+		ds.reportMu.Unlock()
+
+		if !options.DryRun {
+			trigger := options.Trigger
+			if trigger == "" {
+				trigger = models.SyncTriggerManual
+			}
+			run := ds.newRun(trigger, models.SyncModeBackfill)
+			run.StartedAt = report.StartedAt
+			for _, result := range report.Files {
+				run.Add(result)
+			}
+			ds.recordRun(run, err)
+		}
+	}()
+
+	mode := "processing all files"
+	if options.SkipExisting {
+		mode = "skipping existing files"
+	}
+	if options.DryRun {
+		mode += ", dry run"
+	}
+	if !options.Filter.IsZero() {
+		mode += ", " + options.Filter.String()
+	}
+	ds.logger.Printf("Starting backfill for folder %s (%s)", ds.folderID, mode)
+
+	// Track changes from before the listing, so files added while the backfill runs are picked up
+	// by the next incremental sync
+	if !options.DryRun {
+		if _, err := ds.ensurePageToken(ctx); err != nil {
+			ds.logger.Printf("Could not start tracking changes: %v", err)
+		}
+	}
+
+	files, err := ds.listFiles(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Listed = len(files)
+
+	consecutiveErrors := 0
+	runMetrics := NewSyncMetrics(10)
 
 	for _, f := range files {
 		if ctx.Err() != nil {
-			return ctx.Err()
+			return report, ctx.Err()
+		}
+		if reason := options.Filter.excludes(f); reason != "" {
+			report.Filtered++
+			continue
 		}
 
-		// Add delay between files to avoid rate limiting (especially for videos)
-		time.Sleep(2 * time.Second)
+		// Add delay between files to avoid rate limiting (especially for videos). A dry run
+		// doesn't download, so it needs none
+		if !options.DryRun {
+			if err := sleepContext(ctx, ds.fileDelay); err != nil {
+				return report, err
+			}
+		}
+		if err := ds.checkPaused(options); err != nil {
+			return report, err
+		}
 
 		// attempt sync
-		if err := ds.SyncFile(ctx, f, skipExisting); err != nil {
+		result, err := ds.SyncFile(ctx, f, SyncOptions{SkipExisting: options.SkipExisting, DryRun: options.DryRun})
+		report.Add(result)
+		if !result.Skipped() && !options.DryRun {
+			runMetrics.Record(result.FileName, result.Timings)
+		}
+		if err != nil {
 			ds.logger.Printf("Sync error for %s: %v", f.Name, err)
-			errCount++
 			consecutiveErrors++
 
 			// If we're getting persistent 403 errors, back off significantly
@@ -169,7 +1021,9 @@ func (ds *DriveService) BackfillFromDrive(ctx context.Context, skipExisting bool
 			if errors.As(err, &apiErr) && (apiErr.Code == 403 || apiErr.Code == 429) && consecutiveErrors >= 3 {
 				backoffDuration := 5 * time.Minute
 				ds.logger.Printf("Detected persistent rate limiting (HTTP %d), pausing for %v", apiErr.Code, backoffDuration)
-				time.Sleep(backoffDuration)
+				if err := sleepContext(ctx, backoffDuration); err != nil {
+					return report, err
+				}
 				consecutiveErrors = 0 // Reset after backing off
 			}
 			continue
@@ -177,25 +1031,192 @@ func (ds *DriveService) BackfillFromDrive(ctx context.Context, skipExisting bool
 
 		// Reset consecutive error count on success
 		consecutiveErrors = 0
-		newCount++
 	}
 
-	ds.logger.Printf("Backfill complete: %d processed, %d skipped, %d errors", newCount, skippedCount, errCount)
-	if errCount > 0 {
-		return fmt.Errorf("backfill completed with %d errors", errCount)
+	if ds.propagate && !options.DryRun && options.Filter.IsZero() {
+		candidates, err := ds.missingFromListing(ctx, files)
+		if err != nil {
+			ds.logger.Printf("Failed to check for deleted files: %v", err)
+		}
+		report.Removed = ds.propagateDeletions(ctx, candidates)
 	}
-	return nil
+
+	if options.DryRun {
+		ds.logger.Printf("[DRY] Backfill complete: %d would be synced, %d skipped, %d filtered out, %d errors", report.Synced, report.Skipped, report.Filtered, report.Failed)
+	} else {
+		ds.logger.Printf("Backfill complete: %d synced, %d skipped, %d filtered out, %d removed, %d errors", report.Synced, report.Skipped, report.Filtered, len(report.Removed), report.Failed)
+		if len(report.Removed) > 0 {
+			ds.logger.Printf("Removed (gone from Drive): %s", strings.Join(report.Removed, ", "))
+		}
+		ds.logger.Print(runMetrics.Summary())
+	}
+	if report.Failed > 0 {
+		return report, fmt.Errorf("backfill completed with %d errors", report.Failed)
+	}
+	return report, nil
+}
+
+// Saves the current Changes API page token if none is saved yet, reporting whether it did.
+// Does nothing without a sync state store.
+func (ds *DriveService) ensurePageToken(ctx context.Context) (bool, error) {
+	if ds.syncState == nil {
+		return false, nil
+	}
+
+	state, err := ds.syncState.Get(ctx, ds.folderID)
+	if err != nil {
+		return false, err
+	}
+	if state.PageToken != "" {
+		return false, nil
+	}
+
+	token, err := ds.driveClient.GetStartPageToken(ctx)
+	if err != nil {
+		return false, err
+	}
+	if err := ds.syncState.SetPageToken(ctx, ds.folderID, token); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Syncs the files added or modified in the folder (and, with recursion, its subfolders) since the
+// saved page token, using the Changes API instead of listing the whole folder. The first run only saves a token, since changes from
+// before it can't be listed; a backfill picks up files that already exist. Removed and trashed
+// files are logged and their stored copies kept, unless deletions are propagated, in which case
+// they are removed once the next run finds them still gone. The token only advances once every change
+// synced, so failed files are retried by the next run (synced ones are skipped as complete).
+// Returns the number of files synced.
+func (ds *DriveService) SyncChanges(ctx context.Context) (int, error) {
+	run := ds.newRun(models.SyncTriggerManual, models.SyncModeChanges)
+	err := ds.syncChanges(ctx, run)
+	return run.Synced, err
 }
 
-// Polls the Drive folder at a fixed interval for new files.
-// For production, consider using Drive push notifications.
+// Does SyncChanges' work, counting each changed file's result into run.
+func (ds *DriveService) syncChanges(ctx context.Context, run *models.SyncRun) error {
+	if ds.syncState == nil {
+		return fmt.Errorf("incremental sync needs a sync state store")
+	}
+
+	started, err := ds.ensurePageToken(ctx)
+	if err != nil {
+		return err
+	}
+	if started {
+		ds.logger.Printf("Tracking changes to folder %s from now on (run a backfill to sync files already there)", ds.folderID)
+		return nil
+	}
+
+	state, err := ds.syncState.Get(ctx, ds.folderID)
+	if err != nil {
+		return err
+	}
+	changes, nextToken, err := ds.driveClient.ListChanges(ctx, state.PageToken)
+	if err != nil {
+		return err
+	}
+
+	var gone []*models.ImageMetadata
+	defer func() {
+		if removed := ds.propagateDeletions(ctx, gone); len(removed) > 0 {
+			ds.logger.Printf("Removed %d files gone from Drive: %s", len(removed), strings.Join(removed, ", "))
+		}
+	}()
+	for _, change := range changes {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !ds.PausedAt().IsZero() {
+			return ErrSyncPaused
+		}
+
+		file := change.File
+		if file != nil && file.MimeType == driveFolderMimeType {
+			// A folder was added, moved, renamed or trashed; list the tree again when next needed
+			if ds.recursive {
+				ds.setFolderTree(nil)
+			}
+			continue
+		}
+		if change.Removed || file == nil || file.Trashed {
+			if doc := ds.noteRemoval(ctx, change); doc != nil {
+				gone = append(gone, doc)
+			}
+			continue
+		}
+		inFolder, err := ds.inSyncedFolder(ctx, file)
+		if err != nil {
+			return err
+		}
+		if !inFolder {
+			continue
+		}
+
+		result, err := ds.SyncFile(ctx, file, SyncOptions{})
+		run.Add(result)
+		if err != nil {
+			ds.logger.Printf("Error syncing changed file %s: %v", file.Name, err)
+		}
+	}
+
+	if run.Failed > 0 {
+		return fmt.Errorf("%d of %d changes failed to sync, will retry", run.Failed, len(changes))
+	}
+	return ds.syncState.SetPageToken(ctx, ds.folderID, nextToken)
+}
+
+// Logs a removed or trashed Drive file if it was synced from this folder, returning its document
+// (nil if there is none). Unless deletions are propagated, the document and stored copy are kept.
+func (ds *DriveService) noteRemoval(ctx context.Context, change *drive.Change) *models.ImageMetadata {
+	if change.File != nil {
+		if inFolder, err := ds.inSyncedFolder(ctx, change.File); err != nil || !inFolder {
+			return nil
+		}
+	}
+
+	existing, err := ds.firestore.GetImageMetadataByDriveFileID(ctx, change.FileId)
+	if err != nil {
+		if !errors.Is(err, apperrors.ErrNotFound) {
+			ds.logger.Printf("Failed to look up removed Drive file %s: %v", change.FileId, err)
+		}
+		return nil
+	}
+	if existing.Hidden() {
+		return nil
+	}
+	if !ds.propagate {
+		ds.logger.Printf("Drive file %s (%s) was removed or trashed; keeping %s", change.FileId, existing.FileName, existing.StoragePath)
+	}
+	return existing
+}
+
+// Watches the folder at a fixed interval. With a sync state store, each tick syncs the changes
+// since the last one through the Changes API (SyncChanges). Without one, or when the credentials
+// can't use the Changes API (an API key only reaches public files), each tick lists the folder
+// and syncs files created or modified since the last check. The last check is saved in the sync
+// state store, so files added while the process was down are picked up after a restart; without a
+// saved one, the watch looks back SetWatchLookback's duration. It only advances once every file
+// changed in its window synced or was skipped, so failed files are retried by the next tick.
+// With a sync lock, each tick holds it while it runs, and ticks are skipped while another
+// instance holds it. With a sync run store, each tick that runs is recorded there. Ticks are
+// skipped while the sync is paused, and a tick running when it is paused stops after the file in
+// flight, leaving the rest to the first tick after Resume.
 func (ds *DriveService) WatchForChanges(ctx context.Context, interval time.Duration) error {
-	ds.logger.Printf("Starting watch for changes (polling every %v)", interval)
+	useChanges := ds.syncState != nil
+	if useChanges {
+		ds.logger.Printf("Starting watch for changes (Changes API every %v)", interval)
+	} else {
+		ds.logger.Printf("Starting watch for changes (polling every %v)", interval)
+	}
 
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	lastCheck := time.Now()
+	lastCheck := ds.loadLastCheck(ctx)
+	locked := false // Whether the previous tick found the lock held elsewhere, to log only changes
+	paused := false // Whether the previous tick was skipped for a pause, likewise
 
 	for {
 		select {
@@ -203,38 +1224,155 @@ func (ds *DriveService) WatchForChanges(ctx context.Context, interval time.Durat
 			ds.logger.Println("Watch stopped by context")
 			return ctx.Err()
 		case <-ticker.C:
-			ds.logger.Printf("Checking for new files since %v", lastCheck)
+			if pausedAt := ds.PausedAt(); !pausedAt.IsZero() {
+				if !paused {
+					ds.logger.Printf("Skipping watch ticks while the sync is paused (since %v)", pausedAt)
+				}
+				paused = true
+				continue
+			}
+			paused = false
 
-			files, err := ds.driveClient.ListFilesInFolder(ctx, ds.folderID)
+			tickCtx, unlock, err := ds.lockSync(ctx)
 			if err != nil {
-				ds.logger.Printf("Error listing files: %v", err)
+				if !locked || !errors.Is(err, ErrSyncLocked) {
+					ds.logger.Printf("Skipping watch ticks until the sync lock is free: %v", err)
+				}
+				locked = true
 				continue
 			}
+			if locked {
+				ds.logger.Println("Acquired the sync lock, resuming watch")
+				locked = false
+			}
+			useChanges = ds.watchTick(tickCtx, useChanges, &lastCheck)
+			unlock()
+		}
+	}
+}
 
-			newFilesCount := 0
-			for _, file := range files {
-				createdTime, err := time.Parse(time.RFC3339, file.CreatedTime)
-				if err != nil {
-					ds.logger.Printf("Failed to parse creation time for %s: %v", file.Name, err)
-					continue
-				}
+// Runs one watch tick: incremental sync through the Changes API, or listing the folder when that
+// isn't available. Returns whether the next tick should use the Changes API.
+func (ds *DriveService) watchTick(ctx context.Context, useChanges bool, lastCheck *time.Time) bool {
+	if useChanges {
+		run := ds.newRun(models.SyncTriggerWatch, models.SyncModeChanges)
+		err := ds.syncChanges(ctx, run)
+		switch {
+		case errors.Is(err, apperrors.ErrUnauthorized):
+			// Not recorded: nothing was synced, and the tick lists the folder instead
+			ds.logger.Printf("Changes API unavailable with these credentials, falling back to listing the folder: %v", err)
+			useChanges = false
+		case errors.Is(err, ErrSyncPaused):
+			ds.logger.Println("Paused with changes left to sync, syncing them after resuming")
+		case err != nil:
+			ds.logger.Printf("Error syncing changes: %v", err)
+		case run.Synced > 0:
+			ds.logger.Printf("Synced %d changed files", run.Synced)
+		}
+		if useChanges {
+			ds.recordRun(run, err)
+			return true
+		}
+	}
 
-				if createdTime.After(lastCheck) {
-					ds.logger.Printf("Found new file: %s", file.Name)
-					// Don't skip existing files when watching for changes
-					if err := ds.SyncFile(ctx, file, false); err != nil {
-						ds.logger.Printf("Error syncing new file %s: %v", file.Name, err)
-						continue
-					}
-					newFilesCount++
-				}
-			}
+	run := ds.newRun(models.SyncTriggerWatch, models.SyncModePoll)
+	checkStart := time.Now()
+	err := ds.syncNewFiles(ctx, *lastCheck, run)
+	ds.recordRun(run, err)
+	if err != nil {
+		ds.logger.Printf("Checking files changed since %v again next tick: %v", *lastCheck, err)
+		return false
+	}
+	*lastCheck = checkStart
+	ds.saveLastCheck(ctx, *lastCheck)
+	return false
+}
 
-			if newFilesCount > 0 {
-				ds.logger.Printf("Synced %d new files", newFilesCount)
-			}
+// Returns the polling watch's saved last check, or now minus the lookback when none was saved or
+// it can't be read.
+func (ds *DriveService) loadLastCheck(ctx context.Context) time.Time {
+	fallback := time.Now().Add(-ds.lookback)
+	if ds.syncState == nil {
+		return fallback
+	}
+
+	state, err := ds.syncState.Get(ctx, ds.folderID)
+	switch {
+	case err != nil:
+		ds.logger.Printf("Failed to read the last check, looking back %v: %v", ds.lookback, err)
+		return fallback
+	case state.LastCheck.IsZero():
+		return fallback
+	}
+	ds.logger.Printf("Resuming from last check at %v", state.LastCheck)
+	return state.LastCheck
+}
 
-			lastCheck = time.Now()
+// Saves the polling watch's last check, logging a failure: the watch carries on from the one in
+// memory, and only a restart would look further back than needed.
+func (ds *DriveService) saveLastCheck(ctx context.Context, lastCheck time.Time) {
+	if ds.syncState == nil {
+		return
+	}
+	if err := ds.syncState.SetLastCheck(ctx, ds.folderID, lastCheck); err != nil {
+		ds.logger.Printf("Failed to save the last check: %v", err)
+	}
+}
+
+// Window before the last check that the polling watch looks at again, since Drive's timestamps
+// can lag the clock they're compared with. Files synced in the previous tick are skipped as
+// complete.
+const pollOverlap = 2 * time.Minute
+
+// Lists the folder and syncs the files created or modified after since (less pollOverlap),
+// counting each one's result into run. Returns an error unless the listing succeeded and every
+// such file synced or was skipped.
+func (ds *DriveService) syncNewFiles(ctx context.Context, since time.Time, run *models.SyncRun) error {
+	ds.logger.Printf("Checking for new or modified files since %v", since)
+
+	files, err := ds.listFiles(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+
+	cutoff := since.Add(-pollOverlap)
+	for _, file := range files {
+		createdTime := utils.ParseDriveTime(file.CreatedTime)
+		if createdTime.IsZero() {
+			ds.logger.Printf("Failed to parse creation time for %s: %q", file.Name, file.CreatedTime)
+			continue
+		}
+		// An edited or replaced file keeps its creation time
+		changedTime := createdTime
+		if modifiedTime := utils.ParseDriveTime(file.ModifiedTime); modifiedTime.After(changedTime) {
+			changedTime = modifiedTime
+		}
+
+		if changedTime.After(cutoff) {
+			if !ds.PausedAt().IsZero() {
+				return ErrSyncPaused
+			}
+			// Don't skip existing files when watching for changes
+			result, err := ds.SyncFile(ctx, file, SyncOptions{})
+			run.Add(result)
+			if err != nil {
+				ds.logger.Printf("Error syncing new file %s: %v", file.Name, err)
+				continue
+			}
+			if !result.Skipped() {
+				ds.logger.Printf("Synced new or modified file: %s", file.Name)
+			}
 		}
 	}
+
+	if run.Synced > 0 {
+		ds.logger.Printf("Synced %d new or modified files", run.Synced)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if run.Failed > 0 {
+		return fmt.Errorf("%d files failed to sync", run.Failed)
+	}
+	return nil
 }
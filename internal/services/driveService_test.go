@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+
+	"trekka-api/internal/models"
+	"trekka-api/internal/testutil"
+)
+
+// Returns a DriveService syncing "folder" of the fake Drive, without Firestore or Storage, for
+// tests that never get as far as syncing a file.
+func newFakeDriveService(t *testing.T, files ...testutil.DriveFile) (*DriveService, *testutil.FakeDrive) {
+	t.Helper()
+	fake := testutil.NewFakeDrive(t)
+	fake.Add(files...)
+	return NewDriveService(NewDriveClient(fake.Service, DriveClientOptions{}), nil, nil, &fakeGeocoder{}, "folder"), fake
+}
+
+func TestBackfillFileDelayStopsWhenContextIsCancelled(t *testing.T) {
+	ds, _ := newFakeDriveService(t, testutil.DriveFile{ID: "file-1", Name: "IMG_1.jpg", MimeType: "image/jpeg", Parent: "folder"})
+	ds.SetFileDelay(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	report, err := ds.BackfillFromDrive(ctx, BackfillOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want the context's error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("returned after %v, want within milliseconds of the cancellation", elapsed)
+	}
+	if report == nil || report.Listed != 1 || len(report.Files) != 0 {
+		t.Errorf("report = %+v, want the file listed but not synced", report)
+	}
+}
+
+func TestSyncStreamsFilesOverTheThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int64
+		size      int64
+		mimeType  string
+		want      bool
+	}{
+		{"under the threshold", 100 << 20, 10 << 20, "video/mp4", false},
+		{"at the threshold", 100 << 20, 100 << 20, "video/mp4", false},
+		{"over the threshold", 100 << 20, 2 << 30, "video/mp4", true},
+		{"HEIC over the threshold", 100 << 20, 200 << 20, "image/heic", false},
+		{"streaming disabled", 0, 2 << 30, "video/mp4", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds, _ := newFakeDriveService(t)
+			ds.SetStreamThreshold(tt.threshold)
+			if got := ds.streams(&drive.File{Size: tt.size, MimeType: tt.mimeType}); got != tt.want {
+				t.Errorf("streams = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamedDownloadStaysWithinMemoryBudget(t *testing.T) {
+	const (
+		payload = 300 << 20
+		budget  = 16 << 20
+	)
+	ds, _ := newFakeDriveService(t, testutil.DriveFile{ID: "video", Name: "VID_1.mp4", MimeType: "video/mp4", Parent: "folder", Size: payload})
+	file := &drive.File{Id: "video", Name: "VID_1.mp4", MimeType: "video/mp4", Size: payload}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	tmpPath, err := ds.downloadToTemp(context.Background(), file)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	defer os.Remove(tmpPath)
+
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		t.Fatalf("stat temp file: %v", err)
+	}
+	if info.Size() != payload {
+		t.Errorf("temp file holds %d bytes, want %d", info.Size(), payload)
+	}
+	// Allocations add up whether or not they were collected, so holding the payload at any
+	// point would show
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > budget {
+		t.Errorf("allocated %d MB streaming %d MB, over the %d MB budget", allocated>>20, payload>>20, budget>>20)
+	}
+}
+
+func TestModifiedSinceSync(t *testing.T) {
+	synced := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	before, after := synced.Add(-time.Minute), synced.Add(time.Minute)
+	driveTime := func(t time.Time) string { return t.Format(time.RFC3339Nano) }
+
+	tests := []struct {
+		name         string
+		modifiedTime string
+		existing     models.ImageMetadata
+		want         bool
+	}{
+		{"modified after the last sync", driveTime(after), models.ImageMetadata{SourceModifiedTime: synced}, true},
+		{"unchanged since the last sync", driveTime(synced), models.ImageMetadata{SourceModifiedTime: synced}, false},
+		{"older than the last sync", driveTime(before), models.ImageMetadata{SourceModifiedTime: synced}, false},
+		{"same instant in another zone", "2024-05-01T12:00:00+02:00", models.ImageMetadata{SourceModifiedTime: synced}, false},
+		{"source time wins over the update time", driveTime(after), models.ImageMetadata{SourceModifiedTime: synced, UpdatedAt: after.Add(time.Hour)}, true},
+		{"legacy document updated before the edit", driveTime(after), models.ImageMetadata{UpdatedAt: synced}, true},
+		{"legacy document updated after the edit", driveTime(before), models.ImageMetadata{UpdatedAt: synced}, false},
+		{"no sync time at all", driveTime(after), models.ImageMetadata{}, false},
+		{"no Drive time", "", models.ImageMetadata{SourceModifiedTime: synced}, false},
+		{"malformed Drive time", "yesterday", models.ImageMetadata{SourceModifiedTime: synced}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &drive.File{ModifiedTime: tt.modifiedTime}
+			if got := modifiedSinceSync(file, &tt.existing); got != tt.want {
+				t.Errorf("modifiedSinceSync = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncDecisionMatrix(t *testing.T) {
+	synced := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	complete := models.ImageMetadata{
+		FileName:           "IMG_1.jpg",
+		FileNameLower:      "img_1.jpg",
+		StoragePath:        "2024/05/IMG_1.jpg",
+		TakenAt:            synced,
+		FormattedDate:      "1 May 2024",
+		Coordinates:        models.Coordinates{Lat: "51.5", Lng: "-0.1"},
+		GeoLocation:        "London",
+		SourceModifiedTime: synced,
+	}
+	incomplete := complete
+	incomplete.GeoLocation = ""
+
+	tests := []struct {
+		name         string
+		existing     *models.ImageMetadata
+		modified     bool
+		skipExisting bool
+		wantStatus   string
+		wantReason   string
+	}{
+		{"new file", nil, false, false, models.SyncStatusWouldSync, "new"},
+		{"new file skipping existing", nil, false, true, models.SyncStatusWouldSync, "new"},
+		{"complete", &complete, false, false, models.SyncStatusSkippedComplete, "exists as doc-1"},
+		{"complete skipping existing", &complete, false, true, models.SyncStatusSkippedExisting, "exists as doc-1"},
+		{"incomplete", &incomplete, false, false, models.SyncStatusWouldSync, "metadata incomplete"},
+		{"incomplete skipping existing", &incomplete, false, true, models.SyncStatusSkippedExisting, "exists as doc-1"},
+		{"complete but modified", &complete, true, false, models.SyncStatusWouldSync, "modified in Drive since last sync"},
+		{"complete but modified skipping existing", &complete, true, true, models.SyncStatusWouldSync, "modified in Drive since last sync"},
+		{"incomplete and modified", &incomplete, true, false, models.SyncStatusWouldSync, "modified in Drive since last sync"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := newEmulatorFirestore(t)
+			if tt.existing != nil {
+				seedImage(t, fs, "doc-1", tt.existing)
+			}
+			ds := NewDriveService(nil, nil, fs, &fakeGeocoder{}, "folder")
+
+			modifiedTime := synced
+			if tt.modified {
+				modifiedTime = synced.Add(time.Hour)
+			}
+			file := &drive.File{
+				Id:           "file-1",
+				Name:         "IMG_1.jpg",
+				MimeType:     "image/jpeg",
+				Size:         1024,
+				ModifiedTime: modifiedTime.Format(time.RFC3339Nano),
+			}
+
+			result, err := ds.SyncFile(context.Background(), file, SyncOptions{SkipExisting: tt.skipExisting, DryRun: true})
+			if err != nil {
+				t.Fatalf("sync: %v", err)
+			}
+			if result.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", result.Status, tt.wantStatus)
+			}
+			if !strings.HasPrefix(result.Reason, tt.wantReason) {
+				t.Errorf("reason = %q, want it to start with %q", result.Reason, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"trekka-api/internal/models"
+	"trekka-api/internal/testutil"
+)
+
+// Returns a FirestoreService on the Firestore emulator, in a project of its own so tests never see
+// each other's documents. Skips the test unless FIRESTORE_EMULATOR_HOST is set (see make
+// test-emulator).
+func newEmulatorFirestore(t *testing.T) *FirestoreService {
+	t.Helper()
+	return NewFirestoreService(testutil.FirestoreClient(t), "images")
+}
+
+// Returns a StorageService on a fake GCS server, with the fake to seed and inspect it.
+func newFakeStorage(t *testing.T) (*StorageService, *testutil.FakeGCS) {
+	t.Helper()
+	gcs := testutil.NewFakeGCS(t)
+	return NewStorageService(gcs.Client, testutil.FakeBucket), gcs
+}
+
+// Stores metadata under id, bypassing the service, to seed a test.
+func seedImage(t *testing.T, fs *FirestoreService, id string, metadata *models.ImageMetadata) {
+	t.Helper()
+	if _, err := fs.client.Collection(fs.collection).Doc(id).Set(context.Background(), metadata); err != nil {
+		t.Fatalf("seed image %s: %v", id, err)
+	}
+}
+
+// Counts the documents in the image collection.
+func countImages(t *testing.T, fs *FirestoreService) int {
+	t.Helper()
+	docs, err := fs.client.Collection(fs.collection).Documents(context.Background()).GetAll()
+	if err != nil {
+		t.Fatalf("list images: %v", err)
+	}
+	return len(docs)
+}
+
+// Geocoder answering every lookup with parts (or err) after delay, counting the calls.
+type fakeGeocoder struct {
+	parts models.LocationParts
+	err   error
+	delay time.Duration
+	calls atomic.Int64
+}
+
+func (g *fakeGeocoder) ReverseGeocode(ctx context.Context, coordinates models.Coordinates) (models.LocationParts, error) {
+	g.calls.Add(1)
+	time.Sleep(g.delay)
+	return g.parts, g.err
+}
+
+// Encodes a width×height PNG filled with c.
+func solidPNG(t testing.TB, c color.Color, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// Encodes a small JPEG taken at 2024-06-01 12:00 whose EXIF GPS position is lat and lng, each
+// given as degrees, minutes and seconds with its N/S or E/W reference.
+func gpsJPEG(t testing.TB, lat [3]uint32, latRef string, lng [3]uint32, lngRef string) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+
+	// Big-endian TIFF: IFD0 (DateTime and the GPS IFD pointer) at 8, the GPS IFD (the four
+	// position tags) at 38, then the rationals at 92 and 116 and the date string at 140
+	be := binary.BigEndian
+	var tiff bytes.Buffer
+	entry := func(tag, typ uint16, count uint32, value []byte) {
+		binary.Write(&tiff, be, []uint16{tag, typ})
+		binary.Write(&tiff, be, count)
+		tiff.Write(value)
+	}
+	offset := func(o uint32) []byte { return be.AppendUint32(nil, o) }
+
+	tiff.WriteString("MM")
+	binary.Write(&tiff, be, uint16(0x002A))
+	binary.Write(&tiff, be, uint32(8))
+	binary.Write(&tiff, be, uint16(2))
+	entry(0x0132, 2, 20, offset(140)) // DateTime
+	entry(0x8825, 4, 1, offset(38))   // GPS IFD
+	binary.Write(&tiff, be, uint32(0))
+	binary.Write(&tiff, be, uint16(4))
+	entry(0x0001, 2, 2, []byte(latRef+"\x00\x00\x00"))
+	entry(0x0002, 5, 3, offset(92))
+	entry(0x0003, 2, 2, []byte(lngRef+"\x00\x00\x00"))
+	entry(0x0004, 5, 3, offset(116))
+	binary.Write(&tiff, be, uint32(0))
+	for _, v := range append(lat[:], lng[:]...) {
+		binary.Write(&tiff, be, []uint32{v, 1})
+	}
+	tiff.WriteString("2024:06:01 12:00:00\x00")
+
+	app1 := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var out bytes.Buffer
+	out.Write(encoded.Bytes()[:2]) // SOI
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, be, uint16(len(app1)+2))
+	out.Write(app1)
+	out.Write(encoded.Bytes()[2:])
+	return out.Bytes()
+}
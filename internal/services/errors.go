@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apperrors "trekka-api/internal/errors"
+)
+
+// Maps errors from GCS, Google APIs (Drive) and gRPC (Firestore) onto the application sentinels
+// so handlers can choose a status code with errors.Is. The original error stays in the chain,
+// so callers can still inspect it (e.g. googleapi.Error for Drive rate-limit backoff).
+// Errors that already carry a sentinel, or that don't match a known case, are returned unchanged.
+func classifyError(err error) error {
+	if err == nil || hasSentinel(err) {
+		return err
+	}
+
+	if errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, storage.ErrBucketNotExist) {
+		return fmt.Errorf("%w: %w", apperrors.ErrNotFound, err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", apperrors.ErrUnavailable, err)
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Code == http.StatusNotFound:
+			return fmt.Errorf("%w: %w", apperrors.ErrNotFound, err)
+		case apiErr.Code == http.StatusRequestedRangeNotSatisfiable:
+			return fmt.Errorf("%w: %w", apperrors.ErrRangeNotSatisfiable, err)
+		case apiErr.Code == http.StatusBadRequest:
+			return fmt.Errorf("%w: %w", apperrors.ErrInvalidInput, err)
+		case apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500 || isRateLimitReason(apiErr):
+			return fmt.Errorf("%w: %w", apperrors.ErrUnavailable, err)
+		case apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusForbidden:
+			return fmt.Errorf("%w: %w", apperrors.ErrUnauthorized, err)
+		}
+		return err
+	}
+
+	switch status.Code(err) {
+	case codes.NotFound:
+		return fmt.Errorf("%w: %w", apperrors.ErrNotFound, err)
+	case codes.InvalidArgument:
+		return fmt.Errorf("%w: %w", apperrors.ErrInvalidInput, err)
+	case codes.AlreadyExists:
+		return fmt.Errorf("%w: %w", apperrors.ErrConflict, err)
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return fmt.Errorf("%w: %w", apperrors.ErrUnauthorized, err)
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return fmt.Errorf("%w: %w", apperrors.ErrUnavailable, err)
+	}
+
+	return err
+}
+
+// Reports whether err already wraps one of the application sentinels.
+func hasSentinel(err error) bool {
+	for _, sentinel := range []error{
+		apperrors.ErrNotFound,
+		apperrors.ErrInvalidInput,
+		apperrors.ErrUnauthorized,
+		apperrors.ErrUnsupportedMediaType,
+		apperrors.ErrTooLarge,
+		apperrors.ErrUnavailable,
+		apperrors.ErrRangeNotSatisfiable,
+		apperrors.ErrConflict,
+		apperrors.ErrMissingIndex,
+		apperrors.ErrChecksumMismatch,
+	} {
+		if errors.Is(err, sentinel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Google APIs report quota exhaustion as 403 with a rate-limit reason rather than 429.
+func isRateLimitReason(apiErr *googleapi.Error) bool {
+	for _, item := range apiErr.Errors {
+		switch item.Reason {
+		case "rateLimitExceeded", "userRateLimitExceeded", "quotaExceeded":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apperrors "trekka-api/internal/errors"
+)
+
+func TestClassifyError(t *testing.T) {
+	plain := errors.New("boom")
+
+	tests := []struct {
+		name string
+		err  error
+		want error // Sentinel the result must match; nil for unchanged
+	}{
+		{name: "nil", err: nil},
+		{name: "unknown error", err: plain},
+		{name: "object not found", err: fmt.Errorf("open: %w", storage.ErrObjectNotExist), want: apperrors.ErrNotFound},
+		{name: "bucket not found", err: storage.ErrBucketNotExist, want: apperrors.ErrNotFound},
+		{name: "deadline", err: fmt.Errorf("read: %w", context.DeadlineExceeded), want: apperrors.ErrUnavailable},
+		{name: "api 404", err: &googleapi.Error{Code: http.StatusNotFound}, want: apperrors.ErrNotFound},
+		{name: "api 416", err: &googleapi.Error{Code: http.StatusRequestedRangeNotSatisfiable}, want: apperrors.ErrRangeNotSatisfiable},
+		{name: "api 400", err: &googleapi.Error{Code: http.StatusBadRequest}, want: apperrors.ErrInvalidInput},
+		{name: "api 429", err: &googleapi.Error{Code: http.StatusTooManyRequests}, want: apperrors.ErrUnavailable},
+		{name: "api 503", err: &googleapi.Error{Code: http.StatusServiceUnavailable}, want: apperrors.ErrUnavailable},
+		{name: "api 403 rate limited", err: &googleapi.Error{
+			Code:   http.StatusForbidden,
+			Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}},
+		}, want: apperrors.ErrUnavailable},
+		{name: "api 403", err: &googleapi.Error{Code: http.StatusForbidden}, want: apperrors.ErrUnauthorized},
+		{name: "api 401", err: &googleapi.Error{Code: http.StatusUnauthorized}, want: apperrors.ErrUnauthorized},
+		{name: "api 409 unmapped", err: &googleapi.Error{Code: http.StatusConflict}},
+		{name: "grpc not found", err: status.Error(codes.NotFound, "missing"), want: apperrors.ErrNotFound},
+		{name: "grpc invalid argument", err: status.Error(codes.InvalidArgument, "bad"), want: apperrors.ErrInvalidInput},
+		{name: "grpc already exists", err: status.Error(codes.AlreadyExists, "taken"), want: apperrors.ErrConflict},
+		{name: "grpc permission denied", err: status.Error(codes.PermissionDenied, "no"), want: apperrors.ErrUnauthorized},
+		{name: "grpc unauthenticated", err: status.Error(codes.Unauthenticated, "no"), want: apperrors.ErrUnauthorized},
+		{name: "grpc unavailable", err: status.Error(codes.Unavailable, "down"), want: apperrors.ErrUnavailable},
+		{name: "grpc resource exhausted", err: status.Error(codes.ResourceExhausted, "quota"), want: apperrors.ErrUnavailable},
+		{name: "grpc deadline", err: status.Error(codes.DeadlineExceeded, "slow"), want: apperrors.ErrUnavailable},
+		{name: "grpc aborted unmapped", err: status.Error(codes.Aborted, "contention")},
+		{name: "already classified", err: fmt.Errorf("%w: %w", apperrors.ErrConflict, status.Error(codes.NotFound, "gone")), want: apperrors.ErrConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyError(tt.err)
+
+			if tt.want == nil {
+				if got != tt.err {
+					t.Errorf("classifyError() = %v, want the error unchanged", got)
+				}
+				return
+			}
+			if !errors.Is(got, tt.want) {
+				t.Errorf("classifyError() = %v, want it to match %v", got, tt.want)
+			}
+			if !errors.Is(got, tt.err) {
+				t.Errorf("classifyError() = %v, dropped the original error from the chain", got)
+			}
+		})
+	}
+
+	// The Drive backoff still finds the API error behind the sentinel
+	var apiErr *googleapi.Error
+	if !errors.As(classifyError(&googleapi.Error{Code: http.StatusTooManyRequests}), &apiErr) || apiErr.Code != http.StatusTooManyRequests {
+		t.Error("classifyError() hid the googleapi.Error from errors.As")
+	}
+}
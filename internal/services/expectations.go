@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+const expectedFilesCollection = "expectedFiles"
+
+// Most filenames accepted in one AddExpected call.
+const MaxExpectedFilesPerRequest = 10000
+
+// Tracks filenames expected to arrive through sync and reports which never made it.
+type ExpectationService struct {
+	client    *firestore.Client
+	firestore *FirestoreService
+}
+
+// Creates an expectation store alongside the image collection.
+func NewExpectationService(fs *FirestoreService) *ExpectationService {
+	return &ExpectationService{
+		client:    fs.client,
+		firestore: fs,
+	}
+}
+
+// Registers filenames as expected. Names already registered keep their original registration
+// time and are counted as duplicates; blank names are ignored.
+// Returns the number newly added and the number of duplicates.
+func (s *ExpectationService) AddExpected(ctx context.Context, fileNames []string) (int, int, error) {
+	if len(fileNames) == 0 {
+		return 0, 0, fmt.Errorf("%w: no filenames given", apperrors.ErrInvalidInput)
+	}
+	if len(fileNames) > MaxExpectedFilesPerRequest {
+		return 0, 0, fmt.Errorf("%w: at most %d filenames per request", apperrors.ErrInvalidInput, MaxExpectedFilesPerRequest)
+	}
+
+	now := time.Now()
+	added, duplicates := 0, 0
+	for _, name := range fileNames {
+		name = strings.TrimSpace(name)
+		key := expectationKey(name)
+		if key == "" {
+			continue
+		}
+
+		_, err := s.client.Collection(expectedFilesCollection).Doc(key).Create(ctx, &models.ExpectedFile{
+			FileName:     path.Base(name),
+			RegisteredAt: now,
+		})
+		if status.Code(err) == codes.AlreadyExists {
+			duplicates++
+			continue
+		}
+		if err != nil {
+			return added, duplicates, fmt.Errorf("failed to register %s: %w", name, classifyError(err))
+		}
+		added++
+	}
+
+	return added, duplicates, nil
+}
+
+// Diffs every registered expectation against the image collection. Matching is case-insensitive
+// and treats a HEIC/HEIF expectation as satisfied by the converted .jpg.
+func (s *ExpectationService) Report(ctx context.Context) (*models.ExpectedFilesReport, error) {
+	ingested := make(map[string]time.Time)
+	if err := s.firestore.ForEachImageMetadata(ctx, 500, func(img *models.ImageMetadata) error {
+		ingested[expectationKey(img.FileName)] = img.CreatedAt
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	report := &models.ExpectedFilesReport{
+		SatisfiedLate: []*models.ExpectedFile{},
+		Missing:       []*models.ExpectedFile{},
+		GeneratedAt:   time.Now(),
+	}
+
+	iter := s.client.Collection(expectedFilesCollection).Documents(ctx)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list expected files: %w", classifyError(err))
+		}
+
+		var expected models.ExpectedFile
+		if err := doc.DataTo(&expected); err != nil {
+			// Log but don't fail on individual document parse errors
+			continue
+		}
+		report.Expected++
+
+		createdAt, ok := ingested[doc.Ref.ID]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, &expected)
+		case createdAt.After(expected.RegisteredAt):
+			expected.IngestedAt = createdAt
+			report.SatisfiedLate = append(report.SatisfiedLate, &expected)
+		default:
+			report.Satisfied++
+		}
+	}
+
+	sort.Slice(report.Missing, func(i, j int) bool {
+		return report.Missing[i].FileName < report.Missing[j].FileName
+	})
+	sort.Slice(report.SatisfiedLate, func(i, j int) bool {
+		return report.SatisfiedLate[i].FileName < report.SatisfiedLate[j].FileName
+	})
+
+	return report, nil
+}
+
+// Normalises a filename for matching: base name, lower-cased, with HEIC/HEIF extensions
+// mapped to .jpg since those files are stored converted. Also used as the document ID.
+func expectationKey(fileName string) string {
+	name := strings.ToLower(path.Base(strings.TrimSpace(fileName)))
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+
+	switch ext := path.Ext(name); ext {
+	case ".heic", ".heif":
+		name = strings.TrimSuffix(name, ext) + ".jpg"
+	}
+	return name
+}
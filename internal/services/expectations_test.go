@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+func TestExpectationKey(t *testing.T) {
+	tests := []struct {
+		fileName string
+		want     string
+	}{
+		{"IMG_0001.JPG", "img_0001.jpg"},
+		{"  DCIM/100APPLE/IMG_0002.jpg ", "img_0002.jpg"},
+		{"IMG_0003.HEIC", "img_0003.jpg"},
+		{"img_0004.heif", "img_0004.jpg"},
+		{"clip.MOV", "clip.mov"},
+		{"", ""},
+		{"..", ""},
+		{"/", ""},
+	}
+
+	for _, tt := range tests {
+		if got := expectationKey(tt.fileName); got != tt.want {
+			t.Errorf("expectationKey(%q) = %q, want %q", tt.fileName, got, tt.want)
+		}
+	}
+}
+
+func TestExpectedFilesReport(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	expectations := NewExpectationService(fs)
+	ctx := context.Background()
+
+	// Ingested before registration: satisfied, matched case-insensitively
+	seedImage(t, fs, "early", &models.ImageMetadata{FileName: "img_0001.jpg", CreatedAt: time.Now().Add(-time.Hour)})
+	// Ingested after registration: satisfied late, the HEIC expectation matching its .jpg conversion
+	seedImage(t, fs, "late", &models.ImageMetadata{FileName: "IMG_0002.jpg", CreatedAt: time.Now().Add(time.Hour)})
+	// Never expected: not part of the report
+	seedImage(t, fs, "unexpected", &models.ImageMetadata{FileName: "other.jpg", CreatedAt: time.Now()})
+
+	added, duplicates, err := expectations.AddExpected(ctx, []string{
+		"IMG_0001.JPG",
+		"IMG_0002.HEIC",
+		"IMG_0003.JPG",
+		"img_0003.jpg", // Same file again
+		"  ",
+	})
+	if err != nil {
+		t.Fatalf("AddExpected: %v", err)
+	}
+	if added != 3 || duplicates != 1 {
+		t.Errorf("AddExpected = %d added, %d duplicates; want 3 and 1", added, duplicates)
+	}
+
+	// Registering again keeps the original entries
+	if added, duplicates, err := expectations.AddExpected(ctx, []string{"img_0001.jpg"}); err != nil || added != 0 || duplicates != 1 {
+		t.Errorf("re-registering = %d added, %d duplicates, %v; want 0, 1, nil", added, duplicates, err)
+	}
+
+	report, err := expectations.Report(ctx)
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	if report.Expected != 3 || report.Satisfied != 1 {
+		t.Errorf("report has %d expected, %d satisfied; want 3 and 1", report.Expected, report.Satisfied)
+	}
+	if len(report.SatisfiedLate) != 1 || report.SatisfiedLate[0].FileName != "IMG_0002.HEIC" {
+		t.Errorf("satisfied late = %v, want IMG_0002.HEIC", report.SatisfiedLate)
+	} else if report.SatisfiedLate[0].IngestedAt.IsZero() {
+		t.Error("satisfied late entry has no IngestedAt")
+	}
+	if len(report.Missing) != 1 || report.Missing[0].FileName != "IMG_0003.JPG" {
+		t.Errorf("missing = %v, want IMG_0003.JPG", report.Missing)
+	}
+}
+
+func TestAddExpectedRejectsEmptyList(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	if _, _, err := NewExpectationService(fs).AddExpected(context.Background(), nil); !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("AddExpected(nil) error = %v, want ErrInvalidInput", err)
+	}
+}
@@ -2,12 +2,16 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/firestore"
-	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
@@ -17,78 +21,254 @@ import (
 )
 
 type FirestoreService struct {
-	client     *firestore.Client
-	collection string
+	client           *firestore.Client
+	collection       string
+	deterministicIDs bool
 }
 
+// Creates a service keying new documents by deterministic IDs (see DeterministicDocumentID).
 func NewFirestoreService(client *firestore.Client, collection string) *FirestoreService {
 	return &FirestoreService{
-		client:     client,
-		collection: collection,
+		client:           client,
+		collection:       collection,
+		deterministicIDs: true,
+	}
+}
+
+// Sets whether new documents get deterministic IDs (the default), so re-ingesting the same media
+// updates one document instead of adding duplicates, or random ones.
+func (fs *FirestoreService) SetDeterministicIDs(enabled bool) {
+	fs.deterministicIDs = enabled
+}
+
+// Derives a stable document ID for a piece of media. Drive-sourced media use the Drive file ID,
+// which survives renames; anything else uses the SHA-256 of its stored bytes (contentHash, as in
+// ImageMetadata.ContentHash), so the same bytes uploaded under another name or month prefix land
+// on the same document. Records without a content hash, such as ones written before it was
+// stored, fall back to the SHA-256 of their normalized file name (see normalizedFileName).
+// Returns "" if none of these is known.
+func DeterministicDocumentID(driveFileID, contentHash, fileName string) string {
+	if driveFileID != "" {
+		return driveFileID
+	}
+	if hash := strings.ToLower(strings.TrimSpace(contentHash)); hash != "" {
+		return hash
+	}
+	name := normalizedFileName(fileName)
+	if name == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+// Normalizes a file name for DeterministicDocumentID: the base name without any directory (such as
+// the YYYY/MM storage prefix) or surrounding space, lower-cased like fileNameLower, with HEIC/HEIF
+// names mapped to the .jpg they are stored as.
+func normalizedFileName(fileName string) string {
+	name := path.Base(strings.TrimSpace(strings.ReplaceAll(fileName, "\\", "/")))
+	if name == "." || name == "/" {
+		return ""
+	}
+	return strings.ToLower(storedFileName(name, ""))
+}
+
+// Fills the fields derived from others before a whole document is written: the lower-cased file
+// name for search and the list of missing fields for ListImagesMissing*.
+func setDerivedFields(metadata *models.ImageMetadata) {
+	metadata.FileNameLower = strings.ToLower(metadata.FileName)
+	metadata.Missing = utils.MissingFields(metadata)
+}
+
+// Returns the document ID new media should be stored under, or "" when deterministic IDs are
+// disabled or can't be derived (so the document gets a random ID).
+func (fs *FirestoreService) deterministicID(metadata *models.ImageMetadata) string {
+	if !fs.deterministicIDs {
+		return ""
+	}
+	return DeterministicDocumentID(metadata.DriveFileID, metadata.ContentHash, metadata.FileName)
+}
+
+// Reads at most one document reference (no fields), the cheapest query that proves
+// credentials and collection access.
+func (fs *FirestoreService) Ping(ctx context.Context) error {
+	if _, err := fs.client.Collection(fs.collection).Select().Limit(1).Documents(ctx).GetAll(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", fs.collection, classifyError(err))
+	}
+	return nil
+}
+
+// Runs query to completion, retrying transient failures with withRetry. Results are read in
+// full rather than iterated, so a stream that breaks midway is retried from the start instead
+// of returning a partial result.
+func (fs *FirestoreService) getAll(ctx context.Context, query firestore.Query) ([]*firestore.DocumentSnapshot, error) {
+	var docs []*firestore.DocumentSnapshot
+	err := withRetry(ctx, "query", func() error {
+		var err error
+		docs, err = query.Documents(ctx).GetAll()
+		return err
+	})
+	return docs, err
+}
+
+// Smallest number of documents visiblePage reads per query.
+const visiblePageChunk = 100
+
+// Reads one page of the documents query returns, leaving out quarantined and deleted ones. Hidden
+// documents can't be excluded in the query itself (an absent status can't be matched), so the
+// query is read in chunks, resuming after the last document read, and pages are counted over
+// visible documents only; a limit of 0 reads them all.
+func (fs *FirestoreService) visiblePage(ctx context.Context, query firestore.Query, limit int, page int) ([]*models.ImageMetadata, error) {
+	skip := page * limit
+	chunk := max(limit, visiblePageChunk)
+	var results []*models.ImageMetadata
+	var last *firestore.DocumentSnapshot
+	for {
+		q := query
+		if limit > 0 {
+			q = q.Limit(chunk)
+		}
+		if last != nil {
+			q = q.StartAfter(last)
+		}
+		docs, err := fs.getAll(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			var metadata models.ImageMetadata
+			if err := doc.DataTo(&metadata); err != nil {
+				// Log but don't fail on individual document parse errors
+				continue
+			}
+			if metadata.Hidden() {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			metadata.Id = doc.Ref.ID
+			metadata.Revision = doc.UpdateTime
+			results = append(results, &metadata)
+			if limit > 0 && len(results) == limit {
+				return results, nil
+			}
+		}
+		if limit == 0 || len(docs) < chunk {
+			return results, nil
+		}
+		last = docs[len(docs)-1]
 	}
 }
 
 // Retrieves image metadata by document ID.
+// IDs from before the deterministic ID migration are resolved via the legacyIds field.
 func (fs *FirestoreService) GetImageMetadata(ctx context.Context, id string) (*models.ImageMetadata, error) {
-	doc, err := fs.client.Collection(fs.collection).Doc(id).Get(ctx)
+	ref := fs.client.Collection(fs.collection).Doc(id)
+	var doc *firestore.DocumentSnapshot
+	err := withRetry(ctx, "get "+id, func() error {
+		var err error
+		doc, err = ref.Get(ctx)
+		return err
+	})
 	if err != nil {
 		// Check if document not found
 		if status.Code(err) == codes.NotFound {
-			return nil, errors.ErrNotFound
+			return fs.getImageMetadataByLegacyID(ctx, id)
 		}
-		return nil, fmt.Errorf("failed to get document: %w", err)
+		return nil, fmt.Errorf("failed to get document: %w", classifyError(err))
 	}
 
 	var metadata models.ImageMetadata
 	if err := doc.DataTo(&metadata); err != nil {
 		return nil, fmt.Errorf("failed to parse metadata: %w", err)
 	}
+	metadata.Id = doc.Ref.ID
+	metadata.Revision = doc.UpdateTime
 
 	return &metadata, nil
 }
 
+// Resolves a pre-migration random document ID to the document that replaced it.
+func (fs *FirestoreService) getImageMetadataByLegacyID(ctx context.Context, id string) (*models.ImageMetadata, error) {
+	return fs.firstImageMetadata(ctx, fs.client.Collection(fs.collection).Where("legacyIds", "array-contains", id))
+}
+
 // Retrieves all image metadata from the collection with pagination.
+// Quarantined documents are left out, and don't count towards pages; ListAllImageMetadata includes them.
 func (fs *FirestoreService) ListImageMetadata(ctx context.Context, limit int, page int) ([]*models.ImageMetadata, error) {
 	// Validate pagination parameters
 	if limit < 0 {
-		return nil, fmt.Errorf("limit cannot be negative")
+		return nil, fmt.Errorf("%w: limit cannot be negative", errors.ErrInvalidInput)
 	}
 	if page < 0 {
-		return nil, fmt.Errorf("page cannot be negative")
+		return nil, fmt.Errorf("%w: page cannot be negative", errors.ErrInvalidInput)
 	}
 
 	// Order by takenAt if available, fallback to createdAt
 	query := fs.client.Collection(fs.collection).OrderBy("takenAt", firestore.Desc)
 
-	if limit > 0 {
-		// Cap maximum limit to prevent excessive memory usage
-		if limit > 1000 {
-			limit = 1000
-		}
-		query = query.Limit(limit)
-		if page > 0 {
-			query = query.Offset(page * limit)
-		}
+	// Cap maximum limit to prevent excessive memory usage
+	if limit > 1000 {
+		limit = 1000
 	}
 
-	iter := query.Documents(ctx)
-	defer iter.Stop()
+	results, err := fs.visiblePage(ctx, query, limit, page)
+	if err != nil {
+		return nil, fmt.Errorf("failed to iterate documents: %w", classifyError(err))
+	}
 
-	var results []*models.ImageMetadata
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to iterate documents: %w", err)
-		}
+	return results, nil
+}
+
+// Lists documents without coordinates. See ListIncompleteImages.
+func (fs *FirestoreService) ListImagesMissingCoordinates(ctx context.Context) ([]*models.ImageMetadata, error) {
+	return fs.listImagesMissing(ctx, models.MissingCoordinates)
+}
+
+// Lists documents without takenAt. See ListIncompleteImages.
+func (fs *FirestoreService) ListImagesMissingTakenAt(ctx context.Context) ([]*models.ImageMetadata, error) {
+	return fs.listImagesMissing(ctx, models.MissingTakenAt)
+}
+
+// Lists documents without a geoLocation. See ListIncompleteImages.
+func (fs *FirestoreService) ListImagesMissingGeoLocation(ctx context.Context) ([]*models.ImageMetadata, error) {
+	return fs.listImagesMissing(ctx, models.MissingGeoLocation)
+}
+
+// Lists the documents utils.HasEmptyFields reports as incomplete, reading only those instead of
+// the whole collection. Firestore can't query for absent fields, so this goes through the missing
+// array written with every document; documents written before it existed aren't found until
+// backfilled with update-metadata -missing-fields. Quarantined documents are left out.
+func (fs *FirestoreService) ListIncompleteImages(ctx context.Context) ([]*models.ImageMetadata, error) {
+	return fs.listImagesMissing(ctx, models.MissingCoordinates, models.MissingTakenAt, models.MissingGeoLocation, models.MissingFormattedDate)
+}
+
+// Lists non-quarantined documents whose missing array holds any of fields.
+func (fs *FirestoreService) listImagesMissing(ctx context.Context, fields ...string) ([]*models.ImageMetadata, error) {
+	coll := fs.client.Collection(fs.collection)
+	query := coll.Where("missing", "array-contains", fields[0])
+	if len(fields) > 1 {
+		query = coll.Where("missing", "array-contains-any", fields)
+	}
+
+	docs, err := fs.getAll(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query incomplete documents: %w", classifyError(err))
+	}
 
+	var results []*models.ImageMetadata
+	for _, doc := range docs {
 		var metadata models.ImageMetadata
 		if err := doc.DataTo(&metadata); err != nil {
-			// Log but don't fail on individual document parse errors
 			continue
 		}
+		if metadata.Hidden() {
+			continue
+		}
+		metadata.Id = doc.Ref.ID
+		metadata.Revision = doc.UpdateTime
 
 		results = append(results, &metadata)
 	}
@@ -96,15 +276,53 @@ func (fs *FirestoreService) ListImageMetadata(ctx context.Context, limit int, pa
 	return results, nil
 }
 
+// Sets only the missing field of a document from its current values, leaving everything else
+// untouched (backfill for documents written before the field existed).
+func (fs *FirestoreService) SetMissingFields(ctx context.Context, id string, missing []string) error {
+	value := any(missing)
+	if len(missing) == 0 {
+		value = firestore.Delete
+	}
+	return fs.updateFields(ctx, id, []firestore.Update{{Path: "missing", Value: value}})
+}
+
+// Lists documents without a takenAt field. Listings ordered by takenAt (ListImageMetadata and
+// ListImageMetadataFiltered) can't return these at all, since Firestore leaves documents without
+// the ordered field out of the query. Reads the whole collection, as absent fields can't be
+// queried and such documents predate the missing array.
+func (fs *FirestoreService) ListImagesWithoutTakenAt(ctx context.Context) ([]*models.ImageMetadata, error) {
+	var results []*models.ImageMetadata
+	err := fs.ForEachImageMetadata(ctx, 500, func(metadata *models.ImageMetadata) error {
+		if metadata.TakenAt.IsZero() {
+			results = append(results, metadata)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Sets only the takenAt field of a document and drops it from the missing array, bringing the
+// document back into listings ordered by takenAt.
+func (fs *FirestoreService) SetTakenAt(ctx context.Context, id string, takenAt time.Time) error {
+	return fs.updateFields(ctx, id, []firestore.Update{
+		{Path: "takenAt", Value: takenAt},
+		{Path: "missing", Value: firestore.ArrayRemove(models.MissingTakenAt)},
+	})
+}
+
 // Retrieves all image metadata ordered by createdAt.
 // Used for migrations where takenAt field might not exist yet.
 func (fs *FirestoreService) ListAllImageMetadata(ctx context.Context, limit int, page int) ([]*models.ImageMetadata, error) {
 	// Validate pagination parameters
 	if limit < 0 {
-		return nil, fmt.Errorf("limit cannot be negative")
+		return nil, fmt.Errorf("%w: limit cannot be negative", errors.ErrInvalidInput)
 	}
 	if page < 0 {
-		return nil, fmt.Errorf("page cannot be negative")
+		return nil, fmt.Errorf("%w: page cannot be negative", errors.ErrInvalidInput)
 	}
 
 	// Order by createdAt instead of takenAt for migration compatibility
@@ -121,84 +339,1094 @@ func (fs *FirestoreService) ListAllImageMetadata(ctx context.Context, limit int,
 		}
 	}
 
-	iter := query.Documents(ctx)
-	defer iter.Stop()
+	docs, err := fs.getAll(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to iterate documents: %w", classifyError(err))
+	}
 
 	var results []*models.ImageMetadata
+	for _, doc := range docs {
+		var metadata models.ImageMetadata
+		if err := doc.DataTo(&metadata); err != nil {
+			// Log but don't fail on individual document parse errors
+			continue
+		}
+		metadata.Id = doc.Ref.ID
+		metadata.Revision = doc.UpdateTime
+
+		results = append(results, &metadata)
+	}
+
+	return results, nil
+}
+
+// Walks every image metadata document in pages of pageSize, using document-ID cursors so
+// collections larger than the list endpoints' 1000-document cap are covered without offsets.
+// fn is called once per document; returning an error from it stops the walk.
+func (fs *FirestoreService) ForEachImageMetadata(ctx context.Context, pageSize int, fn func(*models.ImageMetadata) error) error {
+	if pageSize <= 0 {
+		return fmt.Errorf("%w: page size must be positive", errors.ErrInvalidInput)
+	}
+
+	var last *firestore.DocumentSnapshot
 	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
+		query := fs.client.Collection(fs.collection).OrderBy(firestore.DocumentID, firestore.Asc).Limit(pageSize)
+		if last != nil {
+			query = query.StartAfter(last)
 		}
+
+		docs, err := fs.getAll(ctx, query)
 		if err != nil {
-			return nil, fmt.Errorf("failed to iterate documents: %w", err)
+			return fmt.Errorf("failed to fetch page: %w", classifyError(err))
+		}
+
+		for _, doc := range docs {
+			var metadata models.ImageMetadata
+			if err := doc.DataTo(&metadata); err != nil {
+				// Log but don't fail on individual document parse errors
+				continue
+			}
+			metadata.Id = doc.Ref.ID
+			metadata.Revision = doc.UpdateTime
+
+			if err := fn(&metadata); err != nil {
+				return err
+			}
 		}
 
+		if len(docs) < pageSize {
+			return nil
+		}
+		last = docs[len(docs)-1]
+	}
+}
+
+// Creates a new image metadata document.
+// With deterministic IDs enabled, the document is keyed as DeterministicDocumentID says and
+// created with Create, so a second create of the same media fails with an error wrapping
+// ErrConflict (Firestore's AlreadyExists) instead of overwriting fields such as the description
+// or favorite mark. The ID is returned with that error so callers can fall back to an update.
+func (fs *FirestoreService) CreateImageMetadata(ctx context.Context, metadata *models.ImageMetadata) (string, error) {
+	setDerivedFields(metadata)
+	if id := fs.deterministicID(metadata); id != "" {
+		err := withRetry(ctx, "create "+id, func() error {
+			_, err := fs.client.Collection(fs.collection).Doc(id).Create(ctx, metadata)
+			return err
+		})
+		if err != nil {
+			return id, fmt.Errorf("failed to create metadata %s: %w", id, classifyError(err))
+		}
+		return id, nil
+	}
+
+	// Create under an ID picked up front rather than Add, so a retry after a write that did land
+	// finds the document instead of adding a duplicate
+	docRef := fs.client.Collection(fs.collection).NewDoc()
+	err := withRetry(ctx, "create "+docRef.ID, func() error {
+		_, err := docRef.Create(ctx, metadata)
+		return err
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		err = nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create metadata: %w", classifyError(err))
+	}
+
+	return docRef.ID, nil
+}
+
+// Creates or updates the document with the given ID inside a transaction. merge receives the
+// stored metadata as read in the transaction (nil if the document doesn't exist yet) and returns
+// what to write, so concurrent writers merge over each other's result instead of overwriting it.
+// merge may run more than once if the transaction is retried, so it must not keep state between calls.
+// The returned metadata replaces the whole document; see MergeExtractedMetadata to write only extracted fields.
+// When the merge changes what album summaries show of the image, the albums holding it are refreshed.
+func (fs *FirestoreService) MergeImageMetadata(ctx context.Context, id string, merge func(existing *models.ImageMetadata) (*models.ImageMetadata, error)) (*models.ImageMetadata, error) {
+	return fs.mergeImageMetadata(ctx, id, merge, false)
+}
+
+// Merges like MergeImageMetadata, but an existing document only has the fields sync extracts
+// and takes from Drive (see extractedFieldUpdates) written, so fields set by other writers,
+// including ones ImageMetadata doesn't know about, survive. A new document is written whole.
+func (fs *FirestoreService) MergeExtractedMetadata(ctx context.Context, id string, merge func(existing *models.ImageMetadata) (*models.ImageMetadata, error)) (*models.ImageMetadata, error) {
+	return fs.mergeImageMetadata(ctx, id, merge, true)
+}
+
+func (fs *FirestoreService) mergeImageMetadata(ctx context.Context, id string, merge func(existing *models.ImageMetadata) (*models.ImageMetadata, error), extractedOnly bool) (*models.ImageMetadata, error) {
+	if id == "" {
+		return nil, fmt.Errorf("%w: document ID cannot be empty", errors.ErrInvalidInput)
+	}
+
+	ref := fs.client.Collection(fs.collection).Doc(id)
+	var before, metadata *models.ImageMetadata // before is the stored record, kept apart from merge's changes
+	err := fs.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		before = nil
+		var existing *models.ImageMetadata
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			existing = &models.ImageMetadata{}
+			if err := doc.DataTo(existing); err != nil {
+				return fmt.Errorf("failed to parse metadata: %w", err)
+			}
+			existing.Id = id
+			existing.Revision = doc.UpdateTime
+			stored := *existing
+			before = &stored
+		}
+
+		metadata, err = merge(existing)
+		if err != nil {
+			return err
+		}
+		metadata.Id = id
+		if existing != nil && extractedOnly {
+			return tx.Update(ref, extractedFieldUpdates(metadata, true))
+		}
+		setDerivedFields(metadata)
+		return tx.Set(ref, metadata)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to persist metadata: %w", classifyError(err))
+	}
+
+	// A new document isn't in any album yet
+	if before != nil && affectsAlbumSummary(before, metadata) {
+		if err := fs.refreshAlbumsContaining(ctx, id); err != nil {
+			return nil, fmt.Errorf("metadata persisted but album refresh failed: %w", err)
+		}
+	}
+
+	return metadata, nil
+}
+
+// Replaces an image metadata document with metadata. Fields not in ImageMetadata, such as ones
+// set by hand or by other tools, are dropped; use UpdateImageMetadataFields to change only some fields.
+func (fs *FirestoreService) ReplaceImageMetadata(ctx context.Context, id string, metadata *models.ImageMetadata) error {
+	if id == "" {
+		return fmt.Errorf("%w: document ID cannot be empty", errors.ErrInvalidInput)
+	}
+	setDerivedFields(metadata)
+	ref := fs.client.Collection(fs.collection).Doc(id)
+	err := withRetry(ctx, "replace "+id, func() error {
+		_, err := ref.Set(ctx, metadata)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replace metadata: %w", classifyError(err))
+	}
+
+	return nil
+}
+
+// Replaces a document only if it hasn't changed since it was read at revision (its update time,
+// as set in ImageMetadata.Revision by reads). Returns ErrConflict if another writer got there
+// first, so the caller can re-read, re-apply its change and retry. A zero revision writes unconditionally.
+// Albums holding the image are refreshed when the change alters their summary.
+func (fs *FirestoreService) ReplaceImageMetadataAt(ctx context.Context, id string, metadata *models.ImageMetadata, revision time.Time) error {
+	if revision.IsZero() {
+		return fs.ReplaceImageMetadata(ctx, id, metadata)
+	}
+	if id == "" {
+		return fmt.Errorf("%w: document ID cannot be empty", errors.ErrInvalidInput)
+	}
+
+	ref := fs.client.Collection(fs.collection).Doc(id)
+	var before models.ImageMetadata
+	err := fs.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return errors.ErrNotFound
+			}
+			return err
+		}
+		if !doc.UpdateTime.Equal(revision) {
+			return fmt.Errorf("%w: %s changed at %s (expected %s)", errors.ErrConflict, id,
+				doc.UpdateTime.Format(time.RFC3339Nano), revision.Format(time.RFC3339Nano))
+		}
+		before = models.ImageMetadata{}
+		if err := doc.DataTo(&before); err != nil {
+			return fmt.Errorf("failed to parse metadata: %w", err)
+		}
+		setDerivedFields(metadata)
+		return tx.Set(ref, metadata)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update metadata: %w", classifyError(err))
+	}
+
+	if affectsAlbumSummary(&before, metadata) {
+		if err := fs.refreshAlbumsContaining(ctx, id); err != nil {
+			return fmt.Errorf("metadata updated but album refresh failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// MetadataUpdate is one document written by BulkUpdateImageMetadata.
+type MetadataUpdate struct {
+	Id       string
+	Metadata *models.ImageMetadata // Record with freshly extracted fields merged in
+	Revision time.Time             // Update time the merge was based on; zero writes unconditionally
+}
+
+// Writes the fields sync extracts from the file (location, dates, resolution, color, hash) for
+// many documents through a BulkWriter, which batches and parallelises the writes. Other fields,
+// such as descriptions, Drive properties and favorites, are left as stored. Returns one error per update, nil where
+// the write succeeded; a document changed since its revision fails with ErrConflict.
+func (fs *FirestoreService) BulkUpdateImageMetadata(ctx context.Context, updates []MetadataUpdate) []error {
+	errs := make([]error, len(updates))
+	jobs := make([]*firestore.BulkWriterJob, len(updates))
+
+	bw := fs.client.BulkWriter(ctx)
+	for i, u := range updates {
+		if u.Id == "" {
+			errs[i] = fmt.Errorf("%w: document ID cannot be empty", errors.ErrInvalidInput)
+			continue
+		}
+		precond := firestore.Exists
+		if !u.Revision.IsZero() {
+			precond = firestore.LastUpdateTime(u.Revision)
+		}
+		job, err := bw.Update(fs.client.Collection(fs.collection).Doc(u.Id), extractedFieldUpdates(u.Metadata, false), precond)
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to queue update: %w", classifyError(err))
+			continue
+		}
+		jobs[i] = job
+	}
+	bw.End()
+
+	for i, job := range jobs {
+		if job == nil {
+			continue
+		}
+		if _, err := job.Results(); err != nil {
+			if !updates[i].Revision.IsZero() && status.Code(err) == codes.FailedPrecondition {
+				errs[i] = fmt.Errorf("%w: %s changed since %s", errors.ErrConflict, updates[i].Id, updates[i].Revision.Format(time.RFC3339Nano))
+				continue
+			}
+			errs[i] = fmt.Errorf("failed to update metadata: %w", classifyError(err))
+		}
+	}
+
+	return errs
+}
+
+// Lists the fields mergeExtracted takes from the file as updates, and withSource, those it takes
+// from a Drive file too (the description, appProperties and file ID). Empty values delete the
+// field, as the omitempty tags on ImageMetadata would when writing the whole document.
+func extractedFieldUpdates(m *models.ImageMetadata, withSource bool) []firestore.Update {
+	missing := utils.MissingFields(m)
+	fields := []fieldUpdate{
+		{"coordinates", m.Coordinates, m.Coordinates == (models.Coordinates{})},
+		{"geoLocation", m.GeoLocation, m.GeoLocation == ""},
+		{"city", m.City, m.City == ""},
+		{"region", m.Region, m.Region == ""},
+		{"country", m.Country, m.Country == ""},
+		{"countryCode", m.CountryCode, m.CountryCode == ""},
+		{"town", m.Town, m.Town == ""},
+		{"village", m.Village, m.Village == ""},
+		{"state", m.State, m.State == ""},
+		{"county", m.County, m.County == ""},
+		{"placeKey", m.PlaceKey, m.PlaceKey == ""},
+		{"geohash", m.Geohash, m.Geohash == ""},
+		{"takenAt", m.TakenAt, m.TakenAt.IsZero()},
+		{"formattedDate", m.FormattedDate, m.FormattedDate == ""},
+		{"resolution", m.Resolution, len(m.Resolution) == 0},
+		{"dominantColor", m.DominantColor, m.DominantColor == ""},
+		{"contentHash", m.ContentHash, m.ContentHash == ""},
+		{"sourceChecksum", m.SourceChecksum, m.SourceChecksum == ""},
+		{"sourceModifiedTime", m.SourceModifiedTime, m.SourceModifiedTime.IsZero()},
+		{"updatedAt", m.UpdatedAt, m.UpdatedAt.IsZero()},
+		{"missing", missing, len(missing) == 0},
+	}
+	if withSource {
+		fields = append(fields, []fieldUpdate{
+			{"description", m.Description, m.Description == ""},
+			{"hasDescription", m.HasDescription, !m.HasDescription},
+			{"sourceDescriptionHash", m.SourceDescriptionHash, m.SourceDescriptionHash == ""},
+			{"sourceProperties", m.SourceProperties, len(m.SourceProperties) == 0},
+			{"driveFileId", m.DriveFileID, m.DriveFileID == ""},
+		}...)
+	}
+
+	updates := make([]firestore.Update, 0, len(fields))
+	for _, f := range fields {
+		value := f.value
+		if f.empty {
+			value = firestore.Delete
+		}
+		updates = append(updates, firestore.Update{Path: f.path, Value: value})
+	}
+	return updates
+}
+
+// One field extractedFieldUpdates writes, deleted when empty.
+type fieldUpdate struct {
+	path  string
+	value any
+	empty bool
+}
+
+// Sets only the dominantColor field of a document, leaving everything else untouched.
+func (fs *FirestoreService) SetDominantColor(ctx context.Context, id string, color string) error {
+	return fs.updateFields(ctx, id, []firestore.Update{{Path: "dominantColor", Value: color}})
+}
+
+// Sets only the fileNameLower field of a document, leaving everything else untouched.
+func (fs *FirestoreService) SetFileNameLower(ctx context.Context, id string, fileNameLower string) error {
+	return fs.updateFields(ctx, id, []firestore.Update{{Path: "fileNameLower", Value: fileNameLower}})
+}
+
+// Sets only the placeKey field of a document, leaving everything else untouched.
+func (fs *FirestoreService) SetPlaceKey(ctx context.Context, id string, placeKey string) error {
+	return fs.updateFields(ctx, id, []firestore.Update{{Path: "placeKey", Value: placeKey}})
+}
+
+// Sets the geoLocation and location hierarchy of a document and records whether it was geocoded
+// directly or propagated from a nearby point (see GeocodeTrip), so propagated values can be refined later.
+func (fs *FirestoreService) SetGeoLocation(ctx context.Context, id string, location string, parts models.LocationParts, source string) error {
+	updates := append(locationPartsUpdates(parts),
+		firestore.Update{Path: "geoLocation", Value: location},
+		firestore.Update{Path: "geoLocationSource", Value: source},
+	)
+	if location != "" {
+		updates = append(updates, firestore.Update{Path: "missing", Value: firestore.ArrayRemove(models.MissingGeoLocation)})
+	}
+	return fs.updateFields(ctx, id, updates)
+}
+
+// Replaces the geoLocation and location hierarchy of a document after a forced re-geocode,
+// marking the location as directly geocoded and bumping updatedAt. The write is conditional on
+// revision (see ReplaceImageMetadataAt) so coordinates changed in the meantime aren't paired
+// with a location looked up for the old ones. The countries of albums holding the image are
+// refreshed.
+func (fs *FirestoreService) ReplaceGeoLocation(ctx context.Context, id string, location string, parts models.LocationParts, revision time.Time) error {
+	updates := append(locationPartsUpdates(parts),
+		firestore.Update{Path: "geoLocation", Value: location},
+		firestore.Update{Path: "geoLocationSource", Value: GeoSourceDirect},
+		firestore.Update{Path: "updatedAt", Value: time.Now()},
+	)
+	if location != "" {
+		updates = append(updates, firestore.Update{Path: "missing", Value: firestore.ArrayRemove(models.MissingGeoLocation)})
+	}
+	if err := fs.updateFieldsAt(ctx, id, updates, revision); err != nil {
+		return err
+	}
+
+	if err := fs.refreshAlbumsContaining(ctx, id); err != nil {
+		return fmt.Errorf("location replaced but album refresh failed: %w", err)
+	}
+	return nil
+}
+
+// Sets or, when favorite is nil, toggles a document's favorite flag and bumps updatedAt,
+// without touching other fields. Returns the new value.
+func (fs *FirestoreService) SetFavorite(ctx context.Context, id string, favorite *bool) (bool, error) {
+	if id == "" {
+		return false, fmt.Errorf("%w: document ID cannot be empty", errors.ErrInvalidInput)
+	}
+
+	ref := fs.client.Collection(fs.collection).Doc(id)
+	var value bool
+	err := fs.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if favorite != nil {
+			value = *favorite
+		} else {
+			doc, err := tx.Get(ref)
+			if err != nil {
+				if status.Code(err) == codes.NotFound {
+					return errors.ErrNotFound
+				}
+				return err
+			}
+			current, _ := doc.Data()["favorite"].(bool)
+			value = !current
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "favorite", Value: value},
+			{Path: "updatedAt", Value: time.Now()},
+		})
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to set favorite: %w", classifyError(err))
+	}
+
+	return value, nil
+}
+
+// Writes the non-nil fields of an already validated patch, and bumps updatedAt. An empty
+// description is removed along with hasDescription.
+func (fs *FirestoreService) ApplyMetadataPatch(ctx context.Context, id string, patch models.ImageMetadataPatch) error {
+	updates := []firestore.Update{{Path: "updatedAt", Value: time.Now()}}
+	if patch.Description != nil {
+		if *patch.Description == "" {
+			updates = append(updates,
+				firestore.Update{Path: "description", Value: firestore.Delete},
+				firestore.Update{Path: "hasDescription", Value: firestore.Delete},
+			)
+		} else {
+			updates = append(updates,
+				firestore.Update{Path: "description", Value: *patch.Description},
+				firestore.Update{Path: "hasDescription", Value: true},
+			)
+		}
+	}
+	if patch.Visibility != nil {
+		updates = append(updates, firestore.Update{Path: "visibility", Value: *patch.Visibility})
+	}
+	return fs.updateFields(ctx, id, updates)
+}
+
+// Sets only the location hierarchy fields of a document, leaving geoLocation as it is.
+func (fs *FirestoreService) SetLocationParts(ctx context.Context, id string, parts models.LocationParts) error {
+	return fs.updateFields(ctx, id, locationPartsUpdates(parts))
+}
+
+// Builds the updates for the location hierarchy. Empty levels are deleted so a
+// re-geocode that no longer finds a region doesn't leave a stale one behind.
+func locationPartsUpdates(parts models.LocationParts) []firestore.Update {
+	value := func(v string) interface{} {
+		if v == "" {
+			return firestore.Delete
+		}
+		return v
+	}
+	return []firestore.Update{
+		{Path: "city", Value: value(parts.City)},
+		{Path: "region", Value: value(parts.Region)},
+		{Path: "country", Value: value(parts.Country)},
+		{Path: "countryCode", Value: value(parts.CountryCode)},
+		{Path: "town", Value: value(parts.Town)},
+		{Path: "village", Value: value(parts.Village)},
+		{Path: "state", Value: value(parts.State)},
+		{Path: "county", Value: value(parts.County)},
+	}
+}
+
+// Sets the status of a document (models.StatusQuarantined, models.StatusDeleted, or "" to clear it)
+// along with where its file now lives, and refreshes the summaries of albums holding it, which
+// leave hidden members out.
+func (fs *FirestoreService) SetStatus(ctx context.Context, id string, status string, storagePath string) error {
+	if err := fs.updateFields(ctx, id, []firestore.Update{
+		{Path: "status", Value: status},
+		{Path: "storagePath", Value: storagePath},
+	}); err != nil {
+		return err
+	}
+
+	if err := fs.refreshAlbumsContaining(ctx, id); err != nil {
+		return fmt.Errorf("status set but album refresh failed: %w", err)
+	}
+	return nil
+}
+
+// Points a document at a different stored file (e.g. the JPEG a HEIC was converted to), updating
+// its file name, lower-cased name, storage path and content type together.
+func (fs *FirestoreService) SetStoredFile(ctx context.Context, id string, fileName string, storagePath string, contentType string) error {
+	return fs.updateFields(ctx, id, []firestore.Update{
+		{Path: "fileName", Value: fileName},
+		{Path: "fileNameLower", Value: strings.ToLower(fileName)},
+		{Path: "storagePath", Value: storagePath},
+		{Path: "contentType", Value: contentType},
+	})
+}
+
+// Sets where a document's file lives after it was moved in the bucket. The write is conditional
+// on revision (see updateFieldsAt), so a document re-synced since it was read isn't repointed.
+func (fs *FirestoreService) SetStoragePath(ctx context.Context, id string, storagePath string, revision time.Time) error {
+	return fs.updateFieldsAt(ctx, id, []firestore.Update{{Path: "storagePath", Value: storagePath}}, revision)
+}
+
+// Sets only the geohash field of a document, leaving everything else untouched.
+func (fs *FirestoreService) SetGeohash(ctx context.Context, id string, geohash string) error {
+	return fs.updateFields(ctx, id, []firestore.Update{{Path: "geohash", Value: geohash}})
+}
+
+// Writes coordinates obtained from somewhere other than the file itself (e.g. a GPX track),
+// together with the fields derived from them, in one write. The write is conditional on
+// revision (see ReplaceImageMetadataAt) so a concurrent sync that found EXIF GPS wins.
+func (fs *FirestoreService) SetCoordinates(
+	ctx context.Context,
+	id string,
+	coordinates models.Coordinates,
+	source string,
+	placeKey string,
+	location string,
+	parts models.LocationParts,
+	revision time.Time,
+) error {
+	updates := []firestore.Update{
+		{Path: "coordinates", Value: coordinates},
+		{Path: "coordinatesSource", Value: source},
+		{Path: "geohash", Value: utils.GeohashFromCoordinates(coordinates)},
+		{Path: "placeKey", Value: placeKey},
+		{Path: "updatedAt", Value: time.Now()},
+	}
+	filled := []any{models.MissingCoordinates}
+	if location != "" {
+		updates = append(updates,
+			firestore.Update{Path: "geoLocation", Value: location},
+			firestore.Update{Path: "geoLocationSource", Value: GeoSourceDirect},
+		)
+		updates = append(updates, locationPartsUpdates(parts)...)
+		filled = append(filled, models.MissingGeoLocation)
+	}
+	updates = append(updates, firestore.Update{Path: "missing", Value: firestore.ArrayRemove(filled...)})
+	return fs.updateFieldsAt(ctx, id, updates, revision)
+}
+
+// Returns the most recent updatedAt in the collection (zero if it is empty), a cheap
+// single-document read used to tell whether derived data is still current.
+// Deletions don't move it.
+func (fs *FirestoreService) LatestUpdateTime(ctx context.Context) (time.Time, error) {
+	docs, err := fs.getAll(ctx, fs.client.Collection(fs.collection).
+		Select("updatedAt").
+		OrderBy("updatedAt", firestore.Desc).
+		Limit(1))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to query latest update: %w", classifyError(err))
+	}
+	if len(docs) == 0 {
+		return time.Time{}, nil
+	}
+
+	var metadata models.ImageMetadata
+	if err := docs[0].DataTo(&metadata); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse latest update: %w", err)
+	}
+	return metadata.UpdatedAt, nil
+}
+
+// Lists the id, coordinates and takenAt of every geotagged document, reading only those fields.
+// Quarantined documents are left out.
+func (fs *FirestoreService) ListMapPoints(ctx context.Context) ([]models.MapPoint, error) {
+	docs, err := fs.getAll(ctx, fs.client.Collection(fs.collection).Select("coordinates", "takenAt", "status"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan map points: %w", classifyError(err))
+	}
+
+	var points []models.MapPoint
+	for _, doc := range docs {
+
 		var metadata models.ImageMetadata
 		if err := doc.DataTo(&metadata); err != nil {
 			// Log but don't fail on individual document parse errors
 			continue
 		}
+		lat, lng, ok := utils.ParseCoordinates(metadata.Coordinates)
+		if !ok || metadata.Hidden() {
+			continue
+		}
+
+		point := models.MapPoint{Id: doc.Ref.ID, Lat: float32(lat), Lng: float32(lng)}
+		if !metadata.TakenAt.IsZero() {
+			point.TakenAt = metadata.TakenAt.Unix()
+		}
+		points = append(points, point)
+	}
 
+	return points, nil
+}
+
+// Lists documents taken within [start, end], in takenAt order.
+// A single-field range query, so no composite index is needed.
+func (fs *FirestoreService) ListImageMetadataTakenBetween(ctx context.Context, start, end time.Time) ([]*models.ImageMetadata, error) {
+	if end.Before(start) {
+		return nil, fmt.Errorf("%w: end must not be before start", errors.ErrInvalidInput)
+	}
+
+	docs, err := fs.getAll(ctx, fs.client.Collection(fs.collection).
+		Where("takenAt", ">=", start).
+		Where("takenAt", "<=", end).
+		OrderBy("takenAt", firestore.Asc))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query takenAt range: %w", classifyError(err))
+	}
+
+	var results []*models.ImageMetadata
+	for _, doc := range docs {
+
+		var metadata models.ImageMetadata
+		if err := doc.DataTo(&metadata); err != nil {
+			// Log but don't fail on individual document parse errors
+			continue
+		}
+		metadata.Id = doc.Ref.ID
+		metadata.Revision = doc.UpdateTime
 		results = append(results, &metadata)
 	}
 
 	return results, nil
 }
 
-// Creates a new image metadata document.
-func (fs *FirestoreService) CreateImageMetadata(ctx context.Context, metadata *models.ImageMetadata) (string, error) {
-	docRef, _, err := fs.client.Collection(fs.collection).Add(ctx, metadata)
+// Lists documents whose geohash starts with prefix, up to limit (0 for no limit).
+// A single-field range query, so no composite index is needed.
+func (fs *FirestoreService) ListImageMetadataByGeohashPrefix(ctx context.Context, prefix string, limit int) ([]*models.ImageMetadata, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("%w: geohash prefix cannot be empty", errors.ErrInvalidInput)
+	}
+	if limit < 0 {
+		return nil, fmt.Errorf("%w: limit cannot be negative", errors.ErrInvalidInput)
+	}
+
+	// '~' sorts after every geohash character, closing the prefix range
+	query := fs.client.Collection(fs.collection).
+		Where("geohash", ">=", prefix).
+		Where("geohash", "<", prefix+"~")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	docs, err := fs.getAll(ctx, query)
 	if err != nil {
-		return "", fmt.Errorf("failed to create metadata: %w", err)
+		return nil, fmt.Errorf("failed to query geohash prefix %s: %w", prefix, classifyError(err))
 	}
 
-	return docRef.ID, nil
+	var results []*models.ImageMetadata
+	for _, doc := range docs {
+		var metadata models.ImageMetadata
+		if err := doc.DataTo(&metadata); err != nil {
+			// Log but don't fail on individual document parse errors
+			continue
+		}
+		metadata.Id = doc.Ref.ID
+		metadata.Revision = doc.UpdateTime
+		results = append(results, &metadata)
+	}
+
+	return results, nil
+}
+
+// Finds image metadata whose file name starts with prefix, matching the stored name exactly or
+// fileNameLower case-insensitively, ordered by file name and paginated like ListImageMetadata.
+// Each field is a single-field range query, so no composite index is needed; the two result
+// sets are merged, which means every page reads all matches up to its end.
+func (fs *FirestoreService) SearchImageMetadataByPrefix(ctx context.Context, prefix string, limit int, page int) ([]*models.ImageMetadata, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("%w: search prefix cannot be empty", errors.ErrInvalidInput)
+	}
+	if limit < 0 {
+		return nil, fmt.Errorf("%w: limit cannot be negative", errors.ErrInvalidInput)
+	}
+	if page < 0 {
+		return nil, fmt.Errorf("%w: page cannot be negative", errors.ErrInvalidInput)
+	}
+	if limit == 0 || limit > 1000 {
+		limit = 1000
+	}
+	end := (page + 1) * limit
+
+	seen := make(map[string]*models.ImageMetadata)
+	for field, value := range map[string]string{"fileName": prefix, "fileNameLower": strings.ToLower(prefix)} {
+		// \uf8ff sorts after every character in use, closing the prefix range
+		docs, err := fs.getAll(ctx, fs.client.Collection(fs.collection).
+			Where(field, ">=", value).
+			Where(field, "<", value+"\uf8ff").
+			OrderBy(field, firestore.Asc).
+			Limit(end))
+		if err != nil {
+			return nil, fmt.Errorf("failed to search %s: %w", field, classifyError(err))
+		}
+
+		for _, doc := range docs {
+			if _, ok := seen[doc.Ref.ID]; ok {
+				continue
+			}
+
+			var metadata models.ImageMetadata
+			if err := doc.DataTo(&metadata); err != nil {
+				// Log but don't fail on individual document parse errors
+				continue
+			}
+			metadata.Id = doc.Ref.ID
+			metadata.Revision = doc.UpdateTime
+			seen[doc.Ref.ID] = &metadata
+		}
+	}
+
+	results := make([]*models.ImageMetadata, 0, len(seen))
+	for _, metadata := range seen {
+		if !metadata.Hidden() {
+			results = append(results, metadata)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].FileName != results[j].FileName {
+			return results[i].FileName < results[j].FileName
+		}
+		return results[i].Id < results[j].Id
+	})
+
+	start := page * limit
+	if start >= len(results) {
+		return []*models.ImageMetadata{}, nil
+	}
+	return results[start:min(end, len(results))], nil
 }
 
-// Updates an existing image metadata document.
-func (fs *FirestoreService) UpdateImageMetadata(ctx context.Context, id string, metadata *models.ImageMetadata) error {
-	_, err := fs.client.Collection(fs.collection).Doc(id).Set(ctx, metadata)
+// Retrieves image metadata matching filter, newest first, with the same pagination as
+// ListImageMetadata. A two-letter country matches countryCode, anything longer matches the country
+// name. Each combination of filters needs its own composite index (see README); a missing one is
+// reported as an IndexError naming the fields to index.
+func (fs *FirestoreService) ListImageMetadataFiltered(ctx context.Context, filter models.ImageFilter, limit int, page int) ([]*models.ImageMetadata, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("%w: limit cannot be negative", errors.ErrInvalidInput)
+	}
+	if page < 0 {
+		return nil, fmt.Errorf("%w: page cannot be negative", errors.ErrInvalidInput)
+	}
+	if filter.Country == "" && (filter.Region != "" || filter.City != "") {
+		return nil, fmt.Errorf("%w: region and city filters require a country", errors.ErrInvalidInput)
+	}
+
+	query := fs.client.Collection(fs.collection).Query
+	var fields []string
+	if filter.Favorite {
+		query = query.Where("favorite", "==", true)
+		fields = append(fields, "favorite ASC")
+	}
+	if filter.HasDescription {
+		query = query.Where("hasDescription", "==", true)
+		fields = append(fields, "hasDescription ASC")
+	}
+	if filter.PublicOnly {
+		query = query.Where("visibility", "==", models.VisibilityPublic)
+		fields = append(fields, "visibility ASC")
+	}
+	if len(filter.Country) == 2 {
+		query = query.Where("countryCode", "==", strings.ToUpper(filter.Country))
+		fields = append(fields, "countryCode ASC")
+	} else if filter.Country != "" {
+		query = query.Where("country", "==", filter.Country)
+		fields = append(fields, "country ASC")
+	}
+	if filter.Region != "" {
+		query = query.Where("region", "==", filter.Region)
+		fields = append(fields, "region ASC")
+	}
+	if filter.City != "" {
+		query = query.Where("city", "==", filter.City)
+		fields = append(fields, "city ASC")
+	}
+	query = query.OrderBy("takenAt", firestore.Desc)
+	fields = append(fields, "takenAt DESC")
+
+	if limit > 1000 {
+		limit = 1000
+	}
+
+	results, err := fs.visiblePage(ctx, query, limit, page)
 	if err != nil {
-		return fmt.Errorf("failed to update metadata: %w", err)
+		if isMissingIndex(err) {
+			err = &errors.IndexError{Fields: strings.Join(fields, ", "), Err: err}
+		}
+		return nil, fmt.Errorf("failed to query filtered images: %w", classifyError(err))
+	}
+
+	return results, nil
+}
+
+// Reports whether a query failed because its composite index doesn't exist. Firestore returns
+// FailedPrecondition with a message linking to the console to create it.
+func isMissingIndex(err error) bool {
+	s, ok := status.FromError(err)
+	return ok && s.Code() == codes.FailedPrecondition && strings.Contains(strings.ToLower(s.Message()), "index")
+}
+
+// Updates only the given fields of an existing image metadata document, leaving everything else
+// untouched, including fields ImageMetadata doesn't know about. Callers changing fields that
+// feed derived ones (fileName, or anything in models.Missing*) must update those too.
+// Returns ErrNotFound if the document doesn't exist.
+func (fs *FirestoreService) UpdateImageMetadataFields(ctx context.Context, id string, updates []firestore.Update) error {
+	return fs.updateFields(ctx, id, updates)
+}
+
+// Updates the given fields on an existing document, leaving the rest untouched.
+func (fs *FirestoreService) updateFields(ctx context.Context, id string, updates []firestore.Update) error {
+	return fs.updateFieldsAt(ctx, id, updates, time.Time{})
+}
+
+// Updates fields like updateFields, but only if the document's update time still equals revision
+// (a firestore.LastUpdateTime precondition). Returns ErrConflict when it has changed since.
+// A zero revision updates unconditionally.
+func (fs *FirestoreService) updateFieldsAt(ctx context.Context, id string, updates []firestore.Update, revision time.Time) error {
+	if id == "" {
+		return fmt.Errorf("%w: document ID cannot be empty", errors.ErrInvalidInput)
+	}
+
+	var preconds []firestore.Precondition
+	if !revision.IsZero() {
+		preconds = append(preconds, firestore.LastUpdateTime(revision))
+	}
+
+	if _, err := fs.client.Collection(fs.collection).Doc(id).Update(ctx, updates, preconds...); err != nil {
+		if len(preconds) > 0 && status.Code(err) == codes.FailedPrecondition {
+			return fmt.Errorf("%w: %s changed since %s", errors.ErrConflict, id, revision.Format(time.RFC3339Nano))
+		}
+		return fmt.Errorf("failed to update metadata fields: %w", classifyError(err))
 	}
 
 	return nil
 }
 
-// Deletes an image metadata document by ID.
+// Retrieves a location already resolved for another document in the same place, with its
+// hierarchy (empty parts for documents geocoded before the hierarchy was stored).
+// Returns ErrNotFound if no document with that PlaceKey has a location yet.
+func (fs *FirestoreService) GetGeoLocationByPlaceKey(ctx context.Context, placeKey string) (string, models.LocationParts, error) {
+	docs, err := fs.getAll(ctx, fs.client.Collection(fs.collection).Where("placeKey", "==", placeKey).Limit(10))
+	if err != nil {
+		return "", models.LocationParts{}, fmt.Errorf("failed to query place: %w", classifyError(err))
+	}
+
+	for _, doc := range docs {
+		var metadata models.ImageMetadata
+		if err := doc.DataTo(&metadata); err != nil || metadata.GeoLocation == "" {
+			continue
+		}
+		return metadata.GeoLocation, metadata.LocationParts(), nil
+	}
+
+	return "", models.LocationParts{}, errors.ErrNotFound
+}
+
+// Deletes an image metadata document by ID and removes it from any albums that reference it.
+// If the album cleanup fails the albums still render (dangling references are skipped on read),
+// and calling this again finishes it.
 func (fs *FirestoreService) DeleteImageMetadata(ctx context.Context, id string) error {
-	_, err := fs.client.Collection(fs.collection).Doc(id).Delete(ctx)
+	ref := fs.client.Collection(fs.collection).Doc(id)
+	err := withRetry(ctx, "delete "+id, func() error {
+		_, err := ref.Delete(ctx)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to delete metadata: %w", err)
+		return fmt.Errorf("failed to delete metadata: %w", classifyError(err))
+	}
+
+	if err := fs.removeImageFromAlbums(ctx, id); err != nil {
+		return fmt.Errorf("metadata deleted but album cleanup failed: %w", err)
 	}
 
 	return nil
 }
 
-// Gets image metadata by filename.
+// Gets image metadata by filename, whatever its status. Listings and image requests should use
+// GetVisibleImageMetadataByFilename instead.
 func (fs *FirestoreService) GetImageMetadataByFilename(ctx context.Context, filename string, fileType string) (*models.ImageMetadata, error) {
-	finalFilename := filename
-	if utils.IsHeifLike(fileType) {
-		ext := filepath.Ext(filename)
-		finalFilename = strings.TrimSuffix(filename, ext) + ".jpg"
+	return fs.getImageMetadataByFilename(ctx, filename, fileType, false, false)
+}
+
+// Gets image metadata by filename like GetImageMetadataByFilename, skipping quarantined and
+// soft-deleted documents (see ImageMetadata.Hidden), so a hidden document can't shadow a visible
+// one of the same name or be served itself.
+func (fs *FirestoreService) GetVisibleImageMetadataByFilename(ctx context.Context, filename string, fileType string) (*models.ImageMetadata, error) {
+	return fs.getImageMetadataByFilename(ctx, filename, fileType, false, true)
+}
+
+// Gets the image metadata whose file is stored under storagePath.
+// Returns ErrNotFound if no document points there.
+func (fs *FirestoreService) GetImageMetadataByStoragePath(ctx context.Context, storagePath string) (*models.ImageMetadata, error) {
+	return fs.firstImageMetadata(ctx, fs.client.Collection(fs.collection).Where("storagePath", "==", storagePath))
+}
+
+// Gets the image metadata synced from a Drive file, whatever its name.
+// Returns ErrNotFound if the file was never synced (or was synced before driveFileId was stored).
+func (fs *FirestoreService) GetImageMetadataByDriveFileID(ctx context.Context, driveFileID string) (*models.ImageMetadata, error) {
+	return fs.firstImageMetadata(ctx, fs.client.Collection(fs.collection).Where("driveFileId", "==", driveFileID))
+}
+
+// Gets image metadata by filename like GetVisibleImageMetadataByFilename, but only if the image is
+// public. The visibility condition is part of the query, so private documents are never read; they
+// are reported as ErrNotFound.
+func (fs *FirestoreService) GetPublicImageMetadataByFilename(ctx context.Context, filename string, fileType string) (*models.ImageMetadata, error) {
+	return fs.getImageMetadataByFilename(ctx, filename, fileType, true, true)
+}
+
+// Retrieves a public image's metadata by document ID, with the visibility condition in the query
+// like GetPublicImageMetadataByFilename. Legacy IDs are not resolved.
+func (fs *FirestoreService) GetPublicImageMetadata(ctx context.Context, id string) (*models.ImageMetadata, error) {
+	coll := fs.client.Collection(fs.collection)
+	return fs.firstImageMetadata(ctx, coll.Where(firestore.DocumentID, "==", coll.Doc(id)).
+		Where("visibility", "==", models.VisibilityPublic))
+}
+
+// Matches the name as requested, exactly and then case-insensitively through fileNameLower (so
+// IMG_001.JPG finds img_001.jpg), then for HEIC/HEIF names the converted .jpg name the same way.
+// A file whose conversion failed is stored under its original name, which is why that comes
+// first. Documents synced before fileNameLower existed only match exactly until backfilled
+// (update-metadata -file-name-lower). With visibleOnly, hidden documents are passed over.
+func (fs *FirestoreService) getImageMetadataByFilename(ctx context.Context, filename string, fileType string, publicOnly, visibleOnly bool) (*models.ImageMetadata, error) {
+	for _, name := range fileNameCandidates(filename, fileType) {
+		for _, match := range []struct{ field, value string }{
+			{"fileName", name},
+			{"fileNameLower", strings.ToLower(name)},
+		} {
+			query := fs.client.Collection(fs.collection).Where(match.field, "==", match.value)
+			if publicOnly {
+				query = query.Where("visibility", "==", models.VisibilityPublic)
+			}
+			var metadata *models.ImageMetadata
+			var err error
+			if visibleOnly {
+				metadata, err = fs.firstVisibleImageMetadata(ctx, query)
+			} else {
+				metadata, err = fs.firstImageMetadata(ctx, query)
+			}
+			if err != errors.ErrNotFound {
+				return metadata, err
+			}
+		}
 	}
-	iter := fs.client.Collection(fs.collection).Where("fileName", "==", finalFilename).Limit(1).Documents(ctx)
-	defer iter.Stop()
+	return nil, errors.ErrNotFound
+}
 
-	doc, err := iter.Next()
+// Reads the documents matching query and returns the first that isn't hidden, or ErrNotFound.
+// Only for queries matching a handful of documents, such as one file name.
+func (fs *FirestoreService) firstVisibleImageMetadata(ctx context.Context, query firestore.Query) (*models.ImageMetadata, error) {
+	docs, err := fs.getAll(ctx, query)
 	if err != nil {
-		// Check if no documents found
-		if err == iterator.Done {
-			return nil, errors.ErrNotFound
+		return nil, fmt.Errorf("failed to query documents: %w", classifyError(err))
+	}
+	for _, doc := range docs {
+		var metadata models.ImageMetadata
+		if err := doc.DataTo(&metadata); err != nil {
+			return nil, fmt.Errorf("failed to parse metadata: %w", err)
+		}
+		if metadata.Hidden() {
+			continue
 		}
-		return nil, fmt.Errorf("failed to query documents: %w", err)
+		metadata.Id = doc.Ref.ID
+		metadata.Revision = doc.UpdateTime
+		return &metadata, nil
 	}
+	return nil, errors.ErrNotFound
+}
+
+// Reads the first document matching query as image metadata, or ErrNotFound if there is none.
+func (fs *FirestoreService) firstImageMetadata(ctx context.Context, query firestore.Query) (*models.ImageMetadata, error) {
+	docs, err := fs.getAll(ctx, query.Limit(1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", classifyError(err))
+	}
+	if len(docs) == 0 {
+		return nil, errors.ErrNotFound
+	}
+	doc := docs[0]
 
 	var metadata models.ImageMetadata
 	if err := doc.DataTo(&metadata); err != nil {
 		return nil, fmt.Errorf("failed to parse metadata: %w", err)
 	}
+	metadata.Id = doc.Ref.ID
+	metadata.Revision = doc.UpdateTime
 
 	return &metadata, nil
 }
+
+// Firestore's limit on values in a single "in" filter.
+const maxInQueryValues = 30
+
+// Gets image metadata for several filenames with as few "in" queries as possible.
+// Filenames match like GetVisibleImageMetadataByFilename: as requested, then case-insensitively,
+// then HEIC/HEIF names by their converted .jpg, and hidden documents are passed over.
+// Returns a map keyed by the requested filename; names with no document are absent.
+func (fs *FirestoreService) GetImageMetadataByFilenames(ctx context.Context, filenames []string) (map[string]*models.ImageMetadata, error) {
+	converted := func(name string) string { return storedFileName(name, "") }
+	results := make(map[string]*models.ImageMetadata, len(filenames))
+	for _, pass := range []struct {
+		field string
+		keyOf func(string) string
+		heic  bool // Only names with a converted name, which the pass looks up
+	}{
+		{"fileName", func(name string) string { return name }, false},
+		{"fileNameLower", strings.ToLower, false},
+		{"fileName", converted, true},
+		{"fileNameLower", func(name string) string { return strings.ToLower(converted(name)) }, true},
+	} {
+		var misses []string
+		for _, name := range filenames {
+			if _, ok := results[name]; !ok && (!pass.heic || converted(name) != name) {
+				misses = append(misses, name)
+			}
+		}
+		if err := fs.getImageMetadataByFieldIn(ctx, pass.field, misses, pass.keyOf, results); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// Looks up each name's key (as given by keyOf) in field with "in" queries of up to
+// maxInQueryValues keys, adding matches to results under the requested name.
+func (fs *FirestoreService) getImageMetadataByFieldIn(ctx context.Context, field string, names []string, keyOf func(string) string, results map[string]*models.ImageMetadata) error {
+	requested := make(map[string][]string) // Key -> requested names
+	var keys []string
+	for _, name := range names {
+		key := keyOf(name)
+		if _, ok := requested[key]; !ok {
+			keys = append(keys, key)
+		}
+		requested[key] = append(requested[key], name)
+	}
+
+	for start := 0; start < len(keys); start += maxInQueryValues {
+		chunk := keys[start:min(start+maxInQueryValues, len(keys))]
+
+		docs, err := fs.getAll(ctx, fs.client.Collection(fs.collection).Where(field, "in", chunk))
+		if err != nil {
+			return fmt.Errorf("failed to query documents: %w", classifyError(err))
+		}
+
+		for _, doc := range docs {
+			var metadata models.ImageMetadata
+			if err := doc.DataTo(&metadata); err != nil {
+				// Log but don't fail on individual document parse errors
+				continue
+			}
+			if metadata.Hidden() {
+				continue
+			}
+			metadata.Id = doc.Ref.ID
+			metadata.Revision = doc.UpdateTime
+
+			key := metadata.FileName
+			if field == "fileNameLower" {
+				key = metadata.FileNameLower
+			}
+			for _, name := range requested[key] {
+				if _, ok := results[name]; !ok {
+					results[name] = &metadata
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the names a requested file may be stored under, in the order to try them: the name
+// itself, then for HEIC/HEIF files the converted name (see storedFileName).
+func fileNameCandidates(filename string, fileType string) []string {
+	if converted := storedFileName(filename, fileType); converted != filename {
+		return []string{filename, converted}
+	}
+	return []string{filename}
+}
+
+// Maps a requested filename to the name it is stored under once converted: HEIC/HEIF files are
+// stored as .jpg (unless conversion failed). Either fileType (a MIME type or extension, may be
+// empty) or the name's own extension marks a file as HEIC-like.
+func storedFileName(filename string, fileType string) string {
+	if utils.IsHeifLike(fileType) || utils.IsHeifLike(filepath.Ext(filename)) {
+		ext := filepath.Ext(filename)
+		return strings.TrimSuffix(filename, ext) + ".jpg"
+	}
+	return filename
+}
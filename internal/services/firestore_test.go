@@ -0,0 +1,306 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+	"trekka-api/internal/utils"
+)
+
+func TestDeterministicDocumentID(t *testing.T) {
+	hash := utils.ContentHash([]byte("photo bytes"))
+	nameID := DeterministicDocumentID("", "", "img_1.jpg")
+	if len(nameID) != 64 || nameID == hash {
+		t.Fatalf("ID for a file name = %q, want a SHA-256 hex digest of its own", nameID)
+	}
+
+	tests := []struct {
+		name        string
+		driveFileID string
+		contentHash string
+		fileName    string
+		want        string
+	}{
+		{"drive file ID wins", "drive-1", hash, "IMG_1.jpg", "drive-1"},
+		{"content hash over name", "", hash, "IMG_1.jpg", hash},
+		{"hash in upper case", "", strings.ToUpper(hash), "", hash},
+		{"file name", "", "", "img_1.jpg", nameID},
+		{"file name case", "", "", "IMG_1.JPG", nameID},
+		{"storage path prefix", "", "", "2024/05/IMG_1.jpg", nameID},
+		{"other month prefix", "", "", "2019/11/IMG_1.jpg", nameID},
+		{"surrounding space", "", "", " IMG_1.jpg ", nameID},
+		{"HEIC stored as jpg", "", "", "IMG_1.HEIC", nameID},
+		{"nothing known", "", "", "", ""},
+		{"blank name", "", "", "  ", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DeterministicDocumentID(tt.driveFileID, tt.contentHash, tt.fileName); got != tt.want {
+				t.Errorf("DeterministicDocumentID(%q, %q, %q) = %q, want %q", tt.driveFileID, tt.contentHash, tt.fileName, got, tt.want)
+			}
+		})
+	}
+
+	if DeterministicDocumentID("", "", "IMG_2.jpg") == nameID {
+		t.Error("different file names got the same ID")
+	}
+}
+
+func TestPersistingSameContentKeepsOneDocument(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+	hash := utils.ContentHash([]byte("photo bytes"))
+
+	// Media without a Drive file: an orphan object reconcile adopts, then the same bytes uploaded
+	// again under another name and month prefix
+	for _, storagePath := range []string{"2024/05/IMG_1.jpg", "2024/06/holiday.jpg"} {
+		extracted := &models.ImageMetadata{
+			FileName:    path.Base(storagePath),
+			ContentType: "image/jpeg",
+			StoragePath: storagePath,
+			ContentHash: hash,
+		}
+		if _, err := persistExtracted(ctx, fs, extracted, "", nil, nil); err != nil {
+			t.Fatalf("persist %s: %v", storagePath, err)
+		}
+	}
+
+	if n := countImages(t, fs); n != 1 {
+		t.Fatalf("%d documents after persisting the same bytes under two paths, want 1", n)
+	}
+	got, err := fs.GetImageMetadata(ctx, hash)
+	if err != nil {
+		t.Fatalf("GetImageMetadata(content hash): %v", err)
+	}
+	if got.StoragePath != "2024/05/IMG_1.jpg" {
+		t.Errorf("storage path = %q, want the first copy's, which a re-sync leaves in place", got.StoragePath)
+	}
+}
+
+func TestSecondCreateIsRejected(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+
+	id, err := fs.CreateImageMetadata(ctx, &models.ImageMetadata{FileName: "IMG_1.jpg", DriveFileID: "drive-1"})
+	if err != nil {
+		t.Fatalf("first create: %v", err)
+	}
+	if id != "drive-1" {
+		t.Fatalf("created %q, want the Drive file ID", id)
+	}
+	description := "Tram 28"
+	if err := fs.ApplyMetadataPatch(ctx, id, models.ImageMetadataPatch{Description: &description}); err != nil {
+		t.Fatalf("patch: %v", err)
+	}
+
+	again, err := fs.CreateImageMetadata(ctx, &models.ImageMetadata{FileName: "IMG_1.jpg", DriveFileID: "drive-1"})
+	if !errors.Is(err, apperrors.ErrConflict) {
+		t.Fatalf("second create: err = %v, want ErrConflict", err)
+	}
+	if again != id {
+		t.Errorf("second create returned %q, want the taken ID %q to fall back to", again, id)
+	}
+	stored, err := fs.GetImageMetadata(ctx, id)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if stored.Description != description {
+		t.Errorf("description = %q after a rejected create, want %q", stored.Description, description)
+	}
+}
+
+func TestCreateWithoutHashKeysByFileName(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+
+	id, err := fs.CreateImageMetadata(ctx, &models.ImageMetadata{FileName: "IMG_3.jpg", StoragePath: "2019/01/IMG_3.jpg"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if want := DeterministicDocumentID("", "", "IMG_3.jpg"); id != want {
+		t.Errorf("created %q, want the file name's ID %q", id, want)
+	}
+
+	// The same photo stored under the later YYYY/MM layout
+	_, err = fs.CreateImageMetadata(ctx, &models.ImageMetadata{FileName: "img_3.jpg", StoragePath: "2024/05/img_3.jpg"})
+	if !errors.Is(err, apperrors.ErrConflict) {
+		t.Errorf("create under another prefix: err = %v, want ErrConflict", err)
+	}
+
+	byID, err := fs.GetImageMetadata(ctx, id)
+	if err != nil {
+		t.Fatalf("GetImageMetadata: %v", err)
+	}
+	byName, err := fs.GetImageMetadataByFilename(ctx, "IMG_3.jpg", "jpg")
+	if err != nil {
+		t.Fatalf("GetImageMetadataByFilename: %v", err)
+	}
+	if byID.Id != id || byName.Id != id {
+		t.Errorf("lookups found %q by ID and %q by name, want %q", byID.Id, byName.Id, id)
+	}
+}
+
+func TestResyncKeepsFieldsSetByHand(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+	extracted := func() *models.ImageMetadata {
+		return &models.ImageMetadata{FileName: "IMG_1.jpg", ContentType: "image/jpeg", ContentHash: utils.ContentHash([]byte("photo bytes"))}
+	}
+
+	first, err := persistExtracted(ctx, fs, extracted(), "", nil, nil)
+	if err != nil {
+		t.Fatalf("first persist: %v", err)
+	}
+	description, visibility, favorite := "Tram 28", models.VisibilityPublic, true
+	if err := fs.ApplyMetadataPatch(ctx, first.Id, models.ImageMetadataPatch{Description: &description, Visibility: &visibility}); err != nil {
+		t.Fatalf("patch: %v", err)
+	}
+	if _, err := fs.SetFavorite(ctx, first.Id, &favorite); err != nil {
+		t.Fatalf("favorite: %v", err)
+	}
+
+	// A sync that didn't find the document first, e.g. an interrupted backfill rerun
+	second, err := persistExtracted(ctx, fs, extracted(), "", nil, nil)
+	if err != nil {
+		t.Fatalf("second persist: %v", err)
+	}
+	if second.Id != first.Id {
+		t.Fatalf("second persist wrote %q, want the existing %q", second.Id, first.Id)
+	}
+	stored, err := fs.GetImageMetadata(ctx, first.Id)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if stored.Description != description || stored.Visibility != visibility || !stored.Favorite {
+		t.Errorf("after a re-sync description = %q, visibility = %q, favorite = %t; want the values set by hand",
+			stored.Description, stored.Visibility, stored.Favorite)
+	}
+}
+
+// Seeds n images taken an hour apart, newest first by index, quarantining every third. Returns the
+// visible IDs newest first.
+func seedWithQuarantined(t *testing.T, fs *FirestoreService, n int) []string {
+	t.Helper()
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	var visible []string
+	for i := range n {
+		id := fmt.Sprintf("img-%03d", i)
+		metadata := &models.ImageMetadata{
+			FileName: id + ".jpg",
+			TakenAt:  base.Add(-time.Duration(i) * time.Hour),
+			Favorite: true,
+		}
+		if i%3 == 1 {
+			metadata.Status = models.StatusQuarantined
+		} else {
+			visible = append(visible, id)
+		}
+		seedImage(t, fs, id, metadata)
+	}
+	return visible
+}
+
+// Returns the IDs of images, in order.
+func imageIDs(images []*models.ImageMetadata) []string {
+	ids := make([]string, len(images))
+	for i, image := range images {
+		ids[i] = image.Id
+	}
+	return ids
+}
+
+func TestListingPagesOverVisibleImages(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+
+	// 150 documents, 100 visible: enough to cross visiblePage's read chunks
+	visible := seedWithQuarantined(t, fs, 150)
+
+	listings := map[string]func(limit, page int) ([]*models.ImageMetadata, error){
+		"ListImageMetadata": func(limit, page int) ([]*models.ImageMetadata, error) {
+			return fs.ListImageMetadata(ctx, limit, page)
+		},
+		"ListImageMetadataFiltered": func(limit, page int) ([]*models.ImageMetadata, error) {
+			return fs.ListImageMetadataFiltered(ctx, models.ImageFilter{Favorite: true}, limit, page)
+		},
+	}
+	for name, list := range listings {
+		t.Run(name, func(t *testing.T) {
+			for page, want := range [][]string{visible[0:30], visible[30:60], visible[60:90], visible[90:100], nil} {
+				got, err := list(30, page)
+				if err != nil {
+					t.Fatalf("page %d: %v", page, err)
+				}
+				if !reflect.DeepEqual(imageIDs(got), want) && (len(got) != 0 || len(want) != 0) {
+					t.Errorf("page %d = %v, want %v", page, imageIDs(got), want)
+				}
+			}
+
+			all, err := list(0, 0)
+			if err != nil {
+				t.Fatalf("limit 0: %v", err)
+			}
+			if !reflect.DeepEqual(imageIDs(all), visible) {
+				t.Errorf("limit 0 returned %d images, want the %d visible ones in order", len(all), len(visible))
+			}
+		})
+	}
+}
+
+func TestFileNameLookupsMatchTheSameDocuments(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+	for id, md := range map[string]*models.ImageMetadata{
+		"heic-original":  {FileName: "IMG_2.HEIC", FileNameLower: "img_2.heic"},
+		"heic-converted": {FileName: "IMG_3.jpg", FileNameLower: "img_3.jpg"},
+		"both-original":  {FileName: "IMG_4.HEIC", FileNameLower: "img_4.heic"},
+		"both-converted": {FileName: "IMG_4.jpg", FileNameLower: "img_4.jpg"},
+		"quarantined":    {FileName: "IMG_5.jpg", FileNameLower: "img_5.jpg", Status: models.StatusQuarantined},
+	} {
+		seedImage(t, fs, id, md)
+	}
+
+	names := []string{"IMG_2.HEIC", "img_2.heic", "IMG_3.HEIC", "img_3.heif", "IMG_4.HEIC", "IMG_4.jpg", "IMG_5.jpg", "IMG_9.HEIC"}
+	want := map[string]string{
+		"IMG_2.HEIC": "heic-original",
+		"img_2.heic": "heic-original",
+		"IMG_3.HEIC": "heic-converted",
+		"img_3.heif": "heic-converted",
+		"IMG_4.HEIC": "both-original",
+		"IMG_4.jpg":  "both-converted",
+	}
+
+	batch, err := fs.GetImageMetadataByFilenames(ctx, names)
+	if err != nil {
+		t.Fatalf("GetImageMetadataByFilenames: %v", err)
+	}
+	for _, name := range names {
+		single, err := fs.GetVisibleImageMetadataByFilename(ctx, name, "")
+		switch {
+		case want[name] == "":
+			if !errors.Is(err, apperrors.ErrNotFound) {
+				t.Errorf("GetVisibleImageMetadataByFilename(%s) = %v, want ErrNotFound", name, err)
+			}
+			if found, ok := batch[name]; ok {
+				t.Errorf("batch found %s for %s, want nothing", found.Id, name)
+			}
+		case err != nil || single.Id != want[name]:
+			t.Errorf("GetVisibleImageMetadataByFilename(%s) = %v, %v; want %s", name, single, err, want[name])
+		case batch[name] == nil || batch[name].Id != want[name]:
+			t.Errorf("batch found %v for %s, want %s", batch[name], name, want[name])
+		}
+	}
+
+	// Sync sees hidden documents, so it doesn't duplicate them
+	if found, err := fs.GetImageMetadataByFilename(ctx, "IMG_5.jpg", "jpg"); err != nil || found.Id != "quarantined" {
+		t.Errorf("GetImageMetadataByFilename(IMG_5.jpg) = %v, %v; want the quarantined document", found, err)
+	}
+}
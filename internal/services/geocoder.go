@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+	"trekka-api/internal/utils"
+)
+
+// Names of the geocoding providers GEOCODERS lists.
+const (
+	GeocoderNominatim  = "nominatim"
+	GeocoderGoogleMaps = "google"
+)
+
+// Geocoder turns coordinates into a place. An empty location with a nil error means the provider
+// knows nothing there; invalid coordinates are ErrInvalidInput and an unreachable provider
+// ErrUnavailable. GeocodingService implements it with caching in front of a provider, so
+// extraction and sync depend on this and tests can pass a fake.
+type Geocoder interface {
+	ReverseGeocode(ctx context.Context, coordinates models.Coordinates) (models.LocationParts, error)
+}
+
+// Tries providers in order until one finds a place, e.g. Nominatim, then Google Maps for remote
+// locations Nominatim has nothing for.
+type ChainGeocoder struct {
+	providers []Geocoder
+}
+
+// Returns a geocoder trying providers in order; with a single provider, that provider.
+func NewChainGeocoder(providers ...Geocoder) Geocoder {
+	if len(providers) == 1 {
+		return providers[0]
+	}
+	return &ChainGeocoder{providers: providers}
+}
+
+// Returns the first non-empty location a provider finds. A failing provider is logged and the
+// next one tried; if none finds a place, the result is empty when any provider answered, or the
+// last error when all failed. Invalid coordinates and a cancelled context end the chain early.
+func (c *ChainGeocoder) ReverseGeocode(ctx context.Context, coordinates models.Coordinates) (models.LocationParts, error) {
+	var lastErr error
+	answered := false
+	for i, provider := range c.providers {
+		parts, err := provider.ReverseGeocode(ctx, coordinates)
+		switch {
+		case err != nil && (errors.Is(err, apperrors.ErrInvalidInput) || ctx.Err() != nil):
+			return models.LocationParts{}, err
+		case err != nil:
+			log.Printf("[Geocode] Provider %d of %d failed, trying the next: %v", i+1, len(c.providers), err)
+			lastErr = err
+		case FormatLocation(parts) != "":
+			return parts, nil
+		default:
+			answered = true
+		}
+	}
+	if answered || lastErr == nil {
+		return models.LocationParts{}, nil
+	}
+	return models.LocationParts{}, lastErr
+}
+
+// Builds the geocoder for a GEOCODERS list (names tried in order), e.g. "nominatim,google".
+// Google Maps needs googleAPIKey. Unknown names and a missing key are ErrInvalidInput.
+func GeocoderFor(names []string, googleAPIKey string) (Geocoder, error) {
+	var providers []Geocoder
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+			continue
+		case GeocoderNominatim:
+			providers = append(providers, NewNominatimGeocoder())
+		case GeocoderGoogleMaps:
+			if googleAPIKey == "" {
+				return nil, fmt.Errorf("%w: geocoder %q needs GOOGLE_MAPS_API_KEY", apperrors.ErrInvalidInput, name)
+			}
+			providers = append(providers, NewGoogleMapsGeocoder(googleAPIKey))
+		default:
+			return nil, fmt.Errorf("%w: unknown geocoder %q", apperrors.ErrInvalidInput, name)
+		}
+	}
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("%w: no geocoder configured", apperrors.ErrInvalidInput)
+	}
+	return NewChainGeocoder(providers...), nil
+}
+
+// Returns the PlaceKey for coordinates on geocoder's grid when it has one (GeocodingService
+// does), otherwise on the default grid.
+func placeKeyFor(geocoder Geocoder, coordinates models.Coordinates) string {
+	if g, ok := geocoder.(interface {
+		PlaceKey(models.Coordinates) string
+	}); ok {
+		return g.PlaceKey(coordinates)
+	}
+	return utils.PlaceKey(coordinates, DefaultPlaceGridMeters)
+}
@@ -2,173 +2,268 @@ package services
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"log"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
-	"trekka-api/internal/models"
 
-	"golang.org/x/time/rate"
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+	"trekka-api/internal/utils"
 )
 
-// Performs reverse geocoding using the OpenStreetMap Nominatim
-// API with caching and rate limiting.
+// Performs reverse geocoding with caching in front of a Geocoder provider, the OpenStreetMap
+// Nominatim API unless SetProvider changes it.
 type GeocodingService struct {
-	cache       map[string]string
-	cacheMutex  sync.RWMutex
-	httpClient  *http.Client
-	rateLimiter *rate.Limiter
+	cache      map[string]models.LocationParts
+	cacheMutex sync.RWMutex
+	provider   Geocoder
+	gridMeters int
+	store      *firestore.Client // Persistent cache behind the in-memory one; nil without
+	maxAge     time.Duration     // Age after which persisted results are looked up again (0: never)
 }
 
-// Models the subset of Nominatim’s response that we care about
-// (city/town/village + country).
-type NominatimResponse struct {
-	Address struct {
-		City    string `json:"city"`
-		Town    string `json:"town"`
-		Village string `json:"village"`
-		Country string `json:"country"`
-	} `json:"address"`
-}
+const geocodeCacheCollection = "geocodeCache"
+
+// Default PlaceKey grid size; photos within roughly this distance share a place.
+const DefaultPlaceGridMeters = 100
 
 // Returns a fully configured geocoder.
 // It includes:
 //   - in-memory cache
-//   - shared HTTP client
-//   - Nominatim-compliant rate limiting (1 request/sec)
+//   - Nominatim as the provider, with its rate limiting (1 request/sec)
 func NewGeocodingService() *GeocodingService {
 	return &GeocodingService{
-		cache:      make(map[string]string),
-		httpClient: &http.Client{Timeout: 10 * time.Second},
-		rateLimiter: rate.NewLimiter(
-			rate.Limit(1), // 1 request/sec
-			1,             // burst size
-		),
+		cache:      make(map[string]models.LocationParts),
+		provider:   NewNominatimGeocoder(),
+		gridMeters: DefaultPlaceGridMeters,
 	}
 }
 
-// Performs a coordinate→location lookup.
+// Returns a geocoder that also persists results in the geocodeCache collection, checked on an
+// in-memory miss before calling Nominatim, so lookups survive restarts and cold starts.
+func NewGeocodingServiceWithCache(fs *FirestoreService) *GeocodingService {
+	g := NewGeocodingService()
+	g.store = fs.client
+	return g
+}
+
+// Sets the provider looked up on a cache miss, e.g. a ChainGeocoder from GeocoderFor.
+func (g *GeocodingService) SetProvider(provider Geocoder) {
+	g.provider = provider
+}
+
+// Sets how old a persisted result may be before it is looked up again. Zero keeps them forever.
+func (g *GeocodingService) SetCacheMaxAge(maxAge time.Duration) {
+	g.maxAge = maxAge
+}
+
+// Sets the PlaceKey grid size in metres. Non-positive values are ignored.
+func (g *GeocodingService) SetPlaceGridMeters(meters int) {
+	if meters > 0 {
+		g.gridMeters = meters
+	}
+}
+
+// Returns the PlaceKey for coordinates using this geocoder's grid.
+func (g *GeocodingService) PlaceKey(coordinates models.Coordinates) string {
+	return utils.PlaceKey(coordinates, g.gridMeters)
+}
+
+// Performs a cached coordinate→location lookup, implementing Geocoder.
+func (g *GeocodingService) ReverseGeocode(ctx context.Context, coordinates models.Coordinates) (models.LocationParts, error) {
+	return g.ReverseGeocodeParts(ctx, coordinates, false)
+}
+
+// Performs a coordinate→location lookup, returning the location hierarchy (city, region, country).
 // The function:
 //  1. normalizes coordinates
-//  2. checks the in-memory cache
-//  3. applies rate limiting (required by Nominatim)
-//  4. calls the Nominatim API
-//  5. extracts city/town/village + country
-//  6. caches & returns the formatted result
-func (g *GeocodingService) ReverseGeocode(ctx context.Context, coordinates models.Coordinates) (string, error) {
-	lat, lng, key, err := g.normalizeCoordinates(coordinates)
+//  2. checks the in-memory cache (keyed by PlaceKey, so nearby photos share a lookup),
+//     then the persistent one when configured
+//  3. calls the provider (which applies its own rate limiting)
+//  4. caches & returns the result
+//
+// force skips the cache lookups (step 2) and replaces the cached results, for refreshing a
+// location after the map data has improved.
+func (g *GeocodingService) ReverseGeocodeParts(ctx context.Context, coordinates models.Coordinates, force bool) (models.LocationParts, error) {
+	key, err := g.cacheKey(coordinates)
 	if err != nil {
-		return "", err
+		return models.LocationParts{}, err
 	}
 
 	// First check: read lock
-	g.cacheMutex.RLock()
-	if cached := g.cache[key]; cached != "" {
+	if !force {
+		g.cacheMutex.RLock()
+		if cached, ok := g.cache[key]; ok {
+			g.cacheMutex.RUnlock()
+			return cached, nil
+		}
 		g.cacheMutex.RUnlock()
-		return cached, nil
-	}
-	g.cacheMutex.RUnlock()
 
-	// Rate limit before making API call
-	if err := g.rateLimiter.Wait(ctx); err != nil {
-		return "", err
+		if entry, ok := g.loadStored(ctx, key); ok {
+			cached := entry.LocationParts()
+			g.cacheMutex.Lock()
+			g.cache[key] = cached
+			g.cacheMutex.Unlock()
+			return cached, nil
+		}
 	}
 
-	// Fetch from API
-	result, err := g.fetchLocation(ctx, lat, lng)
+	// Fetch from the provider
+	result, err := g.provider.ReverseGeocode(ctx, coordinates)
 	if err != nil {
-		return "", err
+		return models.LocationParts{}, err
 	}
 
-	// Double-check cache before writing (another goroutine might have set it)
+	// Double-check cache before writing (another goroutine might have set it);
+	// a forced lookup replaces whatever is there
 	g.cacheMutex.Lock()
-	if cached := g.cache[key]; cached != "" {
+	if cached, ok := g.cache[key]; ok && !force {
 		g.cacheMutex.Unlock()
 		return cached, nil
 	}
-	g.cache[key] = result
+	if FormatLocation(result) != "" {
+		g.cache[key] = result
+	}
 	g.cacheMutex.Unlock()
 
+	if FormatLocation(result) != "" {
+		g.saveStored(ctx, key, result)
+	}
+
 	return result, nil
 }
 
-// Parses and normalizes latitude/longitude values,
-// and returns a rounded cache key.
-func (g *GeocodingService) normalizeCoordinates(c models.Coordinates) (lat, lng float64, key string, err error) {
-	lat, err = strconv.ParseFloat(strings.TrimSpace(c.Lat), 64)
+// Resolves coordinates with the levels behind City and Region (Town, Village, State, County), for
+// documents geocoded before those were kept. A persisted result stored since then is used as is;
+// an older one, or none, is looked up again and replaces it, so each place costs at most one
+// geocoder call. Reports whether the geocoder was called.
+func (g *GeocodingService) DetailedLocation(ctx context.Context, coordinates models.Coordinates) (models.LocationParts, bool, error) {
+	key, err := g.cacheKey(coordinates)
 	if err != nil {
-		return 0, 0, "", fmt.Errorf("invalid latitude: %w", err)
+		return models.LocationParts{}, false, err
 	}
-	lng, err = strconv.ParseFloat(strings.TrimSpace(c.Lng), 64)
-	if err != nil {
-		return 0, 0, "", fmt.Errorf("invalid longitude: %w", err)
+	if entry, ok := g.loadStored(ctx, key); ok && entry.Version >= models.GeocodeCacheVersion {
+		return entry.LocationParts(), false, nil
 	}
 
-	// Key rounded to avoid cache fragmentation
-	key = fmt.Sprintf("%.4f,%.4f", lat, lng)
-	return lat, lng, key, nil
+	parts, err := g.ReverseGeocodeParts(ctx, coordinates, true)
+	return parts, true, err
 }
 
-// Performs the actual HTTP request and parses the response.
-func (g *GeocodingService) fetchLocation(ctx context.Context, lat, lng float64) (string, error) {
-	url := fmt.Sprintf(
-		"https://nominatim.openstreetmap.org/reverse?format=json&lat=%f&lon=%f&zoom=18&addressdetails=1",
-		lat, lng,
-	)
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// Returns the key results for coordinates are cached under: their PlaceKey, or the rounded
+// coordinates without a grid.
+func (g *GeocodingService) cacheKey(coordinates models.Coordinates) (string, error) {
+	key, err := g.normalizeCoordinates(coordinates)
 	if err != nil {
 		return "", err
 	}
+	if placeKey := g.PlaceKey(coordinates); placeKey != "" {
+		key = placeKey
+	}
+	return key, nil
+}
 
-	req.Header.Set("User-Agent", "Trekka")
-	req.Header.Set("Accept-Language", "en")
-	req.Header.Set("Referer", "https://trekka.co.uk")
+// Reads a persisted result that is still within the max age. Failures are logged and count as
+// a miss, so an unavailable cache only costs a geocoder call.
+func (g *GeocodingService) loadStored(ctx context.Context, key string) (*models.GeocodeCacheEntry, bool) {
+	if g.store == nil {
+		return nil, false
+	}
 
-	resp, err := g.httpClient.Do(req)
+	doc, err := g.store.Collection(geocodeCacheCollection).Doc(key).Get(ctx)
 	if err != nil {
-		return "", err
+		if status.Code(err) != codes.NotFound {
+			log.Printf("[Geocode] Failed to read cached location %s: %v", key, err)
+		}
+		return nil, false
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("nominatim returned status %d", resp.StatusCode)
+	var entry models.GeocodeCacheEntry
+	if err := doc.DataTo(&entry); err != nil {
+		log.Printf("[Geocode] Failed to parse cached location %s: %v", key, err)
+		return nil, false
+	}
+	if g.maxAge > 0 && time.Since(entry.FetchedAt) > g.maxAge {
+		return nil, false
 	}
+	return &entry, true
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+// Persists a fresh result. Failures are logged; the in-memory cache still has it.
+func (g *GeocodingService) saveStored(ctx context.Context, key string, parts models.LocationParts) {
+	if g.store == nil {
+		return
 	}
 
-	var data NominatimResponse
-	if err := json.Unmarshal(body, &data); err != nil {
+	entry := models.GeocodeCacheEntry{
+		City:        parts.City,
+		Region:      parts.Region,
+		Country:     parts.Country,
+		CountryCode: parts.CountryCode,
+		Town:        parts.Town,
+		Village:     parts.Village,
+		State:       parts.State,
+		County:      parts.County,
+		Version:     models.GeocodeCacheVersion,
+		FetchedAt:   time.Now(),
+	}
+	if _, err := g.store.Collection(geocodeCacheCollection).Doc(key).Set(ctx, &entry); err != nil {
+		log.Printf("[Geocode] Failed to cache location %s: %v", key, err)
+	}
+}
+
+// Returns the rounded cache key of valid coordinates.
+func (g *GeocodingService) normalizeCoordinates(c models.Coordinates) (string, error) {
+	lat, lng, err := parseCoordinates(c)
+	if err != nil {
 		return "", err
 	}
 
-	return g.extractLocation(data), nil
+	// Key rounded to avoid cache fragmentation
+	return fmt.Sprintf("%.4f,%.4f", lat, lng), nil
 }
 
-// Chooses the most specific available location from the response.
-func (g *GeocodingService) extractLocation(n NominatimResponse) string {
-	city := firstNonEmpty(
-		n.Address.City,
-		n.Address.Town,
-		n.Address.Village,
-	)
-	country := n.Address.Country
+// Parses latitude/longitude values, returning ErrInvalidInput for malformed ones.
+func parseCoordinates(c models.Coordinates) (lat, lng float64, err error) {
+	lat, err = strconv.ParseFloat(strings.TrimSpace(c.Lat), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: invalid latitude: %w", apperrors.ErrInvalidInput, err)
+	}
+	lng, err = strconv.ParseFloat(strings.TrimSpace(c.Lng), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: invalid longitude: %w", apperrors.ErrInvalidInput, err)
+	}
+	return lat, lng, nil
+}
 
+// Formats a location for display as "City, Country", or whichever of the two is known.
+func FormatLocation(parts models.LocationParts) string {
 	switch {
-	case city != "" && country != "":
-		return city + ", " + country
-	case city != "":
-		return city
+	case parts.City != "" && parts.Country != "":
+		return parts.City + ", " + parts.Country
+	case parts.City != "":
+		return parts.City
 	default:
-		return country
+		return parts.Country
+	}
+}
+
+// Splits a stored "City, Country" GeoLocation back into parts. Only the two-part form is
+// unambiguous; a single name could be either a city or a country, so ok is false for it.
+func SplitGeoLocation(location string) (models.LocationParts, bool) {
+	city, country, found := strings.Cut(location, ", ")
+	city, country = strings.TrimSpace(city), strings.TrimSpace(country)
+	if !found || city == "" || country == "" || strings.Contains(country, ",") {
+		return models.LocationParts{}, false
 	}
+	return models.LocationParts{City: city, Country: country}, true
 }
 
 // Returns the first non-empty string in the list.
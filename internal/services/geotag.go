@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+	"trekka-api/internal/utils"
+)
+
+// Value recorded in coordinatesSource for positions interpolated from a GPX track.
+const CoordinatesSourceGPX = "gpx"
+
+// Geotags photos without GPS from tracks recorded by a separate GPS logger.
+type GeotagService struct {
+	firestore *FirestoreService
+	geocoder  *GeocodingService
+}
+
+// Creates a geotagger that writes to the given store and geocodes with geocoder.
+func NewGeotagService(fs *FirestoreService, geocoder *GeocodingService) *GeotagService {
+	return &GeotagService{
+		firestore: fs,
+		geocoder:  geocoder,
+	}
+}
+
+// Options for GeotagFromTrack.
+type GeotagOptions struct {
+	Offset time.Duration // Added to each photo's takenAt to get GPS time (negative if the camera clock runs fast)
+	MaxGap time.Duration // Longest gap between trackpoints to interpolate across; 0 for no limit
+	DryRun bool          // Report matches without writing them
+}
+
+// Finds photos taken during the track that have no coordinates, interpolates a position for each
+// from the surrounding trackpoints, and (unless DryRun) writes it with coordinatesSource "gpx"
+// and a reverse-geocoded location. Photos that already have coordinates are never touched.
+func (s *GeotagService) GeotagFromTrack(ctx context.Context, track utils.Track, opts GeotagOptions) (*models.GeotagReport, error) {
+	if len(track) == 0 {
+		return nil, fmt.Errorf("%w: track has no points", apperrors.ErrInvalidInput)
+	}
+	if opts.MaxGap < 0 {
+		return nil, fmt.Errorf("%w: max gap cannot be negative", apperrors.ErrInvalidInput)
+	}
+
+	start, end := track.Span()
+	report := &models.GeotagReport{
+		TrackStart:  start,
+		TrackEnd:    end,
+		TrackPoints: len(track),
+		Matched:     []*models.GeotagMatch{},
+		Unmatched:   []string{},
+		DryRun:      opts.DryRun,
+	}
+
+	// Query in camera time: a photo matches GPS time t when takenAt + offset == t
+	images, err := s.firestore.ListImageMetadataTakenBetween(ctx, start.Add(-opts.Offset), end.Add(-opts.Offset))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, img := range images {
+		if img.Coordinates.Lat != "" && img.Coordinates.Lng != "" {
+			continue
+		}
+		report.Candidates++
+
+		lat, lng, ok := track.PositionAt(img.TakenAt.Add(opts.Offset), opts.MaxGap)
+		if !ok {
+			report.Unmatched = append(report.Unmatched, img.FileName)
+			continue
+		}
+
+		match := &models.GeotagMatch{
+			Id:       img.Id,
+			FileName: img.FileName,
+			TakenAt:  img.TakenAt,
+			Coordinates: models.Coordinates{
+				Lat: fmt.Sprintf("%.6f", lat),
+				Lng: fmt.Sprintf("%.6f", lng),
+			},
+		}
+		if opts.DryRun {
+			report.Matched = append(report.Matched, match)
+			continue
+		}
+
+		placeKey := s.geocoder.PlaceKey(match.Coordinates)
+		var parts models.LocationParts
+		match.GeoLocation, parts = resolveLocation(ctx, s.firestore, s.geocoder, placeKey, match.Coordinates)
+
+		err := s.firestore.SetCoordinates(ctx, img.Id, match.Coordinates, CoordinatesSourceGPX, placeKey, match.GeoLocation, parts, img.Revision)
+		if err != nil {
+			if errors.Is(err, apperrors.ErrConflict) {
+				log.Printf("[Geotag] Skipping %s: modified while geotagging", img.FileName)
+			} else {
+				log.Printf("[Geotag] Failed to write %s: %v", img.FileName, err)
+			}
+			report.Failed++
+			continue
+		}
+		report.Matched = append(report.Matched, match)
+	}
+
+	log.Printf("[Geotag] Track %s - %s: %d candidates, %d matched, %d unmatched, %d failed (dry run: %v)",
+		start.Format(time.RFC3339), end.Format(time.RFC3339), report.Candidates,
+		len(report.Matched), len(report.Unmatched), report.Failed, opts.DryRun)
+
+	return report, nil
+}
+
+// Looks up an image's stored coordinates again, bypassing the geocoder's cache, and replaces its
+// geoLocation and location hierarchy with the result (bumping updatedAt). Legacy IDs are accepted.
+// Returns ErrUnprocessable if the image has no usable coordinates or nothing is found at them
+// (the stored location is then left as it is), and ErrConflict if the document changed meanwhile.
+func (s *GeotagService) Regeocode(ctx context.Context, id string) (*models.RegeocodeResult, error) {
+	img, err := s.firestore.GetImageMetadata(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if img.Coordinates.Lat == "" || img.Coordinates.Lng == "" {
+		return nil, fmt.Errorf("%w: image %s has no coordinates to geocode", apperrors.ErrUnprocessable, img.Id)
+	}
+
+	parts, err := s.geocoder.ReverseGeocodeParts(ctx, img.Coordinates, true)
+	if errors.Is(err, apperrors.ErrInvalidInput) {
+		return nil, fmt.Errorf("%w: image %s has invalid coordinates: %w", apperrors.ErrUnprocessable, img.Id, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to geocode %s: %w", img.Id, err)
+	}
+	location := FormatLocation(parts)
+	if location == "" {
+		return nil, fmt.Errorf("%w: no place found at the coordinates of image %s", apperrors.ErrUnprocessable, img.Id)
+	}
+
+	if err := s.firestore.ReplaceGeoLocation(ctx, img.Id, location, parts, img.Revision); err != nil {
+		return nil, err
+	}
+
+	previous := img.LocationParts()
+	result := &models.RegeocodeResult{
+		Id:          img.Id,
+		FileName:    img.FileName,
+		Coordinates: img.Coordinates,
+		Previous:    img.GeoLocation,
+		GeoLocation: location,
+		City:        parts.City,
+		Region:      parts.Region,
+		Country:     parts.Country,
+		CountryCode: parts.CountryCode,
+		Town:        parts.Town,
+		Village:     parts.Village,
+		State:       parts.State,
+		County:      parts.County,
+		Changed:     location != img.GeoLocation || parts != previous,
+		UpdatedAt:   time.Now(),
+	}
+	log.Printf("[Geotag] Re-geocoded %s: %q -> %q", img.FileName, img.GeoLocation, location)
+	return result, nil
+}
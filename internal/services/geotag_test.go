@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"trekka-api/internal/models"
+	"trekka-api/internal/utils"
+)
+
+func TestGeotagFromTrack(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+
+	geocoder := NewGeocodingService()
+	provider := &fakeGeocoder{parts: models.LocationParts{City: "Lisbon", Country: "Portugal"}}
+	geocoder.SetProvider(provider)
+	geotagger := NewGeotagService(fs, geocoder)
+
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	track := utils.Track{
+		{Lat: 38.70, Lng: -9.12, Time: base},
+		{Lat: 38.72, Lng: -9.14, Time: base.Add(10 * time.Minute)},
+		{Lat: 38.80, Lng: -9.20, Time: base.Add(60 * time.Minute)}, // After the logger was off for 50 minutes
+	}
+
+	// The camera clock runs two minutes slow: a photo stamped 11:58 was taken at 12:00 GPS time
+	const offset = 2 * time.Minute
+	located := models.Coordinates{Lat: "40.000000", Lng: "-8.000000"}
+	photos := map[string]*models.ImageMetadata{
+		"at-point":   {FileName: "at-point.jpg", TakenAt: base.Add(-offset)},
+		"between":    {FileName: "between.jpg", TakenAt: base.Add(5*time.Minute - offset)},
+		"in-gap":     {FileName: "in-gap.jpg", TakenAt: base.Add(30*time.Minute - offset)},
+		"after":      {FileName: "after.jpg", TakenAt: base.Add(90 * time.Minute)},
+		"has-gps":    {FileName: "has-gps.jpg", TakenAt: base.Add(5*time.Minute - offset), Coordinates: located},
+		"before-all": {FileName: "before-all.jpg", TakenAt: base.Add(-time.Hour)},
+	}
+	for id, metadata := range photos {
+		seedImage(t, fs, id, metadata)
+	}
+	opts := GeotagOptions{Offset: offset, MaxGap: 15 * time.Minute}
+
+	wantMatches := map[string]models.Coordinates{
+		"at-point": {Lat: "38.700000", Lng: "-9.120000"},
+		"between":  {Lat: "38.710000", Lng: "-9.130000"},
+	}
+	checkReport := func(t *testing.T, report *models.GeotagReport) {
+		t.Helper()
+		if report.Candidates != 3 {
+			t.Errorf("candidates = %d, want 3 (photos in range without coordinates)", report.Candidates)
+		}
+		if !slices.Equal(report.Unmatched, []string{"in-gap.jpg"}) {
+			t.Errorf("unmatched = %v, want [in-gap.jpg]", report.Unmatched)
+		}
+		if len(report.Matched) != len(wantMatches) {
+			t.Fatalf("matched %d photos, want %d", len(report.Matched), len(wantMatches))
+		}
+		for _, match := range report.Matched {
+			if want, ok := wantMatches[match.Id]; !ok || match.Coordinates != want {
+				t.Errorf("%s matched at %+v, want %+v", match.Id, match.Coordinates, want)
+			}
+		}
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		report, err := geotagger.GeotagFromTrack(ctx, track, GeotagOptions{Offset: opts.Offset, MaxGap: opts.MaxGap, DryRun: true})
+		if err != nil {
+			t.Fatalf("GeotagFromTrack: %v", err)
+		}
+		checkReport(t, report)
+		for id := range wantMatches {
+			got, err := fs.GetImageMetadata(ctx, id)
+			if err != nil {
+				t.Fatalf("read %s: %v", id, err)
+			}
+			if got.Coordinates != (models.Coordinates{}) {
+				t.Errorf("dry run wrote coordinates %+v to %s", got.Coordinates, id)
+			}
+		}
+		if n := provider.calls.Load(); n != 0 {
+			t.Errorf("dry run geocoded %d times", n)
+		}
+	})
+
+	t.Run("write", func(t *testing.T) {
+		report, err := geotagger.GeotagFromTrack(ctx, track, opts)
+		if err != nil {
+			t.Fatalf("GeotagFromTrack: %v", err)
+		}
+		checkReport(t, report)
+		if report.Failed != 0 {
+			t.Errorf("%d writes failed", report.Failed)
+		}
+
+		for id, want := range wantMatches {
+			got, err := fs.GetImageMetadata(ctx, id)
+			if err != nil {
+				t.Fatalf("read %s: %v", id, err)
+			}
+			if got.Coordinates != want || got.CoordinatesSource != CoordinatesSourceGPX {
+				t.Errorf("%s stored at %+v from %q, want %+v from gpx", id, got.Coordinates, got.CoordinatesSource, want)
+			}
+			if got.GeoLocation != "Lisbon, Portugal" || got.Country != "Portugal" || got.Geohash == "" || got.PlaceKey == "" {
+				t.Errorf("%s location = %q in %q, geohash %q, place %q; want the derived fields written", id,
+					got.GeoLocation, got.Country, got.Geohash, got.PlaceKey)
+			}
+		}
+		for _, id := range []string{"in-gap", "after", "before-all"} {
+			got, err := fs.GetImageMetadata(ctx, id)
+			if err != nil {
+				t.Fatalf("read %s: %v", id, err)
+			}
+			if got.Coordinates != (models.Coordinates{}) {
+				t.Errorf("%s geotagged at %+v, want it left alone", id, got.Coordinates)
+			}
+		}
+		got, err := fs.GetImageMetadata(ctx, "has-gps")
+		if err != nil {
+			t.Fatalf("read has-gps: %v", err)
+		}
+		if got.Coordinates != located || got.CoordinatesSource != "" {
+			t.Errorf("photo with EXIF GPS now at %+v from %q, want it untouched", got.Coordinates, got.CoordinatesSource)
+		}
+	})
+
+	t.Run("rerun finds nothing left", func(t *testing.T) {
+		report, err := geotagger.GeotagFromTrack(ctx, track, opts)
+		if err != nil {
+			t.Fatalf("GeotagFromTrack: %v", err)
+		}
+		if report.Candidates != 1 || len(report.Matched) != 0 {
+			t.Errorf("rerun: %d candidates, %d matched; want only the photo in the gap", report.Candidates, len(report.Matched))
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+// Reverse geocodes with the Google Maps Geocoding API, which often knows remote places (trails,
+// national parks) that Nominatim has nothing for. Every request is billed to the API key's project.
+type GoogleMapsGeocoder struct {
+	apiKey     string
+	httpClient *http.Client
+}
+
+// The subset of a Geocoding API response read here. Results run from most to least specific.
+type googleGeocodeResponse struct {
+	Status       string `json:"status"`
+	ErrorMessage string `json:"error_message"`
+	Results      []struct {
+		AddressComponents []struct {
+			LongName  string   `json:"long_name"`
+			ShortName string   `json:"short_name"`
+			Types     []string `json:"types"`
+		} `json:"address_components"`
+	} `json:"results"`
+}
+
+// Returns a Google Maps geocoder using apiKey, which needs the Geocoding API enabled.
+func NewGoogleMapsGeocoder(apiKey string) *GoogleMapsGeocoder {
+	return &GoogleMapsGeocoder{
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Looks coordinates up with the Geocoding API. No result is an empty location, not an error;
+// a refused key or exhausted quota is ErrUnavailable.
+func (g *GoogleMapsGeocoder) ReverseGeocode(ctx context.Context, coordinates models.Coordinates) (models.LocationParts, error) {
+	lat, lng, err := parseCoordinates(coordinates)
+	if err != nil {
+		return models.LocationParts{}, err
+	}
+
+	query := url.Values{}
+	query.Set("latlng", fmt.Sprintf("%f,%f", lat, lng))
+	query.Set("language", "en")
+	query.Set("key", g.apiKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://maps.googleapis.com/maps/api/geocode/json?"+query.Encode(), nil)
+	if err != nil {
+		return models.LocationParts{}, err
+	}
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		// The error's URL carries the API key
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return models.LocationParts{}, fmt.Errorf("%w: google maps request failed: %w", apperrors.ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.LocationParts{}, fmt.Errorf("%w: google maps returned status %d", apperrors.ErrUnavailable, resp.StatusCode)
+	}
+
+	var data googleGeocodeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return models.LocationParts{}, err
+	}
+
+	switch data.Status {
+	case "OK":
+		return extractGoogleLocation(data), nil
+	case "ZERO_RESULTS":
+		return models.LocationParts{}, nil
+	default:
+		return models.LocationParts{}, fmt.Errorf("%w: google maps answered %s: %s", apperrors.ErrUnavailable, data.Status, data.ErrorMessage)
+	}
+}
+
+// Picks the most specific name for each level of the hierarchy, taking each component type from
+// the most specific result that has it.
+func extractGoogleLocation(data googleGeocodeResponse) models.LocationParts {
+	names := make(map[string]string) // Component type -> long name
+	var parts models.LocationParts
+	for _, result := range data.Results {
+		for _, component := range result.AddressComponents {
+			for _, t := range component.Types {
+				if _, ok := names[t]; !ok {
+					names[t] = component.LongName
+				}
+				if t == "country" && parts.Country == "" {
+					parts.Country = component.LongName
+					parts.CountryCode = strings.ToUpper(component.ShortName)
+				}
+			}
+		}
+	}
+
+	parts.City = firstNonEmpty(names["locality"], names["postal_town"], names["sublocality"], names["administrative_area_level_3"])
+	parts.Region = firstNonEmpty(names["administrative_area_level_1"], names["administrative_area_level_2"])
+	parts.Town = names["postal_town"]
+	parts.State = names["administrative_area_level_1"]
+	parts.County = names["administrative_area_level_2"]
+	return parts
+}
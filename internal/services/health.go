@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"trekka-api/internal/models"
+)
+
+// Longest a single dependency probe may take before it is reported as failed.
+const healthCheckTimeout = 2 * time.Second
+
+// How long a health report is reused, so a burst of health checks costs one round of probes.
+const healthCacheTTL = 5 * time.Second
+
+// Probes the services the API depends on (Firestore, Storage and, when sync is enabled, Drive).
+type HealthService struct {
+	checks map[string]func(context.Context) error
+
+	mu     sync.Mutex // Held while probing, so concurrent callers wait for one round instead of starting their own
+	last   *models.HealthReport
+	expiry time.Time
+}
+
+// Creates a health service. drive may be nil when Drive sync is disabled, in which case it isn't checked.
+func NewHealthService(firestore *FirestoreService, storage *StorageService, drive *DriveService) *HealthService {
+	checks := map[string]func(context.Context) error{
+		"firestore": firestore.Ping,
+		"storage":   storage.Ping,
+	}
+	if drive != nil {
+		checks["drive"] = drive.Ping
+	}
+	return &HealthService{checks: checks}
+}
+
+// Probes every dependency in parallel, each under its own timeout, and returns the combined
+// report. A report younger than healthCacheTTL is returned without probing again.
+func (h *HealthService) Check(ctx context.Context) *models.HealthReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.last != nil && time.Now().Before(h.expiry) {
+		return h.last
+	}
+
+	report := &models.HealthReport{
+		Status: models.HealthOK,
+		Checks: make(map[string]models.DependencyHealth, len(h.checks)),
+	}
+
+	var (
+		wg        sync.WaitGroup
+		resultsMu sync.Mutex
+	)
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Detached from the request so a client disconnect doesn't record a spurious failure in the shared report
+			checkCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(checkCtx)
+			result := models.DependencyHealth{Status: models.HealthOK, LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = models.HealthFail
+				result.Error = err.Error()
+				log.Printf("[Health] %s check failed after %dms: %v", name, result.LatencyMs, err)
+			}
+
+			resultsMu.Lock()
+			report.Checks[name] = result
+			if err != nil {
+				report.Status = models.HealthFail
+			}
+			resultsMu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	report.CheckedAt = time.Now().UTC()
+	h.last, h.expiry = report, time.Now().Add(healthCacheTTL)
+	return report
+}
@@ -0,0 +1,401 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+// Most moved or deleted image documents per migration transaction. Firestore allows 500 writes
+// in one, and the album and trip reference updates made in the same transaction need the rest.
+const MaxMigrationBatch = 200
+
+// Firestore's limit on writes in a single transaction.
+const maxTransactionWrites = 500
+
+// IDMove is one document to move to its deterministic ID.
+type IDMove struct {
+	OldID string
+	NewID string
+}
+
+// MigrationResult counts what MigrateDocumentIDs did.
+type MigrationResult struct {
+	Moved  int // Old documents deleted after being copied or folded into their deterministic ID
+	Merged int // Of those, duplicates folded into a document another copy of the media also went to
+	Albums int // Albums whose member references were rewritten
+	Trips  int // Trips whose member references were rewritten
+}
+
+// The moves that share a deterministic ID: copies of the same media.
+type idMoveGroup struct {
+	newID string
+	moves []IDMove
+}
+
+// A stored copy of some media being folded into its deterministic document.
+type migratedDocument struct {
+	id      string
+	data    map[string]interface{}
+	updated time.Time
+}
+
+// Moves documents to their deterministic IDs, batchSize documents (at most MaxMigrationBatch)
+// per transaction. Moves sharing a NewID are duplicates of one piece of media and are folded,
+// together with any document already stored under it, into one (see mergeMigratedDocuments).
+// Album and trip references to the old IDs are rewritten in the same transaction, so no album
+// points at a deleted document; album summaries are recomputed right after. Moves whose old
+// document is gone are skipped. A failed batch is returned with the counts of those before it.
+func (fs *FirestoreService) MigrateDocumentIDs(ctx context.Context, moves []IDMove, batchSize int) (MigrationResult, error) {
+	if batchSize <= 0 || batchSize > MaxMigrationBatch {
+		batchSize = MaxMigrationBatch
+	}
+
+	var result MigrationResult
+	albums := make(map[string]bool)
+	trips := make(map[string]bool)
+	var batch []idMoveGroup
+	size := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		batchResult, albumIDs, tripIDs, err := fs.migrateGroups(ctx, batch)
+		if err != nil {
+			return err
+		}
+		result.Moved += batchResult.Moved
+		result.Merged += batchResult.Merged
+		for _, id := range albumIDs {
+			albums[id] = true
+		}
+		for _, id := range tripIDs {
+			trips[id] = true
+		}
+		result.Albums, result.Trips = len(albums), len(trips)
+		log.Printf("[MigrateIDs] Moved %d documents (%d so far)", batchResult.Moved, result.Moved)
+
+		// Merged duplicates leave albums with fewer (and possibly better located) members
+		for _, id := range albumIDs {
+			if _, err := fs.modifyAlbum(ctx, id, nil, func(*models.Album) error { return nil }); err != nil {
+				return fmt.Errorf("failed to refresh album %s: %w", id, err)
+			}
+		}
+		batch, size = nil, 0
+		return nil
+	}
+
+	for _, group := range groupMoves(moves) {
+		if size > 0 && size+len(group.moves) > batchSize {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+		batch = append(batch, group)
+		size += len(group.moves)
+	}
+	if err := flush(); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Groups moves by their new ID in order of first appearance, leaving out empty IDs, moves to
+// the same ID and repeats.
+func groupMoves(moves []IDMove) []idMoveGroup {
+	index := make(map[string]int)
+	seen := make(map[string]bool)
+	var groups []idMoveGroup
+	for _, move := range moves {
+		if move.OldID == "" || move.NewID == "" || move.OldID == move.NewID || seen[move.OldID] {
+			continue
+		}
+		seen[move.OldID] = true
+
+		i, ok := index[move.NewID]
+		if !ok {
+			i = len(groups)
+			index[move.NewID] = i
+			groups = append(groups, idMoveGroup{newID: move.NewID})
+		}
+		groups[i].moves = append(groups[i].moves, move)
+	}
+	return groups
+}
+
+// Migrates one batch of groups in a transaction, returning its Moved and Merged counts and the
+// albums and trips whose references changed.
+func (fs *FirestoreService) migrateGroups(ctx context.Context, groups []idMoveGroup) (MigrationResult, []string, []string, error) {
+	coll := fs.client.Collection(fs.collection)
+
+	var result MigrationResult
+	var albumIDs, tripIDs []string
+	err := fs.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		result = MigrationResult{}
+		albumIDs, tripIDs = nil, nil
+
+		// Transactions must read everything before their first write
+		var refs []*firestore.DocumentRef
+		for _, group := range groups {
+			refs = append(refs, coll.Doc(group.newID))
+			for _, move := range group.moves {
+				refs = append(refs, coll.Doc(move.OldID))
+			}
+		}
+		snaps, err := tx.GetAll(refs)
+		if err != nil {
+			return err
+		}
+
+		type groupWrite struct {
+			ref     *firestore.DocumentRef
+			data    map[string]interface{}
+			deletes []*firestore.DocumentRef
+		}
+		var writes []groupWrite
+		renamed := make(map[string]string) // Old ID -> deterministic ID
+		next := 0
+		for _, group := range groups {
+			target := snaps[next]
+			next++
+
+			var docs []migratedDocument
+			if target.Exists() {
+				docs = append(docs, migratedDocument{id: group.newID, data: target.Data(), updated: target.UpdateTime})
+			}
+			var deletes []*firestore.DocumentRef
+			for _, move := range group.moves {
+				snap := snaps[next]
+				next++
+				if !snap.Exists() {
+					// Migrated or deleted since it was listed
+					continue
+				}
+				docs = append(docs, migratedDocument{id: move.OldID, data: snap.Data(), updated: snap.UpdateTime})
+				deletes = append(deletes, snap.Ref)
+				renamed[move.OldID] = group.newID
+			}
+			if len(deletes) == 0 {
+				continue
+			}
+
+			writes = append(writes, groupWrite{ref: coll.Doc(group.newID), data: mergeMigratedDocuments(group.newID, docs), deletes: deletes})
+			result.Moved += len(deletes)
+			result.Merged += len(docs) - 1
+		}
+		if len(renamed) == 0 {
+			return nil
+		}
+
+		albums, err := referencingDocuments(tx, fs.client.Collection(albumsCollection), renamed)
+		if err != nil {
+			return err
+		}
+		trips, err := referencingDocuments(tx, fs.client.Collection(tripsCollection), renamed)
+		if err != nil {
+			return err
+		}
+
+		total := len(albums) + len(trips)
+		for _, w := range writes {
+			total += 1 + len(w.deletes)
+		}
+		if total > maxTransactionWrites {
+			return fmt.Errorf("%w: batch needs %d writes (Firestore allows %d); use a smaller batch", apperrors.ErrInvalidInput, total, maxTransactionWrites)
+		}
+
+		for _, w := range writes {
+			if err := tx.Set(w.ref, w.data); err != nil {
+				return err
+			}
+			for _, ref := range w.deletes {
+				if err := tx.Delete(ref); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, doc := range albums {
+			var album models.Album
+			if err := doc.DataTo(&album); err != nil {
+				return fmt.Errorf("failed to parse album %s: %w", doc.Ref.ID, err)
+			}
+			updates := []firestore.Update{{Path: "imageIds", Value: remapIDs(album.ImageIDs, renamed)}}
+			if newID, ok := renamed[album.CoverImageID]; ok {
+				updates = append(updates, firestore.Update{Path: "coverImageId", Value: newID})
+			}
+			if err := tx.Update(doc.Ref, updates); err != nil {
+				return err
+			}
+			albumIDs = append(albumIDs, doc.Ref.ID)
+		}
+
+		for _, doc := range trips {
+			var trip models.Trip
+			if err := doc.DataTo(&trip); err != nil {
+				return fmt.Errorf("failed to parse trip %s: %w", doc.Ref.ID, err)
+			}
+			ids := remapIDs(trip.ImageIDs, renamed)
+			if err := tx.Update(doc.Ref, []firestore.Update{
+				{Path: "imageIds", Value: ids},
+				{Path: "imageCount", Value: len(ids)},
+			}); err != nil {
+				return err
+			}
+			tripIDs = append(tripIDs, doc.Ref.ID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return MigrationResult{}, nil, nil, fmt.Errorf("failed to migrate document IDs: %w", classifyError(err))
+	}
+
+	return result, albumIDs, tripIDs, nil
+}
+
+// Reads, within tx, the documents in coll whose imageIds contain any renamed ID.
+func referencingDocuments(tx *firestore.Transaction, coll *firestore.CollectionRef, renamed map[string]string) ([]*firestore.DocumentSnapshot, error) {
+	oldIDs := make([]string, 0, len(renamed))
+	for id := range renamed {
+		oldIDs = append(oldIDs, id)
+	}
+	sort.Strings(oldIDs)
+
+	seen := make(map[string]bool)
+	var docs []*firestore.DocumentSnapshot
+	for start := 0; start < len(oldIDs); start += maxInQueryValues {
+		chunk := oldIDs[start:min(start+maxInQueryValues, len(oldIDs))]
+		found, err := tx.Documents(coll.Where("imageIds", "array-contains-any", chunk)).GetAll()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find references in %s: %w", coll.ID, err)
+		}
+		for _, doc := range found {
+			if !seen[doc.Ref.ID] {
+				seen[doc.Ref.ID] = true
+				docs = append(docs, doc)
+			}
+		}
+	}
+	return docs, nil
+}
+
+// Replaces renamed IDs in ids, keeping the order and dropping the repeats merged duplicates
+// leave behind.
+func remapIDs(ids []string, renamed map[string]string) []string {
+	seen := make(map[string]bool, len(ids))
+	remapped := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if newID, ok := renamed[id]; ok {
+			id = newID
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		remapped = append(remapped, id)
+	}
+	return remapped
+}
+
+// Folds the stored copies of one piece of media into the document kept under its deterministic
+// ID. The copy already stored there wins, otherwise the most recently updated one; fields it
+// lacks are taken from the other copies. createdAt is the earliest of them, favorite is kept if
+// any copy was a favorite, and every other copy's ID joins legacyIds so links to it keep
+// resolving. Visibility is the winning copy's, so a merge never makes an image public.
+func mergeMigratedDocuments(newID string, docs []migratedDocument) map[string]interface{} {
+	primary := slices.IndexFunc(docs, func(d migratedDocument) bool { return d.id == newID })
+	if primary < 0 {
+		primary = 0
+		for i, doc := range docs {
+			if doc.updated.After(docs[primary].updated) {
+				primary = i
+			}
+		}
+	}
+
+	merged := make(map[string]interface{}, len(docs[primary].data))
+	for key, value := range docs[primary].data {
+		merged[key] = value
+	}
+	createdAt, _ := merged["createdAt"].(time.Time)
+	favorite, _ := merged["favorite"].(bool)
+	var legacy []string
+	for i, doc := range docs {
+		legacy = append(legacy, stringValues(doc.data["legacyIds"])...)
+		if doc.id != newID {
+			legacy = append(legacy, doc.id)
+		}
+		if i == primary {
+			continue
+		}
+
+		for key, value := range doc.data {
+			if key != "visibility" && isEmptyValue(merged[key]) {
+				merged[key] = value
+			}
+		}
+		if t, ok := doc.data["createdAt"].(time.Time); ok && !t.IsZero() && (createdAt.IsZero() || t.Before(createdAt)) {
+			createdAt = t
+		}
+		if f, _ := doc.data["favorite"].(bool); f {
+			favorite = true
+		}
+	}
+
+	if !createdAt.IsZero() {
+		merged["createdAt"] = createdAt
+	}
+	if favorite {
+		merged["favorite"] = true
+	}
+	legacy = slices.DeleteFunc(legacy, func(id string) bool { return id == newID })
+	sort.Strings(legacy)
+	merged["legacyIds"] = slices.Compact(legacy)
+	// Older documents stored their ID; it is read from the reference now
+	delete(merged, "id")
+
+	return merged
+}
+
+// Reports whether a stored field value is absent or empty.
+func isEmptyValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	default:
+		return v.IsZero()
+	}
+}
+
+// Returns the strings in a stored array value.
+func stringValues(value interface{}) []string {
+	var values []string
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+	case []string:
+		values = append(values, v...)
+	}
+	return values
+}
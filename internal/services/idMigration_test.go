@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"trekka-api/internal/models"
+)
+
+func TestGroupMoves(t *testing.T) {
+	moves := []IDMove{
+		{OldID: "a", NewID: "d1"},
+		{OldID: "b", NewID: "d2"},
+		{OldID: "c", NewID: "d1"},
+		{OldID: "d1", NewID: "d1"}, // Already there
+		{OldID: "a", NewID: "d1"},  // Listed twice
+		{OldID: "e", NewID: ""},    // No deterministic ID
+	}
+
+	groups := groupMoves(moves)
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2: %+v", len(groups), groups)
+	}
+	if groups[0].newID != "d1" || len(groups[0].moves) != 2 || groups[0].moves[1].OldID != "c" {
+		t.Errorf("first group = %+v, want a and c moving to d1", groups[0])
+	}
+	if groups[1].newID != "d2" || len(groups[1].moves) != 1 {
+		t.Errorf("second group = %+v, want b moving to d2", groups[1])
+	}
+}
+
+func TestRemapIDs(t *testing.T) {
+	renamed := map[string]string{"a": "d1", "c": "d1", "b": "d2"}
+
+	got := remapIDs([]string{"x", "a", "b", "c", "y"}, renamed)
+	want := []string{"x", "d1", "d2", "y"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("remapIDs = %v, want %v", got, want)
+	}
+}
+
+func TestMergeMigratedDocuments(t *testing.T) {
+	early := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	late := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("existing target wins", func(t *testing.T) {
+		docs := []migratedDocument{
+			{id: "old", updated: late, data: map[string]interface{}{
+				"fileName":    "IMG_1.jpg",
+				"geoLocation": "Sintra, Portugal",
+				"createdAt":   early,
+				"favorite":    true,
+				"visibility":  models.VisibilityPublic,
+				"legacyIds":   []interface{}{"older"},
+				"id":          "old",
+			}},
+			{id: "d1", updated: early, data: map[string]interface{}{
+				"fileName":    "IMG_1.jpg",
+				"geoLocation": "",
+				"createdAt":   late,
+				"description": "kept",
+			}},
+		}
+
+		merged := mergeMigratedDocuments("d1", docs)
+
+		if merged["description"] != "kept" {
+			t.Errorf("description = %v, want the target's", merged["description"])
+		}
+		if merged["geoLocation"] != "Sintra, Portugal" {
+			t.Errorf("geoLocation = %v, want it filled from the duplicate", merged["geoLocation"])
+		}
+		if merged["createdAt"] != early {
+			t.Errorf("createdAt = %v, want the earliest (%v)", merged["createdAt"], early)
+		}
+		if merged["favorite"] != true {
+			t.Errorf("favorite = %v, want true from the duplicate", merged["favorite"])
+		}
+		if _, ok := merged["visibility"]; ok {
+			t.Errorf("visibility = %v, want the target's (unset)", merged["visibility"])
+		}
+		if want := []string{"old", "older"}; !reflect.DeepEqual(merged["legacyIds"], want) {
+			t.Errorf("legacyIds = %v, want %v", merged["legacyIds"], want)
+		}
+		if _, ok := merged["id"]; ok {
+			t.Error("stored id field kept, want it dropped")
+		}
+	})
+
+	t.Run("most recently updated copy wins without a target", func(t *testing.T) {
+		docs := []migratedDocument{
+			{id: "a", updated: early, data: map[string]interface{}{"description": "stale"}},
+			{id: "b", updated: late, data: map[string]interface{}{"description": "fresh"}},
+		}
+
+		merged := mergeMigratedDocuments("d1", docs)
+
+		if merged["description"] != "fresh" {
+			t.Errorf("description = %v, want the latest copy's", merged["description"])
+		}
+		if want := []string{"a", "b"}; !reflect.DeepEqual(merged["legacyIds"], want) {
+			t.Errorf("legacyIds = %v, want %v", merged["legacyIds"], want)
+		}
+	})
+}
+
+func TestMigrateDocumentIDs(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+	coll := fs.client.Collection(fs.collection)
+
+	early := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	late := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	// Two copies of one Drive file (an interrupted backfill) and one other file, all randomly keyed
+	a, b, c := coll.NewDoc().ID, coll.NewDoc().ID, coll.NewDoc().ID
+	seedImage(t, fs, a, &models.ImageMetadata{FileName: "IMG_1.jpg", DriveFileID: "drive-1", CreatedAt: late, Favorite: true})
+	seedImage(t, fs, b, &models.ImageMetadata{FileName: "IMG_1.jpg", DriveFileID: "drive-1", CreatedAt: early,
+		Coordinates: models.Coordinates{Lat: "38.8", Lng: "-9.4"}, GeoLocation: "Sintra, Portugal"})
+	seedImage(t, fs, c, &models.ImageMetadata{FileName: "IMG_2.jpg", DriveFileID: "drive-2", CreatedAt: early})
+
+	if _, err := fs.client.Collection(albumsCollection).Doc("album").Set(ctx, &models.Album{
+		Name: "Portugal", ImageIDs: []string{a, c, b}, CoverImageID: b,
+	}); err != nil {
+		t.Fatalf("seed album: %v", err)
+	}
+	if _, err := fs.client.Collection(tripsCollection).Doc("trip").Set(ctx, &models.Trip{
+		Name: "Portugal", ImageIDs: []string{a, b, c}, ImageCount: 3,
+	}); err != nil {
+		t.Fatalf("seed trip: %v", err)
+	}
+
+	moves := []IDMove{{OldID: a, NewID: "drive-1"}, {OldID: b, NewID: "drive-1"}, {OldID: c, NewID: "drive-2"}}
+	// A batch of two puts each Drive file in a transaction of its own
+	result, err := fs.MigrateDocumentIDs(ctx, moves, 2)
+	if err != nil {
+		t.Fatalf("MigrateDocumentIDs: %v", err)
+	}
+
+	if want := (MigrationResult{Moved: 3, Merged: 1, Albums: 1, Trips: 1}); result != want {
+		t.Errorf("result = %+v, want %+v", result, want)
+	}
+	if n := countImages(t, fs); n != 2 {
+		t.Errorf("%d image documents after migration, want 2", n)
+	}
+
+	merged, err := fs.GetImageMetadata(ctx, "drive-1")
+	if err != nil {
+		t.Fatalf("GetImageMetadata(drive-1): %v", err)
+	}
+	if !merged.CreatedAt.Equal(early) || !merged.Favorite || merged.GeoLocation != "Sintra, Portugal" {
+		t.Errorf("merged = createdAt %v favorite %t geoLocation %q, want the earliest createdAt, the favorite and the location",
+			merged.CreatedAt, merged.Favorite, merged.GeoLocation)
+	}
+
+	// Old IDs keep resolving
+	for _, id := range []string{a, b} {
+		legacy, err := fs.GetImageMetadata(ctx, id)
+		if err != nil {
+			t.Fatalf("GetImageMetadata(%s): %v", id, err)
+		}
+		if legacy.Id != "drive-1" {
+			t.Errorf("GetImageMetadata(%s) found %s, want drive-1", id, legacy.Id)
+		}
+	}
+
+	byName, err := fs.GetImageMetadataByFilename(ctx, "IMG_2.jpg", "jpg")
+	if err != nil {
+		t.Fatalf("GetImageMetadataByFilename(IMG_2.jpg): %v", err)
+	}
+	if byName.Id != "drive-2" {
+		t.Errorf("GetImageMetadataByFilename(IMG_2.jpg) found %s, want drive-2", byName.Id)
+	}
+
+	album, err := fs.GetAlbum(ctx, "album")
+	if err != nil {
+		t.Fatalf("GetAlbum: %v", err)
+	}
+	if want := []string{"drive-1", "drive-2"}; !reflect.DeepEqual(album.ImageIDs, want) {
+		t.Errorf("album imageIds = %v, want %v", album.ImageIDs, want)
+	}
+	if album.CoverImageID != "drive-1" {
+		t.Errorf("album cover = %q, want drive-1", album.CoverImageID)
+	}
+	if album.Summary.MemberCount != 2 {
+		t.Errorf("album member count = %d, want 2", album.Summary.MemberCount)
+	}
+
+	doc, err := fs.client.Collection(tripsCollection).Doc("trip").Get(ctx)
+	if err != nil {
+		t.Fatalf("get trip: %v", err)
+	}
+	var trip models.Trip
+	if err := doc.DataTo(&trip); err != nil {
+		t.Fatalf("parse trip: %v", err)
+	}
+	if want := []string{"drive-1", "drive-2"}; !reflect.DeepEqual(trip.ImageIDs, want) || trip.ImageCount != 2 {
+		t.Errorf("trip = %v (%d), want %v (2)", trip.ImageIDs, trip.ImageCount, want)
+	}
+
+	// A second run finds nothing left to move
+	again, err := fs.MigrateDocumentIDs(ctx, moves, 2)
+	if err != nil {
+		t.Fatalf("second MigrateDocumentIDs: %v", err)
+	}
+	if again != (MigrationResult{}) {
+		t.Errorf("second run = %+v, want nothing moved", again)
+	}
+}
@@ -1,76 +1,1552 @@
 package services
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
+	"cloud.google.com/go/storage"
+	"golang.org/x/sync/singleflight"
+
+	apperrors "trekka-api/internal/errors"
 	"trekka-api/internal/models"
+	"trekka-api/internal/utils"
 )
 
+// Upper bound for thumbnail widths to keep resize work and cache size predictable.
+const MaxThumbnailWidth = 2048
+
+// Default ceiling for objects streamed through OpenImage.
+const DefaultProxyMaxBytes = 25 * 1024 * 1024 // 25MB
+
+// How long the random-pick index is reused before the collection is rescanned.
+const randomIndexTTL = 10 * time.Minute
+
+// How long after the last stale fallback Degraded keeps reporting true.
+const degradedWindow = time.Minute
+
+// Default largest radius accepted by NearbyImages.
+const DefaultNearMaxRadiusMeters = 50_000
+
+// Most documents read per geohash prefix in a proximity query, bounding the scan for dense areas.
+const nearPrefixLimit = 2000
+
+// Longest side of the link-preview JPEGs served by OpenGraphImage.
+const OpenGraphMaxSize = 1200
+
+// Storage prefix for generated link-preview JPEGs, kept apart from the originals.
+const openGraphPrefix = "derived/og/"
+
+// Most filenames accepted by SignedURLs in one call.
+const MaxBatchURLs = 100
+
+// Concurrent signers used by SignedURLs.
+const batchSignWorkers = 8
+
+// How long computed map clusters are reused; panning produces bursts of identical requests.
+const clusterCacheTTL = 30 * time.Second
+
+// Cache key prefix for encoded map points; the collection version and encoding are appended.
+const pointsCachePrefix = "points:"
+
+// Default lifetime of not-found tombstones; see SetNegativeCacheTTL.
+const DefaultNegativeCacheTTL = 30 * time.Second
+
 type ImageService struct {
-	storage   *StorageService
-	cache     *CacheService
-	firestore *FirestoreService
+	storage       *StorageService
+	cache         *CacheService
+	firestore     *FirestoreService
+	proxyMaxBytes int64
+	nearMaxRadius float64            // Metres
+	negativeTTL   time.Duration      // How long GetImage remembers a lookup that found nothing (0 disables)
+	lookups       singleflight.Group // Shares GetImage cache misses between concurrent requests for one key
+	staleFallback bool
+	degradedAt    atomic.Int64 // UnixNano of the last stale fallback
+	webp          bool         // Thumbnails may be transcoded to WebP (cwebp is installed; see EnableWebP)
+
+	storagePaths StoragePathStrategy // Where uploaded files go in the bucket
+	geocoder     Geocoder            // Resolves the location of uploaded and refreshed files; none are resolved if nil
+
+	urlCheckRate   float64 // Fraction of cache hits whose signed URL is probed (see SetURLCheckRate)
+	urlCheckClient *http.Client
+	urlChecked     atomic.Uint64
+	urlBroken      atomic.Uint64
+	urlRegenerated atomic.Uint64
+	urlReports     atomic.Uint64
+
+	randomMu      sync.Mutex
+	randomIndex   []randomCandidate
+	randomExpires time.Time
+}
+
+// Result of a GetImage cache miss, shared between the requests waiting on it.
+type imageLookup struct {
+	url         string
+	contentType string
+	geoLocation string
+	age         time.Duration
+}
+
+// Lightweight entry in the random-pick index.
+type randomCandidate struct {
+	id      string
+	country string
+	year    int
 }
 
 func NewImageService(storage *StorageService, cache *CacheService, firestore *FirestoreService) *ImageService {
 	return &ImageService{
-		storage:   storage,
-		cache:     cache,
-		firestore: firestore,
+		storage:        storage,
+		cache:          cache,
+		firestore:      firestore,
+		proxyMaxBytes:  DefaultProxyMaxBytes,
+		nearMaxRadius:  DefaultNearMaxRadiusMeters,
+		negativeTTL:    DefaultNegativeCacheTTL,
+		storagePaths:   DatedStoragePaths,
+		urlCheckClient: &http.Client{Timeout: urlCheckTimeout},
+	}
+}
+
+// Sets where uploaded files are stored (DatedStoragePaths unless set), like
+// DriveService.SetStoragePathStrategy for synced ones.
+func (s *ImageService) SetStoragePathStrategy(strategy StoragePathStrategy) {
+	s.storagePaths = strategy
+}
+
+// Sets the geocoder that resolves the location of uploaded and refreshed files, so uploads and
+// RefreshMetadata share the configured provider, cache and rate limit with Drive sync.
+func (s *ImageService) SetGeocoder(geocoder Geocoder) {
+	s.geocoder = geocoder
+}
+
+// Sets the largest object OpenImage will stream. Non-positive values are ignored.
+func (s *ImageService) SetProxyMaxBytes(maxBytes int64) {
+	if maxBytes > 0 {
+		s.proxyMaxBytes = maxBytes
+	}
+}
+
+// Sets the largest radius (metres) NearbyImages accepts. Non-positive values are ignored.
+func (s *ImageService) SetNearMaxRadius(meters float64) {
+	if meters > 0 {
+		s.nearMaxRadius = meters
 	}
 }
 
+// Sets how long GetImage answers a file name or ID it didn't find with ErrNotFound from memory
+// instead of asking Firestore again. Zero disables negative caching; negative values are ignored.
+func (s *ImageService) SetNegativeCacheTTL(ttl time.Duration) {
+	if ttl >= 0 {
+		s.negativeTTL = ttl
+	}
+}
+
+// Lets GetThumbnail transcode to WebP for clients that accept it. Only call this once
+// utils.WebPAvailable has found cwebp; without it thumbnails keep their source format.
+func (s *ImageService) EnableWebP() {
+	s.webp = true
+}
+
+// Serves cached signed URLs and list responses past their TTL (for up to maxAge) when Firestore
+// is unavailable, instead of failing the request. Requests with nothing cached still fail.
+func (s *ImageService) EnableStaleFallback(maxAge time.Duration) {
+	s.staleFallback = true
+	s.cache.SetStaleRetention(maxAge)
+}
+
+// Reports whether a stale fallback was served recently, i.e. Firestore is (or just was) unavailable.
+func (s *ImageService) Degraded() bool {
+	last := s.degradedAt.Load()
+	return last != 0 && time.Since(time.Unix(0, last)) < degradedWindow
+}
+
+// Looks up an expired cache entry to serve in place of a failed Firestore read.
+// Only Unavailable-class errors fall back; anything else (e.g. not found) is authoritative.
+func (s *ImageService) staleEntry(key string, err error) (*models.CacheEntry, time.Duration, bool) {
+	if !s.staleFallback || !errors.Is(err, apperrors.ErrUnavailable) {
+		return nil, 0, false
+	}
+
+	entry, age, ok := s.cache.GetStale(key)
+	if !ok {
+		return nil, 0, false
+	}
+
+	s.degradedAt.Store(time.Now().UnixNano())
+	log.Printf("[Image] WARNING: Firestore unavailable, serving %s from cache (%v old): %v", key, age.Round(time.Second), err)
+	return entry, age, true
+}
+
+// Opens a streaming reader for an image so it can be proxied instead of redirected.
+// Returns the reader along with its metadata; the caller must Close the reader.
+// Objects over the proxy size ceiling fail with ErrTooLarge.
+func (s *ImageService) OpenImage(ctx context.Context, fileName string) (*storage.Reader, *models.ImageMetadata, error) {
+	metadata, err := s.lookupByFileName(ctx, fileName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	reader, err := s.storage.OpenFile(ctx, metadata.StoragePath, s.proxyMaxBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", metadata.StoragePath, err)
+	}
+
+	return reader, metadata, nil
+}
+
+// Opens a streaming reader for a byte range of an image or video, for seeking in proxied playback.
+// Offsets and lengths follow StorageService.OpenFileRange. Open-ended ranges are clamped to the
+// proxy size ceiling (clients continue with a follow-up range); explicit ranges above it fail with ErrTooLarge.
+// Ranges starting past the end fail with a RangeError carrying the object's size.
+func (s *ImageService) OpenImageRange(ctx context.Context, fileName string, offset, length int64) (*storage.Reader, *models.ImageMetadata, error) {
+	switch {
+	case offset >= 0 && length < 0:
+		length = s.proxyMaxBytes
+	case offset < 0 && -offset > s.proxyMaxBytes, length > s.proxyMaxBytes:
+		return nil, nil, fmt.Errorf("%w: range exceeds maximum of %d bytes", apperrors.ErrTooLarge, s.proxyMaxBytes)
+	}
+
+	metadata, err := s.lookupByFileName(ctx, fileName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	reader, err := s.storage.OpenFileRange(ctx, metadata.StoragePath, offset, length)
+	if errors.Is(err, apperrors.ErrRangeNotSatisfiable) {
+		// The 416 response has to name the object's size
+		if attrs, attrsErr := s.storage.Attrs(ctx, metadata.StoragePath); attrsErr == nil {
+			err = &apperrors.RangeError{Size: attrs.Size, Err: err}
+		}
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", metadata.StoragePath, err)
+	}
+
+	return reader, metadata, nil
+}
+
+// Returns the size in bytes of an image's stored object, for answering range requests that
+// can't be served.
+func (s *ImageService) ImageSize(ctx context.Context, fileName string) (int64, error) {
+	metadata, err := s.lookupByFileName(ctx, fileName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	attrs, err := s.storage.Attrs(ctx, metadata.StoragePath)
+	if err != nil {
+		return 0, err
+	}
+	return attrs.Size, nil
+}
+
+// Builds the cache entry for a URL signed for metadata's object, valid until urlExpires.
+func signedURLEntry(signedURL string, urlExpires time.Time, metadata *models.ImageMetadata) models.SignedURLEntry {
+	return models.SignedURLEntry{
+		URL:         signedURL,
+		URLExpires:  urlExpires,
+		ContentType: metadata.ContentType,
+		GeoLocation: metadata.GeoLocation,
+		FileName:    metadata.FileName,
+		StoragePath: metadata.StoragePath,
+	}
+}
+
+// Prefix of cache keys for lookups made with ImageRequest.PublicOnly, so an anonymous caller can
+// never be served an entry cached for an authenticated lookup of a private image.
+const publicCacheKeyPrefix = "public:"
+
+// Prefix of cache keys for ImageRequest.Download lookups, whose URLs carry an attachment
+// disposition and mustn't be served for plain views (or the other way round).
+const downloadCacheKeyPrefix = "download:"
+
+// Signing options for a GetImage URL: with download, GCS serves the file as an attachment
+// named after fileName.
+func imageURLOptions(download bool, fileName string) SignedURLOptions {
+	if !download {
+		return SignedURLOptions{}
+	}
+	return SignedURLOptions{Disposition: mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(fileName)})}
+}
+
 // Retrieves an image by generating a signed URL for direct GCS access.
-// Returns the signed URL, content type, geolocation, and any error encountered.
+// Returns the signed URL, content type, geolocation, staleness, and any error encountered.
+// Staleness is zero unless Firestore was unavailable and a stale fallback was served, in which
+// case it is the age of the cached entry (the URL itself is re-signed when possible).
+// With req.PublicOnly, only public images are found (private ones are ErrNotFound).
 // This approach offloads file serving to GCS, reducing serverless function load.
-func (s *ImageService) GetImage(ctx context.Context, req models.ImageRequest) (string, string, string, error) {
+func (s *ImageService) GetImage(ctx context.Context, req models.ImageRequest) (string, string, string, time.Duration, error) {
 	// Determine cache key - use Id if available, otherwise fileName
 	cacheKey := req.Id
 	if cacheKey == "" {
 		cacheKey = req.FileName
 	}
+	if req.PublicOnly {
+		cacheKey = publicCacheKeyPrefix + cacheKey
+	}
+	if req.Download {
+		cacheKey = downloadCacheKeyPrefix + cacheKey
+	}
+
+	if s.negativeTTL > 0 && s.cache.IsNotFound(cacheKey) {
+		log.Printf("[Image] Cached not found: %s", cacheKey)
+		return "", "", "", 0, fmt.Errorf("failed to get metadata: %w", apperrors.ErrNotFound)
+	}
+
+	// Check cache first for existing signed URL, occasionally confirming GCS still accepts it
+	revalidated := false
+	if entry, ok := s.cache.GetSignedURL(cacheKey); ok && entry.Download == req.Download {
+		if !s.sampleURLCheck() || !s.signedURLBroken(ctx, entry.URL) {
+			log.Printf("[Image] Cache hit: %s", cacheKey)
+			return entry.URL, entry.ContentType, entry.GeoLocation, 0, nil
+		}
+		log.Printf("[Image] Cached signed URL for %s is broken, regenerating", cacheKey)
+		s.cache.Delete(cacheKey)
+		revalidated = true
+	} else {
+		log.Printf("[Image] Cache miss: %s", cacheKey)
+	}
+
+	if req.Id == "" && req.FileName == "" {
+		return "", "", "", 0, fmt.Errorf("%w: either Id or FileName must be provided", apperrors.ErrInvalidInput)
+	}
 
-	// Check cache first for existing signed URL
-	if entry, ok := s.cache.Get(cacheKey); ok {
-		log.Printf("[Image] Cache hit: %s", cacheKey)
-		return entry.SignedURL, entry.ContentType, entry.GeoLocation, nil
+	// Concurrent misses on one key share a single lookup and signing call. The shared call isn't
+	// tied to the first caller's cancellation; each caller stops waiting when its own ctx ends.
+	shared := context.WithoutCancel(ctx)
+	ch := s.lookups.DoChan(cacheKey, func() (interface{}, error) {
+		return s.lookupImage(shared, req, cacheKey)
+	})
+	select {
+	case <-ctx.Done():
+		return "", "", "", 0, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return "", "", "", 0, res.Err
+		}
+		if res.Shared {
+			log.Printf("[Image] Shared lookup: %s", cacheKey)
+		}
+		if revalidated {
+			s.urlRegenerated.Add(1)
+		}
+		lookup := res.Val.(*imageLookup)
+		return lookup.url, lookup.contentType, lookup.geoLocation, lookup.age, nil
 	}
+}
 
+// Resolves an image's metadata, signs its URL and caches it under cacheKey (the GetImage miss
+// path). Falls back to a stale cached URL on a Firestore outage and caches not-found answers.
+func (s *ImageService) lookupImage(ctx context.Context, req models.ImageRequest, cacheKey string) (*imageLookup, error) {
 	// Get metadata from Firestore - use Id lookup if available, otherwise fileName lookup
 	var metadata *models.ImageMetadata
 	var err error
-	if req.Id != "" {
+	switch {
+	case req.Id != "" && req.PublicOnly:
+		metadata, err = s.firestore.GetPublicImageMetadata(ctx, req.Id)
+	case req.Id != "":
 		metadata, err = s.firestore.GetImageMetadata(ctx, req.Id)
-	} else if req.FileName != "" {
-		fileType := ""
-		if len(req.FileName) > 4 {
-			fileType = req.FileName[len(req.FileName)-4:]
-		}
-		metadata, err = s.firestore.GetImageMetadataByFilename(ctx, req.FileName, fileType)
-	} else {
-		return "", "", "", fmt.Errorf("either Id or FileName must be provided")
+	case req.FileName != "" && req.PublicOnly:
+		metadata, err = s.firestore.GetPublicImageMetadataByFilename(ctx, req.FileName, fileTypeOf(req.FileName))
+	default:
+		metadata, err = s.lookupByFileName(ctx, req.FileName)
 	}
 	if err != nil {
-		return "", "", "", fmt.Errorf("failed to get metadata: %w", err)
+		if entry, age, ok := s.staleEntry(cacheKey, err); ok && entry.SignedURL != nil {
+			// Signing is local, so the cached URL only needs to be reused if re-signing fails
+			cached := entry.SignedURL
+			signedURL := cached.URL
+			if fresh, _, signErr := s.storage.GenerateSignedURLWith(ctx, cached.StoragePath, imageURLOptions(req.Download, cached.FileName)); signErr == nil {
+				signedURL = fresh
+			}
+			return &imageLookup{url: signedURL, contentType: cached.ContentType, geoLocation: cached.GeoLocation, age: age}, nil
+		}
+		if errors.Is(err, apperrors.ErrNotFound) {
+			s.cache.SetNotFound(cacheKey, s.negativeTTL)
+		}
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
 	}
 
 	// Generate signed URL for direct GCS access
-	signedURL, err := s.storage.GenerateSignedURL(ctx, metadata.StoragePath)
+	signedURL, urlExpires, err := s.storage.GenerateSignedURLWith(ctx, metadata.StoragePath, imageURLOptions(req.Download, metadata.FileName))
 	if err != nil {
-		return "", "", "", fmt.Errorf("failed to generate signed URL: %w", err)
+		return nil, fmt.Errorf("failed to generate signed URL: %w", err)
 	}
 
 	log.Printf("[Image] Generated signed URL for: %s", metadata.StoragePath)
 
 	// Cache the signed URL using the same key used for lookup
-	s.cache.Set(cacheKey, signedURL, metadata.ContentType, metadata.GeoLocation, metadata.FileName)
+	entry := signedURLEntry(signedURL, urlExpires, metadata)
+	entry.Download = req.Download
+	s.cache.SetSignedURL(cacheKey, entry)
 
-	return signedURL, metadata.ContentType, metadata.GeoLocation, nil
+	return &imageLookup{url: signedURL, contentType: metadata.ContentType, geoLocation: metadata.GeoLocation}, nil
+}
+
+// Returns an orientation-corrected JPEG (at most OpenGraphMaxSize on its longest side) for link
+// previews, plus a version string derived from the original's content hash. The preview is
+// generated on first request (videos use their poster frame) and stored under derived/og/, so
+// later requests and other instances reuse it. Anyone can fetch it, so only public images are
+// found; the rest return ErrNotFound, as if absent (see lookupPublicByFileName).
+func (s *ImageService) OpenGraphImage(ctx context.Context, fileName string) ([]byte, string, error) {
+	metadata, err := s.lookupPublicByFileName(ctx, fileName)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	version := metadata.Id
+	if len(metadata.ContentHash) >= 16 {
+		version = metadata.ContentHash[:16]
+	}
+
+	cacheKey := "og:" + version
+	if entry, ok := s.cache.Get(cacheKey); ok && len(entry.Data) > 0 {
+		return entry.Data, version, nil
+	}
+
+	derivedPath := openGraphPrefix + version + ".jpg"
+	data, err := s.storage.FetchFile(ctx, derivedPath)
+	if err == nil {
+		s.cache.SetBytes(cacheKey, data, "image/jpeg", metadata.FileName)
+		return data, version, nil
+	}
+	if !errors.Is(err, apperrors.ErrNotFound) {
+		return nil, "", fmt.Errorf("failed to fetch preview: %w", err)
+	}
+
+	original, err := s.storage.FetchFile(ctx, metadata.StoragePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch original: %w", err)
+	}
+
+	frame := original
+	switch {
+	case strings.HasPrefix(metadata.ContentType, "video/"):
+		if frame, err = utils.ExtractVideoPoster(original); err != nil {
+			return nil, "", fmt.Errorf("%w: no poster frame for %s: %w", apperrors.ErrUnsupportedMediaType, fileName, err)
+		}
+	case !strings.HasPrefix(metadata.ContentType, "image/"):
+		return nil, "", fmt.Errorf("%w: cannot preview %s", apperrors.ErrUnsupportedMediaType, metadata.ContentType)
+	}
+
+	data, err = utils.RenderPreviewJPEG(frame, OpenGraphMaxSize)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to render preview for %s: %w", fileName, err)
+	}
+
+	// A failed upload only costs a regeneration next time, so still serve the preview
+	uploadOptions := UploadOptions{
+		CacheControl: CacheControlFor(derivedPath),
+		Metadata:     map[string]string{"uploaded-by": "trekka-api", "source-id": metadata.Id},
+	}
+	if err := s.storage.UploadFile(ctx, derivedPath, data, "image/jpeg", uploadOptions); err != nil {
+		log.Printf("[Image] Failed to store preview %s: %v", derivedPath, err)
+	} else {
+		log.Printf("[Image] Generated preview %s for %s", derivedPath, fileName)
+	}
+	s.cache.SetBytes(cacheKey, data, "image/jpeg", metadata.FileName)
+
+	return data, version, nil
+}
+
+// Resolves signed URLs for several images at once, for galleries that would otherwise call
+// GetImage per file. Cached entries are used as-is; the rest are looked up with batched
+// Firestore "in" queries and signed concurrently by a bounded worker pool, then cached like
+// GetImage. Missing or unsignable files get an entry with Error set instead of failing the batch;
+// only a failed Firestore query or more than MaxBatchURLs names fail the whole call.
+func (s *ImageService) SignedURLs(ctx context.Context, fileNames []string) (map[string]*models.SignedImageURL, error) {
+	if len(fileNames) > MaxBatchURLs {
+		return nil, fmt.Errorf("%w: at most %d filenames per request", apperrors.ErrInvalidInput, MaxBatchURLs)
+	}
+
+	results := make(map[string]*models.SignedImageURL, len(fileNames))
+	var misses []string
+	for _, name := range fileNames {
+		if _, seen := results[name]; seen {
+			continue
+		}
+		if entry, ok := s.cache.GetSignedURL(name); ok {
+			results[name] = &models.SignedImageURL{SignedURL: entry.URL, ContentType: entry.ContentType, GeoLocation: entry.GeoLocation}
+			continue
+		}
+		results[name] = &models.SignedImageURL{Error: "not found"}
+		misses = append(misses, name)
+	}
+	if len(misses) == 0 {
+		return results, nil
+	}
+
+	found, err := s.firestore.GetImageMetadataByFilenames(ctx, misses)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchSignWorkers)
+	for name, metadata := range found {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			entry := &models.SignedImageURL{ContentType: metadata.ContentType, GeoLocation: metadata.GeoLocation}
+			signedURL, urlExpires, err := s.storage.GenerateSignedURL(ctx, metadata.StoragePath)
+			if err != nil {
+				log.Printf("[Image] Failed to sign %s in batch: %v", metadata.StoragePath, err)
+				entry = &models.SignedImageURL{Error: "failed to generate signed URL"}
+			} else {
+				entry.SignedURL = signedURL
+				s.cache.SetSignedURL(name, signedURLEntry(signedURL, urlExpires, metadata))
+			}
+
+			mu.Lock()
+			results[name] = entry
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// Signs and caches the URLs of the newest count images (by takenAt), as GetImage would on a
+// first request by file name, so the first page loads after a deploy are cache hits. Public
+// images are also cached under their public-mode key. Images already cached are skipped.
+// Returns how many were signed.
+func (s *ImageService) WarmCache(ctx context.Context, count int) (int, error) {
+	if count <= 0 {
+		return 0, nil
+	}
+
+	images, err := s.firestore.ListImageMetadata(ctx, count, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	var warmed atomic.Int64
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchSignWorkers)
+	for _, metadata := range images {
+		if metadata.FileName == "" || metadata.StoragePath == "" {
+			continue
+		}
+		if _, ok := s.cache.GetSignedURL(metadata.FileName); ok {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			signedURL, urlExpires, err := s.storage.GenerateSignedURL(ctx, metadata.StoragePath)
+			if err != nil {
+				log.Printf("[Image] Failed to sign %s during warm-up: %v", metadata.StoragePath, err)
+				return
+			}
+			entry := signedURLEntry(signedURL, urlExpires, metadata)
+			s.cache.SetSignedURL(metadata.FileName, entry)
+			if metadata.Visibility == models.VisibilityPublic {
+				s.cache.SetSignedURL(publicCacheKeyPrefix+metadata.FileName, entry)
+			}
+			warmed.Add(1)
+		}()
+	}
+	wg.Wait()
+
+	return int(warmed.Load()), ctx.Err()
+}
+
+// Retrieves a resized rendition of an image, generating and caching it on first request.
+// Returns the encoded bytes and their content type. Widths above MaxThumbnailWidth are capped,
+// and widths at or above the source width short-circuit to the original bytes.
+// When preferWebP is set and WebP is enabled, JPEG and still GIF renditions are transcoded to
+// WebP (cached separately); animated GIFs are passed through untouched, and anything that fails
+// to transcode is served in its own format.
+func (s *ImageService) GetThumbnail(ctx context.Context, fileName string, width int, preferWebP bool) ([]byte, string, error) {
+	if width <= 0 {
+		return nil, "", fmt.Errorf("%w: width must be positive", apperrors.ErrInvalidInput)
+	}
+	if width > MaxThumbnailWidth {
+		width = MaxThumbnailWidth
+	}
+
+	preferWebP = preferWebP && s.webp
+	format := "orig"
+	if preferWebP {
+		format = "webp"
+	}
+
+	cacheKey := fmt.Sprintf("thumb:%s:%d:%s", fileName, width, format)
+	if entry, ok := s.cache.Get(cacheKey); ok && len(entry.Data) > 0 {
+		log.Printf("[Image] Thumbnail cache hit: %s", cacheKey)
+		return entry.Data, entry.ContentType, nil
+	}
+
+	metadata, err := s.lookupByFileName(ctx, fileName)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	if !strings.HasPrefix(metadata.ContentType, "image/") {
+		return nil, "", fmt.Errorf("%w: cannot resize %s", apperrors.ErrUnsupportedMediaType, metadata.ContentType)
+	}
+
+	original, err := s.storage.FetchFile(ctx, metadata.StoragePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch original: %w", err)
+	}
+
+	// Animated GIFs would lose their frames when resized, so pass them through untouched
+	animated := metadata.ContentType == "image/gif" && utils.IsAnimatedGIF(original)
+	data, contentType := original, metadata.ContentType
+	if !animated {
+		data, contentType, err = utils.ResizeImage(original, metadata.ContentType, width)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to resize %s: %w", fileName, err)
+		}
+	}
+
+	// The resized rendition before any WebP transcode, which the dominant color can be read from
+	var rendition []byte
+	if !animated && !bytes.Equal(data, original) {
+		rendition = data
+	}
+
+	if preferWebP && !animated && (metadata.ContentType == "image/jpeg" || metadata.ContentType == "image/gif") {
+		if webp, err := utils.EncodeWebP(data, 80); err != nil {
+			log.Printf("[Image] WebP transcode failed for %s, serving JPEG: %v", fileName, err)
+		} else {
+			data, contentType = webp, "image/webp"
+		}
+	}
+
+	// Backfill a missing dominant color from the downscaled rendition (never a full-size original),
+	// off the request path
+	if metadata.DominantColor == "" && metadata.Id != "" && rendition != nil {
+		go s.storeDominantColor(metadata.Id, fileName, rendition)
+	}
+
+	log.Printf("[Image] Generated %dpx %s thumbnail for %s (%d -> %d bytes)", width, contentType, fileName, len(original), len(data))
+
+	s.cache.SetBytes(cacheKey, data, contentType, metadata.FileName)
+
+	return data, contentType, nil
+}
+
+// How long storing a dominant color computed by GetThumbnail may take.
+const dominantColorWriteTimeout = 10 * time.Second
+
+// Computes the dominant color of a thumbnail rendition and stores it on the image's document.
+// Runs in the background so thumbnail requests don't wait on Firestore; failures are only logged.
+func (s *ImageService) storeDominantColor(id, fileName string, rendition []byte) {
+	color, err := utils.DominantColorFromBytes(rendition)
+	if err != nil || color == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dominantColorWriteTimeout)
+	defer cancel()
+	if err := s.firestore.SetDominantColor(ctx, id, color); err != nil {
+		log.Printf("[Image] Failed to store dominant color for %s: %v", fileName, err)
+	}
 }
 
 // ListImages retrieves a list of image metadata from Firestore.
-func (s *ImageService) ListImages(ctx context.Context, limit int, page int) ([]*models.ImageMetadata, error) {
-	return s.firestore.ListImageMetadata(ctx, limit, page)
+// With stale fallback enabled, each page is also cached so it can be served (with a non-zero
+// staleness, the age of the cached page) while Firestore is unavailable.
+func (s *ImageService) ListImages(ctx context.Context, limit int, page int) ([]*models.ImageMetadata, time.Duration, error) {
+	cacheKey := fmt.Sprintf("list:%d:%d", limit, page)
+
+	images, err := s.firestore.ListImageMetadata(ctx, limit, page)
+	if err != nil {
+		if entry, age, ok := s.staleEntry(cacheKey, err); ok {
+			var cached []*models.ImageMetadata
+			if jsonErr := json.Unmarshal(entry.Data, &cached); jsonErr == nil {
+				return cached, age, nil
+			}
+		}
+		return nil, 0, err
+	}
+
+	if s.staleFallback {
+		if data, err := json.Marshal(images); err == nil {
+			s.cache.SetBytes(cacheKey, data, "application/json", "")
+		}
+	}
+
+	return images, 0, nil
+}
+
+// Picks a uniformly random image, optionally restricted to a country (case-insensitive)
+// and/or year of takenAt (0 for any). Returns the document ID for use with GetImage.
+// Candidates come from an index of (id, country, year) rebuilt every randomIndexTTL, so
+// picks don't scan the collection. Returns ErrNotFound when nothing matches.
+func (s *ImageService) RandomImageID(ctx context.Context, country string, year int) (string, error) {
+	index, err := s.loadRandomIndex(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	pool := index
+	if country != "" || year != 0 {
+		pool = make([]randomCandidate, 0, len(index))
+		for _, c := range index {
+			if country != "" && !strings.EqualFold(c.country, country) {
+				continue
+			}
+			if year != 0 && c.year != year {
+				continue
+			}
+			pool = append(pool, c)
+		}
+	}
+
+	if len(pool) == 0 {
+		return "", fmt.Errorf("%w: no images match the filters", apperrors.ErrNotFound)
+	}
+
+	return pool[rand.IntN(len(pool))].id, nil
+}
+
+// Returns the random-pick index, rebuilding it when it has expired.
+func (s *ImageService) loadRandomIndex(ctx context.Context) ([]randomCandidate, error) {
+	s.randomMu.Lock()
+	defer s.randomMu.Unlock()
+
+	if s.randomIndex != nil && time.Now().Before(s.randomExpires) {
+		return s.randomIndex, nil
+	}
+
+	var index []randomCandidate
+	err := s.ForEachImage(ctx, func(img *models.ImageMetadata) error {
+		c := randomCandidate{id: img.Id, country: utils.CountryFromGeoLocation(img.GeoLocation)}
+		if !img.TakenAt.IsZero() {
+			c.year = img.TakenAt.Year()
+		}
+		index = append(index, c)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build random index: %w", err)
+	}
+
+	s.randomIndex = index
+	s.randomExpires = time.Now().Add(randomIndexTTL)
+	log.Printf("[Image] Built random index with %d images", len(index))
+
+	return index, nil
+}
+
+// Cache key for the computed collection statistics.
+const statsCacheKey = "stats:images"
+
+// Computes collection statistics by iterating every document, caching the result for the
+// cache TTL. refresh bypasses (and replaces) the cached copy.
+func (s *ImageService) GetStats(ctx context.Context, refresh bool) (*models.ImageStats, error) {
+	if !refresh {
+		if entry, ok := s.cache.Get(statsCacheKey); ok && len(entry.Data) > 0 {
+			var stats models.ImageStats
+			if err := json.Unmarshal(entry.Data, &stats); err == nil {
+				log.Printf("[Image] Stats cache hit")
+				return &stats, nil
+			}
+		}
+	}
+
+	stats := &models.ImageStats{
+		ByCountry: make(map[string]int),
+		ByYear:    make(map[string]int),
+	}
+
+	err := s.ForEachImage(ctx, func(img *models.ImageMetadata) error {
+		stats.Total++
+		if strings.HasPrefix(img.ContentType, "video/") {
+			stats.Videos++
+		} else {
+			stats.Photos++
+		}
+
+		country := utils.CountryFromGeoLocation(img.GeoLocation)
+		if country == "" {
+			country = "Unknown"
+		}
+		stats.ByCountry[country]++
+
+		year := "Unknown"
+		if !img.TakenAt.IsZero() {
+			year = strconv.Itoa(img.TakenAt.Year())
+		}
+		stats.ByYear[year]++
+
+		if utils.HasEmptyFields(img) {
+			stats.Missing.Incomplete++
+		}
+		if img.Coordinates.Lat == "" || img.Coordinates.Lng == "" {
+			stats.Missing.Coordinates++
+		}
+		if img.GeoLocation == "" {
+			stats.Missing.GeoLocation++
+		}
+		if img.TakenAt.IsZero() {
+			stats.Missing.TakenAt++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute stats: %w", err)
+	}
+	stats.GeneratedAt = time.Now().UTC()
+
+	if data, err := json.Marshal(stats); err == nil {
+		s.cache.SetBytes(statsCacheKey, data, "application/json", "")
+	}
+
+	return stats, nil
+}
+
+// Finds images whose file name starts with prefix (case-insensitively). See SearchImageMetadataByPrefix.
+func (s *ImageService) SearchImages(ctx context.Context, prefix string, limit int, page int) ([]*models.ImageMetadata, error) {
+	return s.firestore.SearchImageMetadataByPrefix(ctx, prefix, limit, page)
+}
+
+// Lists images matching filter. See ListImageMetadataFiltered.
+func (s *ImageService) ListImagesFiltered(ctx context.Context, filter models.ImageFilter, limit int, page int) ([]*models.ImageMetadata, error) {
+	return s.firestore.ListImageMetadataFiltered(ctx, filter, limit, page)
+}
+
+// Longest description accepted by UpdateImageMetadata, in characters.
+const MaxDescriptionLength = 2000
+
+// Retrieves one image's metadata by document ID (or legacy ID).
+func (s *ImageService) GetImageMetadata(ctx context.Context, id string) (*models.ImageMetadata, error) {
+	return s.firestore.GetImageMetadata(ctx, id)
+}
+
+// Applies the user-editable fields of patch to an image as field-level updates, evicts its cached
+// entries (including the public ones) and returns the updated metadata. id may be a legacy ID.
+func (s *ImageService) UpdateImageMetadata(ctx context.Context, id string, patch models.ImageMetadataPatch) (*models.ImageMetadata, error) {
+	if patch.Description != nil {
+		description := strings.TrimSpace(*patch.Description)
+		if utf8.RuneCountInString(description) > MaxDescriptionLength {
+			return nil, fmt.Errorf("%w: description longer than %d characters", apperrors.ErrInvalidInput, MaxDescriptionLength)
+		}
+		patch.Description = &description
+	}
+	if patch.Visibility != nil && *patch.Visibility != models.VisibilityPublic && *patch.Visibility != models.VisibilityPrivate {
+		return nil, fmt.Errorf("%w: visibility must be %q or %q", apperrors.ErrInvalidInput, models.VisibilityPublic, models.VisibilityPrivate)
+	}
+
+	metadata, err := s.firestore.GetImageMetadata(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if patch.Description == nil && patch.Visibility == nil {
+		return metadata, nil
+	}
+
+	if err := s.firestore.ApplyMetadataPatch(ctx, metadata.Id, patch); err != nil {
+		return nil, err
+	}
+	if patch.Description != nil {
+		metadata.Description = *patch.Description
+		metadata.HasDescription = *patch.Description != ""
+	}
+	if patch.Visibility != nil {
+		metadata.Visibility = *patch.Visibility
+	}
+	metadata.UpdatedAt = time.Now()
+
+	s.EvictImage(id, metadata.Id, metadata.FileName)
+
+	return metadata, nil
+}
+
+// Re-extracts an image's metadata from its stored file and merges it into the document the way
+// ExtractAndPersistMetadata does (fields the file no longer yields are kept). Returns the changed
+// fields and the merged record. Unless dryRun, a changed record is written conditionally on the
+// revision read (ErrConflict if it moved) and the image's cached entries are evicted; an
+// unchanged record is not written. id may be a legacy ID.
+func (s *ImageService) RefreshMetadata(ctx context.Context, id string, dryRun bool) (*models.MetadataRefresh, error) {
+	metadata, err := s.firestore.GetImageMetadata(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.storage.FetchFile(ctx, metadata.StoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", metadata.StoragePath, err)
+	}
+
+	extracted, err := ExtractMetadataFromBytes(ctx, metadata.FileName, metadata.ContentType, data, s.geocoder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract metadata from %s: %w", metadata.FileName, err)
+	}
+
+	merged := *metadata
+	mergeExtracted(&merged, extracted, "", time.Now())
+	refresh := &models.MetadataRefresh{
+		Id:       metadata.Id,
+		FileName: metadata.FileName,
+		DryRun:   dryRun,
+		Changes:  diffExtracted(metadata, &merged),
+		Metadata: &merged,
+	}
+
+	if len(refresh.Changes) == 0 {
+		refresh.Metadata = metadata
+		return refresh, nil
+	}
+	if dryRun {
+		return refresh, nil
+	}
+
+	if err := s.firestore.ReplaceImageMetadataAt(ctx, metadata.Id, &merged, metadata.Revision); err != nil {
+		return nil, err
+	}
+	refresh.Updated = true
+	s.EvictImage(id, metadata.Id, metadata.FileName)
+
+	return refresh, nil
+}
+
+// Sets an image's favorite flag, or toggles it when favorite is nil, and evicts the image's cached
+// entries so the next read reflects it. id may be a legacy ID. Returns the document ID and new value.
+func (s *ImageService) SetFavorite(ctx context.Context, id string, favorite *bool) (string, bool, error) {
+	metadata, err := s.firestore.GetImageMetadata(ctx, id)
+	if err != nil {
+		return "", false, err
+	}
+
+	value, err := s.firestore.SetFavorite(ctx, metadata.Id, favorite)
+	if err != nil {
+		return "", false, err
+	}
+
+	s.EvictImage(id, metadata.Id, metadata.FileName)
+
+	return metadata.Id, value, nil
+}
+
+// Largest file UploadImage accepts. Uploads are held in memory for conversion and extraction,
+// like the files FetchFile loads.
+const MaxUploadSize = maxFetchSize
+
+// Stores an uploaded image or video and creates its document, the way a Drive sync would: HEIC is
+// converted to JPEG, metadata is extracted and geocoded, and the file is stored where the storage
+// path strategy puts it. An empty contentType (or application/octet-stream) is inferred from the
+// name and content. Anything other than an image or video fails with ErrUnsupportedMediaType. A
+// name (after conversion) already in use, or content already stored under another name, fails with
+// ErrConflict and nothing is written. Entries cached for the name, such as a not-found tombstone,
+// are evicted.
+func (s *ImageService) UploadImage(ctx context.Context, fileName, contentType string, data []byte) (*models.ImageMetadata, error) {
+	fileName = strings.TrimSpace(fileName)
+	if fileName == "" || strings.ContainsAny(fileName, `/\`) || fileName == "." || fileName == ".." {
+		return nil, fmt.Errorf("%w: invalid file name %q", apperrors.ErrInvalidInput, fileName)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: %s is empty", apperrors.ErrInvalidInput, fileName)
+	}
+	if len(data) > MaxUploadSize {
+		return nil, fmt.Errorf("%w: %s is %d bytes, over the %d byte limit", apperrors.ErrTooLarge, fileName, len(data), MaxUploadSize)
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType != "application/octet-stream" {
+		contentType = mediaType
+	} else if byExt := mime.TypeByExtension(path.Ext(fileName)); byExt != "" {
+		contentType, _, _ = mime.ParseMediaType(byExt)
+	} else {
+		contentType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	if !strings.HasPrefix(contentType, "image/") && !strings.HasPrefix(contentType, "video/") {
+		return nil, fmt.Errorf("%w: %s is %s, not an image or video", apperrors.ErrUnsupportedMediaType, fileName, contentType)
+	}
+
+	finalName, finalMime := fileName, contentType
+	if utils.IsHeifLike(contentType) {
+		jpeg, err := utils.ConvertHeicToJpeg(data)
+		if err != nil {
+			log.Printf("[Upload] HEIC conversion failed for %s: %v — storing the original", fileName, err)
+		} else {
+			data, finalMime = jpeg, "image/jpeg"
+			finalName = strings.TrimSuffix(fileName, path.Ext(fileName)) + ".jpg"
+		}
+	}
+
+	existing, err := s.firestore.GetImageMetadataByFilename(ctx, finalName, "")
+	switch {
+	case err == nil:
+		return nil, fmt.Errorf("%w: %s already exists as %s", apperrors.ErrConflict, finalName, existing.Id)
+	case !errors.Is(err, apperrors.ErrNotFound):
+		return nil, fmt.Errorf("lookup existing metadata failed: %w", err)
+	}
+
+	metadata, err := extractMetadata(ctx, s.firestore, s.geocoder, finalName, finalMime, mediaSource{data: data}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if id := s.firestore.deterministicID(metadata); id != "" {
+		if same, err := s.firestore.GetImageMetadata(ctx, id); err == nil {
+			return nil, fmt.Errorf("%w: same content already stored as %s (%s)", apperrors.ErrConflict, same.Id, same.FileName)
+		} else if !errors.Is(err, apperrors.ErrNotFound) {
+			return nil, fmt.Errorf("lookup existing metadata failed: %w", err)
+		}
+	}
+
+	now := time.Now()
+	metadata.StoragePath = s.storagePaths(finalName, metadata.TakenAt)
+	metadata.CreatedAt, metadata.UpdatedAt = now, now
+	if metadata.TakenAt.IsZero() {
+		metadata.TakenAt = now
+	}
+
+	options := UploadOptions{CacheControl: CacheControlFor(metadata.StoragePath)}
+	if err := s.storage.UploadFile(ctx, metadata.StoragePath, data, finalMime, options); err != nil {
+		return nil, fmt.Errorf("upload to storage failed: %w", err)
+	}
+
+	id, err := s.firestore.CreateImageMetadata(ctx, metadata)
+	if err != nil {
+		// A conflict here is a concurrent upload of the same file, whose document now points at the
+		// object just written; otherwise nothing does, so it goes
+		if !errors.Is(err, apperrors.ErrConflict) {
+			if _, delErr := s.storage.DeleteFileIfExists(context.WithoutCancel(ctx), metadata.StoragePath); delErr != nil {
+				log.Printf("[Upload] Failed to remove %s after its metadata wasn't written: %v", metadata.StoragePath, delErr)
+			}
+		}
+		return nil, err
+	}
+	metadata.Id = id
+
+	s.EvictImage(fileName, finalName, id)
+	log.Printf("[Upload] Stored %s as %s (%s)", fileName, metadata.StoragePath, id)
+
+	return metadata, nil
+}
+
+// Deletes an image: its document (and its place in any album), then its stored file, evicting its
+// cached entries. id may be a legacy ID. The document goes first, so a failure to delete the file
+// leaves an orphaned object for reconciliation rather than a document pointing at nothing. A file
+// still in the synced Drive folder comes back with the next backfill.
+func (s *ImageService) DeleteImage(ctx context.Context, id string) error {
+	metadata, err := s.firestore.GetImageMetadata(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.firestore.DeleteImageMetadata(ctx, metadata.Id); err != nil {
+		return err
+	}
+	s.EvictImage(id, metadata.Id, metadata.FileName)
+
+	if metadata.StoragePath != "" {
+		if _, err := s.storage.DeleteFileIfExists(ctx, metadata.StoragePath); err != nil {
+			return fmt.Errorf("metadata deleted but deleting %s failed: %w", metadata.StoragePath, err)
+		}
+	}
+	log.Printf("[Images] Deleted %s (%s)", metadata.Id, metadata.StoragePath)
+
+	return nil
+}
+
+// Evicts an image's cached entries under each of the keys it can be requested by (the ID given
+// by the caller, its document ID and its file name), including the public-mode and download copies.
+func (s *ImageService) EvictImage(keys ...string) {
+	for _, key := range keys {
+		for _, variant := range []string{key, publicCacheKeyPrefix + key} {
+			s.cache.Delete(variant)
+			s.cache.Delete(downloadCacheKeyPrefix + variant)
+		}
+	}
+}
+
+// Cache key for the computed location tree.
+const locationTreeCacheKey = "stats:locations"
+
+// Builds the country → region → city tree with image counts by iterating every document,
+// caching it like GetStats. Countries are keyed by code where known so differently spelled
+// names merge; quarantined images are left out.
+func (s *ImageService) LocationTree(ctx context.Context, refresh bool) (*models.LocationTree, error) {
+	if !refresh {
+		if entry, ok := s.cache.Get(locationTreeCacheKey); ok && len(entry.Data) > 0 {
+			var tree models.LocationTree
+			if err := json.Unmarshal(entry.Data, &tree); err == nil {
+				log.Printf("[Image] Location tree cache hit")
+				return &tree, nil
+			}
+		}
+	}
+
+	tree := &models.LocationTree{Countries: []*models.LocationNode{}}
+	type level struct {
+		node     *models.LocationNode
+		children map[string]*level
+	}
+	child := func(parent map[string]*level, key, name string) *level {
+		l, ok := parent[key]
+		if !ok {
+			l = &level{node: &models.LocationNode{Name: name}, children: make(map[string]*level)}
+			parent[key] = l
+		}
+		l.node.Count++
+		return l
+	}
+	countries := make(map[string]*level)
+
+	err := s.ForEachImage(ctx, func(img *models.ImageMetadata) error {
+		if img.Hidden() {
+			return nil
+		}
+		tree.Total++
+		if img.Country == "" && img.CountryCode == "" {
+			tree.Unlocated++
+			return nil
+		}
+
+		key := img.CountryCode
+		if key == "" {
+			key = strings.ToLower(img.Country)
+		}
+		country := child(countries, key, firstNonEmpty(img.Country, img.CountryCode))
+		if img.CountryCode != "" {
+			country.node.Code = img.CountryCode
+		}
+		if img.Region == "" {
+			return nil
+		}
+		region := child(country.children, img.Region, img.Region)
+		if img.City != "" {
+			child(region.children, img.City, img.City)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build location tree: %w", err)
+	}
+
+	var flatten func(levels map[string]*level) []*models.LocationNode
+	flatten = func(levels map[string]*level) []*models.LocationNode {
+		nodes := make([]*models.LocationNode, 0, len(levels))
+		for _, l := range levels {
+			if len(l.children) > 0 {
+				l.node.Children = flatten(l.children)
+			}
+			nodes = append(nodes, l.node)
+		}
+		sort.Slice(nodes, func(i, j int) bool {
+			if nodes[i].Count != nodes[j].Count {
+				return nodes[i].Count > nodes[j].Count
+			}
+			return nodes[i].Name < nodes[j].Name
+		})
+		return nodes
+	}
+	tree.Countries = flatten(countries)
+	tree.GeneratedAt = time.Now().UTC()
+
+	if data, err := json.Marshal(tree); err == nil {
+		s.cache.SetBytes(locationTreeCacheKey, data, "application/json", "")
+	}
+
+	return tree, nil
+}
+
+// Cache key for the computed countries-visited summary.
+const countriesCacheKey = "stats:countries"
+
+// Summarises the countries images were taken in, with counts and first/last takenAt, by iterating
+// every document and caching the result like GetStats. The country comes from the stored
+// country fields, falling back to GeoLocation for documents not yet reverse geocoded into
+// them; images with neither are only counted as unlocated. Quarantined images are left out.
+func (s *ImageService) CountriesVisited(ctx context.Context, refresh bool) (*models.CountriesVisited, error) {
+	if !refresh {
+		if entry, ok := s.cache.Get(countriesCacheKey); ok && len(entry.Data) > 0 {
+			var visited models.CountriesVisited
+			if err := json.Unmarshal(entry.Data, &visited); err == nil {
+				log.Printf("[Image] Countries cache hit")
+				return &visited, nil
+			}
+		}
+	}
+
+	visited := &models.CountriesVisited{Countries: []*models.CountryVisit{}}
+	countries := make(map[string]*models.CountryVisit)
+
+	err := s.ForEachImage(ctx, func(img *models.ImageMetadata) error {
+		if img.Hidden() {
+			return nil
+		}
+		name := firstNonEmpty(img.Country, utils.CountryFromGeoLocation(img.GeoLocation), img.CountryCode)
+		if name == "" {
+			visited.Unlocated++
+			return nil
+		}
+		visited.Total++
+
+		key := img.CountryCode
+		if key == "" {
+			key = strings.ToLower(name)
+		}
+		country, ok := countries[key]
+		if !ok {
+			country = &models.CountryVisit{Name: name}
+			countries[key] = country
+		}
+		if img.CountryCode != "" {
+			country.Code = img.CountryCode
+		}
+		country.Count++
+		if !img.TakenAt.IsZero() {
+			if country.FirstTakenAt.IsZero() || img.TakenAt.Before(country.FirstTakenAt) {
+				country.FirstTakenAt = img.TakenAt
+			}
+			if img.TakenAt.After(country.LastTakenAt) {
+				country.LastTakenAt = img.TakenAt
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarise countries: %w", err)
+	}
+
+	for _, country := range countries {
+		visited.Countries = append(visited.Countries, country)
+	}
+	sort.Slice(visited.Countries, func(i, j int) bool {
+		a, b := visited.Countries[i], visited.Countries[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Name < b.Name
+	})
+	visited.GeneratedAt = time.Now().UTC()
+
+	if data, err := json.Marshal(visited); err == nil {
+		s.cache.SetBytes(countriesCacheKey, data, "application/json", "")
+	}
+
+	return visited, nil
+}
+
+// Generates a signed GCS URL for an image's stored object without touching the cache.
+func (s *ImageService) SignedURL(ctx context.Context, metadata *models.ImageMetadata) (string, error) {
+	signedURL, _, err := s.storage.GenerateSignedURL(ctx, metadata.StoragePath)
+	return signedURL, err
+}
+
+// Calls fn for every image in the collection, paging through Firestore internally.
+func (s *ImageService) ForEachImage(ctx context.Context, fn func(*models.ImageMetadata) error) error {
+	return s.firestore.ForEachImageMetadata(ctx, 500, fn)
+}
+
+// Finds images taken within radiusMeters of lat/lng, nearest first, up to limit (0 for all).
+// Candidates come from geohash prefix queries covering the circle; exact distances are then
+// computed with the haversine formula to filter and sort. Images without a geohash (no
+// coordinates, or not yet backfilled) are never returned.
+// Returns ErrInvalidInput for invalid coordinates or a radius outside (0, max].
+func (s *ImageService) NearbyImages(ctx context.Context, lat, lng, radiusMeters float64, limit int) ([]*models.NearbyImage, error) {
+	if !utils.ValidLatLng(lat, lng) {
+		return nil, fmt.Errorf("%w: coordinates out of range", apperrors.ErrInvalidInput)
+	}
+	if radiusMeters <= 0 || radiusMeters > s.nearMaxRadius {
+		return nil, fmt.Errorf("%w: radius must be between 0 and %.0f metres", apperrors.ErrInvalidInput, s.nearMaxRadius)
+	}
+
+	var nearby []*models.NearbyImage
+	for _, prefix := range utils.GeohashCoverage(lat, lng, radiusMeters) {
+		candidates, err := s.firestore.ListImageMetadataByGeohashPrefix(ctx, prefix, nearPrefixLimit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query nearby images: %w", err)
+		}
+		if len(candidates) == nearPrefixLimit {
+			log.Printf("[Image] Geohash prefix %s hit the %d document limit; results may be incomplete", prefix, nearPrefixLimit)
+		}
+
+		for _, img := range candidates {
+			imgLat, imgLng, ok := utils.ParseCoordinates(img.Coordinates)
+			if !ok {
+				continue
+			}
+			distance := utils.HaversineMeters(lat, lng, imgLat, imgLng)
+			if distance <= radiusMeters {
+				nearby = append(nearby, &models.NearbyImage{ImageMetadata: img, DistanceMeters: math.Round(distance)})
+			}
+		}
+	}
+
+	sort.Slice(nearby, func(i, j int) bool {
+		return nearby[i].DistanceMeters < nearby[j].DistanceMeters
+	})
+	if limit > 0 && len(nearby) > limit {
+		nearby = nearby[:limit]
+	}
+
+	return nearby, nil
+}
+
+// Groups geotagged images inside a bounding box into clusters sized for the map zoom level
+// (see utils.GeohashPrecisionForZoom), ordered by count (most first). Each cluster has the
+// centroid of its members and the most recently taken photo as its representative.
+// A box with minLng > maxLng crosses the antimeridian. Results are cached for clusterCacheTTL.
+func (s *ImageService) Clusters(ctx context.Context, minLat, minLng, maxLat, maxLng float64, zoom int) ([]*models.Cluster, error) {
+	if !utils.ValidLatLng(minLat, minLng) || !utils.ValidLatLng(maxLat, maxLng) || minLat > maxLat {
+		return nil, fmt.Errorf("%w: invalid bounding box", apperrors.ErrInvalidInput)
+	}
+	if zoom < 0 || zoom > 22 {
+		return nil, fmt.Errorf("%w: zoom must be between 0 and 22", apperrors.ErrInvalidInput)
+	}
+
+	cacheKey := fmt.Sprintf("clusters:%.4f,%.4f,%.4f,%.4f:%d", minLat, minLng, maxLat, maxLng, zoom)
+	if entry, ok := s.cache.Get(cacheKey); ok && len(entry.Data) > 0 {
+		var clusters []*models.Cluster
+		if err := json.Unmarshal(entry.Data, &clusters); err == nil {
+			return clusters, nil
+		}
+	}
+
+	precision := utils.GeohashPrecisionForZoom(zoom)
+	inBox := func(lat, lng float64) bool {
+		if lat < minLat || lat > maxLat {
+			return false
+		}
+		if minLng <= maxLng {
+			return lng >= minLng && lng <= maxLng
+		}
+		return lng >= minLng || lng <= maxLng
+	}
+
+	type accumulator struct {
+		cluster  *models.Cluster
+		sumLat   float64
+		sumLng   float64
+		latestAt time.Time
+	}
+	byHash := make(map[string]*accumulator)
+	clusters := make([]*models.Cluster, 0)
+
+	err := s.firestore.ForEachImageMetadata(ctx, 500, func(img *models.ImageMetadata) error {
+		lat, lng, ok := utils.ParseCoordinates(img.Coordinates)
+		if !ok || !inBox(lat, lng) {
+			return nil
+		}
+
+		hash := utils.Geohash(lat, lng, precision)
+		acc, ok := byHash[hash]
+		if !ok {
+			acc = &accumulator{cluster: &models.Cluster{Geohash: hash}}
+			byHash[hash] = acc
+			clusters = append(clusters, acc.cluster)
+		}
+		acc.cluster.Count++
+		acc.sumLat += lat
+		acc.sumLng += lng
+
+		if acc.cluster.Representative == "" || img.TakenAt.After(acc.latestAt) {
+			acc.latestAt = img.TakenAt
+			acc.cluster.Representative = img.FileName
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan images: %w", err)
+	}
+
+	for _, acc := range byHash {
+		acc.cluster.Lat = acc.sumLat / float64(acc.cluster.Count)
+		acc.cluster.Lng = acc.sumLng / float64(acc.cluster.Count)
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].Count > clusters[j].Count
+	})
+
+	if data, err := json.Marshal(clusters); err == nil {
+		s.cache.SetBytesWithTTL(cacheKey, data, "application/json", "", clusterCacheTTL)
+	}
+
+	return clusters, nil
+}
+
+// Groups images by PlaceKey into distinct places, ordered by photo count (most first).
+// Each place carries the most recently taken photo as its representative. Images without a
+// PlaceKey (no coordinates, or not yet backfilled) and quarantined ones are left out. The whole
+// collection is read a page at a time, so documents without createdAt count too.
+func (s *ImageService) ListPlaces(ctx context.Context) ([]*models.Place, error) {
+	byKey := make(map[string]*models.Place)
+	latest := make(map[string]*models.ImageMetadata)
+	places := make([]*models.Place, 0)
+	err := s.firestore.ForEachImageMetadata(ctx, 500, func(img *models.ImageMetadata) error {
+		if img.PlaceKey == "" || img.Hidden() {
+			return nil
+		}
+
+		place, ok := byKey[img.PlaceKey]
+		if !ok {
+			place = &models.Place{PlaceKey: img.PlaceKey}
+			byKey[img.PlaceKey] = place
+			places = append(places, place)
+		}
+		place.Count++
+
+		if rep := latest[img.PlaceKey]; rep == nil || img.TakenAt.After(rep.TakenAt) {
+			latest[img.PlaceKey] = img
+			place.Representative = img.FileName
+			place.Coordinates = img.Coordinates
+		}
+		if place.GeoLocation == "" {
+			place.GeoLocation = img.GeoLocation
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	sort.SliceStable(places, func(i, j int) bool {
+		return places[i].Count > places[j].Count
+	})
+
+	return places, nil
+}
+
+// Looks up metadata by filename, passing the extension through so HEIC names also find their JPEG
+// conversions. Quarantined and deleted images are ErrNotFound, as they are left out of listings.
+func (s *ImageService) lookupByFileName(ctx context.Context, fileName string) (*models.ImageMetadata, error) {
+	return s.firestore.GetVisibleImageMetadataByFilename(ctx, fileName, fileTypeOf(fileName))
+}
+
+// Looks up an image for an anonymous caller: only documents with VisibilityPublic are read (the
+// condition is part of the query), and quarantined or deleted ones are ErrNotFound too, so
+// callers can't tell a private image from a missing one.
+func (s *ImageService) lookupPublicByFileName(ctx context.Context, fileName string) (*models.ImageMetadata, error) {
+	metadata, err := s.firestore.GetPublicImageMetadataByFilename(ctx, fileName, fileTypeOf(fileName))
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Hidden() {
+		return nil, apperrors.ErrNotFound
+	}
+	return metadata, nil
+}
+
+// Returns the extension of fileName without the dot, the file type GetImageMetadataByFilename expects.
+func fileTypeOf(fileName string) string {
+	return strings.TrimPrefix(path.Ext(fileName), ".")
+}
+
+// Returns a version string for the map points, derived from the collection's latest update,
+// so clients can revalidate with an ETag without the points being recomputed.
+func (s *ImageService) MapPointsVersion(ctx context.Context) (string, error) {
+	latest, err := s.firestore.LatestUpdateTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(latest.UnixNano(), 36), nil
+}
+
+// Returns every geotagged image as a compact point, encoded as a JSON array of tuples or
+// (binary) with models.EncodePointsBinary. Both encodings of a version are cached together
+// for the cache TTL, so switching encodings doesn't rescan the collection.
+func (s *ImageService) MapPoints(ctx context.Context, version string, binary bool) ([]byte, error) {
+	jsonKey := pointsCachePrefix + version + ":json"
+	binaryKey := pointsCachePrefix + version + ":bin"
+
+	key := jsonKey
+	if binary {
+		key = binaryKey
+	}
+	if entry, ok := s.cache.Get(key); ok && len(entry.Data) > 0 {
+		return entry.Data, nil
+	}
+
+	points, err := s.firestore.ListMapPoints(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan map points: %w", err)
+	}
+	if points == nil {
+		points = []models.MapPoint{}
+	}
+
+	jsonData, err := json.Marshal(points)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode map points: %w", err)
+	}
+	binaryData := models.EncodePointsBinary(points)
+
+	s.cache.SetBytes(jsonKey, jsonData, "application/json", "")
+	s.cache.SetBytes(binaryKey, binaryData, models.PointsBinaryContentType, "")
+
+	log.Printf("[Points] Encoded %d points (%d bytes JSON, %d bytes binary)", len(points), len(jsonData), len(binaryData))
+
+	if binary {
+		return binaryData, nil
+	}
+	return jsonData, nil
+}
+
+// Reads every metadata tag of a stored file: EXIF for images, exiftool output for videos.
+// Files over the proxy size ceiling fail with ErrTooLarge. Tags that can't be decoded leave the
+// dump empty with the reason in Error rather than failing, since that is usually the answer.
+// The dump holds all tags; callers page through them.
+func (s *ImageService) ExifTags(ctx context.Context, id string) (*models.ExifDump, error) {
+	metadata, err := s.firestore.GetImageMetadata(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	reader, err := s.storage.OpenFile(ctx, metadata.StoragePath, s.proxyMaxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", metadata.StoragePath, err)
+	}
+	defer reader.Close()
+
+	// exiftool reads videos from stdin; goexif needs the image in memory
+	var tags map[string]string
+	if strings.HasPrefix(metadata.ContentType, "video/") {
+		tags, err = utils.ExtractAllVideoTags(reader)
+	} else {
+		data, readErr := io.ReadAll(reader)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", metadata.StoragePath, classifyError(readErr))
+		}
+		tags, err = utils.ExtractAllExif(data)
+	}
+
+	dump := &models.ExifDump{
+		Id:          metadata.Id,
+		FileName:    metadata.FileName,
+		ContentType: metadata.ContentType,
+		Tags:        tags,
+		Total:       len(tags),
+	}
+	if err != nil {
+		dump.Error = err.Error()
+	}
+	if dump.Tags == nil {
+		dump.Tags = map[string]string{}
+	}
+
+	return dump, nil
 }
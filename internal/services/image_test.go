@@ -0,0 +1,425 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+	"trekka-api/internal/testutil"
+)
+
+// Returns an ImageService on the emulator and a fake GCS server, seeding one image per entry of
+// objects (file name to content type and bytes).
+func newThumbnailService(t *testing.T, objects map[string]struct {
+	contentType string
+	data        []byte
+}) *ImageService {
+	t.Helper()
+	fs := newEmulatorFirestore(t)
+	storage, gcs := newFakeStorage(t)
+	cache := NewCacheService(time.Minute, time.Minute)
+	t.Cleanup(cache.Stop)
+
+	for fileName, object := range objects {
+		storagePath := "2024/05/" + fileName
+		gcs.Put(storagePath, object.data, object.contentType)
+		seedImage(t, fs, fileName, &models.ImageMetadata{
+			FileName: fileName, FileNameLower: strings.ToLower(fileName), ContentType: object.contentType, StoragePath: storagePath,
+		})
+	}
+	return NewImageService(storage, cache, fs)
+}
+
+// Encodes a width×height GIF with frames frames.
+func testGIF(t *testing.T, width, height, frames int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	animation := &gif.GIF{}
+	for i := range frames {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+		frame.SetColorIndex(0, 0, uint8(i%2))
+		animation.Image = append(animation.Image, frame)
+		animation.Delay = append(animation.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation); err != nil {
+		t.Fatalf("encode gif: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestThumbnailFormats(t *testing.T) {
+	var photo bytes.Buffer
+	if err := jpeg.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 64, 48)), nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	animated := testGIF(t, 64, 48, 3)
+
+	images := newThumbnailService(t, map[string]struct {
+		contentType string
+		data        []byte
+	}{
+		"photo.jpg":    {"image/jpeg", photo.Bytes()},
+		"still.gif":    {"image/gif", testGIF(t, 64, 48, 1)},
+		"animated.gif": {"image/gif", animated},
+	})
+	ctx := context.Background()
+
+	// Without EnableWebP (no cwebp), a client preferring WebP still gets JPEG
+	data, contentType, err := images.GetThumbnail(ctx, "photo.jpg", 32, true)
+	if err != nil {
+		t.Fatalf("GetThumbnail(photo.jpg): %v", err)
+	}
+	if contentType != "image/jpeg" {
+		t.Errorf("photo.jpg thumbnail is %s, want image/jpeg with WebP disabled", contentType)
+	}
+	if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil || config.Width != 32 {
+		t.Errorf("photo.jpg thumbnail = %+v, %v; want 32px wide", config, err)
+	}
+
+	_, contentType, err = images.GetThumbnail(ctx, "still.gif", 32, false)
+	if err != nil {
+		t.Fatalf("GetThumbnail(still.gif): %v", err)
+	}
+	if contentType != "image/png" {
+		t.Errorf("still.gif thumbnail is %s, want image/png", contentType)
+	}
+
+	data, contentType, err = images.GetThumbnail(ctx, "animated.gif", 32, true)
+	if err != nil {
+		t.Fatalf("GetThumbnail(animated.gif): %v", err)
+	}
+	if contentType != "image/gif" || !bytes.Equal(data, animated) {
+		t.Errorf("animated.gif thumbnail is %s (%d bytes), want the original GIF untouched", contentType, len(data))
+	}
+}
+
+func TestThumbnailStoresDominantColorInBackground(t *testing.T) {
+	red := solidPNG(t, color.RGBA{R: 220, G: 30, B: 30, A: 255}, 200, 100)
+	images := newThumbnailService(t, map[string]struct {
+		contentType string
+		data        []byte
+	}{
+		"red.png": {"image/png", red},
+	})
+	ctx := context.Background()
+
+	if _, _, err := images.GetThumbnail(ctx, "red.png", 50, false); err != nil {
+		t.Fatalf("GetThumbnail: %v", err)
+	}
+
+	// The color is written after the response, so poll for it
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		metadata, err := images.firestore.GetImageMetadata(ctx, "red.png")
+		if err != nil {
+			t.Fatalf("GetImageMetadata: %v", err)
+		}
+		if metadata.DominantColor != "" {
+			// Resampling may shift a channel by a step
+			var r, g, b int
+			if _, err := fmt.Sscanf(metadata.DominantColor, "#%02x%02x%02x", &r, &g, &b); err != nil ||
+				abs(r-220) > 2 || abs(g-30) > 2 || abs(b-30) > 2 {
+				t.Errorf("dominantColor = %q, want about #dc1e1e", metadata.DominantColor)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no dominantColor stored 5s after the thumbnail was served")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestDominantColorOf(t *testing.T) {
+	data := solidPNG(t, color.RGBA{R: 20, G: 90, B: 200, A: 255}, 32, 32)
+	if got := DominantColorOf("blue.png", "image/png", data); got != "#145ac8" {
+		t.Errorf("DominantColorOf = %q, want #145ac8", got)
+	}
+	if got := DominantColorOf("broken.png", "image/png", []byte("not an image")); got != "" {
+		t.Errorf("DominantColorOf(undecodable) = %q, want empty", got)
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func TestListPlacesReadsWholeCollection(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	// None of these has createdAt, which an ordered listing would have skipped
+	seedImage(t, fs, "a", &models.ImageMetadata{FileName: "a.jpg", PlaceKey: "100:1:1", TakenAt: day, GeoLocation: "Sintra, Portugal"})
+	seedImage(t, fs, "b", &models.ImageMetadata{FileName: "b.jpg", PlaceKey: "100:1:1", TakenAt: day.Add(time.Hour)})
+	seedImage(t, fs, "c", &models.ImageMetadata{FileName: "c.jpg", PlaceKey: "100:2:2", TakenAt: day})
+	seedImage(t, fs, "d", &models.ImageMetadata{FileName: "d.jpg", PlaceKey: "100:2:2", TakenAt: day.Add(48 * time.Hour), Status: models.StatusQuarantined})
+	seedImage(t, fs, "e", &models.ImageMetadata{FileName: "e.jpg"})
+
+	places, err := NewImageService(nil, nil, fs).ListPlaces(ctx)
+	if err != nil {
+		t.Fatalf("ListPlaces: %v", err)
+	}
+
+	if len(places) != 2 {
+		t.Fatalf("got %d places, want 2: %+v", len(places), places)
+	}
+	if p := places[0]; p.PlaceKey != "100:1:1" || p.Count != 2 || p.Representative != "b.jpg" || p.GeoLocation != "Sintra, Portugal" {
+		t.Errorf("first place = %+v, want 100:1:1 with 2 photos, b.jpg as the latest and a's location", p)
+	}
+	if p := places[1]; p.PlaceKey != "100:2:2" || p.Count != 1 || p.Representative != "c.jpg" {
+		t.Errorf("second place = %+v, want 100:2:2 with only c.jpg (d is quarantined)", p)
+	}
+}
+
+// Returns an ImageService whose Firestore reads go through a counting proxy to the emulator, with
+// one image (img-1, photo.jpg) seeded and URLs signed locally.
+func newProxiedImageService(t *testing.T) (*ImageService, *testutil.FirestoreProxy, *CacheService) {
+	t.Helper()
+	proxy := testutil.NewFirestoreProxy(t)
+	fs := NewFirestoreService(proxy.Client, "images")
+	storage, _ := newFakeStorage(t)
+	storage.SetSigningKey(testutil.FakeSigner, testutil.SigningKey(t))
+	cache := newTestCache(t)
+
+	seedImage(t, fs, "img-1", &models.ImageMetadata{
+		FileName:    "photo.jpg",
+		ContentType: "image/jpeg",
+		StoragePath: "images/photo.jpg",
+	})
+	return NewImageService(storage, cache, fs), proxy, cache
+}
+
+func TestGetImageSharesConcurrentMisses(t *testing.T) {
+	images, proxy, cache := newProxiedImageService(t)
+	// Held long enough for every caller to arrive while the first lookup is in flight
+	proxy.SetDelay(200 * time.Millisecond)
+	calls, sets := proxy.Calls(), cache.Stats().Sets
+
+	const callers = 50
+	urls := make([]string, callers)
+	errs := make([]error, callers)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			urls[i], _, _, _, errs[i] = images.GetImage(context.Background(), models.ImageRequest{Id: "img-1"})
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for i := range callers {
+		if errs[i] != nil {
+			t.Fatalf("caller %d: %v", i, errs[i])
+		}
+		if urls[i] == "" || urls[i] != urls[0] {
+			t.Fatalf("caller %d got %q, want the shared URL %q", i, urls[i], urls[0])
+		}
+	}
+	if got := proxy.Calls() - calls; got != 1 {
+		t.Errorf("Firestore calls = %d, want 1 shared by %d callers (%v)", got, callers, proxy.Methods()[calls:])
+	}
+	// Each lookup signs once and caches what it signed
+	if got := cache.Stats().Sets - sets; got != 1 {
+		t.Errorf("URLs signed and cached = %d, want 1", got)
+	}
+
+	proxy.SetDelay(0)
+	if _, _, _, _, err := images.GetImage(context.Background(), models.ImageRequest{Id: "img-1"}); err != nil {
+		t.Fatalf("GetImage after the shared lookup: %v", err)
+	}
+	if got := proxy.Calls() - calls; got != 1 {
+		t.Errorf("Firestore calls = %d after a cached read, want still 1", got)
+	}
+}
+
+func TestGetImageSharedLookupOutlivesCancelledCaller(t *testing.T) {
+	images, proxy, cache := newProxiedImageService(t)
+	proxy.SetDelay(300 * time.Millisecond)
+	calls := proxy.Calls()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, _, _, _, err := images.GetImage(ctx, models.ImageRequest{Id: "img-1"})
+		first <- err
+	}()
+
+	// Join the lookup once it has reached Firestore, then give up on the caller that started it
+	deadline := time.Now().Add(5 * time.Second)
+	for proxy.Calls() == calls {
+		if time.Now().After(deadline) {
+			t.Fatal("the first caller's lookup never reached Firestore")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan error, 1)
+	var url string
+	go func() {
+		var err error
+		url, _, _, _, err = images.GetImage(context.Background(), models.ImageRequest{Id: "img-1"})
+		second <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-first:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("cancelled caller got %v, want context.Canceled", err)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Error("cancelled caller still waiting on the shared lookup")
+	}
+
+	if err := <-second; err != nil {
+		t.Fatalf("waiting caller got %v, want the shared lookup's result", err)
+	}
+	if url == "" {
+		t.Error("waiting caller got no URL")
+	}
+	if got := proxy.Calls() - calls; got != 1 {
+		t.Errorf("Firestore calls = %d, want 1", got)
+	}
+	if _, ok := cache.GetSignedURL("img-1"); !ok {
+		t.Error("shared lookup's URL not cached after its first caller was cancelled")
+	}
+}
+
+func TestGetImageResignsBeforeURLExpires(t *testing.T) {
+	images, proxy, cache := newProxiedImageService(t)
+	clock := &fakeClock{now: time.Now()} // Signing reads the real clock, so start from it
+	cache.now = clock.Now
+	get := func() string {
+		t.Helper()
+		url, _, _, _, err := images.GetImage(context.Background(), models.ImageRequest{Id: "img-1"})
+		if err != nil {
+			t.Fatalf("GetImage: %v", err)
+		}
+		return url
+	}
+
+	first := get()
+	calls, sets := proxy.Calls(), cache.Stats().Sets
+
+	clock.Advance(DefaultSignedURLExpiry - signedURLRefreshMargin - time.Minute)
+	if got := get(); got != first {
+		t.Errorf("with over the margin left: got %q, want the cached URL", got)
+	}
+	if proxy.Calls() != calls || cache.Stats().Sets != sets {
+		t.Errorf("with over the margin left: %d Firestore calls, %d URLs cached; want none", proxy.Calls()-calls, cache.Stats().Sets-sets)
+	}
+
+	clock.Advance(2 * time.Minute)
+	get()
+	if got := cache.Stats().Sets - sets; got != 1 {
+		t.Errorf("within the margin: %d URLs signed and cached, want 1", got)
+	}
+}
+
+func TestGetImageNotFoundTombstone(t *testing.T) {
+	proxy := testutil.NewFirestoreProxy(t)
+	fs := NewFirestoreService(proxy.Client, "images")
+	storage, _ := newFakeStorage(t)
+	storage.SetSigningKey(testutil.FakeSigner, testutil.SigningKey(t))
+	cache := newTestCache(t)
+	images := NewImageService(storage, cache, fs)
+	images.SetGeocoder(&fakeGeocoder{})
+	images.SetNegativeCacheTTL(200 * time.Millisecond)
+	ctx := context.Background()
+
+	// Looks fileName up, returning the error and how many Firestore calls it took
+	lookup := func(fileName string) (int, error) {
+		calls := proxy.Calls()
+		_, _, _, _, err := images.GetImage(ctx, models.ImageRequest{FileName: fileName})
+		return proxy.Calls() - calls, err
+	}
+
+	t.Run("answers from memory until it expires", func(t *testing.T) {
+		if calls, err := lookup("missing.jpg"); !errors.Is(err, apperrors.ErrNotFound) || calls == 0 {
+			t.Fatalf("first lookup = %v after %d calls, want ErrNotFound from Firestore", err, calls)
+		}
+		for range 3 {
+			if calls, err := lookup("missing.jpg"); !errors.Is(err, apperrors.ErrNotFound) || calls != 0 {
+				t.Fatalf("repeat lookup = %v after %d calls, want ErrNotFound from the tombstone", err, calls)
+			}
+		}
+
+		time.Sleep(250 * time.Millisecond)
+		if cache.IsNotFound("missing.jpg") {
+			t.Error("tombstone still found after its TTL")
+		}
+		if calls, err := lookup("missing.jpg"); !errors.Is(err, apperrors.ErrNotFound) || calls == 0 {
+			t.Errorf("lookup after expiry = %v after %d calls, want Firestore asked again", err, calls)
+		}
+	})
+
+	t.Run("cleared by an upload", func(t *testing.T) {
+		if _, err := lookup("uploaded.png"); !errors.Is(err, apperrors.ErrNotFound) {
+			t.Fatalf("lookup before upload = %v, want ErrNotFound", err)
+		}
+
+		if _, err := images.UploadImage(ctx, "uploaded.png", "image/png", solidPNG(t, color.RGBA{R: 200, A: 255}, 8, 8)); err != nil {
+			t.Fatalf("UploadImage: %v", err)
+		}
+		if cache.IsNotFound("uploaded.png") {
+			t.Error("tombstone kept after the upload")
+		}
+		if _, err := lookup("uploaded.png"); err != nil {
+			t.Errorf("lookup after upload = %v, want the uploaded image", err)
+		}
+	})
+
+	t.Run("cleared by a sync", func(t *testing.T) {
+		fake := testutil.NewFakeDrive(t)
+		fake.Add(testutil.DriveFile{ID: "file-1", Name: "synced.png", MimeType: "image/png", Parent: "folder",
+			Data: solidPNG(t, color.RGBA{B: 200, A: 255}, 8, 8)})
+		ds := NewDriveService(NewDriveClient(fake.Service, DriveClientOptions{}), storage, fs, &fakeGeocoder{}, "folder")
+		ds.SetCacheEvictor(images.EvictImage)
+
+		if _, err := lookup("synced.png"); !errors.Is(err, apperrors.ErrNotFound) {
+			t.Fatalf("lookup before sync = %v, want ErrNotFound", err)
+		}
+
+		report, err := ds.BackfillFromDrive(ctx, BackfillOptions{SkipExisting: true})
+		if err != nil || report.Synced != 1 {
+			t.Fatalf("BackfillFromDrive = %+v, %v; want the file synced", report, err)
+		}
+		if cache.IsNotFound("synced.png") {
+			t.Error("tombstone kept after the sync")
+		}
+		if _, err := lookup("synced.png"); err != nil {
+			t.Errorf("lookup after sync = %v, want the synced image", err)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		images.SetNegativeCacheTTL(0)
+		defer images.SetNegativeCacheTTL(200 * time.Millisecond)
+
+		for range 2 {
+			if calls, err := lookup("never.jpg"); !errors.Is(err, apperrors.ErrNotFound) || calls == 0 {
+				t.Errorf("lookup = %v after %d calls, want Firestore asked every time", err, calls)
+			}
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+func TestSplitGeoLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		want     models.LocationParts
+		wantOK   bool
+	}{
+		{"Lisbon, Portugal", models.LocationParts{City: "Lisbon", Country: "Portugal"}, true},
+		{" Lisbon ,  Portugal ", models.LocationParts{City: "Lisbon", Country: "Portugal"}, true},
+		{"Portugal", models.LocationParts{}, false},
+		{"Sintra, Lisboa, Portugal", models.LocationParts{}, false},
+		{", Portugal", models.LocationParts{}, false},
+		{"Lisbon, ", models.LocationParts{}, false},
+		{"", models.LocationParts{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			got, ok := SplitGeoLocation(tt.location)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("SplitGeoLocation(%q) = %+v, %v; want %+v, %v", tt.location, got, ok, tt.want, tt.wantOK)
+			}
+			if ok && FormatLocation(got) != "Lisbon, Portugal" {
+				t.Errorf("FormatLocation(%+v) = %q, want the split undone", got, FormatLocation(got))
+			}
+		})
+	}
+
+	for parts, want := range map[models.LocationParts]string{
+		{City: "Lisbon", Region: "Lisboa", Country: "Portugal"}: "Lisbon, Portugal",
+		{City: "Lisbon"}:    "Lisbon",
+		{Country: "Norway"}: "Norway",
+		{Region: "Lisboa"}:  "",
+	} {
+		if got := FormatLocation(parts); got != want {
+			t.Errorf("FormatLocation(%+v) = %q, want %q", parts, got, want)
+		}
+	}
+}
+
+// Seeds documents with full, partial and missing location hierarchies, one minute apart.
+func seedLocations(t *testing.T, fs *FirestoreService) {
+	t.Helper()
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	docs := []models.ImageMetadata{
+		{CountryCode: "PT", Country: "Portugal", Region: "Lisboa", City: "Lisbon"},
+		{CountryCode: "PT", Country: "Portugal", Region: "Lisboa", City: "Lisbon"},
+		{CountryCode: "PT", Country: "Portugal", Region: "Lisboa", City: "Sintra"},
+		{CountryCode: "PT", Country: "Portugal", Region: "Porto"}, // No city
+		{CountryCode: "PT", Country: "Portugal"},                  // Country only
+		{CountryCode: "PT", Country: "Portugal", Region: "Lisboa", City: "Lisbon", Status: models.StatusQuarantined},
+		{CountryCode: "FR", Country: "France", City: "Paris"}, // City without a region
+		{Country: "Spain", Region: "Madrid", City: "Madrid"},  // Name without a code
+		{Country: "spain", Region: "Madrid", City: "Madrid"},  // Spelled differently
+		{GeoLocation: "Somewhere"},                            // Not split into parts
+		{},
+	}
+	for i := range docs {
+		docs[i].FileName = fmt.Sprintf("img-%02d.jpg", i)
+		docs[i].TakenAt = base.Add(time.Duration(i) * time.Minute)
+		seedImage(t, fs, fmt.Sprintf("img-%02d", i), &docs[i])
+	}
+}
+
+// Summarises nodes as "name:count" strings for comparison.
+func nodeCounts(nodes []*models.LocationNode) []string {
+	var out []string
+	for _, n := range nodes {
+		out = append(out, fmt.Sprintf("%s:%d", n.Name, n.Count))
+	}
+	return out
+}
+
+func TestLocationTreeWithPartialHierarchies(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	seedLocations(t, fs)
+	images := NewImageService(nil, newTestCache(t), fs)
+
+	tree, err := images.LocationTree(context.Background(), true)
+	if err != nil {
+		t.Fatalf("LocationTree: %v", err)
+	}
+	if tree.Total != 10 || tree.Unlocated != 2 {
+		t.Errorf("total = %d, unlocated = %d; want 10 and 2 (quarantined left out)", tree.Total, tree.Unlocated)
+	}
+	if len(tree.Countries) != 3 {
+		t.Fatalf("countries = %v, want Portugal, Spain and France", nodeCounts(tree.Countries))
+	}
+
+	portugal := tree.Countries[0]
+	if portugal.Name != "Portugal" || portugal.Code != "PT" || portugal.Count != 5 {
+		t.Errorf("first country = %s (%s) with %d, want Portugal (PT) with 5", portugal.Name, portugal.Code, portugal.Count)
+	}
+	if got, want := nodeCounts(portugal.Children), []string{"Lisboa:3", "Porto:1"}; !slices.Equal(got, want) {
+		t.Errorf("Portugal's regions = %v, want %v (the country-only image counted above them)", got, want)
+	}
+	if got, want := nodeCounts(portugal.Children[0].Children), []string{"Lisbon:2", "Sintra:1"}; !slices.Equal(got, want) {
+		t.Errorf("Lisboa's cities = %v, want %v", got, want)
+	}
+	if porto := portugal.Children[1]; len(porto.Children) != 0 {
+		t.Errorf("Porto has cities %v, want none", nodeCounts(porto.Children))
+	}
+
+	spain := tree.Countries[1]
+	if !strings.EqualFold(spain.Name, "Spain") || spain.Count != 2 || spain.Code != "" {
+		t.Errorf("second country = %s (%q) with %d, want both spellings of Spain merged with 2", spain.Name, spain.Code, spain.Count)
+	}
+
+	france := tree.Countries[2]
+	if france.Name != "France" || france.Count != 1 || len(france.Children) != 0 {
+		t.Errorf("France = %d with regions %v, want 1 and no regions for a city without one", france.Count, nodeCounts(france.Children))
+	}
+}
+
+func TestListFilteredByLocation(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	seedLocations(t, fs)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		filter models.ImageFilter
+		want   int
+	}{
+		{"country code", models.ImageFilter{Country: "PT"}, 5},
+		{"lower-case country code", models.ImageFilter{Country: "pt"}, 5},
+		{"country name", models.ImageFilter{Country: "Portugal"}, 5},
+		{"country and region", models.ImageFilter{Country: "PT", Region: "Lisboa"}, 3},
+		{"country, region and city", models.ImageFilter{Country: "PT", Region: "Lisboa", City: "Sintra"}, 1},
+		{"country and city", models.ImageFilter{Country: "FR", City: "Paris"}, 1},
+		{"name without a code", models.ImageFilter{Country: "Spain"}, 1},
+		{"unknown region", models.ImageFilter{Country: "PT", Region: "Algarve"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			images, err := fs.ListImageMetadataFiltered(ctx, tt.filter, 100, 0)
+			if err != nil {
+				t.Fatalf("ListImageMetadataFiltered: %v", err)
+			}
+			if len(images) != tt.want {
+				t.Errorf("got %d images, want %d", len(images), tt.want)
+			}
+			for i := 1; i < len(images); i++ {
+				if images[i].TakenAt.After(images[i-1].TakenAt) {
+					t.Errorf("%s listed after the older %s", images[i].Id, images[i-1].Id)
+				}
+			}
+		})
+	}
+
+	for _, filter := range []models.ImageFilter{{Region: "Lisboa"}, {City: "Lisbon"}} {
+		if _, err := fs.ListImageMetadataFiltered(ctx, filter, 100, 0); !errors.Is(err, apperrors.ErrInvalidInput) {
+			t.Errorf("filter %+v without a country: err = %v, want ErrInvalidInput", filter, err)
+		}
+	}
+}
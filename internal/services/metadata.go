@@ -2,51 +2,116 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"slices"
 	"strings"
 	"time"
 
+	apperrors "trekka-api/internal/errors"
 	"trekka-api/internal/models"
 	"trekka-api/internal/utils"
 )
 
 // Extracts metadata from file bytes (EXIF for images, MP4 for videos).
 // Returns a metadata struct with coordinates, timestamp, resolution, and location (if geocoding succeeds).
-func ExtractMetadataFromBytes(ctx context.Context, fileName, contentType string, fileData []byte) (*models.ImageMetadata, error) {
+// A nil geocoder leaves the location unresolved.
+func ExtractMetadataFromBytes(ctx context.Context, fileName, contentType string, fileData []byte, geocoder Geocoder) (*models.ImageMetadata, error) {
+	return extractMetadata(ctx, nil, geocoder, fileName, contentType, mediaSource{data: fileData}, nil)
+}
+
+// A file to extract metadata from: its bytes, or for a file too large to hold in memory, the
+// path of a local copy, which exiftool, ffmpeg and the image decoders read from disk.
+type mediaSource struct {
+	data []byte
+	path string
+}
+
+// Reads coordinates, timestamp and resolution with exiftool for videos and goexif for images.
+func (s mediaSource) extract(isVideo bool) (models.Coordinates, string, []float64, error) {
+	switch {
+	case s.path == "" && isVideo:
+		return utils.ExtractMP4Data(s.data)
+	case s.path == "":
+		return utils.ExtractData(s.data)
+	case isVideo:
+		return utils.ExtractMP4File(s.path)
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return models.Coordinates{}, "", nil, err
+	}
+	defer f.Close()
+	return utils.ExtractDataFromReader(f)
+}
+
+// Returns the SHA-256 of the content.
+func (s mediaSource) contentHash() (string, error) {
+	if s.path == "" {
+		return utils.ContentHash(s.data), nil
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return utils.ContentHashReader(f)
+}
+
+// Shared extraction behind ExtractMetadataFromBytes and ExtractAndPersistMetadata.
+// When firestoreService is set, a location already resolved for the same PlaceKey is reused
+// instead of geocoding again. A nil geocoder resolves no new locations. When timings is set, the
+// Extract and Geocode stages are recorded.
+func extractMetadata(
+	ctx context.Context,
+	firestoreService *FirestoreService,
+	geocoder Geocoder,
+	fileName, contentType string,
+	source mediaSource,
+	timings *models.SyncTimings,
+) (*models.ImageMetadata, error) {
+	start := time.Now()
+	var geocodeTime time.Duration
+
 	var coords models.Coordinates
 	var timestamp string
 	var resolution []float64
 	var extractErr error
 
 	isVideo := strings.HasPrefix(contentType, "video/")
-	if isVideo {
-		coords, timestamp, resolution, extractErr = utils.ExtractMP4Data(fileData)
-	} else {
-		coords, timestamp, resolution, extractErr = utils.ExtractData(fileData)
-	}
+	coords, timestamp, resolution, extractErr = source.extract(isVideo)
 
 	if extractErr != nil {
 		log.Printf("Warning: failed to extract metadata from %s: %v", fileName, extractErr)
 	}
 
+	contentHash, err := source.contentHash()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", fileName, err)
+	}
+
 	// Build metadata struct with extracted data
 	metadata := &models.ImageMetadata{
 		FileName:    fileName,
 		ContentType: contentType,
 		StoragePath: fileName,
+		ContentHash: contentHash,
 	}
 
 	// Populate extracted data
 	if coords.Lat != "" && coords.Lng != "" {
 		metadata.Coordinates = coords
-
-		// Geocode coordinates to location name
-		geocoder := NewGeocodingService()
-		location, err := geocoder.ReverseGeocode(ctx, coords)
-		if err == nil && location != "" {
-			metadata.GeoLocation = location
-		}
+		metadata.PlaceKey = placeKeyFor(geocoder, coords)
+		metadata.Geohash = utils.GeohashFromCoordinates(coords)
+		geocodeStart := time.Now()
+		location, parts := resolveLocation(ctx, firestoreService, geocoder, metadata.PlaceKey, coords)
+		metadata.GeoLocation = location
+		setLocationParts(metadata, parts)
+		geocodeTime = time.Since(geocodeStart)
 	}
 
 	if timestamp != "" {
@@ -60,67 +125,334 @@ func ExtractMetadataFromBytes(ctx context.Context, fileName, contentType string,
 		metadata.Resolution = resolution
 	}
 
+	metadata.DominantColor = dominantColor(fileName, contentType, source)
+
+	if timings != nil {
+		timings.Geocode = geocodeTime
+		timings.Extract = time.Since(start) - geocodeTime
+	}
+
 	return metadata, nil
 }
 
+// Resolves a location name and hierarchy, preferring one already stored for the same place over a
+// geocoding call. A stored location from before the hierarchy was kept is split where unambiguous.
+// Returns "" when neither source has a result.
+func resolveLocation(
+	ctx context.Context,
+	firestoreService *FirestoreService,
+	geocoder Geocoder,
+	placeKey string,
+	coords models.Coordinates,
+) (string, models.LocationParts) {
+	if firestoreService != nil && placeKey != "" {
+		if location, parts, err := firestoreService.GetGeoLocationByPlaceKey(ctx, placeKey); err == nil {
+			if parts == (models.LocationParts{}) {
+				parts, _ = SplitGeoLocation(location)
+			}
+			return location, parts
+		}
+	}
+
+	if geocoder == nil {
+		return "", models.LocationParts{}
+	}
+	parts, err := geocoder.ReverseGeocode(ctx, coords)
+	if err != nil {
+		return "", models.LocationParts{}
+	}
+	return FormatLocation(parts), parts
+}
+
+// Copies a location hierarchy onto the metadata's city/region/country fields and the levels
+// behind them.
+func setLocationParts(metadata *models.ImageMetadata, parts models.LocationParts) {
+	metadata.City = parts.City
+	metadata.Region = parts.Region
+	metadata.Country = parts.Country
+	metadata.CountryCode = parts.CountryCode
+	metadata.Town = parts.Town
+	metadata.Village = parts.Village
+	metadata.State = parts.State
+	metadata.County = parts.County
+}
+
+// Applies freshly extracted fields onto an existing record, keeping whatever extraction didn't find.
+// User-owned fields (Favorite, Visibility) are never derived from the file, so they are
+// always carried over from existing; don't copy them from extracted here. Description is only
+// taken from a Drive sync (driveFileID set), as mergeSourceDescription says.
+func mergeExtracted(existing, extracted *models.ImageMetadata, driveFileID string, now time.Time) *models.ImageMetadata {
+	metadata := existing
+	if extracted.Coordinates.Lat != "" && extracted.Coordinates.Lng != "" {
+		metadata.Coordinates = extracted.Coordinates
+		metadata.GeoLocation = extracted.GeoLocation
+		setLocationParts(metadata, extracted.LocationParts())
+		metadata.PlaceKey = extracted.PlaceKey
+		metadata.Geohash = extracted.Geohash
+	}
+	if !extracted.TakenAt.IsZero() {
+		metadata.TakenAt = extracted.TakenAt
+		metadata.FormattedDate = extracted.FormattedDate
+	}
+	if len(extracted.Resolution) == 2 {
+		metadata.Resolution = extracted.Resolution
+	}
+	if extracted.DominantColor != "" {
+		metadata.DominantColor = extracted.DominantColor
+	}
+	metadata.ContentHash = extracted.ContentHash
+	if extracted.SourceChecksum != "" {
+		metadata.SourceChecksum = extracted.SourceChecksum
+	}
+	if !extracted.SourceModifiedTime.IsZero() {
+		metadata.SourceModifiedTime = extracted.SourceModifiedTime
+	}
+	metadata.UpdatedAt = now
+
+	if driveFileID != "" {
+		metadata.DriveFileID = driveFileID
+		mergeSourceDescription(metadata, extracted)
+		metadata.SourceProperties = extracted.SourceProperties
+	}
+
+	// If TakenAt still not set, fall back to CreatedAt
+	if metadata.TakenAt.IsZero() && !metadata.CreatedAt.IsZero() {
+		metadata.TakenAt = metadata.CreatedAt
+	}
+
+	return metadata
+}
+
+// Takes a Drive file's description (extracted.Description) as the caption when it changed since
+// the last sync, unless the caption was set by hand: only an empty caption, or one matching the
+// hash an earlier sync stored, is replaced (or cleared, when the description was removed).
+func mergeSourceDescription(metadata, extracted *models.ImageMetadata) {
+	if extracted.SourceDescriptionHash == metadata.SourceDescriptionHash {
+		return
+	}
+
+	fromDrive := metadata.Description == "" ||
+		(metadata.SourceDescriptionHash != "" && descriptionHash(metadata.Description) == metadata.SourceDescriptionHash)
+	if fromDrive {
+		metadata.Description = extracted.Description
+		metadata.HasDescription = extracted.Description != ""
+	}
+	metadata.SourceDescriptionHash = extracted.SourceDescriptionHash
+}
+
+// Returns the hash of a Drive description stored in SourceDescriptionHash, or "" for none.
+func descriptionHash(description string) string {
+	if description == "" {
+		return ""
+	}
+	return utils.ContentHash([]byte(description))
+}
+
+// Lists the fields mergeExtracted may change that differ between before and after.
+func diffExtracted(before, after *models.ImageMetadata) []models.FieldChange {
+	changes := []models.FieldChange{}
+	if before.Coordinates != after.Coordinates {
+		changes = append(changes, models.FieldChange{Field: "coordinates", Before: before.Coordinates, After: after.Coordinates})
+	}
+	for _, f := range []struct {
+		field         string
+		before, after string
+	}{
+		{"geoLocation", before.GeoLocation, after.GeoLocation},
+		{"city", before.City, after.City},
+		{"region", before.Region, after.Region},
+		{"country", before.Country, after.Country},
+		{"countryCode", before.CountryCode, after.CountryCode},
+		{"placeKey", before.PlaceKey, after.PlaceKey},
+		{"geohash", before.Geohash, after.Geohash},
+	} {
+		if f.before != f.after {
+			changes = append(changes, models.FieldChange{Field: f.field, Before: f.before, After: f.after})
+		}
+	}
+	if !before.TakenAt.Equal(after.TakenAt) {
+		changes = append(changes, models.FieldChange{Field: "takenAt", Before: before.TakenAt, After: after.TakenAt})
+	}
+	if before.FormattedDate != after.FormattedDate {
+		changes = append(changes, models.FieldChange{Field: "formattedDate", Before: before.FormattedDate, After: after.FormattedDate})
+	}
+	if !slices.Equal(before.Resolution, after.Resolution) {
+		changes = append(changes, models.FieldChange{Field: "resolution", Before: before.Resolution, After: after.Resolution})
+	}
+	if before.DominantColor != after.DominantColor {
+		changes = append(changes, models.FieldChange{Field: "dominantColor", Before: before.DominantColor, After: after.DominantColor})
+	}
+	if before.ContentHash != after.ContentHash {
+		changes = append(changes, models.FieldChange{Field: "contentHash", Before: before.ContentHash, After: after.ContentHash})
+	}
+	return changes
+}
+
+// Computes the dominant color of media bytes the way ingest does (the poster frame for videos),
+// without extracting any other metadata. Returns "" when it can't be computed.
+func DominantColorOf(fileName, contentType string, data []byte) string {
+	return dominantColor(fileName, contentType, mediaSource{data: data})
+}
+
+// Computes the dominant color for an image or video, using the poster frame for videos.
+// Failures are logged and leave the color empty.
+func dominantColor(fileName, contentType string, source mediaSource) string {
+	frame := source.data
+	if strings.HasPrefix(contentType, "video/") {
+		var poster []byte
+		var err error
+		if source.path != "" {
+			poster, err = utils.ExtractVideoPosterFile(source.path)
+		} else {
+			poster, err = utils.ExtractVideoPoster(source.data)
+		}
+		if err != nil {
+			log.Printf("Warning: no poster frame for %s: %v", fileName, err)
+			return ""
+		}
+		frame = poster
+	} else if source.path != "" {
+		return dominantColorOfFile(fileName, source.path)
+	}
+
+	color, err := utils.DominantColorFromBytes(frame)
+	if err != nil {
+		log.Printf("Warning: failed to compute dominant color for %s: %v", fileName, err)
+		return ""
+	}
+
+	return color
+}
+
+// Computes the dominant color of an image file on disk, logging failures like dominantColor.
+func dominantColorOfFile(fileName, filePath string) string {
+	f, err := os.Open(filePath)
+	if err == nil {
+		defer f.Close()
+		var color string
+		if color, err = utils.DominantColorFromReader(f); err == nil {
+			return color
+		}
+	}
+
+	log.Printf("Warning: failed to compute dominant color for %s: %v", fileName, err)
+	return ""
+}
+
+// Extracts metadata from file bytes and merges it into a copy of existing without writing it,
+// for callers that persist many records at once with FirestoreService.BulkUpdateImageMetadata.
+// A nil geocoder leaves new locations unresolved. When timings is set, the Extract and Geocode
+// stages are recorded into it.
+func ExtractAndMergeMetadata(
+	ctx context.Context,
+	firestoreService *FirestoreService,
+	fileName, contentType string,
+	fileData []byte,
+	existing *models.ImageMetadata,
+	geocoder Geocoder,
+	timings *models.SyncTimings,
+) (*models.ImageMetadata, error) {
+	extracted, err := extractMetadata(ctx, firestoreService, geocoder, fileName, contentType, mediaSource{data: fileData}, timings)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := *existing
+	return mergeExtracted(&merged, extracted, "", time.Now()), nil
+}
+
 // Extracts metadata from file bytes and saves to Firestore.
 // For new files (existing == nil), it creates a new record.
 // For existing files, it updates only the extracted fields.
+// driveFileID identifies Drive-sourced media (empty otherwise) and keys deterministic document IDs.
+// A nil geocoder leaves new locations unresolved. When timings is set, the Extract, Geocode and
+// Persist stages are recorded into it.
 func ExtractAndPersistMetadata(
 	ctx context.Context,
 	firestoreService *FirestoreService,
-	fileName, contentType string,
+	fileName, contentType, driveFileID string,
 	fileData []byte,
 	existing *models.ImageMetadata,
-	geoGeocodingService *GeocodingService,
+	geocoder Geocoder,
+	timings *models.SyncTimings,
 ) (*models.ImageMetadata, error) {
 	// Extract metadata from file
-	extracted, err := ExtractMetadataFromBytes(ctx, fileName, contentType, fileData)
+	extracted, err := extractMetadata(ctx, firestoreService, geocoder, fileName, contentType, mediaSource{data: fileData}, timings)
 	if err != nil {
 		return nil, err
 	}
 
+	return persistExtracted(ctx, firestoreService, extracted, driveFileID, existing, timings)
+}
+
+// Saves extracted metadata the way ExtractAndPersistMetadata does, for callers that need the
+// extracted fields (e.g. the capture date for the storage path) before the file is stored.
+// With a driveFileID, extracted also carries the Drive file's description and appProperties
+// (see setDriveFields). When timings is set, the Persist stage is recorded into it.
+func persistExtracted(
+	ctx context.Context,
+	firestoreService *FirestoreService,
+	extracted *models.ImageMetadata,
+	driveFileID string,
+	existing *models.ImageMetadata,
+	timings *models.SyncTimings,
+) (*models.ImageMetadata, error) {
 	now := time.Now()
 
-	// Merge with existing record or create new
-	var metadata *models.ImageMetadata
-	if existing != nil {
-		metadata = existing
-		// Update with extracted data
-		if extracted.Coordinates.Lat != "" && extracted.Coordinates.Lng != "" {
-			metadata.Coordinates = extracted.Coordinates
-			metadata.GeoLocation = extracted.GeoLocation
-		}
-		if !extracted.TakenAt.IsZero() {
-			metadata.TakenAt = extracted.TakenAt
-			metadata.FormattedDate = extracted.FormattedDate
-		}
-		if len(extracted.Resolution) == 2 {
-			metadata.Resolution = extracted.Resolution
-		}
-		metadata.UpdatedAt = now
-	} else {
-		metadata = extracted
+	// Builds the record for media not stored yet. extracted is copied, since the merge below can
+	// run again when its transaction retries.
+	newMetadata := func() *models.ImageMetadata {
+		metadata := *extracted
 		metadata.CreatedAt = now
 		metadata.UpdatedAt = now
+		if driveFileID != "" {
+			metadata.DriveFileID = driveFileID
+		}
+		// If TakenAt still not set, fall back to CreatedAt
+		if metadata.TakenAt.IsZero() {
+			metadata.TakenAt = metadata.CreatedAt
+		}
+		return &metadata
 	}
 
-	// If TakenAt still not set, fall back to CreatedAt
-	if metadata.TakenAt.IsZero() && !metadata.CreatedAt.IsZero() {
-		metadata.TakenAt = metadata.CreatedAt
+	persistStart := time.Now()
+	if timings != nil {
+		defer func() { timings.Persist = time.Since(persistStart) }()
 	}
 
-	// Persist to Firestore (create or update)
-	if existing == nil {
-		firestoreID, err := firestoreService.CreateImageMetadata(ctx, metadata)
-		if err != nil {
+	// New media is created with Create, which fails rather than overwrite when the deterministic
+	// ID is already taken (an earlier sync, or another instance racing this one). That, and media
+	// the caller already found, is persisted by re-reading the document inside a transaction and
+	// merging the extracted fields over what is stored then, so two syncs of the same file can't
+	// interleave and drop each other's writes or fields set by hand.
+	var id string
+	if existing != nil {
+		id = existing.Id
+	} else {
+		metadata := newMetadata()
+		createdID, err := firestoreService.CreateImageMetadata(ctx, metadata)
+		if err == nil {
+			metadata.Id = createdID
+			return metadata, nil
+		}
+		if createdID == "" || !errors.Is(err, apperrors.ErrConflict) {
 			return nil, fmt.Errorf("create metadata failed: %w", err)
 		}
-		metadata.Id = firestoreID
-	} else {
-		if err := firestoreService.UpdateImageMetadata(ctx, metadata.Id, metadata); err != nil {
-			return nil, fmt.Errorf("update metadata failed: %w", err)
+		id = createdID
+	}
+
+	metadata, err := firestoreService.MergeExtractedMetadata(ctx, id, func(current *models.ImageMetadata) (*models.ImageMetadata, error) {
+		if current != nil {
+			return mergeExtracted(current, extracted, driveFileID, now), nil
 		}
+		if existing != nil {
+			// Deleted since the caller read it; don't resurrect it
+			return nil, apperrors.ErrNotFound
+		}
+		return newMetadata(), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("persist metadata failed: %w", err)
 	}
 
 	return metadata, nil
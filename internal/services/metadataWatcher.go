@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"trekka-api/internal/models"
+)
+
+// Delays between listener reconnects, doubling from the first up to the second.
+const (
+	watcherMinBackoff = time.Second
+	watcherMaxBackoff = time.Minute
+)
+
+// MetadataWatcher evicts an image's cached entries as soon as its document changes, using a
+// Firestore snapshot listener on the collection, so edits made elsewhere (another instance, the
+// CLI tools, the console) don't keep being served from cache until the TTL runs out.
+type MetadataWatcher struct {
+	firestore *FirestoreService
+	images    *ImageService
+}
+
+func NewMetadataWatcher(firestore *FirestoreService, images *ImageService) *MetadataWatcher {
+	return &MetadataWatcher{firestore: firestore, images: images}
+}
+
+// Listens for document changes until ctx is canceled, reconnecting with exponential backoff when
+// the listener fails. Each connect starts with a snapshot of the whole collection; only documents
+// updated since the watcher last heard from Firestore are evicted from it, which covers changes
+// made while it was reconnecting. Always returns ctx.Err().
+func (w *MetadataWatcher) Run(ctx context.Context) error {
+	since := time.Now()
+	backoff := watcherMinBackoff
+
+	for {
+		err := w.listen(ctx, &since, func() { backoff = watcherMinBackoff })
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		log.Printf("[CacheWatch] Listener failed, reconnecting in %v: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, watcherMaxBackoff)
+	}
+}
+
+// Runs one listener until it fails, evicting changed documents and advancing since to the read
+// time of each snapshot handled. connected is called once the first snapshot arrives.
+func (w *MetadataWatcher) listen(ctx context.Context, since *time.Time, connected func()) error {
+	iter := w.firestore.client.Collection(w.firestore.collection).Snapshots(ctx)
+	defer iter.Stop()
+
+	initial := true
+	for {
+		snap, err := iter.Next()
+		if err != nil {
+			return err
+		}
+
+		evicted := 0
+		for _, change := range snap.Changes {
+			// The initial snapshot lists every document as added; skip those unchanged since last heard
+			if initial && !change.Doc.UpdateTime.After(*since) {
+				continue
+			}
+			w.evict(change.Doc)
+			evicted++
+		}
+
+		if initial {
+			log.Printf("[CacheWatch] Listening for changes to %s (%d changed while disconnected)", w.firestore.collection, evicted)
+			connected()
+			initial = false
+		}
+		*since = snap.ReadTime
+	}
+}
+
+// Evicts the cached entries of one document under its ID, legacy IDs and file name.
+func (w *MetadataWatcher) evict(doc *firestore.DocumentSnapshot) {
+	keys := []string{doc.Ref.ID}
+
+	var metadata models.ImageMetadata
+	if err := doc.DataTo(&metadata); err != nil {
+		log.Printf("[CacheWatch] Failed to parse %s, evicting by ID only: %v", doc.Ref.ID, err)
+	} else {
+		keys = append(keys, metadata.LegacyIDs...)
+		if metadata.FileName != "" {
+			keys = append(keys, metadata.FileName)
+		}
+	}
+
+	w.images.EvictImage(keys...)
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"image/color"
+	"sync"
+	"testing"
+	"time"
+
+	"trekka-api/internal/models"
+)
+
+func TestReingestingDriveFileKeepsOneDocument(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+	data := solidPNG(t, color.RGBA{R: 200, G: 40, B: 40, A: 255}, 8, 8)
+
+	first, err := ExtractAndPersistMetadata(ctx, fs, "IMG_1.png", "image/png", "drive-file-1", data, nil, &fakeGeocoder{}, nil)
+	if err != nil {
+		t.Fatalf("first ingest: %v", err)
+	}
+
+	// A backfill and the watcher racing over the same file, neither having seen a document
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ExtractAndPersistMetadata(ctx, fs, "IMG_1.png", "image/png", "drive-file-1", data, nil, &fakeGeocoder{}, nil)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("re-ingest: %v", err)
+		}
+	}
+
+	if n := countImages(t, fs); n != 1 {
+		t.Fatalf("%d documents after ingesting one Drive file three times, want 1", n)
+	}
+	stored, err := fs.GetImageMetadata(ctx, first.Id)
+	if err != nil {
+		t.Fatalf("GetImageMetadata(%s): %v", first.Id, err)
+	}
+	if stored.Id != "drive-file-1" {
+		t.Errorf("document ID = %q, want the Drive file ID", stored.Id)
+	}
+	// Firestore keeps microseconds
+	if stored.CreatedAt.Sub(first.CreatedAt).Abs() > time.Millisecond {
+		t.Errorf("createdAt = %v, want the first ingest's %v", stored.CreatedAt, first.CreatedAt)
+	}
+}
+
+func TestExtractMetadataFromBytesUsesGivenGeocoder(t *testing.T) {
+	data := gpsJPEG(t, [3]uint32{38, 42, 36}, "N", [3]uint32{9, 8, 24}, "W")
+
+	geocoder := &fakeGeocoder{parts: models.LocationParts{City: "Lisbon", Country: "Portugal"}}
+	metadata, err := ExtractMetadataFromBytes(context.Background(), "IMG_1.jpg", "image/jpeg", data, geocoder)
+	if err != nil {
+		t.Fatalf("ExtractMetadataFromBytes: %v", err)
+	}
+	if metadata.GeoLocation != "Lisbon, Portugal" {
+		t.Errorf("GeoLocation = %q, want the geocoder's %q", metadata.GeoLocation, "Lisbon, Portugal")
+	}
+	if n := geocoder.calls.Load(); n != 1 {
+		t.Errorf("geocoder called %d times, want 1", n)
+	}
+
+	t.Run("nil geocoder", func(t *testing.T) {
+		metadata, err := ExtractMetadataFromBytes(context.Background(), "IMG_1.jpg", "image/jpeg", data, nil)
+		if err != nil {
+			t.Fatalf("ExtractMetadataFromBytes: %v", err)
+		}
+		if metadata.Coordinates.Lat == "" || metadata.GeoLocation != "" {
+			t.Errorf("Coordinates = %+v, GeoLocation = %q; want coordinates and no location", metadata.Coordinates, metadata.GeoLocation)
+		}
+	})
+}
+
+func TestRefreshMetadataUsesConfiguredGeocoder(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	storage, gcs := newFakeStorage(t)
+	images := NewImageService(storage, newTestCache(t), fs)
+	geocoder := &fakeGeocoder{parts: models.LocationParts{City: "Lisbon", Country: "Portugal"}}
+	images.SetGeocoder(geocoder)
+
+	gcs.Put("images/IMG_1.jpg", gpsJPEG(t, [3]uint32{38, 42, 36}, "N", [3]uint32{9, 8, 24}, "W"), "image/jpeg")
+	seedImage(t, fs, "img-1", &models.ImageMetadata{
+		FileName:    "IMG_1.jpg",
+		ContentType: "image/jpeg",
+		StoragePath: "images/IMG_1.jpg",
+	})
+
+	refresh, err := images.RefreshMetadata(context.Background(), "img-1", true)
+	if err != nil {
+		t.Fatalf("RefreshMetadata: %v", err)
+	}
+	if refresh.Metadata.GeoLocation != "Lisbon, Portugal" {
+		t.Errorf("GeoLocation = %q, want the configured geocoder's %q", refresh.Metadata.GeoLocation, "Lisbon, Portugal")
+	}
+	if n := geocoder.calls.Load(); n != 1 {
+		t.Errorf("geocoder called %d times, want 1", n)
+	}
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+// Reverse geocodes with the OpenStreetMap Nominatim API, within its usage policy of one request
+// per second.
+type NominatimGeocoder struct {
+	httpClient  *http.Client
+	rateLimiter *rate.Limiter
+}
+
+// Models the subset of Nominatim’s response that we care about
+// (settlement and administrative levels, country).
+type NominatimResponse struct {
+	Address struct {
+		City          string `json:"city"`
+		Town          string `json:"town"`
+		Village       string `json:"village"`
+		Hamlet        string `json:"hamlet"`
+		Suburb        string `json:"suburb"`
+		Municipality  string `json:"municipality"`
+		County        string `json:"county"`
+		StateDistrict string `json:"state_district"`
+		State         string `json:"state"`
+		Country       string `json:"country"`
+		CountryCode   string `json:"country_code"`
+	} `json:"address"`
+}
+
+// Returns a Nominatim client with its own HTTP client and a limiter of 1 request/sec, so share
+// one per process.
+func NewNominatimGeocoder() *NominatimGeocoder {
+	return &NominatimGeocoder{
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		rateLimiter: rate.NewLimiter(
+			rate.Limit(1), // 1 request/sec
+			1,             // burst size
+		),
+	}
+}
+
+// Looks coordinates up with Nominatim, waiting for the rate limit first. No result is an empty
+// location, not an error.
+func (n *NominatimGeocoder) ReverseGeocode(ctx context.Context, coordinates models.Coordinates) (models.LocationParts, error) {
+	lat, lng, err := parseCoordinates(coordinates)
+	if err != nil {
+		return models.LocationParts{}, err
+	}
+
+	// Rate limit before making API call
+	if err := n.rateLimiter.Wait(ctx); err != nil {
+		return models.LocationParts{}, fmt.Errorf("%w: geocoding rate limit wait: %w", apperrors.ErrUnavailable, err)
+	}
+	return n.fetchLocation(ctx, lat, lng)
+}
+
+// Performs the actual HTTP request and parses the response.
+func (n *NominatimGeocoder) fetchLocation(ctx context.Context, lat, lng float64) (models.LocationParts, error) {
+	url := fmt.Sprintf(
+		"https://nominatim.openstreetmap.org/reverse?format=json&lat=%f&lon=%f&zoom=18&addressdetails=1",
+		lat, lng,
+	)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return models.LocationParts{}, err
+	}
+
+	req.Header.Set("User-Agent", "Trekka")
+	req.Header.Set("Accept-Language", "en")
+	req.Header.Set("Referer", "https://trekka.co.uk")
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return models.LocationParts{}, fmt.Errorf("%w: nominatim request failed: %w", apperrors.ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.LocationParts{}, fmt.Errorf("%w: nominatim returned status %d", apperrors.ErrUnavailable, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return models.LocationParts{}, err
+	}
+
+	var data NominatimResponse
+	if err := json.Unmarshal(body, &data); err != nil {
+		return models.LocationParts{}, err
+	}
+
+	return n.extractLocation(data), nil
+}
+
+// Picks the most specific available name for each level of the hierarchy, keeping the levels
+// Nominatim named as well.
+//
+// City falls back from settlements to administrative areas, most specific first:
+//
+//	city, town, village, hamlet, suburb, municipality, county, state_district, state
+//
+// Remote places such as national parks have no settlement, but usually a municipality or
+// county, e.g. a point in Torres del Paine gets the Torres del Paine municipality rather than
+// just "Chile". City is only empty when Nominatim named none of these.
+func (n *NominatimGeocoder) extractLocation(r NominatimResponse) models.LocationParts {
+	return models.LocationParts{
+		City: firstNonEmpty(
+			r.Address.City,
+			r.Address.Town,
+			r.Address.Village,
+			r.Address.Hamlet,
+			r.Address.Suburb,
+			r.Address.Municipality,
+			r.Address.County,
+			r.Address.StateDistrict,
+			r.Address.State,
+		),
+		Region:      firstNonEmpty(r.Address.State, r.Address.County),
+		Country:     r.Address.Country,
+		CountryCode: strings.ToUpper(r.Address.CountryCode),
+		Town:        r.Address.Town,
+		Village:     r.Address.Village,
+		State:       r.Address.State,
+		County:      r.Address.County,
+	}
+}
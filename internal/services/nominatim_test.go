@@ -0,0 +1,61 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"trekka-api/internal/models"
+)
+
+// Answers every request with a saved Nominatim reverse response.
+type nominatimFixture string
+
+func (f nominatimFixture) RoundTrip(r *http.Request) (*http.Response, error) {
+	body, err := os.ReadFile(filepath.Join("testdata", "nominatim", string(f)))
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    r,
+	}, nil
+}
+
+func TestNominatimFallsBackToFinerLevels(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    models.LocationParts
+	}{
+		// A settlement wins over the suburb and municipality named with it
+		{"lisbon.json", models.LocationParts{City: "Lisbon", Region: "Lisbon", Country: "Portugal", CountryCode: "PT", County: "Lisbon"}},
+		{"achnasheen.json", models.LocationParts{City: "Achnasheen", Region: "Scotland", Country: "United Kingdom", CountryCode: "GB", State: "Scotland", County: "Highland"}},
+		// National parks: no settlement, so the municipality, then the county
+		{"torres_del_paine.json", models.LocationParts{
+			City: "Torres del Paine", Region: "Magallanes and Chilean Antarctica Region", Country: "Chile", CountryCode: "CL",
+			State: "Magallanes and Chilean Antarctica Region", County: "Provincia de Última Esperanza",
+		}},
+		{"yosemite.json", models.LocationParts{City: "Mariposa County", Region: "California", Country: "United States", CountryCode: "US", State: "California", County: "Mariposa County"}},
+		{"ladakh.json", models.LocationParts{City: "Leh", Region: "Ladakh", Country: "India", CountryCode: "IN", State: "Ladakh"}},
+		// Nothing below the country
+		{"greenland_ice_sheet.json", models.LocationParts{Country: "Greenland", CountryCode: "GL"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			geocoder := &NominatimGeocoder{httpClient: &http.Client{Transport: nominatimFixture(tt.fixture)}}
+			got, err := geocoder.fetchLocation(context.Background(), 0, 0)
+			if err != nil {
+				t.Fatalf("fetchLocation: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+	"trekka-api/internal/utils"
+)
+
+const syncFailuresCollection = "syncFailures"
+
+// Storage prefix quarantined objects are moved under, keeping their original path.
+const QuarantinePrefix = "quarantine/"
+
+// Counts processing failures per source file and, once a file has failed maxFailures times in a
+// row, moves it under QuarantinePrefix so backfills and refresh passes stop retrying it.
+type QuarantineService struct {
+	client      *firestore.Client
+	firestore   *FirestoreService
+	storage     *StorageService
+	maxFailures int
+}
+
+// Creates a quarantine that gives each file maxFailures attempts. Zero or less never quarantines
+// (failures are still counted).
+func NewQuarantineService(fs *FirestoreService, storage *StorageService, maxFailures int) *QuarantineService {
+	return &QuarantineService{
+		client:      fs.client,
+		firestore:   fs,
+		storage:     storage,
+		maxFailures: maxFailures,
+	}
+}
+
+// Reports whether fileName is currently quarantined.
+func (q *QuarantineService) IsQuarantined(ctx context.Context, fileName string) (bool, error) {
+	doc, err := q.failureRef(fileName).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read failure record for %s: %w", fileName, classifyError(err))
+	}
+
+	var record models.SyncFailure
+	if err := doc.DataTo(&record); err != nil {
+		return false, fmt.Errorf("failed to parse failure record for %s: %w", fileName, err)
+	}
+	return !record.QuarantinedAt.IsZero(), nil
+}
+
+// Counts a failed attempt at processing fileName and quarantines it when the budget is used up.
+// Failures that say nothing about the file itself (outages, rate limits, missing credentials,
+// cancellation) are not counted. Returns whether the file is now quarantined.
+func (q *QuarantineService) RecordFailure(ctx context.Context, fileName string, cause error) (bool, error) {
+	if cause == nil || !countsAgainstFile(cause) {
+		return false, nil
+	}
+
+	ref := q.failureRef(fileName)
+	var record models.SyncFailure
+	err := q.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		record = models.SyncFailure{FileName: fileName}
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := doc.DataTo(&record); err != nil {
+				return err
+			}
+		}
+		if !record.QuarantinedAt.IsZero() {
+			return nil
+		}
+
+		record.Attempts++
+		record.LastError = cause.Error()
+		record.LastFailedAt = time.Now()
+		return tx.Set(ref, record)
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to record failure for %s: %w", fileName, classifyError(err))
+	}
+
+	if !record.QuarantinedAt.IsZero() {
+		return true, nil
+	}
+	if q.maxFailures <= 0 || record.Attempts < q.maxFailures {
+		return false, nil
+	}
+
+	if err := q.quarantine(ctx, &record); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Forgets earlier failures of fileName after it was processed successfully.
+func (q *QuarantineService) RecordSuccess(ctx context.Context, fileName string) error {
+	if _, err := q.failureRef(fileName).Delete(ctx); err != nil && status.Code(err) != codes.NotFound {
+		return fmt.Errorf("failed to clear failure record for %s: %w", fileName, classifyError(err))
+	}
+	return nil
+}
+
+// Lists quarantined files, most recently quarantined first.
+func (q *QuarantineService) List(ctx context.Context) ([]*models.SyncFailure, error) {
+	iter := q.client.Collection(syncFailuresCollection).Documents(ctx)
+	defer iter.Stop()
+
+	records := make([]*models.SyncFailure, 0)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list failure records: %w", classifyError(err))
+		}
+
+		var record models.SyncFailure
+		if err := doc.DataTo(&record); err != nil {
+			continue
+		}
+		if !record.QuarantinedAt.IsZero() {
+			records = append(records, &record)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].QuarantinedAt.After(records[j].QuarantinedAt)
+	})
+	return records, nil
+}
+
+// Takes fileName out of quarantine: moves its object back, clears the document's status and
+// forgets its failures so the next sync processes it afresh. Returns ErrNotFound if the file
+// isn't quarantined.
+func (q *QuarantineService) Restore(ctx context.Context, fileName string) (*models.SyncFailure, error) {
+	ref := q.failureRef(fileName)
+	doc, err := ref.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%w: %s is not quarantined", apperrors.ErrNotFound, fileName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read failure record for %s: %w", fileName, classifyError(err))
+	}
+
+	var record models.SyncFailure
+	if err := doc.DataTo(&record); err != nil {
+		return nil, fmt.Errorf("failed to parse failure record for %s: %w", fileName, err)
+	}
+	if record.QuarantinedAt.IsZero() {
+		return nil, fmt.Errorf("%w: %s is not quarantined", apperrors.ErrNotFound, fileName)
+	}
+
+	if record.StoragePath != "" {
+		if _, err := q.storage.MoveFile(ctx, QuarantinePrefix+record.StoragePath, record.StoragePath); err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", record.StoragePath, err)
+		}
+	}
+
+	metadata, err := q.firestore.GetImageMetadataByFilename(ctx, fileName, strings.TrimPrefix(path.Ext(fileName), "."))
+	if err != nil && !errors.Is(err, apperrors.ErrNotFound) {
+		return nil, err
+	}
+	if metadata != nil && metadata.Status == models.StatusQuarantined {
+		storagePath := strings.TrimPrefix(metadata.StoragePath, QuarantinePrefix)
+		if err := q.firestore.SetStatus(ctx, metadata.Id, "", storagePath); err != nil {
+			return nil, fmt.Errorf("failed to clear status of %s: %w", fileName, err)
+		}
+	}
+
+	if _, err := ref.Delete(ctx); err != nil {
+		return nil, fmt.Errorf("failed to clear failure record for %s: %w", fileName, classifyError(err))
+	}
+
+	log.Printf("[Quarantine] Restored %s", fileName)
+	return &record, nil
+}
+
+// Moves the file's object under QuarantinePrefix, marks its document (if any) as quarantined
+// and stamps the failure record.
+func (q *QuarantineService) quarantine(ctx context.Context, record *models.SyncFailure) error {
+	metadata, err := q.firestore.GetImageMetadataByFilename(ctx, record.FileName, strings.TrimPrefix(path.Ext(record.FileName), "."))
+	if err != nil && !errors.Is(err, apperrors.ErrNotFound) {
+		return err
+	}
+
+	// Without a document, the object (if any was uploaded) is still under the source name
+	storagePath := record.FileName
+	if metadata != nil && metadata.StoragePath != "" {
+		storagePath = metadata.StoragePath
+	}
+
+	moved, err := q.storage.MoveFile(ctx, storagePath, QuarantinePrefix+storagePath)
+	if err != nil {
+		return fmt.Errorf("failed to quarantine %s: %w", record.FileName, err)
+	}
+	if moved {
+		record.StoragePath = storagePath
+	}
+
+	if metadata != nil {
+		newPath := metadata.StoragePath
+		if moved {
+			newPath = QuarantinePrefix + storagePath
+		}
+		if err := q.firestore.SetStatus(ctx, metadata.Id, models.StatusQuarantined, newPath); err != nil {
+			return fmt.Errorf("failed to mark %s as quarantined: %w", record.FileName, err)
+		}
+	}
+
+	record.QuarantinedAt = time.Now()
+	if _, err := q.failureRef(record.FileName).Set(ctx, record); err != nil {
+		return fmt.Errorf("failed to record quarantine of %s: %w", record.FileName, classifyError(err))
+	}
+
+	log.Printf("[Quarantine] Quarantined %s after %d failures (last: %s)", record.FileName, record.Attempts, record.LastError)
+	return nil
+}
+
+// Failure records are keyed by a hash of the file name, which may contain characters
+// that aren't allowed in document IDs.
+func (q *QuarantineService) failureRef(fileName string) *firestore.DocumentRef {
+	return q.client.Collection(syncFailuresCollection).Doc(utils.ContentHash([]byte(fileName))[:32])
+}
+
+// Reports whether an error reflects on the file being processed rather than on the environment.
+// A failed upload checksum points at the connection, not the file.
+func countsAgainstFile(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, apperrors.ErrChecksumMismatch) {
+		return false
+	}
+	err = classifyError(err)
+	return !errors.Is(err, apperrors.ErrUnavailable) && !errors.Is(err, apperrors.ErrUnauthorized)
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+func TestQuarantineMovesAndRestoresFile(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	storage, gcs := newFakeStorage(t)
+	ctx := context.Background()
+	quarantine := NewQuarantineService(fs, storage, 2)
+
+	gcs.Put("2024/05/IMG_1.jpg", []byte("truncated"), "image/jpeg")
+	seedImage(t, fs, "img-1", &models.ImageMetadata{
+		FileName: "IMG_1.jpg", StoragePath: "2024/05/IMG_1.jpg", TakenAt: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+	})
+
+	// Outages say nothing about the file and aren't counted
+	if quarantined, err := quarantine.RecordFailure(ctx, "IMG_1.jpg", context.Canceled); err != nil || quarantined {
+		t.Fatalf("RecordFailure(canceled) = %t, %v; want not counted", quarantined, err)
+	}
+	corrupt := errors.New("unexpected EOF")
+	if quarantined, err := quarantine.RecordFailure(ctx, "IMG_1.jpg", corrupt); err != nil || quarantined {
+		t.Fatalf("first failure = %t, %v; want not yet quarantined", quarantined, err)
+	}
+	if quarantined, err := quarantine.RecordFailure(ctx, "IMG_1.jpg", corrupt); err != nil || !quarantined {
+		t.Fatalf("second failure = %t, %v; want quarantined", quarantined, err)
+	}
+
+	if _, ok := gcs.Get("2024/05/IMG_1.jpg"); ok {
+		t.Error("object still at its original path after quarantine")
+	}
+	if _, ok := gcs.Get(QuarantinePrefix + "2024/05/IMG_1.jpg"); !ok {
+		t.Error("object not moved under the quarantine prefix")
+	}
+	stored, err := fs.GetImageMetadata(ctx, "img-1")
+	if err != nil {
+		t.Fatalf("GetImageMetadata: %v", err)
+	}
+	if stored.Status != models.StatusQuarantined || stored.StoragePath != QuarantinePrefix+"2024/05/IMG_1.jpg" {
+		t.Errorf("document = status %q at %q, want quarantined under the prefix", stored.Status, stored.StoragePath)
+	}
+	listed, err := fs.ListImageMetadata(ctx, 10, 0)
+	if err != nil {
+		t.Fatalf("ListImageMetadata: %v", err)
+	}
+	if len(listed) != 0 {
+		t.Errorf("listing returned %d images, want the quarantined one left out", len(listed))
+	}
+	records, err := quarantine.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(records) != 1 || records[0].Attempts != 2 || records[0].LastError != corrupt.Error() {
+		t.Errorf("quarantine list = %+v, want IMG_1.jpg after 2 attempts", records)
+	}
+
+	if _, err := quarantine.Restore(ctx, "IMG_1.jpg"); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	if _, ok := gcs.Get("2024/05/IMG_1.jpg"); !ok {
+		t.Error("object not moved back on restore")
+	}
+	stored, err = fs.GetImageMetadata(ctx, "img-1")
+	if err != nil {
+		t.Fatalf("GetImageMetadata: %v", err)
+	}
+	if stored.Hidden() || stored.StoragePath != "2024/05/IMG_1.jpg" {
+		t.Errorf("document = status %q at %q, want visible at its original path", stored.Status, stored.StoragePath)
+	}
+	listed, err = fs.ListImageMetadata(ctx, 10, 0)
+	if err != nil {
+		t.Fatalf("ListImageMetadata: %v", err)
+	}
+	if len(listed) != 1 {
+		t.Errorf("listing returned %d images after restore, want 1", len(listed))
+	}
+	// The next backfill processes the file again, with a fresh budget
+	if quarantined, err := quarantine.IsQuarantined(ctx, "IMG_1.jpg"); err != nil || quarantined {
+		t.Errorf("IsQuarantined after restore = %t, %v; want false", quarantined, err)
+	}
+	if quarantined, err := quarantine.RecordFailure(ctx, "IMG_1.jpg", corrupt); err != nil || quarantined {
+		t.Errorf("failure after restore = %t, %v; want counted from zero", quarantined, err)
+	}
+
+	if _, err := quarantine.Restore(ctx, "IMG_1.jpg"); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("restoring a file that isn't quarantined = %v, want ErrNotFound", err)
+	}
+}
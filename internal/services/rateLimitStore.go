@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"trekka-api/internal/utils"
+)
+
+const rateLimitCollection = "rateLimits"
+
+// Enforces a request budget shared across all instances using Firestore counters.
+// Each key gets one counter per fixed window, split over a few shards so concurrent
+// increments from many containers don't contend on a single document. The previous
+// window is blended in (sliding-window estimate) so bursts straddling a boundary and
+// small clock skew between instances don't let a client double its budget.
+type DistributedRateLimiter struct {
+	client *firestore.Client
+	limit  int
+	window time.Duration
+	shards int
+	now    func() time.Time
+}
+
+// Creates a limiter allowing limit requests per key per window, stored alongside the image collection.
+func NewDistributedRateLimiter(fs *FirestoreService, limit int, window time.Duration) *DistributedRateLimiter {
+	return &DistributedRateLimiter{
+		client: fs.client,
+		limit:  limit,
+		window: window,
+		shards: 4,
+		now:    time.Now,
+	}
+}
+
+// Records a request for key and reports whether it is within the shared budget.
+func (l *DistributedRateLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	now := l.now().UTC()
+	windowStart := now.Truncate(l.window)
+	prevStart := windowStart.Add(-l.window)
+
+	// Atomic server-side increment on a random shard; safe under concurrency without a transaction
+	shard := rand.IntN(l.shards)
+	ref := l.client.Collection(rateLimitCollection).Doc(l.docID(key, windowStart, shard))
+	if _, err := ref.Set(ctx, map[string]interface{}{
+		"count":     firestore.Increment(1),
+		"key":       key,
+		"window":    windowStart,
+		"expiresAt": windowStart.Add(3 * l.window), // For a Firestore TTL policy
+	}, firestore.MergeAll); err != nil {
+		return false, fmt.Errorf("failed to increment rate limit counter: %w", err)
+	}
+
+	current, err := l.sum(ctx, key, windowStart)
+	if err != nil {
+		return false, err
+	}
+	previous, err := l.sum(ctx, key, prevStart)
+	if err != nil {
+		return false, err
+	}
+
+	// Weight the previous window by how much of it still overlaps the sliding window
+	elapsed := float64(now.Sub(windowStart)) / float64(l.window)
+	estimate := float64(previous)*(1-elapsed) + float64(current)
+
+	return estimate <= float64(l.limit), nil
+}
+
+// Sums all shards of a key's counter for the given window.
+func (l *DistributedRateLimiter) sum(ctx context.Context, key string, windowStart time.Time) (int64, error) {
+	refs := make([]*firestore.DocumentRef, l.shards)
+	for i := range refs {
+		refs[i] = l.client.Collection(rateLimitCollection).Doc(l.docID(key, windowStart, i))
+	}
+
+	docs, err := l.client.GetAll(ctx, refs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read rate limit counters: %w", err)
+	}
+
+	var total int64
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		if count, err := doc.DataAt("count"); err == nil {
+			if n, ok := count.(int64); ok {
+				total += n
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// Builds the counter document ID for a key, window and shard.
+// Keys are hashed since client IPs may contain characters Firestore IDs don't allow.
+func (l *DistributedRateLimiter) docID(key string, windowStart time.Time, shard int) string {
+	return fmt.Sprintf("%s_%d_%d", utils.ContentHash([]byte(key))[:16], windowStart.Unix(), shard)
+}
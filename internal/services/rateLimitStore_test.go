@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Returns two limiters sharing one emulator project, standing in for two server instances, with
+// their clocks fixed at now.
+func newRateLimiterPair(t *testing.T, limit int, window time.Duration, now time.Time) (*DistributedRateLimiter, *DistributedRateLimiter) {
+	t.Helper()
+	fs := newEmulatorFirestore(t)
+	a := NewDistributedRateLimiter(fs, limit, window)
+	b := NewDistributedRateLimiter(fs, limit, window)
+	a.now = func() time.Time { return now }
+	b.now = func() time.Time { return now }
+	return a, b
+}
+
+func TestDistributedRateLimitHoldsAcrossInstances(t *testing.T) {
+	const limit = 10
+	windowStart := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	a, b := newRateLimiterPair(t, limit, time.Minute, windowStart)
+	ctx := context.Background()
+
+	// Requests alternating between instances share one budget
+	allowed := 0
+	for i := 0; i < 2*limit; i++ {
+		instance := a
+		if i%2 == 1 {
+			instance = b
+		}
+		ok, err := instance.Allow(ctx, "203.0.113.7")
+		if err != nil {
+			t.Fatalf("Allow: %v", err)
+		}
+		if ok {
+			allowed++
+		}
+	}
+	if allowed != limit {
+		t.Errorf("allowed %d of %d requests across two instances, want %d", allowed, 2*limit, limit)
+	}
+
+	// Other keys have budgets of their own
+	if ok, err := a.Allow(ctx, "198.51.100.1"); err != nil || !ok {
+		t.Errorf("Allow for another key = %v, %v; want allowed", ok, err)
+	}
+}
+
+func TestDistributedRateLimitUnderConcurrency(t *testing.T) {
+	const limit = 20
+	windowStart := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	a, b := newRateLimiterPair(t, limit, time.Minute, windowStart)
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 3*limit; i++ {
+		instance := a
+		if i%2 == 1 {
+			instance = b
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := instance.Allow(context.Background(), "203.0.113.7")
+			if err != nil {
+				t.Errorf("Allow: %v", err)
+				return
+			}
+			if ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every allowed request counted itself and everything incremented before it, so concurrent
+	// increments can only make the limiter stricter, never let more than limit through
+	if got := allowed.Load(); got > limit {
+		t.Errorf("allowed %d of %d concurrent requests, want at most %d", got, 3*limit, limit)
+	}
+}
+
+func TestDistributedRateLimitBlendsPreviousWindow(t *testing.T) {
+	const limit = 10
+	windowStart := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	a, b := newRateLimiterPair(t, limit, time.Minute, windowStart)
+	ctx := context.Background()
+
+	// Spend the whole budget at the very start of one window
+	for i := 0; i < limit; i++ {
+		if ok, err := a.Allow(ctx, "203.0.113.7"); err != nil || !ok {
+			t.Fatalf("request %d = %v, %v; want allowed", i, ok, err)
+		}
+	}
+
+	// Halfway through the next window on the other instance, half the previous window still
+	// counts: 5 carried over leaves room for 5 more, not a fresh 10
+	b.now = func() time.Time { return windowStart.Add(90 * time.Second) }
+	allowed := 0
+	for i := 0; i < limit; i++ {
+		ok, err := b.Allow(ctx, "203.0.113.7")
+		if err != nil {
+			t.Fatalf("Allow: %v", err)
+		}
+		if ok {
+			allowed++
+		}
+	}
+	if allowed != limit/2 {
+		t.Errorf("allowed %d requests halfway into the next window, want %d", allowed, limit/2)
+	}
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Whether the server can take traffic: not until initialization has finished, and not while
+// Firestore (which nearly every endpoint reads) is unusable. Checked by /readyz.
+type Readiness struct {
+	ready     atomic.Bool
+	firestore *FirestoreService
+
+	mu     sync.Mutex // Held while probing, so concurrent probes share one Firestore read
+	err    error
+	expiry time.Time
+}
+
+// Creates a readiness state that reports not ready until MarkReady is called.
+func NewReadiness(firestore *FirestoreService) *Readiness {
+	return &Readiness{firestore: firestore}
+}
+
+// Marks initialization as finished.
+func (r *Readiness) MarkReady() {
+	r.ready.Store(true)
+}
+
+// Returns nil if the server is ready, or why it isn't. After initialization, Firestore is
+// probed with a cheap read under healthCheckTimeout, and the outcome is reused for healthCacheTTL.
+func (r *Readiness) Check(ctx context.Context) error {
+	if r == nil || !r.ready.Load() {
+		return errors.New("services are still initializing")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Now().Before(r.expiry) {
+		return r.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), healthCheckTimeout)
+	defer cancel()
+
+	r.err = r.firestore.Ping(ctx)
+	r.expiry = time.Now().Add(healthCacheTTL)
+	return r.err
+}
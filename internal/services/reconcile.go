@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+// Object prefixes that hold no media of their own: generated renditions, quarantined files
+// (their documents point there, so they still count for dangling checks) and doctor probes.
+var reconcileSkipPrefixes = []string{"derived/", QuarantinePrefix, "_doctor/"}
+
+// Compares the bucket with the collection: objects without a document (failed persists),
+// documents whose object is gone (renamed or deleted files) and content-type disagreements.
+type ReconcileService struct {
+	firestore  *FirestoreService
+	storage    *StorageService
+	geocoder   *GeocodingService
+	evictCache func(keys ...string) // Optional; drops cached entries of soft-deleted documents
+	running    sync.Mutex
+}
+
+func NewReconcileService(firestore *FirestoreService, storage *StorageService, geocoder *GeocodingService) *ReconcileService {
+	if geocoder == nil {
+		geocoder = NewGeocodingService()
+	}
+	return &ReconcileService{firestore: firestore, storage: storage, geocoder: geocoder}
+}
+
+// Evicts the cached entries of documents soft-deleted by a fix, by ID and file name.
+func (r *ReconcileService) SetCacheEvictor(evict func(keys ...string)) {
+	r.evictCache = evict
+}
+
+// Lists every object and document and reports the differences. With fix, orphan objects are
+// given a document by running extraction on them as a sync would, and dangling documents are
+// marked models.StatusDeleted (kept, but left out of listings); failures are collected in the
+// report rather than stopping the run. Returns ErrConflict if a run is already in progress.
+func (r *ReconcileService) Run(ctx context.Context, fix bool) (*models.ReconcileReport, error) {
+	if !r.running.TryLock() {
+		return nil, fmt.Errorf("%w: reconciliation already running", apperrors.ErrConflict)
+	}
+	defer r.running.Unlock()
+
+	start := time.Now()
+	report := &models.ReconcileReport{
+		OrphanObjects:         []models.OrphanObject{},
+		DanglingDocuments:     []models.DanglingDocument{},
+		ContentTypeMismatches: []models.ContentTypeMismatch{},
+		Fix:                   fix,
+	}
+
+	objects, err := r.storage.ListObjects(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]*storage.ObjectAttrs, len(objects))
+	for _, obj := range objects {
+		byPath[obj.Name] = obj
+	}
+
+	referenced := make(map[string]bool)
+	var dangling []*models.ImageMetadata
+	err = r.firestore.ForEachImageMetadata(ctx, 500, func(img *models.ImageMetadata) error {
+		if img.Status == models.StatusDeleted {
+			return nil
+		}
+		report.Documents++
+		referenced[img.StoragePath] = true
+
+		obj, ok := byPath[img.StoragePath]
+		if !ok {
+			report.DanglingDocuments = append(report.DanglingDocuments, models.DanglingDocument{
+				Id:          img.Id,
+				FileName:    img.FileName,
+				StoragePath: img.StoragePath,
+			})
+			dangling = append(dangling, img)
+			return nil
+		}
+		if img.ContentType != "" && obj.ContentType != "" && img.ContentType != obj.ContentType {
+			report.ContentTypeMismatches = append(report.ContentTypeMismatches, models.ContentTypeMismatch{
+				Id:                img.Id,
+				StoragePath:       img.StoragePath,
+				DocumentType:      img.ContentType,
+				ObjectContentType: obj.ContentType,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read documents: %w", err)
+	}
+
+	for _, obj := range objects {
+		if skipReconcile(obj.Name) {
+			continue
+		}
+		report.Objects++
+		if !referenced[obj.Name] {
+			report.OrphanObjects = append(report.OrphanObjects, models.OrphanObject{
+				StoragePath: obj.Name,
+				ContentType: obj.ContentType,
+				Size:        obj.Size,
+				Updated:     obj.Updated,
+			})
+		}
+	}
+	sort.Slice(report.OrphanObjects, func(i, j int) bool {
+		return report.OrphanObjects[i].StoragePath < report.OrphanObjects[j].StoragePath
+	})
+
+	if fix {
+		r.fixOrphans(ctx, report)
+		r.fixDangling(ctx, dangling, report)
+	}
+
+	report.DurationMs = time.Since(start).Milliseconds()
+	log.Printf("[Reconcile] %d objects, %d documents: %d orphan objects, %d dangling documents, %d content-type mismatches (fix=%v, created=%d, soft-deleted=%d, failed=%d) in %v",
+		report.Objects, report.Documents, len(report.OrphanObjects), len(report.DanglingDocuments), len(report.ContentTypeMismatches),
+		fix, len(report.Created), len(report.SoftDeleted), len(report.FixErrors), time.Since(start))
+
+	return report, nil
+}
+
+// Creates documents for orphan objects by downloading them and running extraction.
+func (r *ReconcileService) fixOrphans(ctx context.Context, report *models.ReconcileReport) {
+	for _, orphan := range report.OrphanObjects {
+		if ctx.Err() != nil {
+			report.FixErrors = append(report.FixErrors, fmt.Sprintf("%s: %v", orphan.StoragePath, ctx.Err()))
+			return
+		}
+
+		data, err := r.storage.FetchFile(ctx, orphan.StoragePath)
+		if err != nil {
+			report.FixErrors = append(report.FixErrors, fmt.Sprintf("%s: %v", orphan.StoragePath, err))
+			continue
+		}
+		// The key may carry a layout prefix (2024/05/); the document is named after the file
+		extracted, err := extractMetadata(ctx, r.firestore, r.geocoder, path.Base(orphan.StoragePath), orphan.ContentType, mediaSource{data: data}, nil)
+		if err != nil {
+			report.FixErrors = append(report.FixErrors, fmt.Sprintf("%s: %v", orphan.StoragePath, err))
+			continue
+		}
+		extracted.StoragePath = orphan.StoragePath
+		if _, err := persistExtracted(ctx, r.firestore, extracted, "", nil, nil); err != nil {
+			report.FixErrors = append(report.FixErrors, fmt.Sprintf("%s: %v", orphan.StoragePath, err))
+			continue
+		}
+
+		log.Printf("[Reconcile] Created metadata for orphan object %s", orphan.StoragePath)
+		report.Created = append(report.Created, orphan.StoragePath)
+	}
+}
+
+// Marks dangling documents as deleted, keeping their storagePath for reference.
+func (r *ReconcileService) fixDangling(ctx context.Context, dangling []*models.ImageMetadata, report *models.ReconcileReport) {
+	for _, img := range dangling {
+		if err := r.firestore.SetStatus(ctx, img.Id, models.StatusDeleted, img.StoragePath); err != nil {
+			report.FixErrors = append(report.FixErrors, fmt.Sprintf("%s: %v", img.Id, err))
+			continue
+		}
+		if r.evictCache != nil {
+			r.evictCache(img.Id, img.FileName)
+		}
+
+		log.Printf("[Reconcile] Soft-deleted %s (%s): %s no longer exists", img.Id, img.FileName, img.StoragePath)
+		report.SoftDeleted = append(report.SoftDeleted, img.Id)
+	}
+}
+
+// Reports whether an object is outside the media the collection describes.
+func skipReconcile(name string) bool {
+	for _, prefix := range reconcileSkipPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return strings.HasSuffix(name, "/")
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"syscall"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Attempts withRetry and withStorageRetry make, including the first.
+const retryMaxAttempts = 3
+
+// Delay before the first retry; it doubles with each attempt, plus up to 50% jitter.
+const retryBaseDelay = 200 * time.Millisecond
+
+// Runs fn, retrying it with exponential backoff while it fails with a transient Firestore error
+// (see isRetryable). Any other error, or the last transient one, is returned as is. Gives up
+// early with ctx.Err() if ctx is done while waiting. fn must be safe to repeat: a write that
+// timed out may still have landed.
+func withRetry(ctx context.Context, op string, fn func() error) error {
+	return retryTransient(ctx, "Firestore", op, isRetryable, fn)
+}
+
+// Runs a GCS call like withRetry, retrying 5xx and 429 responses, gRPC Unavailable-style codes and
+// dropped connections (see isStorageRetryable). The client library retries some of these itself,
+// but not for every call and precondition we use. fn must be safe to repeat; an upload must
+// start a fresh writer each attempt so a partial write is discarded rather than resumed.
+func withStorageRetry(ctx context.Context, op string, fn func() error) error {
+	return retryTransient(ctx, "Storage", op, isStorageRetryable, fn)
+}
+
+// Shared backoff loop behind withRetry and withStorageRetry; tag prefixes the retry log lines.
+func retryTransient(ctx context.Context, tag, op string, retryable func(error) bool, fn func() error) error {
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == retryMaxAttempts || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		wait := delay + rand.N(delay/2)
+		log.Printf("[%s] %s failed (attempt %d/%d), retrying in %v: %v", tag, op, attempt, retryMaxAttempts, wait.Round(time.Millisecond), err)
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// Waits for d, returning ctx.Err() as soon as ctx is done instead of sleeping on.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Reports whether err is a gRPC error Firestore returns under load that is worth retrying.
+// NotFound, PermissionDenied, FailedPrecondition and the like pass through untouched.
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// Reports whether err is a transient GCS failure: a 5xx or 429 from the JSON API, a retryable gRPC
+// code, or a connection reset or cut short mid-response. 4xx answers (not found, precondition
+// failed, checksum rejected) pass through untouched.
+func isStorageRetryable(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code >= 500 || apiErr.Code == http.StatusTooManyRequests
+	}
+	if isRetryable(err) {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+}
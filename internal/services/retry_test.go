@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/testutil"
+)
+
+// Returns a call that fails with err the first failures times, then succeeds, and its call count.
+func failingCall(failures int, err error) (func() error, *int) {
+	calls := 0
+	return func() error {
+		calls++
+		if calls <= failures {
+			return err
+		}
+		return nil
+	}, &calls
+}
+
+func TestWithRetry(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "backend unavailable")
+
+	tests := []struct {
+		name      string
+		failures  int
+		err       error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"first attempt succeeds", 0, unavailable, 1, false},
+		{"recovers after transient errors", 2, unavailable, 3, false},
+		{"gives up after the last attempt", 5, unavailable, retryMaxAttempts, true},
+		{"deadline exceeded is transient", 1, status.Error(codes.DeadlineExceeded, "slow"), 2, false},
+		{"resource exhausted is transient", 1, status.Error(codes.ResourceExhausted, "quota"), 2, false},
+		{"not found is returned at once", 5, status.Error(codes.NotFound, "no such document"), 1, true},
+		{"permission denied is returned at once", 5, status.Error(codes.PermissionDenied, "denied"), 1, true},
+		{"plain errors are returned at once", 5, errors.New("parse failure"), 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn, calls := failingCall(tt.failures, tt.err)
+			err := withRetry(context.Background(), "test", fn)
+			if *calls != tt.wantCalls {
+				t.Errorf("made %d calls, want %d", *calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil && err != tt.err {
+				t.Errorf("err = %v, want the call's own error %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestWithRetryStopsWhenContextEnds(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	fn, calls := failingCall(5, status.Error(codes.Unavailable, "backend unavailable"))
+	start := time.Now()
+	err := withRetry(ctx, "test", fn)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context's error", err)
+	}
+	if *calls != 1 {
+		t.Errorf("made %d calls, want 1 before the context ended", *calls)
+	}
+	if elapsed := time.Since(start); elapsed >= retryBaseDelay {
+		t.Errorf("returned after %v, want as soon as the context ended", elapsed)
+	}
+}
+
+func TestIsStorageRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{&googleapi.Error{Code: http.StatusInternalServerError}, true},
+		{&googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{fmt.Errorf("read: %w", &googleapi.Error{Code: http.StatusBadGateway}), true},
+		{&googleapi.Error{Code: http.StatusNotFound}, false},
+		{&googleapi.Error{Code: http.StatusPreconditionFailed}, false},
+		{&googleapi.Error{Code: http.StatusBadRequest}, false},
+		{status.Error(codes.Unavailable, "unavailable"), true},
+		{status.Error(codes.NotFound, "not found"), false},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{io.ErrUnexpectedEOF, true},
+		{io.EOF, false},
+		{errors.New("checksum mismatch"), false},
+	}
+	for _, tt := range tests {
+		if got := isStorageRetryable(tt.err); got != tt.want {
+			t.Errorf("isStorageRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFirestoreReadsRetryDuringOutage(t *testing.T) {
+	tests := []struct {
+		code      codes.Code
+		wantCalls int64
+		wantErr   error
+	}{
+		{codes.Unavailable, retryMaxAttempts, apperrors.ErrUnavailable},
+		{codes.PermissionDenied, 1, apperrors.ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			outage := testutil.NewFirestoreOutage(t, tt.code)
+			fs := NewFirestoreService(outage.Client, "images")
+
+			_, err := fs.GetImageMetadata(context.Background(), "img-1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if n := outage.Calls(); n != tt.wantCalls {
+				t.Errorf("backend saw %d calls, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
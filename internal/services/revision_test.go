@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+// Seeds an image and returns it as read back, with the revision a writer would hold.
+func seedRevision(t *testing.T, fs *FirestoreService, id string) *models.ImageMetadata {
+	t.Helper()
+	seedImage(t, fs, id, &models.ImageMetadata{
+		FileName:    id + ".jpg",
+		ContentType: "image/jpeg",
+		StoragePath: "2024/06/" + id + ".jpg",
+		TakenAt:     time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	})
+	metadata, err := fs.GetImageMetadata(context.Background(), id)
+	if err != nil {
+		t.Fatalf("read %s: %v", id, err)
+	}
+	if metadata.Revision.IsZero() {
+		t.Fatalf("read %s without a revision", id)
+	}
+	return metadata
+}
+
+// Changes the document behind the reader's back, moving its update time past the revision read.
+func writeConcurrently(t *testing.T, fs *FirestoreService, id string) {
+	t.Helper()
+	updates := []firestore.Update{{Path: "description", Value: "edited elsewhere"}}
+	if _, err := fs.client.Collection(fs.collection).Doc(id).Update(context.Background(), updates); err != nil {
+		t.Fatalf("concurrent write to %s: %v", id, err)
+	}
+}
+
+func TestReplaceAtStaleRevisionConflicts(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+
+	stale := seedRevision(t, fs, "img-1")
+	writeConcurrently(t, fs, "img-1")
+
+	stale.Country = "Norway"
+	err := fs.ReplaceImageMetadataAt(ctx, "img-1", stale, stale.Revision)
+	if !errors.Is(err, apperrors.ErrConflict) {
+		t.Fatalf("replace at a stale revision: err = %v, want ErrConflict", err)
+	}
+	got, err := fs.GetImageMetadata(ctx, "img-1")
+	if err != nil {
+		t.Fatalf("re-read: %v", err)
+	}
+	if got.Country != "" || got.Description != "edited elsewhere" {
+		t.Errorf("after the conflict country = %q, description = %q; want the concurrent write kept", got.Country, got.Description)
+	}
+
+	// A writer that re-reads and merges again gets through
+	got.Country = "Norway"
+	if err := fs.ReplaceImageMetadataAt(ctx, "img-1", got, got.Revision); err != nil {
+		t.Fatalf("replace at the fresh revision: %v", err)
+	}
+	got, err = fs.GetImageMetadata(ctx, "img-1")
+	if err != nil {
+		t.Fatalf("re-read: %v", err)
+	}
+	if got.Country != "Norway" || got.Description != "edited elsewhere" {
+		t.Errorf("after the retry country = %q, description = %q; want both writes", got.Country, got.Description)
+	}
+}
+
+func TestReplaceAtRevision(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+
+	t.Run("zero revision writes unconditionally", func(t *testing.T) {
+		stale := seedRevision(t, fs, "img-zero")
+		writeConcurrently(t, fs, "img-zero")
+
+		stale.Country = "Norway"
+		if err := fs.ReplaceImageMetadataAt(ctx, "img-zero", stale, time.Time{}); err != nil {
+			t.Fatalf("replace without a revision: %v", err)
+		}
+	})
+
+	t.Run("deleted document", func(t *testing.T) {
+		stale := seedRevision(t, fs, "img-gone")
+		if err := fs.DeleteImageMetadata(ctx, "img-gone"); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+
+		err := fs.ReplaceImageMetadataAt(ctx, "img-gone", stale, stale.Revision)
+		if !errors.Is(err, apperrors.ErrNotFound) {
+			t.Errorf("replace of a deleted document: err = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestFieldUpdatesAtStaleRevisionConflict(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+
+	stale := seedRevision(t, fs, "img-1")
+	writeConcurrently(t, fs, "img-1")
+
+	err := fs.SetStoragePath(ctx, "img-1", "2024/07/img-1.jpg", stale.Revision)
+	if !errors.Is(err, apperrors.ErrConflict) {
+		t.Fatalf("SetStoragePath at a stale revision: err = %v, want ErrConflict", err)
+	}
+	coordinates := models.Coordinates{Lat: "59.913900", Lng: "10.752200"}
+	err = fs.SetCoordinates(ctx, "img-1", coordinates, CoordinatesSourceGPX, "", "", models.LocationParts{}, stale.Revision)
+	if !errors.Is(err, apperrors.ErrConflict) {
+		t.Fatalf("SetCoordinates at a stale revision: err = %v, want ErrConflict", err)
+	}
+
+	fresh, err := fs.GetImageMetadata(ctx, "img-1")
+	if err != nil {
+		t.Fatalf("re-read: %v", err)
+	}
+	if fresh.StoragePath != stale.StoragePath || fresh.Coordinates != (models.Coordinates{}) {
+		t.Fatalf("conflicting writes landed: storagePath = %q, coordinates = %+v", fresh.StoragePath, fresh.Coordinates)
+	}
+	if err := fs.SetStoragePath(ctx, "img-1", "2024/07/img-1.jpg", fresh.Revision); err != nil {
+		t.Fatalf("SetStoragePath at the fresh revision: %v", err)
+	}
+}
+
+func TestBulkUpdateReportsConflictsPerDocument(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+
+	current := seedRevision(t, fs, "img-current")
+	stale := seedRevision(t, fs, "img-stale")
+	unconditional := seedRevision(t, fs, "img-unconditional")
+	writeConcurrently(t, fs, "img-stale")
+	writeConcurrently(t, fs, "img-unconditional")
+
+	for _, m := range []*models.ImageMetadata{current, stale, unconditional} {
+		m.Country = "Norway"
+	}
+	errs := fs.BulkUpdateImageMetadata(ctx, []MetadataUpdate{
+		{Id: current.Id, Metadata: current, Revision: current.Revision},
+		{Id: stale.Id, Metadata: stale, Revision: stale.Revision},
+		{Id: unconditional.Id, Metadata: unconditional},
+	})
+
+	if errs[0] != nil {
+		t.Errorf("update at the current revision: %v", errs[0])
+	}
+	if !errors.Is(errs[1], apperrors.ErrConflict) {
+		t.Errorf("update at a stale revision: err = %v, want ErrConflict", errs[1])
+	}
+	if errs[2] != nil {
+		t.Errorf("update without a revision: %v", errs[2])
+	}
+
+	for id, want := range map[string]string{"img-current": "Norway", "img-stale": "", "img-unconditional": "Norway"} {
+		got, err := fs.GetImageMetadata(ctx, id)
+		if err != nil {
+			t.Fatalf("re-read %s: %v", id, err)
+		}
+		if got.Country != want {
+			t.Errorf("%s country = %q, want %q", id, got.Country, want)
+		}
+	}
+}
@@ -3,112 +3,491 @@ package services
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apperrors "trekka-api/internal/errors"
 )
 
 type StorageService struct {
 	client     *storage.Client
 	bucketName string
+	urlExpiry  time.Duration // Lifetime of signed URLs
+	signerID   string        // Service account signing URLs with signerKey; empty to use the client's credentials
+	signerKey  []byte
 }
 
 func NewStorageService(client *storage.Client, bucketName string) *StorageService {
 	return &StorageService{
 		client:     client,
 		bucketName: bucketName,
+		urlExpiry:  DefaultSignedURLExpiry,
 	}
 }
 
+// Largest object FetchFile will load into memory.
+const maxFetchSize = 50 * 1024 * 1024 // 50MB
+
 // Retrieves a file from Google Cloud Storage by its path.
 // Returns the file contents as bytes or an error if the file cannot be retrieved.
-// Implements a maximum file size limit to prevent memory exhaustion.
+// Files over 50MB fail with ErrTooLarge. Only for callers that need the whole file in memory
+// (e.g. EXIF and image decoding); anything that can consume a stream should use OpenFile.
 func (s *StorageService) FetchFile(ctx context.Context, storagePath string) ([]byte, error) {
-	if storagePath == "" {
-		return nil, fmt.Errorf("file path cannot be empty")
+	reader, err := s.OpenFile(ctx, storagePath, maxFetchSize)
+	if err != nil {
+		return nil, err
 	}
+	defer reader.Close()
 
-	bucket := s.client.Bucket(s.bucketName)
-	obj := bucket.Object(storagePath)
-
-	// Get object attributes to check size
-	attrs, err := obj.Attrs(ctx)
+	data, err := io.ReadAll(reader)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get file attributes: %w", err)
+		return nil, fmt.Errorf("failed to read file data: %w", err)
 	}
 
-	// Limit file size to 50MB to prevent memory exhaustion
-	const maxFileSize = 50 * 1024 * 1024 // 50MB
-	if attrs.Size > maxFileSize {
-		return nil, fmt.Errorf("file size %d bytes exceeds maximum allowed size of %d bytes", attrs.Size, maxFileSize)
+	return data, nil
+}
+
+// Opens a streaming reader for a GCS object without buffering it in memory.
+// Objects larger than maxSize (0 for no limit) are rejected before any body bytes are consumed.
+// The reader's Attrs carry the size and content type, so no separate attributes call is needed.
+// The reader is bound to ctx, so cancelling the context aborts the download; callers must Close it.
+func (s *StorageService) OpenFile(ctx context.Context, storagePath string, maxSize int64) (*storage.Reader, error) {
+	if storagePath == "" {
+		return nil, fmt.Errorf("%w: file path cannot be empty", apperrors.ErrInvalidInput)
 	}
 
-	reader, err := obj.NewReader(ctx)
+	var reader *storage.Reader
+	err := withStorageRetry(ctx, "open "+storagePath, func() (err error) {
+		reader, err = s.client.Bucket(s.bucketName).Object(storagePath).NewReader(ctx)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create file reader: %w", err)
+		return nil, fmt.Errorf("failed to create file reader: %w", classifyError(err))
 	}
-	defer reader.Close()
 
-	data, err := io.ReadAll(reader)
+	if maxSize > 0 && reader.Attrs.Size > maxSize {
+		reader.Close()
+		return nil, fmt.Errorf("%w: file size %d bytes exceeds maximum of %d bytes", apperrors.ErrTooLarge, reader.Attrs.Size, maxSize)
+	}
+
+	return reader, nil
+}
+
+// Opens a streaming reader for a byte range of a GCS object, following NewRangeReader semantics:
+// a negative offset reads the last -offset bytes, and a negative length reads to the end.
+// The reader's Attrs carry the full object size and the resolved start offset for Content-Range.
+func (s *StorageService) OpenFileRange(ctx context.Context, storagePath string, offset, length int64) (*storage.Reader, error) {
+	if storagePath == "" {
+		return nil, fmt.Errorf("%w: file path cannot be empty", apperrors.ErrInvalidInput)
+	}
+
+	var reader *storage.Reader
+	err := withStorageRetry(ctx, "open range of "+storagePath, func() (err error) {
+		reader, err = s.client.Bucket(s.bucketName).Object(storagePath).NewRangeReader(ctx, offset, length)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file data: %w", err)
+		return nil, fmt.Errorf("failed to create range reader: %w", classifyError(err))
 	}
 
-	return data, nil
+	return reader, nil
+}
+
+// Default lifetime of signed URLs; see SetSignedURLExpiry.
+const DefaultSignedURLExpiry = 15 * time.Minute
+
+// Longest lifetime GCS accepts for a V4 signed URL.
+const MaxSignedURLExpiry = 7 * 24 * time.Hour
+
+// SignedURLOptions adjusts a URL signed by GenerateSignedURLWith. Zero values keep the defaults.
+type SignedURLOptions struct {
+	Expiry              time.Duration // Lifetime of the URL; 0 for the configured expiry
+	Disposition         string        // Content-Disposition GCS sends with the object (e.g. an attachment filename)
+	ResponseContentType string        // Content-Type GCS sends instead of the stored one
 }
 
-// Creates a temporary signed URL for direct access to a GCS object.
-// The URL expires after 15 minutes, allowing clients to fetch files directly from GCS
-// without proxying through the application server.
-func (s *StorageService) GenerateSignedURL(ctx context.Context, storagePath string) (string, error) {
+// Sets how long signed URLs stay valid. Values outside (0, MaxSignedURLExpiry] are ignored.
+func (s *StorageService) SetSignedURLExpiry(expiry time.Duration) {
+	if expiry > 0 && expiry <= MaxSignedURLExpiry {
+		s.urlExpiry = expiry
+	}
+}
+
+// Signs URLs locally with a service account's PEM private key instead of through the client's
+// credentials, which a client on the storage emulator doesn't have. An empty id restores the default.
+func (s *StorageService) SetSigningKey(googleAccessID string, privateKey []byte) {
+	s.signerID, s.signerKey = googleAccessID, privateKey
+}
+
+// Creates a temporary signed URL for direct access to a GCS object, allowing clients to fetch
+// files directly from GCS without proxying through the application server.
+// Returns the URL and the time it expires (the configured expiry from now).
+func (s *StorageService) GenerateSignedURL(ctx context.Context, storagePath string) (string, time.Time, error) {
+	return s.GenerateSignedURLWith(ctx, storagePath, SignedURLOptions{})
+}
+
+// Creates a signed URL like GenerateSignedURL, with a custom lifetime and response headers.
+// Expiries over MaxSignedURLExpiry (or negative) fail with ErrInvalidInput.
+func (s *StorageService) GenerateSignedURLWith(ctx context.Context, storagePath string, options SignedURLOptions) (string, time.Time, error) {
 	if storagePath == "" {
-		return "", fmt.Errorf("storage path cannot be empty")
+		return "", time.Time{}, fmt.Errorf("%w: storage path cannot be empty", apperrors.ErrInvalidInput)
 	}
 
+	expiry := options.Expiry
+	if expiry == 0 {
+		expiry = s.urlExpiry
+	}
+	if expiry < 0 || expiry > MaxSignedURLExpiry {
+		return "", time.Time{}, fmt.Errorf("%w: signed URL expiry %v outside (0, %v]", apperrors.ErrInvalidInput, expiry, MaxSignedURLExpiry)
+	}
+
+	expires := time.Now().Add(expiry)
 	opts := &storage.SignedURLOptions{
-		Expires: time.Now().Add(15 * time.Minute),
+		Expires: expires,
 		Method:  "GET",
 		Scheme:  storage.SigningSchemeV4,
 	}
+	if s.signerID != "" {
+		opts.GoogleAccessID, opts.PrivateKey = s.signerID, s.signerKey
+	}
+	if options.Disposition != "" || options.ResponseContentType != "" {
+		opts.QueryParameters = url.Values{}
+		if options.Disposition != "" {
+			opts.QueryParameters.Set("response-content-disposition", options.Disposition)
+		}
+		if options.ResponseContentType != "" {
+			opts.QueryParameters.Set("response-content-type", options.ResponseContentType)
+		}
+	}
 
-	url, err := s.client.Bucket(s.bucketName).SignedURL(storagePath, opts)
+	signedURL, err := s.client.Bucket(s.bucketName).SignedURL(storagePath, opts)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate signed URL: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to generate signed URL: %w", err)
+	}
+
+	return signedURL, expires, nil
+}
+
+// Castagnoli table for the CRC32C checksums GCS uses.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Cache-Control values for stored objects, served with every signed URL for them.
+const (
+	// Content-addressed objects (derived/ renditions keyed by content hash) never change
+	ImmutableCacheControl = "public, max-age=31536000, immutable"
+	// Media keyed by file name can be replaced by a re-sync, so browsers and CDNs recheck hourly
+	MutableCacheControl = "public, max-age=3600"
+)
+
+// Returns the Cache-Control an object should be stored with, by where it lives in the bucket.
+func CacheControlFor(storagePath string) string {
+	if strings.HasPrefix(storagePath, "derived/") {
+		return ImmutableCacheControl
 	}
+	return MutableCacheControl
+}
 
-	return url, nil
+// Optional settings for UploadFile.
+type UploadOptions struct {
+	CacheControl string            // Cache-Control header served with the object; none if empty
+	Metadata     map[string]string // Custom metadata, added to (and overriding) uploaded-by
 }
 
 // Uploads a file to Google Cloud Storage.
-// Returns an error if the upload fails.
-func (s *StorageService) UploadFile(ctx context.Context, filePath string, data []byte, contentType string) (err error) {
+// The CRC32C of data is sent with the upload so GCS rejects a corrupted or truncated body, and is
+// compared with the checksum of the stored object afterwards; either mismatch fails with
+// ErrChecksumMismatch, which is worth retrying. Returns an error if the upload fails.
+func (s *StorageService) UploadFile(ctx context.Context, filePath string, data []byte, contentType string, options UploadOptions) error {
 	if filePath == "" {
-		return fmt.Errorf("file path cannot be empty")
+		return fmt.Errorf("%w: file path cannot be empty", apperrors.ErrInvalidInput)
 	}
 	if len(data) == 0 {
-		return fmt.Errorf("data cannot be empty")
+		return fmt.Errorf("%w: data cannot be empty", apperrors.ErrInvalidInput)
+	}
+
+	checksum := crc32.Checksum(data, crc32cTable)
+	return s.upload(ctx, filePath, checksum, func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}, contentType, options)
+}
+
+// Uploads a local file to Google Cloud Storage like UploadFile, streaming it from disk so files
+// too large to hold in memory can be stored. The file is read once for its checksum, then again
+// by each upload attempt.
+func (s *StorageService) UploadFromFile(ctx context.Context, filePath, localPath, contentType string, options UploadOptions) error {
+	if filePath == "" {
+		return fmt.Errorf("%w: file path cannot be empty", apperrors.ErrInvalidInput)
+	}
+
+	open := func() (io.ReadCloser, error) { return os.Open(localPath) }
+	f, err := open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	hash := crc32.New(crc32cTable)
+	n, err := io.Copy(hash, f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", localPath, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: data cannot be empty", apperrors.ErrInvalidInput)
+	}
+
+	return s.upload(ctx, filePath, hash.Sum32(), open, contentType, options)
+}
+
+// Writes the body open returns to filePath, retrying transient failures with a fresh body.
+func (s *StorageService) upload(ctx context.Context, filePath string, checksum uint32, open func() (io.ReadCloser, error), contentType string, options UploadOptions) error {
+	obj := s.client.Bucket(s.bucketName).Object(filePath)
+
+	// Each attempt starts a fresh writer (a new upload session). A write cut short is abandoned by
+	// cancelling its context rather than closed, since Close would finalize a truncated object.
+	var writer *storage.Writer
+	err := withStorageRetry(ctx, "upload "+filePath, func() error {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		writer = obj.NewWriter(attemptCtx)
+		writer.ContentType = contentType
+		writer.CacheControl = options.CacheControl
+		writer.Metadata = map[string]string{
+			"uploaded-by": "trekka-drive-sync",
+		}
+		for key, value := range options.Metadata {
+			writer.Metadata[key] = value
+		}
+		writer.CRC32C = checksum
+		writer.SendCRC32C = true
+
+		body, err := open()
+		if err != nil {
+			cancel()
+			return err
+		}
+		defer body.Close()
+		if _, err := io.Copy(writer, body); err != nil {
+			cancel()
+			return err
+		}
+		return writer.Close()
+	})
+	if err != nil {
+		if isChecksumRejection(err) {
+			return fmt.Errorf("%w: GCS rejected %s: %w", apperrors.ErrChecksumMismatch, filePath, err)
+		}
+		return fmt.Errorf("failed to upload %s: %w", filePath, classifyError(err))
+	}
+
+	if attrs := writer.Attrs(); attrs != nil && attrs.CRC32C != checksum {
+		return fmt.Errorf("%w: %s stored with CRC32C %08x, sent %08x", apperrors.ErrChecksumMismatch, filePath, attrs.CRC32C, checksum)
+	}
+
+	return nil
+}
+
+// Reports whether GCS refused an upload because its body didn't match the CRC32C sent with it.
+// The JSON API answers 400 and the gRPC API InvalidArgument; both name the checksum.
+func isChecksumRejection(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusBadRequest && strings.Contains(apiErr.Message, "CRC32C")
+	}
+	return status.Code(err) == codes.InvalidArgument && strings.Contains(err.Error(), "CRC32C")
+}
+
+// Copies an object to dst, replacing any object already there. A missing src fails with ErrNotFound.
+func (s *StorageService) CopyFile(ctx context.Context, src, dst string) error {
+	if src == "" || dst == "" {
+		return fmt.Errorf("%w: file path cannot be empty", apperrors.ErrInvalidInput)
+	}
+
+	bucket := s.client.Bucket(s.bucketName)
+	if _, err := bucket.Object(dst).CopierFrom(bucket.Object(src)).Run(ctx); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", src, dst, classifyError(err))
+	}
+
+	return nil
+}
+
+// Sets the Cache-Control of a stored object by updating its metadata, without rewriting its data.
+// Returns ErrNotFound if the object doesn't exist.
+func (s *StorageService) SetCacheControl(ctx context.Context, path string, cacheControl string) error {
+	if path == "" {
+		return fmt.Errorf("%w: file path cannot be empty", apperrors.ErrInvalidInput)
+	}
+
+	obj := s.client.Bucket(s.bucketName).Object(path)
+	if _, err := obj.Update(ctx, storage.ObjectAttrsToUpdate{CacheControl: cacheControl}); err != nil {
+		return fmt.Errorf("failed to update %s: %w", path, classifyError(err))
+	}
+
+	return nil
+}
+
+// Moves an object by copying it to dst and deleting the original. Both steps are conditional on
+// the source generation read up front, and the copy never overwrites an existing dst, so a
+// concurrent re-upload is left in place rather than lost. Returns false if src doesn't exist.
+//
+// A delete that still fails after retrying is logged and returned with the original kept. The
+// move can then be run again: a dst already holding src's content counts as copied.
+func (s *StorageService) MoveFile(ctx context.Context, src, dst string) (bool, error) {
+	if src == "" || dst == "" {
+		return false, fmt.Errorf("%w: file path cannot be empty", apperrors.ErrInvalidInput)
 	}
 
 	bucket := s.client.Bucket(s.bucketName)
-	obj := bucket.Object(filePath)
+	srcObj := bucket.Object(src)
+
+	var attrs *storage.ObjectAttrs
+	err := withStorageRetry(ctx, "read "+src, func() (err error) {
+		attrs, err = srcObj.Attrs(ctx)
+		return err
+	})
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", src, classifyError(err))
+	}
 
-	writer := obj.NewWriter(ctx)
-	defer func() {
-		if closeErr := writer.Close(); closeErr != nil && err == nil {
-			err = fmt.Errorf("failed to close writer: %w", closeErr)
+	conds := storage.Conditions{GenerationMatch: attrs.Generation}
+	copier := bucket.Object(dst).If(storage.Conditions{DoesNotExist: true}).CopierFrom(srcObj.If(conds))
+	if _, err := copier.Run(ctx); err != nil {
+		if !isPreconditionFailed(err) || !s.holdsCopy(ctx, dst, attrs) {
+			return false, fmt.Errorf("failed to copy %s to %s: %w", src, dst, classifyError(err))
 		}
-	}()
+		log.Printf("[Storage] %s already holds a copy of %s, deleting the original", dst, src)
+	}
 
-	writer.ContentType = contentType
-	writer.Metadata = map[string]string{
-		"uploaded-by": "trekka-drive-sync",
+	err = withStorageRetry(ctx, "delete "+src, func() error {
+		return srcObj.If(conds).Delete(ctx)
+	})
+	// Gone already: a retried delete whose first attempt went through
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		log.Printf("[Storage] Copied %s to %s but failed to delete the original: %v", src, dst, err)
+		return false, fmt.Errorf("copied %s to %s but failed to delete the original: %w", src, dst, classifyError(err))
 	}
 
-	if _, err := io.Copy(writer, bytes.NewReader(data)); err != nil {
-		return fmt.Errorf("failed to write file data: %w", err)
+	return true, nil
+}
+
+// Reports whether the object at path has the same size and CRC32C as src, as after an earlier
+// copy of it.
+func (s *StorageService) holdsCopy(ctx context.Context, path string, src *storage.ObjectAttrs) bool {
+	attrs, err := s.client.Bucket(s.bucketName).Object(path).Attrs(ctx)
+	return err == nil && attrs.Size == src.Size && attrs.CRC32C == src.CRC32C
+}
+
+// Reports whether err is GCS rejecting a request because a precondition didn't hold.
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
+
+// Reads the attributes of an object (size, MD5, CRC32C, metadata). A missing object fails with
+// ErrNotFound.
+func (s *StorageService) Attrs(ctx context.Context, filePath string) (*storage.ObjectAttrs, error) {
+	var attrs *storage.ObjectAttrs
+	err := withStorageRetry(ctx, "read "+filePath, func() (err error) {
+		attrs, err = s.client.Bucket(s.bucketName).Object(filePath).Attrs(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filePath, classifyError(err))
+	}
+	return attrs, nil
+}
+
+// Objects requested per page by ListObjects (the API maximum).
+const listObjectsPageSize = 1000
+
+// Lists the objects whose names start with prefix ("" for the whole bucket), fetching them a page
+// at a time. Only the name, content type, Cache-Control, size and update time are requested.
+func (s *StorageService) ListObjects(ctx context.Context, prefix string) ([]*storage.ObjectAttrs, error) {
+	query := &storage.Query{Prefix: prefix}
+	if err := query.SetAttrSelection([]string{"Name", "ContentType", "CacheControl", "Size", "Updated"}); err != nil {
+		return nil, fmt.Errorf("failed to build object query: %w", err)
+	}
+
+	it := s.client.Bucket(s.bucketName).Objects(ctx, query)
+	it.PageInfo().MaxSize = listObjectsPageSize
+
+	var objects []*storage.ObjectAttrs
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects under %q: %w", prefix, classifyError(err))
+		}
+		objects = append(objects, attrs)
 	}
 
+	return objects, nil
+}
+
+// Reads the bucket's attributes, the cheapest call that proves credentials and bucket access.
+func (s *StorageService) Ping(ctx context.Context) error {
+	if _, err := s.client.Bucket(s.bucketName).Attrs(ctx); err != nil {
+		return fmt.Errorf("failed to read bucket %s: %w", s.bucketName, classifyError(err))
+	}
 	return nil
 }
+
+// Deletes a file from Google Cloud Storage. A missing object fails with ErrNotFound.
+func (s *StorageService) DeleteFile(ctx context.Context, filePath string) error {
+	if filePath == "" {
+		return fmt.Errorf("%w: file path cannot be empty", apperrors.ErrInvalidInput)
+	}
+
+	if err := s.client.Bucket(s.bucketName).Object(filePath).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete file: %w", classifyError(err))
+	}
+
+	return nil
+}
+
+// Deletes a file like DeleteFile, but treats a missing object as already deleted (for cleanup
+// that may run twice). Returns whether an object was removed.
+func (s *StorageService) DeleteFileIfExists(ctx context.Context, filePath string) (bool, error) {
+	err := s.DeleteFile(ctx, filePath)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Reports whether an object exists, using its attributes rather than downloading it.
+func (s *StorageService) Exists(ctx context.Context, filePath string) (bool, error) {
+	if filePath == "" {
+		return false, fmt.Errorf("%w: file path cannot be empty", apperrors.ErrInvalidInput)
+	}
+
+	err := withStorageRetry(ctx, "read "+filePath, func() error {
+		_, err := s.client.Bucket(s.bucketName).Object(filePath).Attrs(ctx)
+		return err
+	})
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", filePath, classifyError(err))
+	}
+	return true, nil
+}
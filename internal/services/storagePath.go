@@ -0,0 +1,53 @@
+package services
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	apperrors "trekka-api/internal/errors"
+)
+
+// Bucket layouts for newly stored media (STORAGE_LAYOUT).
+const (
+	StorageLayoutFlat  = "flat"  // <fileName> in the bucket root
+	StorageLayoutDated = "dated" // YYYY/MM/<fileName>, by capture date
+)
+
+// Picks the object key for a newly stored file from its name and capture date (zero if unknown).
+// Documents record the full key in storagePath, so readers never depend on the layout.
+type StoragePathStrategy func(fileName string, takenAt time.Time) string
+
+// Stores every file in the bucket root under its name.
+func FlatStoragePaths(fileName string, _ time.Time) string {
+	return fileName
+}
+
+// Stores files under YYYY/MM/ of their capture date, or of today when it isn't known.
+// EXIF times carry no zone and are parsed as UTC, so the month is taken in UTC to keep it local.
+func DatedStoragePaths(fileName string, takenAt time.Time) string {
+	if takenAt.IsZero() {
+		takenAt = time.Now()
+	}
+	return takenAt.UTC().Format("2006/01/") + fileName
+}
+
+// Returns the strategy for a STORAGE_LAYOUT value.
+func StoragePathStrategyFor(layout string) (StoragePathStrategy, error) {
+	switch layout {
+	case StorageLayoutFlat:
+		return FlatStoragePaths, nil
+	case StorageLayoutDated:
+		return DatedStoragePaths, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown storage layout %q", apperrors.ErrInvalidInput, layout)
+	}
+}
+
+// Adds the Drive file ID to a key's file name (2024/05/IMG_0001-<id>.jpg), for a file whose key
+// already belongs to a different file with the same name.
+func disambiguateStoragePath(storagePath, driveFileID string) string {
+	ext := path.Ext(storagePath)
+	return strings.TrimSuffix(storagePath, ext) + "-" + driveFileID + ext
+}
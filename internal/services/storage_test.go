@@ -0,0 +1,252 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	apperrors "trekka-api/internal/errors"
+)
+
+func TestDeleteFile(t *testing.T) {
+	storage, gcs := newFakeStorage(t)
+	ctx := context.Background()
+	gcs.Put("images/photo.jpg", []byte("photo"), "image/jpeg")
+
+	if err := storage.DeleteFile(ctx, "images/photo.jpg"); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	if _, ok := gcs.Get("images/photo.jpg"); ok {
+		t.Error("object still stored after DeleteFile")
+	}
+
+	if err := storage.DeleteFile(ctx, "images/photo.jpg"); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("DeleteFile of a missing object: err = %v, want ErrNotFound", err)
+	}
+	if err := storage.DeleteFile(ctx, ""); !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("DeleteFile with no path: err = %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestDeleteFileIfExists(t *testing.T) {
+	storage, gcs := newFakeStorage(t)
+	ctx := context.Background()
+	gcs.Put("images/photo.jpg", []byte("photo"), "image/jpeg")
+
+	removed, err := storage.DeleteFileIfExists(ctx, "images/photo.jpg")
+	if err != nil || !removed {
+		t.Fatalf("DeleteFileIfExists = %t, %v; want the object removed", removed, err)
+	}
+	if _, ok := gcs.Get("images/photo.jpg"); ok {
+		t.Error("object still stored after DeleteFileIfExists")
+	}
+
+	// Running the cleanup again is not a failure
+	removed, err = storage.DeleteFileIfExists(ctx, "images/photo.jpg")
+	if err != nil || removed {
+		t.Errorf("DeleteFileIfExists of a missing object = %t, %v; want false, nil", removed, err)
+	}
+
+	if _, err := storage.DeleteFileIfExists(ctx, ""); !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("DeleteFileIfExists with no path: err = %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestExists(t *testing.T) {
+	storage, gcs := newFakeStorage(t)
+	ctx := context.Background()
+	gcs.Put("images/photo.jpg", []byte("photo"), "image/jpeg")
+
+	tests := []struct {
+		path    string
+		want    bool
+		wantErr error
+	}{
+		{"images/photo.jpg", true, nil},
+		{"images/missing.jpg", false, nil},
+		{"images", false, nil}, // A prefix is not an object
+		{"", false, apperrors.ErrInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := storage.Exists(ctx, tt.path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Exists = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	if reads := gcs.Reads(); len(reads) != 0 {
+		t.Errorf("Exists downloaded %d objects, want it to read attributes only", len(reads))
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	storage, gcs := newFakeStorage(t)
+	ctx := context.Background()
+	gcs.Put("images/photo.jpg", []byte("photo"), "image/jpeg")
+	gcs.Put("backup/photo.jpg", []byte("older photo"), "image/jpeg")
+
+	// The copy replaces whatever dst held
+	if err := storage.CopyFile(ctx, "images/photo.jpg", "backup/photo.jpg"); err != nil {
+		t.Fatalf("CopyFile: %v", err)
+	}
+	if data, _ := gcs.Get("backup/photo.jpg"); string(data) != "photo" {
+		t.Errorf("copy holds %q, want %q", data, "photo")
+	}
+	if _, ok := gcs.Get("images/photo.jpg"); !ok {
+		t.Error("original removed by CopyFile")
+	}
+
+	if err := storage.CopyFile(ctx, "images/missing.jpg", "backup/missing.jpg"); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("CopyFile of a missing object: err = %v, want ErrNotFound", err)
+	}
+	if err := storage.CopyFile(ctx, "images/photo.jpg", ""); !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("CopyFile with no destination: err = %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	storage, gcs := newFakeStorage(t)
+	ctx := context.Background()
+	gcs.Put("images/photo.jpg", []byte("photo"), "image/jpeg")
+
+	moved, err := storage.MoveFile(ctx, "images/photo.jpg", "quarantine/photo.jpg")
+	if err != nil || !moved {
+		t.Fatalf("MoveFile = %t, %v; want the object moved", moved, err)
+	}
+	if data, _ := gcs.Get("quarantine/photo.jpg"); string(data) != "photo" {
+		t.Errorf("destination holds %q, want %q", data, "photo")
+	}
+	if _, ok := gcs.Get("images/photo.jpg"); ok {
+		t.Error("original still stored after MoveFile")
+	}
+
+	moved, err = storage.MoveFile(ctx, "images/photo.jpg", "quarantine/photo.jpg")
+	if err != nil || moved {
+		t.Errorf("MoveFile of a missing object = %t, %v; want false, nil", moved, err)
+	}
+	if _, err := storage.MoveFile(ctx, "", "quarantine/photo.jpg"); !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("MoveFile with no source: err = %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestMoveFileKeepsDifferentDestination(t *testing.T) {
+	storage, gcs := newFakeStorage(t)
+	ctx := context.Background()
+	gcs.Put("quarantine/photo.jpg", []byte("photo"), "image/jpeg")
+	gcs.Put("images/photo.jpg", []byte("re-uploaded photo"), "image/jpeg")
+
+	if moved, err := storage.MoveFile(ctx, "quarantine/photo.jpg", "images/photo.jpg"); err == nil || moved {
+		t.Fatalf("MoveFile onto a different object = %t, %v; want an error", moved, err)
+	}
+	if data, _ := gcs.Get("images/photo.jpg"); string(data) != "re-uploaded photo" {
+		t.Errorf("destination overwritten with %q", data)
+	}
+	if _, ok := gcs.Get("quarantine/photo.jpg"); !ok {
+		t.Error("source removed although it wasn't copied")
+	}
+}
+
+func TestMoveFileRetriesDelete(t *testing.T) {
+	storage, gcs := newFakeStorage(t)
+	ctx := context.Background()
+	gcs.Put("images/photo.jpg", []byte("photo"), "image/jpeg")
+	gcs.FailNext(http.MethodDelete, http.StatusServiceUnavailable)
+
+	moved, err := storage.MoveFile(ctx, "images/photo.jpg", "quarantine/photo.jpg")
+	if err != nil || !moved {
+		t.Fatalf("MoveFile = %t, %v; want the delete retried", moved, err)
+	}
+	if _, ok := gcs.Get("images/photo.jpg"); ok {
+		t.Error("original still stored after a retried delete")
+	}
+}
+
+func TestMoveFileDeleteFailureIsLoggedAndResumable(t *testing.T) {
+	storage, gcs := newFakeStorage(t)
+	ctx := context.Background()
+	gcs.Put("images/photo.jpg", []byte("photo"), "image/jpeg")
+	// Not retryable, so the move stops between the copy and the delete
+	gcs.FailNext(http.MethodDelete, http.StatusForbidden)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	moved, err := storage.MoveFile(ctx, "images/photo.jpg", "quarantine/photo.jpg")
+	if err == nil || moved {
+		t.Fatalf("MoveFile with a failing delete = %t, %v; want an error", moved, err)
+	}
+	if !strings.Contains(logs.String(), "failed to delete the original") {
+		t.Errorf("delete failure not logged; log:\n%s", logs.String())
+	}
+	if _, ok := gcs.Get("images/photo.jpg"); !ok {
+		t.Fatal("original gone although its delete failed")
+	}
+	if _, ok := gcs.Get("quarantine/photo.jpg"); !ok {
+		t.Fatal("copy missing after the copy succeeded")
+	}
+
+	// Running the move again finishes it instead of failing on the existing copy
+	moved, err = storage.MoveFile(ctx, "images/photo.jpg", "quarantine/photo.jpg")
+	if err != nil || !moved {
+		t.Fatalf("MoveFile rerun = %t, %v; want the move finished", moved, err)
+	}
+	if _, ok := gcs.Get("images/photo.jpg"); ok {
+		t.Error("original still stored after the rerun")
+	}
+	if data, _ := gcs.Get("quarantine/photo.jpg"); string(data) != "photo" {
+		t.Errorf("destination holds %q, want %q", data, "photo")
+	}
+}
+
+func TestUploadFileRetriesTransientErrors(t *testing.T) {
+	storage, gcs := newFakeStorage(t)
+	ctx := context.Background()
+	gcs.FailNext(http.MethodPost, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+
+	if err := storage.UploadFile(ctx, "images/photo.jpg", []byte("photo"), "image/jpeg", UploadOptions{}); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if got := gcs.Uploads(); got != 3 {
+		t.Errorf("uploads started = %d, want 3", got)
+	}
+	if data, _ := gcs.Get("images/photo.jpg"); string(data) != "photo" {
+		t.Errorf("stored %q, want %q", data, "photo")
+	}
+}
+
+func TestUploadFileChecksumMismatchAfterRetries(t *testing.T) {
+	storage, gcs := newFakeStorage(t)
+	ctx := context.Background()
+	gcs.FailNext(http.MethodPost, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	gcs.CorruptNextUpload()
+
+	err := storage.UploadFile(ctx, "images/photo.jpg", []byte("photo"), "image/jpeg", UploadOptions{})
+	if !errors.Is(err, apperrors.ErrChecksumMismatch) {
+		t.Fatalf("err = %v, want ErrChecksumMismatch", err)
+	}
+	if got := gcs.Uploads(); got != 3 {
+		t.Errorf("uploads started = %d, want 3", got)
+	}
+	if _, ok := gcs.Get("images/photo.jpg"); ok {
+		t.Error("corrupted upload stored")
+	}
+
+	// The mismatch is not retried by the upload itself; a fresh upload goes through
+	if err := storage.UploadFile(ctx, "images/photo.jpg", []byte("photo"), "image/jpeg", UploadOptions{}); err != nil {
+		t.Fatalf("UploadFile after the mismatch: %v", err)
+	}
+	if got := gcs.Uploads(); got != 4 {
+		t.Errorf("uploads started = %d, want 4", got)
+	}
+}
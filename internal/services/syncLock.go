@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apperrors "trekka-api/internal/errors"
+)
+
+const syncLockCollection = "syncLocks"
+
+// Returned by SyncLock.Acquire while another instance holds the lease. It matches ErrConflict.
+var ErrSyncLocked = fmt.Errorf("%w: another instance is syncing Drive", apperrors.ErrConflict)
+
+// Lets one instance at a time sync, through a lease on a Firestore document: the holder's owner
+// ID and when the lease expires. The holder renews it every third of its TTL while work runs,
+// and deletes it when the last holder releases it; a lease left behind by an instance that died
+// expires on its own. Within one instance the lease is shared, so a backfill and a watch tick
+// can overlap without giving it up under each other.
+type SyncLock struct {
+	client *firestore.Client
+	ref    *firestore.DocumentRef
+	owner  string
+	ttl    time.Duration
+	logger *log.Logger
+
+	mu        sync.Mutex
+	holders   int                // Work currently running under the lease
+	lease     context.Context    // Done when the lease is released or lost; nil before the first Acquire
+	endLease  context.CancelFunc // Stops renewing and cancels the work holding the lease
+	renewDone chan struct{}      // Closed once the renewal loop has stopped
+}
+
+// Creates a lock on the syncLocks/<name> document, with leases lasting ttl unless renewed.
+// The owner ID is the host name plus a random suffix, so instances on one host differ.
+func NewSyncLock(fs *FirestoreService, name string, ttl time.Duration) *SyncLock {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "instance"
+	}
+	return &SyncLock{
+		client: fs.client,
+		ref:    fs.client.Collection(syncLockCollection).Doc(name),
+		owner:  host + "-" + uuid.NewString()[:8],
+		ttl:    ttl,
+		logger: log.New(os.Stdout, "[SyncLock] ", log.LstdFlags),
+	}
+}
+
+// Returns the ID this instance holds leases under.
+func (l *SyncLock) Owner() string {
+	return l.owner
+}
+
+// Takes the lease, or joins it if this instance already holds it. Returns a context derived from
+// ctx that is cancelled if the lease is lost (another instance took it over after a renewal
+// failed), and a func to call once the work is done, which gives the lease up when no other work
+// holds it. Fails with ErrSyncLocked while another instance holds an unexpired lease.
+func (l *SyncLock) Acquire(ctx context.Context) (context.Context, func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holders > 0 && l.lease.Err() != nil {
+		// Lost while earlier work still unwinds; it can't be rejoined until that work is done
+		return nil, nil, fmt.Errorf("%w (lease lost, waiting for running work to stop)", ErrSyncLocked)
+	}
+	if l.holders == 0 {
+		if err := l.claim(ctx); err != nil {
+			return nil, nil, err
+		}
+		l.lease, l.endLease = context.WithCancel(context.Background())
+		l.renewDone = make(chan struct{})
+		go l.renew(l.lease, l.endLease, l.renewDone)
+	}
+	l.holders++
+
+	workCtx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(l.lease, cancel)
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			stop()
+			cancel()
+			l.release()
+		})
+	}
+	return workCtx, release, nil
+}
+
+// Drops one holder, giving the lease up after the last.
+func (l *SyncLock) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.holders--
+	if l.holders > 0 {
+		return
+	}
+	l.endLease()
+	<-l.renewDone
+
+	// Not the work's context, which may be cancelled already (e.g. on shutdown)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := l.delete(ctx); err != nil {
+		l.logger.Printf("Failed to release %s, it expires on its own: %v", l.ref.ID, err)
+	}
+}
+
+// Writes this instance's lease if the document is free, expired or already this instance's.
+func (l *SyncLock) claim(ctx context.Context) error {
+	err := l.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(l.ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			owner, expiresAt := leaseOf(doc)
+			if owner != l.owner && time.Now().Before(expiresAt) {
+				return fmt.Errorf("%w (held by %s until %s)", ErrSyncLocked, owner, expiresAt.Format(time.RFC3339))
+			}
+		}
+		return tx.Set(l.ref, l.leaseFields())
+	})
+	if errors.Is(err, ErrSyncLocked) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to acquire %s: %w", l.ref.ID, classifyError(err))
+	}
+	return nil
+}
+
+// Errors extend returns when the document no longer names this instance.
+var errLeaseLost = errors.New("lease lost")
+
+// Pushes this instance's lease expiry out by a TTL, failing with errLeaseLost if another
+// instance holds it now (or nobody does).
+func (l *SyncLock) extend(ctx context.Context) error {
+	err := l.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(l.ref)
+		if status.Code(err) == codes.NotFound {
+			return errLeaseLost
+		}
+		if err != nil {
+			return err
+		}
+		if owner, _ := leaseOf(doc); owner != l.owner {
+			return fmt.Errorf("%w to %s", errLeaseLost, owner)
+		}
+		return tx.Set(l.ref, l.leaseFields())
+	})
+	if err != nil && !errors.Is(err, errLeaseLost) {
+		return classifyError(err)
+	}
+	return err
+}
+
+// Deletes the lease document if it is still this instance's.
+func (l *SyncLock) delete(ctx context.Context) error {
+	return l.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(l.ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if owner, _ := leaseOf(doc); owner != l.owner {
+			return nil
+		}
+		return tx.Delete(l.ref)
+	})
+}
+
+// Renews the lease every third of its TTL until lease is done. The lease counts as lost, and
+// the work holding it is cancelled, when another instance holds the document or no renewal has
+// succeeded for a whole TTL.
+func (l *SyncLock) renew(lease context.Context, endLease context.CancelFunc, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	renewed := time.Now()
+	for {
+		select {
+		case <-lease.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(lease, l.ttl/3)
+		err := l.extend(ctx)
+		cancel()
+		switch {
+		case err == nil:
+			renewed = time.Now()
+			continue
+		case lease.Err() != nil:
+			return
+		case errors.Is(err, errLeaseLost):
+			l.logger.Printf("Lost %s (%v), stopping the work holding it", l.ref.ID, err)
+		case time.Since(renewed) >= l.ttl:
+			l.logger.Printf("Could not renew %s for %v, assuming it lost: %v", l.ref.ID, l.ttl, err)
+		default:
+			l.logger.Printf("Failed to renew %s, retrying: %v", l.ref.ID, err)
+			continue
+		}
+		endLease()
+		return
+	}
+}
+
+// Fields of this instance's lease, expiring a TTL from now. expiresAt can back a Firestore TTL
+// policy, though an expired lease is free to take either way.
+func (l *SyncLock) leaseFields() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"owner":     l.owner,
+		"renewedAt": now,
+		"expiresAt": now.Add(l.ttl),
+	}
+}
+
+// Reads the owner and expiry of a lease document.
+func leaseOf(doc *firestore.DocumentSnapshot) (string, time.Time) {
+	var lease struct {
+		Owner     string    `firestore:"owner"`
+		ExpiresAt time.Time `firestore:"expiresAt"`
+	}
+	if err := doc.DataTo(&lease); err != nil {
+		return "", time.Time{}
+	}
+	return lease.Owner, lease.ExpiresAt
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"trekka-api/internal/models"
+)
+
+// Upper bounds (seconds) of the stage duration histogram buckets; the last is +Inf.
+var syncHistogramBounds = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, math.Inf(1)}
+
+// Aggregates per-file ingest stage timings into histograms and slowest-file lists.
+// Recording is a handful of additions under a mutex, so it is cheap enough for every file.
+type SyncMetrics struct {
+	mu      sync.Mutex
+	files   int
+	counts  map[string][]int // Per-stage, per-bucket (non-cumulative)
+	sums    map[string]time.Duration
+	slowest map[string][]models.FileTiming
+	keep    int
+}
+
+// Creates an empty aggregator keeping the keepSlowest slowest files per stage.
+func NewSyncMetrics(keepSlowest int) *SyncMetrics {
+	m := &SyncMetrics{
+		counts:  make(map[string][]int),
+		sums:    make(map[string]time.Duration),
+		slowest: make(map[string][]models.FileTiming),
+		keep:    keepSlowest,
+	}
+	for _, stage := range models.SyncStages {
+		m.counts[stage] = make([]int, len(syncHistogramBounds))
+	}
+	return m
+}
+
+// Records the timings of one processed file. Stages that did not run (zero) are not observed.
+func (m *SyncMetrics) Record(fileName string, timings models.SyncTimings) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.files++
+	for _, stage := range models.SyncStages {
+		d := timings.Stage(stage)
+		if d <= 0 {
+			continue
+		}
+
+		seconds := d.Seconds()
+		for i, bound := range syncHistogramBounds {
+			if seconds <= bound {
+				m.counts[stage][i]++
+				break
+			}
+		}
+		m.sums[stage] += d
+
+		m.slowest[stage] = insertSlowest(m.slowest[stage], models.FileTiming{FileName: fileName, Duration: d}, m.keep)
+	}
+}
+
+// Returns a copy of the aggregated histograms (cumulative buckets) and slowest files.
+func (m *SyncMetrics) Snapshot() *models.SyncMetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := &models.SyncMetricsSnapshot{
+		Files:      m.files,
+		Histograms: make(map[string]models.StageHistogram, len(models.SyncStages)),
+		Slowest:    make(map[string][]models.FileTiming, len(models.SyncStages)),
+	}
+	for _, stage := range models.SyncStages {
+		hist := models.StageHistogram{SumSeconds: m.sums[stage].Seconds()}
+		cumulative := 0
+		for i, bound := range syncHistogramBounds {
+			cumulative += m.counts[stage][i]
+			if !math.IsInf(bound, 1) {
+				hist.Buckets = append(hist.Buckets, models.HistogramBucket{LeSeconds: bound, Count: cumulative})
+			}
+		}
+		hist.Count = cumulative
+		snapshot.Histograms[stage] = hist
+		snapshot.Slowest[stage] = append([]models.FileTiming{}, m.slowest[stage]...)
+	}
+
+	return snapshot
+}
+
+// Formats the slowest files per stage as a multi-line summary for logs.
+func (m *SyncMetrics) Summary() string {
+	snapshot := m.Snapshot()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Stage timings over %d files:", snapshot.Files)
+	for _, stage := range models.SyncStages {
+		hist := snapshot.Histograms[stage]
+		if hist.Count == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "\n  %s: %d files, avg %v", stage, hist.Count,
+			(time.Duration(hist.SumSeconds*float64(time.Second)) / time.Duration(hist.Count)).Round(time.Millisecond))
+		for i, ft := range snapshot.Slowest[stage] {
+			fmt.Fprintf(&sb, "\n    %2d. %-40s %v", i+1, ft.FileName, ft.Duration.Round(time.Millisecond))
+		}
+	}
+	return sb.String()
+}
+
+// Inserts t into a slowest-first list capped at keep entries.
+func insertSlowest(list []models.FileTiming, t models.FileTiming, keep int) []models.FileTiming {
+	if keep <= 0 {
+		return list
+	}
+	if len(list) == keep && t.Duration <= list[len(list)-1].Duration {
+		return list
+	}
+
+	i := sort.Search(len(list), func(i int) bool { return list[i].Duration < t.Duration })
+	list = append(list, models.FileTiming{})
+	copy(list[i+1:], list[i:])
+	list[i] = t
+	if len(list) > keep {
+		list = list[:keep]
+	}
+	return list
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"trekka-api/internal/models"
+)
+
+func TestExtractRecordsStageTimings(t *testing.T) {
+	const geocodeDelay = 50 * time.Millisecond
+	geocoder := &fakeGeocoder{parts: models.LocationParts{City: "Lisbon", Country: "Portugal"}, delay: geocodeDelay}
+	data := gpsJPEG(t, [3]uint32{38, 42, 36}, "N", [3]uint32{9, 8, 24}, "W")
+
+	var timings models.SyncTimings
+	metadata, err := ExtractAndMergeMetadata(context.Background(), nil, "IMG_1.jpg", "image/jpeg", data, &models.ImageMetadata{}, geocoder, &timings)
+	if err != nil {
+		t.Fatalf("ExtractAndMergeMetadata: %v", err)
+	}
+	if metadata.GeoLocation != "Lisbon, Portugal" {
+		t.Fatalf("geoLocation = %q, want the fake geocoder's Lisbon, Portugal", metadata.GeoLocation)
+	}
+
+	if timings.Geocode < geocodeDelay || timings.Geocode > geocodeDelay+time.Second {
+		t.Errorf("geocode stage = %v, want about the geocoder's %v", timings.Geocode, geocodeDelay)
+	}
+	// Parsing an 8×8 JPEG is quick, and the geocoding delay mustn't be counted twice
+	if timings.Extract <= 0 || timings.Extract >= geocodeDelay {
+		t.Errorf("extract stage = %v, want above zero and below the geocoding delay", timings.Extract)
+	}
+	if timings.Persist != 0 || timings.Download != 0 {
+		t.Errorf("stages that didn't run were timed: %+v", timings)
+	}
+}
+
+func TestPersistRecordsStageTiming(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	geocoder := &fakeGeocoder{parts: models.LocationParts{City: "Lisbon", Country: "Portugal"}}
+	data := gpsJPEG(t, [3]uint32{38, 42, 36}, "N", [3]uint32{9, 8, 24}, "W")
+
+	var timings models.SyncTimings
+	if _, err := ExtractAndPersistMetadata(context.Background(), fs, "IMG_1.jpg", "image/jpeg", "drive-file-1", data, nil, geocoder, &timings); err != nil {
+		t.Fatalf("ExtractAndPersistMetadata: %v", err)
+	}
+	if timings.Persist <= 0 || timings.Extract <= 0 {
+		t.Errorf("timings = %+v, want extract and persist recorded", timings)
+	}
+	if got := timings.Total(); got != timings.Extract+timings.Geocode+timings.Persist {
+		t.Errorf("Total = %v, want the sum of the stages that ran", got)
+	}
+}
+
+func TestSyncMetricsAggregatesStages(t *testing.T) {
+	metrics := NewSyncMetrics(2)
+	metrics.Record("a.jpg", models.SyncTimings{Download: 30 * time.Millisecond, Upload: 2 * time.Second})
+	metrics.Record("b.jpg", models.SyncTimings{Download: 300 * time.Millisecond})
+	metrics.Record("c.jpg", models.SyncTimings{Download: 3 * time.Second})
+	metrics.Record("d.jpg", models.SyncTimings{Download: 2 * time.Minute}) // Past the last bound
+
+	snapshot := metrics.Snapshot()
+	if snapshot.Files != 4 {
+		t.Errorf("files = %d, want 4", snapshot.Files)
+	}
+
+	download := snapshot.Histograms[models.StageDownload]
+	if download.Count != 4 {
+		t.Errorf("download count = %d, want 4", download.Count)
+	}
+	if want := (30*time.Millisecond + 300*time.Millisecond + 3*time.Second + 2*time.Minute).Seconds(); download.SumSeconds != want {
+		t.Errorf("download sum = %v, want %v", download.SumSeconds, want)
+	}
+	wantBuckets := map[float64]int{0.05: 1, 0.25: 1, 0.5: 2, 1: 2, 5: 3, 60: 3}
+	for _, bucket := range download.Buckets {
+		if want, ok := wantBuckets[bucket.LeSeconds]; ok && bucket.Count != want {
+			t.Errorf("download bucket le=%v has %d, want %d (cumulative)", bucket.LeSeconds, bucket.Count, want)
+		}
+	}
+
+	// Stages that didn't run for a file aren't observed
+	if got := snapshot.Histograms[models.StageUpload].Count; got != 1 {
+		t.Errorf("upload count = %d, want 1", got)
+	}
+	if got := snapshot.Histograms[models.StageConvert].Count; got != 0 {
+		t.Errorf("convert count = %d, want 0", got)
+	}
+
+	slowest := snapshot.Slowest[models.StageDownload]
+	if len(slowest) != 2 || slowest[0].FileName != "d.jpg" || slowest[1].FileName != "c.jpg" {
+		t.Errorf("slowest downloads = %v, want d.jpg then c.jpg", slowest)
+	}
+
+	summary := metrics.Summary()
+	if !strings.Contains(summary, "over 4 files") || !strings.Contains(summary, "d.jpg") || strings.Contains(summary, "convert") {
+		t.Errorf("summary = %q", summary)
+	}
+
+	// Callers without metrics pass nil
+	var none *SyncMetrics
+	none.Record("e.jpg", models.SyncTimings{Download: time.Second})
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"trekka-api/internal/models"
+)
+
+const syncRunCollection = "syncRuns"
+
+// Largest page of runs ListRuns returns.
+const MaxSyncRunsLimit = 100
+
+// Deletes pruned per batch; Firestore caps a batch at 500 writes.
+const syncRunPruneBatch = 200
+
+// Keeps the history of Drive sync runs in Firestore, one document per backfill or watch tick.
+type SyncRunStore struct {
+	client    *firestore.Client
+	retention time.Duration
+}
+
+// Creates a store that prunes runs that finished more than retention ago (0 keeps them all).
+func NewSyncRunStore(fs *FirestoreService, retention time.Duration) *SyncRunStore {
+	return &SyncRunStore{client: fs.client, retention: retention}
+}
+
+// Saves a finished run under a new ID, then prunes runs past the retention. A failed prune is
+// returned but the run is saved.
+func (s *SyncRunStore) Record(ctx context.Context, run *models.SyncRun) error {
+	ref := s.client.Collection(syncRunCollection).NewDoc()
+	err := withRetry(ctx, "record sync run", func() error {
+		_, err := ref.Set(ctx, run)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record sync run: %w", classifyError(err))
+	}
+	run.Id = ref.ID
+
+	if s.retention <= 0 {
+		return nil
+	}
+	if _, err := s.Prune(ctx, time.Now().Add(-s.retention)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Lists up to limit runs (at most MaxSyncRunsLimit), newest first.
+func (s *SyncRunStore) List(ctx context.Context, limit int) ([]*models.SyncRun, error) {
+	if limit <= 0 || limit > MaxSyncRunsLimit {
+		limit = MaxSyncRunsLimit
+	}
+
+	query := s.client.Collection(syncRunCollection).OrderBy("startedAt", firestore.Desc).Limit(limit)
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sync runs: %w", classifyError(err))
+	}
+
+	runs := make([]*models.SyncRun, 0, len(docs))
+	for _, doc := range docs {
+		var run models.SyncRun
+		if err := doc.DataTo(&run); err != nil {
+			continue
+		}
+		run.Id = doc.Ref.ID
+		runs = append(runs, &run)
+	}
+	return runs, nil
+}
+
+// Deletes the runs that finished before cutoff, returning how many.
+func (s *SyncRunStore) Prune(ctx context.Context, cutoff time.Time) (int, error) {
+	pruned := 0
+	for {
+		query := s.client.Collection(syncRunCollection).Where("finishedAt", "<", cutoff).Limit(syncRunPruneBatch)
+		docs, err := query.Documents(ctx).GetAll()
+		if err != nil {
+			return pruned, fmt.Errorf("failed to list old sync runs: %w", classifyError(err))
+		}
+		if len(docs) == 0 {
+			return pruned, nil
+		}
+
+		batch := s.client.Batch()
+		for _, doc := range docs {
+			batch.Delete(doc.Ref)
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return pruned, fmt.Errorf("failed to prune sync runs: %w", classifyError(err))
+		}
+		pruned += len(docs)
+		if len(docs) < syncRunPruneBatch {
+			return pruned, nil
+		}
+	}
+}
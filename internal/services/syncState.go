@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"trekka-api/internal/models"
+)
+
+const syncStateCollection = "syncState"
+
+// Persists Drive sync progress in Firestore, so it survives restarts and serverless recycling.
+type SyncStateStore struct {
+	client *firestore.Client
+}
+
+func NewSyncStateStore(fs *FirestoreService) *SyncStateStore {
+	return &SyncStateStore{client: fs.client}
+}
+
+// Reads the saved state of a folder, or an empty state (no page token) if it was never synced
+// incrementally.
+func (s *SyncStateStore) Get(ctx context.Context, folderID string) (*models.DriveSyncState, error) {
+	doc, err := s.client.Collection(syncStateCollection).Doc(folderID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return &models.DriveSyncState{FolderID: folderID}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync state of %s: %w", folderID, classifyError(err))
+	}
+
+	var state models.DriveSyncState
+	if err := doc.DataTo(&state); err != nil {
+		return nil, fmt.Errorf("failed to parse sync state of %s: %w", folderID, err)
+	}
+	return &state, nil
+}
+
+// Saves the Changes API page token to resume a folder's sync from.
+func (s *SyncStateStore) SetPageToken(ctx context.Context, folderID, pageToken string) error {
+	_, err := s.client.Collection(syncStateCollection).Doc(folderID).Set(ctx, map[string]interface{}{
+		"folderId":  folderID,
+		"pageToken": pageToken,
+		"updatedAt": time.Now(),
+	}, firestore.MergeAll)
+	if err != nil {
+		return fmt.Errorf("failed to save sync state of %s: %w", folderID, classifyError(err))
+	}
+	return nil
+}
+
+// Replaces the files a folder's deletion pass found missing (see DriveSyncState.Missing).
+func (s *SyncStateStore) SetMissing(ctx context.Context, folderID string, missing map[string]time.Time) error {
+	_, err := s.client.Collection(syncStateCollection).Doc(folderID).Set(ctx, map[string]interface{}{
+		"folderId":  folderID,
+		"missing":   missing,
+		"updatedAt": time.Now(),
+	}, firestore.Merge([]string{"folderId"}, []string{"missing"}, []string{"updatedAt"}))
+	if err != nil {
+		return fmt.Errorf("failed to save sync state of %s: %w", folderID, classifyError(err))
+	}
+	return nil
+}
+
+// Saves when the polling watch last synced every file changed in a folder.
+func (s *SyncStateStore) SetLastCheck(ctx context.Context, folderID string, lastCheck time.Time) error {
+	_, err := s.client.Collection(syncStateCollection).Doc(folderID).Set(ctx, map[string]interface{}{
+		"folderId":  folderID,
+		"lastCheck": lastCheck,
+		"updatedAt": time.Now(),
+	}, firestore.Merge([]string{"folderId"}, []string{"lastCheck"}, []string{"updatedAt"}))
+	if err != nil {
+		return fmt.Errorf("failed to save sync state of %s: %w", folderID, classifyError(err))
+	}
+	return nil
+}
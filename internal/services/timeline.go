@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+// Cache key for the date-sorted image summaries timelines are grouped from.
+const timelineIndexCacheKey = "stats:timeline"
+
+// Groups images into day or month buckets, newest first, with images lacking a capture time in a
+// final TimelineUnknown bucket. Each bucket lists its per newest images. Pages hold up to limit
+// buckets and continue after the bucket key given as cursor (empty for the first page).
+// Buckets use the UTC date of takenAt. The summaries are read with one scan of the collection and
+// cached for the cache TTL, like GetStats.
+func (s *ImageService) Timeline(ctx context.Context, granularity string, per, limit int, cursor string) (*models.Timeline, error) {
+	var keyLayout string
+	switch granularity {
+	case models.TimelineDay:
+		keyLayout = "2006-01-02"
+	case models.TimelineMonth:
+		keyLayout = "2006-01"
+	default:
+		return nil, fmt.Errorf("%w: granularity must be %q or %q", apperrors.ErrInvalidInput, models.TimelineDay, models.TimelineMonth)
+	}
+	if per < 0 || limit <= 0 {
+		return nil, fmt.Errorf("%w: per cannot be negative and limit must be positive", apperrors.ErrInvalidInput)
+	}
+
+	images, err := s.timelineIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	timeline := &models.Timeline{Granularity: granularity, Buckets: []*models.TimelineBucket{}}
+	var current *models.TimelineBucket
+	for _, img := range images {
+		key := models.TimelineUnknown
+		if !img.TakenAt.IsZero() {
+			key = img.TakenAt.UTC().Format(keyLayout)
+		}
+		if !timelineAfter(key, cursor) {
+			continue
+		}
+
+		if current == nil || current.Key != key {
+			if len(timeline.Buckets) == limit {
+				timeline.NextCursor = current.Key
+				break
+			}
+			current = &models.TimelineBucket{Key: key, Images: []models.TimelineImage{}}
+			timeline.Buckets = append(timeline.Buckets, current)
+		}
+		current.Count++
+		if len(current.Images) < per {
+			current.Images = append(current.Images, img)
+		}
+	}
+
+	return timeline, nil
+}
+
+// Reports whether a bucket comes after cursor in timeline order (dated keys newest first, then
+// TimelineUnknown). Dated keys sort lexicographically, so any key compares correctly against
+// a cursor of the same granularity.
+func timelineAfter(key, cursor string) bool {
+	switch {
+	case cursor == "":
+		return true
+	case cursor == models.TimelineUnknown:
+		return false
+	case key == models.TimelineUnknown:
+		return true
+	default:
+		return key < cursor
+	}
+}
+
+// Returns every non-quarantined image as a timeline summary, newest first with undated images at
+// the end, from the cache when possible.
+func (s *ImageService) timelineIndex(ctx context.Context) ([]models.TimelineImage, error) {
+	if entry, ok := s.cache.Get(timelineIndexCacheKey); ok && len(entry.Data) > 0 {
+		var images []models.TimelineImage
+		if err := json.Unmarshal(entry.Data, &images); err == nil {
+			log.Printf("[Image] Timeline index cache hit")
+			return images, nil
+		}
+	}
+
+	var images []models.TimelineImage
+	err := s.ForEachImage(ctx, func(img *models.ImageMetadata) error {
+		if img.Hidden() {
+			return nil
+		}
+		images = append(images, models.TimelineImage{
+			Id:            img.Id,
+			FileName:      img.FileName,
+			ContentType:   img.ContentType,
+			TakenAt:       img.TakenAt,
+			GeoLocation:   img.GeoLocation,
+			DominantColor: img.DominantColor,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build timeline: %w", err)
+	}
+
+	sort.SliceStable(images, func(i, j int) bool {
+		a, b := images[i].TakenAt, images[j].TakenAt
+		if a.IsZero() != b.IsZero() {
+			return b.IsZero()
+		}
+		if !a.Equal(b) {
+			return a.After(b)
+		}
+		return strings.Compare(images[i].FileName, images[j].FileName) < 0
+	})
+
+	if data, err := json.Marshal(images); err == nil {
+		s.cache.SetBytes(timelineIndexCacheKey, data, "application/json", "")
+	}
+
+	return images, nil
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"image/color"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+)
+
+func TestConcurrentMergesKeepEveryField(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+	seedImage(t, fs, "img-1", &models.ImageMetadata{FileName: "img-1.jpg"})
+
+	// Each writer reads the document and sets one field; a blind write would drop the others'
+	setters := map[string]func(*models.ImageMetadata){
+		"city":    func(m *models.ImageMetadata) { m.City = "Lisbon" },
+		"region":  func(m *models.ImageMetadata) { m.Region = "Lisboa" },
+		"country": func(m *models.ImageMetadata) { m.Country = "Portugal" },
+		"color":   func(m *models.ImageMetadata) { m.DominantColor = "#336699" },
+	}
+	var wg sync.WaitGroup
+	for field, set := range setters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := fs.MergeImageMetadata(ctx, "img-1", func(existing *models.ImageMetadata) (*models.ImageMetadata, error) {
+				merged := *existing
+				set(&merged)
+				return &merged, nil
+			})
+			if err != nil {
+				t.Errorf("merge %s: %v", field, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := fs.GetImageMetadata(ctx, "img-1")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	want := models.ImageMetadata{FileName: "img-1.jpg"}
+	for _, set := range setters {
+		set(&want)
+	}
+	if got.City != want.City || got.Region != want.Region || got.Country != want.Country || got.DominantColor != want.DominantColor {
+		t.Errorf("after concurrent merges got %+v, want every writer's field", got)
+	}
+}
+
+func TestPersistKeepsFieldsWrittenMeanwhile(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+	data := solidPNG(t, color.RGBA{R: 40, G: 120, B: 200, A: 255}, 8, 8)
+
+	first, err := ExtractAndPersistMetadata(ctx, fs, "IMG_1.png", "image/png", "drive-file-1", data, nil, &fakeGeocoder{}, nil)
+	if err != nil {
+		t.Fatalf("first ingest: %v", err)
+	}
+
+	// Re-syncs racing a caption edit and a field ImageMetadata doesn't know about
+	description := "Tram 28"
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ExtractAndPersistMetadata(ctx, fs, "IMG_1.png", "image/png", "drive-file-1", data, first, &fakeGeocoder{}, nil); err != nil {
+				t.Errorf("re-ingest: %v", err)
+			}
+		}()
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := fs.ApplyMetadataPatch(ctx, first.Id, models.ImageMetadataPatch{Description: &description}); err != nil {
+			t.Errorf("patch: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		updates := []firestore.Update{{Path: "album", Value: "Lisbon 2024"}}
+		if _, err := fs.client.Collection(fs.collection).Doc(first.Id).Update(ctx, updates); err != nil {
+			t.Errorf("external write: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	stored, err := fs.GetImageMetadata(ctx, first.Id)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if stored.Description != description || !stored.HasDescription {
+		t.Errorf("description = %q (has %v), want the concurrent edit kept", stored.Description, stored.HasDescription)
+	}
+	if stored.DominantColor == "" || len(stored.Resolution) == 0 {
+		t.Errorf("extracted fields missing after re-sync: color %q, resolution %v", stored.DominantColor, stored.Resolution)
+	}
+	doc, err := fs.client.Collection(fs.collection).Doc(first.Id).Get(ctx)
+	if err != nil {
+		t.Fatalf("read raw document: %v", err)
+	}
+	if album, _ := doc.DataAt("album"); album != "Lisbon 2024" {
+		t.Errorf("album = %v, want the field unknown to ImageMetadata kept", album)
+	}
+}
+
+func TestPersistDoesNotResurrectDeletedDocument(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+	data := solidPNG(t, color.RGBA{R: 10, G: 10, B: 10, A: 255}, 8, 8)
+
+	first, err := ExtractAndPersistMetadata(ctx, fs, "IMG_1.png", "image/png", "drive-file-1", data, nil, &fakeGeocoder{}, nil)
+	if err != nil {
+		t.Fatalf("first ingest: %v", err)
+	}
+	if err := fs.DeleteImageMetadata(ctx, first.Id); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	// The caller read the document before it was deleted
+	_, err = ExtractAndPersistMetadata(ctx, fs, "IMG_1.png", "image/png", "drive-file-1", data, first, &fakeGeocoder{}, nil)
+	if !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("persist over a deleted document: err = %v, want ErrNotFound", err)
+	}
+	if n := countImages(t, fs); n != 0 {
+		t.Errorf("%d documents after persisting over a deleted one, want 0", n)
+	}
+}
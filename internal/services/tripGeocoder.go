@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"trekka-api/internal/models"
+	"trekka-api/internal/utils"
+)
+
+// Values recorded in geoLocationSource.
+const (
+	GeoSourceDirect     = "direct"
+	GeoSourcePropagated = "propagated"
+)
+
+// A coordinate along a trip, identified by its document ID.
+type TripPoint struct {
+	ID          string
+	Coordinates models.Coordinates
+	TakenAt     time.Time
+}
+
+// The location assigned to a trip point and how it was obtained.
+type TripGeocodeResult struct {
+	ID          string
+	GeoLocation string
+	Parts       models.LocationParts
+	Source      string // GeoSourceDirect or GeoSourcePropagated
+}
+
+// Geocodes a track of points while skipping redundant lookups. Points are processed in TakenAt
+// order; a point within thresholdMeters of the last directly geocoded point inherits its result
+// (GeoSourcePropagated), anything further away is geocoded (GeoSourceDirect) and becomes the new
+// anchor. Points with invalid coordinates or failed lookups are omitted from the results.
+// Returns the results and the number of geocoding calls made.
+func GeocodeTrip(ctx context.Context, geocoder Geocoder, points []TripPoint, thresholdMeters float64) ([]TripGeocodeResult, int) {
+	ordered := make([]TripPoint, len(points))
+	copy(ordered, points)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].TakenAt.Before(ordered[j].TakenAt)
+	})
+
+	var (
+		results        []TripGeocodeResult
+		calls          int
+		haveAnchor     bool
+		anchorLat      float64
+		anchorLng      float64
+		anchorLocation string
+		anchorParts    models.LocationParts
+	)
+
+	for _, p := range ordered {
+		if ctx.Err() != nil {
+			break
+		}
+
+		lat, errLat := strconv.ParseFloat(strings.TrimSpace(p.Coordinates.Lat), 64)
+		lng, errLng := strconv.ParseFloat(strings.TrimSpace(p.Coordinates.Lng), 64)
+		if errLat != nil || errLng != nil {
+			continue
+		}
+
+		if haveAnchor && utils.HaversineMeters(anchorLat, anchorLng, lat, lng) <= thresholdMeters {
+			results = append(results, TripGeocodeResult{ID: p.ID, GeoLocation: anchorLocation, Parts: anchorParts, Source: GeoSourcePropagated})
+			continue
+		}
+
+		calls++
+		parts, err := geocoder.ReverseGeocode(ctx, p.Coordinates)
+		location := FormatLocation(parts)
+		if err != nil || location == "" {
+			continue
+		}
+
+		haveAnchor, anchorLat, anchorLng, anchorLocation, anchorParts = true, lat, lng, location, parts
+		results = append(results, TripGeocodeResult{ID: p.ID, GeoLocation: location, Parts: parts, Source: GeoSourceDirect})
+	}
+
+	return results, calls
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"trekka-api/internal/models"
+)
+
+// Builds a northbound track of n points stepMeters apart, one a minute from start.
+func syntheticTrack(n int, stepMeters float64, start time.Time) []TripPoint {
+	const metersPerDegreeLat = 111_195.0
+	points := make([]TripPoint, n)
+	for i := range points {
+		lat := 38.7 + float64(i)*stepMeters/metersPerDegreeLat
+		points[i] = TripPoint{
+			ID:          fmt.Sprintf("img-%03d", i),
+			Coordinates: models.Coordinates{Lat: strconv.FormatFloat(lat, 'f', 6, 64), Lng: "-9.14"},
+			TakenAt:     start.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	return points
+}
+
+func TestGeocodeTripSkipsNearbyLookups(t *testing.T) {
+	geocoder := &fakeGeocoder{parts: models.LocationParts{City: "Lisbon", Country: "Portugal"}}
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// 100 points 50m apart: 5km of driving, geocoded every 1km
+	track := syntheticTrack(100, 50, start)
+	results, calls := GeocodeTrip(context.Background(), geocoder, track, 1000)
+
+	if got := int(geocoder.calls.Load()); got != calls {
+		t.Errorf("reported %d calls, geocoder saw %d", calls, got)
+	}
+	if calls < 5 || calls > 6 {
+		t.Errorf("made %d geocoding calls for a 5km track with a 1km threshold, want 5 or 6", calls)
+	}
+	if len(results) != len(track) {
+		t.Fatalf("got %d results, want one per point (%d)", len(results), len(track))
+	}
+
+	direct := 0
+	for i, result := range results {
+		if result.ID != track[i].ID {
+			t.Fatalf("result %d is %s, want %s", i, result.ID, track[i].ID)
+		}
+		if result.GeoLocation != "Lisbon, Portugal" {
+			t.Errorf("%s located at %q, want Lisbon, Portugal", result.ID, result.GeoLocation)
+		}
+		if result.Source == GeoSourceDirect {
+			direct++
+		} else if result.Source != GeoSourcePropagated {
+			t.Errorf("%s has source %q", result.ID, result.Source)
+		}
+	}
+	if results[0].Source != GeoSourceDirect {
+		t.Errorf("first point has source %q, want direct", results[0].Source)
+	}
+	if direct != calls {
+		t.Errorf("%d direct results for %d calls, want one per call", direct, calls)
+	}
+}
+
+func TestGeocodeTripOrdersByTakenAt(t *testing.T) {
+	geocoder := &fakeGeocoder{parts: models.LocationParts{City: "Lisbon", Country: "Portugal"}}
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// The same track reversed: still walked in time order, so still one lookup per km
+	track := syntheticTrack(100, 50, start)
+	reversed := make([]TripPoint, len(track))
+	for i, p := range track {
+		reversed[len(track)-1-i] = p
+	}
+
+	results, calls := GeocodeTrip(context.Background(), geocoder, reversed, 1000)
+	if calls > 6 {
+		t.Errorf("made %d geocoding calls, want at most 6", calls)
+	}
+	if len(results) == 0 || results[0].ID != "img-000" {
+		t.Errorf("results start at %v, want img-000", results)
+	}
+}
+
+func TestGeocodeTripOmitsUnlocatablePoints(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	track := syntheticTrack(3, 10, start)
+	track[1].Coordinates = models.Coordinates{Lat: "north", Lng: "-9.14"}
+
+	// A failed lookup doesn't become an anchor, so the next nearby point is looked up itself
+	geocoder := &fakeGeocoder{err: fmt.Errorf("geocoder down")}
+	results, calls := GeocodeTrip(context.Background(), geocoder, track, 1000)
+	if len(results) != 0 {
+		t.Errorf("got %d results from a failing geocoder, want none", len(results))
+	}
+	if calls != 2 {
+		t.Errorf("made %d calls, want 2 (the invalid point is skipped)", calls)
+	}
+}
@@ -0,0 +1,457 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+	"trekka-api/internal/utils"
+)
+
+const tripsCollection = "trips"
+
+// Most places (cities) listed on a trip and used in its generated name.
+const maxTripPlaces = 3
+
+// Longest manual trip name, in bytes.
+const maxTripNameLength = 200
+
+// Segments the collection into trips: runs of images whose takenAt gaps stay under a threshold,
+// optionally split further where the region changes. Trips are stored in their own collection
+// and recomputed on demand; trip IDs and manual names carry over to the recomputed trip that
+// shares the most images with the old one.
+type TripService struct {
+	client    *firestore.Client
+	firestore *FirestoreService
+	gap       time.Duration
+	regionGap time.Duration
+	recompute sync.Mutex // Held while recomputing; a second run in this process is refused
+}
+
+// Creates a trip service. Consecutive images more than gap apart start a new trip, as do located
+// images in a different region from the previous located image when more than regionGap apart
+// (zero disables region splits).
+func NewTripService(fs *FirestoreService, gap, regionGap time.Duration) *TripService {
+	return &TripService{
+		client:    fs.client,
+		firestore: fs,
+		gap:       gap,
+		regionGap: regionGap,
+	}
+}
+
+// Lists every trip, most recent first. Member IDs are left out; use ListImages for them.
+func (t *TripService) List(ctx context.Context) ([]*models.Trip, error) {
+	iter := t.client.Collection(tripsCollection).OrderBy("startAt", firestore.Desc).Documents(ctx)
+	defer iter.Stop()
+
+	trips := []*models.Trip{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate trips: %w", classifyError(err))
+		}
+
+		var trip models.Trip
+		if err := doc.DataTo(&trip); err != nil {
+			// Skip unparseable documents rather than failing the listing
+			continue
+		}
+		trip.Id = doc.Ref.ID
+		trip.ImageIDs = nil
+		trips = append(trips, &trip)
+	}
+
+	return trips, nil
+}
+
+// Retrieves a trip by ID, including its member IDs.
+func (t *TripService) Get(ctx context.Context, id string) (*models.Trip, error) {
+	if id == "" {
+		return nil, fmt.Errorf("%w: trip ID is required", apperrors.ErrInvalidInput)
+	}
+
+	doc, err := t.client.Collection(tripsCollection).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%w: trip %s", apperrors.ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trip %s: %w", id, classifyError(err))
+	}
+
+	var trip models.Trip
+	if err := doc.DataTo(&trip); err != nil {
+		return nil, fmt.Errorf("failed to parse trip %s: %w", id, err)
+	}
+	trip.Id = doc.Ref.ID
+	return &trip, nil
+}
+
+// Renames a trip. The name is kept when the trip is recomputed; a null or empty name goes back
+// to the generated one.
+func (t *TripService) Rename(ctx context.Context, id string, input models.TripInput) (*models.Trip, error) {
+	name := ""
+	if input.Name != nil {
+		name = strings.TrimSpace(*input.Name)
+	}
+	if len(name) > maxTripNameLength {
+		return nil, fmt.Errorf("%w: name exceeds %d bytes", apperrors.ErrInvalidInput, maxTripNameLength)
+	}
+	if id == "" {
+		return nil, fmt.Errorf("%w: trip ID is required", apperrors.ErrInvalidInput)
+	}
+
+	ref := t.client.Collection(tripsCollection).Doc(id)
+	var trip models.Trip
+	err := t.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&trip); err != nil {
+			return err
+		}
+
+		trip.Name, trip.CustomName = name, name != ""
+		if name == "" {
+			trip.Name = trip.AutoName
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "name", Value: trip.Name},
+			{Path: "customName", Value: trip.CustomName},
+		})
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%w: trip %s", apperrors.ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to rename trip %s: %w", id, classifyError(err))
+	}
+
+	trip.Id = id
+	return &trip, nil
+}
+
+// Returns a trip with one page of its images in takenAt order (limit 0 for all). Images deleted
+// since the trip was computed are skipped and counted as missing; ones quarantined since are left
+// out before paging.
+func (t *TripService) ListImages(ctx context.Context, id string, limit int, page int) (*models.TripImages, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("%w: limit cannot be negative", apperrors.ErrInvalidInput)
+	}
+	if page < 0 {
+		return nil, fmt.Errorf("%w: page cannot be negative", apperrors.ErrInvalidInput)
+	}
+
+	trip, err := t.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	images, missing, total, err := t.firestore.getImagePage(ctx, trip.ImageIDs, limit, page)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trip images: %w", err)
+	}
+
+	trip.ImageIDs = nil
+	return &models.TripImages{
+		Trip:    trip,
+		Images:  images,
+		Total:   total,
+		Missing: missing,
+	}, nil
+}
+
+// Recomputes every trip from the current metadata and replaces the stored set. Each new trip
+// takes over the ID of the old trip it shares the most images with (each old trip is used once),
+// along with that trip's manual name; old trips left without a counterpart are deleted.
+// Quarantined images and images without takenAt belong to no trip.
+// Returns ErrConflict if a recomputation is already running in this process.
+func (t *TripService) Recompute(ctx context.Context) (*models.TripRecompute, error) {
+	if !t.recompute.TryLock() {
+		return nil, fmt.Errorf("%w: trip recomputation already running", apperrors.ErrConflict)
+	}
+	defer t.recompute.Unlock()
+
+	start := time.Now()
+	report := &models.TripRecompute{}
+
+	var images []*models.ImageMetadata
+	err := t.firestore.ForEachImageMetadata(ctx, 500, func(img *models.ImageMetadata) error {
+		if img.Hidden() {
+			return nil
+		}
+		if img.TakenAt.IsZero() {
+			report.Undated++
+			return nil
+		}
+		images = append(images, img)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read images for trips: %w", err)
+	}
+
+	sort.SliceStable(images, func(i, j int) bool {
+		if !images[i].TakenAt.Equal(images[j].TakenAt) {
+			return images[i].TakenAt.Before(images[j].TakenAt)
+		}
+		return images[i].Id < images[j].Id
+	})
+
+	now := time.Now()
+	var trips []*models.Trip
+	for _, members := range segmentTrips(images, t.gap, t.regionGap) {
+		trip := buildTrip(members)
+		trip.ComputedAt = now
+		trips = append(trips, trip)
+		report.Images += len(members)
+	}
+
+	previous, err := t.loadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	kept := matchTrips(trips, previous)
+
+	coll := t.client.Collection(tripsCollection)
+	for _, trip := range trips {
+		ref := coll.NewDoc()
+		if trip.Id != "" {
+			ref = coll.Doc(trip.Id)
+		}
+
+		// Re-read the old trip inside the transaction so a rename made during the
+		// recomputation isn't overwritten
+		err := t.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			trip.Name, trip.CustomName = trip.AutoName, false
+			doc, err := tx.Get(ref)
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+			if err == nil {
+				var current models.Trip
+				if err := doc.DataTo(&current); err == nil && current.CustomName {
+					trip.Name, trip.CustomName = current.Name, true
+				}
+			}
+			return tx.Set(ref, trip)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to save trip %q: %w", trip.AutoName, classifyError(err))
+		}
+		trip.Id = ref.ID
+		if trip.CustomName {
+			report.Renamed++
+		}
+	}
+
+	for _, old := range previous {
+		if kept[old.Id] {
+			continue
+		}
+		if _, err := coll.Doc(old.Id).Delete(ctx); err != nil {
+			return nil, fmt.Errorf("failed to delete trip %s: %w", old.Id, classifyError(err))
+		}
+		report.Removed++
+	}
+
+	report.Trips = len(trips)
+	report.ElapsedMs = time.Since(start).Milliseconds()
+	log.Printf("[Trips] Recomputed %d trips from %d images (%d undated, %d names kept, %d removed) in %v",
+		report.Trips, report.Images, report.Undated, report.Renamed, report.Removed, time.Since(start))
+	return report, nil
+}
+
+// Reads every stored trip, including member IDs.
+func (t *TripService) loadAll(ctx context.Context) ([]*models.Trip, error) {
+	docs, err := t.client.Collection(tripsCollection).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trips: %w", classifyError(err))
+	}
+
+	trips := make([]*models.Trip, 0, len(docs))
+	for _, doc := range docs {
+		var trip models.Trip
+		if err := doc.DataTo(&trip); err != nil {
+			continue
+		}
+		trip.Id = doc.Ref.ID
+		trips = append(trips, &trip)
+	}
+	return trips, nil
+}
+
+// Splits images (sorted by takenAt) into trips. A trip ends when the next image is more than gap
+// after the previous one, or when it is located in a different region than the trip's last
+// located image and more than regionGap after it.
+func segmentTrips(images []*models.ImageMetadata, gap, regionGap time.Duration) [][]*models.ImageMetadata {
+	var (
+		segments    [][]*models.ImageMetadata
+		current     []*models.ImageMetadata
+		lastRegion  string
+		lastLocated time.Time
+	)
+
+	for _, img := range images {
+		region := tripRegion(img)
+		if len(current) > 0 {
+			split := img.TakenAt.Sub(current[len(current)-1].TakenAt) > gap
+			if !split && regionGap > 0 && region != "" && lastRegion != "" && region != lastRegion {
+				split = img.TakenAt.Sub(lastLocated) > regionGap
+			}
+			if split {
+				segments = append(segments, current)
+				current, lastRegion = nil, ""
+			}
+		}
+
+		current = append(current, img)
+		if region != "" {
+			lastRegion, lastLocated = region, img.TakenAt
+		}
+	}
+	if len(current) > 0 {
+		segments = append(segments, current)
+	}
+
+	return segments
+}
+
+// Builds a trip's summary and generated name from its members (sorted by takenAt).
+func buildTrip(members []*models.ImageMetadata) *models.Trip {
+	trip := &models.Trip{
+		StartAt:       members[0].TakenAt,
+		EndAt:         members[len(members)-1].TakenAt,
+		CoverFileName: ComputeAlbumSummary(members, "").CoverFileName,
+		ImageCount:    len(members),
+		ImageIDs:      make([]string, len(members)),
+	}
+
+	countries := make(map[string]int)
+	places := make(map[string]int)
+	for i, m := range members {
+		trip.ImageIDs[i] = m.Id
+		if country := firstNonEmpty(m.Country, utils.CountryFromGeoLocation(m.GeoLocation)); country != "" {
+			countries[country]++
+		}
+		if place := tripPlace(m); place != "" {
+			places[place]++
+		}
+	}
+	trip.Countries = rankByCount(countries, 0)
+	trip.Places = rankByCount(places, maxTripPlaces)
+
+	label := "Trip"
+	switch {
+	case len(trip.Places) > 0:
+		label = strings.Join(trip.Places[:min(2, len(trip.Places))], " & ")
+	case len(trip.Countries) > 0:
+		label = strings.Join(trip.Countries[:min(2, len(trip.Countries))], " & ")
+	}
+	trip.AutoName = label + ", " + trip.StartAt.UTC().Format("Jan 2006")
+	trip.Name = trip.AutoName
+
+	return trip
+}
+
+// Gives each new trip the ID of the old trip it shares the most images with, largest overlaps
+// first so every old trip goes to its best match. Returns the IDs of the old trips taken over.
+func matchTrips(trips, previous []*models.Trip) map[string]bool {
+	owner := make(map[string]string)
+	for _, old := range previous {
+		for _, id := range old.ImageIDs {
+			owner[id] = old.Id
+		}
+	}
+
+	type pair struct {
+		trip    int
+		oldID   string
+		overlap int
+	}
+	var pairs []pair
+	for i, trip := range trips {
+		overlaps := make(map[string]int)
+		for _, id := range trip.ImageIDs {
+			if oldID, ok := owner[id]; ok {
+				overlaps[oldID]++
+			}
+		}
+		for oldID, n := range overlaps {
+			pairs = append(pairs, pair{trip: i, oldID: oldID, overlap: n})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].overlap != pairs[j].overlap {
+			return pairs[i].overlap > pairs[j].overlap
+		}
+		if pairs[i].trip != pairs[j].trip {
+			return pairs[i].trip < pairs[j].trip
+		}
+		return pairs[i].oldID < pairs[j].oldID
+	})
+
+	kept := make(map[string]bool)
+	for _, p := range pairs {
+		if kept[p.oldID] || trips[p.trip].Id != "" {
+			continue
+		}
+		trips[p.trip].Id = p.oldID
+		kept[p.oldID] = true
+	}
+	return kept
+}
+
+// Region used to split trips: country plus region where stored, else the country alone.
+// Empty for images without a location.
+func tripRegion(img *models.ImageMetadata) string {
+	country := firstNonEmpty(img.CountryCode, img.Country, utils.CountryFromGeoLocation(img.GeoLocation))
+	if country == "" {
+		return ""
+	}
+	return strings.ToLower(country + "/" + img.Region)
+}
+
+// City an image was taken in: the stored city, else the part of geoLocation before the country.
+func tripPlace(img *models.ImageMetadata) string {
+	if img.City != "" {
+		return img.City
+	}
+	if i := strings.LastIndex(img.GeoLocation, ","); i > 0 {
+		return strings.TrimSpace(img.GeoLocation[:i])
+	}
+	return ""
+}
+
+// Returns the keys of counts, most frequent first (ties by name), keeping at most limit (0 for all).
+func rankByCount(counts map[string]int, limit int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
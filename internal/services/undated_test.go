@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"trekka-api/internal/models"
+)
+
+func TestRepairedUndatedDocumentAppearsInList(t *testing.T) {
+	fs := newEmulatorFirestore(t)
+	ctx := context.Background()
+	createdAt := time.Date(2019, 3, 2, 9, 30, 0, 0, time.UTC)
+
+	seedImage(t, fs, "dated", &models.ImageMetadata{FileName: "dated.jpg", TakenAt: createdAt.Add(24 * time.Hour)})
+	// Written before takenAt existed, so it has neither the field nor a missing entry for it
+	undated := map[string]any{"fileName": "old.jpg", "createdAt": createdAt}
+	if _, err := fs.client.Collection(fs.collection).Doc("old").Set(ctx, undated); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	seedImage(t, fs, "no-dates", &models.ImageMetadata{FileName: "no-dates.jpg", Missing: []string{models.MissingTakenAt}})
+
+	listed := func() []string {
+		t.Helper()
+		images, err := fs.ListImageMetadata(ctx, 100, 0)
+		if err != nil {
+			t.Fatalf("ListImageMetadata: %v", err)
+		}
+		return imageIDs(images)
+	}
+	if got := listed(); !slices.Equal(got, []string{"dated"}) {
+		t.Fatalf("listed %v before the repair, want only the dated image (Firestore drops the rest)", got)
+	}
+
+	missing, err := fs.ListImagesWithoutTakenAt(ctx)
+	if err != nil {
+		t.Fatalf("ListImagesWithoutTakenAt: %v", err)
+	}
+	if got := imageIDs(missing); !slices.Equal(got, []string{"no-dates", "old"}) {
+		t.Fatalf("documents without takenAt = %v, want [no-dates old]", got)
+	}
+
+	// What `trekka-admin undated repair` does for a document with a createdAt
+	if err := fs.SetTakenAt(ctx, "old", missing[1].CreatedAt); err != nil {
+		t.Fatalf("SetTakenAt: %v", err)
+	}
+	if err := fs.SetTakenAt(ctx, "no-dates", createdAt.Add(48*time.Hour)); err != nil {
+		t.Fatalf("SetTakenAt: %v", err)
+	}
+
+	if got := listed(); !slices.Equal(got, []string{"no-dates", "dated", "old"}) {
+		t.Errorf("listed %v after the repair, want [no-dates dated old] newest first", got)
+	}
+	repaired, err := fs.GetImageMetadata(ctx, "no-dates")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if slices.Contains(repaired.Missing, models.MissingTakenAt) {
+		t.Errorf("missing = %v, want takenAt cleared", repaired.Missing)
+	}
+	if missing, err := fs.ListImagesWithoutTakenAt(ctx); err != nil || len(missing) != 0 {
+		t.Errorf("after the repair ListImagesWithoutTakenAt = %v, %v; want none", imageIDs(missing), err)
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"trekka-api/internal/models"
+)
+
+// Timeout for a single signed URL probe, so a slow GCS response doesn't hold up the request being served.
+const urlCheckTimeout = 3 * time.Second
+
+// Probes a fraction (0-1) of cached signed URLs served by GetImage against GCS, so URLs broken by a
+// rotated signing key or a moved object are replaced instead of redirecting clients to 403s until
+// they expire from the cache. Zero disables sampling; ReportBrokenURL works either way.
+func (s *ImageService) SetURLCheckRate(rate float64) {
+	s.urlCheckRate = min(max(rate, 0), 1)
+}
+
+// Returns the signed URL probe counters since startup.
+func (s *ImageService) URLCheckStats() models.URLCheckStats {
+	return models.URLCheckStats{
+		Checked:     s.urlChecked.Load(),
+		Broken:      s.urlBroken.Load(),
+		Regenerated: s.urlRegenerated.Load(),
+		Reports:     s.urlReports.Load(),
+	}
+}
+
+// Handles a client report that the signed URL for fileName failed. The cached URLs for the file
+// are probed (a report alone doesn't evict, so reports can't be used to defeat the cache), and any
+// that GCS rejects are evicted. A fresh URL is then signed from the current storage path and cached.
+// Returns ErrNotFound if no such file exists.
+func (s *ImageService) ReportBrokenURL(ctx context.Context, fileName string) (*models.URLReport, error) {
+	s.urlReports.Add(1)
+
+	metadata, err := s.lookupByFileName(ctx, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	report := &models.URLReport{FileName: fileName}
+	cached := false
+	for _, key := range []string{fileName, metadata.Id} {
+		entry, ok := s.cache.GetSignedURL(key)
+		if !ok {
+			continue
+		}
+		cached = true
+		if s.signedURLBroken(ctx, entry.URL) {
+			s.cache.Delete(key)
+			s.cache.Delete(downloadCacheKeyPrefix + key)
+			report.Broken = true
+		}
+	}
+	if cached && !report.Broken {
+		log.Printf("[URLCheck] Reported URL for %s still works, keeping it", fileName)
+		return report, nil
+	}
+
+	signedURL, urlExpires, err := s.storage.GenerateSignedURL(ctx, metadata.StoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signed URL: %w", err)
+	}
+	s.cache.SetSignedURL(fileName, signedURLEntry(signedURL, urlExpires, metadata))
+	if report.Broken {
+		s.urlRegenerated.Add(1)
+	}
+	report.Regenerated = true
+
+	log.Printf("[URLCheck] Re-signed %s (broken: %t)", fileName, report.Broken)
+	return report, nil
+}
+
+// Reports whether this cache hit should be probed, per the sampling rate.
+func (s *ImageService) sampleURLCheck() bool {
+	return s.urlCheckRate > 0 && rand.Float64() < s.urlCheckRate
+}
+
+// Probes a signed URL and reports whether GCS rejects it (403 or 404). A GET signature doesn't
+// cover HEAD, so the probe is a one-byte ranged GET. Network errors and other statuses count as
+// working: the probe only evicts on a definite answer from GCS.
+func (s *ImageService) signedURLBroken(ctx context.Context, signedURL string) bool {
+	s.urlChecked.Add(1)
+
+	ctx, cancel := context.WithTimeout(ctx, urlCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, signedURL, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := s.urlCheckClient.Do(req)
+	if err != nil {
+		log.Printf("[URLCheck] Probe failed: %v", err)
+		return false
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusNotFound {
+		return false
+	}
+
+	s.urlBroken.Add(1)
+	log.Printf("[URLCheck] Signed URL rejected with %d", resp.StatusCode)
+	return true
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apperrors "trekka-api/internal/errors"
+	"trekka-api/internal/models"
+	"trekka-api/internal/testutil"
+)
+
+// Serves every request with status, counting the probes.
+func fakeSignedURLHost(t *testing.T, status int) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var probes atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		if r.Header.Get("Range") != "bytes=0-0" {
+			t.Errorf("probe sent Range %q, want bytes=0-0", r.Header.Get("Range"))
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &probes
+}
+
+// Returns an ImageService on the emulator and a fake GCS that signs URLs with a local key, with
+// photo.jpg stored.
+func newURLCheckService(t *testing.T) (*ImageService, *CacheService) {
+	t.Helper()
+	fs := newEmulatorFirestore(t)
+	storage, gcs := newFakeStorage(t)
+	storage.SetSigningKey(testutil.FakeSigner, testutil.SigningKey(t))
+	cache := newTestCache(t)
+
+	metadata := &models.ImageMetadata{
+		FileName: "photo.jpg", FileNameLower: "photo.jpg", ContentType: "image/jpeg", StoragePath: "2024/06/photo.jpg",
+	}
+	seedImage(t, fs, "img-1", metadata)
+	gcs.Put(metadata.StoragePath, []byte("jpeg"), metadata.ContentType)
+
+	return NewImageService(storage, cache, fs), cache
+}
+
+// Caches url as the signed URL for photo.jpg.
+func cacheSignedURL(cache *CacheService, url string) {
+	cache.SetSignedURL("photo.jpg", models.SignedURLEntry{
+		URL:         url,
+		ContentType: "image/jpeg",
+		FileName:    "photo.jpg",
+		StoragePath: "2024/06/photo.jpg",
+		URLExpires:  time.Now().Add(time.Hour),
+	})
+}
+
+func TestReportBrokenURLRegenerates(t *testing.T) {
+	images, cache := newURLCheckService(t)
+	ctx := context.Background()
+	broken, probes := fakeSignedURLHost(t, http.StatusForbidden)
+	brokenURL := broken.URL + "/test-bucket/2024/06/photo.jpg?X-Goog-Signature=rotated"
+	cacheSignedURL(cache, brokenURL)
+
+	report, err := images.ReportBrokenURL(ctx, "photo.jpg")
+	if err != nil {
+		t.Fatalf("ReportBrokenURL: %v", err)
+	}
+	if !report.Broken || !report.Regenerated {
+		t.Errorf("report = %+v, want the URL found broken and regenerated", report)
+	}
+	if n := probes.Load(); n != 1 {
+		t.Errorf("probed the cached URL %d times, want 1", n)
+	}
+
+	// The next request is served the fresh URL from the cache
+	url, _, _, _, err := images.GetImage(ctx, models.ImageRequest{FileName: "photo.jpg"})
+	if err != nil {
+		t.Fatalf("GetImage: %v", err)
+	}
+	if url == brokenURL || !strings.Contains(url, "X-Goog-Signature=") || !strings.Contains(url, "2024/06/photo.jpg") {
+		t.Errorf("served %s, want a fresh signed URL for the object", url)
+	}
+
+	want := models.URLCheckStats{Checked: 1, Broken: 1, Regenerated: 1, Reports: 1}
+	if got := images.URLCheckStats(); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestReportBrokenURLKeepsWorkingURL(t *testing.T) {
+	images, cache := newURLCheckService(t)
+	working, _ := fakeSignedURLHost(t, http.StatusPartialContent)
+	workingURL := working.URL + "/test-bucket/2024/06/photo.jpg?X-Goog-Signature=fine"
+	cacheSignedURL(cache, workingURL)
+
+	report, err := images.ReportBrokenURL(context.Background(), "photo.jpg")
+	if err != nil {
+		t.Fatalf("ReportBrokenURL: %v", err)
+	}
+	if report.Broken || report.Regenerated {
+		t.Errorf("report = %+v, want the working URL kept", report)
+	}
+	if entry, ok := cache.GetSignedURL("photo.jpg"); !ok || entry.URL != workingURL {
+		t.Error("working URL was evicted on a report")
+	}
+
+	if _, err := images.ReportBrokenURL(context.Background(), "unknown.jpg"); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("report for an unknown file: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSampledURLCheckEvictsBrokenURL(t *testing.T) {
+	images, cache := newURLCheckService(t)
+	images.SetURLCheckRate(1)
+	ctx := context.Background()
+
+	broken, probes := fakeSignedURLHost(t, http.StatusNotFound)
+	brokenURL := broken.URL + "/test-bucket/2024/05/photo.jpg?X-Goog-Signature=moved"
+	cacheSignedURL(cache, brokenURL)
+
+	url, _, _, _, err := images.GetImage(ctx, models.ImageRequest{FileName: "photo.jpg"})
+	if err != nil {
+		t.Fatalf("GetImage: %v", err)
+	}
+	if url == brokenURL || !strings.Contains(url, "2024/06/photo.jpg") {
+		t.Errorf("served %s, want a URL re-signed from the current storage path", url)
+	}
+	if n := probes.Load(); n != 1 {
+		t.Errorf("probed %d times, want 1", n)
+	}
+	if entry, ok := cache.GetSignedURL("photo.jpg"); !ok || entry.URL != url {
+		t.Error("regenerated URL was not cached")
+	}
+
+	want := models.URLCheckStats{Checked: 1, Broken: 1, Regenerated: 1}
+	if got := images.URLCheckStats(); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
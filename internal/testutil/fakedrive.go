@@ -0,0 +1,315 @@
+package testutil
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+// In-memory stand-in for the parts of the Drive v3 API DriveClient uses: files.list (by parent
+// folder and name, paged), files.get and downloads (alt=media). Shared Drive parameters are
+// applied the way Drive applies them: Shared Drive files are only listed with
+// supportsAllDrives and includeItemsFromAllDrives, and corpora=drive lists one drive only.
+// Calls are recorded, and can be made to fail with FailNext.
+type FakeDrive struct {
+	Service *drive.Service // Talks to the fake
+
+	mu       sync.Mutex
+	files    []DriveFile
+	calls    []DriveCall
+	failures []int // Status codes the next calls are answered with, in order
+	stall    bool  // Downloads send half the body, then hang until the client gives up
+}
+
+// DriveFile is a file held by FakeDrive.
+type DriveFile struct {
+	ID           string
+	Name         string
+	MimeType     string
+	Parent       string // ID of the folder holding the file
+	DriveID      string // Shared Drive holding the file; empty for My Drive
+	Data         []byte
+	Size         int64 // Serves this many generated bytes instead of Data, for payloads too large to hold
+	Description  string
+	Trashed      bool
+	CreatedTime  time.Time
+	ModifiedTime time.Time
+}
+
+// DriveCall is one request FakeDrive received.
+type DriveCall struct {
+	Method string     // "list", "get" or "download"
+	FileID string     // File asked for by get and download
+	Query  url.Values // Query parameters, such as q, corpora and supportsAllDrives
+	Time   time.Time  // When the request arrived
+}
+
+// Starts a fake Drive server for the test and returns it with a Drive service pointed at it.
+func NewFakeDrive(t *testing.T) *FakeDrive {
+	t.Helper()
+	d := &FakeDrive{}
+
+	server := httptest.NewServer(http.HandlerFunc(d.serve))
+	t.Cleanup(server.Close)
+
+	service, err := drive.NewService(context.Background(),
+		option.WithEndpoint(server.URL+"/drive/v3/"),
+		option.WithHTTPClient(server.Client()),
+	)
+	if err != nil {
+		t.Fatalf("drive service: %v", err)
+	}
+	d.Service = service
+
+	return d
+}
+
+// Adds files. Zero times are set to now.
+func (d *FakeDrive) Add(files ...DriveFile) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, file := range files {
+		if file.CreatedTime.IsZero() {
+			file.CreatedTime = time.Now()
+		}
+		if file.ModifiedTime.IsZero() {
+			file.ModifiedTime = file.CreatedTime
+		}
+		d.files = append(d.files, file)
+	}
+}
+
+// Answers the next len(codes) calls with these HTTP statuses instead of serving them. 403 and 429
+// carry Drive's rate-limit reasons.
+func (d *FakeDrive) FailNext(codes ...int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures = append(d.failures, codes...)
+}
+
+// Makes downloads send half the file and then hang until the client cancels the request.
+func (d *FakeDrive) StallDownloads() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stall = true
+}
+
+// Returns every call received so far, in order.
+func (d *FakeDrive) Calls() []DriveCall {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]DriveCall(nil), d.calls...)
+}
+
+func (d *FakeDrive) serve(w http.ResponseWriter, r *http.Request) {
+	rest, ok := strings.CutPrefix(r.URL.Path, "/drive/v3/files")
+	if !ok || r.Method != http.MethodGet {
+		writeDriveError(w, http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	call := DriveCall{Method: "list", Query: query, Time: time.Now()}
+	if id := strings.TrimPrefix(rest, "/"); id != "" {
+		call.Method, call.FileID = "get", id
+		if query.Get("alt") == "media" {
+			call.Method = "download"
+		}
+	}
+
+	d.mu.Lock()
+	d.calls = append(d.calls, call)
+	fail := 0
+	if len(d.failures) > 0 {
+		fail, d.failures = d.failures[0], d.failures[1:]
+	}
+	d.mu.Unlock()
+	if fail != 0 {
+		writeDriveError(w, fail)
+		return
+	}
+
+	if call.Method == "list" {
+		d.serveList(w, query)
+		return
+	}
+	d.serveFile(w, r, call)
+}
+
+// Answers files.list for the folder (and name) in q.
+func (d *FakeDrive) serveList(w http.ResponseWriter, query url.Values) {
+	allDrives := query.Get("supportsAllDrives") == "true" && query.Get("includeItemsFromAllDrives") == "true"
+	corpora := query.Get("corpora")
+	switch {
+	case (corpora == "drive" || corpora == "allDrives") && !allDrives:
+		writeDriveError(w, http.StatusBadRequest)
+		return
+	case corpora == "drive" && query.Get("driveId") == "":
+		writeDriveError(w, http.StatusBadRequest)
+		return
+	}
+
+	q := query.Get("q")
+	parent, name := queryValue(parentPattern, q), queryValue(namePattern, q)
+	untrashed := strings.Contains(q, "trashed=false")
+
+	d.mu.Lock()
+	var matches []*drive.File
+	for _, file := range d.files {
+		switch {
+		case parent != "" && file.Parent != parent,
+			name != "" && file.Name != name,
+			untrashed && file.Trashed,
+			file.DriveID != "" && !allDrives,
+			corpora == "drive" && file.DriveID != query.Get("driveId"):
+			continue
+		}
+		matches = append(matches, file.resource())
+	}
+	d.mu.Unlock()
+
+	start, _ := strconv.Atoi(query.Get("pageToken"))
+	start = min(start, len(matches))
+	end := len(matches)
+	if size, err := strconv.Atoi(query.Get("pageSize")); err == nil && size > 0 {
+		end = min(start+size, end)
+	}
+	list := &drive.FileList{Files: matches[start:end]}
+	if end < len(matches) {
+		list.NextPageToken = strconv.Itoa(end)
+	}
+	writeJSON(w, list)
+}
+
+// Answers files.get, or a download with alt=media. Shared Drive files are only found with
+// supportsAllDrives.
+func (d *FakeDrive) serveFile(w http.ResponseWriter, r *http.Request, call DriveCall) {
+	d.mu.Lock()
+	var file *DriveFile
+	for i := range d.files {
+		if d.files[i].ID == call.FileID {
+			file = &d.files[i]
+		}
+	}
+	stall := d.stall
+	d.mu.Unlock()
+	if file == nil || (file.DriveID != "" && call.Query.Get("supportsAllDrives") != "true") {
+		writeDriveError(w, http.StatusNotFound)
+		return
+	}
+
+	if call.Method == "get" {
+		writeJSON(w, file.resource())
+		return
+	}
+
+	w.Header().Set("Content-Type", file.MimeType)
+	if file.Size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+		io.CopyN(w, generated{}, file.Size)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(file.Data)))
+	if !stall {
+		w.Write(file.Data)
+		return
+	}
+	w.Write(file.Data[:len(file.Data)/2])
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	select {
+	case <-r.Context().Done():
+	case <-time.After(time.Minute):
+	}
+}
+
+// Endless filler for DriveFile.Size downloads.
+type generated struct{}
+
+func (generated) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(i)
+	}
+	return len(p), nil
+}
+
+// The file as Drive returns it. Generated files have no MD5.
+func (f *DriveFile) resource() *drive.File {
+	file := &drive.File{
+		Id:           f.ID,
+		Name:         f.Name,
+		MimeType:     f.MimeType,
+		Size:         int64(len(f.Data)),
+		Description:  f.Description,
+		Trashed:      f.Trashed,
+		DriveId:      f.DriveID,
+		CreatedTime:  f.CreatedTime.UTC().Format(time.RFC3339Nano),
+		ModifiedTime: f.ModifiedTime.UTC().Format(time.RFC3339Nano),
+	}
+	if f.Size > 0 {
+		file.Size = f.Size
+	} else {
+		sum := md5.Sum(f.Data)
+		file.Md5Checksum = hex.EncodeToString(sum[:])
+	}
+	if f.Parent != "" {
+		file.Parents = []string{f.Parent}
+	}
+	return file
+}
+
+// The quoted values of "'<id>' in parents" and "name='<name>'" in a Drive query.
+var (
+	parentPattern = regexp.MustCompile(`'((?:[^'\\]|\\.)*)' in parents`)
+	namePattern   = regexp.MustCompile(`name\s*=\s*'((?:[^'\\]|\\.)*)'`)
+	escapePattern = regexp.MustCompile(`\\(.)`)
+)
+
+// Returns the unescaped value pattern captures in q, or "".
+func queryValue(pattern *regexp.Regexp, q string) string {
+	match := pattern.FindStringSubmatch(q)
+	if match == nil {
+		return ""
+	}
+	return escapePattern.ReplaceAllString(match[1], "$1")
+}
+
+// Writes a Drive error body, with the reason Drive gives for the status.
+func writeDriveError(w http.ResponseWriter, code int) {
+	reason := "backendError"
+	switch code {
+	case http.StatusForbidden:
+		reason = "userRateLimitExceeded"
+	case http.StatusTooManyRequests:
+		reason = "rateLimitExceeded"
+	case http.StatusNotFound:
+		reason = "notFound"
+	case http.StatusBadRequest:
+		reason = "badRequest"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"code":    code,
+			"message": http.StatusText(code),
+			"errors":  []map[string]any{{"reason": reason, "message": http.StatusText(code)}},
+		},
+	})
+}
@@ -0,0 +1,416 @@
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Bucket the fake GCS server holds its objects in.
+const FakeBucket = "test-bucket"
+
+// In-memory stand-in for the parts of the GCS JSON and XML APIs StorageService uses: object
+// reads (whole or ranged), attributes, uploads (multipart and single-request resumable),
+// rewrites and deletes, with generation preconditions. Objects can also be seeded with Put, and
+// requests made to fail with FailNext or uploads corrupted with CorruptNextUpload.
+type FakeGCS struct {
+	Client *storage.Client // Talks to the fake through STORAGE_EMULATOR_HOST
+
+	mu         sync.Mutex
+	objects    map[string]fakeObject
+	generation int64
+	reads      []string                  // Range header of every media read, "" for whole objects
+	sessions   map[string]uploadMetadata // Resumable uploads started but not yet sent, by upload ID
+	failures   map[string][]int          // Status codes the next requests are answered with, by HTTP method
+	uploads    int                       // Uploads started, failed ones included
+	corrupt    int                       // Uploads still to have their data damaged in transit
+}
+
+type fakeObject struct {
+	data         []byte
+	contentType  string
+	cacheControl string
+	metadata     map[string]string
+	generation   int64
+	updated      time.Time
+}
+
+// Object resource fields an upload sends ahead of the data.
+type uploadMetadata struct {
+	Name         string            `json:"name"`
+	ContentType  string            `json:"contentType"`
+	CacheControl string            `json:"cacheControl"`
+	Metadata     map[string]string `json:"metadata"`
+	CRC32C       string            `json:"crc32c"`
+}
+
+// Starts a fake GCS server for the test and points a storage client at it. Sets
+// STORAGE_EMULATOR_HOST, so the test can't run in parallel.
+func NewFakeGCS(t *testing.T) *FakeGCS {
+	t.Helper()
+	g := &FakeGCS{
+		objects:  make(map[string]fakeObject),
+		sessions: make(map[string]uploadMetadata),
+		failures: make(map[string][]int),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(g.serve))
+	t.Cleanup(server.Close)
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(server.URL, "http://"))
+
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		t.Fatalf("storage client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	g.Client = client
+
+	return g
+}
+
+// Stores data under name as a new generation.
+func (g *FakeGCS) Put(name string, data []byte, contentType string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.generation++
+	g.objects[name] = fakeObject{data: data, contentType: contentType, generation: g.generation, updated: time.Now()}
+}
+
+// Returns the object stored under name.
+func (g *FakeGCS) Get(name string) ([]byte, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	object, ok := g.objects[name]
+	return object.data, ok
+}
+
+// Returns the content type, cache control and custom metadata stored with name.
+func (g *FakeGCS) Attrs(name string) (contentType, cacheControl string, metadata map[string]string, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	object, ok := g.objects[name]
+	return object.contentType, object.cacheControl, object.metadata, ok
+}
+
+// Returns the Range header of every media read so far, "" for whole-object reads.
+func (g *FakeGCS) Reads() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.reads...)
+}
+
+// Answers the next len(codes) requests with this HTTP method (GET, POST, PUT or DELETE) with
+// these statuses instead of serving them.
+func (g *FakeGCS) FailNext(method string, codes ...int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.failures[method] = append(g.failures[method], codes...)
+}
+
+// Damages the data of the next upload as if in transit, so it no longer matches the CRC32C sent
+// with it.
+func (g *FakeGCS) CorruptNextUpload() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.corrupt++
+}
+
+// Returns how many uploads (multipart requests or resumable sessions) have been started, including
+// those answered with a FailNext status.
+func (g *FakeGCS) Uploads() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.uploads
+}
+
+func (g *FakeGCS) serve(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	if r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/upload/") {
+		g.uploads++
+	}
+	fail := 0
+	if queue := g.failures[r.Method]; len(queue) > 0 {
+		fail, g.failures[r.Method] = queue[0], queue[1:]
+	}
+	g.mu.Unlock()
+	if fail != 0 {
+		writeGCSError(w, fail, http.StatusText(fail))
+		return
+	}
+
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	for i, segment := range segments {
+		segments[i], _ = url.PathUnescape(segment)
+	}
+
+	switch {
+	// Uploads: /upload/storage/v1/b/{bucket}/o
+	case len(segments) == 6 && segments[0] == "upload" && segments[3] == "b" && segments[4] == FakeBucket && segments[5] == "o":
+		g.serveUpload(w, r)
+	// JSON API: /storage/v1/b/{bucket}/o/{object}[/rewriteTo/b/{bucket}/o/{object}]
+	case len(segments) >= 6 && segments[0] == "storage" && segments[2] == "b" && segments[3] == FakeBucket && segments[4] == "o":
+		g.serveJSON(w, r, segments[5:])
+	// XML API: /{bucket}/{object}, where the object name keeps its slashes
+	case len(segments) >= 2 && segments[0] == FakeBucket:
+		g.serveMedia(w, r, strings.Join(segments[1:], "/"))
+	default:
+		writeGCSError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (g *FakeGCS) serveJSON(w http.ResponseWriter, r *http.Request, rest []string) {
+	name := rest[0]
+	query := r.URL.Query()
+
+	switch {
+	case len(rest) == 1 && r.Method == http.MethodGet && query.Get("alt") == "media":
+		g.serveMedia(w, r, name)
+	case len(rest) == 1 && r.Method == http.MethodGet:
+		g.mu.Lock()
+		object, ok := g.objects[name]
+		g.mu.Unlock()
+		if !ok {
+			writeGCSError(w, http.StatusNotFound, "No such object: "+name)
+			return
+		}
+		writeJSON(w, objectResource(name, object))
+	case len(rest) == 1 && r.Method == http.MethodDelete:
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		object, ok := g.objects[name]
+		if !ok {
+			writeGCSError(w, http.StatusNotFound, "No such object: "+name)
+			return
+		}
+		if !generationMatches(query.Get("ifGenerationMatch"), object, ok) {
+			writeGCSError(w, http.StatusPreconditionFailed, "Precondition failed")
+			return
+		}
+		delete(g.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	case len(rest) == 6 && rest[1] == "rewriteTo" && rest[3] == FakeBucket && r.Method == http.MethodPost:
+		dst := rest[5]
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		src, ok := g.objects[name]
+		if !ok {
+			writeGCSError(w, http.StatusNotFound, "No such object: "+name)
+			return
+		}
+		existing, exists := g.objects[dst]
+		if !generationMatches(query.Get("ifSourceGenerationMatch"), src, true) ||
+			!generationMatches(query.Get("ifGenerationMatch"), existing, exists) {
+			writeGCSError(w, http.StatusPreconditionFailed, "Precondition failed")
+			return
+		}
+		g.generation++
+		copied := src
+		copied.generation, copied.updated = g.generation, time.Now()
+		g.objects[dst] = copied
+		size := strconv.Itoa(len(copied.data))
+		writeJSON(w, map[string]any{
+			"kind":                "storage#rewriteResponse",
+			"totalBytesRewritten": size,
+			"objectSize":          size,
+			"done":                true,
+			"resource":            objectResource(dst, copied),
+		})
+	default:
+		writeGCSError(w, http.StatusNotImplemented, "not supported by the fake")
+	}
+}
+
+// Accepts an upload in one multipart request, or as a resumable session whose data arrives in a
+// single PUT. Rejects data not matching a CRC32C sent with it, as GCS does.
+func (g *FakeGCS) serveUpload(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodPost && query.Get("uploadType") == "multipart":
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			writeGCSError(w, http.StatusBadRequest, "bad multipart body")
+			return
+		}
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		var meta uploadMetadata
+		part, err := reader.NextPart()
+		if err != nil || json.NewDecoder(part).Decode(&meta) != nil {
+			writeGCSError(w, http.StatusBadRequest, "bad upload metadata")
+			return
+		}
+		part, err = reader.NextPart()
+		if err != nil {
+			writeGCSError(w, http.StatusBadRequest, "missing upload data")
+			return
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			writeGCSError(w, http.StatusBadRequest, "bad upload data")
+			return
+		}
+		if meta.Name == "" {
+			meta.Name = query.Get("name")
+		}
+		g.finishUpload(w, meta, query.Get("ifGenerationMatch"), data)
+	case r.Method == http.MethodPost && query.Get("uploadType") == "resumable":
+		var meta uploadMetadata
+		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+			writeGCSError(w, http.StatusBadRequest, "bad upload metadata")
+			return
+		}
+		if meta.Name == "" {
+			meta.Name = query.Get("name")
+		}
+		g.mu.Lock()
+		g.generation++
+		id := strconv.FormatInt(g.generation, 10)
+		g.sessions[id] = meta
+		g.mu.Unlock()
+
+		session := *r.URL
+		session.Scheme, session.Host = "http", r.Host
+		values := session.Query()
+		values.Set("upload_id", id)
+		session.RawQuery = values.Encode()
+		w.Header().Set("Location", session.String())
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut && query.Get("upload_id") != "":
+		g.mu.Lock()
+		meta, ok := g.sessions[query.Get("upload_id")]
+		delete(g.sessions, query.Get("upload_id"))
+		g.mu.Unlock()
+		if !ok {
+			writeGCSError(w, http.StatusNotFound, "No such upload")
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeGCSError(w, http.StatusBadRequest, "bad upload data")
+			return
+		}
+		g.finishUpload(w, meta, query.Get("ifGenerationMatch"), data)
+	default:
+		writeGCSError(w, http.StatusNotImplemented, "not supported by the fake")
+	}
+}
+
+// Stores an uploaded object and answers with its resource.
+func (g *FakeGCS) finishUpload(w http.ResponseWriter, meta uploadMetadata, precondition string, data []byte) {
+	g.mu.Lock()
+	if g.corrupt > 0 && len(data) > 0 {
+		g.corrupt--
+		data = append([]byte(nil), data...)
+		data[0] ^= 0xff
+	}
+	g.mu.Unlock()
+
+	if meta.CRC32C != "" && meta.CRC32C != crc32cOf(data) {
+		writeGCSError(w, http.StatusBadRequest, "Provided CRC32C doesn't match calculated CRC32C")
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	existing, exists := g.objects[meta.Name]
+	if !generationMatches(precondition, existing, exists) {
+		writeGCSError(w, http.StatusPreconditionFailed, "Precondition failed")
+		return
+	}
+	g.generation++
+	object := fakeObject{
+		data:         data,
+		contentType:  meta.ContentType,
+		cacheControl: meta.CacheControl,
+		metadata:     meta.Metadata,
+		generation:   g.generation,
+		updated:      time.Now(),
+	}
+	g.objects[meta.Name] = object
+	writeJSON(w, objectResource(meta.Name, object))
+}
+
+// Serves an object's bytes, honouring Range the way GCS does (206 with Content-Range, 416 past
+// the end).
+func (g *FakeGCS) serveMedia(w http.ResponseWriter, r *http.Request, name string) {
+	g.mu.Lock()
+	object, ok := g.objects[name]
+	g.reads = append(g.reads, r.Header.Get("Range"))
+	g.mu.Unlock()
+	if !ok {
+		writeGCSError(w, http.StatusNotFound, "No such object: "+name)
+		return
+	}
+
+	w.Header().Set("Content-Type", object.contentType)
+	w.Header().Set("X-Goog-Generation", strconv.FormatInt(object.generation, 10))
+	w.Header().Set("X-Goog-Metageneration", "1")
+	http.ServeContent(w, r, "", object.updated, bytes.NewReader(object.data))
+}
+
+// Reports whether a generation precondition ("" for none, "0" for must not exist) holds.
+func generationMatches(precondition string, object fakeObject, exists bool) bool {
+	if precondition == "" {
+		return true
+	}
+	want, err := strconv.ParseInt(precondition, 10, 64)
+	if err != nil {
+		return false
+	}
+	if want == 0 {
+		return !exists
+	}
+	return exists && object.generation == want
+}
+
+func objectResource(name string, object fakeObject) map[string]any {
+	return map[string]any{
+		"kind":           "storage#object",
+		"name":           name,
+		"bucket":         FakeBucket,
+		"generation":     strconv.FormatInt(object.generation, 10),
+		"metageneration": "1",
+		"size":           strconv.Itoa(len(object.data)),
+		"contentType":    object.contentType,
+		"cacheControl":   object.cacheControl,
+		"metadata":       object.metadata,
+		"crc32c":         crc32cOf(object.data),
+		"updated":        object.updated.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// Encodes data's CRC32C the way GCS reports it: base64 of the big-endian checksum.
+func crc32cOf(data []byte) string {
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func writeJSON(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeGCSError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"code": code, "message": message},
+	})
+}
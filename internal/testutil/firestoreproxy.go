@@ -0,0 +1,183 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// Sits between a Firestore client and the emulator, passing every call through unchanged while
+// counting them, so tests can assert how many reads a code path makes. Calls can be held for a
+// delay first, so concurrent callers overlap.
+type FirestoreProxy struct {
+	Client *firestore.Client // Talks to the emulator through the proxy
+
+	mu    sync.Mutex
+	calls []string // Full method names, e.g. /google.firestore.v1.Firestore/BatchGetDocuments
+	delay time.Duration
+}
+
+// Starts a proxy to the emulator and points a Firestore client at it, in a project of its own as
+// with FirestoreClient. Sets FIRESTORE_EMULATOR_HOST, so the test can't run in parallel. Skips
+// the test unless FIRESTORE_EMULATOR_HOST is set (see make test-emulator).
+func NewFirestoreProxy(t *testing.T) *FirestoreProxy {
+	t.Helper()
+	emulator := os.Getenv("FIRESTORE_EMULATOR_HOST")
+	if emulator == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST not set; skipping emulator test")
+	}
+	p := &FirestoreProxy{}
+
+	conn, err := grpc.NewClient(emulator, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial emulator: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := grpc.NewServer(
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(func(srv any, stream grpc.ServerStream) error {
+			return p.forward(conn, stream)
+		}),
+	)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	t.Setenv("FIRESTORE_EMULATOR_HOST", listener.Addr().String())
+
+	projectID := fmt.Sprintf("test-%d-%d", time.Now().Unix(), emulatorProjects.Add(1))
+	client, err := firestore.NewClient(context.Background(), projectID)
+	if err != nil {
+		t.Fatalf("firestore client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	p.Client = client
+
+	return p
+}
+
+// Holds each call for delay before passing it on.
+func (p *FirestoreProxy) SetDelay(delay time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.delay = delay
+}
+
+// Returns the number of calls passed through so far.
+func (p *FirestoreProxy) Calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.calls)
+}
+
+// Returns the method of every call passed through so far, in order.
+func (p *FirestoreProxy) Methods() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.calls...)
+}
+
+// Passes one call, of any kind of stream, on to the emulator and its responses back.
+func (p *FirestoreProxy) forward(conn *grpc.ClientConn, in grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(in)
+	p.mu.Lock()
+	p.calls = append(p.calls, method)
+	delay := p.delay
+	p.mu.Unlock()
+
+	ctx := in.Context()
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// Pass on the client's metadata (the emulator's auth and routing headers), less the
+	// transport's own
+	md, _ := metadata.FromIncomingContext(ctx)
+	outgoing := metadata.MD{}
+	for key, values := range md {
+		if !strings.HasPrefix(key, ":") && key != "content-type" && key != "user-agent" {
+			outgoing[key] = values
+		}
+	}
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(ctx, outgoing))
+	defer cancel()
+
+	out, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, method, grpc.ForceCodec(rawCodec{}))
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			var msg rawMessage
+			if err := in.RecvMsg(&msg); err != nil {
+				if err == io.EOF {
+					out.CloseSend()
+				}
+				return
+			}
+			if err := out.SendMsg(&msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	for first := true; ; first = false {
+		var msg rawMessage
+		if err := out.RecvMsg(&msg); err != nil {
+			in.SetTrailer(out.Trailer())
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if first {
+			if header, err := out.Header(); err == nil {
+				in.SendHeader(header)
+			}
+		}
+		if err := in.SendMsg(&msg); err != nil {
+			return err
+		}
+	}
+}
+
+// An encoded message, passed on without decoding.
+type rawMessage struct {
+	data []byte
+}
+
+// Codec that leaves messages encoded, so the proxy needs no knowledge of the Firestore protos.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	return v.(*rawMessage).data, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	v.(*rawMessage).data = append([]byte(nil), data...)
+	return nil
+}
+
+// Named for the protos it carries, so requests keep the content type Firestore expects.
+func (rawCodec) Name() string {
+	return "proto"
+}
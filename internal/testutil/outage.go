@@ -0,0 +1,55 @@
+package testutil
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Firestore backend that answers every call with one error, for simulating an outage without the
+// emulator. Reads fail on their first response, which the client library doesn't retry, so only
+// the service's own retries (see withRetry) show up in Calls.
+type FirestoreOutage struct {
+	Client *firestore.Client // Talks to the failing backend through FIRESTORE_EMULATOR_HOST
+
+	calls atomic.Int64
+}
+
+// Starts a gRPC server failing every call with code and points a Firestore client at it. Sets
+// FIRESTORE_EMULATOR_HOST, so the test can't run in parallel.
+func NewFirestoreOutage(t *testing.T, code codes.Code) *FirestoreOutage {
+	t.Helper()
+	o := &FirestoreOutage{}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := grpc.NewServer(grpc.UnknownServiceHandler(func(srv any, stream grpc.ServerStream) error {
+		o.calls.Add(1)
+		return status.Error(code, "simulated Firestore outage")
+	}))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	t.Setenv("FIRESTORE_EMULATOR_HOST", listener.Addr().String())
+
+	client, err := firestore.NewClient(context.Background(), "test-outage")
+	if err != nil {
+		t.Fatalf("firestore client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	o.Client = client
+
+	return o
+}
+
+// Returns the number of calls the backend has failed so far.
+func (o *FirestoreOutage) Calls() int64 {
+	return o.calls.Load()
+}
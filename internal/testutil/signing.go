@@ -0,0 +1,33 @@
+package testutil
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"sync"
+	"testing"
+)
+
+// Service account the key from SigningKey is issued to, for StorageService.SetSigningKey.
+const FakeSigner = "signer@test-project.iam.gserviceaccount.com"
+
+// One key for the whole test binary; generating RSA keys is slow.
+var signingKey = sync.OnceValues(func() ([]byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), nil
+})
+
+// Returns a PEM private key URLs can be signed with locally, so code that signs URLs runs against
+// the fake GCS (whose client has no credentials to sign with). GCS would reject the signatures.
+func SigningKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := signingKey()
+	if err != nil {
+		t.Fatalf("generate signing key: %v", err)
+	}
+	return key
+}
@@ -0,0 +1,35 @@
+// Package testutil holds the emulator helpers and fakes shared by the packages' tests.
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Numbers the emulator projects tests run in.
+var emulatorProjects atomic.Int64
+
+// Returns a client for the Firestore emulator, in a project of its own so tests never see each
+// other's documents. Skips the test unless FIRESTORE_EMULATOR_HOST is set (see make
+// test-emulator).
+func FirestoreClient(t *testing.T) *firestore.Client {
+	t.Helper()
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST not set; skipping emulator test")
+	}
+
+	projectID := fmt.Sprintf("test-%d-%d", time.Now().Unix(), emulatorProjects.Add(1))
+	client, err := firestore.NewClient(context.Background(), projectID)
+	if err != nil {
+		t.Fatalf("firestore client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/disintegration/imaging"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Width images are downscaled to before sampling colors.
+const dominantColorSampleWidth = 64
+
+// Computes the dominant color of an image as a "#rrggbb" hex string.
+// The image is downscaled, pixels are quantized into 4-bit-per-channel buckets, and the
+// average color of the most populated bucket is returned. Mostly transparent pixels are ignored.
+func DominantColor(img image.Image) string {
+	if img == nil || img.Bounds().Empty() {
+		return ""
+	}
+
+	small := img
+	if img.Bounds().Dx() > dominantColorSampleWidth {
+		small = imaging.Resize(img, dominantColorSampleWidth, 0, imaging.Box)
+	}
+
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := make(map[uint16]*bucket)
+	var best *bucket
+
+	bounds := small.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := small.At(x, y).RGBA()
+			if a < 0x8000 {
+				continue
+			}
+			r8, g8, b8 := int(r>>8), int(g>>8), int(b>>8)
+			key := uint16(r8>>4)<<8 | uint16(g8>>4)<<4 | uint16(b8>>4)
+
+			bk := buckets[key]
+			if bk == nil {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+			bk.count++
+			bk.r += r8
+			bk.g += g8
+			bk.b += b8
+
+			if best == nil || bk.count > best.count {
+				best = bk
+			}
+		}
+	}
+
+	if best == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.count, best.g/best.count, best.b/best.count)
+}
+
+// Computes the dominant color of encoded image data.
+// The EXIF-embedded JPEG thumbnail is used when present so large originals don't need a full decode.
+func DominantColorFromBytes(data []byte) (string, error) {
+	return DominantColorFromReader(bytes.NewReader(data))
+}
+
+// Computes the dominant color of an encoded image read from r, preferring its EXIF thumbnail
+// like DominantColorFromBytes.
+func DominantColorFromReader(r io.ReadSeeker) (string, error) {
+	if x, err := exif.Decode(r); err == nil {
+		if thumb, err := x.JpegThumbnail(); err == nil && len(thumb) > 0 {
+			if img, err := imaging.Decode(bytes.NewReader(thumb), imaging.AutoOrientation(true)); err == nil {
+				return DominantColor(img), nil
+			}
+		}
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind image: %w", err)
+	}
+	img, err := imaging.Decode(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	return DominantColor(img), nil
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+)
+
+// Returns a width×height image filled with c.
+func solidImage(c color.Color, width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestDominantColorOfSolidImages(t *testing.T) {
+	tests := []struct {
+		name string
+		fill color.Color
+		want string
+	}{
+		{"red", color.NRGBA{R: 255, A: 255}, "#ff0000"},
+		{"green", color.NRGBA{G: 255, A: 255}, "#00ff00"},
+		{"sky blue", color.NRGBA{R: 90, G: 125, B: 154, A: 255}, "#5a7d9a"},
+		{"white", color.NRGBA{R: 255, G: 255, B: 255, A: 255}, "#ffffff"},
+		{"fully transparent", color.NRGBA{R: 255}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Wider than the sample width, so the downscale path runs too
+			if got := DominantColor(solidImage(tt.fill, 300, 200)); got != tt.want {
+				t.Errorf("DominantColor = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDominantColorIgnoresMinority(t *testing.T) {
+	img := solidImage(color.NRGBA{B: 200, A: 255}, 100, 100)
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 100; x++ {
+			img.Set(x, y, color.NRGBA{R: 200, A: 255})
+		}
+	}
+	if got := DominantColor(img); got != "#0000c8" {
+		t.Errorf("DominantColor = %q, want the 70%% blue #0000c8", got)
+	}
+}
+
+func TestDominantColorFromDownscaledBytesIsFast(t *testing.T) {
+	// A thumbnail-sized rendition, as GetThumbnail passes in
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, solidImage(color.NRGBA{R: 40, G: 160, B: 80, A: 255}, 400, 300)); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	data := buf.Bytes()
+
+	const runs = 20
+	start := time.Now()
+	for range runs {
+		got, err := DominantColorFromBytes(data)
+		if err != nil {
+			t.Fatalf("DominantColorFromBytes: %v", err)
+		}
+		if got != "#28a050" {
+			t.Fatalf("DominantColorFromBytes = %q, want #28a050", got)
+		}
+	}
+	if perCall := time.Since(start) / runs; perCall > 5*time.Millisecond && !raceEnabled {
+		t.Errorf("DominantColorFromBytes took %v per call on a 400px image, want under 5ms", perCall)
+	}
+}
@@ -0,0 +1,18 @@
+package utils
+
+import "math"
+
+// Mean Earth radius in metres.
+const earthRadiusMeters = 6_371_000.0
+
+// Returns the great-circle distance in metres between two lat/lng points (degrees).
+func HaversineMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(a)))
+}
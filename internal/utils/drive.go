@@ -2,10 +2,21 @@ package utils
 
 import (
 	"strings"
+	"time"
 
 	"google.golang.org/api/drive/v3"
 )
 
+// Parses a Drive timestamp (RFC 3339, e.g. "2024-05-01T10:00:00.000Z"), returning the zero time
+// for an empty or malformed one.
+func ParseDriveTime(value string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
 func IsImage(file *drive.File) bool {
 	if file == nil {
 		return false
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDriveTime(t *testing.T) {
+	want := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2024-05-01T10:00:00.000Z", want},
+		{"2024-05-01T10:00:00Z", want},
+		{"2024-05-01T10:00:00.123Z", want.Add(123 * time.Millisecond)},
+		{"2024-05-01T10:00:00.123456789Z", want.Add(123456789 * time.Nanosecond)},
+		{"2024-05-01T12:00:00+02:00", want},
+		{"2024-05-01T05:30:00.000-04:30", want},
+		{"", time.Time{}},
+		{"2024-05-01", time.Time{}},
+		{"2024-05-01 10:00:00", time.Time{}},
+		{"2024:05:01 10:00:00", time.Time{}},
+		{"2024-05-01T10:00:00", time.Time{}},
+		{"not a time", time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := ParseDriveTime(tt.value); !got.Equal(tt.want) {
+			t.Errorf("ParseDriveTime(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
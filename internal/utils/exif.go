@@ -6,6 +6,7 @@ import (
 	"image"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
 	"time"
 
 	"trekka-api/internal/models"
@@ -15,8 +16,11 @@ import (
 
 // Extracts GPS coordinates, timestamp, and resolution from image EXIF data
 func ExtractData(imageData []byte) (models.Coordinates, string, []float64, error) {
-	reader := bytes.NewReader(imageData)
+	return ExtractDataFromReader(bytes.NewReader(imageData))
+}
 
+// Extracts the same data as ExtractData from a seekable reader, e.g. a file too large to load.
+func ExtractDataFromReader(reader io.ReadSeeker) (models.Coordinates, string, []float64, error) {
 	x, err := exif.Decode(reader)
 	if err != nil {
 		return models.Coordinates{}, "", nil, fmt.Errorf("failed to decode EXIF: %w", err)
@@ -71,7 +75,7 @@ func ExtractData(imageData []byte) (models.Coordinates, string, []float64, error
 
 	// Extract resolution from image data
 	var resolution []float64
-	reader.Seek(0, 0) // Reset reader to start
+	reader.Seek(0, io.SeekStart) // Reset reader to start
 	config, _, err := image.DecodeConfig(reader)
 	if err == nil && config.Width > 0 && config.Height > 0 {
 		resolution = []float64{float64(config.Width), float64(config.Height)}
@@ -80,6 +84,24 @@ func ExtractData(imageData []byte) (models.Coordinates, string, []float64, error
 	return coords, timestamp, resolution, nil
 }
 
+// Lists the fields HasEmptyFields checks that are empty, as models.Missing* values.
+func MissingFields(metadata *models.ImageMetadata) []string {
+	var missing []string
+	if metadata.Coordinates.Lat == "" || metadata.Coordinates.Lng == "" {
+		missing = append(missing, models.MissingCoordinates)
+	}
+	if metadata.TakenAt.IsZero() {
+		missing = append(missing, models.MissingTakenAt)
+	}
+	if metadata.GeoLocation == "" {
+		missing = append(missing, models.MissingGeoLocation)
+	}
+	if metadata.FormattedDate == "" {
+		missing = append(missing, models.MissingFormattedDate)
+	}
+	return missing
+}
+
 // Checks if an image already has GPS/location data
 func hasEmptyFields(metadata *models.ImageMetadata, ignoreGeoLoc bool) bool {
 	// Check if GeoLocation string is present
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// Longest tag value returned by the dump functions; longer values (maker notes, embedded
+// thumbnails) are cut short and marked with "…".
+const MaxExifValueLength = 512
+
+// Collects every tag goexif encounters into a name → value map.
+type exifCollector map[string]string
+
+func (c exifCollector) Walk(name exif.FieldName, tag *tiff.Tag) error {
+	value := tag.String()
+	if tag.Format() == tiff.StringVal {
+		if s, err := tag.StringVal(); err == nil {
+			value = s
+		}
+	}
+	c[string(name)] = truncateExifValue(value)
+	return nil
+}
+
+// Decodes every EXIF tag of an image into a tag name → string value map.
+func ExtractAllExif(data []byte) (map[string]string, error) {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode EXIF: %w", err)
+	}
+
+	tags := make(exifCollector)
+	if err := x.Walk(tags); err != nil {
+		return nil, fmt.Errorf("failed to walk EXIF: %w", err)
+	}
+	return tags, nil
+}
+
+// Reads every metadata tag of a video with exiftool into a tag name → string value map.
+// Names are group-qualified (e.g. "QuickTime:CreateDate") since videos repeat tags across groups.
+// The video is streamed to exiftool's stdin from r.
+func ExtractAllVideoTags(r io.Reader) (map[string]string, error) {
+	cmd := exec.Command("exiftool", "-json", "-G", "-")
+	cmd.Stdin = r
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("exiftool failed: %w", err)
+	}
+
+	var objects []map[string]any
+	if err := json.Unmarshal(output, &objects); err != nil {
+		return nil, fmt.Errorf("failed to parse exiftool output: %w", err)
+	}
+	if len(objects) == 0 {
+		return map[string]string{}, nil
+	}
+
+	tags := make(map[string]string, len(objects[0]))
+	for name, value := range objects[0] {
+		if name == "SourceFile" {
+			continue
+		}
+		tags[name] = truncateExifValue(fmt.Sprint(value))
+	}
+	return tags, nil
+}
+
+func truncateExifValue(value string) string {
+	value = strings.TrimRight(value, "\x00")
+	if len(value) <= MaxExifValueLength {
+		return value
+	}
+	return value[:MaxExifValueLength] + "…"
+}
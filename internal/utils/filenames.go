@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Reads filenames from the first column of a CSV, skipping a "filename" header row and blank lines.
+func ParseFileNamesCSV(input io.Reader) ([]string, error) {
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var fileNames []string
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		if len(record) == 0 {
+			continue
+		}
+
+		name := strings.TrimSpace(record[0])
+		if name == "" || (first && strings.EqualFold(strings.ReplaceAll(name, "_", ""), "filename")) {
+			continue
+		}
+		fileNames = append(fileNames, name)
+	}
+
+	return fileNames, nil
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"trekka-api/internal/models"
+)
+
+// Precision of the geohash stored on each document (~5m cells), enough for any radius query
+// to narrow down to a prefix.
+const GeohashPrecision = 9
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Encodes a lat/lng (degrees) as a geohash of the given length.
+func Geohash(lat, lng float64, precision int) string {
+	latMin, latMax := -90.0, 90.0
+	lngMin, lngMax := -180.0, 180.0
+
+	var sb strings.Builder
+	bits, ch := 0, 0
+	even := true // Bits alternate between longitude (even) and latitude (odd)
+
+	for sb.Len() < precision {
+		if even {
+			mid := (lngMin + lngMax) / 2
+			if lng >= mid {
+				ch = ch<<1 | 1
+				lngMin = mid
+			} else {
+				ch <<= 1
+				lngMax = mid
+			}
+		} else {
+			mid := (latMin + latMax) / 2
+			if lat >= mid {
+				ch = ch<<1 | 1
+				latMin = mid
+			} else {
+				ch <<= 1
+				latMax = mid
+			}
+		}
+		even = !even
+
+		bits++
+		if bits == 5 {
+			sb.WriteByte(geohashAlphabet[ch])
+			bits, ch = 0, 0
+		}
+	}
+
+	return sb.String()
+}
+
+// Returns the geohash of stored coordinates at GeohashPrecision, or "" if they are missing or invalid.
+func GeohashFromCoordinates(c models.Coordinates) string {
+	lat, lng, ok := ParseCoordinates(c)
+	if !ok {
+		return ""
+	}
+	return Geohash(lat, lng, GeohashPrecision)
+}
+
+// Parses stored string coordinates into degrees, rejecting values outside the valid ranges.
+func ParseCoordinates(c models.Coordinates) (lat, lng float64, ok bool) {
+	lat, err := strconv.ParseFloat(strings.TrimSpace(c.Lat), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	lng, err = strconv.ParseFloat(strings.TrimSpace(c.Lng), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if !ValidLatLng(lat, lng) {
+		return 0, 0, false
+	}
+	return lat, lng, true
+}
+
+// Reports whether lat/lng are finite and within [-90, 90] / [-180, 180].
+func ValidLatLng(lat, lng float64) bool {
+	return !math.IsNaN(lat) && !math.IsNaN(lng) &&
+		lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+// Returns the geohash prefixes whose cells together cover a circle of radiusMeters around lat/lng:
+// the cell containing the centre plus its eight neighbours, at the longest precision whose cells
+// are at least radiusMeters on each side. Results are deduplicated (neighbours collapse near the
+// poles and at very large radii).
+func GeohashCoverage(lat, lng, radiusMeters float64) []string {
+	precision := 1
+	for p := GeohashPrecision; p >= 1; p-- {
+		height, width := geohashCellSize(p)
+		widthMeters := width * metersPerDegree * math.Cos(lat*math.Pi/180)
+		if height*metersPerDegree >= radiusMeters && widthMeters >= radiusMeters {
+			precision = p
+			break
+		}
+	}
+
+	height, width := geohashCellSize(precision)
+	seen := make(map[string]bool, 9)
+	var prefixes []string
+	for _, dLat := range []float64{-height, 0, height} {
+		for _, dLng := range []float64{-width, 0, width} {
+			cellLat := math.Max(-90, math.Min(90, lat+dLat))
+			cellLng := math.Mod(lng+dLng+540, 360) - 180 // Wrap across the antimeridian
+			hash := Geohash(cellLat, cellLng, precision)
+			if !seen[hash] {
+				seen[hash] = true
+				prefixes = append(prefixes, hash)
+			}
+		}
+	}
+
+	return prefixes
+}
+
+// Picks the geohash precision whose cells suit clustering at a web-map zoom level (0-22),
+// roughly a few cells per 256px tile: precision 1 at world view up to 8 at street level.
+func GeohashPrecisionForZoom(zoom int) int {
+	switch {
+	case zoom <= 2:
+		return 1
+	case zoom <= 4:
+		return 2
+	case zoom <= 7:
+		return 3
+	case zoom <= 10:
+		return 4
+	case zoom <= 12:
+		return 5
+	case zoom <= 15:
+		return 6
+	case zoom <= 17:
+		return 7
+	default:
+		return 8
+	}
+}
+
+// Returns the height and width in degrees of a geohash cell at the given precision.
+func geohashCellSize(precision int) (latDegrees, lngDegrees float64) {
+	bits := 5 * precision
+	lngBits := (bits + 1) / 2
+	latBits := bits / 2
+	return 180 / math.Exp2(float64(latBits)), 360 / math.Exp2(float64(lngBits))
+}
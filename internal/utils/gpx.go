@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// A timestamped position recorded by a GPS logger.
+type TrackPoint struct {
+	Lat  float64
+	Lng  float64
+	Time time.Time
+}
+
+// Trackpoints sorted by time, as returned by ParseGPX.
+type Track []TrackPoint
+
+type gpxDocument struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []struct {
+				Lat  float64 `xml:"lat,attr"`
+				Lon  float64 `xml:"lon,attr"`
+				Time string  `xml:"time"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// Parses the trackpoints of every track and segment in a GPX file into a single time-ordered
+// track. Points without a timestamp or with invalid coordinates are skipped; an error is returned
+// if none are left.
+func ParseGPX(r io.Reader) (Track, error) {
+	var doc gpxDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid GPX: %w", err)
+	}
+
+	var track Track
+	for _, trk := range doc.Tracks {
+		for _, seg := range trk.Segments {
+			for _, pt := range seg.Points {
+				t, err := time.Parse(time.RFC3339, pt.Time)
+				if err != nil || !ValidLatLng(pt.Lat, pt.Lon) {
+					continue
+				}
+				track = append(track, TrackPoint{Lat: pt.Lat, Lng: pt.Lon, Time: t.UTC()})
+			}
+		}
+	}
+	if len(track) == 0 {
+		return nil, fmt.Errorf("GPX contains no timestamped trackpoints")
+	}
+
+	sort.SliceStable(track, func(i, j int) bool { return track[i].Time.Before(track[j].Time) })
+	return track, nil
+}
+
+// Returns the time of the first and last trackpoint.
+func (t Track) Span() (start, end time.Time) {
+	if len(t) == 0 {
+		return time.Time{}, time.Time{}
+	}
+	return t[0].Time, t[len(t)-1].Time
+}
+
+// Returns the position at the given time, linearly interpolated between the surrounding
+// trackpoints. ok is false outside the track, or when the surrounding points are more than
+// maxGap apart (the logger was off, so any position would be a guess). maxGap <= 0 disables
+// the gap check.
+func (t Track) PositionAt(at time.Time, maxGap time.Duration) (lat, lng float64, ok bool) {
+	if len(t) == 0 {
+		return 0, 0, false
+	}
+
+	// First point at or after the requested time
+	i := sort.Search(len(t), func(i int) bool { return !t[i].Time.Before(at) })
+	if i == len(t) {
+		return 0, 0, false
+	}
+	if t[i].Time.Equal(at) {
+		return t[i].Lat, t[i].Lng, true
+	}
+	if i == 0 {
+		return 0, 0, false
+	}
+
+	prev, next := t[i-1], t[i]
+	gap := next.Time.Sub(prev.Time)
+	if maxGap > 0 && gap > maxGap {
+		return 0, 0, false
+	}
+
+	f := float64(at.Sub(prev.Time)) / float64(gap)
+	return prev.Lat + (next.Lat-prev.Lat)*f, prev.Lng + (next.Lng-prev.Lng)*f, true
+}
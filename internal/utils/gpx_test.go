@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Two segments, the second recorded first, with a point lacking a time and one off the globe.
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk>
+    <trkseg>
+      <trkpt lat="38.7200" lon="-9.1400"><time>2024-06-01T12:10:00Z</time></trkpt>
+      <trkpt lat="38.7300" lon="-9.1500"><time>2024-06-01T13:15:00+01:00</time></trkpt>
+    </trkseg>
+    <trkseg>
+      <trkpt lat="38.7000" lon="-9.1200"><time>2024-06-01T12:00:00Z</time></trkpt>
+      <trkpt lat="38.7100" lon="-9.1300"><time>2024-06-01T12:05:00Z</time></trkpt>
+      <trkpt lat="38.7150" lon="-9.1350"></trkpt>
+      <trkpt lat="91" lon="-9.1350"><time>2024-06-01T12:07:00Z</time></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+func TestParseGPX(t *testing.T) {
+	track, err := ParseGPX(strings.NewReader(testGPX))
+	if err != nil {
+		t.Fatalf("ParseGPX: %v", err)
+	}
+	if len(track) != 4 {
+		t.Fatalf("got %d points, want 4 (untimed and invalid points skipped)", len(track))
+	}
+	for i := 1; i < len(track); i++ {
+		if !track[i-1].Time.Before(track[i].Time) {
+			t.Errorf("point %d at %s is not after %s", i, track[i].Time, track[i-1].Time)
+		}
+	}
+
+	start, end := track.Span()
+	if want := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC); !start.Equal(want) {
+		t.Errorf("track starts %s, want %s", start, want)
+	}
+	if want := time.Date(2024, 6, 1, 12, 15, 0, 0, time.UTC); !end.Equal(want) {
+		t.Errorf("track ends %s, want %s (offsets normalised to UTC)", end, want)
+	}
+
+	for _, doc := range []string{"not xml", `<gpx><trk><trkseg><trkpt lat="1" lon="2"/></trkseg></trk></gpx>`} {
+		if _, err := ParseGPX(strings.NewReader(doc)); err == nil {
+			t.Errorf("ParseGPX(%q) succeeded, want an error", doc)
+		}
+	}
+}
+
+func TestTrackPositionAt(t *testing.T) {
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	track := Track{
+		{Lat: 10, Lng: 20, Time: base},
+		{Lat: 11, Lng: 22, Time: base.Add(2 * time.Minute)},
+		{Lat: 12, Lng: 24, Time: base.Add(30 * time.Minute)},
+	}
+
+	tests := []struct {
+		name     string
+		at       time.Duration // After the first point
+		maxGap   time.Duration
+		wantLat  float64
+		wantLng  float64
+		wantFind bool
+	}{
+		{"first trackpoint", 0, 5 * time.Minute, 10, 20, true},
+		{"last trackpoint", 30 * time.Minute, 5 * time.Minute, 12, 24, true},
+		{"between trackpoints", time.Minute, 5 * time.Minute, 10.5, 21, true},
+		{"quarter of the way", 30 * time.Second, 5 * time.Minute, 10.25, 20.5, true},
+		{"before the track", -time.Second, 5 * time.Minute, 0, 0, false},
+		{"after the track", 31 * time.Minute, 5 * time.Minute, 0, 0, false},
+		{"across a gap over the limit", 16 * time.Minute, 5 * time.Minute, 0, 0, false},
+		{"across a gap without a limit", 16 * time.Minute, 0, 11.5, 23, true},
+		{"trackpoint at the edge of a long gap", 2 * time.Minute, 5 * time.Minute, 11, 22, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lat, lng, ok := track.PositionAt(base.Add(tt.at), tt.maxGap)
+			if ok != tt.wantFind {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantFind)
+			}
+			if math.Abs(lat-tt.wantLat) > 1e-9 || math.Abs(lng-tt.wantLng) > 1e-9 {
+				t.Errorf("position = %v, %v; want %v, %v", lat, lng, tt.wantLat, tt.wantLng)
+			}
+		})
+	}
+
+	if _, _, ok := Track(nil).PositionAt(base, 0); ok {
+		t.Error("empty track found a position")
+	}
+}
@@ -0,0 +1,16 @@
+package utils
+
+import "strings"
+
+// Extracts the country from a "City, Country" GeoLocation string.
+// Returns "" when the location is empty.
+func CountryFromGeoLocation(location string) string {
+	location = strings.TrimSpace(location)
+	if location == "" {
+		return ""
+	}
+	if i := strings.LastIndex(location, ","); i >= 0 {
+		return strings.TrimSpace(location[i+1:])
+	}
+	return location
+}
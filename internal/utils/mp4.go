@@ -3,6 +3,7 @@ package utils
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os/exec"
 	"regexp"
 	"strconv"
@@ -13,9 +14,20 @@ import (
 
 // Extracts GPS coordinates and metadata from MP4 video data using exiftool
 func ExtractMP4Data(videoData []byte) (models.Coordinates, string, []float64, error) {
+	return extractMP4("-", bytes.NewReader(videoData))
+}
+
+// Extracts GPS coordinates and metadata from an MP4 file on disk, which exiftool reads itself,
+// for videos too large to hold in memory.
+func ExtractMP4File(filePath string) (models.Coordinates, string, []float64, error) {
+	return extractMP4(filePath, nil)
+}
+
+// Runs exiftool on input (a path, or "-" for stdin) and parses the tags ExtractMP4Data returns.
+func extractMP4(input string, stdin io.Reader) (models.Coordinates, string, []float64, error) {
 	// Use exiftool to extract metadata from MP4
-	cmd := exec.Command("exiftool", "-n", "-GPSLatitude", "-GPSLongitude", "-CreateDate", "-ImageWidth", "-ImageHeight", "-")
-	cmd.Stdin = bytes.NewReader(videoData)
+	cmd := exec.Command("exiftool", "-n", "-GPSLatitude", "-GPSLongitude", "-CreateDate", "-ImageWidth", "-ImageHeight", input)
+	cmd.Stdin = stdin
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -0,0 +1,5 @@
+//go:build !race
+
+package utils
+
+const raceEnabled = false
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"trekka-api/internal/models"
+)
+
+// Metres per degree of latitude (and of longitude at the equator).
+const metersPerDegree = 111_320.0
+
+// Snaps coordinates to a grid of roughly gridMeters × gridMeters cells and returns the cell key.
+// Rows are fixed-height bands of latitude; each row is split into a whole number of equal longitude
+// cells sized for the row's centre latitude, so cells stay roughly square away from the equator
+// and tile cleanly across the antimeridian. Floor division keeps cells the same size on both
+// sides of the equator and prime meridian. Returns "" for missing or unparseable coordinates.
+//
+// The key embeds the grid size ("100:42051:50664"), so changing the grid never mixes cells.
+func PlaceKey(c models.Coordinates, gridMeters int) string {
+	if gridMeters <= 0 {
+		return ""
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(c.Lat), 64)
+	if err != nil {
+		return ""
+	}
+	lng, err := strconv.ParseFloat(strings.TrimSpace(c.Lng), 64)
+	if err != nil {
+		return ""
+	}
+	if lat < -90 || lat > 90 || math.IsNaN(lng) || math.IsInf(lng, 0) {
+		return ""
+	}
+
+	latStep := float64(gridMeters) / metersPerDegree
+	row := int64(math.Floor(lat / latStep))
+
+	// Normalise longitude into [-180, 180)
+	lng = math.Mod(lng+180, 360)
+	if lng < 0 {
+		lng += 360
+	}
+	lng -= 180
+
+	rowCentre := (float64(row) + 0.5) * latStep
+	rowWidthMeters := 360 * metersPerDegree * math.Cos(rowCentre*math.Pi/180)
+	cells := int64(math.Max(1, math.Floor(rowWidthMeters/float64(gridMeters))))
+	lngStep := 360 / float64(cells)
+	col := int64(math.Floor((lng + 180) / lngStep))
+	if col >= cells {
+		col = cells - 1
+	}
+
+	return fmt.Sprintf("%d:%d:%d", gridMeters, row, col)
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"trekka-api/internal/models"
+)
+
+// Splits a PlaceKey into its row and column.
+func placeCell(t *testing.T, lat, lng string) (row, col string) {
+	t.Helper()
+	key := PlaceKey(models.Coordinates{Lat: lat, Lng: lng}, 100)
+	parts := strings.Split(key, ":")
+	if len(parts) != 3 || parts[0] != "100" {
+		t.Fatalf("PlaceKey(%s, %s) = %q, want 100:row:col", lat, lng, key)
+	}
+	return parts[1], parts[2]
+}
+
+func TestPlaceKeyAcrossBoundaries(t *testing.T) {
+	// A few metres either side of the equator: adjacent rows, not one cell spanning it
+	northRow, _ := placeCell(t, "0.00001", "10")
+	southRow, _ := placeCell(t, "-0.00001", "10")
+	if northRow != "0" || southRow != "-1" {
+		t.Errorf("rows either side of the equator = %s and %s, want 0 and -1", northRow, southRow)
+	}
+
+	// Columns tile from the antimeridian, so the prime meridian needn't be a cell edge, but a
+	// sign change mustn't jump or fold columns there
+	_, eastCol := placeCell(t, "51.4779", "0.00001")
+	_, westCol := placeCell(t, "51.4779", "-0.00001")
+	east, _ := strconv.Atoi(eastCol)
+	west, _ := strconv.Atoi(westCol)
+	if east-west != 0 && east-west != 1 {
+		t.Errorf("columns either side of the prime meridian = %d and %d, want the same or adjacent", west, east)
+	}
+	_, farEastCol := placeCell(t, "51.4779", "0.01")
+	_, farWestCol := placeCell(t, "51.4779", "-0.01")
+	farEast, _ := strconv.Atoi(farEastCol)
+	farWest, _ := strconv.Atoi(farWestCol)
+	// 0.02° of longitude at 51.5°N is about 1.4km: 13-15 cells of 100m
+	if span := farEast - farWest; span < 13 || span > 15 {
+		t.Errorf("1.4km across the prime meridian spans %d columns, want about 14", span)
+	}
+
+	// The antimeridian wraps: 180 and -180 are the same place, and the cells either side are the
+	// first and last of the row
+	if a, b := PlaceKey(models.Coordinates{Lat: "-16.5", Lng: "180"}, 100), PlaceKey(models.Coordinates{Lat: "-16.5", Lng: "-180"}, 100); a != b {
+		t.Errorf("PlaceKey at lng 180 = %q and at -180 = %q, want the same cell", a, b)
+	}
+	_, firstCol := placeCell(t, "-16.5", "-179.99999")
+	if firstCol != "0" {
+		t.Errorf("column just east of the antimeridian = %s, want 0", firstCol)
+	}
+	_, lastCol := placeCell(t, "-16.5", "179.99999")
+	if lastCol == "0" {
+		t.Error("column just west of the antimeridian is 0, want the row's last")
+	}
+	if PlaceKey(models.Coordinates{Lat: "-16.5", Lng: "540"}, 100) != PlaceKey(models.Coordinates{Lat: "-16.5", Lng: "180"}, 100) {
+		t.Error("longitudes past 360 don't normalise")
+	}
+
+	// Poles are valid places; beyond them isn't
+	if PlaceKey(models.Coordinates{Lat: "90", Lng: "0"}, 100) == "" || PlaceKey(models.Coordinates{Lat: "-90", Lng: "0"}, 100) == "" {
+		t.Error("a pole got no PlaceKey")
+	}
+}
+
+func TestPlaceKey(t *testing.T) {
+	lisbon := PlaceKey(models.Coordinates{Lat: "38.7223", Lng: "-9.1393"}, 100)
+	tests := []struct {
+		name        string
+		coordinates models.Coordinates
+		gridMeters  int
+		want        string
+	}{
+		{"a few metres away", models.Coordinates{Lat: "38.72231", Lng: "-9.13931"}, 100, lisbon},
+		{"surrounding spaces", models.Coordinates{Lat: " 38.7223 ", Lng: " -9.1393"}, 100, lisbon},
+		{"no grid", models.Coordinates{Lat: "38.7223", Lng: "-9.1393"}, 0, ""},
+		{"missing latitude", models.Coordinates{Lng: "-9.1393"}, 100, ""},
+		{"unparseable", models.Coordinates{Lat: "north", Lng: "-9.1393"}, 100, ""},
+		{"beyond the pole", models.Coordinates{Lat: "90.5", Lng: "0"}, 100, ""},
+		{"infinite longitude", models.Coordinates{Lat: "10", Lng: "Inf"}, 100, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PlaceKey(tt.coordinates, tt.gridMeters); got != tt.want {
+				t.Errorf("PlaceKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if far := PlaceKey(models.Coordinates{Lat: "38.7243", Lng: "-9.1393"}, 100); far == lisbon {
+		t.Error("points 220m apart share a 100m cell")
+	}
+}
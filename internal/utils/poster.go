@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Extracts a representative still frame from video data as JPEG bytes.
+// Uses ffmpeg when available (first frame), otherwise falls back to the preview
+// or thumbnail image embedded in the container, read via exiftool.
+func ExtractVideoPoster(videoData []byte) ([]byte, error) {
+	if len(videoData) == 0 {
+		return nil, fmt.Errorf("data cannot be empty")
+	}
+
+	if _, err := exec.LookPath("ffmpeg"); err == nil {
+		if frame, err := ffmpegFirstFrame(videoData); err == nil && len(frame) > 0 {
+			return frame, nil
+		}
+	}
+
+	return embeddedPoster("-", func() io.Reader { return bytes.NewReader(videoData) })
+}
+
+// Extracts a poster frame like ExtractVideoPoster from a video file on disk, which ffmpeg and
+// exiftool read directly, for videos too large to hold in memory.
+func ExtractVideoPosterFile(filePath string) ([]byte, error) {
+	if _, err := exec.LookPath("ffmpeg"); err == nil {
+		if frame, err := ffmpegFrameFromFile(filePath); err == nil && len(frame) > 0 {
+			return frame, nil
+		}
+	}
+
+	return embeddedPoster(filePath, func() io.Reader { return nil })
+}
+
+// Reads the first preview, thumbnail or cover image exiftool finds in input (a path, or "-" for
+// the reader stdin returns, called once per attempt).
+func embeddedPoster(input string, stdin func() io.Reader) ([]byte, error) {
+	for _, tag := range []string{"-PreviewImage", "-ThumbnailImage", "-CoverArt"} {
+		cmd := exec.Command("exiftool", "-b", tag, input)
+		cmd.Stdin = stdin()
+		output, err := cmd.Output()
+		if err == nil && len(output) > 0 {
+			return output, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no poster frame available")
+}
+
+// Decodes the first video frame with ffmpeg. MP4s often keep their index at the end
+// of the file, so the input goes through a temp file rather than a pipe.
+func ffmpegFirstFrame(videoData []byte) ([]byte, error) {
+	tmp, err := os.CreateTemp("", "trekka-poster-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(videoData); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	return ffmpegFrameFromFile(tmp.Name())
+}
+
+// Decodes the first frame of a video file with ffmpeg as JPEG bytes.
+func ffmpegFrameFromFile(filePath string) ([]byte, error) {
+	cmd := exec.Command("ffmpeg", "-v", "error", "-i", filePath, "-frames:v", "1", "-f", "image2pipe", "-vcodec", "mjpeg", "pipe:1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w (output: %s)", err, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
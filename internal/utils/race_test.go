@@ -0,0 +1,6 @@
+//go:build race
+
+package utils
+
+// The race detector slows code down several times over, so timing checks are skipped under it.
+const raceEnabled = true
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+
+	"github.com/disintegration/imaging"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Resizes image data to the requested width, preserving aspect ratio and EXIF orientation.
+// PNG and GIF sources are re-encoded as PNG to keep transparency (GIFs lose any animation, so
+// callers pass animated ones through); everything else becomes JPEG.
+// If the requested width is not smaller than the source as displayed (after EXIF rotation), the
+// original data is returned untouched.
+func ResizeImage(data []byte, contentType string, width int) ([]byte, string, error) {
+	if width <= 0 {
+		return nil, "", fmt.Errorf("width must be positive")
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image config: %w", err)
+	}
+	// Compare against the width the image is displayed at, which EXIF rotation can swap with its height
+	displayWidth := config.Width
+	if swapsDimensions(exifOrientation(data)) {
+		displayWidth = config.Height
+	}
+	if width >= displayWidth {
+		return data, contentType, nil
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	resized := imaging.Resize(img, width, 0, imaging.Lanczos)
+
+	format, outType := imaging.JPEG, "image/jpeg"
+	if contentType == "image/png" || contentType == "image/gif" {
+		format, outType = imaging.PNG, "image/png"
+	}
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, resized, format, imaging.JPEGQuality(85)); err != nil {
+		return nil, "", fmt.Errorf("failed to encode resized image: %w", err)
+	}
+
+	return buf.Bytes(), outType, nil
+}
+
+// Reads the EXIF orientation of image data, 1 (upright) when it has none.
+func exifOrientation(data []byte) int {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 1
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	orient, err := tag.Int(0)
+	if err != nil {
+		return 1
+	}
+	return orient
+}
+
+// Reports whether an EXIF orientation (5-8) rotates the image a quarter turn, swapping its width
+// and height.
+func swapsDimensions(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
+
+// Renders image data as an orientation-corrected JPEG fitting within maxSize × maxSize, for link
+// previews. Unlike ResizeImage it always re-encodes, so small, PNG or rotated sources come out
+// as upright JPEGs too; images are never enlarged.
+func RenderPreviewJPEG(data []byte, maxSize int) ([]byte, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("max size must be positive")
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() > maxSize || bounds.Dy() > maxSize {
+		img = imaging.Fit(img, maxSize, maxSize, imaging.Lanczos)
+	}
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(82)); err != nil {
+		return nil, fmt.Errorf("failed to encode preview: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// Encodes a width×height JPEG with an EXIF orientation tag.
+func orientedJPEG(t *testing.T, width, height, orientation int) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+
+	// Big-endian TIFF header and an IFD holding only the orientation (tag 0x0112, SHORT)
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x002A})
+	binary.Write(&tiff, binary.BigEndian, []uint32{8})
+	binary.Write(&tiff, binary.BigEndian, []uint16{1, 0x0112, 3})
+	binary.Write(&tiff, binary.BigEndian, []uint32{1})
+	binary.Write(&tiff, binary.BigEndian, []uint16{uint16(orientation), 0})
+	binary.Write(&tiff, binary.BigEndian, []uint32{0})
+
+	app1 := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var out bytes.Buffer
+	out.Write(encoded.Bytes()[:2]) // SOI
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(app1)+2))
+	out.Write(app1)
+	out.Write(encoded.Bytes()[2:])
+	return out.Bytes()
+}
+
+func TestResizeImageComparesDisplayedWidth(t *testing.T) {
+	tests := []struct {
+		name        string
+		orientation int
+		width       int
+		wantResized bool
+		wantWidth   int
+		wantHeight  int
+	}{
+		{"upright, narrower", 1, 100, true, 100, 50},
+		{"upright, as wide", 1, 200, false, 200, 100},
+		// Stored 200×100 but displayed 100×200: 150 would enlarge it
+		{"rotated, wider than displayed", 6, 150, false, 200, 100},
+		{"rotated, narrower", 6, 50, true, 50, 100},
+		{"rotated the other way, narrower", 8, 50, true, 50, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := orientedJPEG(t, 200, 100, tt.orientation)
+
+			out, contentType, err := ResizeImage(data, "image/jpeg", tt.width)
+			if err != nil {
+				t.Fatalf("ResizeImage: %v", err)
+			}
+			if resized := !bytes.Equal(out, data); resized != tt.wantResized {
+				t.Errorf("resized = %t, want %t", resized, tt.wantResized)
+			}
+			if contentType != "image/jpeg" {
+				t.Errorf("content type = %q, want image/jpeg", contentType)
+			}
+			config, _, err := image.DecodeConfig(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("decode output: %v", err)
+			}
+			if config.Width != tt.wantWidth || config.Height != tt.wantHeight {
+				t.Errorf("output = %d×%d, want %d×%d", config.Width, config.Height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestRenderPreviewJPEG(t *testing.T) {
+	tests := []struct {
+		name        string
+		width       int
+		height      int
+		orientation int
+		wantWidth   int
+		wantHeight  int
+	}{
+		{"large landscape is capped", 1600, 800, 1, 1200, 600},
+		// Stored 1600×800 but displayed upright as 800×1600
+		{"rotated comes out upright", 1600, 800, 6, 600, 1200},
+		{"small is not enlarged", 400, 300, 1, 400, 300},
+		{"small rotated is upright", 400, 300, 8, 300, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := RenderPreviewJPEG(orientedJPEG(t, tt.width, tt.height, tt.orientation), 1200)
+			if err != nil {
+				t.Fatalf("RenderPreviewJPEG: %v", err)
+			}
+			config, format, err := image.DecodeConfig(bytes.NewReader(out))
+			if err != nil || format != "jpeg" {
+				t.Fatalf("output isn't a JPEG: %v (%s)", err, format)
+			}
+			if config.Width != tt.wantWidth || config.Height != tt.wantHeight {
+				t.Errorf("output = %d×%d, want %d×%d", config.Width, config.Height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+
+	if _, err := RenderPreviewJPEG([]byte("not an image"), 1200); err == nil {
+		t.Error("RenderPreviewJPEG accepted data that isn't an image")
+	}
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"image/gif"
+	"image/png"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// Reports whether the cwebp encoder is available on PATH.
+func WebPAvailable() bool {
+	_, err := exec.LookPath("cwebp")
+	return err == nil
+}
+
+// Reports whether GIF data has more than one frame. Data that can't be decoded counts as
+// animated, so it is passed through rather than mangled.
+func IsAnimatedGIF(data []byte) bool {
+	decoded, err := gif.DecodeAll(bytes.NewReader(data))
+	return err != nil || len(decoded.Image) > 1
+}
+
+// Transcodes JPEG, PNG or still GIF image data to WebP using the cwebp CLI.
+// The input is written to a temporary file since cwebp cannot read from stdin on all versions;
+// the encoded result is read from stdout. cwebp doesn't read GIFs, so they are passed to it as PNG.
+func EncodeWebP(data []byte, quality int) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("data cannot be empty")
+	}
+
+	if bytes.HasPrefix(data, []byte("GIF8")) {
+		img, err := gif.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode GIF: %w", err)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("failed to re-encode GIF as PNG: %w", err)
+		}
+		data = buf.Bytes()
+	}
+
+	tmp, err := os.CreateTemp("", "trekka-webp-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	cmd := exec.Command("cwebp", "-quiet", "-metadata", "icc", "-q", strconv.Itoa(quality), tmp.Name(), "-o", "-")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("cwebp failed: %w (output: %s)", err, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
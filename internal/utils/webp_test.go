@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// Encodes a GIF with the given number of 4×4 frames.
+func gifWithFrames(t *testing.T, frames int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	animation := &gif.GIF{}
+	for i := range frames {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+		frame.SetColorIndex(0, 0, uint8(i%2))
+		animation.Image = append(animation.Image, frame)
+		animation.Delay = append(animation.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation); err != nil {
+		t.Fatalf("encode gif: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestIsAnimatedGIF(t *testing.T) {
+	if IsAnimatedGIF(gifWithFrames(t, 1)) {
+		t.Error("single-frame GIF reported as animated")
+	}
+	if !IsAnimatedGIF(gifWithFrames(t, 3)) {
+		t.Error("three-frame GIF reported as still")
+	}
+	if !IsAnimatedGIF([]byte("GIF89a truncated")) {
+		t.Error("undecodable GIF reported as still; it should be passed through")
+	}
+}
+
+func TestEncodeWebPFromStillGIF(t *testing.T) {
+	if !WebPAvailable() {
+		t.Skip("cwebp not installed")
+	}
+
+	out, err := EncodeWebP(gifWithFrames(t, 1), 80)
+	if err != nil {
+		t.Fatalf("EncodeWebP: %v", err)
+	}
+	if len(out) < 12 || string(out[:4]) != "RIFF" || string(out[8:12]) != "WEBP" {
+		t.Errorf("output doesn't start with a WebP header: % x", out[:min(len(out), 12)])
+	}
+}